@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	gcmd "github.com/Laisky/go-utils/v6/cmd"
+	"github.com/Laisky/zap"
+	"github.com/spf13/cobra"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/s3retention"
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+var s3RetentionCMD = &cobra.Command{
+	Use:   "s3-retention",
+	Short: "report or apply s3 retention",
+	Long: `report what the retention targets of tasks.s3_retention would remove.
+
+nothing is removed unless --apply is set.`,
+	Args: gcmd.NoExtraArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		if !initialize(ctx, cmd) {
+			return
+		}
+
+		flags := cmd.Flags()
+		target, _ := flags.GetString("target")
+		apply, _ := flags.GetBool("apply")
+
+		reports, err := s3retention.Sweep(ctx, target, !apply)
+		if len(reports) > 0 {
+			out, _ := json.MarshalIndent(reports, "", "  ")
+			fmt.Println(string(out))
+		}
+		if err != nil {
+			log.Logger.Panic("s3 retention", zap.Error(err))
+		}
+	},
+}
+
+func init() {
+	s3RetentionCMD.Flags().String("target", "", "name of the target in tasks.s3_retention.targets, default to all")
+	s3RetentionCMD.Flags().Bool("apply", false, "remove the expired objects")
+	rootCMD.AddCommand(s3RetentionCMD)
+}
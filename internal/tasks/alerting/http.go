@@ -0,0 +1,143 @@
+package alerting
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/sso"
+	"github.com/Laisky/go-ramjet/library/alert"
+)
+
+// requireAdmin guards the api without api token, replaced in tests.
+var requireAdmin = sso.RequireSession(true)
+
+type router struct {
+	dispatcher *alert.Dispatcher
+	apiToken   string
+}
+
+func newRouter(dispatcher *alert.Dispatcher, apiToken string) *router {
+	return &router{
+		dispatcher: dispatcher,
+		apiToken:   apiToken,
+	}
+}
+
+func (r *router) bindHTTP(grp gin.IRouter) {
+	grp.Use(r.auth)
+	grp.GET("/active", r.listActive)
+	grp.GET("/silences", r.listSilences)
+	grp.POST("/silences", r.createSilence)
+	grp.DELETE("/silences/:id", r.deleteSilence)
+}
+
+// auth accepts the bearer api token, or an admin sso session.
+func (r *router) auth(ctx *gin.Context) {
+	token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if ok && r.apiToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(r.apiToken)) == 1 {
+		ctx.Next()
+		return
+	}
+
+	requireAdmin(ctx)
+}
+
+func (r *router) abortErr(ctx *gin.Context, status int, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	logger.Warn("http server abort", zap.Error(err))
+	ctx.AbortWithStatusJSON(status, gin.H{
+		"error": err.Error(),
+	})
+	return true
+}
+
+func (r *router) listActive(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"alerts": r.dispatcher.Active(),
+	})
+}
+
+func (r *router) listSilences(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"silences": r.dispatcher.Silences.List(time.Now()),
+	})
+}
+
+type silenceRequest struct {
+	Matchers map[string]string `json:"matchers"`
+	StartsAt time.Time         `json:"starts_at"`
+	EndsAt   time.Time         `json:"ends_at"`
+	// Duration is like `2h`, used if EndsAt is zero.
+	Duration string `json:"duration"`
+	Comment  string `json:"comment"`
+}
+
+func (r *router) createSilence(ctx *gin.Context) {
+	req := new(silenceRequest)
+	if err := ctx.BindJSON(req); r.abortErr(ctx, http.StatusBadRequest, err) {
+		return
+	}
+
+	now := time.Now()
+	silence := alert.Silence{
+		Matchers: req.Matchers,
+		StartsAt: req.StartsAt,
+		EndsAt:   req.EndsAt,
+		Comment:  req.Comment,
+	}
+	if silence.EndsAt.IsZero() {
+		d, err := time.ParseDuration(req.Duration)
+		if r.abortErr(ctx, http.StatusBadRequest, errors.Wrap(err, "require ends_at or duration")) {
+			return
+		}
+
+		start := silence.StartsAt
+		if start.IsZero() {
+			start = now
+		}
+		silence.EndsAt = start.Add(d)
+	}
+	if user, ok := sso.UserFromRequest(ctx.Request); ok {
+		silence.CreatedBy = user.UserName
+	}
+
+	created, err := r.dispatcher.Silences.Add(silence, now)
+	if r.abortErr(ctx, http.StatusBadRequest, err) {
+		return
+	}
+
+	logger.Info("silence created",
+		zap.String("id", created.ID),
+		zap.Any("matchers", created.Matchers),
+		zap.Time("ends_at", created.EndsAt),
+		zap.String("created_by", created.CreatedBy))
+	ctx.JSON(http.StatusOK, gin.H{
+		"silence": created,
+	})
+}
+
+func (r *router) deleteSilence(ctx *gin.Context) {
+	err := r.dispatcher.Silences.Delete(ctx.Param("id"))
+	if errors.Is(err, alert.ErrSilenceNotFound) {
+		r.abortErr(ctx, http.StatusNotFound, err)
+		return
+	}
+	if r.abortErr(ctx, http.StatusInternalServerError, err) {
+		return
+	}
+
+	logger.Info("silence deleted", zap.String("id", ctx.Param("id")))
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg": "ok",
+	})
+}
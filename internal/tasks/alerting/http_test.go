@@ -0,0 +1,71 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Laisky/go-ramjet/library/alert"
+)
+
+func TestSilencesAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	origRequireAdmin := requireAdmin
+	requireAdmin = func(ctx *gin.Context) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"err": "login required"})
+	}
+	defer func() { requireAdmin = origRequireAdmin }()
+
+	dispatcher, err := alert.NewDispatcher(&alert.Config{
+		Channels:        []alert.ChannelConfig{{Name: "hook", Type: alert.ChannelWebhook, URL: "http://127.0.0.1:0"}},
+		DefaultChannels: []string{"hook"},
+	})
+	require.NoError(t, err)
+
+	engine := gin.New()
+	newRouter(dispatcher, "token").bindHTTP(engine.Group("/alerts"))
+	do := func(method, path, token, body string) (int, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		resp := map[string]any{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	code, _ := do(http.MethodGet, "/alerts/silences", "", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+	code, _ = do(http.MethodGet, "/alerts/silences", "wrong", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = do(http.MethodPost, "/alerts/silences", "token", `{"matchers":{"source":"es"}}`)
+	require.Equal(t, http.StatusBadRequest, code, "requires ends_at or duration")
+
+	code, resp := do(http.MethodPost, "/alerts/silences", "token",
+		`{"matchers":{"source":"es"},"duration":"2h","comment":"maintenance"}`)
+	require.Equal(t, http.StatusOK, code)
+	id := resp["silence"].(map[string]any)["id"].(string)
+	require.NotEmpty(t, id)
+
+	code, resp = do(http.MethodGet, "/alerts/silences", "token", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, resp["silences"], 1)
+
+	code, resp = do(http.MethodGet, "/alerts/active", "token", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Empty(t, resp["alerts"])
+
+	code, _ = do(http.MethodDelete, "/alerts/silences/"+id, "token", "")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = do(http.MethodDelete, "/alerts/silences/"+id, "token", "")
+	require.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,32 @@
+// Package alerting implements the http api of active alerts and silences.
+package alerting
+
+import (
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/store"
+	"github.com/Laisky/go-ramjet/library/alert"
+	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+var logger = log.Logger.Named("alerting")
+
+func bindTask() {
+	logger.Info("bind alerting task...")
+	dispatcher := alert.Manager.Dispatcher()
+	if dispatcher == nil {
+		logger.Warn("alert manager is not setup; not binding alerting api")
+		return
+	}
+
+	cfg, err := alert.LoadConfig()
+	if err != nil {
+		logger.Panic("load alert config", zap.Error(err))
+	}
+	newRouter(dispatcher, cfg.APIToken).bindHTTP(web.Server.Group("/alerts"))
+}
+
+func init() {
+	store.TaskStore.Store("alerting", bindTask)
+}
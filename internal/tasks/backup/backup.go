@@ -0,0 +1,310 @@
+// Package backup implements database backups into S3.
+//
+// Every engine dumps into the same key layout, encoding, manifest and
+// retention, see README.md.
+package backup
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/zap"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/s3"
+)
+
+var logger = log.Logger.Named("backup")
+
+const retentionCleanupTimeout = 10 * time.Minute
+
+// Engine is a kind of database backup.
+type Engine interface {
+	// Engine names the database engine, like `postgres`.
+	Engine() string
+	// Ext is the extension of the raw dump, like `.dump`.
+	Ext() string
+}
+
+// Dumper dumps a database as a stream.
+type Dumper interface {
+	Engine
+	// Dump writes the raw dump into w, tempDir holds intermediate files.
+	Dump(ctx context.Context, w io.Writer, tempDir string) error
+}
+
+// S3Dumper is a database that dumps into S3 by itself.
+// Its backups are neither compressed nor encrypted by the Job.
+type S3Dumper interface {
+	Engine
+	// DumpToS3 writes the backup into the object key.
+	DumpToS3(ctx context.Context, s S3, key string) error
+}
+
+// S3 is where backups are stored.
+type S3 struct {
+	Enable       bool
+	Endpoint     string
+	AccessKey    string `mapstructure:"access_key"`
+	AccessSecret string `mapstructure:"access_secret"`
+	Bucket       string
+}
+
+// Cli returns the client of s.
+func (s S3) Cli() (*minio.Client, error) {
+	cli, err := s3.GetCli(s.Endpoint, s.AccessKey, s.AccessSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "new s3 client")
+	}
+	return cli, nil
+}
+
+// Job backs up one database into S3.
+type Job struct {
+	// KeyPrefix is the managed key prefix, see KeyPrefix.
+	KeyPrefix string
+	// Engine is a Dumper or an S3Dumper.
+	Engine     Engine
+	Encoding   Encoding
+	Encryption Encryption
+	S3         S3
+	Retention  Retention
+	// UseTempFile dumps into a temporary file under TempDir before uploading,
+	// instead of streaming into S3.
+	UseTempFile bool
+	TempDir     string
+}
+
+// Ext returns the object extension of the backups.
+func (j *Job) Ext() string {
+	if _, ok := j.Engine.(S3Dumper); ok {
+		return j.Engine.Ext()
+	}
+	return j.Engine.Ext() + j.Encoding.Ext()
+}
+
+// Key returns the object key of the backup at date.
+func (j *Job) Key(date string) string {
+	return j.KeyPrefix + date + j.Ext()
+}
+
+// Run backs up the database as the backup of date, and starts the retention
+// cleanup in background. It skips if the backup already exists.
+func (j *Job) Run(ctx context.Context, date string) (key string, err error) {
+	key = j.Key(date)
+	s3cli, err := j.S3.Cli()
+	if err != nil {
+		return key, err
+	}
+
+	if exists, err := objectExists(ctx, s3cli, j.S3.Bucket, key); err != nil {
+		logger.Warn("cannot check existing backup; will proceed", zap.Error(err), zap.String("object", key))
+	} else if exists {
+		logger.Info("backup already exists; skip", zap.String("object", key))
+		return key, nil
+	}
+
+	start := time.Now()
+	switch engine := j.Engine.(type) {
+	case S3Dumper:
+		err = j.dumpToS3(ctx, s3cli, engine, key)
+	case Dumper:
+		if j.UseTempFile {
+			err = j.uploadViaTempFile(ctx, s3cli, engine, key)
+		} else {
+			err = j.streamToS3(ctx, s3cli, engine, key)
+		}
+	default:
+		err = errors.Errorf("engine %q can not dump", j.Engine.Engine())
+	}
+	if err != nil {
+		return key, err
+	}
+
+	j.startRetentionCleanup(key)
+	logger.Info("uploaded to s3",
+		zap.String("engine", j.Engine.Engine()),
+		zap.String("object", key),
+		zap.String("cost", gutils.CostSecs(time.Since(start))))
+	return key, nil
+}
+
+// objectExists checks if object already exists.
+func objectExists(ctx context.Context, s3cli *minio.Client, bucket, key string) (bool, error) {
+	_, err := s3cli.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	resp := minio.ToErrorResponse(err)
+	if resp.StatusCode == http.StatusNotFound || strings.EqualFold(resp.Code, "NoSuchKey") {
+		return false, nil
+	}
+	return false, err
+}
+
+// dump runs the dumper and writes the encoded dump into w.
+// It returns the id of the encryption key.
+func (j *Job) dump(ctx context.Context, dumper Dumper, w io.Writer) (string, error) {
+	ew, keyID, err := EncodeWriter(w, j.Encoding, j.Encryption)
+	if err != nil {
+		return "", errors.Wrap(err, "new encoder")
+	}
+	if err = dumper.Dump(ctx, ew, j.TempDir); err != nil {
+		return "", errors.Wrapf(err, "dump %s", dumper.Engine())
+	}
+	if err = ew.Close(); err != nil {
+		return "", errors.Wrap(err, "close encoder")
+	}
+
+	return keyID, nil
+}
+
+// streamToS3 connects a pipe between the dumper and S3 PutObject,
+// then uploads the manifest of the object.
+func (j *Job) streamToS3(ctx context.Context, s3cli *minio.Client, dumper Dumper, key string) error {
+	type dumpResult struct {
+		keyID string
+		err   error
+	}
+	pr, pw := io.Pipe()
+	dw := NewDigestWriter(pw)
+	resultCh := make(chan dumpResult, 1)
+	go func() {
+		// CloseWithError ensures the reader sees error if any
+		keyID, err := j.dump(ctx, dumper, dw)
+		_ = pw.CloseWithError(err)
+		resultCh <- dumpResult{keyID: keyID, err: err}
+	}()
+
+	// Size -1 enables streaming multipart upload
+	logger.Info("s3 put (stream)", zap.String("bucket", j.S3.Bucket), zap.String("key", key))
+	contentType, contentEncoding := contentType(j.Ext())
+	info, putErr := s3.PutObjectCappingVersions(ctx, logger, s3cli, j.S3.Bucket, key, pr, -1, minio.PutObjectOptions{
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding,
+	}, s3.DefaultVersionsToKeep)
+	if putErr != nil {
+		// unblock the producer
+		_ = pr.CloseWithError(putErr)
+	}
+
+	dump := <-resultCh // wait producer
+	if putErr != nil {
+		return errors.Wrap(putErr, "put object")
+	}
+	if dump.err != nil {
+		return errors.Wrap(dump.err, "dump pipeline")
+	}
+
+	if err := uploadManifest(ctx, s3cli, j.S3.Bucket,
+		dw.Manifest(key, j.Engine.Engine(), j.Encoding, dump.keyID)); err != nil {
+		return errors.Wrap(err, "upload manifest")
+	}
+
+	logger.Info("backup completed",
+		zap.String("object", key),
+		zap.Int64("uploaded_size", info.Size),
+		zap.String("etag", info.ETag))
+	return nil
+}
+
+// uploadViaTempFile writes the encoded dump into a temporary file, then
+// uploads that file and its manifest to S3.
+func (j *Job) uploadViaTempFile(ctx context.Context, s3cli *minio.Client, dumper Dumper, key string) error {
+	tempDir := j.TempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	if err := os.MkdirAll(tempDir, 0o755); err != nil {
+		return errors.Wrap(err, "mkdir temp dir")
+	}
+
+	f, err := os.CreateTemp(tempDir, filepath.Base(key)+".*")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+	defer func() {
+		_ = f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			logger.Warn("cleanup temp file failed", zap.String("file", f.Name()), zap.Error(err))
+		}
+	}()
+
+	logger.Info("dump to temp file", zap.String("tmp", f.Name()))
+	dw := NewDigestWriter(f)
+	keyID, err := j.dump(ctx, dumper, dw)
+	if err != nil {
+		return errors.Wrap(err, "dump to temp file")
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "rewind temp file")
+	}
+
+	m := dw.Manifest(key, j.Engine.Engine(), j.Encoding, keyID)
+	logger.Info("s3 put (file)", zap.String("bucket", j.S3.Bucket), zap.String("key", key),
+		zap.String("file", f.Name()), zap.Int64("size", m.Size))
+	contentType, contentEncoding := contentType(j.Ext())
+	info, err := s3.PutObjectCappingVersions(ctx, logger, s3cli, j.S3.Bucket, key, f, m.Size, minio.PutObjectOptions{
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding,
+	}, s3.DefaultVersionsToKeep)
+	if err != nil {
+		return errors.Wrap(err, "put object")
+	}
+	if err = uploadManifest(ctx, s3cli, j.S3.Bucket, m); err != nil {
+		return errors.Wrap(err, "upload manifest")
+	}
+
+	logger.Info("backup completed (file)", zap.String("object", key),
+		zap.Int64("uploaded_size", info.Size), zap.String("etag", info.ETag))
+	return nil
+}
+
+// dumpToS3 lets the database write the backup, then reads it back
+// to build its manifest.
+func (j *Job) dumpToS3(ctx context.Context, s3cli *minio.Client, dumper S3Dumper, key string) error {
+	logger.Info("database writes backup into s3", zap.String("bucket", j.S3.Bucket), zap.String("key", key))
+	if err := dumper.DumpToS3(ctx, j.S3, key); err != nil {
+		return errors.Wrapf(err, "dump %s", dumper.Engine())
+	}
+
+	obj, err := s3cli.GetObject(ctx, j.S3.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "get object %q", key)
+	}
+	defer obj.Close()
+
+	dw := NewDigestWriter(io.Discard)
+	if _, err = io.Copy(dw, obj); err != nil {
+		return errors.Wrapf(err, "read object %q", key)
+	}
+	if err = uploadManifest(ctx, s3cli, j.S3.Bucket, dw.Manifest(key, dumper.Engine(), Encoding{}, "")); err != nil {
+		return errors.Wrap(err, "upload manifest")
+	}
+
+	return nil
+}
+
+// startRetentionCleanup launches background retention cleanup of the backup series.
+func (j *Job) startRetentionCleanup(uploadedKey string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), retentionCleanupTimeout)
+		defer cancel()
+
+		if err := Cleanup(ctx, j.S3, j.KeyPrefix, j.Retention); err != nil {
+			logger.Warn("backup retention cleanup failed",
+				zap.String("bucket", j.S3.Bucket),
+				zap.String("uploaded_object", uploadedKey),
+				zap.String("key_prefix", j.KeyPrefix),
+				zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,288 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/zap"
+	"github.com/klauspost/compress/zstd"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/Laisky/go-ramjet/library/s3"
+)
+
+// Compressions.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ManifestSuffix is appended to the key of a backup to name its manifest.
+const ManifestSuffix = ".sha256.json"
+
+var (
+	compressionExts = map[string]string{CompressionGzip: ".gz", CompressionZstd: ".zst"}
+	encryptionExts  = map[string]string{"": "", EncryptionAge: ".age", EncryptionAESGCM: ".enc"}
+)
+
+// Encoding is how a dump is compressed and encrypted.
+type Encoding struct {
+	// Compression is `gzip`, `zstd`, or empty if the engine compresses by itself.
+	Compression string `json:"compression,omitempty"`
+	Encryption  string `json:"encryption,omitempty"`
+}
+
+// NewEncoding returns the validated encoding, compression defaults to gzip.
+func NewEncoding(compression string, encryption Encryption) (Encoding, error) {
+	if compression == "" {
+		compression = CompressionGzip
+	}
+	if _, ok := compressionExts[compression]; !ok {
+		return Encoding{}, errors.Errorf("unknown compression %q", compression)
+	}
+	if err := encryption.Valid(); err != nil {
+		return Encoding{}, err
+	}
+
+	return Encoding{Compression: compression, Encryption: encryption.Method}, nil
+}
+
+// Ext returns the extension appended to the raw dump extension.
+func (e Encoding) Ext() string {
+	return compressionExts[e.Compression] + encryptionExts[e.Encryption]
+}
+
+// contentType returns the content type and encoding of an object with ext,
+// plain `.gz` objects keep their gzip content encoding.
+func contentType(ext string) (contentType, contentEncoding string) {
+	if ext == ".gz" {
+		return "application/gzip", "gzip"
+	}
+	return "application/octet-stream", ""
+}
+
+// EncodeWriter wraps w by enc. Closing it flushes the compressor and the
+// encryptor, but does not close w. It returns the id of the encryption key.
+func EncodeWriter(w io.Writer, enc Encoding, encryption Encryption) (io.WriteCloser, string, error) {
+	var (
+		closers []io.Closer
+		keyID   string
+	)
+	if enc.Encryption != "" {
+		ew, id, err := encryption.encryptWriter(w, enc.Encryption)
+		if err != nil {
+			return nil, "", err
+		}
+		w, keyID = ew, id
+		closers = append(closers, ew)
+	}
+
+	var cw io.WriteCloser
+	switch enc.Compression {
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "new zstd writer")
+		}
+		cw = zw
+	case CompressionGzip:
+		cw = gzip.NewWriter(w)
+	default:
+		return nil, "", errors.Errorf("unknown compression %q", enc.Compression)
+	}
+
+	return &chainWriter{WriteCloser: cw, closers: closers}, keyID, nil
+}
+
+// chainWriter closes the wrapped writers after itself.
+type chainWriter struct {
+	io.WriteCloser
+	closers []io.Closer
+}
+
+func (c *chainWriter) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		return errors.Wrap(err, "close compressor")
+	}
+	for _, closer := range c.closers {
+		if err := closer.Close(); err != nil {
+			return errors.Wrap(err, "close encryptor")
+		}
+	}
+
+	return nil
+}
+
+// DecodeReader decrypts and decompresses r by enc.
+func DecodeReader(r io.Reader, enc Encoding, encryption Encryption) (io.Reader, error) {
+	if enc.Encryption != "" {
+		dr, err := encryption.decryptReader(r, enc.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		r = dr
+	}
+
+	switch enc.Compression {
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "new zstd reader")
+		}
+		return zr.IOReadCloser(), nil
+	case CompressionGzip:
+		return GunzipIfNeeded(r)
+	default:
+		return r, nil
+	}
+}
+
+// GunzipIfNeeded decompresses r if it is gzip, backups may be served
+// already decoded because of their content encoding.
+func GunzipIfNeeded(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(err, "read backup")
+	}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, errors.Wrap(err, "new gzip reader")
+	}
+	return gz, nil
+}
+
+// Manifest records the checksum of a backup object.
+type Manifest struct {
+	Object string `json:"object"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Engine string `json:"engine"`
+	Encoding
+	// KeyID is the id of the aes-gcm key encrypting the backup.
+	KeyID     string    `json:"key_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DigestWriter hashes and counts what is written into w.
+type DigestWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+// NewDigestWriter returns a DigestWriter writing into w.
+func NewDigestWriter(w io.Writer) *DigestWriter {
+	return &DigestWriter{w: w, hash: sha256.New()}
+}
+
+func (d *DigestWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.hash.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+// Manifest returns the manifest of what is written as object.
+func (d *DigestWriter) Manifest(object, engine string, enc Encoding, keyID string) *Manifest {
+	return &Manifest{
+		Object:    object,
+		Size:      d.size,
+		SHA256:    hex.EncodeToString(d.hash.Sum(nil)),
+		Engine:    engine,
+		Encoding:  enc,
+		KeyID:     keyID,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Check reads r to the end, and checks it against m.
+func (m *Manifest) Check(r io.Reader) error {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return errors.Wrap(err, "read backup")
+	}
+	if n != m.Size {
+		return errors.Errorf("backup size %d does not match manifest %d", n, m.Size)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != m.SHA256 {
+		return errors.Errorf("backup sha256 %s does not match manifest %s", got, m.SHA256)
+	}
+
+	return nil
+}
+
+// uploadManifest stores m alongside its backup object.
+func uploadManifest(ctx context.Context, s3cli *minio.Client, bucket string, m *Manifest) error {
+	payload, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal manifest")
+	}
+
+	key := m.Object + ManifestSuffix
+	if _, err = s3.PutObjectCappingVersions(ctx, logger, s3cli, bucket, key,
+		strings.NewReader(string(payload)), int64(len(payload)), minio.PutObjectOptions{
+			ContentType: "application/json",
+		}, s3.DefaultVersionsToKeep); err != nil {
+		return errors.Wrapf(err, "put object %q", key)
+	}
+
+	return nil
+}
+
+// VerifyObject checks the backup object key against its manifest.
+// Legacy `.gz` backups made before manifests are trusted with a warning,
+// and return a nil manifest.
+func VerifyObject(ctx context.Context, s3cli *minio.Client, bucket, key string) (*Manifest, error) {
+	obj, err := s3cli.GetObject(ctx, bucket, key+ManifestSuffix, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get manifest of %q", key)
+	}
+	defer obj.Close()
+
+	m := new(Manifest)
+	if err = json.NewDecoder(obj).Decode(m); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" && isLegacyKey(key) {
+			logger.Warn("legacy backup has no manifest; skip checksum", zap.String("object", key))
+			return nil, nil //nolint:nilnil
+		}
+		return nil, errors.Wrapf(err, "read manifest of %q", key)
+	}
+	if m.Object != key {
+		return nil, errors.Errorf("manifest is of %q, not %q", m.Object, key)
+	}
+
+	body, err := s3cli.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get object %q", key)
+	}
+	defer body.Close()
+
+	if err = m.Check(body); err != nil {
+		return nil, errors.Wrapf(err, "verify %q", key)
+	}
+	return m, nil
+}
+
+// isLegacyKey reports whether key is a plain gzip backup, the only kind
+// made before manifests.
+func isLegacyKey(key string) bool {
+	base := path.Base(key)
+	name, ok := strings.CutSuffix(base, ".gz")
+	return ok && !strings.Contains(name, ".")
+}
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/require"
+)
+
+func testAESKey(t *testing.T, id string) AESKey {
+	t.Helper()
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return AESKey{ID: id, Key: base64.StdEncoding.EncodeToString(key)}
+}
+
+// TestEncodeDecode round-trips every compression and encryption.
+func TestEncodeDecode(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	oldKey, newKey := testAESKey(t, "2025"), testAESKey(t, "2026")
+	encryption := Encryption{
+		Recipients: []string{identity.Recipient().String()},
+		Identities: []string{identity.String()},
+		Keys:       []AESKey{newKey, oldKey},
+	}
+
+	// larger than one aes-gcm chunk
+	payload := bytes.Repeat([]byte("INSERT INTO users VALUES (1);\n"), 5000)
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		for _, method := range []string{"", EncryptionAge, EncryptionAESGCM} {
+			enc := Encoding{Compression: compression, Encryption: method}
+			t.Run(enc.Ext(), func(t *testing.T) {
+				var buf bytes.Buffer
+				dw := NewDigestWriter(&buf)
+				w, keyID, err := EncodeWriter(dw, enc, encryption)
+				require.NoError(t, err)
+				_, err = w.Write(payload)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				if method == EncryptionAESGCM {
+					require.Equal(t, "2026", keyID, "the first key encrypts")
+				}
+
+				m := dw.Manifest("k"+enc.Ext(), "postgres", enc, keyID)
+				require.NoError(t, m.Check(bytes.NewReader(buf.Bytes())))
+
+				r, err := DecodeReader(bytes.NewReader(buf.Bytes()), enc, encryption)
+				require.NoError(t, err)
+				got, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.Equal(t, payload, got)
+
+				tampered := bytes.Clone(buf.Bytes())
+				tampered[len(tampered)-1] ^= 1
+				require.ErrorContains(t, m.Check(bytes.NewReader(tampered)), "does not match manifest")
+			})
+		}
+	}
+}
+
+// TestAESGCMStream verifies key rotation and that truncation is detected.
+func TestAESGCMStream(t *testing.T) {
+	oldKey, newKey := testAESKey(t, "2025"), testAESKey(t, "2026")
+	payload := bytes.Repeat([]byte{7}, 2*aesGCMChunkSize)
+
+	var buf bytes.Buffer
+	w, err := newAESGCMWriter(&buf, oldKey)
+	require.NoError(t, err)
+	_, err = w.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// backups of a rotated key still decrypt
+	r, err := newAESGCMReader(bytes.NewReader(buf.Bytes()), []AESKey{newKey, oldKey})
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, payload, got)
+
+	_, err = newAESGCMReader(bytes.NewReader(buf.Bytes()), []AESKey{newKey})
+	require.ErrorContains(t, err, `aes key "2025" is not configured`)
+
+	// cut at a chunk boundary
+	header := len(aesGCMMagic) + len("2025\n") + aesGCMNoncePrefix
+	truncated := buf.Bytes()[:header+aesGCMChunkSize+16]
+	r, err = newAESGCMReader(bytes.NewReader(truncated), []AESKey{oldKey})
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorContains(t, err, "corrupted or truncated")
+}
+
+// TestTarDir verifies the archive of directory dumps.
+func TestTarDir(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "toc.dat"), []byte("toc"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "3001.dat"), []byte("rows"), 0o600))
+
+	var buf bytes.Buffer
+	require.NoError(t, TarDir(src, &buf))
+
+	dst := t.TempDir()
+	require.NoError(t, UntarDir(&buf, dst))
+	got, err := os.ReadFile(filepath.Join(dst, "3001.dat"))
+	require.NoError(t, err)
+	require.Equal(t, "rows", string(got))
+}
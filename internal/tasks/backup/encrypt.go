@@ -0,0 +1,327 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/Laisky/errors/v2"
+)
+
+// Encryption methods.
+const (
+	EncryptionAge    = "age"
+	EncryptionAESGCM = "aes-gcm"
+
+	// aesGCMMagic starts every aes-gcm encrypted backup.
+	aesGCMMagic = "ramjet-aesgcm-v1\n"
+	// aesGCMChunkSize is the plaintext size of each sealed chunk.
+	aesGCMChunkSize   = 64 * 1024
+	aesGCMNoncePrefix = 7
+)
+
+// Encryption configures client side encryption of backups.
+//
+// To rotate keys, put the new key first and keep the old ones until
+// the backups encrypted by them expire.
+type Encryption struct {
+	// Method is empty for no encryption, `age` or `aes-gcm`.
+	Method string
+	// Recipients are the age public keys backups are encrypted to.
+	Recipients []string
+	// Identities are the age private keys to decrypt backups,
+	// only needed to restore.
+	Identities []string
+	// Keys are the aes-gcm keys, the first one encrypts backups,
+	// and all of them decrypt.
+	Keys []AESKey
+}
+
+// AESKey is a named AES-256 key.
+type AESKey struct {
+	ID string
+	// Key is the base64 encoded 32 bytes key.
+	Key string
+}
+
+// aead returns the AES-256-GCM cipher of k.
+func (k AESKey) aead() (cipher.AEAD, error) {
+	if k.ID == "" || strings.Contains(k.ID, "\n") {
+		return nil, errors.Errorf("invalid aes key id %q", k.ID)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode aes key %q", k.ID)
+	}
+	if len(key) != 32 {
+		return nil, errors.Errorf("aes key %q should be 32 bytes, got %d", k.ID, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "new aes cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+// Valid checks the settings needed to encrypt backups.
+func (c Encryption) Valid() error {
+	switch c.Method {
+	case "":
+	case EncryptionAge:
+		if _, err := c.ageRecipients(); err != nil {
+			return err
+		}
+	case EncryptionAESGCM:
+		if len(c.Keys) == 0 {
+			return errors.New("aes-gcm encryption requires keys")
+		}
+		for _, k := range c.Keys {
+			if _, err := k.aead(); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.Errorf("unknown encryption %q", c.Method)
+	}
+
+	return nil
+}
+
+func (c Encryption) ageRecipients() ([]age.Recipient, error) {
+	if len(c.Recipients) == 0 {
+		return nil, errors.New("age encryption requires recipients")
+	}
+
+	recipients, err := age.ParseRecipients(strings.NewReader(strings.Join(c.Recipients, "\n")))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse age recipients")
+	}
+	return recipients, nil
+}
+
+// encryptWriter returns a writer encrypting into w by method, and the id
+// of the key used.
+func (c Encryption) encryptWriter(w io.Writer, method string) (io.WriteCloser, string, error) {
+	switch method {
+	case EncryptionAge:
+		recipients, err := c.ageRecipients()
+		if err != nil {
+			return nil, "", err
+		}
+		ew, err := age.Encrypt(w, recipients...)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "new age writer")
+		}
+		return ew, "", nil
+	case EncryptionAESGCM:
+		if len(c.Keys) == 0 {
+			return nil, "", errors.New("aes-gcm encryption requires keys")
+		}
+		ew, err := newAESGCMWriter(w, c.Keys[0])
+		if err != nil {
+			return nil, "", err
+		}
+		return ew, c.Keys[0].ID, nil
+	default:
+		return nil, "", errors.Errorf("unknown encryption %q", method)
+	}
+}
+
+// decryptReader returns a reader decrypting r by method.
+func (c Encryption) decryptReader(r io.Reader, method string) (io.Reader, error) {
+	switch method {
+	case EncryptionAge:
+		if len(c.Identities) == 0 {
+			return nil, errors.New("age decryption requires identities")
+		}
+		identities, err := age.ParseIdentities(strings.NewReader(strings.Join(c.Identities, "\n")))
+		if err != nil {
+			return nil, errors.Wrap(err, "parse age identities")
+		}
+		dr, err := age.Decrypt(r, identities...)
+		if err != nil {
+			return nil, errors.Wrap(err, "decrypt age")
+		}
+		return dr, nil
+	case EncryptionAESGCM:
+		return newAESGCMReader(r, c.Keys)
+	default:
+		return nil, errors.Errorf("unknown encryption %q", method)
+	}
+}
+
+// aesGCMNonce builds the nonce of the counter-th chunk.
+func aesGCMNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, aesGCMNoncePrefix+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// aesGCMWriter encrypts a stream by chunks with AES-256-GCM.
+//
+// The stream is the magic, the key id line, a random nonce prefix, then the
+// sealed chunks. The nonce of each chunk holds its counter and whether it is
+// the last one, so reordered or truncated streams fail to decrypt.
+type aesGCMWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+func newAESGCMWriter(w io.Writer, key AESKey) (*aesGCMWriter, error) {
+	aead, err := key.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, aesGCMNoncePrefix)
+	if _, err = rand.Read(prefix); err != nil {
+		return nil, errors.Wrap(err, "generate nonce")
+	}
+	if _, err = io.WriteString(w, aesGCMMagic+key.ID+"\n"); err != nil {
+		return nil, errors.Wrap(err, "write header")
+	}
+	if _, err = w.Write(prefix); err != nil {
+		return nil, errors.Wrap(err, "write header")
+	}
+
+	return &aesGCMWriter{w: w, aead: aead, prefix: prefix,
+		buf: make([]byte, 0, aesGCMChunkSize)}, nil
+}
+
+func (e *aesGCMWriter) seal(chunk []byte, last bool) error {
+	sealed := e.aead.Seal(nil, aesGCMNonce(e.prefix, e.counter, last), chunk, nil)
+	e.counter++
+	if _, err := e.w.Write(sealed); err != nil {
+		return errors.Wrap(err, "write chunk")
+	}
+	return nil
+}
+
+// Write buffers p, a full chunk is only sealed once more data arrives,
+// since the last chunk is sealed differently.
+func (e *aesGCMWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(e.buf) == aesGCMChunkSize {
+			if err := e.seal(e.buf, false); err != nil {
+				return 0, err
+			}
+			e.buf = e.buf[:0]
+		}
+
+		m := copy(e.buf[len(e.buf):aesGCMChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+	}
+
+	return n, nil
+}
+
+// Close seals the last chunk.
+func (e *aesGCMWriter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+// aesGCMReader decrypts a stream written by aesGCMWriter.
+type aesGCMReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	block   []byte
+	plain   []byte
+	done    bool
+}
+
+// newAESGCMReader reads the header of r, and decrypts it by the key
+// named in the header.
+func newAESGCMReader(r io.Reader, keys []AESKey) (*aesGCMReader, error) {
+	br := bufio.NewReaderSize(r, aesGCMChunkSize+64)
+	magic := make([]byte, len(aesGCMMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != aesGCMMagic {
+		return nil, errors.New("not an aes-gcm encrypted backup")
+	}
+
+	keyID, err := br.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "read key id")
+	}
+	keyID = strings.TrimSuffix(keyID, "\n")
+
+	var aead cipher.AEAD
+	for _, k := range keys {
+		if k.ID == keyID {
+			if aead, err = k.aead(); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if aead == nil {
+		return nil, errors.Errorf("aes key %q is not configured", keyID)
+	}
+
+	prefix := make([]byte, aesGCMNoncePrefix)
+	if _, err = io.ReadFull(br, prefix); err != nil {
+		return nil, errors.Wrap(err, "read nonce")
+	}
+
+	return &aesGCMReader{r: br, aead: aead, prefix: prefix,
+		block: make([]byte, aesGCMChunkSize+aead.Overhead())}, nil
+}
+
+func (d *aesGCMReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk. A chunk is the last one if nothing follows it.
+func (d *aesGCMReader) next() error {
+	n, err := io.ReadFull(d.r, d.block)
+	switch {
+	case errors.Is(err, io.EOF):
+		return errors.New("aes-gcm backup is truncated")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		d.done = true
+	case err != nil:
+		return errors.Wrap(err, "read chunk")
+	default:
+		if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+			d.done = true
+		}
+	}
+
+	plain, err := d.aead.Open(nil, aesGCMNonce(d.prefix, d.counter, d.done), d.block[:n], nil)
+	if err != nil {
+		return errors.New("aes-gcm backup is corrupted or truncated")
+	}
+
+	d.counter++
+	d.plain = plain
+	return nil
+}
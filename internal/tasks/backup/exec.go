@@ -0,0 +1,39 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/zap"
+)
+
+// execCommand builds the dump commands, replaced in tests.
+var execCommand = exec.CommandContext
+
+// runTool runs the dump tool with args, and copies its stdout into w.
+// env is appended to the environment of the tool.
+func runTool(ctx context.Context, tool string, env []string, w io.Writer, args ...string) error {
+	cmd := execCommand(ctx, tool, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = w
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		logger.Named(tool).Warn("stderr", zap.String("msg", msg))
+	}
+	if err != nil {
+		ee := &exec.ExitError{}
+		if errors.As(err, &ee) {
+			return errors.Wrapf(err, "%s exited with code %d", tool, ee.ExitCode())
+		}
+		return errors.Wrapf(err, "run %s", tool)
+	}
+
+	return nil
+}
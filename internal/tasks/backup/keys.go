@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DateLayout is the date in backup object keys.
+const DateLayout = "20060102"
+
+// rawExtRegexp matches the extension of a raw dump, like `.dump`.
+var rawExtRegexp = regexp.MustCompile(`^(\.[a-z0-9]+)?$`)
+
+// cleanS3Path cleans a user-provided S3 key/path to use forward slashes
+// and removes any leading slash.
+func cleanS3Path(p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return ""
+	}
+	p = strings.TrimPrefix(p, "/")
+	p = path.Clean(p)
+	if p == "." {
+		return ""
+	}
+	return p
+}
+
+// KeyPrefix derives the managed S3 key prefix and basename of one backup series.
+//
+//   - If prefix ends with '/', it's treated as an S3 key prefix (directory-like),
+//     and the keys will be: "<prefix><name>-YYYYMMDD<ext>".
+//   - Otherwise, it's treated as the basename (optionally with directories),
+//     and the keys will be: "<dir>/<basename>-YYYYMMDD<ext>".
+//
+// fallback is the basename if both prefix and name are empty.
+func KeyPrefix(prefix, name, fallback string) (keyPrefix string, base string) {
+	pfx := strings.TrimSpace(prefix)
+	if strings.HasSuffix(pfx, "/") {
+		dir := cleanS3Path(pfx)
+		base = strings.TrimSpace(name)
+		if base == "" {
+			base = fallback
+		}
+
+		if dir == "" {
+			return base + "-", base
+		}
+
+		return dir + "/" + base + "-", base
+	}
+
+	cleaned := cleanS3Path(pfx)
+	dir, base := path.Split(cleaned)
+	if strings.TrimSpace(base) == "" {
+		base = strings.TrimSpace(name)
+		if base == "" {
+			base = fallback
+		}
+	}
+
+	if dir == "" {
+		return base + "-", base
+	}
+
+	return path.Clean(dir) + "/" + base + "-", base
+}
+
+// ParseKey splits a managed backup key into its date, the extension of the
+// raw dump and its encoding. Manifests and other objects are not managed.
+func ParseKey(keyPrefix, key string) (date, rawExt string, enc Encoding, ok bool) {
+	rest, ok := strings.CutPrefix(key, keyPrefix)
+	if !ok || len(rest) < len(DateLayout) || strings.HasSuffix(rest, ManifestSuffix) {
+		return "", "", enc, false
+	}
+
+	date, ext := rest[:len(DateLayout)], rest[len(DateLayout):]
+	if _, err := time.Parse(DateLayout, date); err != nil {
+		return "", "", enc, false
+	}
+
+	for method, suffix := range encryptionExts {
+		if suffix != "" && strings.HasSuffix(ext, suffix) {
+			enc.Encryption, ext = method, strings.TrimSuffix(ext, suffix)
+			break
+		}
+	}
+	for compression, suffix := range compressionExts {
+		if strings.HasSuffix(ext, suffix) {
+			enc.Compression, ext = compression, strings.TrimSuffix(ext, suffix)
+			break
+		}
+	}
+	if enc.Encryption != "" && enc.Compression == "" {
+		return "", "", enc, false
+	}
+	if !rawExtRegexp.MatchString(ext) || (ext == "" && enc.Compression == "") {
+		return "", "", enc, false
+	}
+
+	return date, ext, enc, true
+}
+
+// IsManagedKey reports whether key belongs to the backup series of keyPrefix.
+func IsManagedKey(keyPrefix, key string) bool {
+	_, _, _, ok := ParseKey(keyPrefix, key)
+	return ok
+}
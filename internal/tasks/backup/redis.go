@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis dump methods.
+const (
+	// RedisMethodRDB fetches the rdb from the server by `redis-cli --rdb`.
+	RedisMethodRDB = "rdb"
+	// RedisMethodBGSave triggers `BGSAVE`, then reads the rdb file from the
+	// local disk, for servers running on the same host.
+	RedisMethodBGSave = "bgsave"
+)
+
+// redisSavePollInterval is how often LASTSAVE is polled after BGSAVE.
+var redisSavePollInterval = time.Second
+
+// Redis dumps a redis server as a rdb file.
+type Redis struct {
+	// URI is like `redis://:pwd@host:6379/0`.
+	URI string
+	// Method is `rdb` (default) or `bgsave`.
+	Method string
+	// RDBPath is the rdb file of method bgsave, default to the
+	// `dir` and `dbfilename` of the server.
+	RDBPath string
+}
+
+// Engine implements Engine.
+func (r *Redis) Engine() string { return "redis" }
+
+// Ext implements Engine.
+func (r *Redis) Ext() string { return ".rdb" }
+
+// Dump implements Dumper.
+func (r *Redis) Dump(ctx context.Context, w io.Writer, _ string) error {
+	switch r.Method {
+	case "", RedisMethodRDB:
+		return r.dumpRDB(ctx, w)
+	case RedisMethodBGSave:
+		return r.dumpBGSave(ctx, w)
+	default:
+		return errors.Errorf("unknown redis method %q", r.Method)
+	}
+}
+
+// dumpRDB streams the rdb by redis-cli, the password is passed by env.
+func (r *Redis) dumpRDB(ctx context.Context, w io.Writer) error {
+	u, err := url.Parse(r.URI)
+	if err != nil {
+		return errors.Wrap(err, "parse redis uri")
+	}
+
+	var env []string
+	if password, ok := u.User.Password(); ok {
+		env = append(env, "REDISCLI_AUTH="+password)
+		if name := u.User.Username(); name != "" {
+			u.User = url.User(name)
+		} else {
+			u.User = nil
+		}
+	}
+	return runTool(ctx, "redis-cli", env, w, "-u", u.String(), "--rdb", "-")
+}
+
+// dumpBGSave waits for a new BGSAVE to finish, then copies the rdb file.
+func (r *Redis) dumpBGSave(ctx context.Context, w io.Writer) error {
+	opt, err := redis.ParseURL(r.URI)
+	if err != nil {
+		return errors.Wrap(err, "parse redis uri")
+	}
+	cli := redis.NewClient(opt)
+	defer cli.Close() // nolint: errcheck
+
+	rdbPath := r.RDBPath
+	if rdbPath == "" {
+		if rdbPath, err = redisRDBPath(ctx, cli); err != nil {
+			return err
+		}
+	}
+
+	lastSave, err := cli.LastSave(ctx).Result()
+	if err != nil {
+		return errors.Wrap(err, "LASTSAVE")
+	}
+	if err = cli.BgSave(ctx).Err(); err != nil {
+		return errors.Wrap(err, "BGSAVE")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "wait BGSAVE")
+		case <-time.After(redisSavePollInterval):
+		}
+
+		saved, err := cli.LastSave(ctx).Result()
+		if err != nil {
+			return errors.Wrap(err, "LASTSAVE")
+		}
+		if saved != lastSave {
+			break
+		}
+	}
+
+	f, err := os.Open(rdbPath)
+	if err != nil {
+		return errors.Wrap(err, "open rdb")
+	}
+	defer f.Close() // nolint: errcheck
+
+	if _, err = io.Copy(w, f); err != nil {
+		return errors.Wrap(err, "copy rdb")
+	}
+	return nil
+}
+
+// redisRDBPath returns the rdb file configured on the server.
+func redisRDBPath(ctx context.Context, cli *redis.Client) (string, error) {
+	var parts [2]string
+	for i, name := range []string{"dir", "dbfilename"} {
+		cfg, err := cli.ConfigGet(ctx, name).Result()
+		if err != nil {
+			return "", errors.Wrapf(err, "CONFIG GET %s", name)
+		}
+		if parts[i] = cfg[name]; parts[i] == "" {
+			return "", errors.Errorf("redis config %q is empty", name)
+		}
+	}
+
+	return filepath.Join(parts[0], parts[1]), nil
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/zap"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/Laisky/go-ramjet/library/s3"
+)
+
+// Retention is the retention policy of a backup series, the time of a backup
+// is the date in its key.
+type Retention = s3.RetentionPolicy
+
+// SelectExpired picks the managed backup keys that r expires, newest first.
+func SelectExpired(keyPrefix string, keys []string, r Retention) []string {
+	objs := make([]minio.ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		objs = append(objs, minio.ObjectInfo{Key: key})
+	}
+
+	var expired []string
+	for _, item := range selectExpiredObjects(keyPrefix, objs, r, time.Now()) {
+		expired = append(expired, item.Key)
+	}
+	return expired
+}
+
+// selectExpiredObjects picks the managed backups in objs that r expires at now.
+func selectExpiredObjects(keyPrefix string, objs []minio.ObjectInfo, r Retention, now time.Time) []s3.RetentionItem {
+	if r.KeepsAll() {
+		return nil
+	}
+
+	items := make([]s3.RetentionItem, 0, len(objs))
+	for _, obj := range objs {
+		date, _, _, ok := ParseKey(keyPrefix, obj.Key)
+		if !ok {
+			continue
+		}
+		t, _ := time.Parse(DateLayout, date)
+		items = append(items, s3.RetentionItem{Key: obj.Key, Time: t, Size: obj.Size})
+	}
+
+	return s3.ExpiredItems(r.Plan(items, now))
+}
+
+// ListKeys lists the object keys under keyPrefix.
+func ListKeys(ctx context.Context, s3cli *minio.Client, bucket, keyPrefix string) ([]string, error) {
+	objs, err := listObjects(ctx, s3cli, bucket, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+// listObjects lists the objects under keyPrefix.
+func listObjects(ctx context.Context, s3cli *minio.Client, bucket, keyPrefix string) ([]minio.ObjectInfo, error) {
+	var objs []minio.ObjectInfo
+	for obj := range s3cli.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: keyPrefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, errors.Wrapf(obj.Err, "list objects with prefix %q", keyPrefix)
+		}
+
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
+
+// Cleanup removes the managed backups under keyPrefix expired by r,
+// along with their manifests.
+func Cleanup(ctx context.Context, s S3, keyPrefix string, r Retention) error {
+	s3cli, err := s.Cli()
+	if err != nil {
+		return err
+	}
+
+	objs, err := listObjects(ctx, s3cli, s.Bucket, keyPrefix)
+	if err != nil {
+		return err
+	}
+
+	expired := selectExpiredObjects(keyPrefix, objs, r, time.Now())
+	if len(expired) == 0 {
+		logger.Debug("backup retention within limit",
+			zap.String("bucket", s.Bucket),
+			zap.String("key_prefix", keyPrefix),
+			zap.Any("retention", r),
+			zap.Int("objects", len(objs)))
+		return nil
+	}
+
+	logger.Info("cleanup expired backups",
+		zap.String("bucket", s.Bucket),
+		zap.String("key_prefix", keyPrefix),
+		zap.Any("retention", r),
+		zap.Int("delete_count", len(expired)))
+	for _, item := range expired {
+		expiredKey := item.Key
+		if err := s3cli.RemoveObject(ctx, s.Bucket, expiredKey, minio.RemoveObjectOptions{}); err != nil {
+			return errors.Wrapf(err, "remove object %q", expiredKey)
+		}
+
+		if err := s3cli.RemoveObject(ctx, s.Bucket, expiredKey+ManifestSuffix, minio.RemoveObjectOptions{}); err != nil {
+			logger.Warn("remove manifest of expired backup", zap.String("object", expiredKey), zap.Error(err))
+		}
+
+		logger.Info("deleted expired backup",
+			zap.String("bucket", s.Bucket),
+			zap.String("object", expiredKey))
+	}
+
+	return nil
+}
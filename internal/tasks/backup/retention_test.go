@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseKey verifies which keys belong to a backup series.
+func TestParseKey(t *testing.T) {
+	keyPrefix, base := KeyPrefix("backup/mongodb/", "blog", "mongodb-backup")
+	require.Equal(t, "backup/mongodb/blog-", keyPrefix)
+	require.Equal(t, "blog", base)
+	keyPrefix, _ = KeyPrefix("", "", "redis-backup")
+	require.Equal(t, "redis-backup-", keyPrefix)
+
+	date, rawExt, enc, ok := ParseKey("b/blog-", "b/blog-20260101.archive.zst.age")
+	require.True(t, ok)
+	require.Equal(t, "20260101", date)
+	require.Equal(t, ".archive", rawExt)
+	require.Equal(t, Encoding{Compression: CompressionZstd, Encryption: EncryptionAge}, enc)
+
+	_, rawExt, enc, ok = ParseKey("b/blog-", "b/blog-20260101.zip")
+	require.True(t, ok, "engines may compress by themselves")
+	require.Equal(t, ".zip", rawExt)
+	require.Equal(t, Encoding{}, enc)
+
+	for _, key := range []string{
+		"b/blog-20260101.gz.sha256.json",
+		"b/blog-20260101",
+		"b/blog-20260101.rdb.age",
+		"b/blog-latest.gz",
+		"b/blog-restore-verify.json",
+		"b/other-20260101.gz",
+	} {
+		require.False(t, IsManagedKey("b/blog-", key), key)
+	}
+}
+
+// TestSelectExpired verifies the grandfather-father-son retention.
+func TestSelectExpired(t *testing.T) {
+	keyPrefix := "b/db-"
+	keys := []string{
+		"b/db-20251115.gz",
+		"b/db-20251130.gz",
+		"b/db-20251201.gz",
+		"b/db-20251231.gz",
+		"b/db-20260101.gz",
+		"b/db-20260105.gz", // monday
+		"b/db-20260106.gz",
+		"b/db-20260106.dump.gz",
+		"b/db-20260107.gz",
+		"b/db-20260107.gz.sha256.json",
+		"b/db-latest.gz",
+	}
+
+	require.Nil(t, SelectExpired(keyPrefix, keys, Retention{}), "no rule keeps all")
+
+	require.Equal(t, []string{
+		"b/db-20260106.dump.gz",
+		"b/db-20260105.gz",
+		"b/db-20260101.gz",
+		"b/db-20251231.gz",
+		"b/db-20251201.gz",
+		"b/db-20251130.gz",
+		"b/db-20251115.gz",
+	}, SelectExpired(keyPrefix, keys, Retention{KeepLast: 2}))
+
+	// daily keeps 0107, weekly keeps 0107 (W02) and 0101 (W01),
+	// monthly keeps 0107, 1231 and 1130
+	require.Equal(t, []string{
+		"b/db-20260106.gz",
+		"b/db-20260106.dump.gz",
+		"b/db-20260105.gz",
+		"b/db-20251201.gz",
+		"b/db-20251115.gz",
+	}, SelectExpired(keyPrefix, keys, Retention{KeepDaily: 1, KeepWeekly: 2, KeepMonthly: 3}))
+}
@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// TarDir writes the regular files in dir into w.
+func TarDir(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return errors.Wrapf(err, "stat %q", path)
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return errors.Wrapf(err, "rel %q", path)
+		}
+		if err = tw.WriteHeader(&tar.Header{Name: filepath.ToSlash(name), Mode: 0o600,
+			Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+			return errors.Wrapf(err, "write header of %q", name)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrapf(err, "open %q", path)
+		}
+		defer f.Close()
+		if _, err = io.Copy(tw, f); err != nil {
+			return errors.Wrapf(err, "copy %q", path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(tw.Close(), "close tar")
+}
+
+// UntarDir extracts the regular files of r into dir.
+func UntarDir(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read tar")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return errors.Errorf("unsafe path %q in backup", hdr.Name)
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return errors.Wrap(err, "mkdir")
+		}
+		if err = func() error {
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return errors.Wrapf(err, "create %q", path)
+			}
+			defer f.Close()
+			if _, err = io.Copy(f, tr); err != nil {
+				return errors.Wrapf(err, "extract %q", hdr.Name)
+			}
+			return f.Close()
+		}(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/store"
+)
+
+const (
+	// DefaultKeepLast is the retention if no rule is configured.
+	DefaultKeepLast = 14
+
+	defaultIntervalSec = 86400
+	perBackupTimeout   = 30 * time.Minute
+)
+
+// Engines of targets.
+const (
+	EngineMongoDB    = "mongodb"
+	EngineRedis      = "redis"
+	EngineClickHouse = "clickhouse"
+)
+
+type cfgS3 struct {
+	S3        `mapstructure:",squash"`
+	Retention `mapstructure:",squash"`
+}
+
+type cfgTarget struct {
+	// Engine is `mongodb`, `redis` or `clickhouse`.
+	Engine string
+	// Name is the basename of backups, default to the database.
+	Name string
+	// DSN is the connection string of the database.
+	DSN      string
+	Database string
+	// BackupFilePrefix works as `backup_file_prefix` of the postgres task.
+	BackupFilePrefix string `mapstructure:"backup_file_prefix"`
+	// Compression is `gzip` (default) or `zstd`, ignored by clickhouse.
+	Compression string
+	Encryption  Encryption
+	// RedisMethod is `rdb` (default) or `bgsave`.
+	RedisMethod  string `mapstructure:"redis_method"`
+	RedisRDBPath string `mapstructure:"redis_rdb_path"`
+	// ClickHouseS3URL is the bucket url reachable from the clickhouse server.
+	ClickHouseS3URL string `mapstructure:"clickhouse_s3_url"`
+	// ClickHouseServerCredentials uses the server's own S3 credentials,
+	// instead of sending the task's in the BACKUP statement.
+	ClickHouseServerCredentials bool `mapstructure:"clickhouse_server_credentials"`
+}
+
+type cfgBackup struct {
+	Enable      bool
+	IntervalSec int `mapstructure:"interval"`
+	S3          cfgS3
+	UseTempFile bool   `mapstructure:"use_temp_file"`
+	TempDir     string `mapstructure:"temp_dir"`
+	Targets     []cfgTarget
+}
+
+// loadCfg loads `tasks.db_backup`.
+func loadCfg() (*cfgBackup, error) {
+	cfg := new(cfgBackup)
+	if err := gconfig.Shared.UnmarshalKey("tasks.db_backup", cfg); err != nil {
+		return nil, errors.Wrap(err, "unmarshal tasks.db_backup")
+	}
+	if err := cfg.S3.Retention.Valid(); err != nil {
+		return nil, err
+	}
+	if cfg.S3.Retention.KeepsAll() {
+		cfg.S3.KeepLast = DefaultKeepLast
+	}
+
+	return cfg, nil
+}
+
+// engine returns the engine of t.
+func (t cfgTarget) engine() (Engine, error) {
+	switch t.Engine {
+	case EngineMongoDB:
+		return &MongoDB{URI: t.DSN, Database: t.Database}, nil
+	case EngineRedis:
+		return &Redis{URI: t.DSN, Method: t.RedisMethod, RDBPath: t.RedisRDBPath}, nil
+	case EngineClickHouse:
+		return &ClickHouse{
+			DSN:               t.DSN,
+			Database:          t.Database,
+			S3URL:             t.ClickHouseS3URL,
+			ServerCredentials: t.ClickHouseServerCredentials,
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown engine %q", t.Engine)
+	}
+}
+
+// job builds the backup job of t.
+func (t cfgTarget) job(cfg *cfgBackup) (*Job, error) {
+	engine, err := t.engine()
+	if err != nil {
+		return nil, err
+	}
+
+	name := t.Name
+	if name == "" {
+		name = t.Database
+	}
+	keyPrefix, _ := KeyPrefix(t.BackupFilePrefix, name, t.Engine+"-backup")
+	job := &Job{
+		KeyPrefix:   keyPrefix,
+		Engine:      engine,
+		Encryption:  t.Encryption,
+		S3:          cfg.S3.S3,
+		Retention:   cfg.S3.Retention,
+		UseTempFile: cfg.UseTempFile,
+		TempDir:     cfg.TempDir,
+	}
+	if _, ok := engine.(S3Dumper); ok {
+		return job, nil
+	}
+
+	if job.Encoding, err = NewEncoding(t.Compression, t.Encryption); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// runBackup backs up all targets.
+func runBackup() {
+	cfg, err := loadCfg()
+	if err != nil {
+		logger.Error("load db backup config", zap.Error(err))
+		return
+	}
+	if !cfg.S3.Enable {
+		logger.Warn("s3 upload disabled; skip backup to avoid local storage")
+		return
+	}
+
+	today := time.Now().UTC().Format(DateLayout)
+	for _, target := range cfg.Targets {
+		job, err := target.job(cfg)
+		if err != nil {
+			logger.Error("invalid backup target",
+				zap.String("engine", target.Engine),
+				zap.String("database", target.Database),
+				zap.Error(err))
+			continue
+		}
+
+		func() {
+			ctx, cancel := context.WithTimeout(context.Background(), perBackupTimeout)
+			defer cancel()
+
+			if key, err := job.Run(ctx, today); err != nil {
+				logger.Error("backup failed", zap.String("object", key), zap.Error(err))
+			}
+		}()
+	}
+}
+
+func bindTask() {
+	logger.Info("bind db backup task...")
+	cfg, err := loadCfg()
+	if err != nil {
+		logger.Panic("load db backup config", zap.Error(err))
+	}
+	if !cfg.Enable {
+		logger.Info("db backup disabled by config; not binding task")
+		return
+	}
+
+	interval := cfg.IntervalSec
+	if interval <= 0 {
+		interval = defaultIntervalSec
+	}
+	logger.Info("db backup config",
+		zap.Int("interval_sec", interval),
+		zap.Int("targets", len(cfg.Targets)),
+		zap.Any("retention", cfg.S3.Retention),
+		zap.String("s3_endpoint", cfg.S3.Endpoint),
+		zap.String("s3_bucket", cfg.S3.Bucket))
+	go store.TaskStore.TickerAfterRun(time.Duration(interval)*time.Second, runBackup)
+}
+
+func init() {
+	store.TaskStore.Store("db-backup", bindTask)
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTargetJob verifies how targets are turned into backup jobs.
+func TestTargetJob(t *testing.T) {
+	gconfig.Shared.Set("tasks.db_backup", map[string]any{
+		"s3": map[string]any{"enable": true, "bucket": "backups", "endpoint": "s3.example.com", "keep_weekly": 4},
+		"targets": []map[string]any{
+			{"engine": "mongodb", "dsn": "mongodb://u:p@h", "database": "blog",
+				"backup_file_prefix": "backup/mongodb/", "compression": "zstd"},
+			{"engine": "clickhouse", "dsn": "clickhouse://h:9000", "database": "logs"},
+		},
+	})
+	t.Cleanup(func() { gconfig.Shared.Set("tasks.db_backup", nil) })
+
+	cfg, err := loadCfg()
+	require.NoError(t, err)
+	require.Equal(t, Retention{KeepWeekly: 4}, cfg.S3.Retention)
+	require.Equal(t, "backups", cfg.S3.Bucket)
+	require.Len(t, cfg.Targets, 2)
+
+	job, err := cfg.Targets[0].job(cfg)
+	require.NoError(t, err)
+	require.Equal(t, "backup/mongodb/blog-20260101.archive.zst", job.Key("20260101"))
+
+	// clickhouse compresses by itself
+	job, err = cfg.Targets[1].job(cfg)
+	require.NoError(t, err)
+	require.Equal(t, "logs-20260101.zip", job.Key("20260101"))
+	require.Equal(t,
+		"BACKUP DATABASE `logs` TO S3('https://s3.example.com/backups/logs-20260101.zip', '', 'it\\'s')",
+		(&ClickHouse{Database: "logs"}).backupSQL(S3{Endpoint: "s3.example.com", Bucket: "backups", AccessSecret: "it's"},
+			job.Key("20260101")))
+	require.Equal(t,
+		"BACKUP DATABASE `logs` TO S3('https://s3.example.com/backups/logs-20260101.zip')",
+		(&ClickHouse{Database: "logs", ServerCredentials: true}).backupSQL(
+			S3{Endpoint: "s3.example.com", Bucket: "backups", AccessKey: "ak", AccessSecret: "sk"},
+			job.Key("20260101")))
+
+	_, err = cfgTarget{Engine: "mysql"}.job(cfg)
+	require.ErrorContains(t, err, "unknown engine")
+	_, err = cfgTarget{Engine: EngineRedis, Compression: "lz4"}.job(cfg)
+	require.ErrorContains(t, err, "unknown compression")
+
+	gconfig.Shared.Set("tasks.db_backup.s3.keep_weekly", 0)
+	cfg, err = loadCfg()
+	require.NoError(t, err)
+	require.Equal(t, Retention{KeepLast: DefaultKeepLast}, cfg.S3.Retention)
+}
+
+// TestDumpCommands verifies the dump tools are run without passwords in their arguments.
+func TestDumpCommands(t *testing.T) {
+	var got []string
+	original := execCommand
+	execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		got = append([]string{name}, args...)
+		return exec.CommandContext(ctx, "echo", "-n", "dump")
+	}
+	t.Cleanup(func() { execCommand = original })
+
+	var buf bytes.Buffer
+	r := &Redis{URI: "redis://:secret@127.0.0.1:6379/0"}
+	require.NoError(t, r.Dump(context.Background(), &buf, ""))
+	require.Equal(t, []string{"redis-cli", "-u", "redis://127.0.0.1:6379/0", "--rdb", "-"}, got)
+	require.Equal(t, "dump", buf.String())
+
+	m := &MongoDB{URI: "mongodb://u:secret@h", Database: "blog"}
+	require.NoError(t, m.Dump(context.Background(), &buf, t.TempDir()))
+	require.Equal(t, "mongodump", got[0])
+	require.NotContains(t, got, "mongodb://u:secret@h")
+	require.Equal(t, []string{"--archive", "--db", "blog"}, got[3:])
+
+	require.Equal(t, `'it''s'`, quoteYAML("it's"))
+}
@@ -150,59 +150,16 @@ func (w *RssWorker) Write2S3(ctx context.Context,
 	return nil
 }
 
+// persistRSSObjectToS3 uploads payload to key, keeping at most keep versions of it.
 func (w *RssWorker) persistRSSObjectToS3(ctx context.Context, logger glog.Logger, cli s3.ObjectVersionClient, bucket, key, payload string, keep int) error {
-	payloadSize := int64(len(payload))
-	if keep < 0 {
-		keep = 0
-	}
-	preTrimTarget := keep - 1
-	if preTrimTarget < 0 {
-		preTrimTarget = 0
-	}
-
-	if preTrimTarget > 0 {
-		logger.Debug("pre trimming rss versions before upload",
-			zap.String("bucket", bucket),
-			zap.String("object", key),
-			zap.Int("target_keep", preTrimTarget))
-	}
-	if err := s3.KeepLatestObjectVersions(ctx, logger, cli, bucket, key, preTrimTarget); err != nil {
-		return errors.Wrap(err, "pre-trim s3 rss history")
-	}
-
-	upload := func() error {
-		logger.Debug("uploading rss payload",
-			zap.String("bucket", bucket),
-			zap.String("object", key),
-			zap.Int64("bytes", payloadSize))
-		_, err := cli.PutObject(ctx,
-			bucket,
-			key,
-			strings.NewReader(payload),
-			payloadSize,
-			minio.PutObjectOptions{ContentType: "application/xml"},
-		)
-		return err
-	}
-
-	if err := upload(); err != nil {
-		if s3.IsVersionLimitError(err) && keep > 0 {
-			logger.Debug("hit object version cap, trimming and retrying",
-				zap.String("bucket", bucket),
-				zap.String("object", key))
-			if trimErr := s3.KeepLatestObjectVersions(ctx, logger, cli, bucket, key, preTrimTarget); trimErr != nil {
-				return errors.Wrap(trimErr, "trim s3 rss history after limit error")
-			}
-			if retryErr := upload(); retryErr != nil {
-				return errors.Wrapf(retryErr, "put object %v", key)
-			}
-		} else {
-			return errors.Wrapf(err, "put object %v", key)
-		}
-	}
-
-	if err := s3.KeepLatestObjectVersions(ctx, logger, cli, bucket, key, keep); err != nil {
-		return errors.Wrap(err, "trim s3 rss history")
+	logger.Debug("uploading rss payload",
+		zap.String("bucket", bucket),
+		zap.String("object", key),
+		zap.Int("bytes", len(payload)))
+	if _, err := s3.PutObjectCappingVersions(ctx, logger, cli, bucket, key,
+		strings.NewReader(payload), int64(len(payload)),
+		minio.PutObjectOptions{ContentType: "application/xml"}, keep); err != nil {
+		return errors.Wrap(err, "persist rss")
 	}
 
 	return nil
@@ -14,6 +14,8 @@ import (
 	gmw "github.com/Laisky/gin-middlewares/v7"
 	"github.com/Laisky/zap"
 	"github.com/minio/minio-go/v7"
+
+	"github.com/Laisky/go-ramjet/library/s3"
 )
 
 // S3Client defines the minimal S3 client behavior used by CV storage.
@@ -359,29 +361,22 @@ func (s *S3ContentStore) pruneContentHistory(ctx context.Context, limit int) err
 		return errors.Wrap(err, "list content history")
 	}
 
-	removable := make([]minio.ObjectInfo, 0, len(versions))
+	removable := make([]s3.RetentionItem, 0, len(versions))
 	for _, obj := range versions {
 		if strings.TrimSpace(obj.VersionID) == "" {
 			continue
 		}
-		removable = append(removable, obj)
-	}
-
-	sort.Slice(removable, func(i int, j int) bool {
-		if removable[i].LastModified.Equal(removable[j].LastModified) {
-			if removable[i].IsLatest != removable[j].IsLatest {
-				return removable[i].IsLatest
-			}
-			return removable[i].VersionID > removable[j].VersionID
-		}
-		return removable[i].LastModified.After(removable[j].LastModified)
-	})
-
-	if len(removable) <= limit {
-		return nil
+		removable = append(removable, s3.RetentionItem{
+			Key:       obj.Key,
+			VersionID: obj.VersionID,
+			Time:      obj.LastModified,
+			Size:      obj.Size,
+			IsLatest:  obj.IsLatest,
+		})
 	}
 
-	for _, obj := range removable[limit:] {
+	policy := s3.RetentionPolicy{KeepLast: limit}
+	for _, obj := range s3.ExpiredItems(policy.Plan(removable, time.Now())) {
 		if err := s.client.RemoveObject(ctx, s.bucket, s.contentKey, minio.RemoveObjectOptions{VersionID: obj.VersionID}); err != nil {
 			return errors.Wrap(err, "remove stale content version")
 		}
@@ -41,4 +41,14 @@ import (
 	_ "github.com/Laisky/go-ramjet/internal/tasks/telegram/notes"
 	// postgres backup
 	_ "github.com/Laisky/go-ramjet/internal/tasks/postgres"
+	// mongodb, redis & clickhouse backup
+	_ "github.com/Laisky/go-ramjet/internal/tasks/backup"
+	// s3 retention sweeper
+	_ "github.com/Laisky/go-ramjet/internal/tasks/s3retention"
+	// alerts & silences api
+	_ "github.com/Laisky/go-ramjet/internal/tasks/alerting"
+	// public status page & uptime history
+	_ "github.com/Laisky/go-ramjet/internal/tasks/status"
+	// oidc single sign-on
+	_ "github.com/Laisky/go-ramjet/internal/tasks/sso"
 )
@@ -1,8 +1,8 @@
 package monitor
 
 import (
+	"context"
 	"fmt"
-	"time"
 
 	"github.com/Laisky/go-ramjet/library/log"
 
@@ -12,35 +12,43 @@ import (
 	"github.com/Laisky/go-ramjet/library/alert"
 )
 
-// monitorNodeMetrics check node metrics to determine whether to throw alert
+// monitorNodeMetrics check node metrics to determine whether to throw alert,
+// each node fires its own alert and resolves it once the storage recovers
 func monitorNodeMetrics(st *ClusterSt, alertSt *AlertSt, metrics []*NodeMetric) {
 	log.Logger.Debug("monitorNodeMetrics")
-	// monitor fs storage
-	title := "[Ramjet]ES Storage Alert"
-	cnt := time.Now().Format(time.RFC3339) + "\n"
-	isNeedAlert := false
+	threshold := alertSt.Conditions["fs_storage_rate"].(float64)
+	ctx := context.Background()
 
 	for _, m := range metrics {
-		if m.UsageRate > alertSt.Conditions["fs_storage_rate"].(float64) {
-			isNeedAlert = true
-
+		isNeedAlert := m.UsageRate > threshold
+		if isNeedAlert {
 			store.TaskStore.Trigger(NodeStorageAlertEvt, map[string]interface{}{"node": m, "cluster": st}, nil, nil)
-			cnt += fmt.Sprintf("%v's storage is at: %v\n", m.NodeName, m.UsageRate)
 		}
-	}
-
-	if !isNeedAlert {
-		return
-	}
 
-	for name, addr := range alertSt.Receivers {
-		if err := alert.Manager.Send(
-			addr,
-			name,
-			title,
-			cnt,
-		); err != nil {
-			log.Logger.Error("try to send fs alert email got error", zap.Error(err))
+		for name, addr := range alertSt.Receivers {
+			a := alert.Alert{
+				Source:   "elasticsearch",
+				Name:     "node_storage",
+				Severity: alert.SeverityCritical,
+				Labels: map[string]string{
+					"cluster": st.Name,
+					"node":    m.NodeName,
+				},
+				Summary:     "[Ramjet]ES Storage Alert",
+				Description: fmt.Sprintf("%v's storage is at: %v", m.NodeName, m.UsageRate),
+				To:          addr,
+				ToName:      name,
+			}
+
+			var err error
+			if isNeedAlert {
+				err = alert.Manager.Fire(ctx, a)
+			} else {
+				err = alert.Manager.Resolve(ctx, a)
+			}
+			if err != nil {
+				log.Logger.Error("try to send fs alert got error", zap.Error(err))
+			}
 		}
 	}
 }
@@ -37,9 +37,7 @@ func init() {
 	var err error
 	idxRolloverReqBodyTpl, err = template.New("idxRolloverReqBodyTpl").Parse(`
 	{
-		"conditions": {
-			"max_age": "{{.Rollover}}"
-		},
+		"conditions": {{.Conditions}},
 		"aliases": {
 			"{{.IdxAlias}}": {}
 		},
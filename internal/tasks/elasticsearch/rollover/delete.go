@@ -47,8 +47,27 @@ func RunDeleteTask(ctx context.Context, sem *semaphore.Weighted, st *IdxSetting)
 		log.Logger.Error("try to filter indices aliases got error", zap.Error(err))
 	}
 
+	// Never delete indices without snapshots
+	if st.Snapshot != nil && len(tobeDeleteIdx) != 0 {
+		if err = checkSnapshotRepository(ctx, st.API, st.Snapshot.Repository); err != nil {
+			log.Logger.Error("skip deleting indices", zap.Strings("index", tobeDeleteIdx), zap.Error(err))
+			return
+		}
+	}
+
 	log.Logger.Info("try to delete indices", zap.Strings("index", tobeDeleteIdx))
 	for _, idx := range tobeDeleteIdx {
+		if st.Snapshot != nil {
+			if err = snapshotIndex(ctx, st.API, st.Snapshot, idx); errors.Is(err, errSnapshotInProgress) {
+				log.Logger.Info("skip deleting index until its snapshot is done", zap.String("index", idx))
+				continue
+			} else if err != nil {
+				log.Logger.Error("skip deleting index without snapshot",
+					zap.String("index", idx), zap.Error(err))
+				continue
+			}
+		}
+
 		err = RemoveIndexByName(ctx, st.API, idx)
 		if err != nil {
 			log.Logger.Error("try to delete index %v got error",
@@ -95,16 +114,25 @@ func RemoveIndexByName(ctx context.Context, api, index string) (err error) {
 func IsIdxShouldDelete(now time.Time,
 	dateStr string,
 	expires time.Duration) (bool, error) {
-	layout := "2006.01.02 -0700"
-	t, err := time.Parse(layout, strings.ReplaceAll(dateStr, "-", ".")+" +0800")
-	//t, err := time.Parse(layout, dateStr+" +0800")
+	t, err := parseIdxDate(dateStr)
 	if err != nil {
-		return false, errors.Wrapf(err, "parse date %v with layout %v error", dateStr, layout)
+		return false, err
 	}
 	t = t.Add(24 * time.Hour) // elasticsearch dateStr has 1 day delay
 	return now.Sub(t) > expires, nil
 }
 
+// parseIdxDate parse the date of index like `2016.10.31` or `2016-10-31`, treated as +0800
+func parseIdxDate(dateStr string) (time.Time, error) {
+	layout := "2006.01.02 -0700"
+	t, err := time.Parse(layout, strings.ReplaceAll(dateStr, "-", ".")+" +0800")
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "parse date %v with layout %v error", dateStr, layout)
+	}
+
+	return t, nil
+}
+
 // FilterToBeDeleteIndicies return the indices that need be delete
 func FilterToBeDeleteIndicies(allInd []string,
 	idxSt *IdxSetting) (indices []string, err error) {
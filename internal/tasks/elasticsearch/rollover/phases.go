@@ -0,0 +1,336 @@
+package rollover
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/zap"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+const (
+	// phaseMetaKey is the key in index mapping `_meta` to record the applied phase
+	phaseMetaKey = "ramjet_phase"
+	// shrunkIdxSuffix is appended to the name of shrunk indices
+	shrunkIdxSuffix = "-shrunk"
+	phaseTimeout    = time.Hour
+)
+
+var (
+	// healthPollInterval is the interval to wait for cluster health
+	healthPollInterval = time.Second
+)
+
+// RunPhaseTask apply lifecycle phases to indices by their ages
+func RunPhaseTask(ctx context.Context, sem *semaphore.Weighted, st *IdxSetting) {
+	if err := sem.Acquire(ctx, 1); err != nil {
+		log.Logger.Error("acquire task semaphore", zap.Error(err))
+		return
+	}
+	defer sem.Release(1)
+
+	if err := applyPhases(ctx, st, time.Now()); err != nil {
+		log.Logger.Error("apply phases got error", zap.String("index", st.IdxAlias), zap.Error(err))
+	}
+}
+
+// applyPhases apply the latest due phase to each index except the write index
+func applyPhases(ctx context.Context, st *IdxSetting, now time.Time) error {
+	allIdx, err := LoadAllIndicesNames(st.API)
+	if err != nil {
+		return errors.Wrap(err, "load indices")
+	}
+
+	var indices []string
+	for _, idx := range allIdx {
+		if st.Regexp.FindString(idx) == idx {
+			indices = append(indices, idx)
+		}
+	}
+	if indices, err = FilterReadyToBeDeleteIndices(GetAliasURL(st), indices); err != nil {
+		return errors.Wrap(err, "filter write index")
+	}
+	sort.Strings(indices)
+
+	for _, idx := range indices {
+		created, err := parseIdxDate(st.Regexp.FindStringSubmatch(idx)[1])
+		if err != nil {
+			log.Logger.Warn("skip index without date", zap.String("index", idx), zap.Error(err))
+			continue
+		}
+
+		phaseIdx := duePhase(st.Phases, now.Sub(created))
+		if phaseIdx < 0 {
+			continue
+		}
+
+		applied, err := loadIdxPhase(ctx, st.API, idx)
+		if err != nil {
+			log.Logger.Error("load phase of index", zap.String("index", idx), zap.Error(err))
+			continue
+		}
+		if appliedIdx := phaseIndex(st.Phases, applied); appliedIdx >= phaseIdx {
+			continue
+		}
+
+		if err = applyPhase(ctx, st, idx, st.Phases[phaseIdx]); err != nil {
+			log.Logger.Error("apply phase to index got error",
+				zap.String("index", idx),
+				zap.String("phase", st.Phases[phaseIdx].Name),
+				zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// duePhase return the index of the latest phase entered at age, -1 if none
+func duePhase(phases []*Phase, age time.Duration) int {
+	due := -1
+	for i, p := range phases {
+		if age >= p.After {
+			due = i
+		}
+	}
+
+	return due
+}
+
+// phaseIndex return the index of phase by name, -1 if not found
+func phaseIndex(phases []*Phase, name string) int {
+	for i, p := range phases {
+		if p.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// loadIdxPhase load the applied phase recorded in the index mapping
+func loadIdxPhase(ctx context.Context, api, idx string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp := map[string]struct {
+		Mappings struct {
+			Meta map[string]any `json:"_meta"`
+		} `json:"mappings"`
+	}{}
+	if err := esRequest(ctx, http.MethodGet, api+idx+"/_mapping", nil, &resp); err != nil {
+		return "", errors.Wrap(err, "get index mapping")
+	}
+
+	phase, _ := resp[idx].Mappings.Meta[phaseMetaKey].(string)
+	return phase, nil
+}
+
+// applyPhase shrink, move, reduce replicas and force-merge the index,
+// then record the phase in the index mapping
+func applyPhase(ctx context.Context, st *IdxSetting, idx string, phase *Phase) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, phaseTimeout)
+	defer cancel()
+	logger := log.Logger.With(zap.String("index", idx), zap.String("phase", phase.Name))
+	logger.Info("apply phase to index")
+	if gconfig.Shared.GetBool("dry") {
+		return nil
+	}
+
+	if phase.Shrink > 0 && !strings.HasSuffix(idx, shrunkIdxSuffix) {
+		if idx, err = shrinkIndex(ctx, st, idx, phase.Shrink); err != nil {
+			return errors.Wrap(err, "shrink index")
+		}
+	}
+
+	settings := map[string]any{}
+	if phase.Replicas != nil {
+		settings["index.number_of_replicas"] = *phase.Replicas
+	}
+	for attr, val := range phase.Require {
+		settings["index.routing.allocation.require."+attr] = val
+	}
+	if len(settings) != 0 {
+		if err = esRequest(ctx, http.MethodPut, st.API+idx+"/_settings", settings, nil); err != nil {
+			return errors.Wrap(err, "update index settings")
+		}
+	}
+
+	if phase.ForceMerge > 0 {
+		if err = esRequest(ctx, http.MethodPost,
+			st.API+idx+"/_forcemerge?max_num_segments="+strconv.Itoa(phase.ForceMerge), nil, nil); err != nil {
+			return errors.Wrap(err, "force merge index")
+		}
+	}
+
+	if err = esRequest(ctx, http.MethodPut, st.API+idx+"/_mapping", map[string]any{
+		"_meta": map[string]any{phaseMetaKey: phase.Name},
+	}, nil); err != nil {
+		return errors.Wrap(err, "record phase")
+	}
+
+	logger.Info("success apply phase to index", zap.String("target", idx))
+	return nil
+}
+
+// shrinkIndex shrink idx to `<idx>-shrunk` with nShards primary shards,
+// moves aliases of idx to the new index and removes idx.
+//
+// return the name of the shrunk index, or idx if it is already small enough.
+func shrinkIndex(ctx context.Context, st *IdxSetting, idx string, nShards int) (target string, err error) {
+	target = idx + shrunkIdxSuffix
+	if st.Regexp.FindString(target) != target {
+		return "", errors.Errorf("shrunk index %q does not match `index` %q", target, st.Regexp.String())
+	}
+
+	err = esRequest(ctx, http.MethodHead, st.API+target, nil, nil)
+	switch {
+	case err == nil: // resume an interrupted shrink
+	case isESStatus(err, http.StatusNotFound):
+		var nodes []string
+		if nodes, err = loadPrimaryNodes(ctx, st.API, idx); err != nil {
+			return "", errors.Wrap(err, "load primary shards")
+		}
+		if len(nodes) <= nShards {
+			return idx, nil
+		}
+		node := nodes[0]
+
+		// all primaries must be on the same node, and the index must be read-only
+		if err = esRequest(ctx, http.MethodPut, st.API+idx+"/_settings", map[string]any{
+			"index.routing.allocation.require._name": node,
+			"index.blocks.write":                     true,
+		}, nil); err != nil {
+			return "", errors.Wrap(err, "prepare index to shrink")
+		}
+		if err = waitForPrimariesOnNode(ctx, st.API, idx, node); err != nil {
+			return "", errors.Wrap(err, "wait for relocating")
+		}
+
+		if err = esRequest(ctx, http.MethodPost, st.API+idx+"/_shrink/"+target, map[string]any{
+			"settings": map[string]any{
+				"index.number_of_shards":                 nShards,
+				"index.routing.allocation.require._name": nil,
+				"index.blocks.write":                     nil,
+			},
+		}, nil); err != nil {
+			return "", errors.Wrap(err, "shrink")
+		}
+	default:
+		return "", errors.Wrap(err, "check shrunk index")
+	}
+
+	if err = waitForHealth(ctx, st.API, target, "wait_for_status=yellow"); err != nil {
+		return "", errors.Wrap(err, "wait for shrunk index")
+	}
+	if err = swapIndex(ctx, st.API, idx, target); err != nil {
+		return "", errors.Wrap(err, "swap to shrunk index")
+	}
+
+	return target, nil
+}
+
+// loadPrimaryNodes return the nodes of all primary shards of idx
+func loadPrimaryNodes(ctx context.Context, api, idx string) (nodes []string, err error) {
+	var shards []struct {
+		Prirep string `json:"prirep"`
+		Node   string `json:"node"`
+	}
+	if err = esRequest(ctx, http.MethodGet,
+		api+"_cat/shards/"+idx+"?h=prirep,node&format=json", nil, &shards); err != nil {
+		return nil, errors.Wrap(err, "cat shards")
+	}
+
+	for _, shard := range shards {
+		if shard.Prirep != "p" {
+			continue
+		}
+		if shard.Node == "" {
+			return nil, errors.Errorf("primary shard of index %q is unassigned", idx)
+		}
+		nodes = append(nodes, shard.Node)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.Errorf("index %q has no primary shard", idx)
+	}
+
+	return nodes, nil
+}
+
+// waitForPrimariesOnNode wait until all primary shards of idx are relocated to node
+func waitForPrimariesOnNode(ctx context.Context, api, idx, node string) error {
+	for {
+		if err := waitForHealth(ctx, api, idx, "wait_for_no_relocating_shards=true"); err != nil {
+			return err
+		}
+
+		nodes, err := loadPrimaryNodes(ctx, api, idx)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(nodes, func(n string) bool { return n != node }) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "wait for relocating")
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// waitForHealth wait until the cluster health of idx satisfies query
+func waitForHealth(ctx context.Context, api, idx, query string) error {
+	url := api + "_cluster/health/" + idx + "?timeout=20s&" + query
+	for {
+		err := esRequest(ctx, http.MethodGet, url, nil, nil)
+		if !isESStatus(err, http.StatusRequestTimeout) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "wait for cluster health")
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// swapIndex move all aliases from idx to target and remove idx atomically
+func swapIndex(ctx context.Context, api, idx, target string) error {
+	resp := map[string]struct {
+		Aliases map[string]any `json:"aliases"`
+	}{}
+	err := esRequest(ctx, http.MethodGet, api+idx+"/_alias", nil, &resp)
+	if isESStatus(err, http.StatusNotFound) {
+		return nil // already swapped
+	} else if err != nil {
+		return errors.Wrap(err, "get aliases")
+	}
+
+	var actions []map[string]any
+	for alias := range resp[idx].Aliases {
+		actions = append(actions, map[string]any{
+			"add": map[string]any{"index": target, "alias": alias},
+		})
+	}
+	actions = append(actions, map[string]any{
+		"remove_index": map[string]any{"index": idx},
+	})
+
+	if err = esRequest(ctx, http.MethodPost, api+"_aliases",
+		map[string]any{"actions": actions}, nil); err != nil {
+		return errors.Wrap(err, "update aliases")
+	}
+
+	return nil
+}
@@ -0,0 +1,392 @@
+package rollover
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Laisky/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+type fakeIdx struct {
+	phase    string
+	aliases  []string
+	settings map[string]any
+	// nodes are the nodes of primary shards
+	nodes []string
+}
+
+// fakeES is an in-memory elasticsearch serving the APIs used by rollover
+type fakeES struct {
+	mu       sync.Mutex
+	indices  map[string]*fakeIdx
+	writeIdx string
+	repoType string
+	// snapshots are states of snapshots by name
+	snapshots map[string]string
+	// newSnapshotState is the state of created snapshots
+	newSnapshotState string
+	// healthTimeouts is the number of cluster health requests to time out
+	healthTimeouts int
+	// calls are the mutating requests
+	calls []string
+}
+
+func (es *fakeES) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	body := map[string]any{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		es.calls = append(es.calls, r.Method+" "+r.URL.RequestURI())
+	}
+	write := func(v any) { _ = json.NewEncoder(w).Encode(v) }
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch parts[0] {
+	case "_cat":
+		switch parts[1] {
+		case "indices":
+			out := []map[string]string{}
+			for name := range es.indices {
+				out = append(out, map[string]string{"index": name})
+			}
+			write(out)
+		case "aliases":
+			write([]map[string]string{{"index": es.writeIdx}})
+		case "shards":
+			out := []map[string]string{}
+			for _, node := range es.indices[parts[2]].nodes {
+				out = append(out,
+					map[string]string{"prirep": "p", "node": node},
+					map[string]string{"prirep": "r", "node": "replica-node"})
+			}
+			write(out)
+		}
+	case "_cluster":
+		if es.healthTimeouts > 0 {
+			es.healthTimeouts--
+			w.WriteHeader(http.StatusRequestTimeout)
+			write(map[string]any{"timed_out": true})
+			return
+		}
+		write(map[string]any{"status": "green", "timed_out": false})
+	case "_aliases":
+		for _, action := range body["actions"].([]any) {
+			for typ, argI := range action.(map[string]any) {
+				arg := argI.(map[string]any)
+				idx := arg["index"].(string)
+				switch typ {
+				case "add":
+					es.indices[idx].aliases = append(es.indices[idx].aliases, arg["alias"].(string))
+				case "remove_index":
+					delete(es.indices, idx)
+				}
+			}
+		}
+	case "_snapshot":
+		repo := parts[1]
+		if len(parts) == 2 {
+			write(map[string]any{repo: map[string]any{"type": es.repoType}})
+			return
+		}
+
+		name := parts[2]
+		switch r.Method {
+		case http.MethodGet:
+			state, ok := es.snapshots[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			write(map[string]any{"snapshots": []any{map[string]any{"state": state}}})
+		case http.MethodDelete:
+			delete(es.snapshots, name)
+		case http.MethodPut:
+			es.snapshots[name] = es.newSnapshotState
+			write(map[string]any{"snapshot": map[string]any{"state": es.newSnapshotState}})
+		}
+	default:
+		name := parts[0]
+		idx, ok := es.indices[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if len(parts) == 1 {
+			if r.Method == http.MethodDelete {
+				delete(es.indices, name)
+			}
+			return
+		}
+
+		switch parts[1] {
+		case "_mapping":
+			if r.Method == http.MethodPut {
+				idx.phase = body["_meta"].(map[string]any)[phaseMetaKey].(string)
+				return
+			}
+			write(map[string]any{name: map[string]any{
+				"mappings": map[string]any{"_meta": map[string]any{phaseMetaKey: idx.phase}},
+			}})
+		case "_settings":
+			for k, v := range body {
+				idx.settings[k] = v
+			}
+			if node, ok := body["index.routing.allocation.require._name"].(string); ok {
+				for i := range idx.nodes {
+					idx.nodes[i] = node
+				}
+			}
+		case "_alias":
+			aliases := map[string]any{}
+			for _, alias := range idx.aliases {
+				aliases[alias] = map[string]any{}
+			}
+			write(map[string]any{name: map[string]any{"aliases": aliases}})
+		case "_shrink":
+			settings := body["settings"].(map[string]any)
+			target := &fakeIdx{phase: idx.phase, settings: map[string]any{}}
+			for range int(settings["index.number_of_shards"].(float64)) {
+				target.nodes = append(target.nodes, idx.nodes[0])
+			}
+			es.indices[parts[2]] = target
+		}
+	}
+}
+
+func newFakeES(t *testing.T) (*fakeES, string) {
+	t.Helper()
+	es := &fakeES{
+		indices:          map[string]*fakeIdx{},
+		repoType:         "s3",
+		snapshots:        map[string]string{},
+		newSnapshotState: snapshotStateSuccess,
+	}
+	srv := httptest.NewServer(es)
+	t.Cleanup(srv.Close)
+
+	oldInterval := healthPollInterval
+	healthPollInterval = time.Millisecond
+	t.Cleanup(func() { healthPollInterval = oldInterval })
+
+	return es, srv.URL + "/"
+}
+
+func (es *fakeES) addIdx(name string, nodes ...string) {
+	es.indices[name] = &fakeIdx{
+		aliases:  []string{"logs-alias"},
+		settings: map[string]any{},
+		nodes:    nodes,
+	}
+}
+
+func (es *fakeES) names() []string {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	var names []string
+	for name := range es.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func testPhaseSetting(api string) *IdxSetting {
+	zero := 0
+	return &IdxSetting{
+		Regexp:        regexp.MustCompile(`^logs-(\d{4}\.\d{2}\.\d{2})-\d+(-shrunk)?$`),
+		Expires:       90 * 24 * time.Hour,
+		IdxAlias:      "logs-alias",
+		IdxWriteAlias: "logs-write",
+		API:           api,
+		Phases: []*Phase{
+			{Name: "warm", After: 72 * time.Hour, Replicas: &zero, ForceMerge: 1, Require: map[string]string{"data": "warm"}},
+			{Name: "cold", After: 30 * 24 * time.Hour, Shrink: 1, Require: map[string]string{"data": "cold"}},
+		},
+	}
+}
+
+func TestApplyPhases(t *testing.T) {
+	ctx := context.Background()
+	es, api := newFakeES(t)
+	es.addIdx("logs-2026.01.01-000001", "node-a", "node-b")
+	es.addIdx("logs-2026.02.05-000002", "node-a", "node-b")
+	es.addIdx("logs-2026.02.10-000003", "node-a", "node-b")
+	es.addIdx("other-2026.01.01-000001", "node-a", "node-b")
+	es.writeIdx = "logs-2026.02.10-000003"
+	es.healthTimeouts = 2
+
+	st := testPhaseSetting(api)
+	now := time.Date(2026, 2, 10, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, applyPhases(ctx, st, now))
+
+	require.Equal(t, []string{
+		"logs-2026.01.01-000001-shrunk",
+		"logs-2026.02.05-000002",
+		"logs-2026.02.10-000003",
+		"other-2026.01.01-000001",
+	}, es.names())
+
+	// cold: shrunk, aliases moved, moved to cold nodes
+	shrunk := es.indices["logs-2026.01.01-000001-shrunk"]
+	require.Equal(t, "cold", shrunk.phase)
+	require.Equal(t, []string{"logs-alias"}, shrunk.aliases)
+	require.Len(t, shrunk.nodes, 1)
+	require.Equal(t, "cold", shrunk.settings["index.routing.allocation.require.data"])
+
+	// warm: no replicas, moved to warm nodes, force merged
+	warm := es.indices["logs-2026.02.05-000002"]
+	require.Equal(t, "warm", warm.phase)
+	require.Equal(t, map[string]any{
+		"index.number_of_replicas":              float64(0),
+		"index.routing.allocation.require.data": "warm",
+	}, warm.settings)
+	require.Contains(t, es.calls, "POST /logs-2026.02.05-000002/_forcemerge?max_num_segments=1")
+
+	// write index and other indices are untouched
+	require.Empty(t, es.indices["logs-2026.02.10-000003"].phase)
+	require.Empty(t, es.indices["other-2026.01.01-000001"].phase)
+
+	// applied phases are skipped
+	nCalls := len(es.calls)
+	require.NoError(t, applyPhases(ctx, st, now))
+	require.Len(t, es.calls, nCalls)
+
+	// enter the next phase
+	require.NoError(t, applyPhases(ctx, st, now.Add(30*24*time.Hour)))
+	require.Equal(t, "cold", es.indices["logs-2026.02.05-000002-shrunk"].phase)
+}
+
+func TestShrinkIndexNotMatch(t *testing.T) {
+	es, api := newFakeES(t)
+	es.addIdx("logs-2026.01.01-000001", "node-a", "node-b")
+
+	st := testPhaseSetting(api)
+	st.Regexp = regexp.MustCompile(`^logs-(\d{4}\.\d{2}\.\d{2})-\d+$`)
+	_, err := shrinkIndex(context.Background(), st, "logs-2026.01.01-000001", 1)
+	require.ErrorContains(t, err, "does not match `index`")
+	require.Empty(t, es.calls)
+}
+
+func TestSnapshotIndex(t *testing.T) {
+	ctx := context.Background()
+	sst := &SnapshotSetting{Repository: "backup", Timeout: time.Minute}
+
+	t.Run("repository", func(t *testing.T) {
+		es, api := newFakeES(t)
+		require.NoError(t, checkSnapshotRepository(ctx, api, "backup"))
+
+		es.repoType = "fs"
+		require.ErrorContains(t, checkSnapshotRepository(ctx, api, "backup"), `is "fs", expect s3`)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		es, api := newFakeES(t)
+		require.NoError(t, snapshotIndex(ctx, api, sst, "logs-2026.01.01-000001"))
+		require.Equal(t, []string{
+			"PUT /_snapshot/backup/logs-2026.01.01-000001?wait_for_completion=true",
+		}, es.calls)
+
+		// existing snapshot is reused
+		require.NoError(t, snapshotIndex(ctx, api, sst, "logs-2026.01.01-000001"))
+		require.Len(t, es.calls, 1)
+	})
+
+	t.Run("retry failed", func(t *testing.T) {
+		es, api := newFakeES(t)
+		es.snapshots["logs-2026.01.01-000001"] = "PARTIAL"
+		require.NoError(t, snapshotIndex(ctx, api, sst, "logs-2026.01.01-000001"))
+		require.Equal(t, []string{
+			"DELETE /_snapshot/backup/logs-2026.01.01-000001",
+			"PUT /_snapshot/backup/logs-2026.01.01-000001?wait_for_completion=true",
+		}, es.calls)
+
+		es.snapshots["logs-2026.01.01-000001"] = "PARTIAL"
+		es.newSnapshotState = "FAILED"
+		require.ErrorContains(t, snapshotIndex(ctx, api, sst, "logs-2026.01.01-000001"), `is "FAILED"`)
+	})
+
+	t.Run("wait in progress", func(t *testing.T) {
+		es, api := newFakeES(t)
+		es.snapshots["logs-2026.01.01-000001"] = snapshotStateInProgress
+		require.ErrorIs(t, snapshotIndex(ctx, api, sst, "logs-2026.01.01-000001"), errSnapshotInProgress)
+		require.Empty(t, es.calls, "running snapshots are never deleted")
+	})
+}
+
+func TestGoExclusive(t *testing.T) {
+	ctx := context.Background()
+	sem := semaphore.NewWeighted(2)
+	st := &IdxSetting{API: "http://es/", Regexp: regexp.MustCompile(`^logs-`)}
+
+	release := make(chan struct{})
+	done := make(chan struct{}, 2)
+	var runs atomic.Int32
+	task := func(context.Context, *semaphore.Weighted, *IdxSetting) {
+		runs.Add(1)
+		<-release
+		done <- struct{}{}
+	}
+
+	goExclusive(ctx, sem, "test", st, task)
+	// the next tick reloads the setting
+	goExclusive(ctx, sem, "test", &IdxSetting{API: st.API, Regexp: regexp.MustCompile(`^logs-`)}, task)
+	close(release)
+	<-done
+	require.EqualValues(t, 1, runs.Load(), "overlapping run is skipped")
+
+	// wait for the lock to be released
+	v, _ := runningTasks.Load("test|http://es/|^logs-")
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	mu.Unlock() //nolint: staticcheck
+	goExclusive(ctx, sem, "test", st, task)
+	<-done
+	require.EqualValues(t, 2, runs.Load(), "runs again once done")
+}
+
+func TestRunDeleteTaskWithSnapshot(t *testing.T) {
+	ctx := context.Background()
+	sem := semaphore.NewWeighted(1)
+	es, api := newFakeES(t)
+	es.addIdx("logs-2020.01.01-000001", "node-a")
+	es.addIdx("logs-2020.01.02-000002", "node-a")
+	es.writeIdx = "logs-2026.02.10-000003"
+	es.snapshots["logs-2020.01.02-000002"] = "IN_PROGRESS"
+	es.newSnapshotState = "FAILED"
+
+	st := testPhaseSetting(api)
+	st.Snapshot = &SnapshotSetting{Repository: "backup", Timeout: time.Minute}
+	es.snapshots["logs-2020.01.01-000001"] = snapshotStateSuccess
+
+	// indices without successful snapshots are kept
+	RunDeleteTask(ctx, sem, st)
+	require.Equal(t, []string{"logs-2020.01.02-000002"}, es.names())
+
+	// indices are kept if the repository is not s3
+	es.repoType = "fs"
+	es.newSnapshotState = snapshotStateSuccess
+	RunDeleteTask(ctx, sem, st)
+	require.Equal(t, []string{"logs-2020.01.02-000002"}, es.names())
+
+	// running snapshots are waited for
+	es.repoType = "s3"
+	RunDeleteTask(ctx, sem, st)
+	require.Equal(t, []string{"logs-2020.01.02-000002"}, es.names())
+
+	es.snapshots["logs-2020.01.02-000002"] = snapshotStateSuccess
+	RunDeleteTask(ctx, sem, st)
+	require.Empty(t, es.names())
+}
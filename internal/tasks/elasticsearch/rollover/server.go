@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 
-	gconfig "github.com/Laisky/go-config/v2"
 	utils "github.com/Laisky/go-utils/v6"
 	"github.com/Laisky/go-utils/v6/json"
 	"github.com/Laisky/zap"
@@ -16,25 +15,26 @@ import (
 )
 
 type idxDetail struct {
-	Name    string `json:"index-name"`
-	Expires string `json:"index-expires"`
+	Name    string   `json:"index-name"`
+	Expires string   `json:"index-expires"`
+	Phases  []string `json:"index-phases,omitempty"`
 }
 
 func bindHTTP() {
-	var (
-		stI     map[interface{}]interface{}
-		details = []*idxDetail{}
-	)
-	for _, sts := range gconfig.Shared.Get("tasks.elasticsearch-v2.configs").([]interface{}) {
-		stI = sts.(map[interface{}]interface{})
-		if stI["action"].(string) != "rollover" {
-			continue
+	details := []*idxDetail{}
+	sts, err := LoadSettings()
+	if err != nil {
+		log.Logger.Error("cannot load elasticsearch rollover settings", zap.Error(err))
+	}
+	for _, st := range sts {
+		detail := &idxDetail{
+			Name:    st.IdxAlias,
+			Expires: fmt.Sprintf("%vhrs", int(st.Expires.Hours())),
 		}
-
-		details = append(details, &idxDetail{
-			Name:    stI["index-alias"].(string),
-			Expires: fmt.Sprintf("%vhrs", stI["expires"].(int)/3600),
-		})
+		for _, p := range st.Phases {
+			detail.Phases = append(detail.Phases, fmt.Sprintf("%s@%vhrs", p.Name, int(p.After.Hours())))
+		}
+		details = append(details, detail)
 	}
 
 	log.Logger.Info("bind HTTP GET `/es/rollover`")
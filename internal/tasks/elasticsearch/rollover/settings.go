@@ -0,0 +1,208 @@
+package rollover
+
+import (
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+const (
+	defaultNShards         = 5
+	defaultNRepls          = 1
+	defaultSnapshotTimeout = time.Hour
+)
+
+var (
+	// esTimeUnitRegexp matches elasticsearch time units like `7d`
+	esTimeUnitRegexp = regexp.MustCompile(`^\d+(d|h|m|s|ms|micros|nanos)$`)
+	// esByteSizeRegexp matches elasticsearch byte sizes like `50gb`
+	esByteSizeRegexp = regexp.MustCompile(`^\d+(\.\d+)?(b|kb|mb|gb|tb|pb)$`)
+)
+
+// Phase is a lifecycle phase of rollover indices, like warm or cold
+type Phase struct {
+	Name string
+	// After is the age of indices to enter the phase
+	After time.Duration
+	// Shrink shrinks indices to the number of primary shards, 0 to disable
+	Shrink int
+	// ForceMerge force-merges indices to the number of segments, 0 to disable
+	ForceMerge int `mapstructure:"force-merge"`
+	// Replicas sets the number of replicas, nil to keep
+	Replicas *int
+	// Require moves indices to nodes with the allocation attributes, like `{data: warm}`
+	Require map[string]string
+}
+
+// SnapshotSetting snapshots indices into a S3 repository before deleting them
+type SnapshotSetting struct {
+	Repository string
+	Timeout    time.Duration
+}
+
+// rawIdxSetting is an item of `tasks.elasticsearch-v2.configs`
+type rawIdxSetting struct {
+	Action        string
+	Index         string
+	Expires       int
+	IdxAlias      string `mapstructure:"index-alias"`
+	IdxWriteAlias string `mapstructure:"index-write-alias"`
+	Mapping       string
+	API           string
+	Rollover      string
+	MaxSize       string `mapstructure:"max-size"`
+	MaxDocs       int64  `mapstructure:"max-docs"`
+	NRepls        *int   `mapstructure:"n-replicas"`
+	NShards       *int   `mapstructure:"n-shards"`
+	IsSkipCreate  bool   `mapstructure:"skip-create"`
+	Phases        []*Phase
+	Snapshot      *SnapshotSetting
+}
+
+// LoadSettings load task settings
+func LoadSettings() (idxSettings []*IdxSetting, err error) {
+	var raws []*rawIdxSetting
+	if err = gconfig.Shared.UnmarshalKey("tasks.elasticsearch-v2.configs", &raws); err != nil {
+		return nil, errors.Wrap(err, "invalid config `tasks.elasticsearch-v2.configs`")
+	}
+
+	for i, raw := range raws {
+		if raw == nil || raw.Action != "rollover" {
+			continue
+		}
+
+		idx, err := parseIdxSetting(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid config `tasks.elasticsearch-v2.configs[%d]`", i)
+		}
+		log.Logger.Debug("load rollover setting",
+			zap.String("action", raw.Action),
+			zap.String("index", idx.IdxAlias))
+		idxSettings = append(idxSettings, idx)
+	}
+
+	return idxSettings, nil
+}
+
+// parseIdxSetting validates raw and converts it to IdxSetting
+func parseIdxSetting(raw *rawIdxSetting) (*IdxSetting, error) {
+	if raw.Index == "" {
+		return nil, errors.New("`index` is required")
+	}
+	re, err := regexp.Compile(raw.Index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid regexp `index` %q", raw.Index)
+	}
+	if re.NumSubexp() < 1 {
+		return nil, errors.Errorf("`index` %q should capture the date of indices", raw.Index)
+	}
+	if raw.API == "" {
+		return nil, errors.New("`api` is required")
+	}
+	if raw.Expires <= 0 {
+		return nil, errors.New("`expires` should be positive seconds")
+	}
+
+	idx := &IdxSetting{
+		Regexp:        re,
+		Rollover:      raw.Rollover,
+		MaxSize:       raw.MaxSize,
+		MaxDocs:       raw.MaxDocs,
+		Expires:       time.Duration(raw.Expires) * time.Second,
+		IdxAlias:      raw.IdxAlias,
+		IdxWriteAlias: raw.IdxWriteAlias,
+		API:           raw.API,
+		NShards:       defaultNShards,
+		NRepls:        defaultNRepls,
+		IsSkipCreate:  raw.IsSkipCreate,
+		Phases:        raw.Phases,
+		Snapshot:      raw.Snapshot,
+	}
+	if raw.NShards != nil {
+		idx.NShards = *raw.NShards
+	}
+	if raw.NRepls != nil {
+		idx.NRepls = *raw.NRepls
+	}
+	if idx.NShards < 1 || idx.NRepls < 0 {
+		return nil, errors.Errorf("invalid `n-shards` %d or `n-replicas` %d", idx.NShards, idx.NRepls)
+	}
+
+	if idx.IdxWriteAlias == "" {
+		return nil, errors.New("`index-write-alias` is required")
+	}
+	if !idx.IsSkipCreate {
+		if err = validateRolloverSetting(raw); err != nil {
+			return nil, err
+		}
+		if idx.Mapping = getESMapping(raw.Mapping); idx.Mapping == "" {
+			return nil, errors.Errorf("`mapping` %q not found in `tasks.elasticsearch.mappings`", raw.Mapping)
+		}
+	}
+
+	if err = validatePhases(idx.Phases, idx.Expires); err != nil {
+		return nil, err
+	}
+	if idx.Snapshot != nil {
+		if idx.Snapshot.Repository == "" {
+			return nil, errors.New("`snapshot.repository` is required")
+		}
+		if idx.Snapshot.Timeout <= 0 {
+			idx.Snapshot.Timeout = defaultSnapshotTimeout
+		}
+	}
+
+	return idx, nil
+}
+
+func validateRolloverSetting(raw *rawIdxSetting) error {
+	if raw.IdxAlias == "" {
+		return errors.New("`index-alias` is required")
+	}
+	if raw.Rollover == "" && raw.MaxSize == "" && raw.MaxDocs <= 0 {
+		return errors.New("at least one of `rollover`, `max-size` or `max-docs` is required")
+	}
+	if raw.Rollover != "" && !esTimeUnitRegexp.MatchString(raw.Rollover) {
+		return errors.Errorf("invalid `rollover` %q, should be like `7d`", raw.Rollover)
+	}
+	if raw.MaxSize != "" && !esByteSizeRegexp.MatchString(raw.MaxSize) {
+		return errors.Errorf("invalid `max-size` %q, should be like `50gb`", raw.MaxSize)
+	}
+	if raw.MaxDocs < 0 {
+		return errors.Errorf("invalid `max-docs` %d", raw.MaxDocs)
+	}
+
+	return nil
+}
+
+// validatePhases checks phases are named uniquely, ordered by age, and ended before expires
+func validatePhases(phases []*Phase, expires time.Duration) error {
+	var names []string
+	for i, p := range phases {
+		switch {
+		case p == nil || p.Name == "":
+			return errors.Errorf("`phases[%d].name` is required", i)
+		case slices.Contains(names, p.Name):
+			return errors.Errorf("duplicate phase %q", p.Name)
+		case p.After <= 0:
+			return errors.Errorf("`after` of phase %q should be positive", p.Name)
+		case p.After >= expires:
+			return errors.Errorf("`after` of phase %q should be less than `expires`", p.Name)
+		case i > 0 && p.After <= phases[i-1].After:
+			return errors.Errorf("phase %q should be after phase %q", p.Name, phases[i-1].Name)
+		case p.Shrink < 0 || p.ForceMerge < 0 || (p.Replicas != nil && *p.Replicas < 0):
+			return errors.Errorf("`shrink`, `force-merge` and `replicas` of phase %q should not be negative", p.Name)
+		case p.Shrink == 0 && p.ForceMerge == 0 && p.Replicas == nil && len(p.Require) == 0:
+			return errors.Errorf("phase %q has no action", p.Name)
+		}
+		names = append(names, p.Name)
+	}
+
+	return nil
+}
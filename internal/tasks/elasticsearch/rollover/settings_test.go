@@ -0,0 +1,148 @@
+package rollover
+
+import (
+	"testing"
+	"time"
+
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/testify/require"
+)
+
+func validIdxConfig() map[string]any {
+	return map[string]any{
+		"action":            "rollover",
+		"index":             `^logs-(\d{4}\.\d{2}\.\d{2})-\d+(-shrunk)?$`,
+		"expires":           90 * 24 * 3600,
+		"index-alias":       "logs-alias",
+		"index-write-alias": "logs-write",
+		"mapping":           "logs",
+		"api":               "http://localhost:9200/",
+		"rollover":          "1d",
+		"max-size":          "50gb",
+		"max-docs":          100000000,
+		"phases": []any{
+			map[string]any{
+				"name":        "warm",
+				"after":       "72h",
+				"replicas":    0,
+				"force-merge": 1,
+				"require":     map[string]any{"data": "warm"},
+			},
+			map[string]any{
+				"name":    "cold",
+				"after":   "720h",
+				"shrink":  1,
+				"require": map[string]any{"data": "cold"},
+			},
+		},
+		"snapshot": map[string]any{"repository": "s3-backup"},
+	}
+}
+
+func TestLoadSettingsValidation(t *testing.T) {
+	gconfig.Shared.Set("tasks.elasticsearch.mappings.logs", `"mappings": {}`)
+
+	t.Run("valid", func(t *testing.T) {
+		gconfig.Shared.Set("tasks.elasticsearch-v2.configs", []any{
+			map[string]any{"action": "delete"},
+			validIdxConfig(),
+		})
+
+		sts, err := LoadSettings()
+		require.NoError(t, err)
+		require.Len(t, sts, 1)
+
+		st := sts[0]
+		require.Equal(t, 90*24*time.Hour, st.Expires)
+		require.Equal(t, defaultNShards, st.NShards)
+		require.Equal(t, defaultNRepls, st.NRepls)
+		require.Len(t, st.Phases, 2)
+		require.Equal(t, 72*time.Hour, st.Phases[0].After)
+		require.Equal(t, 0, *st.Phases[0].Replicas)
+		require.Equal(t, 1, st.Phases[0].ForceMerge)
+		require.Equal(t, map[string]string{"data": "warm"}, st.Phases[0].Require)
+		require.Nil(t, st.Phases[1].Replicas)
+		require.Equal(t, 1, st.Phases[1].Shrink)
+		require.Equal(t, "s3-backup", st.Snapshot.Repository)
+		require.Equal(t, defaultSnapshotTimeout, st.Snapshot.Timeout)
+
+		conds, err := st.Conditions()
+		require.NoError(t, err)
+		require.JSONEq(t, `{"max_age": "1d", "max_size": "50gb", "max_docs": 100000000}`, string(conds))
+
+		jb, err := GetIdxRolloverReqBodyByIdxAlias(st)
+		require.NoError(t, err)
+		require.Contains(t, jb.String(), `"conditions": {"max_age":"1d"`)
+	})
+
+	for name, c := range map[string]struct {
+		modify func(cfg map[string]any)
+		errMsg string
+	}{
+		"invalid regexp": {
+			func(cfg map[string]any) { cfg["index"] = "logs-(" },
+			"invalid regexp `index`",
+		},
+		"regexp without date": {
+			func(cfg map[string]any) { cfg["index"] = "logs-.*" },
+			"should capture the date",
+		},
+		"missing expires": {
+			func(cfg map[string]any) { delete(cfg, "expires") },
+			"`expires` should be positive",
+		},
+		"wrong type": {
+			func(cfg map[string]any) { cfg["expires"] = "3 days" },
+			"invalid config `tasks.elasticsearch-v2.configs`",
+		},
+		"no conditions": {
+			func(cfg map[string]any) {
+				delete(cfg, "rollover")
+				delete(cfg, "max-size")
+				delete(cfg, "max-docs")
+			},
+			"at least one of",
+		},
+		"invalid max age": {
+			func(cfg map[string]any) { cfg["rollover"] = "1 day" },
+			"invalid `rollover`",
+		},
+		"invalid max size": {
+			func(cfg map[string]any) { cfg["max-size"] = "50G" },
+			"invalid `max-size`",
+		},
+		"unknown mapping": {
+			func(cfg map[string]any) { cfg["mapping"] = "not-exists" },
+			"`mapping` \"not-exists\" not found",
+		},
+		"unordered phases": {
+			func(cfg map[string]any) {
+				cfg["phases"].([]any)[1].(map[string]any)["after"] = "24h"
+			},
+			"phase \"cold\" should be after phase \"warm\"",
+		},
+		"phase after expires": {
+			func(cfg map[string]any) { cfg["expires"] = 3600 },
+			"should be less than `expires`",
+		},
+		"phase without action": {
+			func(cfg map[string]any) {
+				cfg["phases"] = []any{map[string]any{"name": "warm", "after": "72h"}}
+			},
+			"phase \"warm\" has no action",
+		},
+		"snapshot without repository": {
+			func(cfg map[string]any) { cfg["snapshot"] = map[string]any{"timeout": "1h"} },
+			"`snapshot.repository` is required",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := validIdxConfig()
+			c.modify(cfg)
+			gconfig.Shared.Set("tasks.elasticsearch-v2.configs", []any{cfg})
+
+			_, err := LoadSettings()
+			require.ErrorContains(t, err, c.errMsg)
+		})
+	}
+}
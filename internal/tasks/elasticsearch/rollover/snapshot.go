@@ -0,0 +1,104 @@
+package rollover
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+const (
+	snapshotStateSuccess    = "SUCCESS"
+	snapshotStateInProgress = "IN_PROGRESS"
+)
+
+// errSnapshotInProgress is returned if the snapshot of the index is still
+// running, from an earlier run or another cluster client
+var errSnapshotInProgress = errors.New("snapshot is in progress")
+
+// checkSnapshotRepository make sure the snapshot repository exists and is backed by S3
+func checkSnapshotRepository(ctx context.Context, api, repo string) error {
+	resp := map[string]struct {
+		Type string `json:"type"`
+	}{}
+	if err := esRequest(ctx, http.MethodGet, api+"_snapshot/"+url.PathEscape(repo), nil, &resp); err != nil {
+		return errors.Wrapf(err, "get snapshot repository %q", repo)
+	}
+
+	if typ := resp[repo].Type; typ != "s3" {
+		return errors.Errorf("snapshot repository %q is %q, expect s3", repo, typ)
+	}
+
+	return nil
+}
+
+// snapshotIndex snapshot idx into the repository as snapshot `<idx>`,
+// return nil only if the snapshot is successful, and errSnapshotInProgress
+// if it is still running
+func snapshotIndex(ctx context.Context, api string, sst *SnapshotSetting, idx string) error {
+	ctx, cancel := context.WithTimeout(ctx, sst.Timeout)
+	defer cancel()
+	if gconfig.Shared.GetBool("dry") {
+		log.Logger.Debug("snapshot index", zap.String("index", idx))
+		return nil
+	}
+
+	var (
+		snapURL = api + "_snapshot/" + url.PathEscape(sst.Repository) + "/" + url.PathEscape(strings.ToLower(idx))
+		resp    struct {
+			Snapshot  *snapshotInfo   `json:"snapshot"`
+			Snapshots []*snapshotInfo `json:"snapshots"`
+		}
+	)
+
+	err := esRequest(ctx, http.MethodGet, snapURL, nil, &resp)
+	switch {
+	case err == nil:
+		if len(resp.Snapshots) != 0 {
+			switch resp.Snapshots[0].State {
+			case snapshotStateSuccess:
+				log.Logger.Info("snapshot of index already exists", zap.String("index", idx))
+				return nil
+			case snapshotStateInProgress:
+				// deleting a running snapshot aborts it, wait for the next run
+				return errors.Wrapf(errSnapshotInProgress, "snapshot of index %q", idx)
+			}
+		}
+
+		// remove the failed or partial snapshot to retry
+		if err = esRequest(ctx, http.MethodDelete, snapURL, nil, nil); err != nil {
+			return errors.Wrap(err, "delete unsuccessful snapshot")
+		}
+	case isESStatus(err, http.StatusNotFound):
+	default:
+		return errors.Wrap(err, "get snapshot")
+	}
+
+	log.Logger.Info("snapshot index", zap.String("index", idx), zap.String("repository", sst.Repository))
+	if err = esRequest(ctx, http.MethodPut, snapURL+"?wait_for_completion=true", map[string]any{
+		"indices":              idx,
+		"include_global_state": false,
+	}, &resp); err != nil {
+		return errors.Wrap(err, "create snapshot")
+	}
+	if resp.Snapshot == nil || resp.Snapshot.State != snapshotStateSuccess {
+		state := ""
+		if resp.Snapshot != nil {
+			state = resp.Snapshot.State
+		}
+		return errors.Errorf("snapshot of index %q is %q", idx, state)
+	}
+
+	log.Logger.Info("success snapshot index", zap.String("index", idx))
+	return nil
+}
+
+type snapshotInfo struct {
+	State string `json:"state"`
+}
@@ -1,11 +1,14 @@
 package rollover
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"html/template"
 	"io"
 	"net/http"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/Laisky/errors/v2"
@@ -23,12 +26,18 @@ var (
 	httpClient = http.Client{
 		Timeout: time.Second * 30,
 	}
+	esHTTPClient = &http.Client{}
 )
 
 // IdxSetting is the task settings
 type IdxSetting struct {
-	Regexp        *regexp.Regexp
-	Rollover      string
+	Regexp *regexp.Regexp
+	// Rollover is the max age of the write index, like `7d`
+	Rollover string
+	// MaxSize is the max primary shards size of the write index, like `50gb`
+	MaxSize string
+	// MaxDocs is the max number of documents of the write index
+	MaxDocs       int64
 	Expires       time.Duration
 	IdxAlias      string
 	NRepls        int
@@ -37,6 +46,31 @@ type IdxSetting struct {
 	Mapping       template.HTML
 	API           string
 	IsSkipCreate  bool
+	// Phases are applied to indices in order of their ages
+	Phases []*Phase
+	// Snapshot snapshots indices before deleting them if not nil
+	Snapshot *SnapshotSetting
+}
+
+// Conditions return the rollover conditions in json
+func (st *IdxSetting) Conditions() (template.HTML, error) {
+	conds := map[string]any{}
+	if st.Rollover != "" {
+		conds["max_age"] = st.Rollover
+	}
+	if st.MaxSize != "" {
+		conds["max_size"] = st.MaxSize
+	}
+	if st.MaxDocs > 0 {
+		conds["max_docs"] = st.MaxDocs
+	}
+
+	jb, err := json.Marshal(conds)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal rollover conditions")
+	}
+
+	return template.HTML(jb), nil //nolint: gosec
 }
 
 // BindRolloverIndices bind the task to rollover indices
@@ -65,13 +99,39 @@ func runTask() {
 	}
 
 	for _, st := range taskSts {
-		go RunDeleteTask(ctx, sem, st)
+		goExclusive(ctx, sem, "delete", st, RunDeleteTask)
 		if !st.IsSkipCreate {
-			go RunRolloverTask(ctx, sem, st)
+			goExclusive(ctx, sem, "rollover", st, RunRolloverTask)
+		}
+		if len(st.Phases) != 0 {
+			goExclusive(ctx, sem, "phase", st, RunPhaseTask)
 		}
 	}
 }
 
+// runningTasks holds a *sync.Mutex for each task of each setting,
+// locked while the task runs. Settings are reloaded on every tick,
+// so they are keyed by their api and regexp.
+var runningTasks sync.Map
+
+// goExclusive runs the task of kind for st in background, unless it is
+// still running since an earlier tick.
+func goExclusive(ctx context.Context, sem *semaphore.Weighted, kind string, st *IdxSetting,
+	task func(context.Context, *semaphore.Weighted, *IdxSetting)) {
+	v, _ := runningTasks.LoadOrStore(kind+"|"+st.API+"|"+st.Regexp.String(), new(sync.Mutex))
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		log.Logger.Info("skip task still running since last tick",
+			zap.String("task", kind), zap.String("alias", st.IdxAlias))
+		return
+	}
+
+	go func() {
+		defer mu.Unlock()
+		task(ctx, sem, st)
+	}()
+}
+
 func urlMasking(val string) string {
 	return gutils.URLMasking(val, "*****")
 }
@@ -115,45 +175,64 @@ func LoadAllIndicesNames(api string) (indices []string, err error) {
 	return indices, nil
 }
 
-// LoadSettings load task settings
-func LoadSettings() (idxSettings []*IdxSetting, err error) {
-	cfgi, ok := gconfig.Shared.Get("tasks.elasticsearch-v2.configs").([]interface{})
-	if !ok {
-		return nil, errors.New("invalid config")
-	}
+// esStatusError is returned by esRequest when elasticsearch responds an error status
+type esStatusError struct {
+	Status int
+	Body   string
+}
 
-	for _, itemI := range cfgi {
-		item, ok := itemI.(map[interface{}]interface{})
-		if !ok {
-			return nil, errors.New("invalid config")
-		}
+func (e *esStatusError) Error() string {
+	return fmt.Sprintf("elasticsearch return [%d] %s", e.Status, e.Body)
+}
 
-		action, ok := item["action"].(string)
-		if !ok {
-			return nil, errors.New("invalid config for `action`")
-		}
-		if action != "rollover" {
-			continue
-		}
+// isESStatus check whether err is an elasticsearch response with status
+func isESStatus(err error, status int) bool {
+	var statusErr *esStatusError
+	return errors.As(err, &statusErr) && statusErr.Status == status
+}
 
-		//nolint: forcetypeassert
-		idx := &IdxSetting{
-			Regexp:        regexp.MustCompile(item["index"].(string)),
-			Expires:       time.Duration(item["expires"].(int)) * time.Second,
-			IdxAlias:      item["index-alias"].(string),
-			IdxWriteAlias: item["index-write-alias"].(string),
-			Mapping:       getESMapping(item["mapping"].(string)),
-			API:           item["api"].(string),
-			Rollover:      item["rollover"].(string),
-			NRepls:        gutils.FallBack(func() interface{} { return item["n-replicas"].(int) }, 1).(int),
-			NShards:       gutils.FallBack(func() interface{} { return item["n-shards"].(int) }, 5).(int),
-			IsSkipCreate:  gutils.FallBack(func() interface{} { return item["skip-create"].(bool) }, false).(bool),
+// esRequest requests elasticsearch with json body, and decodes the json response into out.
+//
+// esHTTPClient has no timeout, requests are bounded by ctx.
+func esRequest(ctx context.Context, method, url string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		jb, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "marshal request body")
 		}
-		log.Logger.Debug("load rollover setting",
-			zap.String("action", action),
-			zap.String("index", idx.IdxAlias))
-		idxSettings = append(idxSettings, idx)
+		reqBody = bytes.NewReader(jb)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return errors.Wrapf(err, "new http request for url %v", urlMasking(url))
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := esHTTPClient.Do(req) //nolint: bodyclose
+	if err != nil {
+		return errors.Wrapf(err, "%s %v", method, urlMasking(url))
+	}
+	defer gutils.LogErr(resp.Body.Close, log.Logger) // nolint: errcheck,gosec
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read body for url %v", urlMasking(url))
+	}
+	if resp.StatusCode/100 != 2 {
+		return errors.Wrapf(&esStatusError{Status: resp.StatusCode, Body: string(respBytes)},
+			"%s %v", method, urlMasking(url))
+	}
+
+	if out == nil || len(respBytes) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(respBytes, out); err != nil {
+		return errors.Wrapf(err, "parse json body for url %v: %s", urlMasking(url), string(respBytes))
 	}
 
-	return idxSettings, nil
+	return nil
 }
@@ -1,48 +1,57 @@
 package fluentd
 
 import (
+	"context"
 	"fmt"
 	"sync"
-	"time"
 
+	"github.com/Laisky/errors/v2"
 	gconfig "github.com/Laisky/go-config/v2"
 	"github.com/Laisky/zap"
 
+	"github.com/Laisky/go-ramjet/internal/tasks/status"
 	"github.com/Laisky/go-ramjet/library/alert"
 	"github.com/Laisky/go-ramjet/library/log"
 )
 
+// checkForAlert fires an alert for each unhealthy fluentd server,
+// and resolves it once the server is healthy again
 func checkForAlert(m *sync.Map) (err error) {
-	cnt := ""
+	ctx := context.Background()
+	var errs []error
 	m.Range(func(ki, vi interface{}) bool {
-		if vi.(bool) {
-			return true
+		k := ki.(*MonitorCfg)
+		isAlive := vi.(bool)
+		a := alert.Alert{
+			Source:   "fluentd",
+			Name:     "server_health",
+			Severity: alert.SeverityCritical,
+			Labels:   map[string]string{"server": k.Name},
+			Summary:  "[google]fluentd got problem",
+			Description: fmt.Sprintf("%v(%v) got error\ntestd from: %v",
+				k.Name, k.IP, gconfig.Shared.GetString("host")),
+			To:     "ppcelery@gmail.com",
+			ToName: "Laisky Cai",
 		}
 
-		if cnt == "" {
-			cnt = fmt.Sprintf("[%v]some fluentd server got error:\ntestd from: %v\n",
-				time.Now().Format(time.RFC3339),
-				gconfig.Shared.GetString("host"))
+		var checkErr error
+		if !isAlive {
+			checkErr = errors.Errorf("fluentd %s is not alive", k.Name)
 		}
+		status.Publish("fluentd-"+k.Name, "fl-monitor", 0, checkErr)
 
-		k := ki.(*MonitorCfg)
-		cnt += fmt.Sprintf("%v(%v) got error\n", k.Name, k.IP)
+		if gconfig.Shared.GetBool("dry") {
+			log.Logger.Info("send fluentd alert", zap.Bool("alive", isAlive), zap.String("msg", a.Description))
+			return true
+		}
+
+		if isAlive {
+			errs = append(errs, alert.Manager.Resolve(ctx, a))
+		} else {
+			errs = append(errs, alert.Manager.Fire(ctx, a))
+		}
 		return true
 	})
 
-	if gconfig.Shared.GetBool("dry") {
-		log.Logger.Info("send fluentd alert email", zap.String("msg", cnt))
-		return nil
-	}
-
-	if cnt != "" {
-		err = alert.Manager.Send(
-			"ppcelery@gmail.com",
-			"Laisky Cai",
-			"[google]fluentd got problem",
-			cnt,
-		)
-	}
-
-	return err
+	return errors.Join(errs...)
 }
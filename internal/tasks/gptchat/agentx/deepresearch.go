@@ -0,0 +1,302 @@
+package agentx
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	glog "github.com/Laisky/go-utils/v6/log"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/distiller"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/loop"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/model"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/research"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/session"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tools"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	httppkg "github.com/Laisky/go-ramjet/internal/tasks/gptchat/http"
+	rutils "github.com/Laisky/go-ramjet/library/redis"
+)
+
+func init() {
+	httppkg.RegisterDeepResearchRunner(deepResearchRunner{})
+}
+
+// newDeepResearchStore builds the task store. A package variable so tests
+// can swap in research.NewMemoryStore without a Redis server.
+var newDeepResearchStore = func(ttl time.Duration) (research.Store, error) {
+	return research.NewRedisStore(rutils.GetCli().GetDB().Client, ttl)
+}
+
+// deepResearchPDFRenderer renders PDF exports. Swapped in tests.
+var deepResearchPDFRenderer research.PDFRenderer = research.ChromePDFRenderer{}
+
+// ErrTooManyDeepResearchTasks is returned when the user already runs as
+// many tasks as AgentLoopDeepResearchConfig.MaxTasksPerUser allows.
+var ErrTooManyDeepResearchTasks = errors.New("too many running deep research tasks, " +
+	"wait for one to finish")
+
+// defaultDeepResearchTasksPerUser is used when the config leaves
+// MaxTasksPerUser unset.
+const defaultDeepResearchTasksPerUser = 2
+
+// deepResearchSlots counts the running tasks of each user on this instance.
+type deepResearchSlots struct {
+	mu      sync.Mutex
+	running map[string]int
+}
+
+var runningDeepResearch = &deepResearchSlots{running: map[string]int{}}
+
+// acquire takes a slot for username, returning false when it already has
+// limit tasks running.
+func (s *deepResearchSlots) acquire(username string, limit int) bool {
+	if limit <= 0 {
+		limit = defaultDeepResearchTasksPerUser
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[username] >= limit {
+		return false
+	}
+	s.running[username]++
+	return true
+}
+
+// release returns a slot taken by acquire.
+func (s *deepResearchSlots) release(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[username] <= 1 {
+		delete(s.running, username)
+		return
+	}
+	s.running[username]--
+}
+
+// deepResearchRunner implements httppkg.DeepResearchRunner on top of
+// research.Pipeline.
+type deepResearchRunner struct{}
+
+// Create validates the request, persists a pending task and runs the
+// pipeline in the background. The run is detached from the HTTP request:
+// the client polls the status endpoint instead.
+//
+// Each run holds one of the user's slots until it ends, so a user can not
+// pile up long running tasks.
+func (deepResearchRunner) Create(gctx *gin.Context, user *config.UserConfig, prompt string) (string, error) {
+	cfg := agentConfigOrNil()
+	if cfg == nil || !cfg.Enabled || !cfg.DeepResearch.Enabled {
+		return "", ErrAgentLoopDisabled
+	}
+	drCfg := cfg.DeepResearch
+	if err := httppkg.IsModelAllowed(gctx, user, &httppkg.FrontendReq{Model: drCfg.Model}); err != nil {
+		return "", errors.Wrapf(err, "model %q not allowed", drCfg.Model)
+	}
+
+	if !runningDeepResearch.acquire(user.UserName, drCfg.MaxTasksPerUser) {
+		return "", errors.WithStack(ErrTooManyDeepResearchTasks)
+	}
+	started := false
+	defer func() {
+		if !started {
+			runningDeepResearch.release(user.UserName)
+		}
+	}()
+
+	store, err := newDeepResearchStore(time.Duration(drCfg.TaskTTLSeconds) * time.Second)
+	if err != nil {
+		return "", errors.Wrap(err, "new deep research store")
+	}
+
+	task := research.NewTask("dr-"+uuid.NewString(), user.UserName, prompt, drCfg.Model)
+	if err := store.Save(gmw.Ctx(gctx), task); err != nil {
+		return "", errors.Wrap(err, "save deep research task")
+	}
+
+	logger := gmw.GetLogger(gctx).Named("deepresearch").With(zap.String("task_id", task.TaskID))
+	// The gin request is recycled once the handler returns, so everything
+	// the background run needs is copied out here.
+	deps := deepResearchDeps{
+		cfg:          cfg,
+		user:         user,
+		header:       gctx.Request.Header.Clone(),
+		rawQuery:     requestRawQuery(gctx),
+		rawUserToken: httppkg.GetRawUserToken(gctx),
+		store:        store,
+		logger:       logger,
+	}
+	started = true
+	go func() {
+		defer runningDeepResearch.release(user.UserName)
+		ctx, cancel := context.WithTimeout(context.Background(),
+			time.Duration(drCfg.WallClockSeconds)*time.Second)
+		defer cancel()
+		if err := runDeepResearch(ctx, deps, task); err != nil {
+			logger.Warn("deep research task failed", zap.Error(err))
+			return
+		}
+		logger.Info("deep research task finished")
+	}()
+
+	return task.TaskID, nil
+}
+
+// Status returns the persisted task. Tasks owned by other users are
+// reported as not found so task ids cannot be probed.
+func (deepResearchRunner) Status(gctx *gin.Context, user *config.UserConfig, taskID string) (any, error) {
+	return loadOwnedDeepResearchTask(gctx, user, taskID)
+}
+
+// Export renders the finished report.
+func (deepResearchRunner) Export(gctx *gin.Context, user *config.UserConfig, taskID, format string) (
+	body []byte, contentType, filename string, err error) {
+	task, err := loadOwnedDeepResearchTask(gctx, user, taskID)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if task.Status != research.TaskStatusSuccess || task.Report == nil {
+		return nil, "", "", errors.Errorf("task %q is %s, report not ready", taskID, task.Status)
+	}
+
+	body, contentType, filename, err = research.Export(gmw.Ctx(gctx), task.Report, format, deepResearchPDFRenderer)
+	if err != nil {
+		return nil, "", "", errors.WithStack(err)
+	}
+	return body, contentType, filename, nil
+}
+
+// loadOwnedDeepResearchTask loads taskID and checks it belongs to user.
+// Misses are mapped to httppkg.ErrDeepResearchTaskNotFound so the HTTP
+// layer can fall back to the legacy queue.
+func loadOwnedDeepResearchTask(gctx *gin.Context, user *config.UserConfig, taskID string) (*research.Task, error) {
+	ttl := research.DefaultTaskTTL
+	if cfg := agentConfigOrNil(); cfg != nil && cfg.DeepResearch.TaskTTLSeconds > 0 {
+		ttl = time.Duration(cfg.DeepResearch.TaskTTLSeconds) * time.Second
+	}
+	store, err := newDeepResearchStore(ttl)
+	if err != nil {
+		return nil, errors.Wrap(err, "new deep research store")
+	}
+
+	task, err := store.Load(gmw.Ctx(gctx), taskID)
+	if errors.Is(err, research.ErrTaskNotFound) {
+		return nil, errors.WithStack(httppkg.ErrDeepResearchTaskNotFound)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if task.UserName != user.UserName {
+		return nil, errors.WithStack(httppkg.ErrDeepResearchTaskNotFound)
+	}
+	return task, nil
+}
+
+// deepResearchDeps is the request-scoped state copied out of the gin
+// context for the background run.
+type deepResearchDeps struct {
+	cfg          *config.AgentLoopConfig
+	user         *config.UserConfig
+	header       http.Header
+	rawQuery     string
+	rawUserToken string
+	store        research.Store
+	logger       glog.Logger
+	// modelClient and registry override the OneAPI client and the curated
+	// belt in tests.
+	modelClient model.Client
+	registry    tool.Registry
+}
+
+// runDeepResearch builds the model client, the research belt and the
+// per-researcher hook chain, then runs the pipeline to completion.
+func runDeepResearch(ctx context.Context, deps deepResearchDeps, task *research.Task) error {
+	drCfg := deps.cfg.DeepResearch
+
+	modelClient := deps.modelClient
+	if modelClient == nil {
+		modelClient = model.NewOneAPIClient(model.OneAPIDeps{
+			UpstreamDeps: httppkg.UpstreamDeps{
+				User:          deps.user,
+				Logger:        deps.logger,
+				RequestHeader: deps.header,
+				RawQuery:      deps.rawQuery,
+			},
+			Logger: deps.logger,
+		})
+	}
+	modelClient = newCoercingModelClient(modelClient)
+
+	registry := deps.registry
+	if registry == nil {
+		curatedServer := resolveCuratedMCP(deps.cfg, deps.user)
+		frontendReq := forceMCPEnabledWithCuratedServer(&httppkg.FrontendReq{Model: drCfg.Model}, curatedServer)
+		built, err := tools.BuildCuratedBelt(ctx, tools.BeltDeps{
+			Logger:    deps.logger,
+			MCPServer: curatedServer,
+			DepsProvider: tools.LegacyDepsFunc(func(context.Context, string, string) (httppkg.LegacyDeps, error) {
+				return httppkg.LegacyDeps{
+					User:         deps.user,
+					FrontendReq:  frontendReq,
+					RawUserToken: deps.rawUserToken,
+					Logger:       deps.logger,
+				}, nil
+			}),
+			FallbackBelt: research.DefaultResearchTools,
+		})
+		if err != nil {
+			return errors.Wrap(err, "build research belt")
+		}
+		populateCuratedServerTools(curatedServer, built)
+		registry = built
+	}
+
+	distillerModelID := strings.TrimSpace(deps.cfg.DistillerModel)
+	if distillerModelID == "" {
+		distillerModelID = drCfg.Model
+	}
+	llmDistiller := distiller.NewLLMDistiller(modelClient, distillerModelID, distiller.NewCache())
+	if secs := deps.cfg.DistillTimeoutSeconds; secs > 0 {
+		llmDistiller.Timeout = time.Duration(secs) * time.Second
+	}
+	distillThreshold := deps.cfg.DistillThresholdTokens
+	if distillThreshold <= 0 {
+		distillThreshold = distiller.DefaultThresholdTokens
+	}
+	caps := capsFromConfig(deps.cfg)
+
+	pipeline := &research.Pipeline{
+		Model:           modelClient,
+		Registry:        registry,
+		ModelID:         drCfg.Model,
+		Caps:            caps,
+		MaxSubQuestions: drCfg.MaxSubQuestions,
+		MaxParallel:     drCfg.MaxParallel,
+		Store:           deps.store,
+		Logger:          deps.logger,
+		// Researchers only read the web, so the write gate and memory
+		// hooks of the chat path are left out.
+		NewBus: func() *hook.Bus {
+			bus := hook.NewBus(deps.logger)
+			bus.OnBeforeToolCall(loop.NewCircuitHook(caps.CircuitBreakerRepeats))
+			bus.OnAfterToolCall(toolScrubHook())
+			bus.OnAfterToolCall(injectionScanHook(deps.cfg, modelClient,
+				loop.InjectionScanOptions{Logger: deps.logger}))
+			bus.OnAfterToolCall(loop.NewDistillHook(llmDistiller, distillThreshold,
+				session.NewRawStash(), task.Prompt))
+			bus.OnAfterToolCall(loop.NewWrapHook())
+			return bus
+		},
+	}
+	return pipeline.Run(ctx, task)
+}
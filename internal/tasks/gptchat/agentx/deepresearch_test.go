@@ -0,0 +1,131 @@
+package agentx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	glog "github.com/Laisky/go-utils/v6/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/model"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/research"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tools"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	httppkg "github.com/Laisky/go-ramjet/internal/tasks/gptchat/http"
+)
+
+// installMemoryDeepResearchStore swaps the Redis store for a shared
+// in-memory one for the duration of the test.
+func installMemoryDeepResearchStore(t *testing.T) *research.MemoryStore {
+	t.Helper()
+	store := research.NewMemoryStore()
+	original := newDeepResearchStore
+	newDeepResearchStore = func(time.Duration) (research.Store, error) { return store, nil }
+	t.Cleanup(func() { newDeepResearchStore = original })
+	return store
+}
+
+func TestDeepResearch_RunStatusExport(t *testing.T) {
+	cfg := defaultAgentCfg()
+	cfg.DeepResearch = config.AgentLoopDeepResearchConfig{
+		Enabled:          true,
+		Model:            "test-model",
+		MaxSubQuestions:  1,
+		MaxParallel:      1,
+		WallClockSeconds: 30,
+	}
+	setupTestConfig(t, cfg)
+	store := installMemoryDeepResearchStore(t)
+
+	// planner → researcher (send_to_user) → writer, strictly sequential
+	// because there is a single sub-question.
+	client := newFakeModelClient([][]model.StreamChunk{
+		{{Kind: model.ChunkText, Text: `["what is go?"]`}, {Kind: model.ChunkDone}},
+		{{Kind: model.ChunkFunction, FunctionCall: &model.FunctionCall{
+			CallID: "send-1",
+			Name:   tools.SendToUserName,
+			Arguments: rawArgs(t, map[string]any{
+				"final_answer": "a language",
+				"citations":    []map[string]string{{"url": "https://go.dev", "title": "Go"}},
+			}),
+		}}, {Kind: model.ChunkDone}},
+		{{Kind: model.ChunkText, Text: "Go is a language [1]."}, {Kind: model.ChunkDone}},
+	})
+
+	logger, err := glog.NewConsoleWithName("test_deepresearch", glog.LevelError)
+	require.NoError(t, err)
+	task := research.NewTask("dr-test", "tester", "Tell me about Go", "test-model")
+	require.NoError(t, runDeepResearch(context.Background(), deepResearchDeps{
+		cfg:         cfg,
+		store:       store,
+		logger:      logger,
+		modelClient: client,
+		registry:    buildRegistry(t),
+	}, task))
+
+	gctx, _, user := newTestGinCtx(t, "")
+	runner := deepResearchRunner{}
+
+	status, err := runner.Status(gctx, user, "dr-test")
+	require.NoError(t, err)
+	saved, ok := status.(*research.Task)
+	require.True(t, ok)
+	require.Equal(t, research.TaskStatusSuccess, saved.Status)
+
+	body, contentType, _, err := runner.Export(gctx, user, "dr-test", "md")
+	require.NoError(t, err)
+	require.Equal(t, "text/markdown; charset=utf-8", contentType)
+	require.Contains(t, string(body), "Go is a language [1].")
+	require.Contains(t, string(body), "1. [Go](https://go.dev)")
+
+	stranger := *user
+	stranger.UserName = "someone-else"
+	_, err = runner.Status(gctx, &stranger, "dr-test")
+	require.ErrorIs(t, err, httppkg.ErrDeepResearchTaskNotFound)
+	_, err = runner.Status(gctx, user, "dr-missing")
+	require.ErrorIs(t, err, httppkg.ErrDeepResearchTaskNotFound)
+}
+
+func TestDeepResearch_ExportRejectsUnfinishedTask(t *testing.T) {
+	setupTestConfig(t, defaultAgentCfg())
+	store := installMemoryDeepResearchStore(t)
+	require.NoError(t, store.Save(context.Background(),
+		research.NewTask("dr-pending", "tester", "q", "test-model")))
+
+	gctx, _, user := newTestGinCtx(t, "")
+	_, _, _, err := deepResearchRunner{}.Export(gctx, user, "dr-pending", "md")
+	require.ErrorContains(t, err, "report not ready")
+}
+
+func TestDeepResearchSlots(t *testing.T) {
+	slots := &deepResearchSlots{running: map[string]int{}}
+	require.True(t, slots.acquire("alice", 2))
+	require.True(t, slots.acquire("alice", 2))
+	require.False(t, slots.acquire("alice", 2))
+	require.True(t, slots.acquire("bob", 2), "slots are per user")
+
+	slots.release("alice")
+	require.True(t, slots.acquire("alice", 2))
+
+	slots.release("bob")
+	require.NotContains(t, slots.running, "bob")
+}
+
+func TestDeepResearch_CreateRejectsBusyUser(t *testing.T) {
+	cfg := defaultAgentCfg()
+	cfg.DeepResearch = config.AgentLoopDeepResearchConfig{
+		Enabled:         true,
+		Model:           "test-model",
+		MaxTasksPerUser: 1,
+	}
+	setupTestConfig(t, cfg)
+	installMemoryDeepResearchStore(t)
+
+	gctx, _, user := newTestGinCtx(t, "")
+	require.True(t, runningDeepResearch.acquire(user.UserName, 1))
+	t.Cleanup(func() { runningDeepResearch.release(user.UserName) })
+
+	_, err := deepResearchRunner{}.Create(gctx, user, "Tell me about Go")
+	require.ErrorIs(t, err, ErrTooManyDeepResearchTasks)
+}
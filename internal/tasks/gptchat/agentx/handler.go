@@ -11,6 +11,7 @@
 //   - agentx/tools    — Concrete tool wrappers and the curated belt.
 //   - agentx/sse      — Session.Event → SSE chunk adapter.
 //   - agentx/prompt   — Versioned system prompt renderer.
+//   - agentx/research — Native deep-research pipeline (planner, researchers, writer).
 package agentx
 
 import (
@@ -27,6 +28,7 @@ import (
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/distiller"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/injection"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/loop"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/model"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/prompt"
@@ -203,7 +205,7 @@ func handleAgentWithDeps(
 	//    pointer is captured by the closure; we populate Tools after
 	//    BuildCuratedBelt returns (the registry now knows which curated
 	//    names the upstream MCP server actually advertised).
-	curatedServer := resolveCuratedMCP(inputs.AgentCfg)
+	curatedServer := resolveCuratedMCP(inputs.AgentCfg, inputs.User)
 	depsProvider := tools.LegacyDepsFunc(func(ctx context.Context, _ string, _ string) (httppkg.LegacyDeps, error) {
 		return httppkg.LegacyDeps{
 			User:         inputs.User,
@@ -224,6 +226,9 @@ func handleAgentWithDeps(
 			SubagentEnabled:  inputs.AgentCfg.Subagent.Enabled,
 			SubagentMaxDepth: inputs.AgentCfg.Subagent.MaxDepth,
 			FallbackBelt:     []string{"web_search", "web_fetch", "file_read"},
+			UploadedFileReader: func(ctx context.Context, fileURL string) (string, error) {
+				return httppkg.ReadUploadedFile(ctx, inputs.User, fileURL)
+			},
 		})
 		if regErr != nil {
 			return errors.Wrap(regErr, "build curated belt")
@@ -288,6 +293,11 @@ func handleAgentWithDeps(
 	// 6. Hook bus. Registration order is the firing order (verified by
 	//    hook U21); ordering here is load-bearing.
 	caps := capsFromConfig(inputs.AgentCfg)
+	// The session is created before the bus so the injection scanner
+	// can emit security events into it.
+	sess := session.NewSession(session.Config{Logger: logger, BufferSize: 256})
+	securitySink, _ := sess.(session.EventSink)
+	writeGate := &loop.WriteGateEscalation{}
 	bus := hook.NewBus(logger)
 	if !override.DisableDefaults {
 		// Prompt comes BEFORE memory so the memory hook sees the
@@ -297,10 +307,19 @@ func handleAgentWithDeps(
 		bus.OnContext(prompt.NewReactRenderer(caps.MaxIterations).AsContextHook())
 		bus.OnContext(tools.NewMemoryBeforeTurnHook(memDeps))
 		bus.OnBeforeToolCall(loop.NewCircuitHook(caps.CircuitBreakerRepeats))
-		bus.OnBeforeToolCall(loop.NewWriteGateHook(inputs.AgentCfg.WriteGate))
+		bus.OnBeforeToolCall(loop.NewEscalatingWriteGateHook(inputs.AgentCfg.WriteGate, writeGate))
+		// Scrub BEFORE Distill so PII never reaches the summariser.
+		// The injection scan also runs BEFORE Distill, so it sees the
+		// raw wording rather than a summary of it.
 		// Distill BEFORE Wrap: the trust-delimiter encloses the
 		// summarised observation, not the raw bytes. See
 		// loop/distill.go godoc for the rationale.
+		bus.OnAfterToolCall(toolScrubHook())
+		bus.OnAfterToolCall(injectionScanHook(inputs.AgentCfg, modelClient, loop.InjectionScanOptions{
+			Sink:   securitySink,
+			Gate:   writeGate,
+			Logger: logger,
+		}))
 		bus.OnAfterToolCall(loop.NewDistillHook(llmDistiller, distillThreshold, rawStash, userPrompt))
 		bus.OnAfterToolCall(loop.NewWrapHook())
 		bus.OnSessionEnd(tools.NewMemoryAfterTurnHook(memDeps))
@@ -309,8 +328,7 @@ func handleAgentWithDeps(
 		override.PreRegister(bus)
 	}
 
-	// 7. Session, SSE writer, and the consumer goroutine.
-	sess := session.NewSession(session.Config{Logger: logger, BufferSize: 256})
+	// 7. SSE writer and the consumer goroutine.
 	// Capture the events channel BEFORE spawning the consumer. The
 	// session's Close() nils primary; the goroutine must dereference
 	// once and hold the channel across the lifecycle.
@@ -382,6 +400,8 @@ func handleAgentWithDeps(
 		logger.Warn("agent_sse_consumer_error", zap.Error(consumerErr))
 	}
 
+	recordAgentUsage(gctx, sess.Transcript())
+
 	// 10. Surface the loop's error verbatim. Loop terminations (any
 	//     TerminatedBy enum) return nil; only setup/transport failures
 	//     bubble up here.
@@ -391,6 +411,33 @@ func handleAgentWithDeps(
 	return nil
 }
 
+// recordAgentUsage reports the tokens of every model round of the run to
+// usage analytics. Upstreams that report no usage are estimated from the
+// final answer.
+func recordAgentUsage(gctx *gin.Context, tr session.Transcript) {
+	if tr == nil {
+		return
+	}
+
+	var (
+		usage     session.TotalUsage
+		finalText string
+	)
+	for _, ev := range tr.Events() {
+		switch e := ev.(type) {
+		case session.Final:
+			finalText = e.FinalText
+		case session.RunFinished:
+			usage = e.TotalUsage
+		}
+	}
+	if usage.TokensOut == 0 && finalText != "" {
+		usage.TokensOut = httppkg.CountTextTokens(finalText)
+	}
+
+	httppkg.RecordAgentUsage(gctx, usage.TokensIn, usage.TokensOut, usage.ReasoningTokens, usage.ToolCalls)
+}
+
 // setAgentStreamHeaders writes the SSE response headers and request-id
 // echo before the first chunk. Mirrors the proxy path's setStreamHeaders
 // behaviour so the frontend sees an identical wire-level handshake.
@@ -542,11 +589,18 @@ func quoteJSONString(s string) string {
 // `Config.MemoryStorageMCPURL`, which today happens to be the laisky
 // MCP host. The fallback exists so the operator does not have to
 // duplicate the same URL in two places.
-func resolveCuratedMCP(cfg *config.AgentLoopConfig) *httppkg.MCPServerConfig {
+//
+// Members of an organization with its own `mcp_server` use that one.
+func resolveCuratedMCP(cfg *config.AgentLoopConfig, user *config.UserConfig) *httppkg.MCPServerConfig {
 	if cfg == nil {
 		return nil
 	}
 	raw := strings.TrimSpace(cfg.MCPServer)
+	if user != nil && config.Config != nil {
+		if org := config.Config.Organization(user.OrgID); org != nil && org.MCPServer != "" {
+			raw = org.MCPServer
+		}
+	}
 	if raw == "" {
 		if config.Config != nil {
 			raw = strings.TrimSpace(config.Config.MemoryStorageMCPURL)
@@ -570,6 +624,43 @@ func resolveCuratedMCP(cfg *config.AgentLoopConfig) *httppkg.MCPServerConfig {
 	}
 }
 
+// toolScrubHook masks PII in tool results before the distiller or the
+// model sees them, when `openai.moderation.scrub_tool_results` is on.
+// It returns nil otherwise, which the bus ignores.
+func toolScrubHook() func(context.Context, hook.ToolCallEvent) (hook.ToolCallEvent, error) {
+	moderator := httppkg.ModerationPipeline()
+	if !moderator.ScrubToolResults() {
+		return nil
+	}
+	return loop.NewScrubHook(moderator.Scrub)
+}
+
+// injectionScanHook returns the prompt-injection scan hook when
+// `agent_loop.injection_scan.enabled` is on, nil otherwise. The score
+// thresholds in opts are filled from cfg.
+func injectionScanHook(cfg *config.AgentLoopConfig, client model.Client,
+	opts loop.InjectionScanOptions) func(context.Context, hook.ToolCallEvent) (hook.ToolCallEvent, error) {
+	scanCfg := cfg.InjectionScan
+	if !scanCfg.Enabled {
+		return nil
+	}
+
+	var classifier injection.Classifier
+	if scanCfg.ClassifierModel != "" {
+		llm := injection.NewLLMClassifier(client, scanCfg.ClassifierModel)
+		if scanCfg.ClassifierTimeoutSeconds > 0 {
+			llm.Timeout = time.Duration(scanCfg.ClassifierTimeoutSeconds) * time.Second
+		}
+		classifier = llm
+	}
+	scanner := injection.NewScanner(classifier)
+	scanner.ClassifyMinScore = scanCfg.ClassifyMinScore
+
+	opts.SuspiciousScore = scanCfg.SuspiciousScore
+	opts.HighScore = scanCfg.HighScore
+	return loop.NewInjectionScanHook(scanner, opts)
+}
+
 // capsFromConfig snapshots the runtime Caps from the YAML knobs. Zero
 // values land on loop.DefaultCaps via loop.Caps.withDefaults() — but
 // we feed the config values explicitly so unit tests can override.
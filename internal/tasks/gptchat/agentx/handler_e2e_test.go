@@ -409,7 +409,7 @@ func TestE2_CuratedMCPServerReachesLegacyDispatcher(t *testing.T) {
 	// handleAgentWithDeps — when the underlying production behaviour
 	// changes, this test compiles against the same surface and breaks
 	// loudly.
-	curatedServer := resolveCuratedMCP(cfg)
+	curatedServer := resolveCuratedMCP(cfg, nil)
 	require.NotNil(t, curatedServer)
 	require.Equal(t, "https://test-mcp.example.com", curatedServer.URL)
 	depsProvider := tools.LegacyDepsFunc(func(_ context.Context, _, _ string) (httppkg.LegacyDeps, error) {
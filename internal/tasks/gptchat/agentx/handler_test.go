@@ -18,6 +18,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/loop"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/model"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/session"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
@@ -809,3 +810,29 @@ func newTestLogger(t *testing.T) glog.Logger {
 	require.NoError(t, err)
 	return l
 }
+
+// TestInjectionScanHook_ClassifierOnly pins that the configured classifier
+// sees results no heuristic rule flags, so the classifier-only quarantine
+// is reachable.
+func TestInjectionScanHook_ClassifierOnly(t *testing.T) {
+	cfg := &config.AgentLoopConfig{InjectionScan: config.AgentLoopInjectionScanConfig{
+		Enabled:         true,
+		SuspiciousScore: 0.4,
+		HighScore:       0.8,
+		ClassifierModel: "classifier",
+	}}
+	client := newFakeModelClient([][]model.StreamChunk{{
+		{Kind: model.ChunkText, Text: "0.95"},
+		{Kind: model.ChunkDone},
+	}})
+
+	scan := injectionScanHook(cfg, client, loop.InjectionScanOptions{})
+	require.NotNil(t, scan)
+	ev, err := scan(context.Background(), hook.ToolCallEvent{
+		ToolName: "web_fetch",
+		Result:   &tool.Result{Content: "a page with a politely phrased injection no rule knows"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, client.callCount())
+	require.Contains(t, ev.Result.Content, "whole tool output was withheld")
+}
@@ -0,0 +1,193 @@
+// Package injection scores untrusted text — tool results, fetched web
+// pages, MCP payloads — for prompt-injection attempts. The agent loop
+// wires it through loop.NewInjectionScanHook; the package itself knows
+// nothing about hooks or sessions so it stays testable in isolation.
+//
+// Scoring is two-tier:
+//
+//   - Heuristic rules: cheap regexps over well-known injection phrasings
+//     (instruction overrides, fake role delimiters, exfiltration asks).
+//     Each rule carries a weight; matched rules combine as independent
+//     evidence, score = 1 - Π(1 - weight).
+//   - Classifier (optional): a model asked to score the text. It only
+//     runs once the heuristic score reaches ClassifyMinScore, and the
+//     final score is the max of the two.
+package injection
+
+import (
+	"context"
+	"regexp"
+	"slices"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// Rule is one heuristic signal.
+type Rule struct {
+	// Name identifies the rule in spans, reports and security events.
+	Name string
+	// Pattern matches the suspicious span.
+	Pattern *regexp.Regexp
+	// Weight in (0, 1] is the score a match alone contributes.
+	Weight float64
+}
+
+// Span is a byte range of the scanned text matched by a rule.
+type Span struct {
+	Rule       string
+	Start, End int
+}
+
+// Report is the outcome of one Scan.
+type Report struct {
+	// Score in [0, 1] is the combined heuristic + classifier score.
+	Score float64
+	// HeuristicScore is the rules-only score.
+	HeuristicScore float64
+	// ClassifierScore is the classifier's score, zero when it did not run.
+	ClassifierScore float64
+	// Rules lists the matched rule names, in rule order.
+	Rules []string
+	// Spans lists the matched spans, ordered by Start, non-overlapping.
+	Spans []Span
+}
+
+// Classifier scores text for prompt injection, 0 benign to 1 certain.
+type Classifier interface {
+	Classify(ctx context.Context, text string) (float64, error)
+}
+
+// Scanner scores text. The zero value scans with no rules; construct
+// one with NewScanner for the defaults.
+type Scanner struct {
+	// Rules are the heuristic signals.
+	Rules []Rule
+	// Classifier is optional.
+	Classifier Classifier
+	// ClassifyMinScore is the heuristic score at which the classifier is
+	// consulted. Zero consults it for every text.
+	ClassifyMinScore float64
+}
+
+// NewScanner returns a scanner with DefaultRules. classifier may be nil.
+func NewScanner(classifier Classifier) *Scanner {
+	return &Scanner{Rules: DefaultRules(), Classifier: classifier}
+}
+
+// Scan scores text. A classifier failure still returns the heuristic
+// report, alongside the error, so callers can fail open.
+func (s *Scanner) Scan(ctx context.Context, text string) (Report, error) {
+	var report Report
+	if s == nil || text == "" {
+		return report, nil
+	}
+
+	benign := 1.0
+	for _, r := range s.Rules {
+		locs := r.Pattern.FindAllStringIndex(text, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		benign *= 1 - r.Weight
+		report.Rules = append(report.Rules, r.Name)
+		for _, loc := range locs {
+			report.Spans = append(report.Spans, Span{Rule: r.Name, Start: loc[0], End: loc[1]})
+		}
+	}
+	report.HeuristicScore = 1 - benign
+	report.Score = report.HeuristicScore
+	report.Spans = mergeSpans(report.Spans)
+
+	if s.Classifier == nil || report.HeuristicScore < s.ClassifyMinScore {
+		return report, nil
+	}
+	score, err := s.Classifier.Classify(ctx, text)
+	if err != nil {
+		return report, errors.Wrap(err, "classify")
+	}
+	report.ClassifierScore = min(max(score, 0), 1)
+	report.Score = max(report.Score, report.ClassifierScore)
+	return report, nil
+}
+
+// mergeSpans orders spans and folds overlapping ones into the earliest.
+func mergeSpans(spans []Span) []Span {
+	if len(spans) < 2 {
+		return spans
+	}
+	slices.SortFunc(spans, func(a, b Span) int {
+		if a.Start != b.Start {
+			return a.Start - b.Start
+		}
+		return b.End - a.End
+	})
+
+	merged := spans[:1]
+	for _, sp := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if sp.Start < last.End {
+			last.End = max(last.End, sp.End)
+			continue
+		}
+		merged = append(merged, sp)
+	}
+	return merged
+}
+
+// DefaultRules returns the built-in heuristic rules. The weights are
+// tuned so one strong signal (an explicit instruction override) lands in
+// the suspicious band on its own, and two signals cross into the high
+// band.
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Name: "instruction_override",
+			Pattern: regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override|bypass)\b[^.\n]{0,40}?` +
+				`\b(?:previous|prior|above|earlier|preceding|all|any|system|your|the)\b[^.\n]{0,20}?` +
+				`\b(?:instructions?|prompts?|rules|directives|guidelines|guardrails)\b`),
+			Weight: 0.6,
+		},
+		{
+			Name: "role_hijack",
+			Pattern: regexp.MustCompile(`(?i)\byou are now\b|\bfrom now on,? you\b|` +
+				`\bnew (?:system )?instructions?\s*:|\b(?:developer|god|dan|jailbreak) mode\b`),
+			Weight: 0.5,
+		},
+		{
+			Name: "fake_delimiter",
+			Pattern: regexp.MustCompile(`(?im)</?(?:system|assistant|tool_result|instructions?)>|` +
+				`<\|im_(?:start|end)\|>|\[/?INST\]|^\s*(?:system|assistant)\s*:`),
+			Weight: 0.5,
+		},
+		{
+			Name: "prompt_exfiltration",
+			Pattern: regexp.MustCompile(`(?i)\b(?:reveal|print|output|repeat|show|leak)\b[^.\n]{0,30}?` +
+				`\b(?:system prompt|hidden instructions|your instructions|initial prompt)`),
+			Weight: 0.5,
+		},
+		{
+			Name: "data_exfiltration",
+			Pattern: regexp.MustCompile(`(?i)\b(?:send|post|upload|forward|exfiltrate|email)\b[^.\n]{0,60}?` +
+				`\b(?:api[ _-]?keys?|tokens?|passwords?|credentials|secrets?|conversation|chat history)\b|` +
+				`!\[[^\]]*\]\(https?://[^)\s]*\?[^)\s]*=`),
+			Weight: 0.5,
+		},
+		{
+			Name: "tool_coercion",
+			Pattern: regexp.MustCompile(`(?i)\b(?:call|invoke|use|run|execute)\b[^.\n]{0,20}?` +
+				`\b(?:file_write|file_delete|file_rename|send_to_user)\b`),
+			Weight: 0.5,
+		},
+		{
+			Name: "concealment",
+			Pattern: regexp.MustCompile(`(?i)\b(?:do not|don't|never)\b[^.\n]{0,20}?` +
+				`\b(?:tell|inform|mention|alert|notify)\b[^.\n]{0,20}?\bthe user\b`),
+			Weight: 0.4,
+		},
+		{
+			Name:    "hidden_text",
+			Pattern: regexp.MustCompile(`[\x{200B}-\x{200F}\x{2060}-\x{2064}\x{FEFF}]{3,}`),
+			Weight:  0.3,
+		},
+	}
+}
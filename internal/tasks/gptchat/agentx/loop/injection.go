@@ -0,0 +1,162 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	glog "github.com/Laisky/go-utils/v6/log"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/injection"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/session"
+)
+
+// Default injection-scan thresholds. One strong heuristic signal lands in
+// the suspicious band; two, or a confident classifier, reach high.
+const (
+	DefaultInjectionSuspiciousScore = 0.4
+	DefaultInjectionHighScore       = 0.8
+)
+
+// untrustedSpanClose is the literal close tag of a flagged span. Literal
+// occurrences inside the tool output are escaped, like closeTagLiteral in
+// wrap.go, so a hostile page cannot end the span early.
+const (
+	untrustedSpanOpen   = "<untrusted_span "
+	untrustedSpanClose  = "</untrusted_span>"
+	untrustedSpanEscape = "<untrusted_span_close/>"
+)
+
+// InjectionScanOptions configures NewInjectionScanHook.
+type InjectionScanOptions struct {
+	// SuspiciousScore is the score at which flagged spans are wrapped in
+	// <untrusted_span> delimiters. Default DefaultInjectionSuspiciousScore.
+	SuspiciousScore float64
+	// HighScore is the score at which flagged spans are quarantined and
+	// the write-gate is raised. Default DefaultInjectionHighScore.
+	HighScore float64
+	// Sink receives a session.Security event per flagged result. Nil
+	// tolerated.
+	Sink session.EventSink
+	// Gate is raised on a high score. Nil tolerated.
+	Gate *WriteGateEscalation
+	// Logger records classifier failures. Nil tolerated.
+	Logger glog.Logger
+}
+
+// NewInjectionScanHook returns an OnAfterToolCall hook that scores tool
+// output with scanner and defuses likely prompt injections before the
+// model reads them:
+//
+//   - score < SuspiciousScore: pass-through.
+//   - score < HighScore: each flagged span is wrapped in
+//     <untrusted_span reason="rule">…</untrusted_span> so the model can
+//     see it was flagged.
+//   - score ≥ HighScore: flagged spans are replaced with a quarantine
+//     notice, or the whole output is withheld when only the classifier
+//     fired, and the write-gate is raised to ask for the rest of the run.
+//
+// Flagged results emit a session.Security event. Register it after the
+// scrub hook and before Distill, so the summariser never paraphrases an
+// injection into trusted-looking prose. Classifier failures fail open to
+// the heuristic score. A nil scanner yields a nil hook.
+func NewInjectionScanHook(scanner *injection.Scanner, opts InjectionScanOptions) func(context.Context, hook.ToolCallEvent) (hook.ToolCallEvent, error) {
+	if scanner == nil {
+		return nil
+	}
+	if opts.SuspiciousScore <= 0 {
+		opts.SuspiciousScore = DefaultInjectionSuspiciousScore
+	}
+	if opts.HighScore <= 0 {
+		opts.HighScore = DefaultInjectionHighScore
+	}
+
+	return func(ctx context.Context, ev hook.ToolCallEvent) (hook.ToolCallEvent, error) {
+		if ev.Result == nil || ev.Result.IsError || ev.Result.Content == "" {
+			return ev, nil
+		}
+		content := ev.Result.Content
+		if strings.HasPrefix(content, wrapPrefix) {
+			// Already wrapped by an earlier pass; the scan ran then.
+			return ev, nil
+		}
+
+		report, err := scanner.Scan(ctx, content)
+		if err != nil {
+			logDebug(opts.Logger, "injection classifier failed, using heuristic score",
+				zap.String("tool", ev.ToolName), zap.Error(err))
+		}
+		if report.Score < opts.SuspiciousScore {
+			return ev, nil
+		}
+
+		action := session.SecurityActionWrapped
+		var rewritten string
+		raised := false
+		if report.Score >= opts.HighScore {
+			action = session.SecurityActionQuarantined
+			rewritten = quarantineSpans(content, report)
+			raised = opts.Gate.Raise()
+		} else {
+			rewritten = wrapSpans(content, report.Spans)
+		}
+
+		if opts.Sink != nil {
+			_ = opts.Sink.Emit(session.Security{
+				BaseEvent:       session.NewBaseEvent(session.KindSecurity, ""),
+				CallID:          ev.CallID,
+				ToolName:        ev.ToolName,
+				Score:           report.Score,
+				Rules:           report.Rules,
+				Action:          action,
+				WriteGateRaised: raised,
+			})
+		}
+
+		next := *ev.Result
+		next.Content = rewritten
+		ev.Result = &next
+		return ev, nil
+	}
+}
+
+// wrapSpans encloses each span in <untrusted_span> delimiters. Spans with
+// no heuristic match (classifier-only) leave the content as is; the
+// surrounding <tool_result trust="untrusted"> still applies.
+func wrapSpans(content string, spans []injection.Span) string {
+	var b strings.Builder
+	prev := 0
+	for _, sp := range spans {
+		b.WriteString(escapeSpanClose(content[prev:sp.Start]))
+		fmt.Fprintf(&b, `%sreason=%q>%s%s`, untrustedSpanOpen, sp.Rule,
+			escapeSpanClose(content[sp.Start:sp.End]), untrustedSpanClose)
+		prev = sp.End
+	}
+	b.WriteString(escapeSpanClose(content[prev:]))
+	return b.String()
+}
+
+// quarantineSpans replaces each span with a notice. Without heuristic
+// spans there is nothing to cut precisely, so the whole output goes.
+func quarantineSpans(content string, report injection.Report) string {
+	if len(report.Spans) == 0 {
+		return fmt.Sprintf("[quarantined: the whole tool output was withheld as a likely prompt injection (score %.2f)]",
+			report.Score)
+	}
+
+	var b strings.Builder
+	prev := 0
+	for _, sp := range report.Spans {
+		b.WriteString(content[prev:sp.Start])
+		fmt.Fprintf(&b, "[quarantined: possible prompt injection (%s)]", sp.Rule)
+		prev = sp.End
+	}
+	b.WriteString(content[prev:])
+	return b.String()
+}
+
+func escapeSpanClose(s string) string {
+	return strings.ReplaceAll(s, untrustedSpanClose, untrustedSpanEscape)
+}
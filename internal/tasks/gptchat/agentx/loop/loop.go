@@ -184,8 +184,20 @@ func Run(ctx context.Context, sess session.Session, deps RunDeps) error {
 		runErr         error
 		iterationsDone int
 		finalStepID    string
+		// tokens sums the usage the upstream reported for every round
+		tokens model.Usage
 	)
 
+	totalUsage := func() session.TotalUsage {
+		return session.TotalUsage{
+			TokensIn:        tokens.InputTokens,
+			TokensOut:       tokens.OutputTokens,
+			ReasoningTokens: tokens.ReasoningTokens,
+			ToolCalls:       int(budget.ToolCalls()),
+			Iterations:      iterationsDone,
+		}
+	}
+
 	// Helper to emit a Final + RunFinished pair under a given step.
 	emitFinal := func(stepID string, text string, citations []session.Citation, origin, termBy string) error {
 		finalEvent := session.Final{
@@ -201,10 +213,7 @@ func Run(ctx context.Context, sess session.Session, deps RunDeps) error {
 			BaseEvent:    session.NewBaseEvent(session.KindRunFinished, runStarted.EventID()),
 			RunID:        runStarted.RunID,
 			TerminatedBy: termBy,
-			TotalUsage: session.TotalUsage{
-				ToolCalls:  int(budget.ToolCalls()),
-				Iterations: iterationsDone,
-			},
+			TotalUsage:   totalUsage(),
 		}
 		return sink.Emit(runFinished)
 	}
@@ -224,10 +233,7 @@ func Run(ctx context.Context, sess session.Session, deps RunDeps) error {
 			BaseEvent:    session.NewBaseEvent(session.KindRunFinished, runStarted.EventID()),
 			RunID:        runStarted.RunID,
 			TerminatedBy: termBy,
-			TotalUsage: session.TotalUsage{
-				ToolCalls:  int(budget.ToolCalls()),
-				Iterations: iterationsDone,
-			},
+			TotalUsage:   totalUsage(),
 		}
 		return sink.Emit(runFinished)
 	}
@@ -408,6 +414,9 @@ func Run(ctx context.Context, sess session.Session, deps RunDeps) error {
 		if roundUsage != nil {
 			stepFinished.TokensIn = roundUsage.InputTokens
 			stepFinished.TokensOut = roundUsage.OutputTokens
+			tokens.InputTokens += roundUsage.InputTokens
+			tokens.OutputTokens += roundUsage.OutputTokens
+			tokens.ReasoningTokens += roundUsage.ReasoningTokens
 		}
 
 		// 4.f: implicit final. No tool calls -> no executor; emit
@@ -561,10 +570,7 @@ func Run(ctx context.Context, sess session.Session, deps RunDeps) error {
 		BaseEvent:    session.NewBaseEvent(session.KindRunFinished, runStarted.EventID()),
 		RunID:        runStarted.RunID,
 		TerminatedBy: session.TerminatedByIterationCap,
-		TotalUsage: session.TotalUsage{
-			ToolCalls:  int(budget.ToolCalls()),
-			Iterations: iterationsDone,
-		},
+		TotalUsage:   totalUsage(),
 	}
 	if err := sink.Emit(runFinished); err != nil {
 		return gerrors.Wrap(err, "emit RunFinished")
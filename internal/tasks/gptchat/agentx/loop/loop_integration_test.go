@@ -126,13 +126,13 @@ func TestU2_ThreeRounds(t *testing.T) {
 			CallID:    "search-1",
 			Name:      "web_search",
 			Arguments: rawArgs(t, map[string]any{"q": "anthropic"}),
-		}}}.chunks(),
+		}}, usage: &model.Usage{InputTokens: 100, OutputTokens: 10, ReasoningTokens: 4}}.chunks(),
 		// Round 2: web_fetch
 		scriptedRound{functionCalls: []model.FunctionCall{{
 			CallID:    "fetch-1",
 			Name:      "web_fetch",
 			Arguments: rawArgs(t, map[string]any{"url": "https://x"}),
-		}}}.chunks(),
+		}}, usage: &model.Usage{InputTokens: 200, OutputTokens: 20}}.chunks(),
 		// Round 3: send_to_user
 		sendToUserBatch(t, "Anthropic's latest blog post is X."),
 	}
@@ -146,6 +146,9 @@ func TestU2_ThreeRounds(t *testing.T) {
 	require.Equal(t, 1, search.callCount())
 	require.Equal(t, 1, fetch.callCount())
 	require.Equal(t, 3, h.modelClient.callIndex(), "exactly three model calls (one per round)")
+	require.Equal(t, 300, rf.TotalUsage.TokensIn, "usage sums every round")
+	require.Equal(t, 30, rf.TotalUsage.TokensOut)
+	require.Equal(t, 4, rf.TotalUsage.ReasoningTokens)
 }
 
 // TestU3_IterationCap covers U3: model never calls send_to_user, loop aborts
@@ -0,0 +1,36 @@
+package loop
+
+import (
+	"context"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
+)
+
+// NewScrubHook returns an OnAfterToolCall hook that rewrites the tool
+// output through scrub, e.g. the moderation pipeline masking PII in a
+// fetched web page. Register it before the distill hook so neither the
+// summariser nor the main model sees the raw content.
+//
+// A nil scrub yields a nil hook, which Bus.OnAfterToolCall ignores.
+func NewScrubHook(scrub func(string) string) func(context.Context, hook.ToolCallEvent) (hook.ToolCallEvent, error) {
+	if scrub == nil {
+		return nil
+	}
+
+	return func(_ context.Context, ev hook.ToolCallEvent) (hook.ToolCallEvent, error) {
+		if ev.Result == nil || ev.Result.Content == "" {
+			return ev, nil
+		}
+		scrubbed := scrub(ev.Result.Content)
+		if scrubbed == ev.Result.Content {
+			return ev, nil
+		}
+
+		// Shallow-copy the result so the upstream caller's Result is not
+		// mutated through aliasing.
+		next := *ev.Result
+		next.Content = scrubbed
+		ev.Result = &next
+		return ev, nil
+	}
+}
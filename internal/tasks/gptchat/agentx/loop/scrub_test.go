@@ -0,0 +1,36 @@
+package loop
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
+)
+
+func TestScrubHook(t *testing.T) {
+	t.Parallel()
+	require.Nil(t, NewScrubHook(nil))
+
+	h := NewScrubHook(func(s string) string {
+		return strings.ReplaceAll(s, "bob@example.com", "[REDACTED:email]")
+	})
+
+	original := &tool.Result{Content: "contact bob@example.com"}
+	out, err := h(context.Background(), hook.ToolCallEvent{ToolName: "web_fetch", Result: original})
+	require.NoError(t, err)
+	require.Equal(t, "contact [REDACTED:email]", out.Result.Content)
+	require.Equal(t, "contact bob@example.com", original.Content, "the caller's result is not mutated")
+
+	clean := &tool.Result{Content: "nothing here"}
+	out, err = h(context.Background(), hook.ToolCallEvent{Result: clean})
+	require.NoError(t, err)
+	require.Same(t, clean, out.Result)
+
+	out, err = h(context.Background(), hook.ToolCallEvent{})
+	require.NoError(t, err)
+	require.Nil(t, out.Result)
+}
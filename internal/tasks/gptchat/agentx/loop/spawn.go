@@ -0,0 +1,219 @@
+package loop
+
+import (
+	"context"
+	"strings"
+
+	gerrors "github.com/Laisky/errors/v2"
+	glog "github.com/Laisky/go-utils/v6/log"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/model"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/session"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
+)
+
+// DefaultSpawnMaxDepth bounds nested child loops when SpawnParentDeps.MaxDepth
+// is unset. Matches the spawn_agent default from proposal §3.6.
+const DefaultSpawnMaxDepth = 2
+
+// ErrSpawnDepthExhausted is returned by Spawn when running the child would
+// exceed SpawnParentDeps.MaxDepth. Callers surface it to the model as a tool
+// error rather than aborting the parent run.
+var ErrSpawnDepthExhausted = gerrors.New("sub-agent depth exhausted")
+
+// SpawnRequest describes one child loop run. See proposal
+// 2026-05-28-agentx-context-and-subagents.md §3.3 for the shape rationale.
+type SpawnRequest struct {
+	// Task is the user-turn text handed to the child loop.
+	Task string
+	// SystemPrompt, when non-empty, is seeded ahead of Task as a system
+	// message so the parent can pin the child's role (researcher, coder…).
+	SystemPrompt string
+	// AllowTools is the subset of the parent registry the child may call.
+	// send_to_user is always added so the child has an exit; an empty
+	// list leaves the child with send_to_user only.
+	AllowTools []string
+	// SessionID is forwarded to the child's session hooks.
+	SessionID string
+}
+
+// SpawnResult is the distilled outcome of a child loop.
+type SpawnResult struct {
+	// FinalText is the child's Final answer. Empty when the child
+	// terminated without one (iteration cap, error budget, timeout).
+	FinalText string `json:"final_text"`
+	// Citations are the references the child attached to send_to_user.
+	Citations []session.Citation `json:"citations,omitempty"`
+	// TerminatedBy mirrors the child's RunFinished.TerminatedBy.
+	TerminatedBy string `json:"terminated_by"`
+	// ToolCalls and Iterations mirror the child's RunFinished usage.
+	ToolCalls  int `json:"tool_calls"`
+	Iterations int `json:"iterations"`
+}
+
+// SpawnParentDeps carries what a child loop inherits from its parent.
+type SpawnParentDeps struct {
+	// Model is the LLM client shared with the parent. Required.
+	Model model.Client
+	// Registry is the parent registry the child's belt is subset from.
+	// Required.
+	Registry tool.Registry
+	// NewBus builds the child's hook bus. Run registers its own budget
+	// enforcer on the bus it receives, so the child must never share the
+	// parent's bus instance. Nil means an empty bus.
+	NewBus func() *hook.Bus
+	// Caps are the parent's caps; the child halves MaxIterations and
+	// MaxToolCalls (minimum 1) per proposal §3.3.
+	Caps Caps
+	// ModelID is forwarded to every child model request.
+	ModelID string
+	// Depth is the parent's nesting depth (0 for a top-level run).
+	Depth int
+	// MaxDepth bounds Depth+1. Zero falls back to DefaultSpawnMaxDepth.
+	MaxDepth int
+	// OnEvent, when set, receives every event the child emits. It runs on
+	// the child's consumer goroutine and must not block.
+	OnEvent func(session.Event)
+	// Logger is handed to the child session and loop. Nil tolerated.
+	Logger glog.Logger
+}
+
+// Spawn runs one child loop to completion and returns its distilled result.
+// The child sees a fresh input (optional system prompt + Task), a subset of
+// the parent registry, and the parent's remaining wall-clock via ctx.
+//
+// A child that terminates without a Final (iteration cap, budget, timeout)
+// is not an error: the returned SpawnResult carries the TerminatedBy so the
+// caller can decide. Only setup failures and cancellation return an error.
+func Spawn(ctx context.Context, parent SpawnParentDeps, req SpawnRequest) (SpawnResult, error) {
+	if parent.Model == nil {
+		return SpawnResult{}, gerrors.New("loop.Spawn: nil model client")
+	}
+	if parent.Registry == nil {
+		return SpawnResult{}, gerrors.New("loop.Spawn: nil parent registry")
+	}
+	if strings.TrimSpace(req.Task) == "" {
+		return SpawnResult{}, gerrors.New("loop.Spawn: empty task")
+	}
+
+	maxDepth := parent.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultSpawnMaxDepth
+	}
+	if parent.Depth+1 > maxDepth {
+		return SpawnResult{}, gerrors.Wrapf(ErrSpawnDepthExhausted, "max=%d", maxDepth)
+	}
+
+	childReg, err := parent.Registry.Subset(childToolNames(parent.Registry, req.AllowTools))
+	if err != nil {
+		return SpawnResult{}, gerrors.Wrap(err, "subset child registry")
+	}
+
+	bus := hook.NewBus(parent.Logger)
+	if parent.NewBus != nil {
+		if b := parent.NewBus(); b != nil {
+			bus = b
+		}
+	}
+
+	sess := session.NewSession(session.Config{Logger: parent.Logger, BufferSize: 256})
+	events := sess.Events()
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		for ev := range events {
+			if parent.OnEvent != nil {
+				parent.OnEvent(ev)
+			}
+		}
+	}()
+
+	if err := sess.Submit(ctx, session.OpUserTurn{Text: req.Task}); err != nil {
+		_ = sess.Close()
+		<-consumerDone
+		return SpawnResult{}, gerrors.Wrap(err, "submit child user turn")
+	}
+
+	var input []model.InputItem
+	if prompt := strings.TrimSpace(req.SystemPrompt); prompt != "" {
+		input = append(input, systemMessage(prompt))
+	}
+
+	runErr := Run(ctx, sess, RunDeps{
+		Bus:        bus,
+		Registry:   childReg,
+		Model:      parent.Model,
+		Caps:       childCaps(parent.Caps),
+		UserPrompt: req.Task,
+		SessionID:  req.SessionID,
+		Input:      input,
+		ModelID:    parent.ModelID,
+		Logger:     parent.Logger,
+	})
+
+	result := spawnResultFromTranscript(sess.Transcript())
+	_ = sess.Close()
+	<-consumerDone
+
+	if runErr != nil {
+		return result, gerrors.Wrap(runErr, "run child loop")
+	}
+	return result, nil
+}
+
+// childToolNames intersects allow with the parent registry and always adds
+// send_to_user when the parent has it. Unknown names are dropped silently:
+// the caller usually asks for a fixed belt (web_search, web_fetch) and a
+// curated catalog that lacks one of them should degrade, not fail.
+func childToolNames(parent tool.Registry, allow []string) []string {
+	seen := make(map[string]struct{}, len(allow)+1)
+	names := make([]string, 0, len(allow)+1)
+	add := func(name string) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return
+		}
+		if _, ok := seen[name]; ok {
+			return
+		}
+		if _, ok := parent.Get(name); !ok {
+			return
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	add(SendToUserToolName)
+	for _, name := range allow {
+		add(name)
+	}
+	return names
+}
+
+// childCaps halves the iteration and tool-call budgets of the parent so a
+// single child cannot consume the whole parent allowance.
+func childCaps(parent Caps) Caps {
+	c := parent.withDefaults()
+	c.MaxIterations = max(c.MaxIterations/2, 1)
+	c.MaxToolCalls = max(c.MaxToolCalls/2, 1)
+	return c
+}
+
+// spawnResultFromTranscript reads the Final and RunFinished events back out
+// of the child's transcript. The transcript is authoritative — the live
+// event channel may drop events under backpressure.
+func spawnResultFromTranscript(tr session.Transcript) SpawnResult {
+	var out SpawnResult
+	for _, ev := range tr.Events() {
+		switch e := ev.(type) {
+		case session.Final:
+			out.FinalText = e.FinalText
+			out.Citations = e.Citations
+		case session.RunFinished:
+			out.TerminatedBy = e.TerminatedBy
+			out.ToolCalls = e.TotalUsage.ToolCalls
+			out.Iterations = e.TotalUsage.Iterations
+		}
+	}
+	return out
+}
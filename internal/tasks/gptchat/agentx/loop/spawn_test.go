@@ -0,0 +1,111 @@
+package loop
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/model"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/session"
+)
+
+func TestSpawn_ReturnsFinalAndCitations(t *testing.T) {
+	search := newFakeTool("web_search", 0, "result")
+	forbidden := newFakeTool("file_write", 0, "written")
+	reg := buildTestRegistry(t, search, forbidden)
+
+	client := newFakeModelClient([][]model.StreamChunk{
+		scriptedRound{functionCalls: []model.FunctionCall{{
+			CallID:    "call-1",
+			Name:      "web_search",
+			Arguments: rawArgs(t, map[string]any{"query": "go"}),
+		}}}.chunks(),
+		scriptedRound{functionCalls: []model.FunctionCall{{
+			CallID: "send-1",
+			Name:   SendToUserToolName,
+			Arguments: rawArgs(t, map[string]any{
+				"final_answer": "go is a language",
+				"citations":    []map[string]string{{"url": "https://go.dev", "title": "Go"}},
+			}),
+		}}}.chunks(),
+	})
+
+	var (
+		mu    sync.Mutex
+		kinds []string
+	)
+	res, err := Spawn(context.Background(), SpawnParentDeps{
+		Model:    client,
+		Registry: reg,
+		Caps:     DefaultCaps(),
+		ModelID:  "test-model",
+		OnEvent: func(ev session.Event) {
+			mu.Lock()
+			kinds = append(kinds, ev.Kind())
+			mu.Unlock()
+		},
+	}, SpawnRequest{
+		Task:         "what is go?",
+		SystemPrompt: "you are a researcher",
+		AllowTools:   []string{"web_search", "missing_tool"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "go is a language", res.FinalText)
+	require.Equal(t, []session.Citation{{URL: "https://go.dev", Title: "Go"}}, res.Citations)
+	require.Equal(t, session.TerminatedBySendToUser, res.TerminatedBy)
+	require.Equal(t, 1, res.ToolCalls)
+	require.Equal(t, 2, res.Iterations)
+	require.Equal(t, 1, search.callCount())
+	require.Equal(t, 0, forbidden.callCount())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Contains(t, kinds, session.KindToolCallStart)
+	require.Contains(t, kinds, session.KindRunFinished)
+}
+
+func TestSpawn_DepthExhausted(t *testing.T) {
+	reg := buildTestRegistry(t)
+	_, err := Spawn(context.Background(), SpawnParentDeps{
+		Model:    newFakeModelClient(nil),
+		Registry: reg,
+		Depth:    2,
+		MaxDepth: 2,
+	}, SpawnRequest{Task: "x"})
+	require.ErrorIs(t, err, ErrSpawnDepthExhausted)
+}
+
+func TestSpawn_CancelledParent(t *testing.T) {
+	reg := buildTestRegistry(t, newFakeTool("web_fetch", time.Second, "slow"))
+	client := newFakeModelClient([][]model.StreamChunk{
+		scriptedRound{functionCalls: []model.FunctionCall{{
+			CallID:    "call-1",
+			Name:      "web_fetch",
+			Arguments: rawArgs(t, map[string]any{"url": "https://example.com"}),
+		}}}.chunks(),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	res, err := Spawn(ctx, SpawnParentDeps{
+		Model:    client,
+		Registry: reg,
+		Caps:     DefaultCaps(),
+	}, SpawnRequest{Task: "fetch", AllowTools: []string{"web_fetch"}})
+	if err == nil {
+		// A deadline inherited from the parent is reported as a timeout
+		// termination rather than an error.
+		require.Equal(t, session.TerminatedByTimeout, res.TerminatedBy)
+	}
+	require.Empty(t, res.FinalText)
+}
+
+func TestChildCaps_HalvesBudgets(t *testing.T) {
+	c := childCaps(Caps{MaxIterations: 20, MaxToolCalls: 1})
+	require.Equal(t, 10, c.MaxIterations)
+	require.Equal(t, 1, c.MaxToolCalls)
+	require.Equal(t, DefaultCaps().WallClock, c.WallClock)
+}
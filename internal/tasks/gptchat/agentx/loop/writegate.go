@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
@@ -45,7 +46,39 @@ func isWriteTool(name string) bool {
 //
 // Non-write tools always pass through unchanged regardless of mode.
 func NewWriteGateHook(mode string) func(context.Context, hook.ToolCallEvent) (hook.ToolCallEvent, error) {
+	return NewEscalatingWriteGateHook(mode, nil)
+}
+
+// WriteGateEscalation lets another hook tighten the write-gate for the
+// rest of a run, e.g. after the injection scanner flags a tool result.
+// The zero value is not raised; a nil *WriteGateEscalation never is.
+type WriteGateEscalation struct {
+	raised atomic.Bool
+}
+
+// Raise switches the gate to ask mode. It reports whether this call
+// raised it, so callers can tell the user exactly once.
+func (e *WriteGateEscalation) Raise() bool {
+	if e == nil {
+		return false
+	}
+	return e.raised.CompareAndSwap(false, true)
+}
+
+// Raised reports whether Raise has been called.
+func (e *WriteGateEscalation) Raised() bool {
+	return e != nil && e.raised.Load()
+}
+
+// NewEscalatingWriteGateHook is NewWriteGateHook whose mode is raised to
+// WriteGateAsk once esc is raised. WriteGateDeny is already stricter and
+// stays as is. A nil esc behaves exactly like NewWriteGateHook.
+func NewEscalatingWriteGateHook(configured string, esc *WriteGateEscalation) func(context.Context, hook.ToolCallEvent) (hook.ToolCallEvent, error) {
 	return func(_ context.Context, ev hook.ToolCallEvent) (hook.ToolCallEvent, error) {
+		mode := configured
+		if esc.Raised() && mode != WriteGateDeny {
+			mode = WriteGateAsk
+		}
 		if ev.Result != nil {
 			// Only fire on Before-tool-call; defensive pass-through if we
 			// somehow get an After-shaped event.
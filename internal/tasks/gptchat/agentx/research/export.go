@@ -0,0 +1,236 @@
+package research
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+)
+
+// Export formats accepted by the export endpoint.
+const (
+	ExportFormatMarkdown = "md"
+	ExportFormatPDF      = "pdf"
+)
+
+const pdfRenderTimeout = 60 * time.Second
+
+const reportHTMLTemplate = `<!doctype html>
+<html>
+  <head>
+    <meta charset="utf-8" />
+    <meta http-equiv="Content-Security-Policy"
+      content="default-src 'none'; img-src data:; font-src data:; style-src 'unsafe-inline'" />
+    <title>{{ .Title }}</title>
+    <style>
+      @page { size: A4; margin: 18mm; }
+      body {
+        font-family: "Noto Serif", "Noto Serif SC", "Source Han Serif", Georgia, serif;
+        color: #111827; font-size: 11pt; line-height: 1.55;
+      }
+      h1, h2, h3 { font-family: "Noto Sans", "Noto Sans SC", "Helvetica Neue", Arial, sans-serif; }
+      h1 { font-size: 20pt; border-bottom: 1px solid #d1d5db; padding-bottom: 6px; }
+      h2 { font-size: 14pt; margin-top: 18px; }
+      a { color: #1d4ed8; text-decoration: none; word-break: break-all; }
+      code, pre { font-family: "JetBrains Mono", Menlo, monospace; font-size: 9.5pt; }
+      pre { background: #f3f4f6; padding: 8px; white-space: pre-wrap; }
+      table { border-collapse: collapse; }
+      th, td { border: 1px solid #d1d5db; padding: 4px 8px; }
+    </style>
+  </head>
+  <body>{{ .Content }}</body>
+</html>`
+
+var reportTmpl = template.Must(template.New("deepresearch_report").Parse(reportHTMLTemplate))
+
+// Source is one entry of the report's numbered reference list.
+type Source struct {
+	Index int    `json:"index"`
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+}
+
+// displayTitle falls back to the URL when the researcher omitted a title.
+func (s Source) displayTitle() string {
+	if t := strings.TrimSpace(s.Title); t != "" {
+		return t
+	}
+	return s.URL
+}
+
+// Report is the compiled result of a deep-research task.
+type Report struct {
+	Title    string `json:"title"`
+	Question string `json:"question"`
+	// Body is the writer's Markdown with [n] markers into Sources.
+	Body     string    `json:"body"`
+	Findings []Finding `json:"findings"`
+	Sources  []Source  `json:"sources"`
+}
+
+// Markdown renders the full report: title, body, and the numbered
+// references section.
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", r.Title)
+	b.WriteString(strings.TrimSpace(r.Body))
+	b.WriteString("\n")
+	if len(r.Sources) > 0 {
+		b.WriteString("\n## References\n\n")
+		for _, s := range r.Sources {
+			fmt.Fprintf(&b, "%d. [%s](%s)\n", s.Index, escapeLinkText(s.displayTitle()), s.URL)
+		}
+	}
+	return b.String()
+}
+
+// HTML renders the Markdown export into a standalone HTML document.
+func (r *Report) HTML() (string, error) {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	)
+	var body bytes.Buffer
+	if err := md.Convert([]byte(r.Markdown()), &body); err != nil {
+		return "", errors.Wrap(err, "render markdown")
+	}
+
+	var out bytes.Buffer
+	if err := reportTmpl.Execute(&out, struct {
+		Title   string
+		Content template.HTML
+	}{
+		Title:   r.Title,
+		Content: template.HTML(body.String()), //nolint:gosec // goldmark escapes raw HTML without WithUnsafe
+	}); err != nil {
+		return "", errors.Wrap(err, "execute report template")
+	}
+	return out.String(), nil
+}
+
+// PDFRenderer converts a standalone HTML document into PDF bytes.
+type PDFRenderer interface {
+	RenderPDF(ctx context.Context, html string) ([]byte, error)
+}
+
+// ChromePDFRenderer renders PDFs with headless Chrome, the same approach
+// the CV task uses.
+//
+// The report is built from model output and fetched web pages, so every
+// request other than the data: URL of the report itself is failed before
+// it leaves the browser. Otherwise an image in the report could make the
+// server fetch internal addresses.
+type ChromePDFRenderer struct{}
+
+// RenderPDF implements PDFRenderer.
+func (ChromePDFRenderer) RenderPDF(ctx context.Context, html string) ([]byte, error) {
+	renderCtx, cancel := context.WithTimeout(ctx, pdfRenderTimeout)
+	defer cancel()
+
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(
+		renderCtx,
+		append(chromedp.DefaultExecAllocatorOptions[:],
+			chromedp.NoDefaultBrowserCheck,
+			chromedp.NoFirstRun,
+			chromedp.Flag("headless", true),
+			chromedp.Flag("disable-gpu", true),
+			chromedp.Flag("disable-dev-shm-usage", true),
+			chromedp.Flag("no-sandbox", true),
+		)...,
+	)
+	defer cancelAlloc()
+
+	chromeCtx, cancelChrome := chromedp.NewContext(allocCtx)
+	defer cancelChrome()
+
+	chromedp.ListenTarget(chromeCtx, func(ev any) {
+		paused, ok := ev.(*fetch.EventRequestPaused)
+		if !ok {
+			return
+		}
+		// listeners must not block, so the verdict is sent asynchronously
+		go func() {
+			execCtx := cdp.WithExecutor(chromeCtx, chromedp.FromContext(chromeCtx).Target)
+			if isInlineResource(paused.Request.URL) {
+				_ = fetch.ContinueRequest(paused.RequestID).Do(execCtx)
+				return
+			}
+			_ = fetch.FailRequest(paused.RequestID, network.ErrorReasonBlockedByClient).Do(execCtx)
+		}()
+	})
+
+	dataURL := "data:text/html;base64," + base64.StdEncoding.EncodeToString([]byte(html))
+	var pdf []byte
+	if err := chromedp.Run(chromeCtx,
+		fetch.Enable().WithPatterns([]*fetch.RequestPattern{{URLPattern: "*"}}),
+		chromedp.Navigate(dataURL),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		chromedp.ActionFunc(func(ctx context.Context) (err error) {
+			pdf, _, err = page.PrintToPDF().
+				WithPrintBackground(true).
+				WithPreferCSSPageSize(true).
+				Do(ctx)
+			return err
+		}),
+	); err != nil {
+		return nil, errors.Wrap(err, "run chromedp")
+	}
+	if len(pdf) == 0 {
+		return nil, errors.New("empty pdf output")
+	}
+	return pdf, nil
+}
+
+// isInlineResource reports whether url carries its content inline, so
+// loading it makes no network request.
+func isInlineResource(url string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(url)), "data:")
+}
+
+// Export renders the report in the requested format and returns the body,
+// its content type and a download file name.
+func Export(ctx context.Context, r *Report, format string, pdfRenderer PDFRenderer) (
+	body []byte, contentType, filename string, err error) {
+	if r == nil {
+		return nil, "", "", errors.New("report is empty")
+	}
+
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", ExportFormatMarkdown, "markdown":
+		return []byte(r.Markdown()), "text/markdown; charset=utf-8", "deepresearch.md", nil
+	case ExportFormatPDF:
+		if pdfRenderer == nil {
+			pdfRenderer = ChromePDFRenderer{}
+		}
+		html, err := r.HTML()
+		if err != nil {
+			return nil, "", "", errors.WithStack(err)
+		}
+		pdf, err := pdfRenderer.RenderPDF(ctx, html)
+		if err != nil {
+			return nil, "", "", errors.Wrap(err, "render pdf")
+		}
+		return pdf, "application/pdf", "deepresearch.pdf", nil
+	default:
+		return nil, "", "", errors.Errorf("unsupported export format %q", format)
+	}
+}
+
+// escapeLinkText keeps brackets in source titles from breaking the
+// Markdown link syntax.
+func escapeLinkText(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(s)
+}
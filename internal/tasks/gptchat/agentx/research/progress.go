@@ -0,0 +1,186 @@
+package research
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Task statuses. The values match the legacy LLM-storm task statuses so the
+// frontend can poll both kinds of task with the same state machine.
+const (
+	TaskStatusPending = "pending"
+	TaskStatusRunning = "running"
+	TaskStatusSuccess = "success"
+	TaskStatusFailed  = "failed"
+)
+
+// Progress stages recorded on Task.Events.
+const (
+	StagePlanning    = "planning"
+	StagePlanned     = "planned"
+	StageResearching = "researching"
+	StageToolCall    = "tool_call"
+	StageFinding     = "finding"
+	StageWriting     = "writing"
+	StageCompleted   = "completed"
+	StageFailed      = "failed"
+)
+
+// maxTaskEvents bounds the persisted progress log. Older entries are dropped
+// first; the stage transitions that matter to the status endpoint are always
+// among the most recent.
+const maxTaskEvents = 200
+
+// DefaultTaskTTL is how long a task (and its report) stays retrievable.
+const DefaultTaskTTL = 7 * 24 * time.Hour
+
+// ErrTaskNotFound is returned by Store.Load when the task id is unknown or
+// has expired.
+var ErrTaskNotFound = errors.New("deep research task not found")
+
+// ProgressEvent is one persisted step of a running task.
+type ProgressEvent struct {
+	At    time.Time `json:"at"`
+	Stage string    `json:"stage"`
+	// SubQuestion is the 1-based sub-question index the event belongs to;
+	// zero for pipeline-level events.
+	SubQuestion int    `json:"sub_question,omitempty"`
+	Message     string `json:"message"`
+}
+
+// Task is the persisted state of one deep-research run.
+type Task struct {
+	TaskID string `json:"task_id"`
+	// UserName owns the task; the status endpoint only serves the owner.
+	UserName     string          `json:"user_name"`
+	Status       string          `json:"status"`
+	Prompt       string          `json:"prompt"`
+	Model        string          `json:"model"`
+	SubQuestions []string        `json:"sub_questions,omitempty"`
+	Events       []ProgressEvent `json:"events"`
+	Report       *Report         `json:"report,omitempty"`
+	FailedReason string          `json:"failed_reason,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	FinishedAt   *time.Time      `json:"finished_at,omitempty"`
+}
+
+// appendEvent records ev and trims the log to maxTaskEvents.
+func (t *Task) appendEvent(ev ProgressEvent) {
+	t.Events = append(t.Events, ev)
+	if over := len(t.Events) - maxTaskEvents; over > 0 {
+		t.Events = append([]ProgressEvent(nil), t.Events[over:]...)
+	}
+	t.UpdatedAt = ev.At
+}
+
+// clone returns a deep-enough copy for persisting outside the pipeline lock.
+func (t *Task) clone() *Task {
+	cp := *t
+	cp.SubQuestions = append([]string(nil), t.SubQuestions...)
+	cp.Events = append([]ProgressEvent(nil), t.Events...)
+	return &cp
+}
+
+// Store persists tasks for the status and export endpoints.
+type Store interface {
+	Save(ctx context.Context, task *Task) error
+	// Load returns ErrTaskNotFound when the id is unknown.
+	Load(ctx context.Context, taskID string) (*Task, error)
+}
+
+// MemoryStore is an in-process Store used by tests and single-instance
+// deployments without Redis.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	tasks map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tasks: map[string][]byte{}}
+}
+
+// Save implements Store. Tasks are stored serialised so callers can never
+// alias the stored copy.
+func (s *MemoryStore) Save(_ context.Context, task *Task) error {
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return errors.Wrap(err, "marshal task")
+	}
+	s.mu.Lock()
+	s.tasks[task.TaskID] = payload
+	s.mu.Unlock()
+	return nil
+}
+
+// Load implements Store.
+func (s *MemoryStore) Load(_ context.Context, taskID string) (*Task, error) {
+	s.mu.RLock()
+	payload, ok := s.tasks[taskID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errors.WithStack(ErrTaskNotFound)
+	}
+	task := new(Task)
+	if err := json.Unmarshal(payload, task); err != nil {
+		return nil, errors.Wrap(err, "unmarshal task")
+	}
+	return task, nil
+}
+
+// RedisStore persists tasks as JSON blobs with a TTL.
+type RedisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisStore returns a Redis-backed Store. ttl <= 0 falls back to
+// DefaultTaskTTL.
+func NewRedisStore(client *redis.Client, ttl time.Duration) (*RedisStore, error) {
+	if client == nil {
+		return nil, errors.New("redis client is nil")
+	}
+	if ttl <= 0 {
+		ttl = DefaultTaskTTL
+	}
+	return &RedisStore{client: client, ttl: ttl}, nil
+}
+
+// redisTaskKey is the key a task is stored under.
+func redisTaskKey(taskID string) string {
+	return "ramjet:gptchat:deepresearch:" + taskID
+}
+
+// Save implements Store.
+func (s *RedisStore) Save(ctx context.Context, task *Task) error {
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return errors.Wrap(err, "marshal task")
+	}
+	if err := s.client.Set(ctx, redisTaskKey(task.TaskID), payload, s.ttl).Err(); err != nil {
+		return errors.Wrapf(err, "save task %q", task.TaskID)
+	}
+	return nil
+}
+
+// Load implements Store.
+func (s *RedisStore) Load(ctx context.Context, taskID string) (*Task, error) {
+	payload, err := s.client.Get(ctx, redisTaskKey(taskID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errors.WithStack(ErrTaskNotFound)
+		}
+		return nil, errors.Wrapf(err, "load task %q", taskID)
+	}
+	task := new(Task)
+	if err := json.Unmarshal(payload, task); err != nil {
+		return nil, errors.Wrap(err, "unmarshal task")
+	}
+	return task, nil
+}
@@ -0,0 +1,87 @@
+package research
+
+import (
+	"fmt"
+	"strings"
+)
+
+// plannerSystemPrompt instructs the planner to decompose the question into
+// independent sub-questions. The reply is parsed by parseSubQuestions, which
+// tolerates code fences and bullet lists when the model ignores the JSON
+// instruction.
+func plannerSystemPrompt(maxSubQuestions int) string {
+	return fmt.Sprintf(`You are the planner of a deep-research pipeline.
+Break the user's research question into at most %d focused sub-questions that
+can be researched independently on the web. Together they must cover the
+whole question; avoid overlap.
+
+Reply with a JSON array of strings and nothing else, for example:
+["sub-question one", "sub-question two"]`, maxSubQuestions)
+}
+
+// researcherSystemPrompt pins the child loop's role. The child only has the
+// web tools and send_to_user, so the prompt stresses attaching citations —
+// the writer can only cite what the researchers returned.
+func researcherSystemPrompt(question string) string {
+	return fmt.Sprintf(`You are a researcher working on one part of a larger report.
+The overall research question is:
+%s
+
+Use web_search to find sources and web_fetch to read the most relevant ones.
+Prefer primary and recent sources. When you have enough evidence, call
+send_to_user with a concise factual summary (key facts, numbers, dates,
+disagreements between sources) and attach every source you relied on as a
+citation with its url and title.`, question)
+}
+
+// writerSystemPrompt instructs the writer to compile the final report from
+// the numbered source list in the user prompt.
+const writerSystemPrompt = `You are the writer of a deep-research report.
+Compile the findings below into a well-structured Markdown report that answers
+the research question. Start with a one-paragraph summary, then use "##"
+sections. Support every factual claim with numeric citations such as [1] or
+[2][5] that refer to the numbered source list. Only cite sources from that
+list and never invent URLs. Do not add a references section; it is appended
+automatically. Do not start with a top-level "#" title.`
+
+// buildWriterPrompt renders the research question, the per-sub-question
+// findings and the global numbered source list into the writer's user turn.
+// Citations inside each finding are rewritten to their global index so the
+// writer never has to renumber.
+func buildWriterPrompt(question string, findings []Finding, sources []Source) string {
+	index := make(map[string]int, len(sources))
+	for _, s := range sources {
+		index[s.URL] = s.Index
+	}
+
+	var b strings.Builder
+	b.WriteString("Research question:\n")
+	b.WriteString(question)
+	b.WriteString("\n\n# Findings\n")
+	for i, f := range findings {
+		fmt.Fprintf(&b, "\n## Sub-question %d: %s\n", i+1, f.Question)
+		answer := strings.TrimSpace(f.Answer)
+		if answer == "" {
+			answer = "(no findings)"
+		}
+		b.WriteString(answer)
+		b.WriteByte('\n')
+		var refs []string
+		for _, c := range f.Citations {
+			if n, ok := index[normalizeURL(c.URL)]; ok {
+				refs = append(refs, fmt.Sprintf("[%d]", n))
+			}
+		}
+		if len(refs) > 0 {
+			b.WriteString("Sources: ")
+			b.WriteString(strings.Join(refs, " "))
+			b.WriteByte('\n')
+		}
+	}
+
+	b.WriteString("\n# Sources\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, "[%d] %s — %s\n", s.Index, s.displayTitle(), s.URL)
+	}
+	return b.String()
+}
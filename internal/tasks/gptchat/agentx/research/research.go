@@ -0,0 +1,460 @@
+// Package research implements the native deep-research pipeline on top of
+// the agentx loop: a planner decomposes the question, one child loop per
+// sub-question researches it with the web tools (loop.Spawn), and a writer
+// compiles the findings into a cited Markdown report.
+//
+// It replaces the legacy LLM-storm queue for deployments that enable
+// AgentLoop.DeepResearch; the HTTP surface (create / status / export) is
+// shared with the legacy path.
+package research
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	glog "github.com/Laisky/go-utils/v6/log"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/hook"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/loop"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/model"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/session"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
+)
+
+// Pipeline defaults applied when the corresponding field is zero.
+const (
+	DefaultMaxSubQuestions = 5
+	DefaultMaxParallel     = 2
+	// defaultWriterMaxOutputTokens bounds the writer's reply.
+	defaultWriterMaxOutputTokens = 8192
+	// defaultPlannerMaxOutputTokens bounds the planner's reply.
+	defaultPlannerMaxOutputTokens = 1024
+)
+
+// DefaultResearchTools is the belt each researcher child loop gets.
+var DefaultResearchTools = []string{"web_search", "web_fetch"}
+
+// Finding is one researcher's answer to a sub-question.
+type Finding struct {
+	Question  string             `json:"question"`
+	Answer    string             `json:"answer"`
+	Citations []session.Citation `json:"citations,omitempty"`
+	// TerminatedBy mirrors the child loop's termination reason; anything
+	// other than send_to_user means the answer may be empty or partial.
+	TerminatedBy string `json:"terminated_by"`
+}
+
+// Pipeline runs deep-research tasks. A Pipeline is safe for concurrent use
+// as long as Model, Registry and Store are.
+type Pipeline struct {
+	// Model is the LLM client used by the planner, researchers and writer.
+	// Required.
+	Model model.Client
+	// Registry is the tool belt the researchers are subset from. Required.
+	Registry tool.Registry
+	// ModelID is forwarded on every model request.
+	ModelID string
+	// Caps are the parent caps; each researcher gets loop.Spawn's halved
+	// budget. Zero values fall back to loop.DefaultCaps.
+	Caps loop.Caps
+	// MaxSubQuestions caps the planner's decomposition.
+	MaxSubQuestions int
+	// MaxParallel bounds concurrently running researchers.
+	MaxParallel int
+	// ResearchTools overrides DefaultResearchTools.
+	ResearchTools []string
+	// Store persists progress. Nil disables persistence (tests only).
+	Store Store
+	// NewBus builds each researcher's hook bus. Nil means an empty bus.
+	NewBus func() *hook.Bus
+	// Logger is required.
+	Logger glog.Logger
+}
+
+// NewTask returns a pending task ready to be handed to Pipeline.Run.
+func NewTask(taskID, userName, prompt, modelID string) *Task {
+	now := time.Now().UTC()
+	return &Task{
+		TaskID:    taskID,
+		UserName:  userName,
+		Status:    TaskStatusPending,
+		Prompt:    prompt,
+		Model:     modelID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// tracker serialises progress updates from concurrent researchers and
+// persists a snapshot after each one.
+type tracker struct {
+	mu     sync.Mutex
+	task   *Task
+	store  Store
+	logger glog.Logger
+}
+
+// update applies fn to the task under the lock and persists a snapshot.
+// Save errors are logged, not returned: a flaky store must not abort a run
+// that has already spent model budget.
+func (t *tracker) update(ctx context.Context, fn func(task *Task)) {
+	t.mu.Lock()
+	fn(t.task)
+	snapshot := t.task.clone()
+	t.mu.Unlock()
+
+	if t.store == nil {
+		return
+	}
+	if err := t.store.Save(context.WithoutCancel(ctx), snapshot); err != nil {
+		t.logger.Warn("save deep research progress",
+			zap.String("task_id", snapshot.TaskID), zap.Error(err))
+	}
+}
+
+// event appends one progress event.
+func (t *tracker) event(ctx context.Context, stage string, subQuestion int, msg string) {
+	t.update(ctx, func(task *Task) {
+		task.appendEvent(ProgressEvent{
+			At:          time.Now().UTC(),
+			Stage:       stage,
+			SubQuestion: subQuestion,
+			Message:     msg,
+		})
+	})
+}
+
+// Run executes the pipeline for task, persisting progress as it goes. The
+// task is updated in place; on return its Status is success or failed and
+// the returned error mirrors FailedReason.
+func (p *Pipeline) Run(ctx context.Context, task *Task) error {
+	if task == nil {
+		return errors.New("nil task")
+	}
+	tr := &tracker{task: task, store: p.Store, logger: p.Logger}
+
+	report, err := p.run(ctx, tr)
+	finished := time.Now().UTC()
+	if err != nil {
+		tr.update(ctx, func(task *Task) {
+			task.Status = TaskStatusFailed
+			task.FailedReason = err.Error()
+			task.FinishedAt = &finished
+			task.appendEvent(ProgressEvent{At: finished, Stage: StageFailed, Message: err.Error()})
+		})
+		return err
+	}
+
+	tr.update(ctx, func(task *Task) {
+		task.Status = TaskStatusSuccess
+		task.Report = report
+		task.FinishedAt = &finished
+		task.appendEvent(ProgressEvent{
+			At:      finished,
+			Stage:   StageCompleted,
+			Message: fmt.Sprintf("report compiled with %d sources", len(report.Sources)),
+		})
+	})
+	return nil
+}
+
+func (p *Pipeline) run(ctx context.Context, tr *tracker) (*Report, error) {
+	if p.Model == nil {
+		return nil, errors.New("nil model client")
+	}
+	if p.Registry == nil {
+		return nil, errors.New("nil tool registry")
+	}
+	question := strings.TrimSpace(tr.task.Prompt)
+	if question == "" {
+		return nil, errors.New("empty research question")
+	}
+
+	tr.update(ctx, func(task *Task) { task.Status = TaskStatusRunning })
+	tr.event(ctx, StagePlanning, 0, "decomposing the question")
+
+	subQuestions, err := p.plan(ctx, question)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan")
+	}
+	tr.update(ctx, func(task *Task) {
+		task.SubQuestions = subQuestions
+		task.appendEvent(ProgressEvent{
+			At:      time.Now().UTC(),
+			Stage:   StagePlanned,
+			Message: fmt.Sprintf("%d sub-questions", len(subQuestions)),
+		})
+	})
+
+	findings, err := p.research(ctx, tr, question, subQuestions)
+	if err != nil {
+		return nil, errors.Wrap(err, "research")
+	}
+
+	sources := collectSources(findings)
+	tr.event(ctx, StageWriting, 0, fmt.Sprintf("compiling report from %d sources", len(sources)))
+	body, err := p.complete(ctx, writerSystemPrompt,
+		buildWriterPrompt(question, findings, sources), defaultWriterMaxOutputTokens)
+	if err != nil {
+		return nil, errors.Wrap(err, "write report")
+	}
+
+	return &Report{
+		Title:    reportTitle(question),
+		Question: question,
+		Body:     body,
+		Findings: findings,
+		Sources:  sources,
+	}, nil
+}
+
+// plan asks the model for sub-questions. A planner failure is fatal; an
+// unparsable reply degrades to researching the original question.
+func (p *Pipeline) plan(ctx context.Context, question string) ([]string, error) {
+	maxSub := p.MaxSubQuestions
+	if maxSub <= 0 {
+		maxSub = DefaultMaxSubQuestions
+	}
+	reply, err := p.complete(ctx, plannerSystemPrompt(maxSub), question, defaultPlannerMaxOutputTokens)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return parseSubQuestions(reply, question, maxSub), nil
+}
+
+// research runs one child loop per sub-question, at most MaxParallel at a
+// time. A researcher that fails is recorded as an empty finding; the run
+// only fails when none of them produced an answer.
+func (p *Pipeline) research(ctx context.Context, tr *tracker,
+	question string, subQuestions []string) ([]Finding, error) {
+	parallel := p.MaxParallel
+	if parallel <= 0 {
+		parallel = DefaultMaxParallel
+	}
+	allow := p.ResearchTools
+	if len(allow) == 0 {
+		allow = DefaultResearchTools
+	}
+
+	findings := make([]Finding, len(subQuestions))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, sub := range subQuestions {
+		idx := i + 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				findings[idx-1] = Finding{Question: sub, TerminatedBy: session.TerminatedByTimeout}
+				return
+			}
+			defer func() { <-sem }()
+
+			tr.event(ctx, StageResearching, idx, sub)
+			res, err := loop.Spawn(ctx, loop.SpawnParentDeps{
+				Model:    p.Model,
+				Registry: p.Registry,
+				NewBus:   p.NewBus,
+				Caps:     p.Caps,
+				ModelID:  p.ModelID,
+				Logger:   p.Logger,
+				OnEvent: func(ev session.Event) {
+					if start, ok := ev.(session.ToolCallStart); ok && start.ToolName != loop.SendToUserToolName {
+						tr.event(ctx, StageToolCall, idx, start.ToolName+" "+start.ArgsPreview)
+					}
+				},
+			}, loop.SpawnRequest{
+				Task:         sub,
+				SystemPrompt: researcherSystemPrompt(question),
+				AllowTools:   allow,
+				SessionID:    tr.task.TaskID,
+			})
+			if err != nil {
+				p.Logger.Warn("deep research sub-question failed",
+					zap.String("task_id", tr.task.TaskID),
+					zap.Int("sub_question", idx),
+					zap.Error(err))
+			}
+
+			findings[idx-1] = Finding{
+				Question:     sub,
+				Answer:       strings.TrimSpace(res.FinalText),
+				Citations:    res.Citations,
+				TerminatedBy: res.TerminatedBy,
+			}
+			tr.event(ctx, StageFinding, idx, fmt.Sprintf("%s, %d citations, %d tool calls",
+				findingStatus(res, err), len(res.Citations), res.ToolCalls))
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, f := range findings {
+		if f.Answer != "" {
+			return findings, nil
+		}
+	}
+	return nil, errors.New("no researcher produced a finding")
+}
+
+// findingStatus summarises one researcher's outcome for the progress log.
+func findingStatus(res loop.SpawnResult, err error) string {
+	switch {
+	case err != nil:
+		return "failed"
+	case strings.TrimSpace(res.FinalText) == "":
+		return "no answer (" + res.TerminatedBy + ")"
+	default:
+		return "answered"
+	}
+}
+
+// complete runs one tool-less model call and returns the trimmed text.
+func (p *Pipeline) complete(ctx context.Context, system, user string, maxOut uint) (string, error) {
+	ch, err := p.Model.Stream(ctx, model.Request{
+		Model: p.ModelID,
+		Input: []model.InputItem{
+			map[string]any{"role": "system", "content": system},
+			map[string]any{"role": "user", "content": user},
+		},
+		Stream:          true,
+		MaxOutputTokens: maxOut,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "model stream")
+	}
+
+	var (
+		out       strings.Builder
+		streamErr error
+	)
+	for chunk := range ch {
+		switch chunk.Kind {
+		case model.ChunkText:
+			out.WriteString(chunk.Text)
+		case model.ChunkError:
+			if chunk.Err != nil {
+				streamErr = chunk.Err
+			} else if chunk.Text != "" {
+				streamErr = errors.New(chunk.Text)
+			}
+		}
+	}
+	if streamErr != nil {
+		return "", errors.WithStack(streamErr)
+	}
+	text := strings.TrimSpace(out.String())
+	if text == "" {
+		return "", errors.New("model returned empty output")
+	}
+	return text, nil
+}
+
+// parseSubQuestions extracts the planner's sub-questions. It accepts a JSON
+// array (optionally inside a code fence or surrounded by prose) and falls
+// back to bullet or numbered lines. When nothing usable is found the
+// original question is researched as-is.
+func parseSubQuestions(reply, question string, maxSub int) []string {
+	var candidates []string
+	if start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]"); start >= 0 && end > start {
+		var arr []string
+		if err := json.Unmarshal([]byte(reply[start:end+1]), &arr); err == nil {
+			candidates = arr
+		}
+	}
+	if candidates == nil {
+		for _, line := range strings.Split(reply, "\n") {
+			line = strings.TrimSpace(line)
+			trimmed := strings.TrimLeft(line, "-*•0123456789.) ")
+			if trimmed != line && trimmed != "" {
+				candidates = append(candidates, trimmed)
+			}
+		}
+	}
+
+	seen := map[string]struct{}{}
+	out := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		c = strings.TrimSpace(c)
+		key := strings.ToLower(c)
+		if c == "" {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, c)
+		if len(out) == maxSub {
+			break
+		}
+	}
+	if len(out) == 0 {
+		return []string{question}
+	}
+	return out
+}
+
+// collectSources deduplicates every finding's citations by normalised URL
+// and numbers them in first-seen order.
+func collectSources(findings []Finding) []Source {
+	seen := map[string]struct{}{}
+	var sources []Source
+	for _, f := range findings {
+		for _, c := range f.Citations {
+			u := normalizeURL(c.URL)
+			if u == "" {
+				continue
+			}
+			if _, ok := seen[u]; ok {
+				continue
+			}
+			seen[u] = struct{}{}
+			sources = append(sources, Source{
+				Index: len(sources) + 1,
+				URL:   u,
+				Title: strings.TrimSpace(c.Title),
+			})
+		}
+	}
+	return sources
+}
+
+// normalizeURL drops fragments and trailing slashes so the same page cited
+// by two researchers collapses into one source. Non-http(s) URLs are
+// rejected — the writer must not cite something the reader cannot open.
+func normalizeURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	u.Fragment = ""
+	u.Host = strings.ToLower(u.Host)
+	if u.Path != "/" {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+	} else {
+		u.Path = ""
+	}
+	return u.String()
+}
+
+// reportTitle derives the report title from the first line of the question.
+func reportTitle(question string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(question), "\n")
+	title = strings.TrimSpace(title)
+	if r := []rune(title); len(r) > 120 {
+		title = string(r[:120]) + "…"
+	}
+	return title
+}
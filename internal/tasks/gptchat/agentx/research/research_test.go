@@ -0,0 +1,221 @@
+package research
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	glog "github.com/Laisky/go-utils/v6/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/model"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/session"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tools"
+)
+
+// routedModelClient answers planner, writer and researcher requests based on
+// the system prompt, so concurrent researchers need no call ordering.
+type routedModelClient struct {
+	mu          sync.Mutex
+	plannerText string
+	writerText  string
+	// answers maps a sub-question to the send_to_user args its researcher
+	// returns; missing entries make the researcher stop without a final.
+	answers      map[string]map[string]any
+	writerPrompt string
+}
+
+func (c *routedModelClient) Stream(_ context.Context, req model.Request) (<-chan model.StreamChunk, error) {
+	system, user := messageText(req.Input, "system"), messageText(req.Input, "user")
+	var chunks []model.StreamChunk
+	switch {
+	case strings.Contains(system, "planner"):
+		chunks = []model.StreamChunk{{Kind: model.ChunkText, Text: c.plannerText}}
+	case strings.Contains(system, "writer"):
+		c.mu.Lock()
+		c.writerPrompt = user
+		c.mu.Unlock()
+		chunks = []model.StreamChunk{{Kind: model.ChunkText, Text: c.writerText}}
+	default:
+		if args, ok := c.answers[user]; ok {
+			raw, _ := json.Marshal(args)
+			chunks = []model.StreamChunk{{Kind: model.ChunkFunction, FunctionCall: &model.FunctionCall{
+				CallID: "send-" + user, Name: tools.SendToUserName, Arguments: raw,
+			}}}
+		} else {
+			chunks = []model.StreamChunk{{Kind: model.ChunkError, Text: "upstream unavailable"}}
+		}
+	}
+
+	ch := make(chan model.StreamChunk, len(chunks)+1)
+	for _, chunk := range chunks {
+		ch <- chunk
+	}
+	if chunks[len(chunks)-1].Kind != model.ChunkError {
+		ch <- model.StreamChunk{Kind: model.ChunkDone}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (c *routedModelClient) Capabilities() model.Capabilities { return model.Capabilities{} }
+
+// messageText returns the content of the first input message with role.
+func messageText(input []model.InputItem, role string) string {
+	for _, item := range input {
+		if m, ok := item.(map[string]any); ok && m["role"] == role {
+			s, _ := m["content"].(string)
+			return s
+		}
+	}
+	return ""
+}
+
+func testPipeline(t *testing.T, client model.Client, store Store) *Pipeline {
+	t.Helper()
+	logger, err := glog.NewConsoleWithName("research_test", glog.LevelError)
+	require.NoError(t, err)
+	reg := tool.NewRegistry(logger)
+	require.NoError(t, reg.Register(tools.NewSendToUserTool(), tool.SourceLocal))
+	return &Pipeline{
+		Model:    client,
+		Registry: reg,
+		ModelID:  "test-model",
+		Store:    store,
+		Logger:   logger,
+	}
+}
+
+func TestPipelineRun_CompilesCitedReport(t *testing.T) {
+	client := &routedModelClient{
+		plannerText: "```json\n[\"what is go?\", \"who made go?\"]\n```",
+		writerText:  "Go is a language [1] made at Google [2].",
+		answers: map[string]map[string]any{
+			"what is go?": {
+				"final_answer": "a programming language",
+				"citations":    []map[string]string{{"url": "https://go.dev/#intro", "title": "Go"}},
+			},
+			"who made go?": {
+				"final_answer": "Google engineers",
+				"citations": []map[string]string{
+					{"url": "https://go.dev/", "title": "Go"},
+					{"url": "https://en.wikipedia.org/wiki/Go_(programming_language)"},
+				},
+			},
+		},
+	}
+	store := NewMemoryStore()
+	task := NewTask("task-1", "alice", "Tell me about Go", "test-model")
+
+	require.NoError(t, testPipeline(t, client, store).Run(context.Background(), task))
+
+	saved, err := store.Load(context.Background(), "task-1")
+	require.NoError(t, err)
+	require.Equal(t, TaskStatusSuccess, saved.Status)
+	require.Equal(t, []string{"what is go?", "who made go?"}, saved.SubQuestions)
+	require.NotNil(t, saved.FinishedAt)
+	require.Equal(t, StageCompleted, saved.Events[len(saved.Events)-1].Stage)
+
+	report := saved.Report
+	require.NotNil(t, report)
+	require.Equal(t, "Tell me about Go", report.Title)
+	require.Equal(t, []Source{
+		{Index: 1, URL: "https://go.dev", Title: "Go"},
+		{Index: 2, URL: "https://en.wikipedia.org/wiki/Go_(programming_language)"},
+	}, report.Sources)
+	require.Len(t, report.Findings, 2)
+	require.Equal(t, session.TerminatedBySendToUser, report.Findings[0].TerminatedBy)
+
+	require.Contains(t, client.writerPrompt, "[1] Go — https://go.dev")
+	require.Contains(t, client.writerPrompt, "Sources: [1] [2]")
+
+	md := report.Markdown()
+	require.True(t, strings.HasPrefix(md, "# Tell me about Go\n"))
+	require.Contains(t, md, "## References")
+	require.Contains(t, md, "2. [https://en.wikipedia.org/wiki/Go_(programming_language)]")
+}
+
+func TestPipelineRun_FailsWithoutFindings(t *testing.T) {
+	client := &routedModelClient{plannerText: `["q1"]`, writerText: "unused"}
+	store := NewMemoryStore()
+	task := NewTask("task-2", "bob", "anything", "test-model")
+
+	err := testPipeline(t, client, store).Run(context.Background(), task)
+	require.Error(t, err)
+
+	saved, err := store.Load(context.Background(), "task-2")
+	require.NoError(t, err)
+	require.Equal(t, TaskStatusFailed, saved.Status)
+	require.Contains(t, saved.FailedReason, "no researcher produced a finding")
+	require.Nil(t, saved.Report)
+}
+
+func TestParseSubQuestions(t *testing.T) {
+	cases := []struct {
+		name  string
+		reply string
+		want  []string
+	}{
+		{"json", `["a", "b", "a", ""]`, []string{"a", "b"}},
+		{"prose around json", "Here you go:\n[\"a\"]\nDone.", []string{"a"}},
+		{"bullets", "- first\n* second\n3. third", []string{"first", "second", "third"}},
+		{"capped", `["a","b","c","d"]`, []string{"a", "b", "c"}},
+		{"fallback", "I cannot help", []string{"orig"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, parseSubQuestions(tc.reply, "orig", 3))
+		})
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	require.Equal(t, "https://example.com/a", normalizeURL("https://Example.com/a/#frag"))
+	require.Equal(t, "https://example.com", normalizeURL("https://example.com/"))
+	require.Empty(t, normalizeURL("javascript:alert(1)"))
+	require.Empty(t, normalizeURL("not a url"))
+}
+
+func TestExport(t *testing.T) {
+	r := &Report{
+		Title:   "T",
+		Body:    "body [1]",
+		Sources: []Source{{Index: 1, URL: "https://example.com", Title: "a [b]"}},
+	}
+
+	body, ct, name, err := Export(context.Background(), r, "md", nil)
+	require.NoError(t, err)
+	require.Equal(t, "text/markdown; charset=utf-8", ct)
+	require.Equal(t, "deepresearch.md", name)
+	require.Contains(t, string(body), `1. [a \[b\]](https://example.com)`)
+
+	html, err := r.HTML()
+	require.NoError(t, err)
+	require.Contains(t, html, `<a href="https://example.com">`)
+	require.Contains(t, html, `default-src 'none'; img-src data:`)
+
+	_, _, _, err = Export(context.Background(), r, "docx", nil)
+	require.Error(t, err)
+
+	pdf, ct, _, err := Export(context.Background(), r, "pdf", fakePDFRenderer{})
+	require.NoError(t, err)
+	require.Equal(t, "application/pdf", ct)
+	require.Equal(t, "%PDF-fake", string(pdf))
+}
+
+func TestIsInlineResource(t *testing.T) {
+	require.True(t, isInlineResource("data:text/html;base64,PGgxPg=="))
+	require.True(t, isInlineResource(" DATA:image/png;base64,AA"))
+	require.False(t, isInlineResource("http://169.254.169.254/latest/meta-data"))
+	require.False(t, isInlineResource("file:///etc/passwd"))
+	require.False(t, isInlineResource("https://example.com/a.png"))
+}
+
+type fakePDFRenderer struct{}
+
+func (fakePDFRenderer) RenderPDF(context.Context, string) ([]byte, error) {
+	return []byte("%PDF-fake"), nil
+}
@@ -51,6 +51,7 @@ const (
 	KindFinal                   = "final"
 	KindRunFinished             = "run_finished"
 	KindError                   = "error"
+	KindSecurity                = "security"
 )
 
 // Final.Origin values per §4.5.2 and §3.7.
@@ -81,10 +82,11 @@ type Citation struct {
 
 // TotalUsage is the aggregate usage attached to RunFinished.
 type TotalUsage struct {
-	TokensIn   int `json:"tokens_in"`
-	TokensOut  int `json:"tokens_out"`
-	ToolCalls  int `json:"tool_calls"`
-	Iterations int `json:"iterations"`
+	TokensIn        int `json:"tokens_in"`
+	TokensOut       int `json:"tokens_out"`
+	ReasoningTokens int `json:"reasoning_tokens,omitempty"`
+	ToolCalls       int `json:"tool_calls"`
+	Iterations      int `json:"iterations"`
 }
 
 // RunStarted marks the beginning of an agent run.
@@ -176,6 +178,26 @@ type Error struct {
 	Message string `json:"message"`
 }
 
+// Security.Action values.
+const (
+	SecurityActionWrapped     = "wrapped"
+	SecurityActionQuarantined = "quarantined"
+)
+
+// Security reports a tool result flagged by the prompt-injection scanner.
+type Security struct {
+	BaseEvent
+	CallID   string   `json:"call_id"`
+	ToolName string   `json:"tool_name"`
+	Score    float64  `json:"score"`
+	Rules    []string `json:"rules,omitempty"`
+	// Action is what the scanner did to the flagged spans.
+	Action string `json:"action"`
+	// WriteGateRaised is true when this result raised the write-gate to
+	// ask mode for the rest of the run.
+	WriteGateRaised bool `json:"write_gate_raised"`
+}
+
 // envelope is the JSONL marshalling shape: header fields are stored alongside
 // a kind-specific payload so the same line carries both routing data and the
 // typed body.
@@ -24,6 +24,7 @@ func TestEventTypesImplementInterface(t *testing.T) {
 		Final{BaseEvent: base, FinalText: "answer", Origin: FinalOriginSendToUser},
 		RunFinished{BaseEvent: base, RunID: "run", TerminatedBy: TerminatedBySendToUser},
 		Error{BaseEvent: base, Code: "boom", Message: "fail"},
+		Security{BaseEvent: base, CallID: "call-1", ToolName: "web_fetch", Score: 0.9, Action: SecurityActionQuarantined},
 	}
 	for _, ev := range cases {
 		require.Equal(t, "01-id", ev.EventID(), "%T.EventID", ev)
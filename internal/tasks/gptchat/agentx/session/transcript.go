@@ -244,6 +244,12 @@ func decodeEvent(env envelope) (Event, error) {
 			return nil, errors.Wrap(err, KindError)
 		}
 		return ev, nil
+	case KindSecurity:
+		var ev Security
+		if err := json.Unmarshal(env.Payload, &ev); err != nil {
+			return nil, errors.Wrap(err, KindSecurity)
+		}
+		return ev, nil
 	default:
 		return nil, errors.Errorf("unknown event kind %q", env.Kind)
 	}
@@ -164,6 +164,15 @@ func TestTranscript_JSONLRoundTrip(t *testing.T) {
 			Code:      "boom",
 			Message:   "transport failed",
 		},
+		Security{
+			BaseEvent:       BaseEvent{ID: "ev-8", ParentID: "ev-1", EventKind: KindSecurity, At: now.Add(7 * time.Millisecond)},
+			CallID:          "call-abc",
+			ToolName:        "web_fetch",
+			Score:           0.84,
+			Rules:           []string{"instruction_override", "role_hijack"},
+			Action:          SecurityActionQuarantined,
+			WriteGateRaised: true,
+		},
 	}
 	for _, ev := range events {
 		require.NoError(t, tr.Append(ev))
@@ -209,6 +218,10 @@ func TestTranscript_JSONLRoundTrip(t *testing.T) {
 	require.True(t, ok)
 	require.Equal(t, TerminatedBySendToUser, rf.TerminatedBy)
 	require.Equal(t, TotalUsage{TokensIn: 100, TokensOut: 50, ToolCalls: 1, Iterations: 1}, rf.TotalUsage)
+
+	sec, ok := parsed[7].(Security)
+	require.True(t, ok)
+	require.Equal(t, events[7], sec)
 }
 
 func TestTranscript_EventsReturnsCopy(t *testing.T) {
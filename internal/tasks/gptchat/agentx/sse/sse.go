@@ -192,6 +192,13 @@ func (w *Writer) ConsumeOne(ev session.Event) error {
 			return err
 		}
 		return w.emit(EmitFinish, w.requestID, "")
+	case session.Security:
+		line := toolStepMarker + "[" + short(e.CallID) + "] security: possible prompt injection in " +
+			e.ToolName + " (score=" + strconv.FormatFloat(e.Score, 'f', 2, 64) + ", " + e.Action + ")"
+		if e.WriteGateRaised {
+			line += "; write tools now need confirmation"
+		}
+		return w.emitReasoningLine(line + "\n")
 	case session.Error:
 		return w.emitReasoningLine(
 			toolStepMarker + "error: " + e.Code + " — " + e.Message + "\n",
@@ -299,6 +299,27 @@ func TestConsumeOne_Error_EmitsTraceLine(t *testing.T) {
 	}}, r.calls)
 }
 
+func TestConsumeOne_Security_EmitsWarningLine(t *testing.T) {
+	t.Parallel()
+	w, r := newWriter("rid-15")
+	err := w.ConsumeOne(session.Security{
+		BaseEvent:       makeBase(session.KindSecurity),
+		CallID:          "01HTJZ1F5JEDQRD2MNGNH9V0WB",
+		ToolName:        "web_fetch",
+		Score:           0.84,
+		Rules:           []string{"instruction_override"},
+		Action:          session.SecurityActionQuarantined,
+		WriteGateRaised: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []recordedEmit{{
+		Kind:      EmitReasoning,
+		RequestID: "rid-15",
+		Text: "[[TOOLS]] [01HTJZ] security: possible prompt injection in web_fetch " +
+			"(score=0.84, quarantined); write tools now need confirmation\n",
+	}}, r.calls)
+}
+
 // -----------------------------------------------------------------------------
 // U10 — delimiter escaping
 // -----------------------------------------------------------------------------
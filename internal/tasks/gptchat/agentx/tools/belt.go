@@ -89,6 +89,9 @@ type BeltDeps struct {
 	// IsError-returning stubs whenever DiscoverMCPTools fails. Useful in
 	// production to keep the loop alive on a flaky MCP catalog.
 	FallbackBelt []string
+	// UploadedFileReader, when non-nil, registers uploaded_file_read with
+	// SourceLocal so the model can read chat uploads.
+	UploadedFileReader UploadedFileReader
 }
 
 // mcpDiscoverer is the function signature shared by the production
@@ -138,7 +141,14 @@ func BuildCuratedBelt(ctx context.Context, deps BeltDeps) (tool.Registry, error)
 		}
 	}
 
-	// 3. Curated MCP belt. Skip cleanly when MCPServer is nil.
+	// 3. Reader for files uploaded through the chat UI.
+	if deps.UploadedFileReader != nil {
+		if err := reg.Register(NewUploadedFileReadTool(deps.UploadedFileReader), tool.SourceLocal); err != nil {
+			return nil, errors.Wrap(err, "register uploaded_file_read")
+		}
+	}
+
+	// 4. Curated MCP belt. Skip cleanly when MCPServer is nil.
 	if deps.MCPServer == nil {
 		return reg, nil
 	}
@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/session"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
+)
+
+// UploadedFileReadName is the local tool that reads files the user
+// attached through the chat upload button. The curated `file_read` MCP
+// tool only sees the MCP file store, so uploads need their own reader.
+const UploadedFileReadName = "uploaded_file_read"
+
+// uploadedFileReadDefaultLimit is the default window, in characters,
+// returned per call. Long documents are paged with `offset`.
+const uploadedFileReadDefaultLimit = 20000
+
+const uploadedFileReadDescription = "Read a file the user uploaded to the chat " +
+	"(a `user-files/...` URL in their message). PDF, DOCX, XLSX, CSV, HTML, " +
+	"text and images are returned as Markdown with `page-N` / `sheet-<name>` " +
+	"anchors. Use `offset` to page through long documents."
+
+var uploadedFileReadSchema = json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "url": {
+      "type": "string",
+      "description": "The uploaded file URL exactly as it appears in the conversation."
+    },
+    "offset": {
+      "type": "integer",
+      "minimum": 0,
+      "description": "Character offset to start reading from. Default 0."
+    },
+    "limit": {
+      "type": "integer",
+      "minimum": 1,
+      "description": "Maximum characters to return. Default 20000."
+    }
+  },
+  "required": ["url"],
+  "additionalProperties": false
+}`)
+
+// UploadedFileReader returns the extracted Markdown of an uploaded file.
+// Production wires http.ReadUploadedFile bound to the requesting user.
+type UploadedFileReader func(ctx context.Context, fileURL string) (string, error)
+
+// UploadedFileReadArgs is the typed view of the tool arguments.
+type UploadedFileReadArgs struct {
+	URL    string `json:"url"`
+	Offset int    `json:"offset,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+type uploadedFileReadTool struct {
+	read UploadedFileReader
+}
+
+// NewUploadedFileReadTool returns the uploaded_file_read tool backed by read.
+func NewUploadedFileReadTool(read UploadedFileReader) tool.Tool {
+	return uploadedFileReadTool{read: read}
+}
+
+// Name implements tool.Tool.
+func (uploadedFileReadTool) Name() string { return UploadedFileReadName }
+
+// Description implements tool.Tool.
+func (uploadedFileReadTool) Description() string { return uploadedFileReadDescription }
+
+// Schema implements tool.Tool.
+func (uploadedFileReadTool) Schema() json.RawMessage { return uploadedFileReadSchema }
+
+// Execute implements tool.Tool. Read and argument failures are reported as
+// IsError results so the model can correct itself.
+func (t uploadedFileReadTool) Execute(ctx context.Context, call tool.Call, _ session.EventSink) (tool.Result, error) {
+	var args UploadedFileReadArgs
+	if err := json.Unmarshal(call.Args, &args); err != nil {
+		return tool.Result{Content: "uploaded_file_read: " + errors.Wrap(err, "decode arguments").Error(), IsError: true}, nil
+	}
+	args.URL = strings.TrimSpace(args.URL)
+	if args.URL == "" {
+		return tool.Result{Content: "uploaded_file_read: `url` is required", IsError: true}, nil
+	}
+
+	markdown, err := t.read(ctx, args.URL)
+	if err != nil {
+		return tool.Result{Content: "uploaded_file_read: " + err.Error(), IsError: true}, nil
+	}
+
+	return tool.Result{Content: pageText(markdown, args.Offset, args.Limit)}, nil
+}
+
+// pageText returns the [offset, offset+limit) rune window of text with a
+// trailing hint when more content follows.
+func pageText(text string, offset, limit int) string {
+	if limit <= 0 {
+		limit = uploadedFileReadDefaultLimit
+	}
+	runes := []rune(text)
+	offset = min(max(offset, 0), len(runes))
+	end := min(offset+limit, len(runes))
+
+	page := string(runes[offset:end])
+	if end < len(runes) {
+		page += fmt.Sprintf("\n\n[truncated: %d of %d characters shown, continue with offset=%d]",
+			end-offset, len(runes), end)
+	}
+	return page
+}
@@ -0,0 +1,69 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/agentx/tool"
+)
+
+func TestUploadedFileRead_Execute(t *testing.T) {
+	t.Parallel()
+	var gotURL string
+	tt := NewUploadedFileReadTool(func(_ context.Context, fileURL string) (string, error) {
+		gotURL = fileURL
+		if fileURL == "https://s3.example.com/missing" {
+			return "", errors.New("not found")
+		}
+		return "# Doc\n\nhello world", nil
+	})
+	require.Equal(t, UploadedFileReadName, tt.Name())
+
+	res, err := tt.Execute(context.Background(), tool.Call{
+		Name: UploadedFileReadName,
+		Args: json.RawMessage(`{"url":" https://s3.example.com/doc.pdf "}`),
+	}, nil)
+	require.NoError(t, err)
+	require.False(t, res.IsError)
+	require.Equal(t, "# Doc\n\nhello world", res.Content)
+	require.Equal(t, "https://s3.example.com/doc.pdf", gotURL)
+
+	res, err = tt.Execute(context.Background(), tool.Call{
+		Name: UploadedFileReadName,
+		Args: json.RawMessage(`{"url":"https://s3.example.com/doc.pdf","offset":7,"limit":5}`),
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n\n[truncated: 5 of 18 characters shown, continue with offset=12]", res.Content)
+
+	res, err = tt.Execute(context.Background(), tool.Call{
+		Name: UploadedFileReadName,
+		Args: json.RawMessage(`{"url":"https://s3.example.com/missing"}`),
+	}, nil)
+	require.NoError(t, err)
+	require.True(t, res.IsError)
+	require.Contains(t, res.Content, "not found")
+
+	res, err = tt.Execute(context.Background(), tool.Call{
+		Name: UploadedFileReadName,
+		Args: json.RawMessage(`{}`),
+	}, nil)
+	require.NoError(t, err)
+	require.True(t, res.IsError)
+}
+
+func TestBuildCuratedBelt_RegistersUploadedFileReader(t *testing.T) {
+	t.Parallel()
+	reg, err := BuildCuratedBelt(context.Background(), BeltDeps{
+		UploadedFileReader: func(context.Context, string) (string, error) { return "", nil },
+	})
+	require.NoError(t, err)
+	require.Contains(t, reg.Names(), UploadedFileReadName)
+
+	reg, err = BuildCuratedBelt(context.Background(), BeltDeps{})
+	require.NoError(t, err)
+	require.NotContains(t, reg.Names(), UploadedFileReadName)
+}
@@ -4,6 +4,7 @@ package config
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"slices"
 	"strings"
 
 	"github.com/Laisky/errors/v2"
@@ -46,6 +47,16 @@ func SetupConfig() (err error) {
 		&Config.RateLimitFreeModelsIntervalSeconds, 1)
 	Config.RateLimiterBackend = strings.ToLower(strings.TrimSpace(
 		gutils.OptionalVal(&Config.RateLimiterBackend, "redis")))
+	if len(Config.ModelTiers) == 0 {
+		Config.ModelTiers = DefaultModelTiers(Config.RateLimitExpensiveModelsIntervalSeconds)
+	}
+	if err = validModelTiers(Config.ModelTiers); err != nil {
+		return errors.Wrap(err, "invalid openai.model_tiers")
+	}
+	Config.ModelTiers = normalizeModelTiers(Config.ModelTiers)
+	if err = normalizeModeration(&Config.Moderation); err != nil {
+		return errors.Wrap(err, "invalid openai.moderation")
+	}
 	Config.DefaultImageToken = gutils.OptionalVal(
 		&Config.DefaultImageToken, Config.Token)
 	// Config.DefaultImageTokenType = gutils.OptionalVal(
@@ -63,6 +74,13 @@ func SetupConfig() (err error) {
 	Config.MemoryModel = gutils.OptionalVal(&Config.MemoryModel, "openai/gpt-oss-120b")
 	Config.MemoryLLMTimeoutSeconds = gutils.OptionalVal(&Config.MemoryLLMTimeoutSeconds, 15)
 	Config.MemoryLLMMaxOutputTokens = gutils.OptionalVal(&Config.MemoryLLMMaxOutputTokens, 512)
+	if err = normalizeOrganizations(Config.Organizations, Config.MemoryProject); err != nil {
+		return errors.Wrap(err, "invalid openai.organizations")
+	}
+	Config.UsageAnalytics.RollupIntervalSeconds = gutils.OptionalVal(
+		&Config.UsageAnalytics.RollupIntervalSeconds, 600)
+	Config.UsageAnalytics.LookbackDays = gutils.OptionalVal(&Config.UsageAnalytics.LookbackDays, 2)
+	Config.UsageAnalytics.EventRetentionDays = gutils.OptionalVal(&Config.UsageAnalytics.EventRetentionDays, 90)
 	if Config.AgentLoop != nil {
 		Config.AgentLoop.MaxIterations = gutils.OptionalVal(&Config.AgentLoop.MaxIterations, 20)
 		Config.AgentLoop.MaxToolCalls = gutils.OptionalVal(&Config.AgentLoop.MaxToolCalls, 40)
@@ -80,6 +98,23 @@ func SetupConfig() (err error) {
 			&Config.AgentLoop.DistillerModel, "openai/gpt-oss-120b"))
 		Config.AgentLoop.DistillThresholdTokens = gutils.OptionalVal(&Config.AgentLoop.DistillThresholdTokens, 1600)
 		Config.AgentLoop.DistillTimeoutSeconds = gutils.OptionalVal(&Config.AgentLoop.DistillTimeoutSeconds, 8)
+		Config.AgentLoop.DeepResearch.Model = strings.TrimSpace(gutils.OptionalVal(
+			&Config.AgentLoop.DeepResearch.Model, "openai/gpt-oss-120b"))
+		Config.AgentLoop.DeepResearch.MaxSubQuestions = gutils.OptionalVal(
+			&Config.AgentLoop.DeepResearch.MaxSubQuestions, 5)
+		Config.AgentLoop.DeepResearch.MaxParallel = gutils.OptionalVal(&Config.AgentLoop.DeepResearch.MaxParallel, 2)
+		Config.AgentLoop.DeepResearch.WallClockSeconds = gutils.OptionalVal(
+			&Config.AgentLoop.DeepResearch.WallClockSeconds, 1800)
+		Config.AgentLoop.DeepResearch.TaskTTLSeconds = gutils.OptionalVal(
+			&Config.AgentLoop.DeepResearch.TaskTTLSeconds, 7*24*3600)
+		Config.AgentLoop.DeepResearch.MaxTasksPerUser = gutils.OptionalVal(
+			&Config.AgentLoop.DeepResearch.MaxTasksPerUser, 2)
+		Config.AgentLoop.InjectionScan.SuspiciousScore = gutils.OptionalVal(
+			&Config.AgentLoop.InjectionScan.SuspiciousScore, 0.4)
+		Config.AgentLoop.InjectionScan.HighScore = gutils.OptionalVal(&Config.AgentLoop.InjectionScan.HighScore, 0.8)
+		Config.AgentLoop.InjectionScan.ClassifierModel = strings.TrimSpace(Config.AgentLoop.InjectionScan.ClassifierModel)
+		Config.AgentLoop.InjectionScan.ClassifierTimeoutSeconds = gutils.OptionalVal(
+			&Config.AgentLoop.InjectionScan.ClassifierTimeoutSeconds, 8)
 	}
 	Config.WebFetch.Jina.Prefix = normalizeWebFetchPrefix(
 		gutils.OptionalVal(&Config.WebFetch.Jina.Prefix, "https://r.jina.ai/"))
@@ -99,6 +134,7 @@ func SetupConfig() (err error) {
 	// 	&Config.DefaultOpenaiToken, Config.Token)
 	Config.LimitUploadFileBytes = gutils.OptionalVal(
 		&Config.LimitUploadFileBytes, 20*1024*1024)
+	Config.OCRModel = gutils.OptionalVal(&Config.OCRModel, "gpt-4o-mini")
 
 	// format normalize
 	Config.API = strings.TrimRight(Config.API, "/")
@@ -190,10 +226,17 @@ type OpenAI struct {
 	RateLimitFreeModelsIntervalSeconds int `json:"rate_limit_image_models_interval_secs" mapstructure:"rate_limit_image_models_interval_secs"`
 	// RateLimiterBackend (optional) backend for rate limiting (redis or legacy), default redis
 	RateLimiterBackend string `json:"rate_limiter_backend" mapstructure:"rate_limiter_backend"`
+	// ModelTiers (optional) rate limit tiers of models, the first tier matching a model wins,
+	// default is the built-in free models and everything else as expensive
+	ModelTiers []ModelTier `json:"model_tiers" mapstructure:"model_tiers"`
 	// Proxy (optional) proxy url to send request
 	Proxy string `json:"-" mapstructure:"proxy"`
 	// UserTokens (optional) paid user's tenant tokens
 	UserTokens []*UserConfig `json:"user_tokens" mapstructure:"user_tokens"`
+	// Organizations (optional) teams sharing a token quota, models, memory and mcp server
+	Organizations []*OrganizationConfig `json:"organizations" mapstructure:"organizations"`
+	// UsageAnalytics (optional) records chat usage and rolls it up per day
+	UsageAnalytics UsageAnalyticsConfig `json:"usage_analytics" mapstructure:"usage_analytics"`
 	// GoogleAnalytics (optional) google analytics id
 	GoogleAnalytics string `json:"ga" mapstructure:"ga"`
 	// StaticLibs (optional) replace default static libs' url
@@ -220,6 +263,9 @@ type OpenAI struct {
 
 	// PaymentStripeKey (optional) stripe key
 	PaymentStripeKey string `json:"payment_stripe_key" mapstructure:"payment_stripe_key"`
+	// PaymentStripeWebhookSecret (optional) signing secret of the stripe webhook endpoint,
+	// webhook is disabled if empty
+	PaymentStripeWebhookSecret string `json:"payment_stripe_webhook_secret" mapstructure:"payment_stripe_webhook_secret"`
 
 	// LcmBasicAuthUsername (optional) lcm basic auth username
 	LcmBasicAuthUsername string `json:"lcm_basic_auth_username" mapstructure:"lcm_basic_auth_username"`
@@ -227,6 +273,8 @@ type OpenAI struct {
 	LcmBasicAuthPassword string `json:"lcm_basic_auth_password" mapstructure:"lcm_basic_auth_password"`
 	// LimitUploadFileBytes (optional) limit upload file bytes, default is 20MB
 	LimitUploadFileBytes int `json:"limit_upload_file_bytes" mapstructure:"limit_upload_file_bytes"`
+	// OCRModel (optional) vision model used to OCR uploaded images, default is gpt-4o-mini
+	OCRModel string `json:"ocr_model" mapstructure:"ocr_model"`
 	// EnableMemory enables transparent memory hooks in chat flow.
 	EnableMemory bool `json:"enable_memory" mapstructure:"enable_memory"`
 	// MemoryProject is the memory project namespace, default to go-ramjet-memory
@@ -244,11 +292,32 @@ type OpenAI struct {
 	// When nil the agent loop is disabled — `agent_mode=true` requests
 	// receive HTTP 409 agent_mode_disabled.
 	AgentLoop *AgentLoopConfig `json:"agent_loop" mapstructure:"agent_loop"`
+	// Moderation (optional) configures PII detection and redaction of chat requests
+	Moderation ModerationConfig `json:"moderation" mapstructure:"moderation"`
 	// WebFetch configures markdown-oriented web fetch proxy providers.
 	WebFetch WebFetchConfig `json:"web_fetch" mapstructure:"web_fetch"`
 
 	// Azure (optional) azure config
 	Azure azureConfig `json:"azure" mapstructure:"azure"`
+	// EnableLocalVoiceProvider (optional) registers the offline "local" STT/TTS
+	// stand-in, only meant for tests and development
+	EnableLocalVoiceProvider bool `json:"enable_local_voice_provider" mapstructure:"enable_local_voice_provider"`
+}
+
+// UsageAnalyticsConfig configures usage analytics. Each chat request is
+// recorded as a usage event, and a scheduled task rolls the events up into
+// per-day, per-user and per-model totals served by the usage API.
+type UsageAnalyticsConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// RollupIntervalSeconds (optional) how often the rollup task runs, default 600
+	RollupIntervalSeconds int `json:"rollup_interval_seconds" mapstructure:"rollup_interval_seconds"`
+	// LookbackDays (optional) days recomputed by each rollup, today included, default 2
+	LookbackDays int `json:"lookback_days" mapstructure:"lookback_days"`
+	// EventRetentionDays (optional) how long raw usage events are kept, default 90.
+	// Rollups are kept forever.
+	EventRetentionDays int `json:"event_retention_days" mapstructure:"event_retention_days"`
+	// Admins (optional) usernames allowed to query the usage of every user
+	Admins []string `json:"admins" mapstructure:"admins"`
 }
 
 // AgentLoopConfig captures the per-server runtime knobs for the Phase 1
@@ -308,6 +377,57 @@ type AgentLoopConfig struct {
 	// timeout the distiller falls back to deterministic head/tail
 	// truncation so the parent ReAct round is not stalled. Default 8.
 	DistillTimeoutSeconds int `json:"distill_timeout_seconds" mapstructure:"distill_timeout_seconds"`
+	// DeepResearch configures the native deep-research pipeline that
+	// replaces the external LLM-storm queue behind /deepresearch.
+	DeepResearch AgentLoopDeepResearchConfig `json:"deep_research" mapstructure:"deep_research"`
+	// InjectionScan configures the prompt-injection scanner applied to
+	// tool results (agentx/injection).
+	InjectionScan AgentLoopInjectionScanConfig `json:"injection_scan" mapstructure:"injection_scan"`
+}
+
+// AgentLoopInjectionScanConfig configures the prompt-injection scanner.
+// Heuristic rules always run when Enabled; the classifier model is only
+// consulted when ClassifierModel is set.
+type AgentLoopInjectionScanConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// SuspiciousScore is the score at which flagged spans are wrapped in
+	// untrusted delimiters. Default 0.4.
+	SuspiciousScore float64 `json:"suspicious_score" mapstructure:"suspicious_score"`
+	// HighScore is the score at which flagged spans are quarantined and
+	// write tools need confirmation for the rest of the run. Default 0.8.
+	HighScore float64 `json:"high_score" mapstructure:"high_score"`
+	// ClassifierModel, when set, scores results whose heuristic score
+	// reaches ClassifyMinScore. Empty disables the classifier.
+	ClassifierModel string `json:"classifier_model" mapstructure:"classifier_model"`
+	// ClassifyMinScore is the heuristic score at which the classifier is
+	// consulted. Default 0, every result, so the classifier also catches
+	// injections no rule matches.
+	ClassifyMinScore float64 `json:"classify_min_score" mapstructure:"classify_min_score"`
+	// ClassifierTimeoutSeconds bounds each classifier call. Default 8.
+	ClassifierTimeoutSeconds int `json:"classifier_timeout_seconds" mapstructure:"classifier_timeout_seconds"`
+}
+
+// AgentLoopDeepResearchConfig configures the native deep-research pipeline
+// (agentx/research). When Enabled is false /deepresearch keeps using the
+// legacy LLM-storm queue.
+type AgentLoopDeepResearchConfig struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Model drives the planner, researchers and writer. Default
+	// `openai/gpt-oss-120b`.
+	Model string `json:"model" mapstructure:"model"`
+	// MaxSubQuestions caps the planner's decomposition. Default 5.
+	MaxSubQuestions int `json:"max_sub_questions" mapstructure:"max_sub_questions"`
+	// MaxParallel bounds concurrently running researchers. Default 2.
+	MaxParallel int `json:"max_parallel" mapstructure:"max_parallel"`
+	// WallClockSeconds bounds a whole task, including the writer.
+	// Default 1800.
+	WallClockSeconds int `json:"wall_clock_seconds" mapstructure:"wall_clock_seconds"`
+	// TaskTTLSeconds is how long tasks and reports stay retrievable.
+	// Default 7 days.
+	TaskTTLSeconds int `json:"task_ttl_seconds" mapstructure:"task_ttl_seconds"`
+	// MaxTasksPerUser bounds the tasks one user may have running on an
+	// instance at the same time. Default 2.
+	MaxTasksPerUser int `json:"max_tasks_per_user" mapstructure:"max_tasks_per_user"`
 }
 
 // AgentLoopSubagentConfig configures the (Phase-1 stub) subagent tool.
@@ -446,6 +566,51 @@ type UserConfig struct {
 	EnableExternalImageBilling bool `json:"enable_external_image_billing" mapstructure:"enable_external_image_billing"`
 	// ExternalImageBillingUID (optional) external image billing uid
 	// ExternalImageBillingUID string `json:"external_image_billing_uid" mapstructure:"external_image_billing_uid"`
+	// Voice (optional) speech-to-text and text-to-speech preferences
+	Voice UserVoiceConfig `json:"voice" mapstructure:"voice"`
+	// PrepaidCredit is set at runtime for free-tier users holding credit,
+	// their usage is debited from the credit ledger
+	PrepaidCredit bool `json:"prepaid_credit" mapstructure:"-"`
+	// APIKeyID is set at runtime when the user authenticated by a managed api key
+	APIKeyID string `json:"api_key_id,omitempty" mapstructure:"-"`
+	// Scopes is set at runtime from the managed api key, empty means every scope
+	Scopes []string `json:"scopes,omitempty" mapstructure:"-"`
+	// OrgID is set at runtime when the user is a member of an organization
+	OrgID string `json:"org_id,omitempty" mapstructure:"-"`
+	// OrgRole is the user's role in OrgID
+	OrgRole string `json:"org_role,omitempty" mapstructure:"-"`
+}
+
+// HasScope reports whether the user may use scope.
+func (c *UserConfig) HasScope(scope string) bool {
+	return len(c.Scopes) == 0 || slices.Contains(c.Scopes, scope)
+}
+
+// UserVoiceConfig is a user's STT/TTS preferences. Empty fields fall back
+// to the provider defaults; request parameters override them.
+type UserVoiceConfig struct {
+	// TTSProvider (optional) preferred text-to-speech provider, e.g. azure or openai
+	TTSProvider string `json:"tts_provider" mapstructure:"tts_provider"`
+	// STTProvider (optional) preferred speech-to-text provider
+	STTProvider string `json:"stt_provider" mapstructure:"stt_provider"`
+	// Voice (optional) voice name understood by the TTS provider
+	Voice string `json:"voice" mapstructure:"voice"`
+	// TTSModel (optional) model used by the TTS provider
+	TTSModel string `json:"tts_model" mapstructure:"tts_model"`
+	// STTModel (optional) model used by the STT provider
+	STTModel string `json:"stt_model" mapstructure:"stt_model"`
+	// Format (optional) audio format of synthesized speech, e.g. mp3
+	Format string `json:"format" mapstructure:"format"`
+	// Speed (optional) speaking rate, 1 is normal
+	Speed float64 `json:"speed" mapstructure:"speed"`
+	// Language (optional) BCP-47 language hint, e.g. zh-CN
+	Language string `json:"language" mapstructure:"language"`
+}
+
+// IsAnonymous reports whether the user is the free-tier identity shared by
+// every anonymous caller, whose name is chosen by the client.
+func (c *UserConfig) IsAnonymous() bool {
+	return c.Token == FreetierUserToken
 }
 
 // Valid valid and fill default values
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// Model tier names used by the default tiers.
+const (
+	ModelTierFree      = "free"
+	ModelTierStandard  = "standard"
+	ModelTierExpensive = "expensive"
+)
+
+const (
+	// modelTierBurstRatio is the default bucket capacity over the cost,
+	// so a user can send a couple of requests back to back.
+	modelTierBurstRatio = 1.3
+	// modelTierIPBurstRatio is the default per-IP capacity over the
+	// per-user one, a few users may share one NAT address.
+	modelTierIPBurstRatio = 3
+	// defaultFreeModelCost is the cost of one request to a free model.
+	defaultFreeModelCost = 3
+)
+
+// defaultFreeModels are cheap enough to be served to every user.
+var defaultFreeModels = []string{
+	"gpt-3.5-turbo",
+	"gpt-4o-mini",
+	"gpt-5.4-nano",
+	"openai/gpt-oss-20b",
+	"openai/gpt-oss-120b",
+	"deepseek-v4-flash",
+	"gemma2-9b-it",
+	"gemma-3-27b-it",
+	"llama3-8b-8192",
+	"llama3-70b-8192",
+	"llama-3.1-8b-instant",
+	"llama-3.1-405b-instruct",
+	"llama-3.3-70b-versatile",
+	"qwen-qwq-32b",
+	"qwen/qwen3-32b",
+	"tts",
+	"gemini-3.1-flash-lite-preview",
+	"gemini-2.0-flash",
+}
+
+// ModelTier groups models that share a rate limit for users without
+// unlimited access. A request takes Cost tokens from a bucket of Burst
+// tokens refilled by RefillPerSec, one bucket per user and one per client
+// IP in each tier.
+type ModelTier struct {
+	// Name (required) tier name, e.g. free, standard or expensive
+	Name string `json:"name" mapstructure:"name"`
+	// Models (required) models of this tier, a trailing `*` matches by prefix.
+	// A tier listing `*` catches every model not matched by other tiers.
+	Models []string `json:"models" mapstructure:"models"`
+	// Cost (optional) tokens taken per request, <=0 means unlimited
+	Cost int `json:"cost" mapstructure:"cost"`
+	// Burst (optional) per-user bucket capacity, default is 1.3 * cost
+	Burst int `json:"burst" mapstructure:"burst"`
+	// IPBurst (optional) per-IP bucket capacity, default is 3 * burst
+	IPBurst int `json:"ip_burst" mapstructure:"ip_burst"`
+	// RefillPerSec (optional) tokens refilled per second, default is 1
+	RefillPerSec int `json:"refill_per_sec" mapstructure:"refill_per_sec"`
+}
+
+// Match reports whether model belongs to the tier, ignoring the catch-all.
+func (t *ModelTier) Match(model string) bool {
+	for _, m := range t.Models {
+		switch {
+		case m == "*":
+		case strings.HasSuffix(m, "*"):
+			if strings.HasPrefix(model, strings.TrimSuffix(m, "*")) {
+				return true
+			}
+		case m == model:
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ModelTier) isCatchAll() bool {
+	for _, m := range t.Models {
+		if m == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultModelTiers caches the normalized default tiers by the expensive
+// cost, since ModelTierOf runs on every request.
+var defaultModelTiers sync.Map // map[int][]ModelTier
+
+// DefaultModelTiers returns the tiers used when none is configured: the
+// free models, and every other model as expensive.
+func DefaultModelTiers(expensiveCost int) []ModelTier {
+	return []ModelTier{
+		{Name: ModelTierFree, Models: defaultFreeModels, Cost: defaultFreeModelCost},
+		{Name: ModelTierExpensive, Models: []string{"*"}, Cost: expensiveCost},
+	}
+}
+
+// ModelTierOf returns the tier of model, or nil if no tier applies.
+func (c *OpenAI) ModelTierOf(model string) *ModelTier {
+	tiers := c.ModelTiers
+	if len(tiers) == 0 {
+		cost := c.RateLimitExpensiveModelsIntervalSeconds
+		cached, ok := defaultModelTiers.Load(cost)
+		if !ok {
+			cached, _ = defaultModelTiers.LoadOrStore(cost, normalizeModelTiers(DefaultModelTiers(cost)))
+		}
+		tiers = cached.([]ModelTier) //nolint:forcetypeassert // only []ModelTier is stored
+	}
+
+	var catchAll *ModelTier
+	for i := range tiers {
+		if tiers[i].Match(model) {
+			return &tiers[i]
+		}
+		if catchAll == nil && tiers[i].isCatchAll() {
+			catchAll = &tiers[i]
+		}
+	}
+	return catchAll
+}
+
+// validModelTiers checks configured tiers.
+func validModelTiers(tiers []ModelTier) error {
+	names := map[string]bool{}
+	for _, t := range tiers {
+		if t.Name == "" {
+			return errors.New("model tier name is empty")
+		}
+		if names[t.Name] {
+			return errors.Errorf("duplicate model tier %q", t.Name)
+		}
+		names[t.Name] = true
+
+		if len(t.Models) == 0 {
+			return errors.Errorf("model tier %q has no models", t.Name)
+		}
+		if t.Cost > 0 && t.Burst > 0 && t.Burst < t.Cost {
+			return errors.Errorf("burst of model tier %q should not be less than cost %d", t.Name, t.Cost)
+		}
+	}
+	return nil
+}
+
+// normalizeModelTiers fills default burst and refill of each tier.
+func normalizeModelTiers(tiers []ModelTier) []ModelTier {
+	for i := range tiers {
+		t := &tiers[i]
+		if t.Cost <= 0 {
+			continue
+		}
+		if t.Burst <= 0 {
+			t.Burst = int(math.Ceil(float64(t.Cost) * modelTierBurstRatio))
+		}
+		if t.IPBurst <= 0 {
+			t.IPBurst = t.Burst * modelTierIPBurstRatio
+		}
+		if t.RefillPerSec <= 0 {
+			t.RefillPerSec = 1
+		}
+	}
+	return tiers
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelTierOf(t *testing.T) {
+	c := &OpenAI{RateLimitExpensiveModelsIntervalSeconds: 600}
+
+	tier := c.ModelTierOf("gpt-4o-mini")
+	require.Equal(t, ModelTierFree, tier.Name)
+	require.Equal(t, 3, tier.Cost)
+	require.Equal(t, 4, tier.Burst)
+	require.Equal(t, 12, tier.IPBurst)
+
+	tier = c.ModelTierOf("gpt-5")
+	require.Equal(t, ModelTierExpensive, tier.Name)
+	require.Equal(t, 600, tier.Cost)
+	require.Equal(t, 780, tier.Burst)
+	require.Same(t, tier, c.ModelTierOf("o3"), "default tiers are built once")
+	require.Equal(t, 60, (&OpenAI{RateLimitExpensiveModelsIntervalSeconds: 60}).ModelTierOf("o3").Cost)
+
+	c.ModelTiers = normalizeModelTiers([]ModelTier{
+		{Name: ModelTierExpensive, Models: []string{"*"}, Cost: 100},
+		{Name: ModelTierStandard, Models: []string{"claude-*", "gpt-4.1"}, Cost: 10, Burst: 30},
+		{Name: ModelTierFree, Models: []string{"gpt-4o-mini"}},
+	})
+	require.Equal(t, ModelTierStandard, c.ModelTierOf("claude-sonnet").Name,
+		"catch-all only applies when no other tier matches")
+	require.Equal(t, ModelTierStandard, c.ModelTierOf("gpt-4.1").Name)
+	require.Equal(t, 30, c.ModelTierOf("gpt-4.1").Burst)
+	require.Equal(t, ModelTierExpensive, c.ModelTierOf("o3").Name)
+	require.Zero(t, c.ModelTierOf("gpt-4o-mini").Cost, "zero cost is unlimited")
+
+	c.ModelTiers = []ModelTier{{Name: ModelTierFree, Models: []string{"gpt-4o-mini"}, Cost: 1}}
+	require.Nil(t, c.ModelTierOf("o3"))
+}
+
+func TestValidModelTiers(t *testing.T) {
+	require.NoError(t, validModelTiers(DefaultModelTiers(600)))
+	require.ErrorContains(t, validModelTiers([]ModelTier{{Models: []string{"*"}}}), "name is empty")
+	require.ErrorContains(t, validModelTiers([]ModelTier{
+		{Name: "a", Models: []string{"x"}}, {Name: "a", Models: []string{"y"}},
+	}), "duplicate")
+	require.ErrorContains(t, validModelTiers([]ModelTier{{Name: "a"}}), "no models")
+	require.ErrorContains(t, validModelTiers([]ModelTier{
+		{Name: "a", Models: []string{"x"}, Cost: 10, Burst: 5},
+	}), "less than cost")
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+)
+
+// Moderation detectors.
+const (
+	ModerationDetectorEmail      = "email"
+	ModerationDetectorPhone      = "phone"
+	ModerationDetectorAPIKey     = "api_key"
+	ModerationDetectorIDNumber   = "id_number"
+	ModerationDetectorDictionary = "dictionary"
+	ModerationDetectorLLM        = "llm"
+)
+
+// Moderation actions.
+const (
+	// ModerationActionBlock rejects the request.
+	ModerationActionBlock = "block"
+	// ModerationActionMaskUpstream masks findings before the request is sent
+	// upstream, and so also before it is persisted.
+	ModerationActionMaskUpstream = "mask_upstream"
+	// ModerationActionMaskPersist sends findings upstream as is, but masks
+	// them before the conversation is persisted.
+	ModerationActionMaskPersist = "mask_persist"
+)
+
+var (
+	moderationDetectors = []string{
+		ModerationDetectorEmail,
+		ModerationDetectorPhone,
+		ModerationDetectorAPIKey,
+		ModerationDetectorIDNumber,
+		ModerationDetectorDictionary,
+		ModerationDetectorLLM,
+	}
+	moderationActions = []string{
+		ModerationActionBlock,
+		ModerationActionMaskUpstream,
+		ModerationActionMaskPersist,
+	}
+)
+
+// ModerationConfig configures the moderation stage between the chat
+// request and the upstream.
+type ModerationConfig struct {
+	// Enabled turns the moderation stage on
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Rules (optional) detectors and what to do with their findings,
+	// default masks api keys before upstream and other PII before persist
+	Rules []ModerationRule `json:"rules" mapstructure:"rules"`
+	// Dictionary (optional) words matched case-insensitively by the
+	// dictionary detector
+	Dictionary []string `json:"dictionary" mapstructure:"dictionary"`
+	// LLM (optional) configures the llm classifier
+	LLM ModerationLLMConfig `json:"llm" mapstructure:"llm"`
+	// ScrubToolResults masks findings of block and mask_upstream rules in
+	// agent tool results, e.g. fetched web pages
+	ScrubToolResults bool `json:"scrub_tool_results" mapstructure:"scrub_tool_results"`
+}
+
+// ModerationRule applies an action to the findings of a detector.
+type ModerationRule struct {
+	// Detector (required) one of email, phone, api_key, id_number,
+	// dictionary or llm
+	Detector string `json:"detector" mapstructure:"detector"`
+	// Action (required) one of block, mask_upstream or mask_persist,
+	// the llm detector only supports block
+	Action string `json:"action" mapstructure:"action"`
+}
+
+// ModerationLLMConfig configures the llm classifier, which checks the
+// latest user message.
+type ModerationLLMConfig struct {
+	// Model (optional) classifier model, default is openai/gpt-oss-120b
+	Model string `json:"model" mapstructure:"model"`
+	// Categories (optional) categories to flag
+	Categories []string `json:"categories" mapstructure:"categories"`
+	// TimeoutSeconds (optional) classifier timeout, default is 10.
+	// A failed classification lets the request through.
+	TimeoutSeconds int `json:"timeout_seconds" mapstructure:"timeout_seconds"`
+}
+
+// defaultModerationRules mask secrets before upstream and other PII
+// before persist.
+func defaultModerationRules() []ModerationRule {
+	return []ModerationRule{
+		{Detector: ModerationDetectorAPIKey, Action: ModerationActionMaskUpstream},
+		{Detector: ModerationDetectorEmail, Action: ModerationActionMaskPersist},
+		{Detector: ModerationDetectorPhone, Action: ModerationActionMaskPersist},
+		{Detector: ModerationDetectorIDNumber, Action: ModerationActionMaskPersist},
+	}
+}
+
+// normalizeModeration fills defaults and validates cfg.
+func normalizeModeration(cfg *ModerationConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	if len(cfg.Rules) == 0 {
+		cfg.Rules = defaultModerationRules()
+	}
+	cfg.LLM.Model = strings.TrimSpace(gutils.OptionalVal(&cfg.LLM.Model, "openai/gpt-oss-120b"))
+	cfg.LLM.TimeoutSeconds = gutils.OptionalVal(&cfg.LLM.TimeoutSeconds, 10)
+
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		r.Detector = strings.ToLower(strings.TrimSpace(r.Detector))
+		r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+
+		switch {
+		case !slices.Contains(moderationDetectors, r.Detector):
+			return errors.Errorf("rules[%d]: unknown detector %q, should be one of %v",
+				i, r.Detector, moderationDetectors)
+		case !slices.Contains(moderationActions, r.Action):
+			return errors.Errorf("rules[%d]: unknown action %q, should be one of %v",
+				i, r.Action, moderationActions)
+		case r.Detector == ModerationDetectorLLM && r.Action != ModerationActionBlock:
+			return errors.Errorf("rules[%d]: llm detector only supports action block", i)
+		case r.Detector == ModerationDetectorDictionary && len(cfg.Dictionary) == 0:
+			return errors.Errorf("rules[%d]: dictionary detector needs a dictionary", i)
+		}
+	}
+
+	return nil
+}
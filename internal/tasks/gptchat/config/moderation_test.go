@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeModeration(t *testing.T) {
+	cfg := &ModerationConfig{}
+	require.NoError(t, normalizeModeration(cfg))
+	require.Empty(t, cfg.Rules, "disabled moderation is left alone")
+
+	cfg = &ModerationConfig{Enabled: true}
+	require.NoError(t, normalizeModeration(cfg))
+	require.Equal(t, defaultModerationRules(), cfg.Rules)
+	require.Equal(t, "openai/gpt-oss-120b", cfg.LLM.Model)
+	require.Equal(t, 10, cfg.LLM.TimeoutSeconds)
+
+	cfg = &ModerationConfig{Enabled: true, Rules: []ModerationRule{{Detector: " Email ", Action: "BLOCK"}}}
+	require.NoError(t, normalizeModeration(cfg))
+	require.Equal(t, ModerationRule{Detector: "email", Action: "block"}, cfg.Rules[0])
+
+	for _, rule := range []ModerationRule{
+		{Detector: "ssn", Action: "block"},
+		{Detector: "email", Action: "drop"},
+		{Detector: "llm", Action: "mask_persist"},
+		{Detector: "dictionary", Action: "block"},
+	} {
+		cfg = &ModerationConfig{Enabled: true, Rules: []ModerationRule{rule}}
+		require.Error(t, normalizeModeration(cfg), "%+v", rule)
+	}
+}
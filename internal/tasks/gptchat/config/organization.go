@@ -0,0 +1,134 @@
+package config
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// Organization member roles, from the most to the least privileged.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// OrgRoles lists the valid member roles.
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleMember}
+
+const (
+	// defaultOrgQuotaWindowMinutes is the default rolling window of an
+	// organization's token quota.
+	defaultOrgQuotaWindowMinutes = 60 * 24
+)
+
+var orgIDRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// OrganizationConfig is a team of users sharing a token quota, a model
+// allowlist, a memory project and a curated MCP server. Members are
+// managed through the organization admin API, Owners are always members.
+type OrganizationConfig struct {
+	// ID (required) lowercase letters, digits, `-` and `_`
+	ID string `json:"id" mapstructure:"id"`
+	// Name (optional) display name, default to ID
+	Name string `json:"name" mapstructure:"name"`
+	// Owners (required) usernames always owning the organization,
+	// they can not be removed through the admin API
+	Owners []string `json:"owners" mapstructure:"owners"`
+	// AllowedModels (optional) models every member may call, in addition
+	// to their own
+	AllowedModels []string `json:"allowed_models" mapstructure:"allowed_models"`
+	// TokenQuota (optional) token quota shared by all members
+	TokenQuota OrgTokenQuota `json:"token_quota" mapstructure:"token_quota"`
+	// MemoryProject (optional) memory project namespace of the members,
+	// default to `<openai.memory_project>-org-<id>`
+	MemoryProject string `json:"memory_project" mapstructure:"memory_project"`
+	// MCPServer (optional) curated MCP server url of the members' agent
+	// runs, default to `openai.agent_loop.mcp_server`
+	MCPServer string `json:"mcp_server" mapstructure:"mcp_server"`
+}
+
+// OrgTokenQuota is a token budget shared by an organization.
+type OrgTokenQuota struct {
+	// Limit (optional) tokens per window, <=0 means unlimited
+	Limit int `json:"limit" mapstructure:"limit"`
+	// WindowMinutes (optional) rolling window, default to one day
+	WindowMinutes int `json:"window_minutes" mapstructure:"window_minutes"`
+}
+
+// Organization returns the organization of id, nil if unknown.
+func (c *OpenAI) Organization(id string) *OrganizationConfig {
+	if c == nil || id == "" {
+		return nil
+	}
+	for _, org := range c.Organizations {
+		if org.ID == id {
+			return org
+		}
+	}
+	return nil
+}
+
+// OrgRoleAtLeast reports whether role is as privileged as want.
+func OrgRoleAtLeast(role, want string) bool {
+	i, j := slices.Index(OrgRoles, role), slices.Index(OrgRoles, want)
+	return i >= 0 && j >= 0 && i <= j
+}
+
+// normalizeOrganizations validates orgs and fills their defaults.
+func normalizeOrganizations(orgs []*OrganizationConfig, memoryProject string) error {
+	seen := map[string]bool{}
+	owners := map[string]string{}
+	for i, org := range orgs {
+		if org == nil {
+			return errors.Errorf("organizations[%d] is empty", i)
+		}
+
+		org.ID = strings.TrimSpace(org.ID)
+		if !orgIDRegexp.MatchString(org.ID) {
+			return errors.Errorf("organizations[%d].id %q should match %s", i, org.ID, orgIDRegexp)
+		}
+		if seen[org.ID] {
+			return errors.Errorf("duplicate organization %q", org.ID)
+		}
+		seen[org.ID] = true
+
+		org.Name = strings.TrimSpace(org.Name)
+		if org.Name == "" {
+			org.Name = org.ID
+		}
+
+		if len(org.Owners) == 0 {
+			return errors.Errorf("organization %q has no owners", org.ID)
+		}
+		for j, owner := range org.Owners {
+			owner = strings.TrimSpace(owner)
+			if owner == "" {
+				return errors.Errorf("organization %q has an empty owner", org.ID)
+			}
+			// a user belongs to one organization at most
+			if other, ok := owners[owner]; ok {
+				return errors.Errorf("user %q owns both %q and %q", owner, other, org.ID)
+			}
+			owners[owner] = org.ID
+			org.Owners[j] = owner
+		}
+
+		if org.TokenQuota.WindowMinutes <= 0 {
+			org.TokenQuota.WindowMinutes = defaultOrgQuotaWindowMinutes
+		}
+		org.MemoryProject = strings.TrimSpace(org.MemoryProject)
+		if org.MemoryProject == "" {
+			org.MemoryProject = memoryProject + "-org-" + org.ID
+		}
+		org.MCPServer = strings.TrimRight(strings.TrimSpace(org.MCPServer), "/")
+		if org.MCPServer != "" &&
+			!strings.HasPrefix(org.MCPServer, "http://") && !strings.HasPrefix(org.MCPServer, "https://") {
+			return errors.Errorf("organization %q mcp_server should be an url", org.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeOrganizations(t *testing.T) {
+	orgs := []*OrganizationConfig{
+		{ID: "acme", Owners: []string{" alice "}, MCPServer: "https://mcp.acme.test/"},
+		{ID: "globex", Name: "Globex", Owners: []string{"hank"}, MemoryProject: "globex-mem",
+			TokenQuota: OrgTokenQuota{Limit: 1000, WindowMinutes: 10}},
+	}
+	require.NoError(t, normalizeOrganizations(orgs, "gptchat"))
+	require.Equal(t, "acme", orgs[0].Name)
+	require.Equal(t, []string{"alice"}, orgs[0].Owners)
+	require.Equal(t, "gptchat-org-acme", orgs[0].MemoryProject)
+	require.Equal(t, 60*24, orgs[0].TokenQuota.WindowMinutes)
+	require.Equal(t, "https://mcp.acme.test", orgs[0].MCPServer)
+	require.Equal(t, "globex-mem", orgs[1].MemoryProject)
+	require.Equal(t, 10, orgs[1].TokenQuota.WindowMinutes)
+
+	conf := &OpenAI{Organizations: orgs}
+	require.Same(t, orgs[1], conf.Organization("globex"))
+	require.Nil(t, conf.Organization("initech"))
+	require.Nil(t, conf.Organization(""))
+
+	for name, bad := range map[string][]*OrganizationConfig{
+		"bad id":       {{ID: "Acme Corp", Owners: []string{"alice"}}},
+		"no owner":     {{ID: "acme"}},
+		"duplicate id": {{ID: "acme", Owners: []string{"alice"}}, {ID: "acme", Owners: []string{"bob"}}},
+		"two orgs":     {{ID: "acme", Owners: []string{"alice"}}, {ID: "globex", Owners: []string{"alice"}}},
+		"mcp alias":    {{ID: "acme", Owners: []string{"alice"}, MCPServer: "laisky"}},
+	} {
+		require.Error(t, normalizeOrganizations(bad, "gptchat"), name)
+	}
+}
+
+func TestOrgRoleAtLeast(t *testing.T) {
+	require.True(t, OrgRoleAtLeast(OrgRoleOwner, OrgRoleAdmin))
+	require.True(t, OrgRoleAtLeast(OrgRoleAdmin, OrgRoleAdmin))
+	require.False(t, OrgRoleAtLeast(OrgRoleMember, OrgRoleAdmin))
+	require.False(t, OrgRoleAtLeast("", OrgRoleMember))
+}
@@ -1,7 +1,11 @@
 // package db is a package for database
 package db
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // OpenaiMessage message from openai
 type OpenaiMessage struct {
@@ -47,6 +51,7 @@ const (
 	PriceTxt2ImageSchnell        Price = 1500       // 0.003 usd
 	PriceUploadFile              Price = 2500       // 0.005 usd
 	PriceTTS                     Price = 20         // 0.00004 usd
+	PriceChatPer1KTokens         Price = 1000       // 0.002 usd
 	PriceUSD                     Price = 500000     // 1 usd
 	PriceRMB                     Price = 500000 / 8 // 1 rmb
 )
@@ -66,3 +71,135 @@ type Billing struct {
 	// UsedQuota how many quotes used totally, 1usd = 500000 quotes
 	UsedQuota Price `bson:"used_quota" json:"used_quota"`
 }
+
+// CreditEntryType kind of a credit ledger entry
+type CreditEntryType string
+
+const (
+	// CreditTopUp credit bought by the user
+	CreditTopUp CreditEntryType = "topup"
+	// CreditDebit credit spent on chat, image, tts or upload
+	CreditDebit CreditEntryType = "debit"
+	// CreditRefund credit returned to the user, or taken back when a
+	// top-up payment is refunded
+	CreditRefund CreditEntryType = "refund"
+)
+
+// CreditEntry one immutable row of the prepaid credit ledger
+type CreditEntry struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Username string             `bson:"username"      json:"username"`
+	Type     CreditEntryType    `bson:"type"          json:"type"`
+	// Amount signed quota change, positive adds credit, 1usd = 500000 quotes
+	Amount Price  `bson:"amount" json:"amount"`
+	Reason string `bson:"reason" json:"reason"`
+	// IdempotencyKey is unique across the ledger, an entry with a known key
+	// is never applied twice
+	IdempotencyKey string    `bson:"idempotency_key" json:"idempotency_key"`
+	CreatedAt      time.Time `bson:"created_at"      json:"created_at"`
+	// Pending is set until the entry has moved the balance
+	Pending bool `bson:"pending,omitempty" json:"-"`
+}
+
+// CreditBalance current prepaid credit of a user
+type CreditBalance struct {
+	Username  string    `bson:"username"   json:"username"`
+	Balance   Price     `bson:"balance"    json:"balance"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	// PendingKeys are the idempotency keys of pending entries that already
+	// moved the balance
+	PendingKeys []string `bson:"pending_keys,omitempty" json:"-"`
+}
+
+// APIKey is a user minted api key. Only the hash of the secret is stored.
+type APIKey struct {
+	ID       string `bson:"_id"      json:"id"`
+	Username string `bson:"username" json:"username"`
+	Name     string `bson:"name"     json:"name"`
+	// Hint is the last characters of the secret, to tell keys apart
+	Hint       string `bson:"hint"        json:"hint"`
+	SecretHash string `bson:"secret_hash" json:"-"`
+	// Scopes the key may use, empty means every scope
+	Scopes []string `bson:"scopes" json:"scopes"`
+	// AllowedModels narrows the owner's models, empty means the owner's
+	AllowedModels []string `bson:"allowed_models" json:"allowed_models"`
+	// SpendCap <=0 means unlimited
+	SpendCap    Price       `bson:"spend_cap"              json:"spend_cap"`
+	Spent       Price       `bson:"spent"                  json:"spent"`
+	Owner       APIKeyOwner `bson:"owner"                  json:"-"`
+	ExpiresAt   time.Time   `bson:"expires_at,omitempty"   json:"expires_at,omitempty"`
+	RevokedAt   time.Time   `bson:"revoked_at,omitempty"   json:"revoked_at,omitempty"`
+	RotatedFrom string      `bson:"rotated_from,omitempty" json:"rotated_from,omitempty"`
+	CreatedAt   time.Time   `bson:"created_at"             json:"created_at"`
+}
+
+// APIKeyOwner is the sso identity of a key's owner that is not in the
+// static user list. The owner is resolved by the current sso rules on
+// every use, so a user dropped from them loses their keys.
+type APIKeyOwner struct {
+	SSOSubject string   `bson:"sso_subject,omitempty" json:"sso_subject,omitempty"`
+	SSOEmail   string   `bson:"sso_email,omitempty"   json:"sso_email,omitempty"`
+	SSOGroups  []string `bson:"sso_groups,omitempty"  json:"sso_groups,omitempty"`
+}
+
+// OrgMember is a user's membership of an organization. Owners listed in
+// the organization's settings are members without a document.
+type OrgMember struct {
+	// Username is the document id, a user belongs to one organization at most
+	Username  string    `bson:"_id"        json:"username"`
+	OrgID     string    `bson:"org_id"     json:"org_id"`
+	Role      string    `bson:"role"       json:"role"`
+	AddedBy   string    `bson:"added_by"   json:"added_by"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	// AcceptedAt is zero while the membership is an invite the user has
+	// not accepted, which grants nothing
+	AcceptedAt time.Time `bson:"accepted_at,omitempty" json:"accepted_at,omitempty"`
+	// Pending is set in responses for invites not accepted yet
+	Pending bool `bson:"-" json:"pending,omitempty"`
+}
+
+// Usage event kinds
+const (
+	UsageKindChat  = "chat"
+	UsageKindImage = "image"
+	UsageKindAgent = "agent"
+	// UsageKindEmbedding is an embeddings request of the OpenAI-compatible gateway
+	UsageKindEmbedding = "embedding"
+)
+
+// UsageEvent is one request recorded for usage analytics
+type UsageEvent struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Username string             `bson:"username"      json:"username"`
+	OrgID    string             `bson:"org_id"        json:"org_id,omitempty"`
+	Model    string             `bson:"model"         json:"model"`
+	// Kind is one of the UsageKind constants
+	Kind             string `bson:"kind"              json:"kind"`
+	PromptTokens     int    `bson:"prompt_tokens"     json:"prompt_tokens"`
+	CompletionTokens int    `bson:"completion_tokens" json:"completion_tokens"`
+	ReasoningTokens  int    `bson:"reasoning_tokens"  json:"reasoning_tokens"`
+	ToolCalls        int    `bson:"tool_calls"        json:"tool_calls"`
+	// Cost is the estimated price of the request
+	Cost      Price     `bson:"cost"       json:"cost"`
+	Error     bool      `bson:"error"      json:"error"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// UsageDaily is the usage of one user and model on one UTC day
+type UsageDaily struct {
+	// ID is `<day>|<username>|<model>`
+	ID               string    `bson:"_id"               json:"-"`
+	Day              string    `bson:"day"               json:"day"`
+	Username         string    `bson:"username"          json:"username"`
+	OrgID            string    `bson:"org_id"            json:"org_id,omitempty"`
+	Model            string    `bson:"model"             json:"model"`
+	Requests         int64     `bson:"requests"          json:"requests"`
+	Errors           int64     `bson:"errors"            json:"errors"`
+	PromptTokens     int64     `bson:"prompt_tokens"     json:"prompt_tokens"`
+	CompletionTokens int64     `bson:"completion_tokens" json:"completion_tokens"`
+	ReasoningTokens  int64     `bson:"reasoning_tokens"  json:"reasoning_tokens"`
+	ToolCalls        int64     `bson:"tool_calls"        json:"tool_calls"`
+	Cost             Price     `bson:"cost"              json:"cost"`
+	UpdatedAt        time.Time `bson:"updated_at"        json:"updated_at"`
+}
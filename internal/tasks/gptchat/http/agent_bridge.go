@@ -126,15 +126,9 @@ func ExecuteToolCallCtx(
 	// Rate limit MCP tools for freetier users.
 	// Only applies to users whose API key begins with "FREETIER-".
 	if strings.HasPrefix(deps.RawUserToken, "FREETIER-") {
-		if expensiveModelRateLimiter == nil {
-			onceLimiter.Do(setupRateLimiter)
-		}
-		ratelimitCost := config.Config.RateLimitExpensiveModelsIntervalSeconds
-		if ratelimitCost <= 0 {
-			ratelimitCost = 600
-		}
-		if !expensiveModelRateLimiter.AllowN(ratelimitCost) {
-			return "", "", errors.New("MCP tools are rate limited for freetier users; please try again later")
+		if err := checkModelRateLimit(ctx, user, rateLimitModelMCPTool,
+			"MCP tools are rate limited for freetier users; please try again in %d seconds"); err != nil {
+			return "", "", errors.WithStack(err)
 		}
 	}
 
@@ -0,0 +1,688 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/copier"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+	"github.com/Laisky/go-ramjet/internal/tasks/sso"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+// Scopes of managed api keys.
+const (
+	ScopeChat  = "chat"
+	ScopeImage = "image"
+	ScopeTTS   = "tts"
+	ScopeAgent = "agent"
+	ScopeFiles = "files"
+)
+
+var apiKeyScopes = []string{ScopeChat, ScopeImage, ScopeTTS, ScopeAgent, ScopeFiles}
+
+const (
+	// apiKeyPrefix marks managed api keys, it must not collide with the
+	// prefixes handled by getUserByToken.
+	apiKeyPrefix = "ramjet-"
+	apiKeyCol    = "api_keys"
+	// apiKeyCacheTTL bounds how long a revoked key keeps working on other
+	// instances.
+	apiKeyCacheTTL = 30 * time.Second
+	// maxAPIKeysPerUser caps active keys of one user.
+	maxAPIKeysPerUser = 50
+)
+
+var (
+	// ErrAPIKeyNotFound is returned for unknown keys.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrAPIKeySpendCap is returned when a charge exceeds the key's spend cap.
+	ErrAPIKeySpendCap = errors.New("api key spend cap reached")
+)
+
+// scopeError rejects a managed api key used outside its scopes.
+type scopeError struct{ scope string }
+
+func (e *scopeError) Error() string {
+	return "api key lacks scope " + e.scope
+}
+
+// HTTPStatus returns 403.
+func (e *scopeError) HTTPStatus() int {
+	return http.StatusForbidden
+}
+
+// apiKeyStore keeps managed api keys.
+type apiKeyStore interface {
+	// Insert stores a new key.
+	Insert(ctx context.Context, key *db.APIKey) error
+	// GetByHash finds a key by its secret hash, ErrAPIKeyNotFound if unknown.
+	GetByHash(ctx context.Context, secretHash string) (*db.APIKey, error)
+	// Get finds a key of username by id, ErrAPIKeyNotFound if unknown.
+	Get(ctx context.Context, username, id string) (*db.APIKey, error)
+	// List returns the keys of username, newest first.
+	List(ctx context.Context, username string) ([]db.APIKey, error)
+	// Revoke marks a key of username revoked.
+	Revoke(ctx context.Context, username, id string, at time.Time) error
+	// AddSpend adds cost to the key's spend and returns the updated key,
+	// failing with ErrAPIKeySpendCap if it would pass the cap, unless
+	// allowOverdraft.
+	AddSpend(ctx context.Context, id string, cost db.Price, allowOverdraft bool) (*db.APIKey, error)
+}
+
+var (
+	apiKeyCache = gutils.NewExpCache[*db.APIKey](context.Background(), apiKeyCacheTTL)
+
+	mongoAPIKeyStoreOnce sync.Once
+	mongoAPIKeyStoreIns  *mongoAPIKeyStore
+	mongoAPIKeyStoreErr  error
+)
+
+// newAPIKeyStore returns the key store in use. It is a variable so tests
+// can replace mongo.
+var newAPIKeyStore = func() (apiKeyStore, error) {
+	mongoAPIKeyStoreOnce.Do(func() {
+		mongoAPIKeyStoreIns, mongoAPIKeyStoreErr = newMongoAPIKeyStore()
+	})
+	return mongoAPIKeyStoreIns, mongoAPIKeyStoreErr
+}
+
+type mongoAPIKeyStore struct {
+	col *mongo.Collection
+}
+
+//nolint:contextcheck // indexes are created once, detached from any request
+func newMongoAPIKeyStore() (*mongoAPIKeyStore, error) {
+	openaiDB, err := db.GetOpenaiDB()
+	if err != nil {
+		return nil, errors.Wrap(err, "get openai db")
+	}
+
+	s := &mongoAPIKeyStore{col: openaiDB.GetCol(apiKeyCol)}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if _, err = s.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "secret_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "username", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	}); err != nil {
+		return nil, errors.Wrap(err, "create api key indexes")
+	}
+
+	return s, nil
+}
+
+// Insert implements apiKeyStore.
+func (s *mongoAPIKeyStore) Insert(ctx context.Context, key *db.APIKey) error {
+	_, err := s.col.InsertOne(ctx, key)
+	return errors.Wrap(err, "insert api key")
+}
+
+// GetByHash implements apiKeyStore.
+func (s *mongoAPIKeyStore) GetByHash(ctx context.Context, secretHash string) (*db.APIKey, error) {
+	return s.findOne(ctx, bson.M{"secret_hash": secretHash})
+}
+
+// Get implements apiKeyStore.
+func (s *mongoAPIKeyStore) Get(ctx context.Context, username, id string) (*db.APIKey, error) {
+	return s.findOne(ctx, bson.M{"_id": id, "username": username})
+}
+
+func (s *mongoAPIKeyStore) findOne(ctx context.Context, filter bson.M) (*db.APIKey, error) {
+	key := new(db.APIKey)
+	if err := s.col.FindOne(ctx, filter).Decode(key); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, errors.Wrap(err, "find api key")
+	}
+
+	return key, nil
+}
+
+// List implements apiKeyStore.
+func (s *mongoAPIKeyStore) List(ctx context.Context, username string) ([]db.APIKey, error) {
+	cur, err := s.col.Find(ctx, bson.M{"username": username},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "find api keys of %q", username)
+	}
+
+	var keys []db.APIKey
+	if err = cur.All(ctx, &keys); err != nil {
+		return nil, errors.Wrap(err, "decode api keys")
+	}
+	return keys, nil
+}
+
+// Revoke implements apiKeyStore.
+func (s *mongoAPIKeyStore) Revoke(ctx context.Context, username, id string, at time.Time) error {
+	res, err := s.col.UpdateOne(ctx,
+		bson.M{"_id": id, "username": username},
+		bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return errors.Wrapf(err, "revoke api key %q", id)
+	}
+	if res.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AddSpend implements apiKeyStore.
+func (s *mongoAPIKeyStore) AddSpend(ctx context.Context,
+	id string, cost db.Price, allowOverdraft bool) (*db.APIKey, error) {
+	filter := bson.M{"_id": id}
+	if !allowOverdraft {
+		// keys without a cap always match, capped keys only while the cost fits
+		filter["$expr"] = bson.M{"$or": bson.A{
+			bson.M{"$lte": bson.A{"$spend_cap", 0}},
+			bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$spent", cost}}, "$spend_cap"}},
+		}}
+	}
+
+	key := new(db.APIKey)
+	if err := s.col.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"spent": cost}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(key); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAPIKeySpendCap
+		}
+		return nil, errors.Wrapf(err, "add spend to api key %q", id)
+	}
+	return key, nil
+}
+
+// hashAPIKey hashes a secret. The secrets are random, so a fast hash is
+// enough and keeps the lookup cheap.
+func hashAPIKey(secret string) string {
+	hashed := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hashed[:])
+}
+
+// newAPIKeySecret returns a random secret with apiKeyPrefix.
+func newAPIKeySecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// getUserByAPIKey resolves a managed api key to its owner, narrowed by
+// the key's scopes and models.
+func getUserByAPIKey(ctx context.Context, secret string) (*config.UserConfig, error) {
+	secretHash := hashAPIKey(secret)
+	key, ok := apiKeyCache.Load(secretHash)
+	if !ok {
+		store, err := newAPIKeyStore()
+		if err != nil {
+			return nil, errors.Wrap(err, "get api key store")
+		}
+		if key, err = store.GetByHash(ctx, secretHash); err != nil {
+			return nil, errors.Wrap(err, "get api key")
+		}
+		apiKeyCache.Store(secretHash, key)
+	}
+
+	now := time.Now()
+	switch {
+	case !key.RevokedAt.IsZero():
+		return nil, errors.Errorf("api key %q is revoked", key.ID)
+	case !key.ExpiresAt.IsZero() && now.After(key.ExpiresAt):
+		return nil, errors.Errorf("api key %q is expired", key.ID)
+	case key.SpendCap > 0 && key.Spent >= key.SpendCap:
+		return nil, errors.Wrapf(ErrAPIKeySpendCap, "api key %q", key.ID)
+	}
+
+	user, err := apiKeyOwner(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "get api key owner")
+	}
+	// organization models widen the owner's, before the key narrows them
+	applyOrganization(ctx, user)
+	user.APIKeyID = key.ID
+	user.Scopes = slices.Clone(key.Scopes)
+	user.AllowedModels = narrowModels(ownerModels(user), key.AllowedModels)
+	if err = user.Valid(); err != nil {
+		return nil, errors.Wrap(err, "valid api key user")
+	}
+
+	return user, nil
+}
+
+// apiKeyOwner returns the live owner of key, from the static user list or
+// by the current sso rules. Keys of owners that resolve to neither are
+// rejected.
+func apiKeyOwner(key *db.APIKey) (*config.UserConfig, error) {
+	user := new(config.UserConfig)
+	if owner := staticUserByName(key.Username); owner != nil {
+		if err := copier.CopyWithOption(user, owner, copier.Option{DeepCopy: true}); err != nil {
+			return nil, errors.Wrap(err, "copy owner")
+		}
+		return user, nil
+	}
+
+	if key.Owner.SSOSubject != "" {
+		owner, ok := ssoUserByIdentity(sso.Identity{
+			Subject: key.Owner.SSOSubject,
+			Email:   key.Owner.SSOEmail,
+			Groups:  key.Owner.SSOGroups,
+		})
+		if ok && owner.UserName == key.Username {
+			return owner, nil
+		}
+	}
+
+	return nil, errors.Errorf("owner %q of api key %q no longer exists", key.Username, key.ID)
+}
+
+// staticUserByName finds a paid user of `openai.user_tokens`.
+func staticUserByName(username string) *config.UserConfig {
+	for _, u := range config.Config.UserTokens {
+		if u.UserName == username && u.Token != config.FreetierUserToken {
+			return u
+		}
+	}
+	return nil
+}
+
+// staticUserByToken finds a paid user of `openai.user_tokens`.
+func staticUserByToken(token string) *config.UserConfig {
+	for _, u := range config.Config.UserTokens {
+		if u.Token == token && u.Token != config.FreetierUserToken {
+			return u
+		}
+	}
+	return nil
+}
+
+// ownerModels returns the models a user may call, users skipping the
+// model check may call any.
+func ownerModels(user *config.UserConfig) []string {
+	if user.BYOK || user.NoLimitExpensiveModels || slices.Contains(user.AllowedModels, "*") {
+		return []string{"*"}
+	}
+	return user.AllowedModels
+}
+
+// narrowModels returns the models of keyModels the owner may call, or the
+// owner's models if keyModels is empty.
+func narrowModels(owner, keyModels []string) []string {
+	if len(keyModels) == 0 {
+		return slices.Clone(owner)
+	}
+	if slices.Contains(owner, "*") {
+		return slices.Clone(keyModels)
+	}
+
+	var models []string
+	for _, m := range keyModels {
+		if slices.Contains(owner, m) {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// requireUserScope rejects managed api keys lacking scope.
+func requireUserScope(user *config.UserConfig, scope string) error {
+	if user.HasScope(scope) {
+		return nil
+	}
+	return &scopeError{scope: scope}
+}
+
+// RequireScope is a middleware rejecting managed api keys lacking scope.
+// Other users have every scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, err := getUserByAuthHeader(ctx)
+		if web.AbortErr(ctx, errors.Wrap(err, "get user by auth header")) {
+			return
+		}
+		if web.AbortErr(ctx, requireUserScope(user, scope)) {
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// chargeAPIKeySpend adds cost to the spend of the user's managed api key.
+// It does nothing for other users.
+func chargeAPIKeySpend(ctx context.Context, user *config.UserConfig, cost db.Price, allowOverdraft bool) error {
+	if user.APIKeyID == "" || cost <= 0 {
+		return nil
+	}
+
+	if err := addAPIKeySpend(ctx, user.APIKeyID, cost, allowOverdraft); err != nil {
+		return errors.Wrapf(err, "charge %d to api key %q", cost.Int(), user.APIKeyID)
+	}
+	return nil
+}
+
+// addAPIKeySpend adds cost, which may be negative, to the spend of the
+// key id. The cached key is replaced by the updated one, so the spend
+// cap check of the next request sees this charge.
+func addAPIKeySpend(ctx context.Context, id string, cost db.Price, allowOverdraft bool) error {
+	store, err := newAPIKeyStore()
+	if err != nil {
+		return errors.Wrap(err, "get api key store")
+	}
+	key, err := store.AddSpend(ctx, id, cost, allowOverdraft)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	apiKeyCache.Store(key.SecretHash, key)
+	return nil
+}
+
+// apiKeySpendReservation is spend taken from a managed api key before a
+// chat is sent upstream, so the spend cap holds while its usage is not
+// known yet.
+type apiKeySpendReservation struct {
+	keyID    string
+	reserved db.Price
+	once     sync.Once
+}
+
+// reserveAPIKeySpend charges the price of tokens to the user's managed
+// api key, failing with ErrAPIKeySpendCap if that passes the cap. It
+// returns nil for other users.
+func reserveAPIKeySpend(ctx context.Context, user *config.UserConfig, tokens int) (*apiKeySpendReservation, error) {
+	if user.APIKeyID == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	cost := chatTokensCost(tokens)
+	if err := chargeAPIKeySpend(ctx, user, cost, false); err != nil {
+		return nil, errors.Wrap(err, "reserve api key spend")
+	}
+	return &apiKeySpendReservation{keyID: user.APIKeyID, reserved: cost}, nil
+}
+
+// Settle charges the difference between the price of the used tokens and
+// the reservation, a request that delivered nothing settles with 0 and is
+// refunded. Only the first call counts.
+func (r *apiKeySpendReservation) Settle(ctx context.Context, tokens int) error {
+	if r == nil {
+		return nil
+	}
+
+	var err error
+	r.once.Do(func() {
+		delta := chatTokensCost(max(tokens, 0)) - r.reserved
+		if delta == 0 {
+			return
+		}
+		if err = addAPIKeySpend(ctx, r.keyID, delta, true); err != nil {
+			err = errors.Wrapf(err, "settle %d to api key %q", delta.Int(), r.keyID)
+		}
+	})
+	return err
+}
+
+// needsBilling reports whether priced operations of user go through
+// checkUserExternalBilling.
+func needsBilling(user *config.UserConfig) bool {
+	return user.EnableExternalImageBilling || user.APIKeyID != ""
+}
+
+// managedKeyOwner returns the user allowed to manage their api keys.
+// Managed keys can not mint keys, and free or byok users have no
+// entitlement to hand out.
+func managedKeyOwner(ctx *gin.Context) (*config.UserConfig, error) {
+	user, err := getUserByAuthHeader(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get user by auth header")
+	}
+
+	switch {
+	case user.APIKeyID != "":
+		return nil, errors.New("managed api keys can not manage api keys")
+	case user.IsFree, user.PrepaidCredit:
+		return nil, errors.New("free-tier users can not mint api keys")
+	case user.BYOK:
+		return nil, errors.New("users bringing their own token can not mint api keys")
+	}
+	return user, nil
+}
+
+// CreateAPIKeyRequest mints an api key.
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// AllowedModels must be allowed for the owner, empty means the owner's
+	AllowedModels []string `json:"allowed_models"`
+	// SpendCapUSD <=0 means unlimited
+	SpendCapUSD float64 `json:"spend_cap_usd"`
+	// ExpiresInDays <=0 means never
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+func (r *CreateAPIKeyRequest) valid(owner *config.UserConfig) error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > 64 {
+		return errors.New("name should be 1 to 64 characters")
+	}
+	for _, s := range r.Scopes {
+		if !slices.Contains(apiKeyScopes, s) {
+			return errors.Errorf("unknown scope %q, should be one of %v", s, apiKeyScopes)
+		}
+	}
+
+	allowed := ownerModels(owner)
+	for _, m := range r.AllowedModels {
+		if m != "*" && !slices.Contains(allowed, "*") && !slices.Contains(allowed, m) {
+			return errors.Errorf("model %q is not allowed for %q", m, owner.UserName)
+		}
+	}
+
+	if r.SpendCapUSD < 0 || r.ExpiresInDays < 0 {
+		return errors.New("spend_cap_usd and expires_in_days should not be negative")
+	}
+	return nil
+}
+
+// apiKeyResponse returns a key, with its secret only when just minted.
+func apiKeyResponse(key *db.APIKey, secret string) gin.H {
+	resp := gin.H{"api_key": key}
+	if secret != "" {
+		resp["key"] = secret
+	}
+	return resp
+}
+
+// CreateAPIKeyHandler mints an api key, the secret is only shown once.
+func CreateAPIKeyHandler(ctx *gin.Context) {
+	owner, err := managedKeyOwner(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	req := new(CreateAPIKeyRequest)
+	if err = ctx.BindJSON(req); err != nil {
+		web.AbortErr(ctx, errors.Wrap(err, "bind request"))
+		return
+	}
+	if web.AbortErr(ctx, req.valid(owner)) {
+		return
+	}
+
+	store, err := newAPIKeyStore()
+	if web.AbortErr(ctx, errors.Wrap(err, "get api key store")) {
+		return
+	}
+	keys, err := store.List(gmw.Ctx(ctx), owner.UserName)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	active := 0
+	for _, k := range keys {
+		if k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || time.Now().Before(k.ExpiresAt)) {
+			active++
+		}
+	}
+	if active >= maxAPIKeysPerUser {
+		web.AbortErr(ctx, errors.Errorf("at most %d active api keys per user", maxAPIKeysPerUser))
+		return
+	}
+
+	key := &db.APIKey{
+		ID:            gutils.UUID7(),
+		Username:      owner.UserName,
+		Name:          req.Name,
+		Scopes:        req.Scopes,
+		AllowedModels: req.AllowedModels,
+		SpendCap:      db.Price(req.SpendCapUSD * float64(db.PriceUSD)),
+		CreatedAt:     time.Now().UTC(),
+	}
+	if staticUserByName(owner.UserName) == nil {
+		id, ok := ssoIdentityFromRequest(ctx.Request)
+		if !ok {
+			web.AbortErr(ctx, errors.Errorf("can not resolve %q later, only static or sso users can mint api keys", owner.UserName))
+			return
+		}
+		key.Owner = db.APIKeyOwner{SSOSubject: id.Subject, SSOEmail: id.Email, SSOGroups: id.Groups}
+	}
+	if req.ExpiresInDays > 0 {
+		key.ExpiresAt = key.CreatedAt.AddDate(0, 0, req.ExpiresInDays)
+	}
+
+	secret, err := insertAPIKey(ctx, store, key)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	gmw.GetLogger(ctx).Info("mint api key",
+		zap.String("user", owner.UserName),
+		zap.String("key", key.ID),
+		zap.Strings("scopes", key.Scopes))
+	ctx.JSON(http.StatusOK, apiKeyResponse(key, secret))
+}
+
+// insertAPIKey sets a fresh secret on key and stores it.
+func insertAPIKey(ctx *gin.Context, store apiKeyStore, key *db.APIKey) (secret string, err error) {
+	if secret, err = newAPIKeySecret(); err != nil {
+		return "", errors.Wrap(err, "new secret")
+	}
+	key.SecretHash = hashAPIKey(secret)
+	key.Hint = secret[len(secret)-4:]
+
+	if err = store.Insert(gmw.Ctx(ctx), key); err != nil {
+		return "", errors.Wrap(err, "insert api key")
+	}
+	return secret, nil
+}
+
+// ListAPIKeysHandler lists the current user's api keys.
+func ListAPIKeysHandler(ctx *gin.Context) {
+	owner, err := managedKeyOwner(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	store, err := newAPIKeyStore()
+	if web.AbortErr(ctx, errors.Wrap(err, "get api key store")) {
+		return
+	}
+	keys, err := store.List(gmw.Ctx(ctx), owner.UserName)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// RotateAPIKeyHandler replaces a key by a new one with the same settings
+// and spend, the old key stops working at once.
+func RotateAPIKeyHandler(ctx *gin.Context) {
+	owner, err := managedKeyOwner(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	store, err := newAPIKeyStore()
+	if web.AbortErr(ctx, errors.Wrap(err, "get api key store")) {
+		return
+	}
+	old, err := store.Get(gmw.Ctx(ctx), owner.UserName, ctx.Param("id"))
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if !old.RevokedAt.IsZero() {
+		web.AbortErr(ctx, errors.Errorf("api key %q is revoked", old.ID))
+		return
+	}
+
+	key := *old
+	key.ID = gutils.UUID7()
+	key.RotatedFrom = old.ID
+	key.CreatedAt = time.Now().UTC()
+	secret, err := insertAPIKey(ctx, store, &key)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if web.AbortErr(ctx, revokeAPIKey(ctx, store, owner.UserName, old)) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, apiKeyResponse(&key, secret))
+}
+
+// RevokeAPIKeyHandler revokes a key.
+func RevokeAPIKeyHandler(ctx *gin.Context) {
+	owner, err := managedKeyOwner(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	store, err := newAPIKeyStore()
+	if web.AbortErr(ctx, errors.Wrap(err, "get api key store")) {
+		return
+	}
+	key, err := store.Get(gmw.Ctx(ctx), owner.UserName, ctx.Param("id"))
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if web.AbortErr(ctx, revokeAPIKey(ctx, store, owner.UserName, key)) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, apiKeyResponse(key, ""))
+}
+
+func revokeAPIKey(ctx *gin.Context, store apiKeyStore, username string, key *db.APIKey) error {
+	key.RevokedAt = time.Now().UTC()
+	if err := store.Revoke(gmw.Ctx(ctx), username, key.ID, key.RevokedAt); err != nil {
+		return errors.Wrap(err, "revoke api key")
+	}
+	apiKeyCache.Delete(key.SecretHash)
+
+	gmw.GetLogger(ctx).Info("revoke api key",
+		zap.String("user", username), zap.String("key", key.ID))
+	return nil
+}
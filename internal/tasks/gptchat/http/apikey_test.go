@@ -0,0 +1,349 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laisky/testify/require"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+	"github.com/Laisky/go-ramjet/internal/tasks/sso"
+)
+
+// memoryAPIKeyStore is an in-memory apiKeyStore.
+type memoryAPIKeyStore struct {
+	mu   sync.Mutex
+	keys []*db.APIKey
+}
+
+func (s *memoryAPIKeyStore) Insert(_ context.Context, key *db.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := *key
+	s.keys = append(s.keys, &k)
+	return nil
+}
+
+func (s *memoryAPIKeyStore) GetByHash(_ context.Context, secretHash string) (*db.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if k.SecretHash == secretHash {
+			key := *k
+			return &key, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (s *memoryAPIKeyStore) Get(_ context.Context, username, id string) (*db.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if k.Username == username && k.ID == id {
+			key := *k
+			return &key, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (s *memoryAPIKeyStore) List(_ context.Context, username string) ([]db.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []db.APIKey
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if s.keys[i].Username == username {
+			keys = append(keys, *s.keys[i])
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryAPIKeyStore) Revoke(_ context.Context, username, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if k.Username == username && k.ID == id {
+			k.RevokedAt = at
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+func (s *memoryAPIKeyStore) AddSpend(_ context.Context,
+	id string, cost db.Price, allowOverdraft bool) (*db.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if k.ID == id {
+			if !allowOverdraft && k.SpendCap > 0 && k.Spent+cost > k.SpendCap {
+				return nil, ErrAPIKeySpendCap
+			}
+			k.Spent += cost
+			updated := *k
+			return &updated, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+// setupAPIKeyStore serves managed api keys from memory.
+func setupAPIKeyStore(t *testing.T) *memoryAPIKeyStore {
+	t.Helper()
+	store := &memoryAPIKeyStore{}
+	originalStore := newAPIKeyStore
+	newAPIKeyStore = func() (apiKeyStore, error) { return store, nil }
+	t.Cleanup(func() {
+		newAPIKeyStore = originalStore
+		for _, k := range store.keys {
+			apiKeyCache.Delete(k.SecretHash)
+		}
+	})
+	return store
+}
+
+// newAPIKeyRouter serves the key management routes.
+func newAPIKeyRouter() *gin.Engine {
+	router := gin.New()
+	router.POST("/user/keys", CreateAPIKeyHandler)
+	router.GET("/user/keys", ListAPIKeysHandler)
+	router.POST("/user/keys/:id/rotate", RotateAPIKeyHandler)
+	router.DELETE("/user/keys/:id", RevokeAPIKeyHandler)
+	return router
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupPaidUsers(t)
+	store := setupAPIKeyStore(t)
+	router := newAPIKeyRouter()
+
+	resp, payload := testRequest(t, router, http.MethodPost, "/user/keys", testPaidToken,
+		`{"name":"ci","scopes":["chat"],"allowed_models":["gpt-5"],"expires_in_days":7}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	secret := payload["key"].(string)
+	require.True(t, strings.HasPrefix(secret, apiKeyPrefix))
+	require.NotContains(t, resp.Body.String(), "secret_hash")
+	require.Len(t, store.keys, 1)
+	require.Equal(t, hashAPIKey(secret), store.keys[0].SecretHash)
+	require.NotContains(t, store.keys[0].SecretHash, secret)
+	keyID := store.keys[0].ID
+
+	// the key resolves to its owner, narrowed by scopes and models
+	user, err := getUserByToken(newAuthContext(""), "Bearer "+secret)
+	require.NoError(t, err)
+	require.Equal(t, "alice", user.UserName)
+	require.Equal(t, keyID, user.APIKeyID)
+	require.Equal(t, []string{"gpt-5"}, user.AllowedModels)
+	require.True(t, user.HasScope(ScopeChat))
+	require.False(t, user.HasScope(ScopeImage))
+	require.Error(t, IsModelAllowed(context.Background(), user, &FrontendReq{Model: "gpt-4o"}))
+
+	// managed keys can not mint keys
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/keys", secret, `{"name":"nested"}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// models the owner may not call are rejected
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/keys", testPaidToken,
+		`{"name":"bad","allowed_models":["o1-pro"]}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// free users can not mint keys
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/keys", "FREETIER-abcdef1234567890", `{"name":"free"}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp, payload = testRequest(t, router, http.MethodGet, "/user/keys", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Len(t, payload["api_keys"], 1)
+
+	// rotating revokes the old key
+	resp, payload = testRequest(t, router, http.MethodPost, "/user/keys/"+keyID+"/rotate", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	rotated := payload["key"].(string)
+	require.NotEqual(t, secret, rotated)
+	_, err = getUserByToken(newAuthContext(""), secret)
+	require.ErrorContains(t, err, "revoked")
+	user, err = getUserByToken(newAuthContext(""), rotated)
+	require.NoError(t, err)
+	require.Equal(t, []string{"gpt-5"}, user.AllowedModels)
+
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/keys/"+user.APIKeyID, testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	_, err = getUserByToken(newAuthContext(""), rotated)
+	require.ErrorContains(t, err, "revoked")
+
+	// other users can not see the keys
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/keys/"+keyID, "unknown-token-0123456789", "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestAPIKeyExpiryAndSpendCap(t *testing.T) {
+	setupPaidUsers(t)
+	store := setupAPIKeyStore(t)
+	ctx := context.Background()
+
+	expired := &db.APIKey{
+		ID: "expired", Username: "alice", SecretHash: hashAPIKey(apiKeyPrefix + "expired"),
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}
+	capped := &db.APIKey{
+		ID: "capped", Username: "alice", SecretHash: hashAPIKey(apiKeyPrefix + "capped"),
+		SpendCap: db.PriceTxt2Image + 1,
+	}
+	require.NoError(t, store.Insert(ctx, expired))
+	require.NoError(t, store.Insert(ctx, capped))
+
+	_, err := getUserByToken(newAuthContext(""), apiKeyPrefix+"expired")
+	require.ErrorContains(t, err, "expired")
+
+	user, err := getUserByToken(newAuthContext(""), apiKeyPrefix+"capped")
+	require.NoError(t, err)
+	require.Equal(t, []string{"gpt-4o", "gpt-5"}, user.AllowedModels, "inherits the owner's models")
+	require.True(t, needsBilling(user))
+
+	require.NoError(t, checkUserExternalBilling(ctx, user, db.PriceTxt2Image, "txt2image"))
+	err = checkUserExternalBilling(ctx, user, db.PriceTxt2Image, "txt2image")
+	require.ErrorIs(t, err, ErrAPIKeySpendCap)
+
+	// chat is charged after the fact and may pass the cap, the cached key
+	// follows the charge
+	require.NoError(t, chargeAPIKeySpend(ctx, user, db.PriceTxt2Image, true))
+	_, err = getUserByToken(newAuthContext(""), apiKeyPrefix+"capped")
+	require.ErrorIs(t, err, ErrAPIKeySpendCap)
+}
+
+func TestAPIKeySpendReservation(t *testing.T) {
+	setupPaidUsers(t)
+	store := setupAPIKeyStore(t)
+	ctx := context.Background()
+	require.NoError(t, store.Insert(ctx, &db.APIKey{
+		ID: "chat", Username: "alice", SecretHash: hashAPIKey(apiKeyPrefix + "chat"),
+		SpendCap: chatTokensCost(3000),
+	}))
+	user, err := getUserByToken(newAuthContext(""), apiKeyPrefix+"chat")
+	require.NoError(t, err)
+
+	// the reservation is taken before the chat goes upstream
+	spend, err := reserveAPIKeySpend(ctx, user, 2000)
+	require.NoError(t, err)
+	require.Equal(t, chatTokensCost(2000), store.keys[0].Spent)
+	_, err = reserveAPIKeySpend(ctx, user, 2000)
+	require.ErrorIs(t, err, ErrAPIKeySpendCap, "a second chat would pass the cap")
+
+	// settling charges the actual usage, only once
+	chargeChatCredit(ctx, user, spend, 1000)
+	require.NoError(t, spend.Settle(ctx, 0))
+	require.Equal(t, chatTokensCost(1000), store.keys[0].Spent)
+
+	// a chat that delivered nothing is refunded
+	spend, err = reserveAPIKeySpend(ctx, user, 2000)
+	require.NoError(t, err)
+	require.NoError(t, spend.Settle(ctx, 0))
+	require.Equal(t, chatTokensCost(1000), store.keys[0].Spent)
+
+	// other users reserve nothing
+	spend, err = reserveAPIKeySpend(ctx, &config.UserConfig{UserName: "bob"}, 2000)
+	require.NoError(t, err)
+	require.Nil(t, spend)
+}
+
+func TestAPIKeyRejectedOnceOwnerIsGone(t *testing.T) {
+	setupPaidUsers(t)
+	store := setupAPIKeyStore(t)
+	ctx := context.Background()
+
+	ssoUsers := map[string]*config.UserConfig{
+		"sub-bob": {UserName: "bob@example.com", Token: "sso-bob", AllowedModels: []string{"gpt-5-mini"}},
+	}
+	original := ssoUserByIdentity
+	ssoUserByIdentity = func(id sso.Identity) (*config.UserConfig, bool) {
+		u, ok := ssoUsers[id.Subject]
+		return u, ok
+	}
+	t.Cleanup(func() { ssoUserByIdentity = original })
+
+	for _, key := range []*db.APIKey{
+		{ID: "static", Username: "alice", SecretHash: hashAPIKey(apiKeyPrefix + "static")},
+		{ID: "sso", Username: "bob@example.com", SecretHash: hashAPIKey(apiKeyPrefix + "sso"),
+			Owner: db.APIKeyOwner{SSOSubject: "sub-bob", SSOEmail: "bob@example.com"}},
+		{ID: "orphan", Username: "mallory", SecretHash: hashAPIKey(apiKeyPrefix + "orphan")},
+	} {
+		require.NoError(t, store.Insert(ctx, key))
+	}
+
+	user, err := getUserByToken(newAuthContext(""), apiKeyPrefix+"sso")
+	require.NoError(t, err)
+	require.Equal(t, []string{"gpt-5-mini"}, user.AllowedModels, "entitlements of the live sso user")
+
+	_, err = getUserByToken(newAuthContext(""), apiKeyPrefix+"orphan")
+	require.ErrorContains(t, err, "no longer exists")
+
+	// the sso rules drop bob, and alice leaves the static user list
+	delete(ssoUsers, "sub-bob")
+	config.Config.UserTokens = slices.DeleteFunc(config.Config.UserTokens,
+		func(u *config.UserConfig) bool { return u.UserName == "alice" })
+	for _, secret := range []string{"sso", "static"} {
+		_, err = getUserByToken(newAuthContext(""), apiKeyPrefix+secret)
+		require.ErrorContains(t, err, "no longer exists", secret)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupPaidUsers(t)
+	store := setupAPIKeyStore(t)
+	require.NoError(t, store.Insert(context.Background(), &db.APIKey{
+		ID: "tts-only", Username: "alice", SecretHash: hashAPIKey(apiKeyPrefix + "tts-only"),
+		Scopes: []string{ScopeTTS},
+	}))
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/api", RequireScope(ScopeChat), ok)
+	router.GET("/tts", RequireScope(ScopeTTS), ok)
+
+	for _, tc := range []struct {
+		path, token string
+		code        int
+	}{
+		{"/api", apiKeyPrefix + "tts-only", http.StatusForbidden},
+		{"/tts", apiKeyPrefix + "tts-only", http.StatusOK},
+		{"/api", testPaidToken, http.StatusOK},
+		{"/api", "", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, tc.code, resp.Code, "%s as %q", tc.path, tc.token)
+	}
+}
+
+func TestGetUserByToken_StaticPaidUser(t *testing.T) {
+	setupPaidUsers(t)
+
+	user, err := getUserByToken(newAuthContext(""), testPaidToken)
+	require.NoError(t, err)
+	require.Equal(t, "alice", user.UserName)
+	require.Empty(t, user.APIKeyID)
+	require.True(t, user.HasScope(ScopeAgent))
+
+	// the static list is not modified through the returned user
+	user.AllowedModels[0] = "changed"
+	require.Equal(t, "gpt-4o", config.Config.UserTokens[1].AllowedModels[0])
+
+	_, err = getUserByToken(newAuthContext(""), "unknown-token-0123456789")
+	require.ErrorContains(t, err, "invalid token")
+}
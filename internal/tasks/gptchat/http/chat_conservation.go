@@ -55,16 +55,17 @@ func saveLLMConservation(req *FrontendReq, respContent string, reasoning string)
 		return
 	}
 
+	moderator := ModerationPipeline()
 	docu := &db.OpenaiConservation{
 		Model:      req.Model,
 		MaxTokens:  req.MaxTokens,
-		Completion: respContent,
-		Reasoning:  reasoning,
+		Completion: moderator.Persist(respContent),
+		Reasoning:  moderator.Persist(reasoning),
 	}
 	for _, msg := range req.Messages {
 		docu.Prompt = append(docu.Prompt, db.OpenaiMessage{
 			Role:    msg.Role.String(),
-			Content: msg.Content.String(),
+			Content: moderator.Persist(msg.Content.String()),
 		})
 	}
 
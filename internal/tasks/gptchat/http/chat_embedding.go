@@ -250,19 +250,38 @@ func (r *FrontendReq) embeddingUrlContent(gctx *gin.Context, user *config.UserCo
 	for _, url := range urls {
 		url := url
 		pool.Go(func() (err error) {
-			content, err := FetchURLContent(gctx, url)
-			if err != nil {
-				return errors.Wrap(err, "fetch url content")
+			var (
+				content []byte
+				ext     string
+			)
+
+			// files uploaded by UploadFiles are read from their extracted
+			// markdown, falling back to the raw bytes if extraction fails.
+			if uploaded, ok := parseUploadedFileURL(url); ok {
+				markdown, ingestErr := loadUploadedFileMarkdown(gmw.Ctx(gctx), user, uploaded)
+				if ingestErr == nil {
+					content, ext = []byte(markdown), ".md"
+				} else {
+					log.Logger.Warn("load uploaded file markdown",
+						zap.String("url", url), zap.Error(ingestErr))
+				}
 			}
 
-			parsedURL, err := urllib.Parse(url)
-			if err != nil {
-				return errors.Wrap(err, "parse url")
-			}
+			if content == nil {
+				content, err = FetchURLContent(gctx, url)
+				if err != nil {
+					return errors.Wrap(err, "fetch url content")
+				}
 
-			ext := strings.ToLower(filepath.Ext(parsedURL.Path))
-			if !gutils.Contains([]string{".txt", ".md", ".doc", ".docx", ".ppt", ".pptx", ".pdf"}, ext) {
-				ext = ".html" // default
+				parsedURL, err := urllib.Parse(url)
+				if err != nil {
+					return errors.Wrap(err, "parse url")
+				}
+
+				ext = strings.ToLower(filepath.Ext(parsedURL.Path))
+				if !gutils.Contains([]string{".txt", ".md", ".doc", ".docx", ".ppt", ".pptx", ".pdf"}, ext) {
+					ext = ".html" // default
+				}
 			}
 
 			auxiliary, err := queryChunks(gctx, queryChunksArgs{
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	stdjson "encoding/json"
 	"io"
 	"net/http"
 	"strings"
@@ -17,6 +18,7 @@ import (
 	"github.com/Laisky/zap"
 	"github.com/gin-gonic/gin"
 
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
 	"github.com/Laisky/go-ramjet/library/log"
 	"github.com/Laisky/go-ramjet/library/openai"
 	"github.com/Laisky/go-ramjet/library/web"
@@ -488,6 +490,18 @@ func OneShotChatHandler(gctx *gin.Context) {
 	if err := gctx.BindJSON(req); web.AbortErr(gctx, err) {
 		return
 	}
+	moderator := ModerationPipeline()
+	if req.SystemPrompt, err = moderator.Upstream(req.SystemPrompt); web.AbortErr(gctx, err) {
+		return
+	}
+	if req.UserPrompt, err = moderator.Upstream(req.UserPrompt); web.AbortErr(gctx, err) {
+		return
+	}
+
+	if req.JSONSchema != nil {
+		oneShotStructuredChat(gctx, user, req)
+		return
+	}
 
 	resp, err := openai.OneshotChat(gmw.Ctx(gctx), user.APIBase, user.OpenaiToken, "", req.SystemPrompt, req.UserPrompt)
 	if web.AbortErr(gctx, err) {
@@ -498,3 +512,30 @@ func OneShotChatHandler(gctx *gin.Context) {
 		"response": resp,
 	})
 }
+
+// oneShotStructuredChat answers req with a JSON document matching req.JSONSchema.
+// An answer that still mismatches after the repairs is returned with 422.
+func oneShotStructuredChat(gctx *gin.Context, user *config.UserConfig, req *OneShotChatRequest) {
+	if err := req.JSONSchema.Valid(); err != nil {
+		web.AbortErr(gctx, errors.Wrap(err, "invalid json_schema"))
+		return
+	}
+
+	resp, err := openai.OneshotStructured(gmw.Ctx(gctx), user.APIBase, user.OpenaiToken, "",
+		req.SystemPrompt, req.UserPrompt, req.JSONSchema, nil)
+	if schemaErr := new(openai.SchemaError); errors.As(err, &schemaErr) {
+		gctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"err":      err.Error(),
+			"response": resp,
+		})
+		return
+	}
+	if web.AbortErr(gctx, err) {
+		return
+	}
+
+	gctx.JSON(http.StatusOK, gin.H{
+		"response": resp,
+		"data":     stdjson.RawMessage(resp),
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/jinzhu/copier"
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/sso"
 	"github.com/Laisky/go-ramjet/library/log"
 )
 
@@ -20,6 +21,14 @@ const (
 	ctxKeyUserAuth string = "ctx_user_auth"
 )
 
+// ssoUserFromRequest resolves the user of an sso session cookie. It and
+// the other sso hooks are variables so tests can fake a session.
+var (
+	ssoUserFromRequest     = sso.UserFromRequest
+	ssoIdentityFromRequest = sso.IdentityFromRequest
+	ssoUserByIdentity      = sso.UserByIdentity
+)
+
 // GetRawUserToken returns the original user token from the Authorization header.
 //
 // It is cached into the gin context by getUserByAuthHeader.
@@ -45,6 +54,17 @@ func getUserByAuthHeader(gctx *gin.Context) (user *config.UserConfig, err error)
 
 	userToken := strings.TrimPrefix(gctx.Request.Header.Get("authorization"), "Bearer ")
 	if userToken == "" {
+		// api tokens win over the sso session cookie
+		if user, ok := ssoUserFromRequest(gctx.Request); ok {
+			applyOrganization(gmw.Ctx(gctx), user)
+			if err = user.Valid(); err != nil {
+				return nil, errors.Wrap(err, "valid sso user")
+			}
+
+			gctx.Set(ctxKeyUser, user)
+			return user, nil
+		}
+
 		log.Logger.Debug("user token not found in header, use freetier token instead")
 		userToken = config.FreetierUserToken
 	}
@@ -212,19 +232,26 @@ SWITCH_FOR_USER:
 		}
 
 		applyUserAPIBaseOverride(gctx, user, logger)
+	case strings.HasPrefix(userToken, apiKeyPrefix): // managed api key
+		if user, err = getUserByAPIKey(gmw.Ctx(gctx), userToken); err != nil {
+			return nil, errors.Wrap(err, "get user by api key")
+		}
+
+		logger.Debug("use managed api key",
+			zap.String("user", user.UserName),
+			zap.String("key", user.APIKeyID))
 	default: // use server's token in settings
-		return nil, errors.New("invalid token")
-		// for _, u := range config.Config.UserTokens {
-		// 	if u.Token == userToken {
-		// 		logger.Debug("paid user", zap.String("user", u.UserName))
-		// 		if err = u.Valid(); err != nil {
-		// 			return nil, errors.Wrap(err, "valid paid user")
-		// 		}
-
-		// 		user = u
-		// 		break SWITCH_FOR_USER
-		// 	}
-		// }
+		paidUser := staticUserByToken(userToken)
+		if paidUser == nil {
+			return nil, errors.New("invalid token")
+		}
+
+		logger.Debug("paid user", zap.String("user", paidUser.UserName))
+		user = &config.UserConfig{}
+		if err = copier.CopyWithOption(user, paidUser, copier.Option{DeepCopy: true}); err != nil {
+			return nil, errors.Wrap(err, "copy paid user")
+		}
+		applyOrganization(gmw.Ctx(gctx), user)
 
 		// // use user's own openai/azure or whatever token
 		// hashed := sha256.Sum256([]byte(userToken))
@@ -266,6 +293,7 @@ SWITCH_FOR_USER:
 	if err = user.Valid(); err != nil {
 		return nil, errors.Wrap(err, "valid user")
 	}
+	applyPrepaidCredit(gmw.Ctx(gctx), user)
 
 	return user, nil
 }
@@ -3,8 +3,10 @@ package http
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/Laisky/go-utils/v6/json"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
 
@@ -45,6 +47,43 @@ func setupTestConfig() {
 	}
 }
 
+const (
+	testPaidToken  = "alice-paid-token-0123456789"
+	testBobToken   = "bob-paid-token-0123456789"
+	testCarolToken = "carol-paid-token-0123456789"
+)
+
+// setupPaidUsers sets up the test config with the paid users alice, bob
+// and carol.
+func setupPaidUsers(t *testing.T) {
+	t.Helper()
+	originalConfig := config.Config
+	setupTestConfig()
+	config.Config.UserTokens = append(config.Config.UserTokens,
+		&config.UserConfig{Token: testPaidToken, UserName: "alice", AllowedModels: []string{"gpt-4o", "gpt-5"}},
+		&config.UserConfig{Token: testBobToken, UserName: "bob", AllowedModels: []string{"gpt-4o"}},
+		&config.UserConfig{Token: testCarolToken, UserName: "carol", AllowedModels: []string{"gpt-4o"}},
+	)
+	t.Cleanup(func() { config.Config = originalConfig })
+}
+
+// testRequest sends a request to router as token, the body of a json
+// response with status 200 is decoded into payload.
+func testRequest(t *testing.T, router http.Handler,
+	method, path, token, body string) (resp *httptest.ResponseRecorder, payload map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	if resp.Code == http.StatusOK && strings.HasPrefix(resp.Header().Get("Content-Type"), "application/json") {
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &payload))
+	}
+	return resp, payload
+}
+
 func TestGetUserByAuthHeader_FreeTierUsesConfiguredAllowedModels(t *testing.T) {
 	setupTestConfig()
 
@@ -172,3 +211,36 @@ func TestGetUserByAuthHeader_ApiBaseOverrideInvalidIgnored(t *testing.T) {
 		t.Fatalf("expected invalid api base to be ignored, got %q", user.APIBase)
 	}
 }
+
+func TestGetUserByAuthHeader_SSOSession(t *testing.T) {
+	setupTestConfig()
+	original := ssoUserFromRequest
+	ssoUserFromRequest = func(r *http.Request) (*config.UserConfig, bool) {
+		if _, err := r.Cookie("ramjet_sso_session"); err != nil {
+			return nil, false
+		}
+		return &config.UserConfig{
+			UserName:      "alice@example.com",
+			Token:         "sso-abc",
+			AllowedModels: []string{"gpt-5"},
+		}, true
+	}
+	t.Cleanup(func() { ssoUserFromRequest = original })
+
+	ctx := newAuthContext("")
+	ctx.Request.AddCookie(&http.Cookie{Name: "ramjet_sso_session", Value: "sid"})
+	user, err := getUserByAuthHeader(ctx)
+	require.NoError(t, err)
+	require.False(t, user.IsFree)
+	require.Equal(t, "alice@example.com", user.UserName)
+	require.Equal(t, []string{"gpt-5"}, user.AllowedModels)
+	require.Equal(t, "SERVER_OPENAI_TOKEN", user.OpenaiToken, "defaults are filled")
+	require.Empty(t, GetRawUserToken(ctx))
+
+	// an api token wins over the session cookie
+	ctx = newAuthContext("FREETIER-abcdef1234567890")
+	ctx.Request.AddCookie(&http.Cookie{Name: "ramjet_sso_session", Value: "sid"})
+	user, err = getUserByAuthHeader(ctx)
+	require.NoError(t, err)
+	require.True(t, user.IsFree)
+}
@@ -0,0 +1,458 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+// ErrInsufficientCredit is returned when a debit exceeds the user's balance.
+var ErrInsufficientCredit = errors.New("insufficient credit")
+
+const (
+	creditLedgerCol  = "credit_ledger"
+	creditBalanceCol = "credit_balances"
+	// creditStatusTTL bounds how stale the paid status of a free-tier
+	// user can be after a top-up or after the credit runs out.
+	creditStatusTTL = 30 * time.Second
+)
+
+// creditLedger is the append-only prepaid credit ledger.
+type creditLedger interface {
+	// Apply records entry and moves the balance by entry.Amount. An entry
+	// whose IdempotencyKey is already recorded is skipped and applied is
+	// false. A debit that would make the balance negative fails with
+	// ErrInsufficientCredit unless allowOverdraft.
+	Apply(ctx context.Context, entry *db.CreditEntry, allowOverdraft bool) (balance db.Price, applied bool, err error)
+	// Balance returns the current balance, zero for unknown users.
+	Balance(ctx context.Context, username string) (db.Price, error)
+	// Entry looks up an entry by its idempotency key.
+	Entry(ctx context.Context, idempotencyKey string) (entry *db.CreditEntry, found bool, err error)
+	// Entries returns the latest entries of username, newest first.
+	Entries(ctx context.Context, username string, limit int) ([]db.CreditEntry, error)
+}
+
+var (
+	creditStatusCache = gutils.NewExpCache[db.Price](context.Background(), creditStatusTTL)
+
+	mongoCreditLedgerOnce sync.Once
+	mongoCreditLedgerIns  *mongoCreditLedger
+	mongoCreditLedgerErr  error
+)
+
+// newCreditLedger returns the ledger in use. It is a variable so tests can
+// replace mongo.
+var newCreditLedger = func() (creditLedger, error) {
+	mongoCreditLedgerOnce.Do(func() {
+		mongoCreditLedgerIns, mongoCreditLedgerErr = newMongoCreditLedger()
+	})
+	return mongoCreditLedgerIns, mongoCreditLedgerErr
+}
+
+// mongoCreditLedger keeps entries in one collection with a unique index on
+// the idempotency key, and the running balance per user in another.
+//
+// An entry is inserted as pending before the balance moves, so a retried
+// operation is rejected by the unique index instead of being counted twice.
+// The balance records the keys of pending entries it has applied in the
+// same update, so an entry left pending by a crash is resumed by its retry
+// without moving the balance again.
+type mongoCreditLedger struct {
+	ledger   *mongo.Collection
+	balances *mongo.Collection
+}
+
+//nolint:contextcheck // indexes are created once, detached from any request
+func newMongoCreditLedger() (*mongoCreditLedger, error) {
+	openaiDB, err := db.GetOpenaiDB()
+	if err != nil {
+		return nil, errors.Wrap(err, "get openai db")
+	}
+
+	l := &mongoCreditLedger{
+		ledger:   openaiDB.GetCol(creditLedgerCol),
+		balances: openaiDB.GetCol(creditBalanceCol),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if _, err = l.ledger.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "idempotency_key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "username", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	}); err != nil {
+		return nil, errors.Wrap(err, "create credit ledger indexes")
+	}
+	if _, err = l.balances.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return nil, errors.Wrap(err, "create credit balance index")
+	}
+
+	return l, nil
+}
+
+// Apply implements creditLedger.
+func (l *mongoCreditLedger) Apply(ctx context.Context,
+	entry *db.CreditEntry, allowOverdraft bool) (balance db.Price, applied bool, err error) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+	entry.Pending = true
+
+	if _, err = l.ledger.InsertOne(ctx, entry); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return 0, false, errors.Wrap(err, "insert credit entry")
+		}
+
+		existing, found, err := l.Entry(ctx, entry.IdempotencyKey)
+		if err != nil {
+			return 0, false, errors.Wrap(err, "get credit entry")
+		}
+		if !found {
+			return 0, false, errors.Errorf("credit entry %q is rolled back concurrently", entry.IdempotencyKey)
+		}
+		if !existing.Pending {
+			balance, err = l.Balance(ctx, existing.Username)
+			return balance, false, errors.Wrap(err, "get balance")
+		}
+
+		// resume an entry interrupted before it was marked applied
+		entry = existing
+	}
+
+	if balance, err = l.moveBalance(ctx, entry, allowOverdraft); err != nil {
+		if errors.Is(err, ErrInsufficientCredit) {
+			// roll back the entry so the same key can be retried later
+			if _, delErr := l.ledger.DeleteOne(ctx, bson.M{
+				"idempotency_key": entry.IdempotencyKey,
+				"pending":         true,
+			}); delErr != nil {
+				gmw.GetLogger(ctx).Error("roll back credit entry",
+					zap.String("key", entry.IdempotencyKey), zap.Error(delErr))
+			}
+		}
+		return 0, false, err
+	}
+
+	if _, err = l.ledger.UpdateOne(ctx,
+		bson.M{"idempotency_key": entry.IdempotencyKey},
+		bson.M{"$unset": bson.M{"pending": ""}}); err != nil {
+		return 0, false, errors.Wrap(err, "mark credit entry applied")
+	}
+	if _, err = l.balances.UpdateOne(ctx,
+		bson.M{"username": entry.Username},
+		bson.M{"$pull": bson.M{"pending_keys": entry.IdempotencyKey}}); err != nil {
+		// a stale key only blocks its own entry, which is applied already
+		gmw.GetLogger(ctx).Warn("clear pending credit key",
+			zap.String("key", entry.IdempotencyKey), zap.Error(err))
+	}
+
+	return balance, true, nil
+}
+
+// moveBalance moves the balance by entry.Amount unless entry has moved it
+// already, and returns the new balance.
+func (l *mongoCreditLedger) moveBalance(ctx context.Context,
+	entry *db.CreditEntry, allowOverdraft bool) (db.Price, error) {
+	if _, err := l.balances.UpdateOne(ctx,
+		bson.M{"username": entry.Username},
+		bson.M{"$setOnInsert": bson.M{"balance": db.Price(0), "updated_at": entry.CreatedAt}},
+		options.Update().SetUpsert(true)); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return 0, errors.Wrap(err, "create credit balance")
+	}
+
+	filter := bson.M{
+		"username":     entry.Username,
+		"pending_keys": bson.M{"$ne": entry.IdempotencyKey},
+	}
+	if entry.Amount < 0 && !allowOverdraft {
+		filter["balance"] = bson.M{"$gte": -entry.Amount}
+	}
+
+	updated := new(db.CreditBalance)
+	err := l.balances.FindOneAndUpdate(ctx, filter, bson.M{
+		"$inc":  bson.M{"balance": entry.Amount},
+		"$set":  bson.M{"updated_at": entry.CreatedAt},
+		"$push": bson.M{"pending_keys": entry.IdempotencyKey},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(updated)
+	switch {
+	case err == nil:
+		return updated.Balance, nil
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return 0, errors.Wrap(err, "update credit balance")
+	}
+
+	// either the balance holds the key already, or it is too low
+	if err = l.balances.FindOne(ctx, bson.M{
+		"username":     entry.Username,
+		"pending_keys": entry.IdempotencyKey,
+	}).Decode(updated); err == nil {
+		return updated.Balance, nil
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, errors.Wrap(err, "get credit balance")
+	}
+
+	return 0, ErrInsufficientCredit
+}
+
+// Balance implements creditLedger.
+func (l *mongoCreditLedger) Balance(ctx context.Context, username string) (db.Price, error) {
+	balance := new(db.CreditBalance)
+	if err := l.balances.FindOne(ctx, bson.M{"username": username}).Decode(balance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, nil
+		}
+		return 0, errors.Wrapf(err, "get credit balance of %q", username)
+	}
+
+	return balance.Balance, nil
+}
+
+// Entry implements creditLedger.
+func (l *mongoCreditLedger) Entry(ctx context.Context, idempotencyKey string) (*db.CreditEntry, bool, error) {
+	entry := new(db.CreditEntry)
+	if err := l.ledger.FindOne(ctx, bson.M{"idempotency_key": idempotencyKey}).Decode(entry); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrapf(err, "get credit entry %q", idempotencyKey)
+	}
+
+	return entry, true, nil
+}
+
+// Entries implements creditLedger.
+func (l *mongoCreditLedger) Entries(ctx context.Context, username string, limit int) ([]db.CreditEntry, error) {
+	cur, err := l.ledger.Find(ctx, bson.M{"username": username},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "find credit entries of %q", username)
+	}
+
+	var entries []db.CreditEntry
+	if err = cur.All(ctx, &entries); err != nil {
+		return nil, errors.Wrap(err, "decode credit entries")
+	}
+
+	return entries, nil
+}
+
+// creditAccount returns the ledger account of user.
+//
+// Anonymous free-tier users share one identity and choose their own name,
+// and byok users are named by a prefix of whatever token they send, so
+// neither can hold credit. Static, sso and managed api key users are named
+// by the server.
+func creditAccount(user *config.UserConfig) (string, error) {
+	switch {
+	case user.IsAnonymous():
+		return "", errors.New("anonymous free-tier users can not hold credit, please sign in")
+	case user.BYOK:
+		return "", errors.New("users bringing their own token can not hold credit")
+	}
+	return user.UserName, nil
+}
+
+// applyPrepaidCredit upgrades a free user to paid while they hold credit.
+// The balance is cached briefly since this runs on every request.
+//
+// Image billing is switched on as well, so every priced operation goes
+// through checkUserExternalBilling, which debits the ledger.
+func applyPrepaidCredit(ctx context.Context, user *config.UserConfig) {
+	if !user.IsFree || config.Config.PaymentStripeWebhookSecret == "" {
+		return
+	}
+	account, err := creditAccount(user)
+	if err != nil {
+		return
+	}
+
+	balance, ok := creditStatusCache.Load(account)
+	if !ok {
+		ledger, err := newCreditLedger()
+		if err == nil {
+			balance, err = ledger.Balance(ctx, account)
+		}
+		if err != nil {
+			gmw.GetLogger(ctx).Warn("get credit balance, treat user as free",
+				zap.String("user", account), zap.Error(err))
+			return
+		}
+		creditStatusCache.Store(account, balance)
+	}
+
+	if balance > 0 {
+		user.IsFree = false
+		user.PrepaidCredit = true
+		user.EnableExternalImageBilling = true
+	}
+}
+
+// chargeCredit debits cost from a prepaid user's credit. It does nothing
+// for other users, whose usage is billed upstream.
+//
+// Usage that is only known afterwards, such as chat tokens, is recorded
+// with allowOverdraft so the last request of a user may run the balance
+// below zero.
+func chargeCredit(ctx context.Context,
+	user *config.UserConfig, cost db.Price, reason string, allowOverdraft bool) error {
+	if !user.PrepaidCredit || cost <= 0 {
+		return nil
+	}
+	account, err := creditAccount(user)
+	if err != nil {
+		return errors.Wrap(err, "get credit account")
+	}
+
+	ledger, err := newCreditLedger()
+	if err != nil {
+		return errors.Wrap(err, "get credit ledger")
+	}
+
+	balance, _, err := ledger.Apply(ctx, &db.CreditEntry{
+		Username:       account,
+		Type:           db.CreditDebit,
+		Amount:         -cost,
+		Reason:         reason,
+		IdempotencyKey: "debit:" + gutils.UUID7(),
+	}, allowOverdraft)
+	if err != nil {
+		return errors.Wrapf(err, "debit %d credit for %q", cost.Int(), reason)
+	}
+
+	creditStatusCache.Store(account, balance)
+	log.Logger.Named("credit").Debug("debit credit",
+		zap.String("username", account),
+		zap.String("reason", reason),
+		zap.Int("cost", cost.Int()),
+		zap.Int("balance", balance.Int()))
+	return nil
+}
+
+// refundCredit returns cost debited by chargeCredit for an operation that
+// delivered nothing.
+func refundCredit(ctx context.Context,
+	user *config.UserConfig, cost db.Price, reason string) error {
+	if !user.PrepaidCredit || cost <= 0 {
+		return nil
+	}
+	account, err := creditAccount(user)
+	if err != nil {
+		return errors.Wrap(err, "get credit account")
+	}
+
+	ledger, err := newCreditLedger()
+	if err != nil {
+		return errors.Wrap(err, "get credit ledger")
+	}
+
+	balance, _, err := ledger.Apply(ctx, &db.CreditEntry{
+		Username:       account,
+		Type:           db.CreditRefund,
+		Amount:         cost,
+		Reason:         reason,
+		IdempotencyKey: "refund:" + gutils.UUID7(),
+	}, true)
+	if err != nil {
+		return errors.Wrapf(err, "refund %d credit for %q", cost.Int(), reason)
+	}
+
+	creditStatusCache.Store(account, balance)
+	return nil
+}
+
+// chatTokensCost returns the price of tokens chat tokens, rounded up.
+func chatTokensCost(tokens int) db.Price {
+	return db.Price((tokens*db.PriceChatPer1KTokens.Int() + 999) / 1000)
+}
+
+// chargeChatCredit debits a finished chat by its token usage, and adds it
+// to the spend of a managed api key. spend is the reservation taken from
+// the key before the chat went upstream, it is settled instead.
+func chargeChatCredit(ctx context.Context,
+	user *config.UserConfig, spend *apiKeySpendReservation, tokens int) {
+	if !user.PrepaidCredit && user.APIKeyID == "" {
+		return
+	}
+
+	cost := chatTokensCost(tokens)
+	if err := chargeCredit(ctx, user, cost, "chat", true); err != nil {
+		gmw.GetLogger(ctx).Error("charge chat credit",
+			zap.String("user", user.UserName), zap.Error(err))
+	}
+
+	var err error
+	if spend != nil {
+		err = spend.Settle(ctx, tokens)
+	} else {
+		err = chargeAPIKeySpend(ctx, user, cost, true)
+	}
+	if err != nil {
+		gmw.GetLogger(ctx).Error("charge chat to api key",
+			zap.String("user", user.UserName), zap.Error(err))
+	}
+}
+
+// GetCreditBalanceHandler returns the current user's credit balance and
+// latest ledger entries.
+func GetCreditBalanceHandler(ctx *gin.Context) {
+	user, err := getUserByAuthHeader(ctx)
+	if web.AbortErr(ctx, errors.Wrap(err, "get user by auth header")) {
+		return
+	}
+	account, err := creditAccount(user)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	limit := 20
+	if v := ctx.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > 200 {
+			web.AbortErr(ctx, errors.Errorf("limit should be in (0, 200], got %q", v))
+			return
+		}
+	}
+
+	ledger, err := newCreditLedger()
+	if web.AbortErr(ctx, errors.Wrap(err, "get credit ledger")) {
+		return
+	}
+	balance, err := ledger.Balance(gmw.Ctx(ctx), account)
+	if web.AbortErr(ctx, errors.Wrap(err, "get balance")) {
+		return
+	}
+	entries, err := ledger.Entries(gmw.Ctx(ctx), account, limit)
+	if web.AbortErr(ctx, errors.Wrap(err, "get entries")) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"username":    account,
+		"balance":     balance,
+		"balance_usd": float64(balance) / float64(db.PriceUSD),
+		"paid":        balance > 0,
+		"entries":     entries,
+	})
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -9,10 +10,51 @@ import (
 	"github.com/Laisky/zap"
 	"github.com/gin-gonic/gin"
 
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
 	rutils "github.com/Laisky/go-ramjet/library/redis"
 	"github.com/Laisky/go-ramjet/library/web"
 )
 
+// DeepResearchRunner is the native deep-research backend. It lives in
+// agentx (which already depends on this package), so it is registered at
+// init time via RegisterDeepResearchRunner, the same way as the agent
+// dispatcher.
+type DeepResearchRunner interface {
+	// Create starts a task in the background and returns its id.
+	Create(ctx *gin.Context, user *config.UserConfig, prompt string) (taskID string, err error)
+	// Status returns the JSON-serialisable task state. Returns
+	// ErrDeepResearchTaskNotFound for unknown ids and tasks owned by
+	// another user.
+	Status(ctx *gin.Context, user *config.UserConfig, taskID string) (any, error)
+	// Export renders the finished report as format (md or pdf).
+	Export(ctx *gin.Context, user *config.UserConfig, taskID, format string) (
+		body []byte, contentType, filename string, err error)
+}
+
+var (
+	registeredDeepResearchRunner DeepResearchRunner
+	// ErrDeepResearchTaskNotFound is returned by DeepResearchRunner when
+	// the task is not a native one, so the handlers can fall back to the
+	// legacy LLM-storm queue.
+	ErrDeepResearchTaskNotFound = errors.New("deep research task not found")
+)
+
+// RegisterDeepResearchRunner installs the native deep-research backend.
+// Called once from agentx.init().
+func RegisterDeepResearchRunner(r DeepResearchRunner) {
+	registeredDeepResearchRunner = r
+}
+
+// nativeDeepResearchEnabled reports whether /deepresearch should use the
+// native pipeline instead of the LLM-storm queue.
+func nativeDeepResearchEnabled() bool {
+	return registeredDeepResearchRunner != nil &&
+		config.Config != nil &&
+		config.Config.AgentLoop != nil &&
+		config.Config.AgentLoop.Enabled &&
+		config.Config.AgentLoop.DeepResearch.Enabled
+}
+
 // CreateDeepresearchRequest deepresearch request
 type CreateDeepresearchRequest struct {
 	Prompt string `binding:"required,min=1" json:"prompt"`
@@ -39,8 +81,13 @@ func CreateDeepResearchHandler(c *gin.Context) {
 		return
 	}
 
-	taskID, err := rutils.GetCli().
-		AddLLMStormTask(gmw.Ctx(c), req.Prompt, user.Token)
+	var taskID string
+	if nativeDeepResearchEnabled() {
+		taskID, err = registeredDeepResearchRunner.Create(c, user, req.Prompt)
+	} else {
+		taskID, err = rutils.GetCli().
+			AddLLMStormTask(gmw.Ctx(c), req.Prompt, user.Token)
+	}
 	if web.AbortErr(c, errors.WithStack(err)) {
 		return
 	}
@@ -75,6 +122,21 @@ func GetDeepResearchStatusHandler(c *gin.Context) {
 		return
 	}
 
+	// native tasks first; ids unknown to the native store may still be
+	// in-flight storm tasks created before the switch.
+	if registeredDeepResearchRunner != nil {
+		nativeTask, err := registeredDeepResearchRunner.Status(c, user, taskID)
+		if err == nil {
+			logger.Info("get native deepresearch status", zap.String("task_id", taskID))
+			c.JSON(http.StatusOK, nativeTask)
+			return
+		}
+		if !errors.Is(err, ErrDeepResearchTaskNotFound) {
+			web.AbortErr(c, errors.WithStack(err))
+			return
+		}
+	}
+
 	task, err := rutils.GetCli().
 		GetLLMStormTaskResult(gmw.Ctx(c), taskID)
 	if web.AbortErr(c, errors.WithStack(err)) {
@@ -88,3 +150,44 @@ func GetDeepResearchStatusHandler(c *gin.Context) {
 	task.APIKey = "*******" // hide api key
 	c.JSON(http.StatusOK, task)
 }
+
+// ExportDeepResearchHandler downloads a finished native deepresearch report.
+//
+// Query:
+//   - format: md (default) or pdf.
+func ExportDeepResearchHandler(c *gin.Context) {
+	logger := gmw.GetLogger(c)
+
+	user, err := getUserByAuthHeader(c)
+	if web.AbortErr(c, errors.WithStack(err)) {
+		return
+	}
+
+	taskID := strings.TrimSpace(c.Param("task_id"))
+	if taskID == "" {
+		web.AbortErr(c, errors.New("should set task_id"))
+		return
+	}
+
+	if registeredDeepResearchRunner == nil {
+		web.AbortErr(c, errors.New("deepresearch export is not supported"))
+		return
+	}
+
+	format := strings.TrimSpace(c.DefaultQuery("format", "md"))
+	body, contentType, filename, err := registeredDeepResearchRunner.Export(c, user, taskID, format)
+	if errors.Is(err, ErrDeepResearchTaskNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"err": err.Error()})
+		return
+	}
+	if web.AbortErr(c, errors.WithStack(err)) {
+		return
+	}
+
+	logger.Info("export deepresearch report",
+		zap.String("task_id", taskID),
+		zap.String("format", format),
+		zap.Int("bytes", len(body)))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, contentType, body)
+}
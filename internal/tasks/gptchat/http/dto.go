@@ -9,6 +9,7 @@ import (
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
 	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/openai"
 )
 
 // OpenaiMessageRole message role
@@ -435,4 +436,6 @@ type ExternalBillingUserResponse struct {
 type OneShotChatRequest struct {
 	SystemPrompt string `json:"system_prompt"`
 	UserPrompt   string `binding:"required,min=1" json:"user_prompt"`
+	// JSONSchema (optional) makes the response a JSON document matching the schema
+	JSONSchema *openai.Schema `json:"json_schema,omitempty"`
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/minio/minio-go/v7"
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/s3"
 	"github.com/Laisky/go-ramjet/library/web"
 )
@@ -58,6 +59,15 @@ func UploadFiles(ctx *gin.Context) {
 		return
 	}
 
+	err = chargeCredit(gmw.Ctx(ctx), user, db.PriceUploadFile, "upload file", false)
+	if web.AbortErr(ctx, errors.Wrap(err, "charge credit")) {
+		return
+	}
+	err = chargeAPIKeySpend(gmw.Ctx(ctx), user, db.PriceUploadFile, false)
+	if web.AbortErr(ctx, errors.Wrap(err, "charge api key")) {
+		return
+	}
+
 	fileContent, err := file.Open()
 	if web.AbortErr(ctx, errors.Wrap(err, "open file")) {
 		return
@@ -71,9 +81,11 @@ func UploadFiles(ctx *gin.Context) {
 	fileBytes := buf.Bytes()
 
 	fileHashBytes := sha256.Sum256(fileBytes)
-	fileHash := hex.EncodeToString(fileHashBytes[:])
-	objkeyPrefix := fmt.Sprintf("user-files/%s/%s/%s",
-		fileHash[:2], fileHash[2:4], fileHash)
+	uploaded := uploadedFile{
+		Hash: hex.EncodeToString(fileHashBytes[:]),
+		Ext:  ext,
+	}
+	objkey := uploaded.objkey()
 
 	s3cli, err := s3.GetCli()
 	if web.AbortErr(ctx, errors.Wrap(err, "get s3 client")) {
@@ -82,7 +94,7 @@ func UploadFiles(ctx *gin.Context) {
 
 	_, err = s3cli.PutObject(ctx,
 		config.Config.S3.Bucket,
-		objkeyPrefix+ext,
+		objkey,
 		bytes.NewReader(fileBytes),
 		int64(len(fileBytes)),
 		minio.PutObjectOptions{
@@ -97,9 +109,13 @@ func UploadFiles(ctx *gin.Context) {
 		zap.String("user", user.UserName),
 		zap.String("file", file.Filename),
 		zap.String("ext", ext),
-		zap.String("objkey", objkeyPrefix+ext),
+		zap.String("objkey", objkey),
 	)
+
+	// extract documents to markdown ahead of the first chat turn using them
+	warmUploadedFileText(gmw.Ctx(ctx), user, uploaded)
+
 	ctx.JSON(200, gin.H{
-		"url": fmt.Sprintf("https://s3.laisky.com/%s/%s", config.Config.S3.Bucket, objkeyPrefix+ext),
+		"url": fmt.Sprintf("https://s3.laisky.com/%s/%s", config.Config.S3.Bucket, objkey),
 	})
 }
@@ -0,0 +1,237 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	urllib "net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/zap"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/ingest"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/s3"
+	"github.com/Laisky/go-ramjet/library/openai"
+)
+
+// ErrNotUploadedFile is returned by ReadUploadedFile for URLs that were not
+// produced by UploadFiles.
+var ErrNotUploadedFile = errors.New("not an uploaded file url")
+
+const (
+	uploadedFilePrefix     = "user-files"
+	uploadedFileTextPrefix = "user-files-text"
+	uploadedFileIngestTTL  = 3 * time.Minute
+	ocrPrompt              = "Transcribe all text in this image as Markdown. " +
+		"Keep the reading order, headings, lists and tables. " +
+		"Output only the transcription."
+)
+
+var (
+	uploadedFileKeyRegexp = regexp.MustCompile(
+		`^` + uploadedFilePrefix + `/([0-9a-f]{2})/([0-9a-f]{2})/([0-9a-f]{64})(\.[a-zA-Z0-9]{1,10})$`)
+	uploadedFileTextCache = gutils.NewExpCache[string](context.Background(), time.Hour)
+	uploadedFileIngestSF  singleflight.Group
+)
+
+// uploadedFile identifies one file stored by UploadFiles. The SHA-256 hash
+// of its content is the cache key of the extracted text.
+type uploadedFile struct {
+	Hash string
+	Ext  string
+}
+
+func (f uploadedFile) objkeyPrefix() string {
+	return fmt.Sprintf("%s/%s/%s/%s", uploadedFilePrefix, f.Hash[:2], f.Hash[2:4], f.Hash)
+}
+
+// objkey is the key of the original upload.
+func (f uploadedFile) objkey() string {
+	return f.objkeyPrefix() + f.Ext
+}
+
+// textObjkey is the key of the extracted Markdown. It lives under its own
+// prefix so it can never collide with an uploaded .md file.
+func (f uploadedFile) textObjkey() string {
+	return fmt.Sprintf("%s/%s/%s/%s.md", uploadedFileTextPrefix, f.Hash[:2], f.Hash[2:4], f.Hash)
+}
+
+// parseUploadedFileURL recognizes the URLs returned by UploadFiles.
+func parseUploadedFileURL(fileURL string) (uploadedFile, bool) {
+	if config.Config == nil {
+		return uploadedFile{}, false
+	}
+	parsed, err := urllib.Parse(strings.TrimSpace(fileURL))
+	if err != nil {
+		return uploadedFile{}, false
+	}
+	objkey, ok := strings.CutPrefix(parsed.Path, "/"+config.Config.S3.Bucket+"/")
+	if !ok {
+		return uploadedFile{}, false
+	}
+	matched := uploadedFileKeyRegexp.FindStringSubmatch(objkey)
+	if matched == nil {
+		return uploadedFile{}, false
+	}
+	return uploadedFile{Hash: matched[3], Ext: matched[4]}, true
+}
+
+// uploadedFileStore is the object storage behind uploaded files.
+type uploadedFileStore interface {
+	// Get returns ok=false when key does not exist.
+	Get(ctx context.Context, key string) (data []byte, ok bool, err error)
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+}
+
+type s3UploadedFileStore struct {
+	cli *minio.Client
+}
+
+// Get implements uploadedFileStore.
+func (s s3UploadedFileStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	object, err := s.cli.GetObject(ctx, config.Config.S3.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		if isS3NoSuchKey(err) {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrapf(err, "get object %q", key)
+	}
+	defer gutils.CloseWithLog(object, gmw.GetLogger(ctx))
+
+	return readAllOrNotFound(object)
+}
+
+// Put implements uploadedFileStore.
+func (s s3UploadedFileStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	_, err := s.cli.PutObject(ctx, config.Config.S3.Bucket, key,
+		bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType},
+	)
+	return errors.Wrapf(err, "put object %q", key)
+}
+
+// newUploadedFileStore is swapped by tests.
+var newUploadedFileStore = func() (uploadedFileStore, error) {
+	cli, err := s3.GetCli()
+	if err != nil {
+		return nil, errors.Wrap(err, "get s3 client")
+	}
+	return s3UploadedFileStore{cli: cli}, nil
+}
+
+// visionOCR recognizes image text with the user's upstream vision model.
+type visionOCR struct {
+	user *config.UserConfig
+}
+
+// OCRImage implements ingest.OCR.
+func (o visionOCR) OCRImage(ctx context.Context, image []byte) (string, error) {
+	if o.user == nil {
+		return "", errors.WithStack(ingest.ErrOCRUnavailable)
+	}
+	dataURL := fmt.Sprintf("data:%s;base64,%s", imageType(image), base64Encode(image))
+	text, err := openai.OneshotVision(ctx, o.user.APIBase, o.user.OpenaiToken,
+		config.Config.OCRModel, "", ocrPrompt, dataURL)
+	if err != nil {
+		return "", errors.Wrap(err, "ocr by vision model")
+	}
+	return text, nil
+}
+
+// ingestOCR is swapped by tests.
+var ingestOCR = func(user *config.UserConfig) ingest.OCR {
+	return visionOCR{user: user}
+}
+
+// loadUploadedFileMarkdown returns the extracted Markdown of f. The result
+// is looked up in memory, then in the object store, and only extracted from
+// the original upload when neither has it; concurrent callers for the same
+// hash share one extraction.
+func loadUploadedFileMarkdown(ctx context.Context, user *config.UserConfig, f uploadedFile) (string, error) {
+	if markdown, ok := uploadedFileTextCache.Load(f.Hash); ok {
+		return markdown, nil
+	}
+	if !ingest.Supports(f.Ext) {
+		return "", errors.Wrapf(ingest.ErrUnsupportedFormat, "ext %q", f.Ext)
+	}
+
+	v, err, _ := uploadedFileIngestSF.Do(f.Hash, func() (any, error) {
+		store, err := newUploadedFileStore()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		cached, ok, err := store.Get(ctx, f.textObjkey())
+		if err != nil {
+			return nil, errors.Wrap(err, "load extracted text")
+		}
+		if ok {
+			return string(cached), nil
+		}
+
+		content, ok, err := store.Get(ctx, f.objkey())
+		if err != nil {
+			return nil, errors.Wrap(err, "load uploaded file")
+		}
+		if !ok {
+			return nil, errors.Errorf("uploaded file %q not found", f.objkey())
+		}
+
+		markdown, err := ingest.Extract(ctx, f.Ext, content, &ingest.Options{OCR: ingestOCR(user)})
+		if err != nil {
+			return nil, errors.Wrap(err, "extract uploaded file")
+		}
+
+		if err := store.Put(ctx, f.textObjkey(), []byte(markdown), "text/markdown; charset=utf-8"); err != nil {
+			gmw.GetLogger(ctx).Warn("save extracted text", zap.Error(err))
+		}
+		return markdown, nil
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	markdown := v.(string)
+	uploadedFileTextCache.Store(f.Hash, markdown)
+	return markdown, nil
+}
+
+// ReadUploadedFile returns the normalized Markdown of a file uploaded via
+// UploadFiles, extracting and caching it on first use. It returns
+// ErrNotUploadedFile for any other URL.
+func ReadUploadedFile(ctx context.Context, user *config.UserConfig, fileURL string) (string, error) {
+	f, ok := parseUploadedFileURL(fileURL)
+	if !ok {
+		return "", errors.Wrapf(ErrNotUploadedFile, "%q", fileURL)
+	}
+	return loadUploadedFileMarkdown(ctx, user, f)
+}
+
+// warmUploadedFileText extracts a fresh upload in the background so the
+// first chat turn that references it does not pay the extraction latency.
+func warmUploadedFileText(ctx context.Context, user *config.UserConfig, f uploadedFile) {
+	if !ingest.Supports(f.Ext) {
+		return
+	}
+	logger := gmw.GetLogger(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), uploadedFileIngestTTL)
+		defer cancel()
+
+		if _, err := loadUploadedFileMarkdown(ctx, user, f); err != nil {
+			logger.Warn("ingest uploaded file",
+				zap.String("objkey", f.objkey()),
+				zap.Error(err))
+			return
+		}
+		logger.Debug("ingest uploaded file success", zap.String("objkey", f.objkey()))
+	}()
+}
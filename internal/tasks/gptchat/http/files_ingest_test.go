@@ -0,0 +1,116 @@
+package http
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Laisky/testify/require"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/ingest"
+)
+
+// memoryUploadedFileStore keeps objects in a map and counts reads.
+type memoryUploadedFileStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	gets    map[string]int
+}
+
+func (s *memoryUploadedFileStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets[key]++
+	data, ok := s.objects[key]
+	return data, ok, nil
+}
+
+func (s *memoryUploadedFileStore) Put(_ context.Context, key string, data []byte, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func setupUploadedFileStore(t *testing.T) *memoryUploadedFileStore {
+	t.Helper()
+	originalConfig := config.Config
+	config.Config = &config.OpenAI{}
+	config.Config.S3.Bucket = "bucket"
+	t.Cleanup(func() { config.Config = originalConfig })
+
+	store := &memoryUploadedFileStore{objects: map[string][]byte{}, gets: map[string]int{}}
+	originalStore := newUploadedFileStore
+	newUploadedFileStore = func() (uploadedFileStore, error) { return store, nil }
+	t.Cleanup(func() { newUploadedFileStore = originalStore })
+	return store
+}
+
+func TestParseUploadedFileURL(t *testing.T) {
+	setupUploadedFileStore(t)
+	hash := strings.Repeat("ab", 32)
+
+	f, ok := parseUploadedFileURL("https://s3.laisky.com/bucket/user-files/ab/ab/" + hash + ".PDF")
+	require.True(t, ok)
+	require.Equal(t, uploadedFile{Hash: hash, Ext: ".PDF"}, f)
+	require.Equal(t, "user-files/ab/ab/"+hash+".PDF", f.objkey())
+	require.Equal(t, "user-files-text/ab/ab/"+hash+".md", f.textObjkey())
+
+	for _, u := range []string{
+		"https://s3.laisky.com/other-bucket/user-files/ab/ab/" + hash + ".pdf",
+		"https://s3.laisky.com/bucket/user-files/ab/ab/" + hash,
+		"https://s3.laisky.com/bucket/user-files/ab/ab/short.pdf",
+		"https://example.com/doc.pdf",
+	} {
+		_, ok := parseUploadedFileURL(u)
+		require.False(t, ok, u)
+	}
+}
+
+type staticOCR string
+
+func (s staticOCR) OCRImage(context.Context, []byte) (string, error) { return string(s), nil }
+
+func TestReadUploadedFile(t *testing.T) {
+	store := setupUploadedFileStore(t)
+	originalOCR := ingestOCR
+	ingestOCR = func(*config.UserConfig) ingest.OCR { return staticOCR("text in image") }
+	t.Cleanup(func() { ingestOCR = originalOCR })
+
+	csvFile := uploadedFile{Hash: strings.Repeat("c1", 32), Ext: ".csv"}
+	store.objects[csvFile.objkey()] = []byte("a,b\n1,2\n")
+	csvURL := "https://s3.laisky.com/bucket/" + csvFile.objkey()
+
+	markdown, err := ReadUploadedFile(context.Background(), &config.UserConfig{}, csvURL)
+	require.NoError(t, err)
+	require.Equal(t, "| a | b |\n| --- | --- |\n| 1 | 2 |", markdown)
+	require.Equal(t, []byte(markdown), store.objects[csvFile.textObjkey()])
+
+	// served from the in-memory cache on the second read
+	_, err = ReadUploadedFile(context.Background(), &config.UserConfig{}, csvURL)
+	require.NoError(t, err)
+	require.Equal(t, 1, store.gets[csvFile.objkey()])
+
+	// a previously extracted text is reused without touching the original
+	mdFile := uploadedFile{Hash: strings.Repeat("d2", 32), Ext: ".docx"}
+	store.objects[mdFile.textObjkey()] = []byte("cached text")
+	markdown, err = ReadUploadedFile(context.Background(), nil, "https://s3.laisky.com/bucket/"+mdFile.objkey())
+	require.NoError(t, err)
+	require.Equal(t, "cached text", markdown)
+	require.Zero(t, store.gets[mdFile.objkey()])
+
+	imgFile := uploadedFile{Hash: strings.Repeat("e3", 32), Ext: ".png"}
+	store.objects[imgFile.objkey()] = []byte("png")
+	markdown, err = ReadUploadedFile(context.Background(), nil, "https://s3.laisky.com/bucket/"+imgFile.objkey())
+	require.NoError(t, err)
+	require.Equal(t, "text in image", markdown)
+
+	_, err = ReadUploadedFile(context.Background(), nil, "https://example.com/a.pdf")
+	require.ErrorIs(t, err, ErrNotUploadedFile)
+
+	missing := uploadedFile{Hash: strings.Repeat("f4", 32), Ext: ".pdf"}
+	_, err = ReadUploadedFile(context.Background(), nil, "https://s3.laisky.com/bucket/"+missing.objkey())
+	require.ErrorContains(t, err, "not found")
+}
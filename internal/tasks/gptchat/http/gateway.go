@@ -0,0 +1,1140 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+// The OpenAI-compatible gateway serves /gptchat/v1/* for third-party
+// clients. Requests are translated into a FrontendReq and run through the
+// same pipeline as the SPA (ChatHandler): authentication, model allowlist,
+// moderation, quota, memory and the server-side tool loop. The SPA-style
+// chat completion output of the pipeline is then rewritten by
+// gatewayWriter into the wire format the client asked for.
+
+const (
+	// ctxKeyGatewayReq holds the *FrontendReq translated by the gateway,
+	// read by convert2UpstreamResponsesRequest instead of the body.
+	ctxKeyGatewayReq = "ctx_gateway_req"
+	// ctxKeyGatewayWriter holds the *gatewayWriter of the request.
+	ctxKeyGatewayWriter = "ctx_gateway_writer"
+
+	// maxGatewayRequestBytes caps the request bodies of the gateway,
+	// leaving room for a few inline images.
+	maxGatewayRequestBytes = 32 << 20
+	// maxEmbeddingsResponseBytes caps the upstream embeddings response,
+	// enough for a full batch of large vectors.
+	maxEmbeddingsResponseBytes = 256 << 20
+)
+
+var errGatewayClientTools = errors.New("client-side tools are not supported, " +
+	"tools run server-side: pass MCP servers in `mcp_servers` instead")
+
+// gatewayBodyTooLargeError is returned for request bodies over
+// maxGatewayRequestBytes.
+type gatewayBodyTooLargeError struct {
+	limit int64
+}
+
+func (e *gatewayBodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body exceeds %d bytes", e.limit)
+}
+
+// HTTPStatus returns 413.
+func (e *gatewayBodyTooLargeError) HTTPStatus() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// gatewayParseErr wraps an error of reading or parsing the request body.
+func gatewayParseErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &gatewayBodyTooLargeError{limit: tooLarge.Limit}
+	}
+	return errors.Wrap(err, "parse request")
+}
+
+// requestFrontendReq returns the request translated by the gateway, or
+// parses the SPA request body.
+func requestFrontendReq(ctx *gin.Context) (*FrontendReq, error) {
+	if v, ok := ctx.Get(ctxKeyGatewayReq); ok {
+		if req, ok := v.(*FrontendReq); ok && req != nil {
+			return req, nil
+		}
+	}
+	return bodyChecker(ctx.Request.Body)
+}
+
+// gatewaySwitches returns the chat switches of gateway requests. URL
+// crawling is off so the prompt is what the client sent.
+func gatewaySwitches(enableMemory *bool) *FrontendReq {
+	req := &FrontendReq{}
+	req.LaiskyExtra = &struct {
+		ChatSwitch struct {
+			DisableHttpsCrawler bool  `json:"disable_https_crawler"`
+			EnableGoogleSearch  bool  `json:"enable_google_search"`
+			EnableMemory        *bool `json:"enable_memory,omitempty"`
+			AgentMode           *bool `json:"agent_mode,omitempty"`
+		} `json:"chat_switch"`
+	}{}
+	req.LaiskyExtra.ChatSwitch.DisableHttpsCrawler = true
+	req.LaiskyExtra.ChatSwitch.EnableMemory = enableMemory
+	return req
+}
+
+// gatewayChatRequest is an OpenAI chat completions request.
+type gatewayChatRequest struct {
+	Model         string               `json:"model"`
+	Messages      []gatewayChatMessage `json:"messages"`
+	Stream        bool                 `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	MaxTokens           uint                `json:"max_tokens"`
+	MaxCompletionTokens uint                `json:"max_completion_tokens"`
+	Temperature         float64             `json:"temperature"`
+	TopP                float64             `json:"top_p"`
+	N                   int                 `json:"n"`
+	PresencePenalty     float64             `json:"presence_penalty"`
+	FrequencyPenalty    float64             `json:"frequency_penalty"`
+	ReasoningEffort     string              `json:"reasoning_effort,omitempty"`
+	Tools               []OpenaiChatReqTool `json:"tools,omitempty"`
+	ToolChoice          any                 `json:"tool_choice,omitempty"`
+
+	// MCPServers (extension) MCP servers whose tools run server-side
+	MCPServers []MCPServerConfig `json:"mcp_servers,omitempty"`
+	// EnableMemory (extension) turns memory off for this request
+	EnableMemory *bool `json:"enable_memory,omitempty"`
+}
+
+// gatewayChatMessage is one message of a chat completions request.
+type gatewayChatMessage struct {
+	Role       OpenaiMessageRole         `json:"role"`
+	Content    FrontendReqMessageContent `json:"content"`
+	ToolCalls  []stdjson.RawMessage      `json:"tool_calls,omitempty"`
+	ToolCallID string                    `json:"tool_call_id,omitempty"`
+}
+
+// toFrontendReq translates r into the pipeline's request.
+func (r *gatewayChatRequest) toFrontendReq() (*FrontendReq, error) {
+	if len(r.Tools) != 0 {
+		return nil, errGatewayClientTools
+	}
+	if r.N > 1 {
+		return nil, errors.New("n > 1 is not supported")
+	}
+
+	req := gatewaySwitches(r.EnableMemory)
+	req.Model = strings.TrimSpace(r.Model)
+	req.Stream = r.Stream
+	req.MaxTokens = gutils.OptionalVal(&r.MaxCompletionTokens, r.MaxTokens)
+	req.Temperature = r.Temperature
+	req.TopP = r.TopP
+	req.PresencePenalty = r.PresencePenalty
+	req.FrequencyPenalty = r.FrequencyPenalty
+	req.ReasoningEffort = r.ReasoningEffort
+	req.MCPServers = r.MCPServers
+	req.ToolChoice = r.ToolChoice
+
+	for i, m := range r.Messages {
+		if m.Role == "tool" || len(m.ToolCalls) != 0 || m.ToolCallID != "" {
+			return nil, errors.Wrapf(errGatewayClientTools, "messages[%d]", i)
+		}
+		if m.Role == "" {
+			return nil, errors.Errorf("messages[%d].role is empty", i)
+		}
+		req.Messages = append(req.Messages, FrontendReqMessage{Role: m.Role, Content: m.Content})
+	}
+	if len(req.Messages) == 0 {
+		return nil, errors.New("no messages")
+	}
+
+	req.fillDefault()
+	return req, nil
+}
+
+// gatewayResponsesRequest is an OpenAI Responses API request.
+type gatewayResponsesRequest struct {
+	Model           string             `json:"model"`
+	Input           stdjson.RawMessage `json:"input"`
+	Instructions    string             `json:"instructions,omitempty"`
+	Stream          bool               `json:"stream"`
+	MaxOutputTokens uint               `json:"max_output_tokens"`
+	Temperature     float64            `json:"temperature"`
+	TopP            float64            `json:"top_p"`
+	Reasoning       *struct {
+		Effort string `json:"effort"`
+	} `json:"reasoning,omitempty"`
+	Tools              []stdjson.RawMessage `json:"tools,omitempty"`
+	ToolChoice         any                  `json:"tool_choice,omitempty"`
+	PreviousResponseID string               `json:"previous_response_id,omitempty"`
+
+	// MCPServers (extension) MCP servers whose tools run server-side
+	MCPServers []MCPServerConfig `json:"mcp_servers,omitempty"`
+	// EnableMemory (extension) turns memory off for this request
+	EnableMemory *bool `json:"enable_memory,omitempty"`
+}
+
+// gatewayResponsesItem is one input item of a Responses API request.
+type gatewayResponsesItem struct {
+	Type    string             `json:"type"`
+	Role    OpenaiMessageRole  `json:"role"`
+	Content stdjson.RawMessage `json:"content"`
+}
+
+// gatewayResponsesPart is one content part of a Responses API input item.
+type gatewayResponsesPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL string `json:"image_url"`
+}
+
+// toFrontendReq translates r into the pipeline's request.
+func (r *gatewayResponsesRequest) toFrontendReq() (*FrontendReq, error) {
+	switch {
+	case len(r.Tools) != 0:
+		return nil, errGatewayClientTools
+	case r.PreviousResponseID != "":
+		return nil, errors.New("previous_response_id is not supported, responses are not stored: " +
+			"send the whole conversation in `input`")
+	}
+
+	req := gatewaySwitches(r.EnableMemory)
+	req.Model = strings.TrimSpace(r.Model)
+	req.Stream = r.Stream
+	req.MaxTokens = r.MaxOutputTokens
+	req.Temperature = r.Temperature
+	req.TopP = r.TopP
+	req.MCPServers = r.MCPServers
+	req.ToolChoice = r.ToolChoice
+	if r.Reasoning != nil {
+		req.ReasoningEffort = r.Reasoning.Effort
+	}
+
+	if r.Instructions != "" {
+		req.Messages = append(req.Messages, FrontendReqMessage{
+			Role:    OpenaiMessageRoleSystem,
+			Content: FrontendReqMessageContent{StringContent: r.Instructions},
+		})
+	}
+
+	input := bytes.TrimSpace(r.Input)
+	switch {
+	case len(input) == 0:
+	case input[0] == '"':
+		var text string
+		if err := json.Unmarshal(input, &text); err != nil {
+			return nil, errors.Wrap(err, "parse input")
+		}
+		req.Messages = append(req.Messages, FrontendReqMessage{
+			Role:    OpenaiMessageRoleUser,
+			Content: FrontendReqMessageContent{StringContent: text},
+		})
+	default:
+		var items []gatewayResponsesItem
+		if err := json.Unmarshal(input, &items); err != nil {
+			return nil, errors.Wrap(err, "input should be a string or a list of messages")
+		}
+		for i, item := range items {
+			msg, err := item.toMessage()
+			if err != nil {
+				return nil, errors.Wrapf(err, "input[%d]", i)
+			}
+			req.Messages = append(req.Messages, msg)
+		}
+	}
+	if len(req.Messages) == 0 {
+		return nil, errors.New("no input")
+	}
+
+	req.fillDefault()
+	return req, nil
+}
+
+// toMessage translates a message item, rejecting tool call items.
+func (item gatewayResponsesItem) toMessage() (FrontendReqMessage, error) {
+	msg := FrontendReqMessage{Role: item.Role}
+	switch {
+	case item.Type != "" && item.Type != "message":
+		if strings.HasPrefix(item.Type, "function_call") {
+			return msg, errGatewayClientTools
+		}
+		return msg, errors.Errorf("item type %q is not supported", item.Type)
+	case item.Role == "":
+		return msg, errors.New("role is empty")
+	}
+
+	content := bytes.TrimSpace(item.Content)
+	if len(content) != 0 && content[0] == '"' {
+		if err := json.Unmarshal(content, &msg.Content.StringContent); err != nil {
+			return msg, errors.Wrap(err, "parse content")
+		}
+		return msg, nil
+	}
+
+	var parts []gatewayResponsesPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return msg, errors.Wrap(err, "content should be a string or a list of parts")
+	}
+	for _, p := range parts {
+		switch p.Type {
+		case "input_text", "output_text", "text":
+			msg.Content.ArrayContent = append(msg.Content.ArrayContent, OpenaiVisionMessageContent{
+				Type: OpenaiVisionMessageContentTypeText,
+				Text: p.Text,
+			})
+		case "input_image":
+			msg.Content.ArrayContent = append(msg.Content.ArrayContent, OpenaiVisionMessageContent{
+				Type:     OpenaiVisionMessageContentTypeImageUrl,
+				ImageUrl: &OpenaiVisionMessageContentImageUrl{URL: p.ImageURL},
+			})
+		default:
+			return msg, errors.Errorf("content type %q is not supported", p.Type)
+		}
+	}
+	return msg, nil
+}
+
+// gatewayTranslator rewrites the SPA-style chat completion output of the
+// pipeline into another wire format.
+type gatewayTranslator interface {
+	// Event translates the data of one stream chunk into SSE frames.
+	Event(data []byte) []byte
+	// Done returns the frames closing a stream.
+	Done() []byte
+	// Fail returns the frames closing a stream that broke with msg.
+	Fail(msg string) []byte
+	// Body translates a complete non-streaming response.
+	Body(data []byte) ([]byte, error)
+}
+
+// gatewayWriter buffers and translates what the pipeline writes. Streams
+// are translated frame by frame, other responses once the handler is done.
+// Error responses are rewritten into OpenAI's error object.
+type gatewayWriter struct {
+	gin.ResponseWriter
+
+	mu      sync.Mutex
+	tr      gatewayTranslator
+	decided bool
+	stream  bool
+	done    bool
+	buf     []byte
+}
+
+// GatewayMiddleware wraps the response writer of the OpenAI-compatible
+// routes, so every response, errors of earlier middlewares included, is in
+// OpenAI's format.
+func GatewayMiddleware(ctx *gin.Context) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxGatewayRequestBytes)
+	w := &gatewayWriter{ResponseWriter: ctx.Writer}
+	ctx.Writer = w
+	ctx.Set(ctxKeyGatewayWriter, w)
+	defer func() { ctx.Writer = w.ResponseWriter }()
+
+	ctx.Next()
+	w.finish()
+}
+
+// setGatewayTranslator sets the translator of the request's gateway writer.
+func setGatewayTranslator(ctx *gin.Context, tr gatewayTranslator) {
+	if v, ok := ctx.Get(ctxKeyGatewayWriter); ok {
+		if w, ok := v.(*gatewayWriter); ok {
+			w.mu.Lock()
+			w.tr = tr
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Write implements gin.ResponseWriter.
+func (w *gatewayWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return len(p), nil
+	}
+	if !w.decided {
+		w.decided = true
+		w.stream = w.tr != nil &&
+			strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+	}
+
+	w.buf = append(w.buf, p...)
+	if !w.stream {
+		return len(p), nil
+	}
+
+	for !w.done {
+		i := bytes.Index(w.buf, []byte("\n\n"))
+		if i < 0 {
+			break
+		}
+		out := w.frame(w.buf[:i])
+		w.buf = w.buf[i+2:]
+		if len(out) != 0 {
+			if _, err := w.ResponseWriter.Write(out); err != nil {
+				return 0, errors.Wrap(err, "write translated frame")
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// WriteString implements gin.ResponseWriter.
+func (w *gatewayWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush implements http.Flusher, it is a no-op until the stream starts.
+func (w *gatewayWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// frame translates one SSE frame. Heartbeats become SSE comments.
+func (w *gatewayWriter) frame(frame []byte) []byte {
+	var out []byte
+	for _, line := range bytes.Split(frame, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte(":")) {
+			out = append(append(out, line...), "\n\n"...)
+			continue
+		}
+		payload, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+
+		switch payload = bytes.TrimSpace(payload); string(payload) {
+		case "[HEARTBEAT]":
+			out = append(out, ": heartbeat\n\n"...)
+		case "[DONE]":
+			w.done = true
+			return append(out, w.tr.Done()...)
+		default:
+			out = append(out, w.tr.Event(payload)...)
+		}
+	}
+	return out
+}
+
+// finish writes the buffered response once the handler is done.
+func (w *gatewayWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	w.done = true
+
+	if w.stream {
+		// the stream broke before [DONE], what is left is an error body
+		_, _ = w.ResponseWriter.Write(w.tr.Fail(gatewayErrorMessage(w.Status(), w.buf)))
+		w.ResponseWriter.Flush()
+		return
+	}
+
+	body := w.buf
+	switch {
+	case w.Status() >= http.StatusBadRequest:
+		body = gatewayErrorBody(w.Status(), body)
+		w.Header().Set("Content-Type", "application/json")
+	case w.tr != nil && len(body) != 0:
+		translated, err := w.tr.Body(body)
+		if err != nil {
+			log.Logger.Warn("translate gateway response", zap.Error(err))
+			break
+		}
+		body = translated
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if len(body) != 0 {
+		_, _ = w.ResponseWriter.Write(body)
+	}
+}
+
+// gatewayErrorMessage extracts the message of an error body.
+func gatewayErrorMessage(status int, body []byte) string {
+	var payload struct {
+		Err   string `json:"err"`
+		Error any    `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		switch e := payload.Error.(type) {
+		case string:
+			return e
+		case map[string]any:
+			if msg, ok := e["message"].(string); ok {
+				return msg
+			}
+		}
+		if payload.Err != "" {
+			return payload.Err
+		}
+	}
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return msg
+	}
+	if status < http.StatusBadRequest {
+		return "stream ended unexpectedly"
+	}
+	return http.StatusText(status)
+}
+
+// gatewayErrorBody rewrites an error body into OpenAI's error object.
+// Bodies already in that shape, e.g. from upstream, are kept.
+func gatewayErrorBody(status int, body []byte) []byte {
+	var upstream struct {
+		Error map[string]any `json:"error"`
+	}
+	if err := json.Unmarshal(body, &upstream); err == nil && upstream.Error["message"] != nil {
+		return body
+	}
+
+	out, _ := json.Marshal(gin.H{"error": gin.H{
+		"message": gatewayErrorMessage(status, body),
+		"type":    gatewayErrorType(status),
+		"param":   nil,
+		"code":    nil,
+	}})
+	return out
+}
+
+// gatewayErrorType returns OpenAI's error type of status.
+func gatewayErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= http.StatusInternalServerError:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// gatewayChatUsage is the token usage of a chat completion.
+type gatewayChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func newGatewayChatUsage(prompt, completion int) *gatewayChatUsage {
+	return &gatewayChatUsage{
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+		TotalTokens:      prompt + completion,
+	}
+}
+
+// gatewayChatChunk is a chat.completion.chunk.
+type gatewayChatChunk struct {
+	ID      string               `json:"id"`
+	Object  string               `json:"object"`
+	Created int64                `json:"created"`
+	Model   string               `json:"model"`
+	Choices []gatewayChunkChoice `json:"choices"`
+	Usage   *gatewayChatUsage    `json:"usage,omitempty"`
+}
+
+type gatewayChunkChoice struct {
+	Index        int               `json:"index"`
+	Delta        gatewayChunkDelta `json:"delta"`
+	FinishReason *string           `json:"finish_reason"`
+}
+
+type gatewayChunkDelta struct {
+	Role             string  `json:"role,omitempty"`
+	Content          *string `json:"content,omitempty"`
+	ReasoningContent string  `json:"reasoning_content,omitempty"`
+}
+
+// gatewayChatCompletion is a chat.completion.
+type gatewayChatCompletion struct {
+	ID      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Model   string              `json:"model"`
+	Choices []gatewayChatChoice `json:"choices"`
+	Usage   *gatewayChatUsage   `json:"usage"`
+}
+
+type gatewayChatChoice struct {
+	Index   int `json:"index"`
+	Message struct {
+		Role             string `json:"role"`
+		Content          string `json:"content"`
+		ReasoningContent string `json:"reasoning_content,omitempty"`
+	} `json:"message"`
+	FinishReason string `json:"finish_reason"`
+}
+
+// chatCompletionsTranslator normalizes the pipeline's chat completion
+// output: a stable id, null finish reasons and token usage.
+type chatCompletionsTranslator struct {
+	id           string
+	created      int64
+	model        string
+	promptTokens int
+	includeUsage bool
+
+	roleSent bool
+	content  strings.Builder
+}
+
+func newChatCompletionsTranslator(model string, promptTokens int, includeUsage bool) *chatCompletionsTranslator {
+	return &chatCompletionsTranslator{
+		id:           "chatcmpl-" + gutils.RandomStringWithLength(24),
+		created:      time.Now().Unix(),
+		model:        model,
+		promptTokens: promptTokens,
+		includeUsage: includeUsage,
+	}
+}
+
+func (t *chatCompletionsTranslator) chunk(choices []gatewayChunkChoice, usage *gatewayChatUsage) []byte {
+	data, err := json.Marshal(gatewayChatChunk{
+		ID:      t.id,
+		Object:  "chat.completion.chunk",
+		Created: t.created,
+		Model:   t.model,
+		Choices: choices,
+		Usage:   usage,
+	})
+	if err != nil {
+		return nil
+	}
+	return append(append([]byte("data: "), data...), "\n\n"...)
+}
+
+// Event implements gatewayTranslator.
+func (t *chatCompletionsTranslator) Event(data []byte) []byte {
+	var in OpenaiCompletionStreamResp
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil
+	}
+
+	var choices []gatewayChunkChoice
+	for _, c := range in.Choices {
+		out := gatewayChunkChoice{Index: c.Index}
+		if text, ok := c.Delta.Content.(string); ok && text != "" {
+			out.Delta.Content = &text
+			t.content.WriteString(text)
+		}
+		out.Delta.ReasoningContent = c.Delta.ReasoningContent
+		if c.FinishReason != "" {
+			reason := c.FinishReason
+			out.FinishReason = &reason
+		}
+		if out.Delta.Content == nil && out.Delta.ReasoningContent == "" && out.FinishReason == nil {
+			continue
+		}
+		if !t.roleSent {
+			t.roleSent = true
+			out.Delta.Role = OpenaiMessageRoleAI
+		}
+		choices = append(choices, out)
+	}
+	if len(choices) == 0 {
+		return nil
+	}
+	return t.chunk(choices, nil)
+}
+
+// Done implements gatewayTranslator.
+func (t *chatCompletionsTranslator) Done() []byte {
+	var out []byte
+	if t.includeUsage {
+		out = t.chunk([]gatewayChunkChoice{},
+			newGatewayChatUsage(t.promptTokens, CountTextTokens(t.content.String())))
+	}
+	return append(out, "data: [DONE]\n\n"...)
+}
+
+// Fail implements gatewayTranslator.
+func (t *chatCompletionsTranslator) Fail(msg string) []byte {
+	data, _ := json.Marshal(gin.H{"error": gin.H{"message": msg, "type": "server_error"}})
+	return append(append([]byte("data: "), data...), "\n\ndata: [DONE]\n\n"...)
+}
+
+// Body implements gatewayTranslator.
+func (t *chatCompletionsTranslator) Body(data []byte) ([]byte, error) {
+	var in OpenaiCompletionResp
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, errors.Wrap(err, "parse chat completion")
+	}
+
+	out := gatewayChatCompletion{
+		ID:      t.id,
+		Object:  "chat.completion",
+		Created: t.created,
+		Model:   t.model,
+	}
+	var completion string
+	for _, c := range in.Choices {
+		choice := gatewayChatChoice{Index: c.Index, FinishReason: gutils.OptionalVal(&c.FinishReason, "stop")}
+		choice.Message.Role = OpenaiMessageRoleAI
+		choice.Message.Content = c.Message.Content
+		choice.Message.ReasoningContent = c.Message.ReasoningContent
+		completion += c.Message.Content
+		out.Choices = append(out.Choices, choice)
+	}
+	out.Usage = newGatewayChatUsage(t.promptTokens, CountTextTokens(completion))
+
+	return json.Marshal(out)
+}
+
+// gatewayResponse is a Responses API response object.
+type gatewayResponse struct {
+	ID        string                `json:"id"`
+	Object    string                `json:"object"`
+	CreatedAt int64                 `json:"created_at"`
+	Status    string                `json:"status"`
+	Model     string                `json:"model"`
+	Output    []gatewayResponseItem `json:"output"`
+	Usage     *gatewayResponseUsage `json:"usage"`
+	Error     any                   `json:"error"`
+}
+
+type gatewayResponseItem struct {
+	ID      string                   `json:"id"`
+	Type    string                   `json:"type"`
+	Status  string                   `json:"status"`
+	Role    string                   `json:"role"`
+	Content []gatewayResponseContent `json:"content"`
+}
+
+type gatewayResponseContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+type gatewayResponseUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// responsesTranslator turns the pipeline's chat completion output into a
+// Responses API response with one assistant message. Reasoning and tool
+// progress are not forwarded.
+type responsesTranslator struct {
+	id           string
+	itemID       string
+	created      int64
+	model        string
+	promptTokens int
+
+	seq     int
+	started bool
+	text    strings.Builder
+}
+
+func newResponsesTranslator(model string, promptTokens int) *responsesTranslator {
+	return &responsesTranslator{
+		id:           "resp_" + gutils.RandomStringWithLength(24),
+		itemID:       "msg_" + gutils.RandomStringWithLength(24),
+		created:      time.Now().Unix(),
+		model:        model,
+		promptTokens: promptTokens,
+	}
+}
+
+func (t *responsesTranslator) part() gatewayResponseContent {
+	return gatewayResponseContent{Type: "output_text", Text: t.text.String(), Annotations: []any{}}
+}
+
+func (t *responsesTranslator) item(status string) gatewayResponseItem {
+	item := gatewayResponseItem{
+		ID:      t.itemID,
+		Type:    "message",
+		Status:  status,
+		Role:    OpenaiMessageRoleAI,
+		Content: []gatewayResponseContent{},
+	}
+	if status == "completed" {
+		item.Content = append(item.Content, t.part())
+	}
+	return item
+}
+
+func (t *responsesTranslator) response(status string, respErr any) gatewayResponse {
+	resp := gatewayResponse{
+		ID:        t.id,
+		Object:    "response",
+		CreatedAt: t.created,
+		Status:    status,
+		Model:     t.model,
+		Output:    []gatewayResponseItem{},
+		Error:     respErr,
+	}
+	if status == "completed" {
+		resp.Output = append(resp.Output, t.item(status))
+		output := CountTextTokens(t.text.String())
+		resp.Usage = &gatewayResponseUsage{
+			InputTokens:  t.promptTokens,
+			OutputTokens: output,
+			TotalTokens:  t.promptTokens + output,
+		}
+	}
+	return resp
+}
+
+// event encodes one named SSE event.
+func (t *responsesTranslator) event(typ string, fields gin.H) []byte {
+	fields["type"] = typ
+	fields["sequence_number"] = t.seq
+	t.seq++
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return []byte("event: " + typ + "\ndata: " + string(data) + "\n\n")
+}
+
+// start returns the events opening the response, once.
+func (t *responsesTranslator) start() []byte {
+	if t.started {
+		return nil
+	}
+	t.started = true
+	out := t.event("response.created", gin.H{"response": t.response("in_progress", nil)})
+	out = append(out, t.event("response.output_item.added", gin.H{
+		"output_index": 0,
+		"item":         t.item("in_progress"),
+	})...)
+	return append(out, t.event("response.content_part.added", gin.H{
+		"item_id":       t.itemID,
+		"output_index":  0,
+		"content_index": 0,
+		"part":          gatewayResponseContent{Type: "output_text", Annotations: []any{}},
+	})...)
+}
+
+// Event implements gatewayTranslator.
+func (t *responsesTranslator) Event(data []byte) []byte {
+	var in OpenaiCompletionStreamResp
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil
+	}
+
+	out := t.start()
+	for _, c := range in.Choices {
+		text, ok := c.Delta.Content.(string)
+		if !ok || text == "" {
+			continue
+		}
+		t.text.WriteString(text)
+		out = append(out, t.event("response.output_text.delta", gin.H{
+			"item_id":       t.itemID,
+			"output_index":  0,
+			"content_index": 0,
+			"delta":         text,
+		})...)
+	}
+	return out
+}
+
+// Done implements gatewayTranslator.
+func (t *responsesTranslator) Done() []byte {
+	out := t.start()
+	out = append(out, t.event("response.output_text.done", gin.H{
+		"item_id":       t.itemID,
+		"output_index":  0,
+		"content_index": 0,
+		"text":          t.text.String(),
+	})...)
+	out = append(out, t.event("response.content_part.done", gin.H{
+		"item_id":       t.itemID,
+		"output_index":  0,
+		"content_index": 0,
+		"part":          t.part(),
+	})...)
+	out = append(out, t.event("response.output_item.done", gin.H{
+		"output_index": 0,
+		"item":         t.item("completed"),
+	})...)
+	return append(out, t.event("response.completed", gin.H{"response": t.response("completed", nil)})...)
+}
+
+// Fail implements gatewayTranslator.
+func (t *responsesTranslator) Fail(msg string) []byte {
+	out := t.start()
+	out = append(out, t.event("error", gin.H{"code": "server_error", "message": msg, "param": nil})...)
+	return append(out, t.event("response.failed", gin.H{"response": t.response("failed",
+		gin.H{"code": "server_error", "message": msg})})...)
+}
+
+// Body implements gatewayTranslator.
+func (t *responsesTranslator) Body(data []byte) ([]byte, error) {
+	var in OpenaiCompletionResp
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, errors.Wrap(err, "parse chat completion")
+	}
+	for _, c := range in.Choices {
+		t.text.WriteString(c.Message.Content)
+	}
+	return json.Marshal(t.response("completed", nil))
+}
+
+// runGatewayChat runs req through the chat pipeline, translating its
+// output with tr.
+func runGatewayChat(ctx *gin.Context, req *FrontendReq, tr gatewayTranslator) {
+	setGatewayTranslator(ctx, tr)
+	ctx.Set(ctxKeyGatewayReq, req)
+	ChatHandler(ctx)
+}
+
+// GatewayChatCompletionsHandler serves POST /v1/chat/completions.
+func GatewayChatCompletionsHandler(ctx *gin.Context) {
+	in := new(gatewayChatRequest)
+	if err := ctx.ShouldBindJSON(in); web.AbortErr(ctx, gatewayParseErr(err)) {
+		return
+	}
+	req, err := in.toFrontendReq()
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	includeUsage := in.StreamOptions != nil && in.StreamOptions.IncludeUsage
+	runGatewayChat(ctx, req, newChatCompletionsTranslator(req.Model, req.PromptTokens(), includeUsage))
+}
+
+// GatewayResponsesHandler serves POST /v1/responses.
+func GatewayResponsesHandler(ctx *gin.Context) {
+	in := new(gatewayResponsesRequest)
+	if err := ctx.ShouldBindJSON(in); web.AbortErr(ctx, gatewayParseErr(err)) {
+		return
+	}
+	req, err := in.toFrontendReq()
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	runGatewayChat(ctx, req, newResponsesTranslator(req.Model, req.PromptTokens()))
+}
+
+// gatewayEmbeddingInputs returns the texts of an embeddings input and its
+// token count. Token arrays count one token per element.
+func gatewayEmbeddingInputs(raw stdjson.RawMessage) (texts []string, tokens int, err error) {
+	raw = bytes.TrimSpace(raw)
+	var text string
+	if err = json.Unmarshal(raw, &text); err == nil {
+		return []string{text}, CountTextTokens(text), nil
+	}
+	if err = json.Unmarshal(raw, &texts); err == nil {
+		for _, t := range texts {
+			tokens += CountTextTokens(t)
+		}
+		return texts, tokens, nil
+	}
+	var ids []int
+	if err = json.Unmarshal(raw, &ids); err == nil {
+		return nil, len(ids), nil
+	}
+	var batches [][]int
+	if err = json.Unmarshal(raw, &batches); err == nil {
+		for _, b := range batches {
+			tokens += len(b)
+		}
+		return nil, tokens, nil
+	}
+	return nil, 0, errors.New("input should be a string, a list of strings or token ids")
+}
+
+// moderateEmbeddingsPayload runs the texts of an embeddings request
+// through the moderation pipeline, and returns payload with the masked
+// texts. Token ids can not be moderated, so they are refused while
+// moderation is enabled.
+func moderateEmbeddingsPayload(ctx *gin.Context, payload []byte, texts []string, tokens int) ([]byte, error) {
+	if ModerationPipeline() == nil {
+		return payload, nil
+	}
+	if texts == nil && tokens != 0 {
+		return nil, errors.New("token id inputs are not supported while moderation is enabled")
+	}
+
+	masked, err := moderateTexts(ctx, texts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if slices.Equal(masked, texts) {
+		return payload, nil
+	}
+
+	var body map[string]stdjson.RawMessage
+	if err = json.Unmarshal(payload, &body); err != nil {
+		return nil, errors.Wrap(err, "parse request")
+	}
+	if body["input"], err = json.Marshal(masked); err != nil {
+		return nil, errors.Wrap(err, "marshal input")
+	}
+	return json.Marshal(body)
+}
+
+// GatewayEmbeddingsHandler serves POST /v1/embeddings. The request is
+// forwarded to the user's upstream after the model, quota and billing
+// checks.
+func GatewayEmbeddingsHandler(ctx *gin.Context) {
+	user, err := getUserByAuthHeader(ctx)
+	if web.AbortErr(ctx, errors.Wrap(err, "get user by auth header")) {
+		return
+	}
+	payload, err := io.ReadAll(ctx.Request.Body)
+	if web.AbortErr(ctx, gatewayParseErr(err)) {
+		return
+	}
+
+	var in struct {
+		Model string             `json:"model"`
+		Input stdjson.RawMessage `json:"input"`
+	}
+	if err = json.Unmarshal(payload, &in); web.AbortErr(ctx, errors.Wrap(err, "parse request")) {
+		return
+	}
+	texts, tokens, err := gatewayEmbeddingInputs(in.Input)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if payload, err = moderateEmbeddingsPayload(ctx, payload, texts, tokens); web.AbortErr(ctx,
+		errors.Wrap(err, "moderate request")) {
+		return
+	}
+
+	// quota and allowlist checks work on a FrontendReq
+	checkReq := &FrontendReq{Model: strings.TrimSpace(in.Model), N: 1}
+	for _, text := range texts {
+		checkReq.Messages = append(checkReq.Messages, FrontendReqMessage{
+			Role:    OpenaiMessageRoleUser,
+			Content: FrontendReqMessageContent{StringContent: text},
+		})
+	}
+	if checkReq.Model == "" {
+		web.AbortErr(ctx, errors.New("model is required"))
+		return
+	}
+	if err = IsModelAllowed(ctx, user, checkReq); web.AbortErr(ctx,
+		errors.Wrapf(err, "check is model allowed for user %q", user.UserName)) {
+		return
+	}
+	// token ids have no text to count, so the quota takes the counted tokens
+	reservation, err := reserveTokenCount(ctx, user, tokens, 0)
+	if web.AbortErr(ctx, errors.Wrap(err, "reserve token quota")) {
+		return
+	}
+	defer clearTokenReservation(ctx)
+	spend, err := reserveAPIKeySpend(gmw.Ctx(ctx), user, tokens)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	defer func() { _ = spend.Settle(gmw.Ctx(ctx), 0) }()
+
+	usage := startUsage(ctx)
+	if usage != nil {
+		usage.Kind = db.UsageKindEmbedding
+		usage.Model = checkReq.Model
+		usage.PromptTokens = tokens
+	}
+	status, body, err := forwardEmbeddings(gmw.Ctx(ctx), user, payload)
+	if reservation != nil {
+		_ = reservation.Finalize(gmw.Ctx(ctx), 0)
+	}
+	if err == nil && status != http.StatusOK {
+		err = errors.Errorf("upstream returned %d", status)
+	}
+	if err == nil {
+		chargeChatCredit(gmw.Ctx(ctx), user, spend, tokens)
+		if usage != nil {
+			usage.Cost = chatTokensCost(tokens)
+		}
+	}
+	finishUsage(ctx, usage, err)
+	if body == nil {
+		web.AbortErr(ctx, err)
+		return
+	}
+
+	// upstream errors are already in OpenAI's format
+	ctx.Data(status, "application/json", body)
+}
+
+// forwardEmbeddings posts payload to the embeddings api of user's upstream.
+func forwardEmbeddings(ctx context.Context, user *config.UserConfig, payload []byte) (int, []byte, error) {
+	url := strings.TrimRight(user.APIBase, "/") + "/v1/embeddings"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "new embeddings request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+user.OpenaiToken)
+
+	resp, err := httpcli.Do(req) //nolint:bodyclose
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "do embeddings request")
+	}
+	defer gutils.LogErr(resp.Body.Close, log.Logger)
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEmbeddingsResponseBytes+1))
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "read embeddings response")
+	}
+	if len(body) > maxEmbeddingsResponseBytes {
+		return 0, nil, errors.Errorf("embeddings response exceeds %d bytes", maxEmbeddingsResponseBytes)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// GatewayModelsHandler serves GET /v1/models with the models the user may
+// call. Wildcard allowlists are not expanded.
+func GatewayModelsHandler(ctx *gin.Context) {
+	user, err := getUserByAuthHeader(ctx)
+	if web.AbortErr(ctx, errors.Wrap(err, "get user by auth header")) {
+		return
+	}
+
+	models := slices.Clone(user.AllowedModels)
+	slices.Sort(models)
+	models = slices.Compact(models)
+	data := make([]gin.H, 0, len(models))
+	for _, m := range models {
+		if m == "*" {
+			continue
+		}
+		data = append(data, gin.H{"id": m, "object": "model", "created": 0, "owned_by": "gptchat"})
+	}
+	ctx.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
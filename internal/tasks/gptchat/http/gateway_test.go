@@ -0,0 +1,425 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/Laisky/testify/require"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+func TestGatewayChatRequestToFrontendReq(t *testing.T) {
+	in := new(gatewayChatRequest)
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"model": "gpt-4o",
+		"stream": true,
+		"max_tokens": 100,
+		"max_completion_tokens": 200,
+		"enable_memory": false,
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": [
+				{"type": "text", "text": "what is this?"},
+				{"type": "image_url", "image_url": {"url": "https://img.example/a.png"}}
+			]}
+		]
+	}`), in))
+
+	req, err := in.toFrontendReq()
+	require.NoError(t, err)
+	require.Equal(t, "gpt-4o", req.Model)
+	require.True(t, req.Stream)
+	require.EqualValues(t, 200, req.MaxTokens, "max_completion_tokens wins")
+	require.Len(t, req.Messages, 2)
+	require.Equal(t, "what is this?", req.Messages[1].Content.String())
+	require.Equal(t, "https://img.example/a.png", req.Messages[1].Content.ArrayContent[1].ImageUrl.URL)
+	require.True(t, req.LaiskyExtra.ChatSwitch.DisableHttpsCrawler)
+	require.False(t, *req.LaiskyExtra.ChatSwitch.EnableMemory)
+
+	for _, body := range []string{
+		`{"model":"gpt-4o","messages":[]}`,
+		`{"model":"gpt-4o","n":2,"messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"gpt-4o","tools":[{"type":"function","function":{"name":"f"}}],"messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"gpt-4o","messages":[{"role":"tool","tool_call_id":"c1","content":"42"}]}`,
+	} {
+		in = new(gatewayChatRequest)
+		require.NoError(t, json.Unmarshal([]byte(body), in))
+		_, err = in.toFrontendReq()
+		require.Error(t, err, body)
+	}
+}
+
+func TestGatewayResponsesRequestToFrontendReq(t *testing.T) {
+	in := new(gatewayResponsesRequest)
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"model": "gpt-5",
+		"instructions": "be brief",
+		"input": "hello",
+		"max_output_tokens": 64,
+		"reasoning": {"effort": "low"}
+	}`), in))
+	req, err := in.toFrontendReq()
+	require.NoError(t, err)
+	require.Len(t, req.Messages, 2)
+	require.Equal(t, OpenaiMessageRole(OpenaiMessageRoleSystem), req.Messages[0].Role)
+	require.Equal(t, "hello", req.Messages[1].Content.String())
+	require.Equal(t, "low", req.ReasoningEffort)
+	require.EqualValues(t, 64, req.MaxTokens)
+
+	in = new(gatewayResponsesRequest)
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"model": "gpt-5",
+		"input": [
+			{"role": "user", "content": "hi"},
+			{"type": "message", "role": "assistant", "content": [{"type": "output_text", "text": "hello"}]},
+			{"role": "user", "content": [
+				{"type": "input_text", "text": "and this?"},
+				{"type": "input_image", "image_url": "data:image/png;base64,AA=="}
+			]}
+		]
+	}`), in))
+	req, err = in.toFrontendReq()
+	require.NoError(t, err)
+	require.Len(t, req.Messages, 3)
+	require.Equal(t, "hello", req.Messages[1].Content.String())
+	require.Equal(t, "data:image/png;base64,AA==", req.Messages[2].Content.ArrayContent[1].ImageUrl.URL)
+
+	for _, body := range []string{
+		`{"model":"gpt-5"}`,
+		`{"model":"gpt-5","input":"hi","previous_response_id":"resp_1"}`,
+		`{"model":"gpt-5","input":"hi","tools":[{"type":"function","name":"f"}]}`,
+		`{"model":"gpt-5","input":[{"type":"function_call_output","call_id":"c1","output":"42"}]}`,
+		`{"model":"gpt-5","input":[{"role":"user","content":[{"type":"input_file","file_id":"f1"}]}]}`,
+	} {
+		in = new(gatewayResponsesRequest)
+		require.NoError(t, json.Unmarshal([]byte(body), in))
+		_, err = in.toFrontendReq()
+		require.Error(t, err, body)
+	}
+}
+
+// writeSPAStream writes what the chat pipeline streams for "Hello".
+func writeSPAStream(ctx *gin.Context) {
+	ctx.Header("content-type", "text/event-stream")
+	// frames may be split across writes
+	_, _ = ctx.Writer.WriteString("data: ")
+	_, _ = ctx.Writer.WriteString(`{"choices":[{"delta":{"role":"assistant","reasoning_content":"thinking"},"index":0}]}`)
+	_, _ = ctx.Writer.WriteString("\n\n")
+	_, _ = ctx.Writer.WriteString("data: [HEARTBEAT]\n\n")
+	_, _ = ctx.Writer.WriteString(`data: {"choices":[{"delta":{"role":"assistant","content":"Hel"},"index":0}]}` + "\n\n" +
+		`data: {"choices":[{"delta":{"role":"assistant","content":"lo"},"index":0}]}` + "\n\n")
+	_, _ = ctx.Writer.WriteString(`data: {"choices":[{"delta":{"role":"assistant","content":""},"index":0,"finish_reason":"stop"}]}` + "\n\n")
+	_, _ = ctx.Writer.WriteString("data: [DONE]\n\n")
+	_, _ = ctx.Writer.WriteString("data: [HEARTBEAT]\n\n")
+}
+
+// sseData returns the data payloads of an SSE body.
+func sseData(body string) (data []string) {
+	for _, line := range strings.Split(body, "\n") {
+		if payload, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, payload)
+		}
+	}
+	return data
+}
+
+func TestGatewayWriterChatStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/s", GatewayMiddleware, func(ctx *gin.Context) {
+		setGatewayTranslator(ctx, newChatCompletionsTranslator("gpt-4o", 7, true))
+		writeSPAStream(ctx)
+	})
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/s", nil))
+
+	require.Contains(t, resp.Body.String(), ": heartbeat\n\n")
+	data := sseData(resp.Body.String())
+	require.Len(t, data, 6, resp.Body.String())
+	require.Equal(t, "[DONE]", data[5], "nothing is written after [DONE]")
+
+	var chunks []gatewayChatChunk
+	for _, d := range data[:5] {
+		var c gatewayChatChunk
+		require.NoError(t, json.Unmarshal([]byte(d), &c))
+		require.Equal(t, "chat.completion.chunk", c.Object)
+		require.Equal(t, "gpt-4o", c.Model)
+		chunks = append(chunks, c)
+	}
+	require.Equal(t, chunks[0].ID, chunks[4].ID)
+	require.Equal(t, "assistant", chunks[0].Choices[0].Delta.Role)
+	require.Empty(t, chunks[1].Choices[0].Delta.Role, "the role is sent once")
+	require.Equal(t, "thinking", chunks[0].Choices[0].Delta.ReasoningContent)
+	require.Equal(t, "Hel", *chunks[1].Choices[0].Delta.Content)
+	require.Nil(t, chunks[1].Choices[0].FinishReason)
+	require.Equal(t, "stop", *chunks[3].Choices[0].FinishReason)
+	require.Empty(t, chunks[4].Choices)
+	require.Equal(t, 7, chunks[4].Usage.PromptTokens)
+	require.Positive(t, chunks[4].Usage.CompletionTokens)
+}
+
+func TestGatewayWriterResponsesStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/s", GatewayMiddleware, func(ctx *gin.Context) {
+		setGatewayTranslator(ctx, newResponsesTranslator("gpt-5", 3))
+		writeSPAStream(ctx)
+	})
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/s", nil))
+
+	var types []string
+	var last map[string]any
+	for i, d := range sseData(resp.Body.String()) {
+		var ev map[string]any
+		require.NoError(t, json.Unmarshal([]byte(d), &ev))
+		require.EqualValues(t, i, ev["sequence_number"])
+		types = append(types, ev["type"].(string))
+		last = ev
+	}
+	require.Equal(t, []string{
+		"response.created",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_text.done",
+		"response.content_part.done",
+		"response.output_item.done",
+		"response.completed",
+	}, types)
+	require.Contains(t, resp.Body.String(), "event: response.completed\n")
+
+	completed := last["response"].(map[string]any)
+	require.Equal(t, "completed", completed["status"])
+	item := completed["output"].([]any)[0].(map[string]any)
+	require.Equal(t, "Hello", item["content"].([]any)[0].(map[string]any)["text"])
+	require.EqualValues(t, 3, completed["usage"].(map[string]any)["input_tokens"])
+}
+
+func TestGatewayWriterErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/err", GatewayMiddleware, func(ctx *gin.Context) {
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"err": "slow down"})
+	})
+	router.GET("/upstream", GatewayMiddleware, func(ctx *gin.Context) {
+		ctx.Data(http.StatusBadRequest, "application/json",
+			[]byte(`{"error":{"message":"bad input","type":"invalid_request_error"}}`))
+	})
+	router.GET("/broken", GatewayMiddleware, func(ctx *gin.Context) {
+		setGatewayTranslator(ctx, newChatCompletionsTranslator("gpt-4o", 0, false))
+		ctx.Header("content-type", "text/event-stream")
+		_, _ = ctx.Writer.WriteString(`data: {"choices":[{"delta":{"content":"Hi"},"index":0}]}` + "\n\n")
+		ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"err": "upstream gone"})
+	})
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/err", nil))
+	require.Equal(t, http.StatusTooManyRequests, resp.Code)
+	require.JSONEq(t, `{"error":{"message":"slow down","type":"rate_limit_error","param":null,"code":null}}`,
+		resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/upstream", nil))
+	require.JSONEq(t, `{"error":{"message":"bad input","type":"invalid_request_error"}}`, resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/broken", nil))
+	data := sseData(resp.Body.String())
+	require.Len(t, data, 3)
+	require.JSONEq(t, `{"error":{"message":"upstream gone","type":"server_error"}}`, data[1])
+	require.Equal(t, "[DONE]", data[2])
+}
+
+// setupGateway serves the gateway routes against a fake upstream, which
+// answers every responses call with "pong".
+// setupGateway serves the gateway routes as a user of a fake upstream, it
+// returns the router, the upstream calls and the user.
+func setupGateway(t *testing.T) (*gin.Engine, *[]string, *config.UserConfig) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls = append(calls, r.URL.Path+" "+r.Header.Get("Authorization")+" "+string(body))
+		mu.Unlock()
+
+		w.Header().Set("content-type", "application/json")
+		if r.URL.Path == "/v1/embeddings" {
+			_, _ = w.Write([]byte(`{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1]}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"resp-1","output_text":"pong","output":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	originalCli := httpcli
+	httpcli = upstream.Client()
+	t.Cleanup(func() { httpcli = originalCli })
+
+	originalConfig := config.Config
+	config.Config = &config.OpenAI{
+		Token:                                   "srv-token",
+		API:                                     upstream.URL,
+		RateLimitExpensiveModelsIntervalSeconds: 600,
+	}
+	t.Cleanup(func() { config.Config = originalConfig })
+
+	user := &config.UserConfig{
+		Token:         "laisky-abcdefghijklmno",
+		UserName:      "tester",
+		APIBase:       upstream.URL,
+		OpenaiToken:   "sk-user",
+		AllowedModels: []string{"gpt-4.1", "text-embedding-3-small"},
+	}
+	require.NoError(t, user.Valid())
+
+	router := gin.New()
+	v1 := router.Group("/v1", func(ctx *gin.Context) { ctx.Set(ctxKeyUser, user) }, GatewayMiddleware)
+	v1.POST("/chat/completions", GatewayChatCompletionsHandler)
+	v1.POST("/responses", GatewayResponsesHandler)
+	v1.POST("/embeddings", GatewayEmbeddingsHandler)
+	v1.GET("/models", GatewayModelsHandler)
+	return router, &calls, user
+}
+
+func gatewayRequest(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestGatewayEndToEnd(t *testing.T) {
+	router, calls, _ := setupGateway(t)
+
+	resp := gatewayRequest(router, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt-4.1","max_tokens":50,"messages":[{"role":"user","content":"ping"}]}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var completion gatewayChatCompletion
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &completion))
+	require.Equal(t, "chat.completion", completion.Object)
+	require.True(t, strings.HasPrefix(completion.ID, "chatcmpl-"))
+	require.Equal(t, "pong", completion.Choices[0].Message.Content)
+	require.Equal(t, "stop", completion.Choices[0].FinishReason)
+	require.Positive(t, completion.Usage.PromptTokens)
+	require.Contains(t, (*calls)[0], "/v1/responses Bearer sk-user")
+
+	resp = gatewayRequest(router, http.MethodPost, "/v1/responses",
+		`{"model":"gpt-4.1","max_output_tokens":50,"input":"ping"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var response gatewayResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	require.Equal(t, "response", response.Object)
+	require.Equal(t, "completed", response.Status)
+	require.Equal(t, "pong", response.Output[0].Content[0].Text)
+
+	resp = gatewayRequest(router, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt-5","messages":[{"role":"user","content":"ping"}]}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), `"type":"invalid_request_error"`)
+	require.Contains(t, resp.Body.String(), `model \"gpt-5\" is not allowed`)
+
+	resp = gatewayRequest(router, http.MethodPost, "/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":["a","b"]}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Contains(t, resp.Body.String(), `"embedding":[0.1]`)
+	require.Contains(t, (*calls)[len(*calls)-1], `/v1/embeddings Bearer sk-user {"model":"text-embedding-3-small"`)
+
+	resp = gatewayRequest(router, http.MethodPost, "/v1/embeddings", `{"model":"text-embedding-3-small","input":{}}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = gatewayRequest(router, http.MethodGet, "/v1/models", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"object":"list","data":[
+		{"id":"gpt-4.1","object":"model","created":0,"owned_by":"gptchat"},
+		{"id":"text-embedding-3-small","object":"model","created":0,"owned_by":"gptchat"}]}`, resp.Body.String())
+}
+
+func TestGatewayEmbeddingsModeration(t *testing.T) {
+	router, calls, _ := setupGateway(t)
+	classifier := &stubClassifier{}
+	setupModeration(t, classifier)
+
+	resp := gatewayRequest(router, http.MethodPost, "/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":"mail bob@example.com","user":"u1"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Contains(t, (*calls)[len(*calls)-1], `"input":["mail [REDACTED:email]"]`)
+	require.Contains(t, (*calls)[len(*calls)-1], `"user":"u1"`)
+	require.Equal(t, []string{"mail [REDACTED:email]"}, classifier.texts)
+
+	n := len(*calls)
+	resp = gatewayRequest(router, http.MethodPost, "/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":["hi","sk-abcdefghijklmnopqrstuvwx"]}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), "blocked by moderation")
+
+	resp = gatewayRequest(router, http.MethodPost, "/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":[1,2,3]}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), "token id inputs are not supported")
+	require.Len(t, *calls, n, "refused requests never reach upstream")
+}
+
+func TestGatewayEmbeddingsTokenIDQuota(t *testing.T) {
+	router, calls, user := setupGateway(t)
+	user.OrgID = "acme"
+	config.Config.Organizations = []*config.OrganizationConfig{
+		{ID: "acme", TokenQuota: config.OrgTokenQuota{Limit: 100, WindowMinutes: 60}},
+	}
+
+	var reserved []int
+	original := reserveQuota
+	reserveQuota = func(m *TokenQuotaManager, _ context.Context,
+		_, _ string, promptTokens, estimatedOutput int) (*TokenReservation, error) {
+		total := promptTokens + estimatedOutput
+		if total > m.limit {
+			return nil, &QuotaExceededError{Subject: m.subject, Limit: m.limit, Window: m.windowDuration}
+		}
+		reserved = append(reserved, total)
+		return nil, nil
+	}
+	t.Cleanup(func() { reserveQuota = original })
+
+	ids := make([]int, 101)
+	input, err := json.Marshal([][]int{ids[:60], ids[60:]})
+	require.NoError(t, err)
+	resp := gatewayRequest(router, http.MethodPost, "/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":`+string(input)+`}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code, resp.Body.String())
+	require.Contains(t, resp.Body.String(), "quota exceeded")
+	require.Empty(t, *calls, "over quota requests never reach upstream")
+
+	resp = gatewayRequest(router, http.MethodPost, "/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":[1,2,3]}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, []int{3}, reserved, "token ids count against the quota")
+}
+
+func TestGatewayBodyLimit(t *testing.T) {
+	router, calls, _ := setupGateway(t)
+	input := strings.Repeat("a", maxGatewayRequestBytes)
+
+	resp := gatewayRequest(router, http.MethodPost, "/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":"`+input+`"}`)
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.Code, resp.Body.String())
+	resp = gatewayRequest(router, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt-4.1","messages":[{"role":"user","content":"`+input+`"}]}`)
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.Code, resp.Body.String())
+	require.Empty(t, *calls)
+}
@@ -66,7 +66,7 @@ func DrawByDalleHandler(ctx *gin.Context) {
 		return
 	}
 
-	if user.EnableExternalImageBilling {
+	if needsBilling(user) {
 		if err := checkUserExternalBilling(gmw.Ctx(ctx),
 			user, GetImageModelPrice(req.Model), "txt2image"); web.AbortErr(ctx, err) {
 			return
@@ -341,7 +341,7 @@ func EditImageHandler(ctx *gin.Context) {
 		return
 	}
 
-	if user.EnableExternalImageBilling {
+	if needsBilling(user) {
 		price := GetImageModelPrice(req.Model)
 		if req.Model == "flux-fill-pro" || req.Model == "black-forest-labs/flux-fill-pro" {
 			price = db.PriceTxt2ImageFluxFillPro
@@ -522,3 +522,87 @@ func fetchImageEditFromOpenai(ctx context.Context,
 
 	return imgs, nil
 }
+
+func init() {
+	RegisterImageProvider(openaiImageProvider{})
+	RegisterImageProvider(azureDalleImageProvider{})
+}
+
+// openaiImageProvider draws through the user's OneAPI-compatible
+// /v1/images endpoints.
+type openaiImageProvider struct{}
+
+// Name implements ImageProvider.
+func (openaiImageProvider) Name() string { return "openai" }
+
+// Supports implements ImageProvider.
+func (openaiImageProvider) Supports(user *config.UserConfig, _ string) bool {
+	return user != nil && user.APIBase != "" &&
+		!strings.Contains(user.ImageUrl, "openai.azure.com")
+}
+
+// Capabilities implements ImageProvider. Inpaint is an edit with a mask.
+func (openaiImageProvider) Capabilities() ImageCapabilities {
+	return ImageCapabilities{Generate: true, Edit: true, Inpaint: true, MaxN: 8}
+}
+
+// Price implements ImageProvider.
+func (openaiImageProvider) Price(model string) db.Price { return GetImageModelPrice(model) }
+
+// ServerKeyed implements ImageProvider.
+func (openaiImageProvider) ServerKeyed() bool { return false }
+
+// Generate implements ImageProvider.
+func (openaiImageProvider) Generate(ctx context.Context,
+	user *config.UserConfig, req *ImageRequest) ([][]byte, error) {
+	return fetchImageFromOpenaiDalle(ctx, user, req.Model, req.Prompt, req.N, req.Size)
+}
+
+// Edit implements ImageProvider.
+func (openaiImageProvider) Edit(ctx context.Context,
+	user *config.UserConfig, req *ImageRequest) ([][]byte, error) {
+	if len(req.Image) == 0 {
+		return nil, errors.New("image is required")
+	}
+	return fetchImageEditFromOpenai(ctx, user, req.Model, req.Prompt, req.Image, req.Mask)
+}
+
+// Inpaint implements ImageProvider.
+func (p openaiImageProvider) Inpaint(ctx context.Context,
+	user *config.UserConfig, req *ImageRequest) ([][]byte, error) {
+	if len(req.Mask) == 0 {
+		return nil, errors.New("mask is required for inpainting")
+	}
+	return p.Edit(ctx, user, req)
+}
+
+// azureDalleImageProvider draws through an Azure OpenAI dall-e deployment,
+// one image per upstream call.
+type azureDalleImageProvider struct{ unsupportedImageOps }
+
+// Name implements ImageProvider.
+func (azureDalleImageProvider) Name() string { return "azure-dalle" }
+
+// Supports implements ImageProvider.
+func (azureDalleImageProvider) Supports(user *config.UserConfig, _ string) bool {
+	return user != nil && strings.Contains(user.ImageUrl, "openai.azure.com")
+}
+
+// Capabilities implements ImageProvider.
+func (azureDalleImageProvider) Capabilities() ImageCapabilities {
+	return ImageCapabilities{Generate: true, MaxN: 8}
+}
+
+// Price implements ImageProvider.
+func (azureDalleImageProvider) Price(model string) db.Price { return GetImageModelPrice(model) }
+
+// ServerKeyed implements ImageProvider.
+func (azureDalleImageProvider) ServerKeyed() bool { return false }
+
+// Generate implements ImageProvider.
+func (azureDalleImageProvider) Generate(ctx context.Context,
+	user *config.UserConfig, req *ImageRequest) ([][]byte, error) {
+	return drawImagesConcurrently(ctx, req.N, func(ctx context.Context) ([]byte, error) {
+		return fetchImageFromAzureDalle(ctx, user, req.Prompt)
+	})
+}
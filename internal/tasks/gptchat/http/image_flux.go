@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"math/big"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -58,27 +60,12 @@ func InpaitingByFlux(ctx *gin.Context) {
 }
 
 func replicateFluxHandler(ctx *gin.Context, nImage int, model, prompt string, req any) {
-	var price db.Price
-	imgExt := ".png"
-	switch model {
-	case "flux-dev":
-		price = db.PriceTxt2ImageFluxDev
-	case "flux-schnell":
-		price = db.PriceTxt2ImageSchnell
-	case "flux-pro":
-		price = db.PriceTxt2ImageFluxPro
-	case "flux-fill-pro":
-		price = db.PriceTxt2ImageFluxFillPro
-	case "flux-1.1-pro":
-		price = db.PriceTxt2ImageFluxPro11
-	case "flux-kontext-pro":
-		price = db.PriceTxt2ImageFluxKontextPro
-	case "flux-1.1-pro-ultra":
-		price = db.PriceTxt2ImageFluxProUltra11
-	default:
+	if !isReplicateFluxModel(model) {
 		web.AbortErr(ctx, errors.Errorf("unknown model %q", model))
 		return
 	}
+	price := GetImageModelPrice(model)
+	imgExt := ".png"
 
 	taskID := gutils.RandomStringWithLength(36)
 	logger := gmw.GetLogger(ctx).Named("image_flux").With(
@@ -192,6 +179,24 @@ func replicateFluxHandler(ctx *gin.Context, nImage int, model, prompt string, re
 	})
 }
 
+// replicateFluxModels are the black-forest-labs models served by replicate
+var replicateFluxModels = map[string]struct{}{
+	"flux-dev":           {},
+	"flux-schnell":       {},
+	"flux-pro":           {},
+	"flux-fill-pro":      {},
+	"flux-1.1-pro":       {},
+	"flux-kontext-pro":   {},
+	"flux-1.1-pro-ultra": {},
+}
+
+// isReplicateFluxModel check if model is a flux model served by replicate,
+// with or without the "black-forest-labs/" prefix
+func isReplicateFluxModel(model string) bool {
+	_, ok := replicateFluxModels[strings.TrimPrefix(model, "black-forest-labs/")]
+	return ok
+}
+
 // drawFluxByReplicate draw image by replicate service
 func drawFluxByReplicate(ctx context.Context,
 	model string, req *DrawImageByFluxReplicateRequest) (img []byte, err error) {
@@ -437,3 +442,105 @@ func requestFluxImageAPI(ctx context.Context,
 
 // 	return imgContent, nil
 // }
+
+func init() {
+	RegisterImageProvider(replicateFluxImageProvider{})
+}
+
+// replicateFluxImageProvider draws by black-forest-labs flux models on
+// replicate. Replicate returns one image per prediction.
+type replicateFluxImageProvider struct{ unsupportedImageOps }
+
+// Name implements ImageProvider.
+func (replicateFluxImageProvider) Name() string { return "replicate-flux" }
+
+// Supports implements ImageProvider.
+func (replicateFluxImageProvider) Supports(_ *config.UserConfig, model string) bool {
+	return config.Config.ReplicateApikey != "" && isReplicateFluxModel(model)
+}
+
+// Capabilities implements ImageProvider.
+func (replicateFluxImageProvider) Capabilities() ImageCapabilities {
+	return ImageCapabilities{Generate: true, Inpaint: true, MaxN: 8}
+}
+
+// Price implements ImageProvider.
+func (replicateFluxImageProvider) Price(model string) db.Price { return GetImageModelPrice(model) }
+
+// ServerKeyed implements ImageProvider.
+func (replicateFluxImageProvider) ServerKeyed() bool { return true }
+
+// Generate implements ImageProvider. req.Image, when set, is passed as the
+// image prompt (or the input image for flux-kontext-pro).
+func (replicateFluxImageProvider) Generate(ctx context.Context,
+	_ *config.UserConfig, req *ImageRequest) ([][]byte, error) {
+	model := strings.TrimPrefix(req.Model, "black-forest-labs/")
+	width, height := fluxSizeFromImageRequest(req.Size)
+
+	var imagePrompt *string
+	if len(req.Image) > 0 {
+		dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(req.Image)
+		imagePrompt = &dataURL
+	}
+
+	return drawImagesConcurrently(ctx, req.N, func(ctx context.Context) ([]byte, error) {
+		// drawFluxByReplicate mutates the request, so every image gets its own
+		return drawFluxByReplicate(ctx, model, &DrawImageByFluxReplicateRequest{
+			Input: FluxInput{
+				Steps:           25,
+				Prompt:          req.Prompt,
+				ImagePrompt:     imagePrompt,
+				Guidance:        3,
+				Interval:        2,
+				AspectRatio:     "1:1",
+				SafetyTolerance: 2,
+				NImages:         1,
+				Width:           width,
+				Height:          height,
+			},
+		})
+	})
+}
+
+// Inpaint implements ImageProvider. Only flux-fill-pro supports masks.
+func (replicateFluxImageProvider) Inpaint(ctx context.Context,
+	_ *config.UserConfig, req *ImageRequest) ([][]byte, error) {
+	model := strings.TrimPrefix(req.Model, "black-forest-labs/")
+	if model != "flux-fill-pro" {
+		return nil, errors.Wrapf(ErrImageOperationNotSupported, "inpaint by %s", model)
+	}
+	if len(req.Image) == 0 || len(req.Mask) == 0 {
+		return nil, errors.New("image and mask are required for inpainting")
+	}
+
+	image := "data:image/png;base64," + base64.StdEncoding.EncodeToString(req.Image)
+	mask := "data:image/png;base64," + base64.StdEncoding.EncodeToString(req.Mask)
+	return drawImagesConcurrently(ctx, req.N, func(ctx context.Context) ([]byte, error) {
+		return inpaitingFluxByReplicate(ctx, model, &InpaintingImageByFlusReplicateRequest{
+			Input: FluxInpaintingInput{
+				Mask:            mask,
+				Image:           image,
+				Steps:           25,
+				Prompt:          req.Prompt,
+				Guidance:        3,
+				SafetyTolerance: 2,
+			},
+		})
+	})
+}
+
+// fluxSizeFromImageRequest parses an OpenAI-style "WxH" size into flux's
+// width/height range, falling back to 1024x1024.
+func fluxSizeFromImageRequest(size string) (width, height int) {
+	width, height = 1024, 1024
+	w, h, ok := strings.Cut(strings.ToLower(strings.TrimSpace(size)), "x")
+	if !ok {
+		return width, height
+	}
+	pw, errW := strconv.Atoi(w)
+	ph, errH := strconv.Atoi(h)
+	if errW != nil || errH != nil {
+		return width, height
+	}
+	return min(max(pw, 256), 1440), min(max(ph, 256), 1440)
+}
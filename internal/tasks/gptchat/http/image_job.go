@@ -0,0 +1,411 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/go-utils/v6/json"
+	glog "github.com/Laisky/go-utils/v6/log"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/s3"
+	rutils "github.com/Laisky/go-ramjet/library/redis"
+	s3lib "github.com/Laisky/go-ramjet/library/s3"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+// Image job statuses.
+const (
+	ImageJobStatusPending = "pending"
+	ImageJobStatusRunning = "running"
+	ImageJobStatusSuccess = "success"
+	ImageJobStatusFailed  = "failed"
+)
+
+const (
+	// imageJobTTL is how long a job stays queryable after its last update.
+	imageJobTTL = 7 * 24 * time.Hour
+	// imageJobTimeout bounds one job, upload included.
+	imageJobTimeout = 5 * time.Minute
+	// imageJobRefundTimeout bounds the refund of a failed job.
+	imageJobRefundTimeout = 30 * time.Second
+)
+
+// errImageJobNotFound is returned by imageJobStore.Load for unknown ids.
+var errImageJobNotFound = errors.New("image job not found")
+
+// ImageJob is the persisted state of one asynchronous image task.
+type ImageJob struct {
+	TaskID string `json:"task_id"`
+	// UserName owns the job; only the owner can query it.
+	UserName     string     `json:"user_name"`
+	Provider     string     `json:"provider"`
+	Model        string     `json:"model"`
+	Operation    string     `json:"operation"`
+	Prompt       string     `json:"prompt"`
+	N            int        `json:"n"`
+	Status       string     `json:"status"`
+	ImageURLs    []string   `json:"image_urls,omitempty"`
+	FailedReason string     `json:"failed_reason,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	// Charged is what the submission billed, refunded if the job fails.
+	Charged db.Price `json:"charged,omitempty"`
+}
+
+// imageJobStore persists image jobs.
+type imageJobStore interface {
+	Save(ctx context.Context, job *ImageJob) error
+	// Load returns errImageJobNotFound for unknown ids.
+	Load(ctx context.Context, taskID string) (*ImageJob, error)
+}
+
+// redisImageJobStore stores jobs as JSON blobs with imageJobTTL.
+type redisImageJobStore struct {
+	client *redis.Client
+}
+
+func imageJobRedisKey(taskID string) string {
+	return "ramjet:gptchat:image_job:" + taskID
+}
+
+// Save implements imageJobStore.
+func (s *redisImageJobStore) Save(ctx context.Context, job *ImageJob) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrap(err, "marshal image job")
+	}
+	if err = s.client.Set(ctx, imageJobRedisKey(job.TaskID), payload, imageJobTTL).Err(); err != nil {
+		return errors.Wrapf(err, "save image job %q", job.TaskID)
+	}
+	return nil
+}
+
+// Load implements imageJobStore.
+func (s *redisImageJobStore) Load(ctx context.Context, taskID string) (*ImageJob, error) {
+	payload, err := s.client.Get(ctx, imageJobRedisKey(taskID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errors.WithStack(errImageJobNotFound)
+		}
+		return nil, errors.Wrapf(err, "load image job %q", taskID)
+	}
+	job := new(ImageJob)
+	if err = json.Unmarshal(payload, job); err != nil {
+		return nil, errors.Wrap(err, "unmarshal image job")
+	}
+	return job, nil
+}
+
+// newImageJobStore returns the job store. Replaced in tests.
+var newImageJobStore = func() imageJobStore {
+	return &redisImageJobStore{client: rutils.GetCli().GetDB().Client}
+}
+
+// imageJobUploader uploads one result image. Replaced in tests.
+var imageJobUploader = uploadImage2Minio
+
+// SubmitImageJobRequest submits an asynchronous image job.
+type SubmitImageJobRequest struct {
+	// Operation is one of generate (default), edit, inpaint.
+	Operation string `json:"operation"`
+	// Provider optionally pins the backend, see ListImageProviders.
+	Provider string `json:"provider"`
+	Model    string `binding:"required,min=1" json:"model"`
+	Prompt   string `binding:"required,min=1" json:"prompt"`
+	N        int    `json:"n"`
+	Size     string `json:"size"`
+	// Image and Mask are URLs or base64 (optionally data-url) payloads.
+	Image string `json:"image"`
+	Mask  string `json:"mask"`
+}
+
+// SubmitImageJobHandler validates and bills an image job, then runs it in
+// the background. The response carries the task id to poll.
+func SubmitImageJobHandler(ctx *gin.Context) {
+	req := new(SubmitImageJobRequest)
+	if err := ctx.ShouldBindJSON(req); web.AbortErr(ctx, errors.WithStack(err)) {
+		return
+	}
+	req.Operation = strings.ToLower(strings.TrimSpace(req.Operation))
+	if req.Operation == "" {
+		req.Operation = ImageOpGenerate
+	}
+
+	user, err := getUserByAuthHeader(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	if req.N <= 0 || user.IsFree {
+		req.N = 1
+	}
+
+	provider, err := resolveImageProvider(user, req.Provider, req.Model)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if !provider.Capabilities().Supports(req.Operation) {
+		web.AbortErr(ctx, errors.Wrapf(ErrImageOperationNotSupported,
+			"%s by %s", req.Operation, provider.Name()))
+		return
+	}
+	if maxN := max(provider.Capabilities().MaxN, 1); req.N > maxN {
+		req.N = maxN
+	}
+
+	if err = IsModelAllowed(ctx, user, &FrontendReq{
+		N:     req.N,
+		Model: req.Model,
+	}); web.AbortErr(ctx, err) {
+		return
+	}
+
+	imgReq := &ImageRequest{
+		Model:  req.Model,
+		Prompt: req.Prompt,
+		N:      req.N,
+		Size:   req.Size,
+	}
+	if req.Image != "" {
+		if imgReq.Image, err = getDataFromUrlOrBase64(gmw.Ctx(ctx), req.Image); web.AbortErr(ctx,
+			errors.Wrap(err, "get image content")) {
+			return
+		}
+	}
+	if req.Mask != "" {
+		if imgReq.Mask, err = getDataFromUrlOrBase64(gmw.Ctx(ctx), req.Mask); web.AbortErr(ctx,
+			errors.Wrap(err, "get mask content")) {
+			return
+		}
+	}
+
+	// providers on the server's key are paid by the server, so they are
+	// billed to everyone, the same as the legacy flux handler did
+	var charged db.Price
+	if needsBilling(user) || provider.ServerKeyed() {
+		charged = db.Price(provider.Price(req.Model).Int() * req.N)
+		if err := checkUserExternalBilling(gmw.Ctx(ctx),
+			user, charged, imageJobCostReason(req.Operation, req.Model)); web.AbortErr(ctx, err) {
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	job := &ImageJob{
+		TaskID:    gutils.RandomStringWithLength(36),
+		UserName:  user.UserName,
+		Provider:  provider.Name(),
+		Model:     req.Model,
+		Operation: req.Operation,
+		Prompt:    req.Prompt,
+		N:         req.N,
+		Status:    ImageJobStatusPending,
+		Charged:   charged,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	store := newImageJobStore()
+	if err = store.Save(gmw.Ctx(ctx), job); err != nil {
+		refundImageJob(gmw.Ctx(ctx), gmw.GetLogger(ctx), user, job)
+		web.AbortErr(ctx, err)
+		return
+	}
+
+	logger := gmw.GetLogger(ctx).Named("image_job").With(
+		zap.String("task_id", job.TaskID),
+		zap.String("provider", job.Provider),
+		zap.String("model", job.Model),
+		zap.String("operation", job.Operation),
+	)
+	// the run owns job from here on, so the response uses a copy
+	resp := gin.H{
+		"task_id": job.TaskID,
+		"status":  job.Status,
+	}
+	go runImageJob(logger, store, provider, user, job, imgReq)
+
+	logger.Info("image job submitted")
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// GetImageJobHandler returns the state of an image job owned by the caller.
+func GetImageJobHandler(ctx *gin.Context) {
+	user, err := getUserByAuthHeader(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	taskID := strings.TrimSpace(ctx.Param("task_id"))
+	if taskID == "" {
+		web.AbortErr(ctx, errors.New("should set task_id"))
+		return
+	}
+
+	job, err := newImageJobStore().Load(gmw.Ctx(ctx), taskID)
+	if err == nil && job.UserName != user.UserName {
+		err = errors.WithStack(errImageJobNotFound)
+	}
+	if errors.Is(err, errImageJobNotFound) {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"err": err.Error()})
+		return
+	}
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+// ListImageProvidersHandler lists the registered image providers and what
+// they can do.
+func ListImageProvidersHandler(ctx *gin.Context) {
+	var providers []gin.H
+	for _, name := range ListImageProviders() {
+		imageProvidersMu.RLock()
+		p := imageProviders[name]
+		imageProvidersMu.RUnlock()
+		providers = append(providers, gin.H{
+			"name":         name,
+			"capabilities": p.Capabilities(),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"providers": providers})
+}
+
+// imageJobCostReason is the billing reason of an image job.
+func imageJobCostReason(operation, model string) string {
+	return "image-job:" + operation + ":" + model
+}
+
+// refundImageJob gives back what the submission of job charged. It runs
+// on its own deadline, since a job that timed out has spent ctx.
+func refundImageJob(ctx context.Context, logger glog.Logger, user *config.UserConfig, job *ImageJob) {
+	if job.Charged <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), imageJobRefundTimeout)
+	defer cancel()
+
+	if err := refundUserBilling(ctx, user, job.Charged,
+		imageJobCostReason(job.Operation, job.Model)); err != nil {
+		logger.Error("refund image job", zap.Int("cost", job.Charged.Int()), zap.Error(err))
+		return
+	}
+	logger.Info("refund image job", zap.Int("cost", job.Charged.Int()))
+}
+
+// runImageJob executes one job detached from the submitting request and
+// records the outcome. Store errors are logged only: the images are
+// already paid for, so a flaky redis must not abort the run. A failed
+// job is refunded.
+func runImageJob(logger glog.Logger,
+	store imageJobStore,
+	provider ImageProvider,
+	user *config.UserConfig,
+	job *ImageJob,
+	req *ImageRequest,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), imageJobTimeout)
+	defer cancel()
+	ctx = gmw.SetLogger(ctx, logger)
+
+	save := func() {
+		job.UpdatedAt = time.Now().UTC()
+		if err := store.Save(ctx, job); err != nil {
+			logger.Error("save image job", zap.Error(err))
+		}
+	}
+
+	job.Status = ImageJobStatusRunning
+	save()
+
+	objkeyPrefix := drawImageByTxtObjkeyPrefix(job.TaskID)
+	if job.Operation != ImageOpGenerate {
+		objkeyPrefix = drawImageByImageObjkeyPrefix(job.TaskID)
+	}
+
+	urls, err := func() ([]string, error) {
+		imgs, err := runImageOperation(ctx, provider, user, job.Operation, req)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(imgs) == 0 {
+			return nil, errors.New("provider returned no image")
+		}
+
+		urls := make([]string, len(imgs))
+		var pool errgroup.Group
+		for i, img := range imgs {
+			objkey := fmt.Sprintf("%s-%d", objkeyPrefix, i)
+			urls[i] = fmt.Sprintf("https://%s/%s/%s.png",
+				config.Config.S3.Endpoint, config.Config.S3.Bucket, objkey)
+			pool.Go(func() error {
+				return imageJobUploader(ctx, objkey, job.Prompt, img, ".png")
+			})
+		}
+		if err := pool.Wait(); err != nil {
+			return nil, errors.Wrap(err, "upload images")
+		}
+		return urls, nil
+	}()
+
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	if err != nil {
+		logger.Error("image job failed", zap.Error(err))
+		uploadImageJobErrMsg(ctx, logger, objkeyPrefix, job.Prompt, err)
+		job.Status = ImageJobStatusFailed
+		job.FailedReason = err.Error()
+		save()
+		refundImageJob(ctx, logger, user, job)
+		return
+	}
+
+	logger.Info("image job succeed", zap.Int("n", len(urls)))
+	job.Status = ImageJobStatusSuccess
+	job.ImageURLs = urls
+	save()
+}
+
+// uploadImageJobErrMsg leaves the failure next to the images' object keys,
+// the same place the synchronous handlers put it.
+func uploadImageJobErrMsg(ctx context.Context,
+	logger glog.Logger, objkeyPrefix, prompt string, jobErr error) {
+	s3cli, err := s3.GetCli()
+	if err != nil {
+		logger.Error("get s3 client", zap.Error(err))
+		return
+	}
+
+	msg := []byte(fmt.Sprintf("failed to draw image for %q, got %s", prompt, jobErr.Error()))
+	if _, err := s3lib.PutObjectCappingVersions(ctx,
+		logger,
+		s3cli,
+		config.Config.S3.Bucket,
+		objkeyPrefix+".err.txt",
+		bytes.NewReader(msg),
+		int64(len(msg)),
+		minio.PutObjectOptions{
+			ContentType: "text/plain",
+		},
+		s3lib.DefaultVersionsToKeep,
+	); err != nil {
+		logger.Error("upload error msg", zap.Error(err))
+	}
+}
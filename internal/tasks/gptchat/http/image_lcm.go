@@ -3,6 +3,7 @@ package http
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"math/rand"
@@ -20,6 +21,7 @@ import (
 	"github.com/minio/minio-go/v7"
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/s3"
 	s3lib "github.com/Laisky/go-ramjet/library/s3"
 	"github.com/Laisky/go-ramjet/library/web"
@@ -59,58 +61,9 @@ func DrawByLcmHandler(ctx *gin.Context) {
 			defer cancel()
 
 			if err := func() (err error) {
-				upstreamReqBody, err := json.Marshal(DrawImageByLcmRequest{
-					Data: [6]any{
-						strings.TrimSpace(req.Prompt),
-						"data:image/png;base64," + req.ImageBase64,
-						4,
-						1,
-						0.9,
-						1000 + rand.Intn(1000),
-					},
-					FnIndex: 1,
-				})
+				img, err := fetchImageFromLcm(taskCtx, req.Prompt, req.ImageBase64)
 				if err != nil {
-					return errors.Wrap(err, "marshal request body")
-				}
-
-				upstreamReq, err := http.NewRequestWithContext(taskCtx, http.MethodPost,
-					"http://100.92.237.35:7860/run/predict", bytes.NewReader(upstreamReqBody))
-				if web.AbortErr(ctx, errors.Wrap(err, "new request")) {
-					return
-				}
-
-				upstreamReq.Header.Add("Content-Type", "application/json")
-				if config.Config.LcmBasicAuthUsername != "" {
-					upstreamReq.SetBasicAuth(
-						config.Config.LcmBasicAuthUsername,
-						config.Config.LcmBasicAuthPassword,
-					)
-				}
-
-				resp, err := httpcli.Do(upstreamReq) //nolint: bodyclose
-				if err != nil {
-					return errors.Wrap(err, "do request")
-				}
-				defer gutils.LogErr(resp.Body.Close, logger)
-
-				if resp.StatusCode != http.StatusOK {
-					payload, _ := io.ReadAll(resp.Body)
-					return errors.Errorf("bad status code [%d]%s", resp.StatusCode, string(payload))
-				}
-
-				respBody := new(DrawImageByLcmResponse)
-				if err = json.NewDecoder(resp.Body).Decode(respBody); err != nil {
-					return errors.Wrap(err, "decode response")
-				}
-
-				if len(respBody.Data) == 0 {
-					return errors.New("empty response")
-				}
-
-				img, err := DecodeBase64(respBody.Data[0])
-				if err != nil {
-					return errors.Wrap(err, "decode image")
+					return errors.WithStack(err)
 				}
 
 				return uploadImage2Minio(taskCtx,
@@ -165,3 +118,103 @@ func DrawByLcmHandler(ctx *gin.Context) {
 		"image_urls": imageUrls,
 	})
 }
+
+// fetchImageFromLcm draws one image by the self-hosted LCM img2img service.
+//
+// imageBase64 is the raw base64 source image without the data-url prefix.
+func fetchImageFromLcm(ctx context.Context, prompt, imageBase64 string) ([]byte, error) {
+	logger := gmw.GetLogger(ctx)
+	upstreamReqBody, err := json.Marshal(DrawImageByLcmRequest{
+		Data: [6]any{
+			strings.TrimSpace(prompt),
+			"data:image/png;base64," + imageBase64,
+			4,
+			1,
+			0.9,
+			1000 + rand.Intn(1000),
+		},
+		FnIndex: 1,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal request body")
+	}
+
+	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"http://100.92.237.35:7860/run/predict", bytes.NewReader(upstreamReqBody))
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+
+	upstreamReq.Header.Add("Content-Type", "application/json")
+	if config.Config.LcmBasicAuthUsername != "" {
+		upstreamReq.SetBasicAuth(
+			config.Config.LcmBasicAuthUsername,
+			config.Config.LcmBasicAuthPassword,
+		)
+	}
+
+	resp, err := httpcli.Do(upstreamReq) //nolint: bodyclose
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+	defer gutils.LogErr(resp.Body.Close, logger)
+
+	if resp.StatusCode != http.StatusOK {
+		payload, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("bad status code [%d]%s", resp.StatusCode, string(payload))
+	}
+
+	respBody := new(DrawImageByLcmResponse)
+	if err = json.NewDecoder(resp.Body).Decode(respBody); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+
+	if len(respBody.Data) == 0 {
+		return nil, errors.New("empty response")
+	}
+
+	img, err := DecodeBase64(respBody.Data[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "decode image")
+	}
+
+	return img, nil
+}
+
+func init() {
+	RegisterImageProvider(lcmImageProvider{})
+}
+
+// lcmImageProvider redraws an image by the self-hosted LCM img2img service.
+type lcmImageProvider struct{ unsupportedImageOps }
+
+// Name implements ImageProvider.
+func (lcmImageProvider) Name() string { return "lcm" }
+
+// Supports implements ImageProvider.
+func (lcmImageProvider) Supports(_ *config.UserConfig, model string) bool {
+	return strings.Contains(model, "lcm")
+}
+
+// Capabilities implements ImageProvider.
+func (lcmImageProvider) Capabilities() ImageCapabilities {
+	return ImageCapabilities{Edit: true, MaxN: 2}
+}
+
+// Price implements ImageProvider.
+func (lcmImageProvider) Price(string) db.Price { return db.PriceTxt2Image }
+
+// ServerKeyed implements ImageProvider.
+func (lcmImageProvider) ServerKeyed() bool { return true }
+
+// Edit implements ImageProvider.
+func (lcmImageProvider) Edit(ctx context.Context,
+	_ *config.UserConfig, req *ImageRequest) ([][]byte, error) {
+	if len(req.Image) == 0 {
+		return nil, errors.New("image is required")
+	}
+	imageBase64 := base64.StdEncoding.EncodeToString(req.Image)
+	return drawImagesConcurrently(ctx, req.N, func(ctx context.Context) ([]byte, error) {
+		return fetchImageFromLcm(ctx, req.Prompt, imageBase64)
+	})
+}
@@ -0,0 +1,211 @@
+package http
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+)
+
+// Image operations accepted by ImageProvider and the image job API.
+const (
+	ImageOpGenerate = "generate"
+	ImageOpEdit     = "edit"
+	ImageOpInpaint  = "inpaint"
+)
+
+// ErrImageOperationNotSupported is returned by an ImageProvider for an
+// operation its Capabilities do not advertise.
+var ErrImageOperationNotSupported = errors.New("image operation not supported by provider")
+
+// ImageRequest is the provider-neutral input of one image operation.
+type ImageRequest struct {
+	Model  string
+	Prompt string
+	// N is the number of images to produce. Providers that can only draw
+	// one image per call loop internally.
+	N int
+	// Size is an OpenAI-style "WxH" hint; providers that take an aspect
+	// ratio instead ignore it.
+	Size string
+	// Image is the source image for edit and inpaint.
+	Image []byte
+	// Mask marks the region to repaint for inpaint; optional for edit.
+	Mask []byte
+}
+
+// ImageCapabilities describes which operations a provider implements.
+type ImageCapabilities struct {
+	Generate bool `json:"generate"`
+	Edit     bool `json:"edit"`
+	Inpaint  bool `json:"inpaint"`
+	// MaxN caps ImageRequest.N; zero means 1.
+	MaxN int `json:"max_n"`
+}
+
+// Supports reports whether op is advertised.
+func (c ImageCapabilities) Supports(op string) bool {
+	switch op {
+	case ImageOpGenerate:
+		return c.Generate
+	case ImageOpEdit:
+		return c.Edit
+	case ImageOpInpaint:
+		return c.Inpaint
+	default:
+		return false
+	}
+}
+
+// ImageProvider is one image generation backend. Implementations return raw
+// image bytes; uploading, billing and job bookkeeping are handled by the
+// caller, so a new backend only has to talk to its upstream.
+type ImageProvider interface {
+	// Name is the stable identifier clients pass as `provider`.
+	Name() string
+	// Supports reports whether the provider can serve model for user,
+	// e.g. whether the required upstream credentials are configured.
+	Supports(user *config.UserConfig, model string) bool
+	Capabilities() ImageCapabilities
+	// Price is the cost of one image of model.
+	Price(model string) db.Price
+	// ServerKeyed reports whether the provider calls its upstream with the
+	// server's own credentials instead of the user's, so every job has to
+	// be billed.
+	ServerKeyed() bool
+	Generate(ctx context.Context, user *config.UserConfig, req *ImageRequest) ([][]byte, error)
+	Edit(ctx context.Context, user *config.UserConfig, req *ImageRequest) ([][]byte, error)
+	Inpaint(ctx context.Context, user *config.UserConfig, req *ImageRequest) ([][]byte, error)
+}
+
+// unsupportedImageOps can be embedded by providers that only implement a
+// subset of the operations.
+type unsupportedImageOps struct{}
+
+// Generate implements ImageProvider.
+func (unsupportedImageOps) Generate(context.Context, *config.UserConfig, *ImageRequest) ([][]byte, error) {
+	return nil, errors.WithStack(ErrImageOperationNotSupported)
+}
+
+// Edit implements ImageProvider.
+func (unsupportedImageOps) Edit(context.Context, *config.UserConfig, *ImageRequest) ([][]byte, error) {
+	return nil, errors.WithStack(ErrImageOperationNotSupported)
+}
+
+// Inpaint implements ImageProvider.
+func (unsupportedImageOps) Inpaint(context.Context, *config.UserConfig, *ImageRequest) ([][]byte, error) {
+	return nil, errors.WithStack(ErrImageOperationNotSupported)
+}
+
+var (
+	imageProvidersMu sync.RWMutex
+	imageProviders   = map[string]ImageProvider{}
+)
+
+// defaultImageProviders is tried in order when a request does not name a
+// provider. It mirrors DrawByDalleHandler: Azure for users whose image
+// endpoint is Azure, the OneAPI-compatible endpoint otherwise.
+var defaultImageProviders = []string{"azure-dalle", "openai"}
+
+// RegisterImageProvider installs p under p.Name(), replacing any provider
+// with the same name. Backends register themselves from init().
+func RegisterImageProvider(p ImageProvider) {
+	imageProvidersMu.Lock()
+	defer imageProvidersMu.Unlock()
+	imageProviders[p.Name()] = p
+}
+
+// ListImageProviders returns the registered provider names, sorted.
+func ListImageProviders() []string {
+	imageProvidersMu.RLock()
+	defer imageProvidersMu.RUnlock()
+	names := make([]string, 0, len(imageProviders))
+	for name := range imageProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveImageProvider picks the provider for one request. An explicit name
+// must exist and support the model; otherwise defaultImageProviders is
+// walked in order.
+func resolveImageProvider(user *config.UserConfig, name, model string) (ImageProvider, error) {
+	imageProvidersMu.RLock()
+	defer imageProvidersMu.RUnlock()
+
+	if name = strings.TrimSpace(name); name != "" {
+		p, ok := imageProviders[name]
+		if !ok {
+			return nil, errors.Errorf("unknown image provider %q", name)
+		}
+		if !p.Supports(user, model) {
+			return nil, errors.Errorf("image provider %q does not support model %q", name, model)
+		}
+		return p, nil
+	}
+
+	for _, candidate := range defaultImageProviders {
+		if p, ok := imageProviders[candidate]; ok && p.Supports(user, model) {
+			return p, nil
+		}
+	}
+	return nil, errors.Errorf("no image provider for model %q", model)
+}
+
+// runImageOperation dispatches op to p after checking its capabilities.
+func runImageOperation(ctx context.Context,
+	p ImageProvider, user *config.UserConfig, op string, req *ImageRequest) ([][]byte, error) {
+	caps := p.Capabilities()
+	if !caps.Supports(op) {
+		return nil, errors.Wrapf(ErrImageOperationNotSupported, "%s by %s", op, p.Name())
+	}
+
+	maxN := caps.MaxN
+	if maxN <= 0 {
+		maxN = 1
+	}
+	if req.N <= 0 {
+		req.N = 1
+	}
+	if req.N > maxN {
+		req.N = maxN
+	}
+
+	switch op {
+	case ImageOpGenerate:
+		return p.Generate(ctx, user, req)
+	case ImageOpEdit:
+		return p.Edit(ctx, user, req)
+	case ImageOpInpaint:
+		return p.Inpaint(ctx, user, req)
+	default:
+		return nil, errors.Errorf("unknown image operation %q", op)
+	}
+}
+
+// drawImagesConcurrently calls draw n times in parallel for providers whose
+// upstream returns a single image per request.
+func drawImagesConcurrently(ctx context.Context, n int,
+	draw func(ctx context.Context) ([]byte, error)) ([][]byte, error) {
+	imgs := make([][]byte, n)
+	pool, poolCtx := errgroup.WithContext(ctx)
+	for i := range n {
+		pool.Go(func() (err error) {
+			if imgs[i], err = draw(poolCtx); err != nil {
+				return errors.Wrapf(err, "draw image %d", i)
+			}
+			return nil
+		})
+	}
+	if err := pool.Wait(); err != nil {
+		return nil, err
+	}
+	return imgs, nil
+}
@@ -0,0 +1,234 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/go-utils/v6/log"
+	"github.com/Laisky/testify/require"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+)
+
+// fakeImageProvider returns one fixed image per N, or err when set.
+type fakeImageProvider struct {
+	unsupportedImageOps
+	err error
+}
+
+func (fakeImageProvider) Name() string                             { return "fake" }
+func (fakeImageProvider) Supports(*config.UserConfig, string) bool { return true }
+func (fakeImageProvider) Price(string) db.Price                    { return db.PriceTxt2Image }
+func (fakeImageProvider) ServerKeyed() bool                        { return true }
+func (fakeImageProvider) Capabilities() ImageCapabilities {
+	return ImageCapabilities{Generate: true, MaxN: 2}
+}
+
+func (p fakeImageProvider) Generate(_ context.Context,
+	_ *config.UserConfig, req *ImageRequest) ([][]byte, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	imgs := make([][]byte, req.N)
+	for i := range imgs {
+		imgs[i] = []byte("png")
+	}
+	return imgs, nil
+}
+
+// memoryImageJobStore keeps jobs in a map.
+type memoryImageJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]ImageJob
+}
+
+func (s *memoryImageJobStore) Save(_ context.Context, job *ImageJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.TaskID] = *job
+	return nil
+}
+
+func (s *memoryImageJobStore) Load(_ context.Context, taskID string) (*ImageJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[taskID]
+	if !ok {
+		return nil, errors.WithStack(errImageJobNotFound)
+	}
+	return &job, nil
+}
+
+func TestResolveImageProvider(t *testing.T) {
+	openaiUser := &config.UserConfig{APIBase: "https://api.test", ImageUrl: "https://api.test/v1/images"}
+	azureUser := &config.UserConfig{APIBase: "https://api.test", ImageUrl: "https://x.openai.azure.com/dalle"}
+
+	p, err := resolveImageProvider(openaiUser, "", "dall-e-3")
+	require.NoError(t, err)
+	require.Equal(t, "openai", p.Name())
+
+	p, err = resolveImageProvider(azureUser, "", "dall-e-3")
+	require.NoError(t, err)
+	require.Equal(t, "azure-dalle", p.Name())
+
+	_, err = resolveImageProvider(openaiUser, "no-such-provider", "dall-e-3")
+	require.ErrorContains(t, err, "unknown image provider")
+
+	_, err = resolveImageProvider(openaiUser, "lcm", "dall-e-3")
+	require.ErrorContains(t, err, "does not support model")
+
+	require.Subset(t, ListImageProviders(),
+		[]string{"azure-dalle", "lcm", "openai", "replicate-flux"})
+	require.NotContains(t, ListImageProviders(), "nvidia-sdxl-turbo",
+		"the nvidia route is disabled, jobs must not reach it either")
+}
+
+func TestRunImageOperation(t *testing.T) {
+	req := &ImageRequest{Model: "m", Prompt: "p", N: 5}
+	imgs, err := runImageOperation(context.Background(), fakeImageProvider{}, nil, ImageOpGenerate, req)
+	require.NoError(t, err)
+	require.Len(t, imgs, 2, "N is capped to the provider's MaxN")
+
+	_, err = runImageOperation(context.Background(), fakeImageProvider{}, nil, ImageOpInpaint, req)
+	require.ErrorIs(t, err, ErrImageOperationNotSupported)
+}
+
+func TestRunImageJob(t *testing.T) {
+	originalConfig := config.Config
+	config.Config = &config.OpenAI{}
+	config.Config.S3.Endpoint = "s3.test"
+	config.Config.S3.Bucket = "bucket"
+	t.Cleanup(func() { config.Config = originalConfig })
+
+	originalUploader := imageJobUploader
+	t.Cleanup(func() { imageJobUploader = originalUploader })
+	var (
+		mu       sync.Mutex
+		uploaded []string
+	)
+	imageJobUploader = func(_ context.Context, objkeyPrefix, _ string, _ []byte, ext string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		uploaded = append(uploaded, objkeyPrefix+ext)
+		return nil
+	}
+
+	logger := log.Shared.Named("test")
+	store := &memoryImageJobStore{jobs: map[string]ImageJob{}}
+
+	job := &ImageJob{TaskID: "job-ok", Operation: ImageOpGenerate, Prompt: "cat", N: 2}
+	runImageJob(logger, store, fakeImageProvider{}, nil, job, &ImageRequest{Prompt: "cat", N: 2})
+	saved, err := store.Load(context.Background(), "job-ok")
+	require.NoError(t, err)
+	require.Equal(t, ImageJobStatusSuccess, saved.Status)
+	require.Len(t, saved.ImageURLs, 2)
+	require.Contains(t, saved.ImageURLs[0], "https://s3.test/bucket/create-images/")
+	require.NotNil(t, saved.FinishedAt)
+	require.Len(t, uploaded, 2)
+
+	job = &ImageJob{TaskID: "job-failed", Operation: ImageOpGenerate, Prompt: "cat", N: 1}
+	runImageJob(logger, store, fakeImageProvider{err: errors.New("upstream down")},
+		nil, job, &ImageRequest{Prompt: "cat", N: 1})
+	saved, err = store.Load(context.Background(), "job-failed")
+	require.NoError(t, err)
+	require.Equal(t, ImageJobStatusFailed, saved.Status)
+	require.Contains(t, saved.FailedReason, "upstream down")
+	require.Empty(t, saved.ImageURLs)
+}
+
+func TestRunImageJobRefundsFailedJob(t *testing.T) {
+	ledger := setupCreditLedger(t)
+	config.Config.S3.Endpoint = "s3.test"
+	config.Config.S3.Bucket = "bucket"
+	originalUploader := imageJobUploader
+	imageJobUploader = func(context.Context, string, string, []byte, string) error { return nil }
+	t.Cleanup(func() { imageJobUploader = originalUploader })
+
+	logger := log.Shared.Named("test")
+	store := &memoryImageJobStore{jobs: map[string]ImageJob{}}
+	user := &config.UserConfig{UserName: "bob", PrepaidCredit: true}
+	ledger.balances["bob"] = 10
+
+	job := &ImageJob{TaskID: "job-ok", Operation: ImageOpGenerate, Model: "m", N: 1, Charged: db.PriceTxt2Image}
+	runImageJob(logger, store, fakeImageProvider{}, user, job, &ImageRequest{Prompt: "cat", N: 1})
+	require.Equal(t, db.Price(10), ledger.balances["bob"], "delivered jobs keep the charge")
+
+	job = &ImageJob{TaskID: "job-failed", Operation: ImageOpGenerate, Model: "m", N: 1, Charged: db.PriceTxt2Image}
+	runImageJob(logger, store, fakeImageProvider{err: errors.New("upstream down")},
+		user, job, &ImageRequest{Prompt: "cat", N: 1})
+	require.Equal(t, 10+db.PriceTxt2Image, ledger.balances["bob"])
+	require.Len(t, ledger.entries, 1)
+	require.Equal(t, db.CreditRefund, ledger.entries[0].Type)
+	require.Equal(t, "image-job:generate:m", ledger.entries[0].Reason)
+}
+
+func TestSubmitImageJobBillsServerKeyedProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var pushed atomic.Int32
+	billing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/token/consume" {
+			pushed.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(billing.Close)
+	originalCli := httpcli
+	httpcli = billing.Client()
+	t.Cleanup(func() { httpcli = originalCli })
+
+	originalConfig := config.Config
+	config.Config = &config.OpenAI{ExternalBillingAPI: billing.URL}
+	t.Cleanup(func() { config.Config = originalConfig })
+
+	store := &memoryImageJobStore{jobs: map[string]ImageJob{}}
+	originalStore, originalUploader := newImageJobStore, imageJobUploader
+	newImageJobStore = func() imageJobStore { return store }
+	imageJobUploader = func(context.Context, string, string, []byte, string) error { return nil }
+	t.Cleanup(func() { newImageJobStore, imageJobUploader = originalStore, originalUploader })
+
+	RegisterImageProvider(fakeImageProvider{})
+	t.Cleanup(func() {
+		imageProvidersMu.Lock()
+		delete(imageProviders, "fake")
+		imageProvidersMu.Unlock()
+	})
+
+	// a plain user is not billed for their own key, but is for the server's
+	user := &config.UserConfig{UserName: "alice", Token: "alice-token", NoLimitExpensiveModels: true}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(ctxKeyUser, user) })
+	router.POST("/jobs", SubmitImageJobHandler)
+	req := httptest.NewRequest(http.MethodPost, "/jobs",
+		strings.NewReader(`{"provider":"fake","model":"m","prompt":"cat"}`))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, int32(1), pushed.Load())
+
+	require.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		for _, job := range store.jobs {
+			return job.Status == ImageJobStatusSuccess && job.Charged == db.PriceTxt2Image
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFluxSizeFromImageRequest(t *testing.T) {
+	w, h := fluxSizeFromImageRequest("")
+	require.Equal(t, [2]int{1024, 1024}, [2]int{w, h})
+	w, h = fluxSizeFromImageRequest("2048x100")
+	require.Equal(t, [2]int{1440, 256}, [2]int{w, h})
+	w, h = fluxSizeFromImageRequest("768X512")
+	require.Equal(t, [2]int{768, 512}, [2]int{w, h})
+}
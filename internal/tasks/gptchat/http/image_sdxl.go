@@ -55,46 +55,9 @@ func DrawBySdxlturboHandlerByNvidia(ctx *gin.Context) {
 			taskCtx, cancel := context.WithTimeout(ctx, time.Minute*5)
 			defer cancel()
 
-			nvreq := NewNvidiaDrawImageBySdxlturboRequest(rawreq.Text)
-
-			upstreamReqBody, err := json.Marshal(nvreq)
-			if err != nil {
-				return errors.Wrap(err, "marshal request body")
-			}
-
-			upstreamReq, err := http.NewRequestWithContext(taskCtx, http.MethodPost,
-				"https://ai.api.nvidia.com/v1/genai/stabilityai/sdxl-turbo",
-				bytes.NewReader(upstreamReqBody))
-			if err != nil {
-				return errors.Wrap(err, "new request to nvidia")
-			}
-
-			upstreamReq.Header.Add("Content-Type", "application/json")
-			upstreamReq.Header.Set("Authorization", "Bearer "+config.Config.NvidiaApikey)
-
-			resp, err := httpcli.Do(upstreamReq) //nolint: bodyclose
-			if err != nil {
-				return errors.Wrap(err, "do request")
-			}
-			defer gutils.LogErr(resp.Body.Close, logger)
-
-			if resp.StatusCode != http.StatusOK {
-				payload, _ := io.ReadAll(resp.Body)
-				return errors.Errorf("bad status code [%d]%s", resp.StatusCode, string(payload))
-			}
-
-			respData := new(NvidiaDrawImageBySdxlturboResponse)
-			if err = json.NewDecoder(resp.Body).Decode(respData); err != nil {
-				return errors.Wrap(err, "decode response")
-			}
-
-			if len(respData.Artifacts) == 0 {
-				return errors.New("empty response")
-			}
-
-			imgcontent, err := DecodeBase64(respData.Artifacts[0].Base64)
+			imgcontent, err := fetchImageFromNvidiaSdxlturbo(taskCtx, rawreq.Text)
 			if err != nil {
-				return errors.Wrap(err, "decode image")
+				return errors.WithStack(err)
 			}
 
 			logger.Debug("succeed get image from nvidia")
@@ -156,6 +119,54 @@ func DrawBySdxlturboHandlerByNvidia(ctx *gin.Context) {
 	})
 }
 
+// fetchImageFromNvidiaSdxlturbo draws one image by nvidia's hosted sdxl-turbo
+func fetchImageFromNvidiaSdxlturbo(ctx context.Context, prompt string) ([]byte, error) {
+	logger := gmw.GetLogger(ctx)
+	nvreq := NewNvidiaDrawImageBySdxlturboRequest(prompt)
+
+	upstreamReqBody, err := json.Marshal(nvreq)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal request body")
+	}
+
+	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://ai.api.nvidia.com/v1/genai/stabilityai/sdxl-turbo",
+		bytes.NewReader(upstreamReqBody))
+	if err != nil {
+		return nil, errors.Wrap(err, "new request to nvidia")
+	}
+
+	upstreamReq.Header.Add("Content-Type", "application/json")
+	upstreamReq.Header.Set("Authorization", "Bearer "+config.Config.NvidiaApikey)
+
+	resp, err := httpcli.Do(upstreamReq) //nolint: bodyclose
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+	defer gutils.LogErr(resp.Body.Close, logger)
+
+	if resp.StatusCode != http.StatusOK {
+		payload, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("bad status code [%d]%s", resp.StatusCode, string(payload))
+	}
+
+	respData := new(NvidiaDrawImageBySdxlturboResponse)
+	if err = json.NewDecoder(resp.Body).Decode(respData); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+
+	if len(respData.Artifacts) == 0 {
+		return nil, errors.New("empty response")
+	}
+
+	imgcontent, err := DecodeBase64(respData.Artifacts[0].Base64)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode image")
+	}
+
+	return imgcontent, nil
+}
+
 func DrawBySdxlturboHandlerBySelfHosted(ctx *gin.Context) {
 	taskID := gutils.RandomStringWithLength(36)
 
@@ -0,0 +1,195 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/memoryx"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+const (
+	defaultMemorySearchLimit = 10
+	maxMemorySearchLimit     = 50
+)
+
+// openMemoryStore returns the memory store of user. It is a variable so
+// tests can replace it.
+var openMemoryStore = func(ctx context.Context, user *config.UserConfig) (*memoryx.Store, error) {
+	return memoryx.OpenStore(ctx, config.Config, user)
+}
+
+// memoryStoreOf returns the memory store of the current user.
+func memoryStoreOf(ctx *gin.Context) (*config.UserConfig, *memoryx.Store, error) {
+	user, err := getUserByAuthHeader(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get user by auth header")
+	}
+
+	store, err := openMemoryStore(gmw.Ctx(ctx), user)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open memory store")
+	}
+	return user, store, nil
+}
+
+// GetMemoryHandler lists the facts and summaries remembered about the
+// current user, `q` filters the facts.
+func GetMemoryHandler(ctx *gin.Context) {
+	_, store, err := memoryStoreOf(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	facts, err := store.Facts(gmw.Ctx(ctx), ctx.Query("q"))
+	if web.AbortErr(ctx, errors.Wrap(err, "list facts")) {
+		return
+	}
+	summaries, err := store.Summaries(gmw.Ctx(ctx))
+	if web.AbortErr(ctx, errors.Wrap(err, "list summaries")) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"project":    store.Keys().Project,
+		"session_id": store.Keys().SessionID,
+		"facts":      facts,
+		"summaries":  summaries,
+	})
+}
+
+// SearchMemoryHandler searches all memory files of the current user.
+func SearchMemoryHandler(ctx *gin.Context) {
+	_, store, err := memoryStoreOf(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	limit := defaultMemorySearchLimit
+	if raw := ctx.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 || limit > maxMemorySearchLimit {
+			web.AbortErr(ctx, errors.Errorf("limit should be between 1 and %d", maxMemorySearchLimit))
+			return
+		}
+	}
+
+	hits, err := store.Search(gmw.Ctx(ctx), ctx.Query("q"), limit)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"hits": hits})
+}
+
+// UpdateMemoryFactHandler edits the value of a fact, or pins it.
+func UpdateMemoryFactHandler(ctx *gin.Context) {
+	_, store, err := memoryStoreOf(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	req := new(memoryx.FactUpdate)
+	if err = ctx.ShouldBindJSON(req); web.AbortErr(ctx, errors.Wrap(err, "parse request")) {
+		return
+	}
+	if req.Value == nil && req.Pinned == nil {
+		web.AbortErr(ctx, errors.New("value or pinned is required"))
+		return
+	}
+
+	fact, err := store.UpdateFact(gmw.Ctx(ctx), ctx.Param("id"), *req)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, fact)
+}
+
+// DeleteMemoryFactHandler forgets one fact.
+func DeleteMemoryFactHandler(ctx *gin.Context) {
+	_, store, err := memoryStoreOf(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if web.AbortErr(ctx, store.DeleteFact(gmw.Ctx(ctx), ctx.Param("id"))) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"msg": "ok"})
+}
+
+// DeleteMemorySummaryHandler removes one summary or insight.
+func DeleteMemorySummaryHandler(ctx *gin.Context) {
+	_, store, err := memoryStoreOf(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if web.AbortErr(ctx, store.DeleteSummary(gmw.Ctx(ctx), ctx.Param("id"))) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"msg": "ok"})
+}
+
+// RunMemoryMaintenanceHandler compacts the memory of the current user.
+func RunMemoryMaintenanceHandler(ctx *gin.Context) {
+	_, store, err := memoryStoreOf(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if web.AbortErr(ctx, store.Maintain(gmw.Ctx(ctx))) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"msg": "ok"})
+}
+
+// ExportMemoryHandler downloads every memory file of the current user.
+func ExportMemoryHandler(ctx *gin.Context) {
+	user, store, err := memoryStoreOf(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	export, err := store.Export(gmw.Ctx(ctx))
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	gmw.GetLogger(ctx).Info("export memory",
+		zap.String("user", user.UserName),
+		zap.Int("files", len(export.Files)))
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="memory-%s.json"`, export.SessionID))
+	ctx.JSON(http.StatusOK, export)
+}
+
+// EraseMemoryHandler deletes everything remembered about the current user.
+// Managed api keys can not erase memory.
+func EraseMemoryHandler(ctx *gin.Context) {
+	user, store, err := memoryStoreOf(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if user.APIKeyID != "" {
+		web.AbortErr(ctx, errors.New("managed api keys can not erase memory"))
+		return
+	}
+
+	if web.AbortErr(ctx, store.Erase(gmw.Ctx(ctx))) {
+		return
+	}
+
+	gmw.GetLogger(ctx).Info("erase memory",
+		zap.String("user", user.UserName),
+		zap.String("project", store.Keys().Project),
+		zap.String("session", store.Keys().SessionID))
+	ctx.JSON(http.StatusOK, gin.H{"msg": "ok"})
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Laisky/go-utils/v6/agents/memory"
+	memorystorage "github.com/Laisky/go-utils/v6/agents/memory/storage"
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/Laisky/testify/require"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/memoryx"
+)
+
+// memoryFiles is an in-memory memorystorage.Engine.
+type memoryFiles struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func (m *memoryFiles) Read(_ context.Context, _, path string, _, _ int64) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files[path], nil
+}
+
+func (m *memoryFiles) Write(_ context.Context, _, path, content string, mode memorystorage.WriteMode, _ int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mode == memorystorage.WriteModeAppend {
+		content = m.files[path] + content
+	}
+	m.files[path] = content
+	return nil
+}
+
+func (m *memoryFiles) Stat(_ context.Context, _, path string) (memorystorage.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.files[path]
+	return memorystorage.FileInfo{Path: path, Exists: ok, Type: memorystorage.FileTypeFile}, nil
+}
+
+func (m *memoryFiles) List(_ context.Context, _, path string, _, _ int) ([]memorystorage.FileInfo, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []memorystorage.FileInfo
+	for p := range m.files {
+		if strings.HasPrefix(p, path+"/") {
+			entries = append(entries, memorystorage.FileInfo{Path: p, Exists: true, Type: memorystorage.FileTypeFile})
+		}
+	}
+	return entries, false, nil
+}
+
+func (m *memoryFiles) Search(_ context.Context, _, query, _ string, _ int) ([]memorystorage.FileChunk, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var chunks []memorystorage.FileChunk
+	for p, body := range m.files {
+		if strings.Contains(body, query) {
+			chunks = append(chunks, memorystorage.FileChunk{FilePath: p, Content: body})
+		}
+	}
+	return chunks, nil
+}
+
+func (m *memoryFiles) Delete(_ context.Context, _, path string, _ bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p := range m.files {
+		if strings.HasPrefix(p, path+"/") {
+			delete(m.files, p)
+		}
+	}
+	return nil
+}
+
+// stubMemoryEngine is a memory.Engine without management support.
+type stubMemoryEngine struct{}
+
+func (stubMemoryEngine) BeforeTurn(context.Context, memory.BeforeTurnInput) (memory.BeforeTurnOutput, error) {
+	return memory.BeforeTurnOutput{}, nil
+}
+
+func (stubMemoryEngine) AfterTurn(context.Context, memory.AfterTurnInput) error { return nil }
+
+// setupMemoryStore serves the memory of every user from one session
+// holding a fact.
+func setupMemoryStore(t *testing.T) *memoryFiles {
+	t.Helper()
+	fact, err := json.Marshal(memory.MemoryFact{ID: "t1", TS: "2026-10-17T08:00:00Z", FactID: "user_name",
+		Key: "name", Value: "alice", Confidence: 0.9, Tier: "L0", State: "active"})
+	require.NoError(t, err)
+	files := &memoryFiles{files: map[string]string{
+		"/memory/s1/memory_tiers/L0/2026/10/facts-202610.jsonl": string(fact) + "\n",
+	}}
+
+	original := openMemoryStore
+	openMemoryStore = func(_ context.Context, user *config.UserConfig) (*memoryx.Store, error) {
+		return memoryx.NewStore(files, stubMemoryEngine{},
+			memoryx.RuntimeKeys{Project: "p", SessionID: "s1", UserID: user.UserName}), nil
+	}
+	t.Cleanup(func() { openMemoryStore = original })
+	return files
+}
+
+// newMemoryRouter serves the memory routes.
+func newMemoryRouter() *gin.Engine {
+	router := gin.New()
+	router.GET("/user/memory", GetMemoryHandler)
+	router.GET("/user/memory/search", SearchMemoryHandler)
+	router.GET("/user/memory/export", ExportMemoryHandler)
+	router.POST("/user/memory/facts/:id", UpdateMemoryFactHandler)
+	router.DELETE("/user/memory/facts/:id", DeleteMemoryFactHandler)
+	router.POST("/user/memory/maintenance", RunMemoryMaintenanceHandler)
+	router.DELETE("/user/memory", EraseMemoryHandler)
+	return router
+}
+
+func TestMemoryHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupPaidUsers(t)
+	setupAPIKeyStore(t)
+	files := setupMemoryStore(t)
+	router := newMemoryRouter()
+
+	resp, payload := testRequest(t, router, http.MethodGet, "/user/memory", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, "s1", payload["session_id"])
+	facts := payload["facts"].([]any)
+	require.Len(t, facts, 1)
+	require.Equal(t, "user_name::name", facts[0].(map[string]any)["id"])
+	require.Equal(t, true, facts[0].(map[string]any)["pinned"])
+
+	resp, payload = testRequest(t, router, http.MethodPost, "/user/memory/facts/user_name::name", testPaidToken,
+		`{"value":"Alice","pinned":false}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, "Alice", payload["value"])
+	require.Equal(t, false, payload["pinned"])
+
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/memory/facts/user_name::name", testPaidToken, `{}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/memory/facts/nope", testPaidToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp, payload = testRequest(t, router, http.MethodGet, "/user/memory/search?q=Alice", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.NotEmpty(t, payload["hits"])
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/memory/search?q=Alice&limit=500", testPaidToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/memory/maintenance", testPaidToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code, "the engine does not support maintenance")
+
+	resp, payload = testRequest(t, router, http.MethodGet, "/user/memory/export", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Contains(t, resp.Header().Get("Content-Disposition"), "memory-s1.json")
+	require.Len(t, payload["files"], 3, "two shards and the index")
+
+	// managed api keys can not erase memory
+	resp, payload = testRequest(t, newAPIKeyRouter(), http.MethodPost, "/user/keys", testPaidToken,
+		`{"name":"ci","scopes":["chat"]}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/memory", payload["key"].(string), "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Len(t, files.files, 3)
+
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/memory", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Empty(t, files.files)
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/testify/require"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/moderation"
+)
+
+type stubClassifier struct {
+	flagged bool
+	err     error
+	texts   []string
+}
+
+func (c *stubClassifier) Classify(_ context.Context, text string) (bool, string, error) {
+	c.texts = append(c.texts, text)
+	return c.flagged, "test", c.err
+}
+
+func setupModeration(t *testing.T, classifier moderation.Classifier) {
+	t.Helper()
+	p, err := moderation.New(config.ModerationConfig{
+		Enabled: true,
+		Rules: []config.ModerationRule{
+			{Detector: config.ModerationDetectorAPIKey, Action: config.ModerationActionBlock},
+			{Detector: config.ModerationDetectorEmail, Action: config.ModerationActionMaskUpstream},
+			{Detector: config.ModerationDetectorPhone, Action: config.ModerationActionMaskPersist},
+			{Detector: config.ModerationDetectorLLM, Action: config.ModerationActionBlock},
+		},
+	}, classifier)
+	require.NoError(t, err)
+
+	original := ModerationPipeline
+	ModerationPipeline = func() *moderation.Pipeline { return p }
+	t.Cleanup(func() { ModerationPipeline = original })
+}
+
+func TestModerateFrontendReq(t *testing.T) {
+	classifier := &stubClassifier{}
+	setupModeration(t, classifier)
+
+	req := &FrontendReq{Messages: []FrontendReqMessage{
+		{Role: OpenaiMessageRoleSystem, Content: FrontendReqMessageContent{StringContent: "be nice"}},
+		{Role: OpenaiMessageRoleUser, Content: FrontendReqMessageContent{StringContent: "mail bob@example.com"}},
+		{Role: OpenaiMessageRoleAI, Content: FrontendReqMessageContent{StringContent: "sure"}},
+		{Role: OpenaiMessageRoleUser, Content: FrontendReqMessageContent{ArrayContent: []OpenaiVisionMessageContent{
+			{Type: OpenaiVisionMessageContentTypeText, Text: "call +1 555 123 4567, cc alice@example.com"},
+		}}},
+	}}
+	require.NoError(t, moderateFrontendReq(newAuthContext(""), req))
+	require.Equal(t, "mail [REDACTED:email]", req.Messages[1].Content.String())
+	require.Equal(t, "call +1 555 123 4567, cc [REDACTED:email]", req.Messages[3].Content.String(),
+		"mask_persist findings still go upstream")
+	require.Equal(t, []string{"call +1 555 123 4567, cc [REDACTED:email]"}, classifier.texts,
+		"only the latest user message is classified")
+	require.Equal(t, "call [REDACTED:phone], cc [REDACTED:email]",
+		ModerationPipeline().Persist(req.Messages[3].Content.String()))
+
+	// secrets block the request
+	req = &FrontendReq{Messages: []FrontendReqMessage{
+		{Role: OpenaiMessageRoleUser, Content: FrontendReqMessageContent{StringContent: "my key sk-abcdefghijklmnopqrstuvwx"}},
+	}}
+	err := moderateFrontendReq(newAuthContext(""), req)
+	require.ErrorIs(t, err, moderation.ErrBlocked)
+
+	// the classifier blocks flagged requests, but failing open
+	req.Messages[0].Content.StringContent = "hello"
+	classifier.flagged = true
+	require.ErrorIs(t, moderateFrontendReq(newAuthContext(""), req), moderation.ErrBlocked)
+	classifier.flagged, classifier.err = false, errors.New("classifier down")
+	require.NoError(t, moderateFrontendReq(newAuthContext(""), req))
+}
+
+func TestModerateFrontendReq_Disabled(t *testing.T) {
+	original := ModerationPipeline
+	ModerationPipeline = func() *moderation.Pipeline { return nil }
+	t.Cleanup(func() { ModerationPipeline = original })
+
+	req := &FrontendReq{Messages: []FrontendReqMessage{
+		{Role: OpenaiMessageRoleUser, Content: FrontendReqMessageContent{StringContent: "sk-abcdefghijklmnopqrstuvwx"}},
+	}}
+	require.NoError(t, moderateFrontendReq(newAuthContext(""), req))
+	require.Equal(t, "sk-abcdefghijklmnopqrstuvwx", req.Messages[0].Content.String())
+}
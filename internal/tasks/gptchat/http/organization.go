@@ -0,0 +1,617 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+const (
+	orgMemberCol = "org_members"
+	// orgMemberCacheTTL bounds how long a removed member keeps the
+	// organization's entitlements on other instances.
+	orgMemberCacheTTL = 30 * time.Second
+	// maxOrgMembers caps the members of one organization.
+	maxOrgMembers = 500
+)
+
+var (
+	// ErrOrgMemberNotFound is returned for users without a membership.
+	ErrOrgMemberNotFound = errors.New("organization member not found")
+	// ErrOrgMemberElsewhere is returned when adding a user who already
+	// belongs to another organization.
+	ErrOrgMemberElsewhere = errors.New("user belongs to another organization")
+	// ErrOrgInviteNotFound is returned when a user has no invite to act on.
+	ErrOrgInviteNotFound = errors.New("no pending organization invite")
+)
+
+// orgRoleError rejects users lacking the role an organization operation
+// needs.
+type orgRoleError struct{ msg string }
+
+func (e *orgRoleError) Error() string {
+	return e.msg
+}
+
+// HTTPStatus returns 403.
+func (e *orgRoleError) HTTPStatus() int {
+	return http.StatusForbidden
+}
+
+// orgMemberStore keeps the memberships of organizations.
+type orgMemberStore interface {
+	// Get finds the membership of username, ErrOrgMemberNotFound if none.
+	Get(ctx context.Context, username string) (*db.OrgMember, error)
+	// List returns the members of orgID, by username.
+	List(ctx context.Context, orgID string) ([]db.OrgMember, error)
+	// Upsert invites member to its organization or updates its role,
+	// replacing a pending invite of another organization. It fails with
+	// ErrOrgMemberElsewhere if the user belongs to another one.
+	Upsert(ctx context.Context, member *db.OrgMember) error
+	// Accept marks the pending invite of username to orgID as accepted,
+	// ErrOrgInviteNotFound if there is none.
+	Accept(ctx context.Context, orgID, username string, at time.Time) error
+	// Delete removes username from orgID, ErrOrgMemberNotFound if absent.
+	Delete(ctx context.Context, orgID, username string) error
+}
+
+var (
+	// orgMemberCache caches memberships by username, a zero OrgMember
+	// marks a user without one.
+	orgMemberCache = gutils.NewExpCache[*db.OrgMember](context.Background(), orgMemberCacheTTL)
+
+	mongoOrgMemberStoreOnce sync.Once
+	mongoOrgMemberStoreIns  *mongoOrgMemberStore
+	mongoOrgMemberStoreErr  error
+)
+
+// newOrgMemberStore returns the membership store in use. It is a variable
+// so tests can replace mongo.
+var newOrgMemberStore = func() (orgMemberStore, error) {
+	mongoOrgMemberStoreOnce.Do(func() {
+		mongoOrgMemberStoreIns, mongoOrgMemberStoreErr = newMongoOrgMemberStore()
+	})
+	return mongoOrgMemberStoreIns, mongoOrgMemberStoreErr
+}
+
+type mongoOrgMemberStore struct {
+	col *mongo.Collection
+}
+
+//nolint:contextcheck // indexes are created once, detached from any request
+func newMongoOrgMemberStore() (*mongoOrgMemberStore, error) {
+	openaiDB, err := db.GetOpenaiDB()
+	if err != nil {
+		return nil, errors.Wrap(err, "get openai db")
+	}
+
+	s := &mongoOrgMemberStore{col: openaiDB.GetCol(orgMemberCol)}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if _, err = s.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "org_id", Value: 1}},
+	}); err != nil {
+		return nil, errors.Wrap(err, "create org member indexes")
+	}
+
+	return s, nil
+}
+
+// Get implements orgMemberStore.
+func (s *mongoOrgMemberStore) Get(ctx context.Context, username string) (*db.OrgMember, error) {
+	member := new(db.OrgMember)
+	if err := s.col.FindOne(ctx, bson.M{"_id": username}).Decode(member); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOrgMemberNotFound
+		}
+		return nil, errors.Wrap(err, "find org member")
+	}
+
+	return member, nil
+}
+
+// List implements orgMemberStore.
+func (s *mongoOrgMemberStore) List(ctx context.Context, orgID string) ([]db.OrgMember, error) {
+	cur, err := s.col.Find(ctx, bson.M{"org_id": orgID},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "find members of %q", orgID)
+	}
+
+	var members []db.OrgMember
+	if err = cur.All(ctx, &members); err != nil {
+		return nil, errors.Wrap(err, "decode org members")
+	}
+	return members, nil
+}
+
+// Upsert implements orgMemberStore. The username is the document id, so
+// upserting a member of another organization hits a duplicate key.
+func (s *mongoOrgMemberStore) Upsert(ctx context.Context, member *db.OrgMember) error {
+	_, err := s.col.UpdateOne(ctx,
+		bson.M{"_id": member.Username, "$or": bson.A{
+			bson.M{"org_id": member.OrgID},
+			bson.M{"accepted_at": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{
+			"org_id":     member.OrgID,
+			"role":       member.Role,
+			"added_by":   member.AddedBy,
+			"created_at": member.CreatedAt,
+			"updated_at": member.UpdatedAt,
+		}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrOrgMemberElsewhere
+	}
+	return errors.Wrapf(err, "upsert org member %q", member.Username)
+}
+
+// Accept implements orgMemberStore.
+func (s *mongoOrgMemberStore) Accept(ctx context.Context, orgID, username string, at time.Time) error {
+	res, err := s.col.UpdateOne(ctx,
+		bson.M{"_id": username, "org_id": orgID, "accepted_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"accepted_at": at, "updated_at": at}})
+	if err != nil {
+		return errors.Wrapf(err, "accept org invite of %q", username)
+	}
+	if res.MatchedCount == 0 {
+		return ErrOrgInviteNotFound
+	}
+	return nil
+}
+
+// Delete implements orgMemberStore.
+func (s *mongoOrgMemberStore) Delete(ctx context.Context, orgID, username string) error {
+	res, err := s.col.DeleteOne(ctx, bson.M{"_id": username, "org_id": orgID})
+	if err != nil {
+		return errors.Wrapf(err, "delete org member %q", username)
+	}
+	if res.DeletedCount == 0 {
+		return ErrOrgMemberNotFound
+	}
+	return nil
+}
+
+// configOrgOwner returns the organization whose settings list username as
+// an owner.
+func configOrgOwner(username string) *config.OrganizationConfig {
+	for _, org := range config.Config.Organizations {
+		if slices.Contains(org.Owners, username) {
+			return org
+		}
+	}
+	return nil
+}
+
+// orgMembership returns the organization and role of username, nil if
+// the user is not a member.
+func orgMembership(ctx context.Context, username string) (*config.OrganizationConfig, string, error) {
+	if len(config.Config.Organizations) == 0 || username == "" {
+		return nil, "", nil
+	}
+	if org := configOrgOwner(username); org != nil {
+		return org, config.OrgRoleOwner, nil
+	}
+
+	member, ok := orgMemberCache.Load(username)
+	if !ok {
+		store, err := newOrgMemberStore()
+		if err != nil {
+			return nil, "", errors.Wrap(err, "get org member store")
+		}
+		member, err = store.Get(ctx, username)
+		switch {
+		case errors.Is(err, ErrOrgMemberNotFound):
+			member = &db.OrgMember{}
+		case err != nil:
+			return nil, "", errors.Wrap(err, "get org member")
+		}
+		orgMemberCache.Store(username, member)
+	}
+
+	// invites grant nothing until accepted, and memberships of
+	// organizations dropped from the settings are ignored
+	if member.AcceptedAt.IsZero() {
+		return nil, "", nil
+	}
+	org := config.Config.Organization(member.OrgID)
+	if org == nil {
+		return nil, "", nil
+	}
+	return org, member.Role, nil
+}
+
+// applyOrganization grants user the entitlements of their organization.
+// Users whose membership can not be loaded keep their own entitlements.
+func applyOrganization(ctx context.Context, user *config.UserConfig) {
+	org, role, err := orgMembership(ctx, user.UserName)
+	if err != nil {
+		gmw.GetLogger(ctx).Warn("get organization, treat user as non-member",
+			zap.String("user", user.UserName), zap.Error(err))
+		return
+	}
+	if org == nil {
+		return
+	}
+
+	user.OrgID = org.ID
+	user.OrgRole = role
+	if !slices.Contains(user.AllowedModels, "*") {
+		for _, m := range org.AllowedModels {
+			if !slices.Contains(user.AllowedModels, m) {
+				user.AllowedModels = append(user.AllowedModels, m)
+			}
+		}
+	}
+}
+
+// orgAdmin returns the current user and their organization, if the user
+// holds at least role in it. Managed api keys can not administer.
+func orgAdmin(ctx *gin.Context, role string) (*config.UserConfig, *config.OrganizationConfig, error) {
+	user, err := getUserByAuthHeader(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get user by auth header")
+	}
+
+	org := config.Config.Organization(user.OrgID)
+	switch {
+	case org == nil:
+		return nil, nil, &orgRoleError{msg: "user is not a member of any organization"}
+	case user.APIKeyID != "" && role != config.OrgRoleMember:
+		return nil, nil, &orgRoleError{msg: "managed api keys can not administer organizations"}
+	case !config.OrgRoleAtLeast(user.OrgRole, role):
+		return nil, nil, &orgRoleError{msg: "organization " + role + " role required"}
+	}
+	return user, org, nil
+}
+
+// orgMembers lists the members of org, its configured owners first.
+func orgMembers(ctx context.Context, org *config.OrganizationConfig) ([]db.OrgMember, error) {
+	store, err := newOrgMemberStore()
+	if err != nil {
+		return nil, errors.Wrap(err, "get org member store")
+	}
+	stored, err := store.List(ctx, org.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "list org members")
+	}
+
+	members := make([]db.OrgMember, 0, len(org.Owners)+len(stored))
+	for _, owner := range org.Owners {
+		members = append(members, db.OrgMember{Username: owner, OrgID: org.ID, Role: config.OrgRoleOwner})
+	}
+	for _, m := range stored {
+		if !slices.Contains(org.Owners, m.Username) {
+			m.Pending = m.AcceptedAt.IsZero()
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// GetOrganizationHandler returns the current user's organization and role.
+func GetOrganizationHandler(ctx *gin.Context) {
+	user, org, err := orgAdmin(ctx, config.OrgRoleMember)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"organization": gin.H{
+			"id":             org.ID,
+			"name":           org.Name,
+			"allowed_models": org.AllowedModels,
+			"token_quota":    org.TokenQuota,
+		},
+		"role": user.OrgRole,
+	})
+}
+
+// ListOrgMembersHandler lists the members of the admin's organization.
+func ListOrgMembersHandler(ctx *gin.Context) {
+	_, org, err := orgAdmin(ctx, config.OrgRoleAdmin)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	members, err := orgMembers(gmw.Ctx(ctx), org)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// UpsertOrgMemberRequest adds a member or changes their role.
+type UpsertOrgMemberRequest struct {
+	Username string `json:"username"`
+	// Role default to member
+	Role string `json:"role"`
+}
+
+// UpsertOrgMemberHandler invites a user to the admin's organization, or
+// changes a member's role. The user only joins once they accept the
+// invite by AcceptOrgInviteHandler. Only owners may grant or revoke admin
+// and owner roles.
+func UpsertOrgMemberHandler(ctx *gin.Context) {
+	admin, org, err := orgAdmin(ctx, config.OrgRoleAdmin)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	req := new(UpsertOrgMemberRequest)
+	if err = ctx.BindJSON(req); err != nil {
+		web.AbortErr(ctx, errors.Wrap(err, "bind request"))
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Role = strings.ToLower(strings.TrimSpace(gutils.OptionalVal(&req.Role, config.OrgRoleMember)))
+	switch {
+	case req.Username == "":
+		web.AbortErr(ctx, errors.New("username is required"))
+		return
+	case !slices.Contains(config.OrgRoles, req.Role):
+		web.AbortErr(ctx, errors.Errorf("unknown role %q, should be one of %v", req.Role, config.OrgRoles))
+		return
+	case configOrgOwner(req.Username) != nil:
+		web.AbortErr(ctx, errors.Errorf("%q is an owner in the settings", req.Username))
+		return
+	}
+
+	store, err := newOrgMemberStore()
+	if web.AbortErr(ctx, errors.Wrap(err, "get org member store")) {
+		return
+	}
+	current, err := store.Get(gmw.Ctx(ctx), req.Username)
+	switch {
+	case errors.Is(err, ErrOrgMemberNotFound):
+		current = nil
+	case err != nil:
+		web.AbortErr(ctx, err)
+		return
+	case current.OrgID != org.ID && !current.AcceptedAt.IsZero():
+		web.AbortErr(ctx, errors.Wrapf(ErrOrgMemberElsewhere, "add %q", req.Username))
+		return
+	case current.OrgID != org.ID:
+		// a pending invite of another organization is replaced
+		current = nil
+	}
+	if admin.OrgRole != config.OrgRoleOwner &&
+		(req.Role != config.OrgRoleMember || (current != nil && current.Role != config.OrgRoleMember)) {
+		web.AbortErr(ctx, &orgRoleError{msg: "only owners can manage admins and owners"})
+		return
+	}
+
+	if current == nil {
+		members, err := store.List(gmw.Ctx(ctx), org.ID)
+		if web.AbortErr(ctx, err) {
+			return
+		}
+		if len(members) >= maxOrgMembers {
+			web.AbortErr(ctx, errors.Errorf("at most %d members per organization", maxOrgMembers))
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	member := &db.OrgMember{
+		Username:  req.Username,
+		OrgID:     org.ID,
+		Role:      req.Role,
+		AddedBy:   admin.UserName,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if current != nil {
+		member.CreatedAt = current.CreatedAt
+		member.AcceptedAt = current.AcceptedAt
+	}
+	if web.AbortErr(ctx, store.Upsert(gmw.Ctx(ctx), member)) {
+		return
+	}
+	orgMemberCache.Delete(member.Username)
+	member.Pending = member.AcceptedAt.IsZero()
+
+	gmw.GetLogger(ctx).Info("upsert org member",
+		zap.String("org", org.ID),
+		zap.String("member", member.Username),
+		zap.String("role", member.Role),
+		zap.String("by", admin.UserName))
+	ctx.JSON(http.StatusOK, gin.H{"member": member})
+}
+
+// RemoveOrgMemberHandler removes a member from the admin's organization.
+// Admins may only remove members, owners may remove anyone but the owners
+// listed in the settings.
+func RemoveOrgMemberHandler(ctx *gin.Context) {
+	admin, org, err := orgAdmin(ctx, config.OrgRoleAdmin)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	username := ctx.Param("username")
+	if slices.Contains(org.Owners, username) {
+		web.AbortErr(ctx, errors.Errorf("%q is an owner in the settings", username))
+		return
+	}
+
+	store, err := newOrgMemberStore()
+	if web.AbortErr(ctx, errors.Wrap(err, "get org member store")) {
+		return
+	}
+	member, err := store.Get(gmw.Ctx(ctx), username)
+	if err == nil && member.OrgID != org.ID {
+		err = ErrOrgMemberNotFound
+	}
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if admin.OrgRole != config.OrgRoleOwner && member.Role != config.OrgRoleMember {
+		web.AbortErr(ctx, &orgRoleError{msg: "only owners can manage admins and owners"})
+		return
+	}
+
+	if web.AbortErr(ctx, store.Delete(gmw.Ctx(ctx), org.ID, username)) {
+		return
+	}
+	orgMemberCache.Delete(username)
+
+	gmw.GetLogger(ctx).Info("remove org member",
+		zap.String("org", org.ID),
+		zap.String("member", username),
+		zap.String("by", admin.UserName))
+	ctx.JSON(http.StatusOK, gin.H{"member": member})
+}
+
+// orgInvitee returns the current user and their pending invite. Only users
+// who could be members may act on invites.
+func orgInvitee(ctx *gin.Context) (*config.UserConfig, *db.OrgMember, orgMemberStore, error) {
+	user, err := getUserByAuthHeader(ctx)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "get user by auth header")
+	}
+	switch {
+	case user.APIKeyID != "":
+		return nil, nil, nil, &orgRoleError{msg: "managed api keys can not act on organization invites"}
+	case user.IsFree, user.BYOK:
+		return nil, nil, nil, &orgRoleError{msg: "free-tier and byok users can not join organizations"}
+	}
+
+	store, err := newOrgMemberStore()
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "get org member store")
+	}
+	invite, err := store.Get(gmw.Ctx(ctx), user.UserName)
+	switch {
+	case errors.Is(err, ErrOrgMemberNotFound):
+		return nil, nil, nil, ErrOrgInviteNotFound
+	case err != nil:
+		return nil, nil, nil, errors.Wrap(err, "get org member")
+	case !invite.AcceptedAt.IsZero() || config.Config.Organization(invite.OrgID) == nil:
+		return nil, nil, nil, ErrOrgInviteNotFound
+	}
+
+	invite.Pending = true
+	return user, invite, store, nil
+}
+
+// GetOrgInviteHandler returns the current user's pending invite.
+func GetOrgInviteHandler(ctx *gin.Context) {
+	_, invite, _, err := orgInvitee(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"invite": invite})
+}
+
+// AcceptOrgInviteHandler joins the current user to the organization that
+// invited them.
+func AcceptOrgInviteHandler(ctx *gin.Context) {
+	user, invite, store, err := orgInvitee(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	invite.AcceptedAt = time.Now().UTC()
+	if web.AbortErr(ctx, store.Accept(gmw.Ctx(ctx), invite.OrgID, user.UserName, invite.AcceptedAt)) {
+		return
+	}
+	orgMemberCache.Delete(user.UserName)
+	invite.Pending = false
+
+	gmw.GetLogger(ctx).Info("accept org invite",
+		zap.String("org", invite.OrgID),
+		zap.String("member", user.UserName),
+		zap.String("role", invite.Role))
+	ctx.JSON(http.StatusOK, gin.H{"member": invite})
+}
+
+// DeclineOrgInviteHandler drops the current user's pending invite.
+func DeclineOrgInviteHandler(ctx *gin.Context) {
+	user, invite, store, err := orgInvitee(ctx)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	if web.AbortErr(ctx, store.Delete(gmw.Ctx(ctx), invite.OrgID, user.UserName)) {
+		return
+	}
+	orgMemberCache.Delete(user.UserName)
+
+	gmw.GetLogger(ctx).Info("decline org invite",
+		zap.String("org", invite.OrgID),
+		zap.String("member", user.UserName))
+	ctx.JSON(http.StatusOK, gin.H{"invite": invite})
+}
+
+// orgMemberUsage is the token usage of one member.
+type orgMemberUsage struct {
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+	Tokens   int64  `json:"tokens"`
+}
+
+// OrgUsageHandler returns the token usage of each member of the admin's
+// organization over the last `days` days, default 7. Usage of former
+// members is listed without a role.
+func OrgUsageHandler(ctx *gin.Context) {
+	_, org, err := orgAdmin(ctx, config.OrgRoleAdmin)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	days := 7
+	if raw := ctx.Query("days"); raw != "" {
+		if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > orgUsageMaxDays {
+			web.AbortErr(ctx, errors.Errorf("days should be 1 to %d", orgUsageMaxDays))
+			return
+		}
+	}
+
+	members, err := orgMembers(gmw.Ctx(ctx), org)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	usage, err := loadOrgUsage(gmw.Ctx(ctx), org.ID, days)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	var total int64
+	resp := make([]orgMemberUsage, 0, len(members))
+	for _, m := range members {
+		resp = append(resp, orgMemberUsage{Username: m.Username, Role: m.Role, Tokens: usage[m.Username]})
+		delete(usage, m.Username)
+	}
+	for username, tokens := range usage {
+		resp = append(resp, orgMemberUsage{Username: username, Tokens: tokens})
+	}
+	for _, u := range resp {
+		total += u.Tokens
+	}
+	sort.SliceStable(resp, func(i, j int) bool { return resp[i].Tokens > resp[j].Tokens })
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"days":        days,
+		"total":       total,
+		"token_quota": org.TokenQuota,
+		"members":     resp,
+	})
+}
@@ -0,0 +1,287 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laisky/testify/require"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+)
+
+// memoryOrgMemberStore is an in-memory orgMemberStore.
+type memoryOrgMemberStore struct {
+	mu      sync.Mutex
+	members map[string]db.OrgMember
+}
+
+func (s *memoryOrgMemberStore) Get(_ context.Context, username string) (*db.OrgMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.members[username]
+	if !ok {
+		return nil, ErrOrgMemberNotFound
+	}
+	return &m, nil
+}
+
+func (s *memoryOrgMemberStore) List(_ context.Context, orgID string) ([]db.OrgMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []db.OrgMember
+	for _, m := range s.members {
+		if m.OrgID == orgID {
+			members = append(members, m)
+		}
+	}
+	slices.SortFunc(members, func(a, b db.OrgMember) int { return strings.Compare(a.Username, b.Username) })
+	return members, nil
+}
+
+func (s *memoryOrgMemberStore) Upsert(_ context.Context, member *db.OrgMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.members[member.Username]; ok && m.OrgID != member.OrgID && !m.AcceptedAt.IsZero() {
+		return ErrOrgMemberElsewhere
+	}
+	s.members[member.Username] = *member
+	return nil
+}
+
+func (s *memoryOrgMemberStore) Accept(_ context.Context, orgID, username string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.members[username]
+	if !ok || m.OrgID != orgID || !m.AcceptedAt.IsZero() {
+		return ErrOrgInviteNotFound
+	}
+	m.AcceptedAt, m.UpdatedAt = at, at
+	s.members[username] = m
+	return nil
+}
+
+func (s *memoryOrgMemberStore) Delete(_ context.Context, orgID, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.members[username]; !ok || m.OrgID != orgID {
+		return ErrOrgMemberNotFound
+	}
+	delete(s.members, username)
+	return nil
+}
+
+// setupOrganizations adds the organization acme owned by alice, and
+// serves its members from memory.
+func setupOrganizations(t *testing.T) *memoryOrgMemberStore {
+	t.Helper()
+	config.Config.Organizations = []*config.OrganizationConfig{
+		{
+			ID:            "acme",
+			Name:          "Acme",
+			Owners:        []string{"alice"},
+			AllowedModels: []string{"claude-sonnet"},
+			TokenQuota:    config.OrgTokenQuota{Limit: 100000, WindowMinutes: 60},
+			MemoryProject: "acme-memory",
+		},
+		{ID: "globex", Owners: []string{"hank"}},
+	}
+
+	store := &memoryOrgMemberStore{members: map[string]db.OrgMember{}}
+	originalStore := newOrgMemberStore
+	newOrgMemberStore = func() (orgMemberStore, error) { return store, nil }
+	t.Cleanup(func() {
+		newOrgMemberStore = originalStore
+		for _, name := range []string{"alice", "bob", "carol", "dave", "hank"} {
+			orgMemberCache.Delete(name)
+		}
+	})
+	return store
+}
+
+// newOrgRouter serves the organization routes.
+func newOrgRouter() *gin.Engine {
+	router := gin.New()
+	router.GET("/user/org", GetOrganizationHandler)
+	router.GET("/user/org/members", ListOrgMembersHandler)
+	router.POST("/user/org/members", UpsertOrgMemberHandler)
+	router.DELETE("/user/org/members/:username", RemoveOrgMemberHandler)
+	router.GET("/user/org/usage", OrgUsageHandler)
+	router.GET("/user/org/invite", GetOrgInviteHandler)
+	router.POST("/user/org/invite/accept", AcceptOrgInviteHandler)
+	router.DELETE("/user/org/invite", DeclineOrgInviteHandler)
+	return router
+}
+
+func TestApplyOrganization(t *testing.T) {
+	setupPaidUsers(t)
+	setupOrganizations(t)
+
+	alice, err := getUserByToken(newAuthContext(""), testPaidToken)
+	require.NoError(t, err)
+	require.Equal(t, "acme", alice.OrgID)
+	require.Equal(t, config.OrgRoleOwner, alice.OrgRole)
+	require.Equal(t, []string{"gpt-4o", "gpt-5", "claude-sonnet"}, alice.AllowedModels)
+	require.Equal(t, []string{"gpt-4o", "gpt-5"}, config.Config.UserTokens[1].AllowedModels,
+		"the static user is not modified")
+
+	bob, err := getUserByToken(newAuthContext(""), testBobToken)
+	require.NoError(t, err)
+	require.Empty(t, bob.OrgID)
+	require.Equal(t, []string{"gpt-4o"}, bob.AllowedModels)
+}
+
+func TestOrganizationMembership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupPaidUsers(t)
+	store := setupOrganizations(t)
+	router := newOrgRouter()
+
+	// alice owns acme through the settings
+	resp, payload := testRequest(t, router, http.MethodGet, "/user/org", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, "owner", payload["role"])
+
+	// non-members can not see any organization
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/org", testBobToken, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	// an invite grants nothing until accepted
+	resp, payload = testRequest(t, router, http.MethodPost, "/user/org/members", testPaidToken,
+		`{"username":"bob","role":"admin"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, true, payload["member"].(map[string]any)["pending"])
+	bob, err := getUserByToken(newAuthContext(""), testBobToken)
+	require.NoError(t, err)
+	require.Empty(t, bob.OrgID)
+	require.NotContains(t, bob.AllowedModels, "claude-sonnet")
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/org", testBobToken, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp, payload = testRequest(t, router, http.MethodGet, "/user/org/invite", testBobToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, "acme", payload["invite"].(map[string]any)["org_id"])
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/invite/accept", testBobToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	bob, err = getUserByToken(newAuthContext(""), testBobToken)
+	require.NoError(t, err)
+	require.Equal(t, "acme", bob.OrgID)
+	require.Contains(t, bob.AllowedModels, "claude-sonnet")
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/invite/accept", testBobToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code, "nothing left to accept")
+
+	// admins invite members, but not admins
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/members", testBobToken, `{"username":"carol"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, config.OrgRoleMember, store.members["carol"].Role)
+	require.Equal(t, "bob", store.members["carol"].AddedBy)
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/invite/accept", testCarolToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/members", testBobToken,
+		`{"username":"dave","role":"admin"}`)
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/members", testBobToken,
+		`{"username":"dave","role":"boss"}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// users belong to one organization
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/members", testPaidToken, `{"username":"hank"}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.NoError(t, store.Upsert(context.Background(), &db.OrgMember{
+		Username: "dave", OrgID: "globex", Role: "member", AcceptedAt: time.Now()}))
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/members", testPaidToken, `{"username":"dave"}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), ErrOrgMemberElsewhere.Error())
+
+	// a pending invite of another organization is replaced
+	require.NoError(t, store.Upsert(context.Background(), &db.OrgMember{Username: "frank", OrgID: "globex", Role: "member"}))
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/members", testPaidToken, `{"username":"frank"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, "acme", store.members["frank"].OrgID)
+
+	// members can not administer
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/org/members", testCarolToken, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp, payload = testRequest(t, router, http.MethodGet, "/user/org/members", testBobToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var names []string
+	for _, m := range payload["members"].([]any) {
+		names = append(names, m.(map[string]any)["username"].(string))
+	}
+	require.Equal(t, []string{"alice", "bob", "carol", "frank"}, names)
+
+	// owners in the settings stay, admins can not remove admins
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/org/members/alice", testBobToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.NoError(t, store.Upsert(context.Background(), &db.OrgMember{
+		Username: "erin", OrgID: "acme", Role: "admin", AcceptedAt: time.Now()}))
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/org/members/erin", testBobToken, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/org/members/dave", testBobToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code, "dave is in another organization")
+
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/org/members/carol", testBobToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	carol, err := getUserByToken(newAuthContext(""), testCarolToken)
+	require.NoError(t, err)
+	require.Empty(t, carol.OrgID)
+}
+
+func TestDeclineOrgInvite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupPaidUsers(t)
+	store := setupOrganizations(t)
+	router := newOrgRouter()
+
+	resp, _ := testRequest(t, router, http.MethodGet, "/user/org/invite", testBobToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/members", testPaidToken, `{"username":"bob"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp, _ = testRequest(t, router, http.MethodDelete, "/user/org/invite", testBobToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.NotContains(t, store.members, "bob")
+
+	// free-tier users can not be invited into a quota
+	resp, _ = testRequest(t, router, http.MethodPost, "/user/org/invite/accept", "FREETIER-0123456789abcdef", "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+func TestOrgUsageHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupPaidUsers(t)
+	store := setupOrganizations(t)
+	router := newOrgRouter()
+	require.NoError(t, store.Upsert(context.Background(), &db.OrgMember{
+		Username: "bob", OrgID: "acme", Role: "member", AcceptedAt: time.Now()}))
+
+	originalUsage := loadOrgUsage
+	var gotDays int
+	loadOrgUsage = func(_ context.Context, orgID string, days int) (map[string]int64, error) {
+		require.Equal(t, "acme", orgID)
+		gotDays = days
+		return map[string]int64{"bob": 300, "alice": 100, "former": 50}, nil
+	}
+	t.Cleanup(func() { loadOrgUsage = originalUsage })
+
+	resp, payload := testRequest(t, router, http.MethodGet, "/user/org/usage?days=3", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, 3, gotDays)
+	require.EqualValues(t, 450, payload["total"])
+	members := payload["members"].([]any)
+	require.Len(t, members, 3)
+	require.Equal(t, map[string]any{"username": "bob", "role": "member", "tokens": float64(300)}, members[0])
+	require.Equal(t, map[string]any{"username": "former", "tokens": float64(50)}, members[2])
+
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/org/usage?days=90", testPaidToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/org/usage", testBobToken, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+}
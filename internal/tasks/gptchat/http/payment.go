@@ -1,15 +1,21 @@
 package http
 
 import (
+	"context"
+	"io"
 	"net/http"
 
 	"github.com/Laisky/errors/v2"
 	gmw "github.com/Laisky/gin-middlewares/v7"
+	"github.com/Laisky/go-utils/v6/json"
 	"github.com/Laisky/zap"
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v76"
 	"github.com/stripe/stripe-go/v76/paymentintent"
+	"github.com/stripe/stripe-go/v76/webhook"
 
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
 	"github.com/Laisky/go-ramjet/library/web"
 )
 
@@ -22,6 +28,10 @@ type paymentRequest struct {
 }
 
 // PaymentHandler creates a Stripe PaymentIntent.
+//
+// The intent carries the credit account in its metadata, so the webhook
+// can credit the user once the payment succeeds. Anonymous users are
+// refused, see creditAccount.
 func PaymentHandler(c *gin.Context) {
 	logger := gmw.GetLogger(c)
 	if c.Request.Method != http.MethodPost {
@@ -29,8 +39,17 @@ func PaymentHandler(c *gin.Context) {
 		return
 	}
 
+	user, err := getUserByAuthHeader(c)
+	if web.AbortErr(c, errors.Wrap(err, "get user by auth header")) {
+		return
+	}
+	account, err := creditAccount(user)
+	if web.AbortErr(c, err) {
+		return
+	}
+
 	req := new(paymentRequest)
-	if err := c.BindJSON(req); web.AbortErr(c, err) {
+	if err = c.BindJSON(req); web.AbortErr(c, err) {
 		return
 	}
 
@@ -47,6 +66,7 @@ func PaymentHandler(c *gin.Context) {
 			Enabled: stripe.Bool(true),
 		},
 	}
+	params.AddMetadata(stripeMetadataUsername, account)
 
 	pi, err := paymentintent.New(params)
 	if web.AbortErr(c, err) {
@@ -54,9 +74,175 @@ func PaymentHandler(c *gin.Context) {
 	}
 
 	logger.Info("create payment intent",
+		zap.String("username", account),
 		zap.Int64("amount", amount),
 		zap.String("client", pi.ID))
 	c.JSON(http.StatusOK, gin.H{
 		"clientSecret": pi.ClientSecret,
 	})
 }
+
+const (
+	stripeMetadataUsername = "username"
+	// maxStripeWebhookBytes is what Stripe recommends reading at most.
+	maxStripeWebhookBytes = 65536
+)
+
+// StripeWebhookHandler receives signed Stripe events and keeps the credit
+// ledger in sync with payments.
+//
+// Stripe delivers events at least once and retries on any non-2xx status,
+// so every ledger entry is keyed by the Stripe object it comes from.
+func StripeWebhookHandler(c *gin.Context) {
+	logger := gmw.GetLogger(c)
+	if config.Config.PaymentStripeWebhookSecret == "" {
+		web.AbortErr(c, errors.New("stripe webhook is not configured"))
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxStripeWebhookBytes))
+	if web.AbortErr(c, errors.Wrap(err, "read body")) {
+		return
+	}
+
+	event, err := webhook.ConstructEventWithOptions(payload,
+		c.GetHeader("Stripe-Signature"),
+		config.Config.PaymentStripeWebhookSecret,
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true})
+	if web.AbortErr(c, errors.Wrap(err, "verify stripe signature")) {
+		return
+	}
+
+	logger = logger.With(zap.String("event_id", event.ID), zap.String("event_type", string(event.Type)))
+	ledger, err := newCreditLedger()
+	if err != nil {
+		logger.Error("get credit ledger", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"err": "credit ledger unavailable"})
+		return
+	}
+
+	switch event.Type {
+	case stripe.EventTypePaymentIntentSucceeded:
+		err = handleStripePaymentSucceeded(gmw.Ctx(c), ledger, event)
+	case stripe.EventTypeRefundCreated, stripe.EventTypeRefundUpdated:
+		err = handleStripeRefund(gmw.Ctx(c), ledger, event)
+	default:
+		logger.Debug("ignore stripe event")
+	}
+	if err != nil {
+		// let stripe retry, the ledger skips whatever was already applied
+		logger.Error("handle stripe event", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"err": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+// stripeTopUpKey is the idempotency key of the top-up paid by a PaymentIntent.
+func stripeTopUpKey(paymentIntentID string) string {
+	return "stripe:payment_intent:" + paymentIntentID
+}
+
+func handleStripePaymentSucceeded(ctx context.Context, ledger creditLedger, event stripe.Event) error {
+	pi := new(stripe.PaymentIntent)
+	if err := json.Unmarshal(event.Data.Raw, pi); err != nil {
+		return errors.Wrap(err, "unmarshal payment intent")
+	}
+
+	username := pi.Metadata[stripeMetadataUsername]
+	if username == "" {
+		// not created by PaymentHandler, nothing to credit
+		gmw.GetLogger(ctx).Warn("payment intent without username",
+			zap.String("payment_intent", pi.ID))
+		return nil
+	}
+
+	amount, err := stripeAmountToQuota(pi.AmountReceived, pi.Currency)
+	if err != nil {
+		return errors.Wrapf(err, "convert amount of payment intent %q", pi.ID)
+	}
+
+	balance, applied, err := ledger.Apply(ctx, &db.CreditEntry{
+		Username:       username,
+		Type:           db.CreditTopUp,
+		Amount:         amount,
+		Reason:         "stripe payment " + pi.ID,
+		IdempotencyKey: stripeTopUpKey(pi.ID),
+	}, false)
+	if err != nil {
+		return errors.Wrap(err, "apply top-up")
+	}
+
+	creditStatusCache.Delete(username)
+	gmw.GetLogger(ctx).Info("stripe top-up",
+		zap.String("username", username),
+		zap.Bool("applied", applied),
+		zap.Int("amount", amount.Int()),
+		zap.Int("balance", balance.Int()))
+	return nil
+}
+
+// handleStripeRefund takes back the credit of a refunded top-up. The user
+// is found through the top-up entry, since refunds carry no metadata of
+// the payment intent.
+func handleStripeRefund(ctx context.Context, ledger creditLedger, event stripe.Event) error {
+	refund := new(stripe.Refund)
+	if err := json.Unmarshal(event.Data.Raw, refund); err != nil {
+		return errors.Wrap(err, "unmarshal refund")
+	}
+	if refund.Status != stripe.RefundStatusSucceeded || refund.PaymentIntent == nil {
+		return nil
+	}
+
+	topUp, found, err := ledger.Entry(ctx, stripeTopUpKey(refund.PaymentIntent.ID))
+	if err != nil {
+		return errors.Wrap(err, "get top-up entry")
+	}
+	if !found {
+		gmw.GetLogger(ctx).Warn("refund of unknown payment intent",
+			zap.String("refund", refund.ID),
+			zap.String("payment_intent", refund.PaymentIntent.ID))
+		return nil
+	}
+
+	amount, err := stripeAmountToQuota(refund.Amount, refund.Currency)
+	if err != nil {
+		return errors.Wrapf(err, "convert amount of refund %q", refund.ID)
+	}
+
+	// the money is already returned, so the balance may go negative
+	balance, applied, err := ledger.Apply(ctx, &db.CreditEntry{
+		Username:       topUp.Username,
+		Type:           db.CreditRefund,
+		Amount:         -amount,
+		Reason:         "stripe refund " + refund.ID,
+		IdempotencyKey: "stripe:refund:" + refund.ID,
+	}, true)
+	if err != nil {
+		return errors.Wrap(err, "apply refund")
+	}
+
+	creditStatusCache.Delete(topUp.Username)
+	gmw.GetLogger(ctx).Info("stripe refund",
+		zap.String("username", topUp.Username),
+		zap.Bool("applied", applied),
+		zap.Int("amount", amount.Int()),
+		zap.Int("balance", balance.Int()))
+	return nil
+}
+
+// stripeAmountToQuota converts an amount in the smallest currency unit to quota.
+func stripeAmountToQuota(amount int64, currency stripe.Currency) (db.Price, error) {
+	var perUnit db.Price
+	switch currency {
+	case stripe.CurrencyCNY:
+		perUnit = db.PriceRMB
+	case stripe.CurrencyUSD:
+		perUnit = db.PriceUSD
+	default:
+		return 0, errors.Errorf("unsupported currency %q", currency)
+	}
+
+	return db.Price(amount) * perUnit / 100, nil
+}
@@ -25,27 +25,52 @@ const (
 	tokenQuotaWindow           = 10 * time.Minute
 )
 
+const (
+	// orgUsageTTL keeps daily per-member usage for the org usage API.
+	orgUsageTTL = 35 * 24 * time.Hour
+	// orgUsageMaxDays caps the days the org usage API sums over.
+	orgUsageMaxDays = 31
+)
+
 var (
 	tokenQuotaOnce sync.Once
 	tokenQuotaMgr  *TokenQuotaManager
+
+	// reserveQuota takes tokens from a quota. It is a variable so tests
+	// can replace redis.
+	reserveQuota = (*TokenQuotaManager).reserve
 )
 
 // QuotaExceededError indicates the free-tier quota has been exhausted.
 type QuotaExceededError struct {
+	// Subject is "free tier" or the organization whose quota is exhausted
+	Subject    string
 	Limit      int
 	Used       int
 	Remaining  int
 	RetryAfter time.Duration
+	// Window is the rolling window of the quota
+	Window time.Duration
 }
 
 func (e *QuotaExceededError) Error() string {
-	return fmt.Sprintf("free tier quota exceeded: limit %d tokens per 10-minute window, %d tokens used", e.Limit, e.Used)
+	return fmt.Sprintf("%s quota exceeded: limit %d tokens per %d-minute window, %d tokens used",
+		e.Subject, e.Limit, int(e.Window/time.Minute), e.Used)
 }
 
-// TokenQuotaManager keeps track of per-user token usage within a rolling window.
+// TokenQuotaManager keeps track of per-user, or per-organization, token
+// usage within a rolling window.
 type TokenQuotaManager struct {
-	client         *redis.Client
-	logger         glog.Logger
+	client *redis.Client
+	logger glog.Logger
+	// subject names the quota in errors
+	subject string
+	// keyPrefix prefixes the redis key of each quota holder
+	keyPrefix string
+	// orgID is set for organization quotas, whose usage is also recorded
+	// per member
+	orgID string
+	// limit <=0 means unlimited
 	limit          int
 	windowDuration time.Duration
 	windowMinutes  int64
@@ -60,8 +85,11 @@ type TokenReservation struct {
 	promptTokens   int
 	reservedOutput int
 	reservedTotal  int
-	createdAt      time.Time
-	once           sync.Once
+	// memberKey and member locate the per-member usage of org quotas
+	memberKey string
+	member    string
+	createdAt time.Time
+	once      sync.Once
 }
 
 var quotaAdjustScript = redis.NewScript(`
@@ -95,6 +123,8 @@ func getTokenQuotaManager() *TokenQuotaManager {
 		tokenQuotaMgr = &TokenQuotaManager{
 			client:         client.Client,
 			logger:         log.Logger.Named("token_quota"),
+			subject:        "free tier",
+			keyPrefix:      "ramjet:gptchat:quota:",
 			limit:          tokenQuotaLimit,
 			windowDuration: tokenQuotaWindow,
 			windowMinutes:  int64(tokenQuotaWindow / time.Minute),
@@ -105,23 +135,64 @@ func getTokenQuotaManager() *TokenQuotaManager {
 	return tokenQuotaMgr
 }
 
-// Reserve tokens for a free-tier user. Returns nil when no reservation is needed.
+// getOrgQuotaManager returns the quota manager of org, whose usage in redis
+// is shared by its members. It is built per request, so changed settings
+// apply at once.
+func getOrgQuotaManager(org *config.OrganizationConfig) *TokenQuotaManager {
+	window := time.Duration(org.TokenQuota.WindowMinutes) * time.Minute
+	return &TokenQuotaManager{
+		client:         rutils.GetCli().GetDB().Client,
+		logger:         log.Logger.Named("org_token_quota"),
+		subject:        fmt.Sprintf("organization %q", org.ID),
+		keyPrefix:      "ramjet:gptchat:quota:org:",
+		orgID:          org.ID,
+		limit:          org.TokenQuota.Limit,
+		windowDuration: window,
+		windowMinutes:  int64(org.TokenQuota.WindowMinutes),
+		ttl:            window + 2*time.Minute,
+	}
+}
+
+// ReserveTokens reserves tokens from the quota of an organization member,
+// or of a free-tier user. Returns nil when no reservation is needed.
 func ReserveTokens(ctx *gin.Context, user *config.UserConfig, req *FrontendReq) (*TokenReservation, error) {
-	if ctx == nil || user == nil || req == nil || !user.IsFree {
+	if req == nil {
+		return nil, nil
+	}
+
+	return reserveTokenCount(ctx, user, req.PromptTokens(), int(req.MaxTokens))
+}
+
+// reserveTokenCount is ReserveTokens for requests whose tokens are
+// already counted, such as embeddings of token ids.
+func reserveTokenCount(ctx *gin.Context,
+	user *config.UserConfig, promptTokens, estimatedOutput int) (*TokenReservation, error) {
+	if ctx == nil || user == nil {
 		return nil, nil
 	}
 
-	manager := getTokenQuotaManager()
-	if manager == nil {
-		return nil, errors.New("token quota manager not available")
+	var manager *TokenQuotaManager
+	holder := user.UserName
+	switch {
+	case user.OrgID != "":
+		org := config.Config.Organization(user.OrgID)
+		if org == nil {
+			return nil, nil
+		}
+		manager = getOrgQuotaManager(org)
+		holder = org.ID
+	case user.IsFree:
+		if manager = getTokenQuotaManager(); manager == nil {
+			return nil, errors.New("token quota manager not available")
+		}
+	default:
+		return nil, nil
 	}
 
-	promptTokens := req.PromptTokens()
-	estimatedOutput := int(req.MaxTokens)
 	if estimatedOutput < 0 {
 		estimatedOutput = 0
 	}
-	reservation, err := manager.reserve(gmw.Ctx(ctx), user.UserName, promptTokens, estimatedOutput)
+	reservation, err := reserveQuota(manager, gmw.Ctx(ctx), holder, user.UserName, promptTokens, estimatedOutput)
 	if err != nil {
 		return nil, err
 	}
@@ -153,12 +224,21 @@ func clearTokenReservation(ctx *gin.Context) {
 	}
 }
 
-func (m *TokenQuotaManager) key(user string) string {
-	return fmt.Sprintf("ramjet:gptchat:quota:%s", user)
+func (m *TokenQuotaManager) key(holder string) string {
+	return m.keyPrefix + holder
+}
+
+// orgUsageKey is the redis hash of the per-member token usage of orgID
+// on the day of t.
+func orgUsageKey(orgID string, t time.Time) string {
+	return fmt.Sprintf("ramjet:gptchat:org_usage:%s:%s", orgID, t.UTC().Format("20060102"))
 }
 
-func (m *TokenQuotaManager) reserve(ctx context.Context, user string, promptTokens, estimatedOutput int) (*TokenReservation, error) {
-	if user == "" {
+// reserve reserves tokens from holder's quota, recording them against
+// member too for organization quotas.
+func (m *TokenQuotaManager) reserve(ctx context.Context, holder, member string,
+	promptTokens, estimatedOutput int) (*TokenReservation, error) {
+	if holder == "" {
 		return nil, errors.New("empty user name for quota reservation")
 	}
 
@@ -174,10 +254,13 @@ func (m *TokenQuotaManager) reserve(ctx context.Context, user string, promptToke
 		fields = append(fields, strconv.FormatInt(bucket-i, 10))
 	}
 
-	key := m.key(user)
-	vals, err := m.client.HMGet(ctx, key, fields...).Result()
-	if err != nil {
-		return nil, errors.Wrap(err, "load token quota usage")
+	key := m.key(holder)
+	var vals []any
+	if m.limit > 0 {
+		var err error
+		if vals, err = m.client.HMGet(ctx, key, fields...).Result(); err != nil {
+			return nil, errors.Wrap(err, "load token quota usage")
+		}
 	}
 
 	used := 0
@@ -199,7 +282,7 @@ func (m *TokenQuotaManager) reserve(ctx context.Context, user string, promptToke
 		}
 	}
 
-	if used+total > m.limit {
+	if m.limit > 0 && used+total > m.limit {
 		retryAfter := m.windowDuration
 		if earliestBucket >= 0 {
 			earliestTime := time.Unix(earliestBucket*60, 0).UTC()
@@ -215,6 +298,8 @@ func (m *TokenQuotaManager) reserve(ctx context.Context, user string, promptToke
 			remaining = 0
 		}
 		return nil, &QuotaExceededError{
+			Subject:    m.subject,
+			Window:     m.windowDuration,
 			Limit:      m.limit,
 			Used:       used,
 			Remaining:  remaining,
@@ -230,7 +315,7 @@ func (m *TokenQuotaManager) reserve(ctx context.Context, user string, promptToke
 		m.logger.Warn("set quota ttl", zap.Error(err), zap.String("key", key))
 	}
 
-	return &TokenReservation{
+	reservation := &TokenReservation{
 		manager:        m,
 		key:            key,
 		field:          fields[0],
@@ -238,15 +323,29 @@ func (m *TokenQuotaManager) reserve(ctx context.Context, user string, promptToke
 		reservedOutput: estimatedOutput,
 		reservedTotal:  total,
 		createdAt:      now,
-	}, nil
+	}
+	if m.orgID != "" && member != "" {
+		reservation.memberKey = orgUsageKey(m.orgID, now)
+		reservation.member = member
+		if err := m.adjustKey(ctx, reservation.memberKey, member, int64(total), orgUsageTTL); err != nil {
+			m.logger.Warn("record org member usage", zap.Error(err),
+				zap.String("org", m.orgID), zap.String("member", member))
+		}
+	}
+
+	return reservation, nil
 }
 
 func (m *TokenQuotaManager) adjust(ctx context.Context, key, field string, delta int64) error {
+	return m.adjustKey(ctx, key, field, delta, m.ttl)
+}
+
+func (m *TokenQuotaManager) adjustKey(ctx context.Context, key, field string, delta int64, ttl time.Duration) error {
 	if delta == 0 {
 		return nil
 	}
 
-	_, err := quotaAdjustScript.Run(ctx, m.client, []string{key}, field, delta, int64(m.ttl/time.Second)).Result()
+	_, err := quotaAdjustScript.Run(ctx, m.client, []string{key}, field, delta, int64(ttl/time.Second)).Result()
 	if err != nil {
 		return errors.Wrap(err, "adjust token quota")
 	}
@@ -254,6 +353,25 @@ func (m *TokenQuotaManager) adjust(ctx context.Context, key, field string, delta
 	return nil
 }
 
+// loadOrgUsage sums the per-member token usage of orgID over the last
+// days, today included. It is a variable so tests can replace redis.
+var loadOrgUsage = func(ctx context.Context, orgID string, days int) (map[string]int64, error) {
+	client := rutils.GetCli().GetDB().Client
+	usage := map[string]int64{}
+	now := time.Now()
+	for i := 0; i < days; i++ {
+		vals, err := client.HGetAll(ctx, orgUsageKey(orgID, now.AddDate(0, 0, -i))).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "load org usage")
+		}
+		for member, raw := range vals {
+			usage[member] += int64(parseRedisInt(raw))
+		}
+	}
+
+	return usage, nil
+}
+
 // Finalize updates the reservation to match the actual token usage.
 func (r *TokenReservation) Finalize(ctx context.Context, actualOutputTokens int) error {
 	if r == nil {
@@ -279,6 +397,11 @@ func (r *TokenReservation) Finalize(ctx context.Context, actualOutputTokens int)
 		if err := r.manager.adjust(ctx, r.key, r.field, int64(delta)); err != nil {
 			result = err
 		}
+		if r.memberKey != "" {
+			if err := r.manager.adjustKey(ctx, r.memberKey, r.member, int64(delta), orgUsageTTL); err != nil {
+				result = errors.Join(result, err)
+			}
+		}
 	})
 
 	return result
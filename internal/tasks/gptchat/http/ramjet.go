@@ -295,6 +295,10 @@ func setUserAuth(gctx *gin.Context, req *http.Request) error {
 
 		// generate image need special token
 		if strings.HasPrefix(req.URL.Path, "/gptchat/image/") {
+			if err = requireUserScope(user, ScopeImage); err != nil {
+				return errors.WithStack(err)
+			}
+
 			cost = db.PriceTxt2Image
 			costReason = "txt2image"
 			token = user.ImageToken
@@ -309,7 +313,7 @@ func setUserAuth(gctx *gin.Context, req *http.Request) error {
 		req.Header.Set("Authorization", token)
 	}
 
-	if user.EnableExternalImageBilling {
+	if needsBilling(user) {
 		if err := checkUserExternalBilling(gmw.Ctx(gctx), user, cost, costReason); err != nil {
 			return errors.Wrapf(err, "check quota for user %q", user.UserName)
 		}
@@ -393,7 +397,53 @@ func GetUserInternalBill(ctx context.Context,
 //  1. get user's current quota from external billing api
 //  2. check if user has enough quota
 //  3. update user's quota
+//
+// Users paying by prepaid credit are debited from the credit ledger instead,
+// and managed api keys add the cost to their spend.
 func checkUserExternalBilling(ctx context.Context,
+	user *config.UserConfig, cost db.Price, costReason string) (err error) {
+	if err = chargeAPIKeySpend(ctx, user, cost, false); err != nil {
+		return errors.Wrap(err, "charge api key")
+	}
+
+	switch {
+	case user.PrepaidCredit:
+		return chargeCredit(ctx, user, cost, costReason, false)
+	case user.APIKeyID != "" && !user.EnableExternalImageBilling:
+		// only the key's spend is tracked, the owner is not billed externally
+		return nil
+	}
+
+	return pushExternalBillingCost(ctx, user, cost, costReason)
+}
+
+// refundUserBilling gives back cost charged by checkUserExternalBilling for
+// an operation that delivered nothing, through the same channels.
+func refundUserBilling(ctx context.Context,
+	user *config.UserConfig, cost db.Price, costReason string) (err error) {
+	if cost <= 0 {
+		return nil
+	}
+
+	if user.APIKeyID != "" {
+		if err = addAPIKeySpend(ctx, user.APIKeyID, -cost, true); err != nil {
+			return errors.Wrapf(err, "refund %d to api key %q", cost.Int(), user.APIKeyID)
+		}
+	}
+
+	switch {
+	case user.PrepaidCredit:
+		return refundCredit(ctx, user, cost, costReason)
+	case user.APIKeyID != "" && !user.EnableExternalImageBilling:
+		return nil
+	}
+
+	return pushExternalBillingCost(ctx, user, -cost, "refund:"+costReason)
+}
+
+// pushExternalBillingCost adds cost to the user's used quota in the
+// external billing api. A negative cost gives quota back.
+func pushExternalBillingCost(ctx context.Context,
 	user *config.UserConfig, cost db.Price, costReason string) (err error) {
 	logger := log.Logger.Named("openai.billing")
 	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	rlimiter "github.com/Laisky/go-ramjet/library/ratelimit"
+)
+
+// Pseudo models of features that are rate limited like a model. They
+// fall into the catch-all tier unless a tier lists them.
+const (
+	rateLimitModelWebSearch = "web-search"
+	rateLimitModelMCPTool   = "mcp-tool"
+)
+
+var (
+	tierLimitersMu sync.Mutex
+	// tierLimiters caches limiters by tier settings, so changing a tier
+	// in config gets fresh buckets.
+	tierLimiters = map[string]rlimiter.KeyedLimiter{}
+)
+
+// tierLimiter returns the keyed limiter of one bucket kind of a tier.
+func tierLimiter(tier *config.ModelTier, kind string, burst int) (rlimiter.KeyedLimiter, error) {
+	name := fmt.Sprintf("gptchat:tier:%s:%s", tier.Name, kind)
+	cacheKey := fmt.Sprintf("%s:%d:%d", name, burst, tier.RefillPerSec)
+
+	tierLimitersMu.Lock()
+	defer tierLimitersMu.Unlock()
+	if l, ok := tierLimiters[cacheKey]; ok {
+		return l, nil
+	}
+
+	l, err := rlimiter.NewKeyed(name, rlimiter.Args{Max: burst, NPerSec: tier.RefillPerSec})
+	if err != nil {
+		return nil, errors.Wrapf(err, "new limiter %q", name)
+	}
+	tierLimiters[cacheKey] = l
+	return l, nil
+}
+
+// checkModelRateLimit takes one request of model from the buckets of its
+// tier, one keyed by user and one by client IP, so a heavy user only
+// exhausts their own budget. A request denied by any bucket takes nothing
+// from the others.
+//
+// The `RateLimit-*` headers of the tighter bucket are set when ctx is a
+// gin context. A denied request returns *rlimiter.ExceededError whose
+// message is msgFormat filled with the seconds to wait.
+func checkModelRateLimit(ctx context.Context, user *config.UserConfig, model, msgFormat string) error {
+	logger := gmw.GetLogger(ctx)
+	tier := config.Config.ModelTierOf(model)
+	if tier == nil || tier.Cost <= 0 {
+		logger.Debug("no rate limit for model", zap.String("model", model))
+		return nil
+	}
+
+	var ip string
+	gctx, _ := ctx.(*gin.Context)
+	if gctx != nil && gctx.Request != nil {
+		ip = gctx.ClientIP()
+	}
+
+	// anonymous callers choose their own name, so only their address
+	// tells them apart
+	userKey := user.UserName
+	if user.IsAnonymous() {
+		userKey = "anonymous:" + ip
+	}
+
+	buckets := []rateLimitBucket{{"user", userKey, tier.Burst}}
+	if ip != "" {
+		buckets = append(buckets, rateLimitBucket{"ip", ip, tier.IPBurst})
+	}
+
+	limiters := make([]rlimiter.KeyedLimiter, len(buckets))
+	for i, b := range buckets {
+		limiter, err := tierLimiter(tier, b.kind, b.burst)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		limiters[i] = limiter
+	}
+
+	var tightest *rlimiter.Result
+	for i, b := range buckets {
+		result := limiters[i].AllowN(ctx, b.key, tier.Cost)
+		logger.Debug("check rate limit",
+			zap.String("model", model),
+			zap.String("tier", tier.Name),
+			zap.String("bucket", b.kind),
+			zap.Bool("allowed", result.Allowed),
+			zap.Int("remaining", result.Remaining))
+		if !result.Allowed {
+			// a denied request costs nothing, so a busy shared address
+			// never drains the users behind it
+			for j := range i {
+				limiters[j].ReturnN(ctx, buckets[j].key, tier.Cost)
+			}
+			return &rlimiter.ExceededError{
+				Result: result,
+				Msg:    fmt.Sprintf(msgFormat, int(math.Ceil(result.RetryAfter.Seconds()))),
+			}
+		}
+		if tightest == nil || result.Remaining < tightest.Remaining {
+			tightest = &result
+		}
+	}
+
+	if gctx != nil && tightest != nil {
+		tightest.SetHeaders(gctx.Writer.Header())
+	}
+	return nil
+}
+
+type rateLimitBucket struct {
+	kind, key string
+	burst     int
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/testify/require"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	rlimiter "github.com/Laisky/go-ramjet/library/ratelimit"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+func setupMemoryRateLimit(t *testing.T, tiers []config.ModelTier) {
+	t.Helper()
+	originalBackend := gconfig.Shared.GetString("openai.rate_limiter_backend")
+	gconfig.Shared.Set("openai.rate_limiter_backend", rlimiter.BackendLegacy)
+	originalConfig := config.Config
+	config.Config = &config.OpenAI{ModelTiers: tiers}
+
+	tierLimitersMu.Lock()
+	originalLimiters := tierLimiters
+	tierLimiters = map[string]rlimiter.KeyedLimiter{}
+	tierLimitersMu.Unlock()
+
+	t.Cleanup(func() {
+		gconfig.Shared.Set("openai.rate_limiter_backend", originalBackend)
+		config.Config = originalConfig
+		tierLimitersMu.Lock()
+		tierLimiters = originalLimiters
+		tierLimitersMu.Unlock()
+	})
+}
+
+func newRateLimitTestContext(ip string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/chat", nil)
+	ctx.Request.RemoteAddr = ip + ":1234"
+	return ctx, w
+}
+
+func TestIsModelAllowedRateLimitsPerUserAndIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupMemoryRateLimit(t, []config.ModelTier{
+		{Name: config.ModelTierExpensive, Models: []string{"*"},
+			Cost: 10, Burst: 10, IPBurst: 30, RefillPerSec: 1},
+	})
+	alice := &config.UserConfig{UserName: "alice", AllowedModels: []string{"*"}}
+	bob := &config.UserConfig{UserName: "bob", AllowedModels: []string{"*"}}
+
+	ctx, w := newRateLimitTestContext("10.0.0.1")
+	require.NoError(t, IsModelAllowed(ctx, alice, &FrontendReq{Model: "gpt-5"}))
+	require.Equal(t, "10", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	ctx, w = newRateLimitTestContext("10.0.0.1")
+	err := IsModelAllowed(ctx, alice, &FrontendReq{Model: "gpt-5"})
+	var exceeded *rlimiter.ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	require.Contains(t, err.Error(), "hold on for 10 seconds")
+	web.AbortErr(ctx, err)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "10", w.Header().Get("Retry-After"))
+
+	// a heavy user does not lock out others, until their shared IP runs dry
+	ctx, _ = newRateLimitTestContext("10.0.0.2")
+	require.NoError(t, IsModelAllowed(ctx, bob, &FrontendReq{Model: "gpt-5"}))
+	for _, name := range []string{"carol", "dave"} {
+		ctx, _ = newRateLimitTestContext("10.0.0.1")
+		user := &config.UserConfig{UserName: name, AllowedModels: []string{"*"}}
+		require.NoError(t, IsModelAllowed(ctx, user, &FrontendReq{Model: "gpt-5"}))
+	}
+	ctx, _ = newRateLimitTestContext("10.0.0.1")
+	erin := &config.UserConfig{UserName: "erin", AllowedModels: []string{"*"}}
+	err = IsModelAllowed(ctx, erin, &FrontendReq{Model: "gpt-5"})
+	require.ErrorAs(t, err, &exceeded)
+
+	// the denied request took nothing from erin's own bucket
+	ctx, w = newRateLimitTestContext("10.0.0.3")
+	require.NoError(t, IsModelAllowed(ctx, erin, &FrontendReq{Model: "gpt-5"}))
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	// unlimited users skip the buckets
+	ctx, _ = newRateLimitTestContext("10.0.0.1")
+	require.NoError(t, IsModelAllowed(ctx, &config.UserConfig{UserName: "alice", BYOK: true},
+		&FrontendReq{Model: "gpt-5"}))
+}
+
+func TestIsModelAllowedUsesModelTiers(t *testing.T) {
+	setupMemoryRateLimit(t, []config.ModelTier{
+		{Name: config.ModelTierFree, Models: []string{"gpt-4o-mini"}},
+		{Name: config.ModelTierExpensive, Models: []string{"*"},
+			Cost: 5, Burst: 5, IPBurst: 5, RefillPerSec: 1},
+	})
+	user := &config.UserConfig{UserName: "alice", AllowedModels: []string{"*"}}
+
+	ctx, _ := newRateLimitTestContext("10.0.0.1")
+	for range 5 {
+		require.NoError(t, IsModelAllowed(ctx, user, &FrontendReq{Model: "gpt-4o-mini"}))
+	}
+
+	require.NoError(t, checkModelRateLimit(ctx, user, rateLimitModelWebSearch, "wait %d"))
+	require.EqualError(t, checkModelRateLimit(ctx, user, rateLimitModelMCPTool, "wait %d"), "wait 5")
+}
+
+func TestIsModelAllowedKeysAnonymousUsersByIP(t *testing.T) {
+	setupMemoryRateLimit(t, []config.ModelTier{
+		{Name: config.ModelTierExpensive, Models: []string{"*"},
+			Cost: 10, Burst: 10, IPBurst: 100, RefillPerSec: 1},
+	})
+	anonymous := func(name string) *config.UserConfig {
+		return &config.UserConfig{UserName: name, Token: config.FreetierUserToken, AllowedModels: []string{"*"}}
+	}
+
+	ctx, _ := newRateLimitTestContext("10.0.0.1")
+	require.NoError(t, IsModelAllowed(ctx, anonymous("FREETIER-a"), &FrontendReq{Model: "gpt-5"}))
+
+	// a fresh name from the same address shares the bucket
+	ctx, _ = newRateLimitTestContext("10.0.0.1")
+	var exceeded *rlimiter.ExceededError
+	require.ErrorAs(t, IsModelAllowed(ctx, anonymous("FREETIER-b"), &FrontendReq{Model: "gpt-5"}), &exceeded)
+
+	ctx, _ = newRateLimitTestContext("10.0.0.2")
+	require.NoError(t, IsModelAllowed(ctx, anonymous("FREETIER-b"), &FrontendReq{Model: "gpt-5"}))
+}
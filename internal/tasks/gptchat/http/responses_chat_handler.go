@@ -12,7 +12,6 @@ import (
 
 	"github.com/Laisky/errors/v2"
 	gmw "github.com/Laisky/gin-middlewares/v7"
-	gconfig "github.com/Laisky/go-config/v2"
 	gutils "github.com/Laisky/go-utils/v6"
 	"github.com/Laisky/go-utils/v6/json"
 	"github.com/Laisky/zap"
@@ -20,6 +19,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/memoryx"
 	"github.com/Laisky/go-ramjet/library/web"
 )
@@ -78,7 +78,9 @@ type memoryTurnContext struct {
 // Intermediate steps are streamed via delta.reasoning_content so the UI can render them
 // inside the collapsible Thinking panel.
 func ChatHandler(ctx *gin.Context) {
-	_ = sendChatWithResponsesToolLoop(ctx)
+	usage := startUsage(ctx)
+	err := sendChatWithResponsesToolLoop(ctx)
+	finishUsage(ctx, usage, err)
 }
 
 func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
@@ -87,6 +89,11 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 	if web.AbortErr(ctx, err) {
 		return err
 	}
+	usage := usageFromCtx(ctx)
+	if usage != nil {
+		usage.Model = frontendReq.Model
+		usage.PromptTokens = frontendReq.PromptTokens()
+	}
 
 	// ---------------------------------------------------------
 	// Special flow: Image generation
@@ -96,6 +103,9 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 	if isImageModel(frontendReq.Model) {
 		logger.Debug("routing image model request to image generation logic",
 			zap.String("model", frontendReq.Model))
+		if err := requireUserScope(user, ScopeImage); web.AbortErr(ctx, err) {
+			return err
+		}
 
 		// Extract prompt from last user message
 		var prompt string
@@ -112,7 +122,7 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 			return err
 		}
 
-		if user.EnableExternalImageBilling {
+		if needsBilling(user) {
 			if err := checkUserExternalBilling(gmw.Ctx(ctx),
 				user, GetImageModelPrice(frontendReq.Model), "txt2image"); web.AbortErr(ctx, err) {
 				return err
@@ -175,6 +185,10 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 			)
 			markdownText += fmt.Sprintf("![Image](%s)\n\n", url)
 		}
+		if usage != nil {
+			usage.Kind = db.UsageKindImage
+			usage.Cost = GetImageModelPrice(frontendReq.Model) * db.Price(len(imgContents))
+		}
 
 		return writeFinalToUI(ctx, frontendReq, nil, strings.TrimSpace(markdownText), "", nil)
 	}
@@ -188,6 +202,12 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 	// request that does not flip the switch (acceptance criterion #5,
 	// proposal §4.2 decision #1).
 	if ctx.GetBool(ctxKeyAgentMode) {
+		if usage != nil {
+			usage.Kind = db.UsageKindAgent
+		}
+		if err := requireUserScope(user, ScopeAgent); web.AbortErr(ctx, err) {
+			return err
+		}
 		if registeredAgentDispatcher == nil {
 			ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{
 				"error": "agent_mode_disabled",
@@ -213,6 +233,15 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 	reservation := getTokenReservation(ctx)
 	defer clearTokenReservation(ctx)
 
+	// the spend cap of a managed api key must hold before the usage is
+	// known, an unsettled reservation is refunded
+	spend, err := reserveAPIKeySpend(gmw.Ctx(ctx), user,
+		frontendReq.PromptTokens()+int(frontendReq.MaxTokens))
+	if web.AbortErr(ctx, err) {
+		return err
+	}
+	defer func() { _ = spend.Settle(gmw.Ctx(ctx), 0) }()
+
 	// If MCP is enabled (api keys present), skip cache to avoid persisting secrets.
 	cacheAllowed := !(config.Config != nil && config.Config.EnableMemory)
 
@@ -227,6 +256,9 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 		if cacheKey, err := req2CacheKey(frontendReq); err == nil {
 			if respContent, ok := llmRespCache.Load(cacheKey); ok {
 				finalText := respContent
+				if usage != nil {
+					usage.CompletionTokens = CountTextTokens(finalText)
+				}
 				if reservation != nil {
 					_ = reservation.Finalize(gmw.Ctx(ctx), CountTextTokens(finalText))
 				}
@@ -274,6 +306,9 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 			return extractErr
 		}
 		lastCalls = len(calls)
+		if usage != nil {
+			usage.ToolCalls += len(calls)
+		}
 
 		if len(calls) == 0 {
 			finalText = extractOutputTextFromResponses(resp)
@@ -355,6 +390,13 @@ func sendChatWithResponsesToolLoop(ctx *gin.Context) error {
 	if reservation != nil {
 		_ = reservation.Finalize(gmw.Ctx(ctx), CountTextTokens(finalText))
 	}
+	completionTokens := CountTextTokens(finalText)
+	chargeChatCredit(gmw.Ctx(ctx), user, spend, frontendReq.PromptTokens()+completionTokens)
+	if usage != nil {
+		usage.CompletionTokens = completionTokens
+		usage.ReasoningTokens = CountTextTokens(fullReasoning)
+		usage.Cost = chatTokensCost(usage.PromptTokens + completionTokens)
+	}
 
 	// Save to cache and audit log.
 	if cacheAllowed && frontendReq != nil && len(frontendReq.Messages) > 0 {
@@ -674,7 +716,7 @@ func convert2UpstreamResponsesRequest(ctx *gin.Context) (*FrontendReq, *config.U
 	frontendReq := &FrontendReq{}
 	requestMemoryEnabled := true
 	if gutils.Contains([]string{http.MethodPost, http.MethodPut}, ctx.Request.Method) {
-		frontendReq, err = bodyChecker(ctx.Request.Body)
+		frontendReq, err = requestFrontendReq(ctx)
 		if err != nil {
 			return nil, nil, nil, errors.Wrap(err, "request is illegal")
 		}
@@ -693,11 +735,11 @@ func convert2UpstreamResponsesRequest(ctx *gin.Context) (*FrontendReq, *config.U
 		if frontendReq.LaiskyExtra != nil &&
 			frontendReq.LaiskyExtra.ChatSwitch.EnableGoogleSearch {
 			if user.IsFree {
-				ratelimitCost := gconfig.Shared.GetInt("openai.rate_limit_expensive_models_interval_secs")
-				if !expensiveModelRateLimiter.AllowN(ratelimitCost) {
-					return nil, nil, nil, errors.New("web search is limited for free users" +
-						"you need upgrade to a paid membership to enable this feature unlimitedly, " +
-						"more info at https://wiki.laisky.com/projects/gpt/pay/")
+				if err := checkModelRateLimit(ctx, user, rateLimitModelWebSearch,
+					"web search is limited for free users, please try again in %d seconds. "+
+						"you need upgrade to a paid membership to enable this feature unlimitedly, "+
+						"more info at https://wiki.laisky.com/projects/gpt/pay/"); err != nil {
+					return nil, nil, nil, errors.WithStack(err)
 				}
 			}
 			frontendReq.embeddingGoogleSearch(ctx, user)
@@ -723,6 +765,10 @@ func convert2UpstreamResponsesRequest(ctx *gin.Context) (*FrontendReq, *config.U
 			return nil, nil, nil, errors.Wrapf(err, "check is model allowed for user %q", user.UserName)
 		}
 
+		if err := moderateFrontendReq(ctx, frontendReq); err != nil {
+			return nil, nil, nil, errors.Wrap(err, "moderate request")
+		}
+
 		if frontendReq != nil && len(frontendReq.Messages) > 0 {
 			reservation, reserveErr := ReserveTokens(ctx, user, frontendReq)
 			if reserveErr != nil {
@@ -51,27 +51,36 @@ type TranscriptionResponse struct {
 
 // Transcript transcribe audio to text
 func Transcript(ctx context.Context, user *config.UserConfig, req *TranscriptRequest) (respData *TranscriptionResponse, err error) {
+	return transcriptByOpenai(ctx, user, req.File, "file.wav", req.Model, "")
+}
+
+// transcriptByOpenai calls the OpenAI-compatible `/v1/audio/transcriptions`
+// of user's api base.
+func transcriptByOpenai(ctx context.Context, user *config.UserConfig,
+	audio io.Reader, filename, model, language string) (respData *TranscriptionResponse, err error) {
 	logger := gmw.GetLogger(ctx)
-	// if err := checkUserExternalBilling(ctx, user, db.PriceTTS, "tts"); err != nil {
-	// 	return nil, errors.Wrap(err, "check user external billing")
-	// }
 
 	upstreamUrl := fmt.Sprintf("%s/v1/audio/transcriptions", user.APIBase)
 	var requestBody bytes.Buffer
 	multiPartWriter := multipart.NewWriter(&requestBody)
 
-	fileWriter, err := multiPartWriter.CreateFormFile("file", "file.wav")
+	fileWriter, err := multiPartWriter.CreateFormFile("file", filename)
 	if err != nil {
 		return nil, errors.Wrap(err, "create form field file")
 	}
 
-	if _, err = io.Copy(fileWriter, req.File); err != nil {
+	if _, err = io.Copy(fileWriter, audio); err != nil {
 		return nil, errors.Wrap(err, "copy file")
 	}
 
-	if err = multiPartWriter.WriteField("model", req.Model); err != nil {
+	if err = multiPartWriter.WriteField("model", model); err != nil {
 		return nil, errors.Wrap(err, "write field model")
 	}
+	if language != "" {
+		if err = multiPartWriter.WriteField("language", language); err != nil {
+			return nil, errors.Wrap(err, "write field language")
+		}
+	}
 	if err = multiPartWriter.WriteField("response_format", "verbose_json"); err != nil {
 		return nil, errors.Wrap(err, "write field response_format")
 	}
@@ -2,19 +2,17 @@ package http
 
 import (
 	"context"
-	"fmt"
 	"io"
+	"net/http"
 	"net/url"
-	"os"
 	"regexp"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/Laisky/errors/v2"
 	gmw "github.com/Laisky/gin-middlewares/v7"
 	gutils "github.com/Laisky/go-utils/v6"
 	"github.com/Laisky/zap"
-	"github.com/Microsoft/cognitive-services-speech-sdk-go/audio"
-	"github.com/Microsoft/cognitive-services-speech-sdk-go/speech"
 	"github.com/gin-gonic/gin"
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
@@ -23,20 +21,25 @@ import (
 	"github.com/Laisky/go-ramjet/library/web"
 )
 
-// TTSHanler text to speech by azure, will return audio stream
-func TTSHanler(ctx *gin.Context) {
-	if config.Config.Azure.TTSKey == "" || config.Config.Azure.TTSRegion == "" {
-		web.AbortErr(ctx, fmt.Errorf("azure tts key or region is empty"))
-		return
-	}
+// chargeVoice bills one voice call. It is a variable so tests can run the
+// handlers without the external billing api.
+var chargeVoice = func(ctx context.Context, user *config.UserConfig, reason string) error {
+	return checkUserExternalBilling(ctx, user, db.PriceTTS, reason)
+}
 
+// TTSHanler text to speech, will stream the audio.
+//
+// Query params: `text` (required), and optional `provider`, `voice`,
+// `model`, `format`, `speed`, `language` that override the user's voice
+// preferences. Long text in a streamable format is synthesized and flushed
+// chunk by chunk, each chunk billed as one TTS call.
+func TTSHanler(ctx *gin.Context) {
 	user, err := getUserByToken(ctx, ctx.Query("apikey"))
 	if web.AbortErr(ctx, errors.Wrap(err, "get user by auth header")) {
 		return
 	}
 
-	if err = IsModelAllowed(ctx.Request.Context(),
-		user, &FrontendReq{Model: "tts"}); web.AbortErr(ctx,
+	if err = IsModelAllowed(ctx, user, &FrontendReq{Model: "tts"}); web.AbortErr(ctx,
 		errors.Wrap(err, "check model allowed")) {
 		return
 	}
@@ -45,115 +48,170 @@ func TTSHanler(ctx *gin.Context) {
 	if web.AbortErr(ctx, errors.Wrap(err, "url.QueryUnescape")) {
 		return
 	}
-
-	logger := gmw.GetLogger(ctx)
-	azureTTSConfig, err := speech.NewSpeechConfigFromSubscription(config.Config.Azure.TTSKey, config.Config.Azure.TTSRegion)
-	if web.AbortErr(ctx, errors.Wrap(err, "new speech config")) {
+	if strings.TrimSpace(text) == "" {
+		web.AbortErr(ctx, errors.New("text is empty"))
 		return
 	}
-	defer azureTTSConfig.Close()
 
-	// if err = azureTTSConfig.SetSpeechSynthesisVoiceName("en-US-SaraNeural"); web.AbortErr(ctx, errors.Wrap(err, "set voice name")) {
-	// 	return
-	// }
+	var speed float64
+	if v := ctx.Query("speed"); v != "" {
+		if speed, err = strconv.ParseFloat(v, 64); web.AbortErr(ctx, errors.Wrap(err, "parse speed")) {
+			return
+		}
+	}
 
-	audioCfg, err := audio.NewAudioConfigFromDefaultSpeakerOutput()
-	if web.AbortErr(ctx, errors.Wrap(err, "new audio config")) {
+	provider, err := resolveTTSProvider(user, ctx.Query("provider"))
+	if web.AbortErr(ctx, errors.Wrap(err, "resolve tts provider")) {
 		return
 	}
-
-	speechSynthesizer, err := speech.NewSpeechSynthesizerFromConfig(azureTTSConfig, audioCfg)
-	if web.AbortErr(ctx, errors.Wrap(err, "new speech synthesizer")) {
+	req, err := newSpeechRequest(provider, user, SpeechRequest{
+		Text:     text,
+		Voice:    ctx.Query("voice"),
+		Model:    ctx.Query("model"),
+		Format:   ctx.Query("format"),
+		Speed:    speed,
+		Language: ctx.Query("language"),
+	})
+	if web.AbortErr(ctx, errors.Wrap(err, "new speech request")) {
 		return
 	}
-	defer speechSynthesizer.Close()
 
-	speechSynthesizer.SynthesisStarted(func(event speech.SpeechSynthesisEventArgs) {
-		defer event.Close()
-		logger.Debug("synthesis started")
-	})
-	speechSynthesizer.Synthesizing(func(event speech.SpeechSynthesisEventArgs) {
-		defer event.Close()
-	})
-	speechSynthesizer.SynthesisCompleted(func(event speech.SpeechSynthesisEventArgs) {
-		defer event.Close()
-		logger.Debug("synthesis completed")
-	})
-	speechSynthesizer.SynthesisCanceled(func(event speech.SpeechSynthesisEventArgs) {
-		defer event.Close()
-		logger.Debug("synthesis canceled")
-	})
+	chunks := []string{text}
+	if streamableAudioFormats[req.Format] {
+		chunks = splitSpeechText(text, speechChunkRunes)
+	}
 
-	if err := checkUserExternalBilling(gmw.Ctx(ctx), user, db.PriceTTS, "tts"); web.AbortErr(ctx, errors.Wrap(err, "check user external billing")) {
+	logger := gmw.GetLogger(ctx).With(
+		zap.String("provider", provider.Name()),
+		zap.String("format", req.Format),
+		zap.Int("chunks", len(chunks)))
+	nBytes, err := streamSpeech(gmw.Ctx(ctx), ctx, provider, user, req, chunks)
+	if err != nil {
+		if nBytes == 0 {
+			web.AbortErr(ctx, err)
+			return
+		}
+
+		// the response has started, so the client gets truncated audio
+		logger.Error("tts stream interrupted", zap.Int64("sent", nBytes), zap.Error(err))
 		return
 	}
 
-	ssml, err := generateSSML(gmw.Ctx(ctx), user, text)
-	if err != nil {
-		logger.Warn("failed to generate ssml by llm", zap.Error(err))
-		// use default ssml
-		ssml = fmt.Sprintf(`<speak xmlns="http://www.w3.org/2001/10/synthesis" xmlns:mstts="http://www.w3.org/2001/mstts"
-			xmlns:emo="http://www.w3.org/2009/10/emotionml" version="1.0" xml:lang="zh-CN">
-			<voice name="zh-CN-XiaoxiaoNeural">
-				<mstts:express-as style="gentle">%s</mstts:express-as>
-			</voice>
-		</speak>`, text)
+	logger.Info("tts audio succeed",
+		zap.Int("text_len", len(text)),
+		zap.String("audio_size", gutils.HumanReadableByteCount(nBytes, true)))
+}
+
+// streamSpeech synthesizes chunks one after another and flushes each to
+// the client as soon as it arrives. It returns the bytes written, so the
+// caller knows whether the error can still be reported as a response.
+func streamSpeech(ctx context.Context, gctx *gin.Context,
+	provider TTSProvider, user *config.UserConfig, req *SpeechRequest, chunks []string) (nBytes int64, err error) {
+	for i, chunk := range chunks {
+		if err = chargeVoice(ctx, user, "tts"); err != nil {
+			return nBytes, errors.Wrap(err, "check user external billing")
+		}
+
+		chunkReq := *req
+		chunkReq.Text = chunk
+		audio, err := provider.Synthesize(ctx, user, &chunkReq)
+		if err != nil {
+			return nBytes, errors.Wrapf(err, "synthesize chunk %d", i)
+		}
+
+		if nBytes == 0 {
+			gctx.Header("Content-Type", audioContentType(req.Format))
+		}
+		n, err := io.Copy(gctx.Writer, audio)
+		gutils.CloseWithLog(audio, gmw.GetLogger(ctx))
+		nBytes += n
+		if err != nil {
+			return nBytes, errors.Wrapf(err, "copy chunk %d", i)
+		}
+		gctx.Writer.Flush()
 	}
 
-	task := speechSynthesizer.StartSpeakingSsmlAsync(ssml)
-	// task := speechSynthesizer.StartSpeakingTextAsync(req.Text)
-	var outcome speech.SpeechSynthesisOutcome
-	select {
-	case outcome = <-task:
-	case <-time.After(60 * time.Second):
-		web.AbortErr(ctx, errors.New("timeout for speech synthesis"))
-		return
+	if nBytes < 1 {
+		return 0, errors.New("failed to generate audio")
 	}
-	defer outcome.Close()
-	if web.AbortErr(ctx, errors.Wrap(outcome.Error, "outcome")) {
+	return nBytes, nil
+}
+
+// TranscriptHandler speech to text.
+//
+// Multipart form: `file` (required), and optional `provider`, `model`,
+// `language` that override the user's voice preferences.
+func TranscriptHandler(ctx *gin.Context) {
+	user, err := getUserByAuthHeader(ctx)
+	if web.AbortErr(ctx, errors.Wrap(err, "get user by auth header")) {
 		return
 	}
 
-	// in most case we want to streaming receive the audio to lower the latency,
-	// we can use AudioDataStream to do so.)
-	stream, err := speech.NewAudioDataStreamFromSpeechSynthesisResult(outcome.Result)
-	if web.AbortErr(ctx, errors.Wrap(err, "new audio data stream")) {
+	if err = IsModelAllowed(ctx, user, &FrontendReq{Model: "tts"}); web.AbortErr(ctx,
+		errors.Wrap(err, "check model allowed")) {
 		return
 	}
-	defer stream.Close()
 
-	if outcome.Failed() {
-		web.AbortErr(ctx, errors.Errorf("speech synthesis failed: %s", outcome.Error))
+	file, err := ctx.FormFile("file")
+	if web.AbortErr(ctx, errors.Wrap(err, "get file from form")) {
+		return
+	}
+	if file.Size > int64(config.Config.LimitUploadFileBytes) {
+		web.AbortErr(ctx, errors.Errorf("file size should not exceed %d bytes",
+			config.Config.LimitUploadFileBytes))
 		return
 	}
 
-	// Create a temporary file with a .wav extension in the default temporary directory
-	tempFp, err := os.CreateTemp("", "tts-*.wav")
-	if web.AbortErr(ctx, errors.Wrap(err, "create temp file")) {
+	provider, err := resolveSTTProvider(user, ctx.PostForm("provider"))
+	if web.AbortErr(ctx, errors.Wrap(err, "resolve stt provider")) {
 		return
 	}
-	defer os.Remove(tempFp.Name()) // Ensure the temporary file is removed after use
-	defer tempFp.Close()
 
-	// Use the temporary file's name (path) for saving
-	if err = <-stream.SaveToWavFileAsync(tempFp.Name()); web.AbortErr(ctx, errors.Wrap(err, "save to wav file")) {
+	audio, err := file.Open()
+	if web.AbortErr(ctx, errors.Wrap(err, "open file")) {
 		return
 	}
+	defer gutils.CloseWithLog(audio, gmw.GetLogger(ctx))
 
-	ctx.Header("Content-Type", "audio/wav")
-	nBytes, err := io.Copy(ctx.Writer, tempFp)
-	if web.AbortErr(ctx, errors.Wrap(err, "copy stream")) {
+	if err = chargeVoice(gmw.Ctx(ctx), user, "stt"); web.AbortErr(ctx,
+		errors.Wrap(err, "check user external billing")) {
 		return
 	}
 
-	if nBytes < 1 {
-		web.AbortErr(ctx, errors.New("failed to generate audio"))
+	model := ctx.PostForm("model")
+	language := ctx.PostForm("language")
+	result, err := provider.Transcribe(gmw.Ctx(ctx), user, &TranscribeRequest{
+		Audio:    audio,
+		Filename: file.Filename,
+		Model:    gutils.OptionalVal(&model, user.Voice.STTModel),
+		Language: gutils.OptionalVal(&language, user.Voice.Language),
+	})
+	if web.AbortErr(ctx, errors.Wrapf(err, "transcribe by %s", provider.Name())) {
 		return
 	}
+	result.Provider = provider.Name()
 
-	logger.Info("tts audio succeed",
-		zap.Int("text_len", len(text)),
-		zap.String("audio_size", gutils.HumanReadableByteCount(nBytes, true)))
+	ctx.JSON(http.StatusOK, result)
+}
+
+// ListVoiceProvidersHandler lists the registered voice providers.
+func ListVoiceProvidersHandler(ctx *gin.Context) {
+	var ttsList []gin.H
+	tts, stt := ListVoiceProviders()
+	for _, name := range tts {
+		voiceProvidersMu.RLock()
+		p := ttsProviders[name]
+		voiceProvidersMu.RUnlock()
+		ttsList = append(ttsList, gin.H{
+			"name":    name,
+			"formats": p.Formats(),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"tts": ttsList,
+		"stt": stt,
+	})
 }
 
 var ssmlRegexp = regexp.MustCompile(`(?ims)(<speak.*</speak>)`)
@@ -0,0 +1,632 @@
+package http
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+const (
+	usageEventCol = "usage_events"
+	usageDailyCol = "usage_daily"
+	// usageDayLayout formats the UTC days of rollups and queries.
+	usageDayLayout = "2006-01-02"
+	// usageMaxRangeDays caps the days of one usage query.
+	usageMaxRangeDays = 366
+	// usageDefaultRangeDays is the range of a query without `from`.
+	usageDefaultRangeDays = 30
+	// usageSaveTimeout bounds the write of one usage event.
+	usageSaveTimeout = 10 * time.Second
+	// ctxKeyUsage holds the *db.UsageEvent of the running chat request.
+	ctxKeyUsage = "ctx_usage_event"
+)
+
+// Usage query scopes.
+const (
+	usageScopeSelf = "self"
+	usageScopeOrg  = "org"
+	usageScopeAll  = "all"
+)
+
+// usageAccessError rejects usage queries beyond the user's scope.
+type usageAccessError struct{ msg string }
+
+func (e *usageAccessError) Error() string {
+	return e.msg
+}
+
+// HTTPStatus returns 403.
+func (e *usageAccessError) HTTPStatus() int {
+	return http.StatusForbidden
+}
+
+// usageQuery selects daily rollups.
+type usageQuery struct {
+	// From and To are inclusive UTC days formatted by usageDayLayout
+	From, To string
+	// Usernames (optional) matches any of these users
+	Usernames []string
+	// OrgID (optional) matches the usage recorded in this organization
+	OrgID string
+	// Model (optional) matches one model
+	Model string
+}
+
+// usageStore keeps usage events and their daily rollups.
+type usageStore interface {
+	// InsertEvent records one usage event.
+	InsertEvent(ctx context.Context, ev *db.UsageEvent) error
+	// EachEvent calls fn for every event created in [from, to).
+	EachEvent(ctx context.Context, from, to time.Time, fn func(*db.UsageEvent) error) error
+	// SaveDaily replaces the rollups with the same ID.
+	SaveDaily(ctx context.Context, rows []db.UsageDaily) error
+	// Daily returns the rollups matching q, by day, username and model.
+	Daily(ctx context.Context, q usageQuery) ([]db.UsageDaily, error)
+}
+
+var (
+	mongoUsageStoreOnce sync.Once
+	mongoUsageStoreIns  *mongoUsageStore
+	mongoUsageStoreErr  error
+)
+
+// newUsageStore returns the usage store in use. It is a variable so tests
+// can replace mongo.
+var newUsageStore = func() (usageStore, error) {
+	mongoUsageStoreOnce.Do(func() {
+		mongoUsageStoreIns, mongoUsageStoreErr = newMongoUsageStore()
+	})
+	return mongoUsageStoreIns, mongoUsageStoreErr
+}
+
+type mongoUsageStore struct {
+	events *mongo.Collection
+	daily  *mongo.Collection
+}
+
+//nolint:contextcheck // indexes are created once, detached from any request
+func newMongoUsageStore() (*mongoUsageStore, error) {
+	openaiDB, err := db.GetOpenaiDB()
+	if err != nil {
+		return nil, errors.Wrap(err, "get openai db")
+	}
+
+	s := &mongoUsageStore{
+		events: openaiDB.GetCol(usageEventCol),
+		daily:  openaiDB.GetCol(usageDailyCol),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	retention := time.Duration(config.Config.UsageAnalytics.EventRetentionDays) * 24 * time.Hour
+	if _, err = s.events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+	}); err != nil {
+		return nil, errors.Wrap(err, "create usage event indexes")
+	}
+	if _, err = s.daily.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "day", Value: 1}}},
+		{Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "day", Value: 1}}},
+		{Keys: bson.D{{Key: "day", Value: 1}}},
+	}); err != nil {
+		return nil, errors.Wrap(err, "create usage daily indexes")
+	}
+
+	return s, nil
+}
+
+// InsertEvent implements usageStore.
+func (s *mongoUsageStore) InsertEvent(ctx context.Context, ev *db.UsageEvent) error {
+	_, err := s.events.InsertOne(ctx, ev)
+	return errors.Wrap(err, "insert usage event")
+}
+
+// EachEvent implements usageStore.
+func (s *mongoUsageStore) EachEvent(ctx context.Context,
+	from, to time.Time, fn func(*db.UsageEvent) error) error {
+	cur, err := s.events.Find(ctx, bson.M{"created_at": bson.M{"$gte": from, "$lt": to}})
+	if err != nil {
+		return errors.Wrap(err, "find usage events")
+	}
+	defer cur.Close(ctx) // nolint: errcheck
+
+	for cur.Next(ctx) {
+		ev := new(db.UsageEvent)
+		if err = cur.Decode(ev); err != nil {
+			return errors.Wrap(err, "decode usage event")
+		}
+		if err = fn(ev); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(cur.Err(), "iterate usage events")
+}
+
+// SaveDaily implements usageStore.
+func (s *mongoUsageStore) SaveDaily(ctx context.Context, rows []db.UsageDaily) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(rows))
+	for i := range rows {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": rows[i].ID}).
+			SetReplacement(rows[i]).
+			SetUpsert(true))
+	}
+	_, err := s.daily.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return errors.Wrap(err, "save usage rollups")
+}
+
+// Daily implements usageStore.
+func (s *mongoUsageStore) Daily(ctx context.Context, q usageQuery) ([]db.UsageDaily, error) {
+	filter := bson.M{"day": bson.M{"$gte": q.From, "$lte": q.To}}
+	if len(q.Usernames) != 0 {
+		filter["username"] = bson.M{"$in": q.Usernames}
+	}
+	if q.OrgID != "" {
+		filter["org_id"] = q.OrgID
+	}
+	if q.Model != "" {
+		filter["model"] = q.Model
+	}
+
+	cur, err := s.daily.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "day", Value: 1}, {Key: "username", Value: 1}, {Key: "model", Value: 1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "find usage rollups")
+	}
+
+	var rows []db.UsageDaily
+	if err = cur.All(ctx, &rows); err != nil {
+		return nil, errors.Wrap(err, "decode usage rollups")
+	}
+	return rows, nil
+}
+
+// usageEnabled reports whether usage analytics is on.
+func usageEnabled() bool {
+	return config.Config != nil && config.Config.UsageAnalytics.Enabled
+}
+
+// startUsage attaches a usage event of kind chat to ctx, nil if usage
+// analytics is off. The chat flow fills it in through usageFromCtx.
+func startUsage(ctx *gin.Context) *db.UsageEvent {
+	if !usageEnabled() {
+		return nil
+	}
+	ev := &db.UsageEvent{Kind: db.UsageKindChat}
+	ctx.Set(ctxKeyUsage, ev)
+	return ev
+}
+
+// usageFromCtx returns the usage event of the running request, nil if none.
+func usageFromCtx(ctx *gin.Context) *db.UsageEvent {
+	if v, ok := ctx.Get(ctxKeyUsage); ok {
+		if ev, ok := v.(*db.UsageEvent); ok {
+			return ev
+		}
+	}
+	return nil
+}
+
+// RecordAgentUsage fills the usage event of an agent run. The tokens sum
+// every model round of the run, zero prompt tokens keep the estimate of
+// the request.
+func RecordAgentUsage(ctx *gin.Context, promptTokens, completionTokens, reasoningTokens, toolCalls int) {
+	ev := usageFromCtx(ctx)
+	if ev == nil {
+		return
+	}
+
+	if promptTokens > 0 {
+		ev.PromptTokens = promptTokens
+	}
+	ev.CompletionTokens = completionTokens
+	ev.ReasoningTokens = reasoningTokens
+	ev.ToolCalls = toolCalls
+	ev.Cost = chatTokensCost(ev.PromptTokens + ev.CompletionTokens)
+}
+
+// finishUsage records ev in the background once the request is done.
+// Requests that never reached a model, e.g. rejected by authentication,
+// are not recorded.
+func finishUsage(ctx *gin.Context, ev *db.UsageEvent, reqErr error) {
+	if ev == nil || ev.Model == "" {
+		return
+	}
+	user, ok := ctx.Get(ctxKeyUser)
+	if !ok {
+		return
+	}
+	u, ok := user.(*config.UserConfig)
+	if !ok || u == nil {
+		return
+	}
+
+	ev.Username = u.UserName
+	ev.OrgID = u.OrgID
+	ev.Error = reqErr != nil || ctx.Writer.Status() >= http.StatusBadRequest
+	ev.CreatedAt = time.Now().UTC()
+	go saveUsageEvent(ev)
+}
+
+// saveUsageEvent writes ev to the usage store.
+func saveUsageEvent(ev *db.UsageEvent) {
+	logger := log.Logger.Named("save_usage")
+	store, err := newUsageStore()
+	if err != nil {
+		logger.Error("get usage store", zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), usageSaveTimeout)
+	defer cancel()
+	if err = store.InsertEvent(ctx, ev); err != nil {
+		logger.Error("save usage event", zap.String("user", ev.Username), zap.Error(err))
+	}
+}
+
+// usageDayStart returns the start of the UTC day of t.
+func usageDayStart(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// usageDailyID returns the rollup id of day, username and model.
+func usageDailyID(day, username, model string) string {
+	return day + "|" + username + "|" + model
+}
+
+// RollupUsage recomputes the daily rollups of the lookback days ending at
+// now, today included. Each day is rebuilt from its raw events, so running
+// it again is harmless and events saved late are picked up by the next run.
+func RollupUsage(ctx context.Context, now time.Time) error {
+	store, err := newUsageStore()
+	if err != nil {
+		return errors.Wrap(err, "get usage store")
+	}
+
+	lookback := max(config.Config.UsageAnalytics.LookbackDays, 1)
+	today := usageDayStart(now)
+	for i := lookback - 1; i >= 0; i-- {
+		from := today.AddDate(0, 0, -i)
+		rows, err := rollupUsageDay(ctx, store, from, now)
+		if err != nil {
+			return errors.Wrapf(err, "rollup usage of %s", from.Format(usageDayLayout))
+		}
+		if err = store.SaveDaily(ctx, rows); err != nil {
+			return errors.Wrapf(err, "save usage of %s", from.Format(usageDayLayout))
+		}
+	}
+
+	return nil
+}
+
+// rollupUsageDay sums the events of the day starting at from by user and
+// model.
+func rollupUsageDay(ctx context.Context, store usageStore, from, now time.Time) ([]db.UsageDaily, error) {
+	day := from.Format(usageDayLayout)
+	rows := map[string]*db.UsageDaily{}
+	latest := map[string]time.Time{}
+	err := store.EachEvent(ctx, from, from.AddDate(0, 0, 1), func(ev *db.UsageEvent) error {
+		id := usageDailyID(day, ev.Username, ev.Model)
+		row, ok := rows[id]
+		if !ok {
+			row = &db.UsageDaily{ID: id, Day: day, Username: ev.Username, Model: ev.Model}
+			rows[id] = row
+		}
+
+		// the organization of the latest request wins, events come in no
+		// particular order
+		if !ev.CreatedAt.Before(latest[id]) {
+			row.OrgID = ev.OrgID
+			latest[id] = ev.CreatedAt
+		}
+		row.Requests++
+		if ev.Error {
+			row.Errors++
+		}
+		row.PromptTokens += int64(ev.PromptTokens)
+		row.CompletionTokens += int64(ev.CompletionTokens)
+		row.ReasoningTokens += int64(ev.ReasoningTokens)
+		row.ToolCalls += int64(ev.ToolCalls)
+		row.Cost += ev.Cost
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "iterate usage events")
+	}
+
+	result := make([]db.UsageDaily, 0, len(rows))
+	for _, row := range rows {
+		row.UpdatedAt = now.UTC()
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// usagePoint is the usage of one day in a series.
+type usagePoint struct {
+	Day              string   `json:"day"`
+	Requests         int64    `json:"requests"`
+	Errors           int64    `json:"errors"`
+	ErrorRate        float64  `json:"error_rate"`
+	PromptTokens     int64    `json:"prompt_tokens"`
+	CompletionTokens int64    `json:"completion_tokens"`
+	ReasoningTokens  int64    `json:"reasoning_tokens"`
+	ToolCalls        int64    `json:"tool_calls"`
+	Cost             db.Price `json:"cost"`
+	CostUSD          float64  `json:"cost_usd"`
+}
+
+func (p *usagePoint) add(row *db.UsageDaily) {
+	p.Requests += row.Requests
+	p.Errors += row.Errors
+	p.PromptTokens += row.PromptTokens
+	p.CompletionTokens += row.CompletionTokens
+	p.ReasoningTokens += row.ReasoningTokens
+	p.ToolCalls += row.ToolCalls
+	p.Cost += row.Cost
+}
+
+func (p *usagePoint) finish() {
+	if p.Requests > 0 {
+		p.ErrorRate = float64(p.Errors) / float64(p.Requests)
+	}
+	p.CostUSD = usageUSD(p.Cost)
+}
+
+// usageSeries is the usage of one group over the queried days.
+type usageSeries struct {
+	Key    string        `json:"key"`
+	Points []*usagePoint `json:"points"`
+}
+
+// usageUSD converts cost to usd.
+func usageUSD(cost db.Price) float64 {
+	return float64(cost) / float64(db.PriceUSD)
+}
+
+// usageDays lists the days from..to, inclusive.
+func usageDays(from, to time.Time) []string {
+	var days []string
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format(usageDayLayout))
+	}
+	return days
+}
+
+// buildUsageSeries groups rows into one dense series per group, with a
+// point for every day. groupBy is `day` for a single total series,
+// `model` or `user`.
+func buildUsageSeries(rows []db.UsageDaily, days []string, groupBy string) (series []usageSeries, total usagePoint) {
+	dayIdx := make(map[string]int, len(days))
+	for i, d := range days {
+		dayIdx[d] = i
+	}
+
+	byKey := map[string][]*usagePoint{}
+	for i := range rows {
+		row := &rows[i]
+		idx, ok := dayIdx[row.Day]
+		if !ok {
+			continue
+		}
+
+		var key string
+		switch groupBy {
+		case "model":
+			key = row.Model
+		case "user":
+			key = row.Username
+		default:
+			key = "total"
+		}
+		points, ok := byKey[key]
+		if !ok {
+			points = make([]*usagePoint, len(days))
+			for j, d := range days {
+				points[j] = &usagePoint{Day: d}
+			}
+			byKey[key] = points
+		}
+		points[idx].add(row)
+		total.add(row)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	series = make([]usageSeries, 0, len(keys))
+	for _, k := range keys {
+		for _, p := range byKey[k] {
+			p.finish()
+		}
+		series = append(series, usageSeries{Key: k, Points: byKey[k]})
+	}
+	total.finish()
+	return series, total
+}
+
+// parseUsageRange reads the `from` and `to` days of a query, default to the
+// last usageDefaultRangeDays days ending today.
+func parseUsageRange(ctx *gin.Context, now time.Time) (from, to time.Time, err error) {
+	to = usageDayStart(now)
+	if raw := ctx.Query("to"); raw != "" {
+		if to, err = time.Parse(usageDayLayout, raw); err != nil {
+			return from, to, errors.Errorf("to should be formatted as %s", usageDayLayout)
+		}
+	}
+	from = to.AddDate(0, 0, 1-usageDefaultRangeDays)
+	if raw := ctx.Query("from"); raw != "" {
+		if from, err = time.Parse(usageDayLayout, raw); err != nil {
+			return from, to, errors.Errorf("from should be formatted as %s", usageDayLayout)
+		}
+	}
+
+	switch {
+	case from.After(to):
+		return from, to, errors.New("from should not be after to")
+	case to.Sub(from) >= usageMaxRangeDays*24*time.Hour:
+		return from, to, errors.Errorf("range should not exceed %d days", usageMaxRangeDays)
+	}
+	return from, to, nil
+}
+
+// usageQueryOf builds the rollup query of the request, checking that user
+// may see the requested scope.
+func usageQueryOf(ctx *gin.Context, from, to time.Time) (q usageQuery, err error) {
+	q = usageQuery{
+		From:  from.Format(usageDayLayout),
+		To:    to.Format(usageDayLayout),
+		Model: strings.TrimSpace(ctx.Query("model")),
+	}
+	username := strings.TrimSpace(ctx.Query("user"))
+
+	switch scope := ctx.DefaultQuery("scope", usageScopeSelf); scope {
+	case usageScopeSelf:
+		user, err := getUserByAuthHeader(ctx)
+		if err != nil {
+			return q, errors.Wrap(err, "get user by auth header")
+		}
+		if username != "" && username != user.UserName {
+			return q, &usageAccessError{msg: "use scope org or all to query other users"}
+		}
+		q.Usernames = []string{user.UserName}
+	case usageScopeOrg:
+		_, org, err := orgAdmin(ctx, config.OrgRoleAdmin)
+		if err != nil {
+			return q, err
+		}
+		q.OrgID = org.ID
+	case usageScopeAll:
+		user, err := getUserByAuthHeader(ctx)
+		if err != nil {
+			return q, errors.Wrap(err, "get user by auth header")
+		}
+		if user.APIKeyID != "" || !slices.Contains(config.Config.UsageAnalytics.Admins, user.UserName) {
+			return q, &usageAccessError{msg: "usage admin required"}
+		}
+	default:
+		return q, errors.Errorf("unknown scope %q", scope)
+	}
+
+	if username != "" {
+		q.Usernames = []string{username}
+	}
+	return q, nil
+}
+
+// GetUsageHandler returns the daily usage rollups of the current user, of
+// the organization it administers, or of everyone for usage admins.
+//
+// Query: from, to (YYYY-MM-DD, inclusive, UTC), scope (self|org|all),
+// user, model, group_by (day|model|user) and format (json|csv).
+func GetUsageHandler(ctx *gin.Context) {
+	if !usageEnabled() {
+		web.AbortErr(ctx, errors.New("usage analytics is disabled"))
+		return
+	}
+
+	from, to, err := parseUsageRange(ctx, time.Now())
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	q, err := usageQueryOf(ctx, from, to)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	groupBy := ctx.DefaultQuery("group_by", "day")
+	if !slices.Contains([]string{"day", "model", "user"}, groupBy) {
+		web.AbortErr(ctx, errors.Errorf("unknown group_by %q", groupBy))
+		return
+	}
+	format := ctx.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		web.AbortErr(ctx, errors.Errorf("unknown format %q", format))
+		return
+	}
+
+	store, err := newUsageStore()
+	if web.AbortErr(ctx, errors.Wrap(err, "get usage store")) {
+		return
+	}
+	rows, err := store.Daily(gmw.Ctx(ctx), q)
+	if web.AbortErr(ctx, errors.Wrap(err, "load usage")) {
+		return
+	}
+
+	if format == "csv" {
+		if err = writeUsageCSV(ctx, q, rows); err != nil {
+			gmw.GetLogger(ctx).Warn("write usage csv", zap.Error(err))
+		}
+		return
+	}
+
+	series, total := buildUsageSeries(rows, usageDays(from, to), groupBy)
+	ctx.JSON(http.StatusOK, gin.H{
+		"from":     q.From,
+		"to":       q.To,
+		"group_by": groupBy,
+		"series":   series,
+		"total":    total,
+	})
+}
+
+// writeUsageCSV writes rows as a csv attachment, one line per day, user and
+// model.
+func writeUsageCSV(ctx *gin.Context, q usageQuery, rows []db.UsageDaily) error {
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="usage-%s-%s.csv"`, q.From, q.To))
+	ctx.Status(http.StatusOK)
+
+	w := csv.NewWriter(ctx.Writer)
+	if err := w.Write([]string{
+		"day", "username", "org_id", "model", "requests", "errors",
+		"prompt_tokens", "completion_tokens", "reasoning_tokens", "tool_calls", "cost_usd",
+	}); err != nil {
+		return errors.Wrap(err, "write csv header")
+	}
+	for _, row := range rows {
+		if err := w.Write([]string{
+			row.Day, row.Username, row.OrgID, row.Model,
+			strconv.FormatInt(row.Requests, 10),
+			strconv.FormatInt(row.Errors, 10),
+			strconv.FormatInt(row.PromptTokens, 10),
+			strconv.FormatInt(row.CompletionTokens, 10),
+			strconv.FormatInt(row.ReasoningTokens, 10),
+			strconv.FormatInt(row.ToolCalls, 10),
+			strconv.FormatFloat(usageUSD(row.Cost), 'f', 6, 64),
+		}); err != nil {
+			return errors.Wrap(err, "write csv row")
+		}
+	}
+	w.Flush()
+	return errors.Wrap(w.Error(), "flush csv")
+}
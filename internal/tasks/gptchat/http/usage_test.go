@@ -0,0 +1,283 @@
+package http
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laisky/testify/require"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/db"
+)
+
+// memoryUsageStore is an in-memory usageStore.
+type memoryUsageStore struct {
+	mu      sync.Mutex
+	events  []db.UsageEvent
+	daily   map[string]db.UsageDaily
+	queries []usageQuery
+	saved   chan struct{}
+}
+
+func (s *memoryUsageStore) InsertEvent(_ context.Context, ev *db.UsageEvent) error {
+	s.mu.Lock()
+	s.events = append(s.events, *ev)
+	s.mu.Unlock()
+	if s.saved != nil {
+		s.saved <- struct{}{}
+	}
+	return nil
+}
+
+func (s *memoryUsageStore) EachEvent(_ context.Context, from, to time.Time, fn func(*db.UsageEvent) error) error {
+	s.mu.Lock()
+	events := slices.Clone(s.events)
+	s.mu.Unlock()
+	for i := range events {
+		if !events[i].CreatedAt.Before(from) && events[i].CreatedAt.Before(to) {
+			if err := fn(&events[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryUsageStore) SaveDaily(_ context.Context, rows []db.UsageDaily) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range rows {
+		s.daily[row.ID] = row
+	}
+	return nil
+}
+
+func (s *memoryUsageStore) Daily(_ context.Context, q usageQuery) ([]db.UsageDaily, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, q)
+	var rows []db.UsageDaily
+	for _, row := range s.daily {
+		if row.Day < q.From || row.Day > q.To ||
+			(len(q.Usernames) != 0 && !slices.Contains(q.Usernames, row.Username)) ||
+			(q.OrgID != "" && row.OrgID != q.OrgID) ||
+			(q.Model != "" && row.Model != q.Model) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	slices.SortFunc(rows, func(a, b db.UsageDaily) int { return strings.Compare(a.ID, b.ID) })
+	return rows, nil
+}
+
+// setupUsageStore enables usage analytics, and keeps the usage in memory.
+func setupUsageStore(t *testing.T) *memoryUsageStore {
+	t.Helper()
+	config.Config.UsageAnalytics = config.UsageAnalyticsConfig{
+		Enabled:      true,
+		LookbackDays: 2,
+		Admins:       []string{"carol"},
+	}
+
+	store := &memoryUsageStore{daily: map[string]db.UsageDaily{}}
+	originalStore := newUsageStore
+	newUsageStore = func() (usageStore, error) { return store, nil }
+	t.Cleanup(func() { newUsageStore = originalStore })
+	return store
+}
+
+func TestRollupUsage(t *testing.T) {
+	setupTestConfig()
+	store := setupUsageStore(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	store.events = []db.UsageEvent{
+		{Username: "alice", OrgID: "acme", Model: "gpt-4o", PromptTokens: 100, CompletionTokens: 50,
+			ToolCalls: 2, Cost: 150, CreatedAt: now.Add(-time.Hour)},
+		{Username: "alice", OrgID: "acme", Model: "gpt-4o", PromptTokens: 10, Error: true,
+			CreatedAt: now.Add(-2 * time.Hour)},
+		// an older request before alice joined acme comes last
+		{Username: "alice", Model: "gpt-4o", CreatedAt: now.Add(-3 * time.Hour)},
+		{Username: "alice", Model: "gpt-5", ReasoningTokens: 7, CreatedAt: now.Add(-24 * time.Hour)},
+		// outside the lookback
+		{Username: "bob", Model: "gpt-4o", CreatedAt: now.Add(-72 * time.Hour)},
+	}
+
+	require.NoError(t, RollupUsage(context.Background(), now))
+	require.NoError(t, RollupUsage(context.Background(), now), "rollups are idempotent")
+	require.Len(t, store.daily, 2)
+
+	row := store.daily["2026-10-18|alice|gpt-4o"]
+	require.Equal(t, "2026-10-18", row.Day)
+	require.Equal(t, "acme", row.OrgID)
+	require.EqualValues(t, 3, row.Requests)
+	require.EqualValues(t, 1, row.Errors)
+	require.EqualValues(t, 110, row.PromptTokens)
+	require.EqualValues(t, 50, row.CompletionTokens)
+	require.EqualValues(t, 2, row.ToolCalls)
+	require.EqualValues(t, 150, row.Cost)
+	require.Equal(t, now, row.UpdatedAt)
+
+	require.EqualValues(t, 7, store.daily["2026-10-17|alice|gpt-5"].ReasoningTokens)
+}
+
+func TestRecordAgentUsage(t *testing.T) {
+	setupPaidUsers(t)
+	setupUsageStore(t)
+	ctx := newAuthContext(testPaidToken)
+	RecordAgentUsage(ctx, 100, 10, 0, 1)
+
+	ev := startUsage(ctx)
+	ev.PromptTokens = 12
+	RecordAgentUsage(ctx, 0, 40, 5, 2)
+	require.Equal(t, 12, ev.PromptTokens, "keeps the estimate without upstream usage")
+	require.Equal(t, 40, ev.CompletionTokens)
+	require.Equal(t, 5, ev.ReasoningTokens)
+	require.Equal(t, 2, ev.ToolCalls)
+	require.Equal(t, chatTokensCost(52), ev.Cost)
+
+	RecordAgentUsage(ctx, 3000, 40, 0, 2)
+	require.Equal(t, 3000, ev.PromptTokens, "every round's prompt counts")
+	require.Equal(t, chatTokensCost(3040), ev.Cost)
+}
+
+func TestBuildUsageSeries(t *testing.T) {
+	rows := []db.UsageDaily{
+		{Day: "2026-10-16", Username: "alice", Model: "gpt-4o", Requests: 4, Errors: 1, Cost: db.PriceUSD},
+		{Day: "2026-10-18", Username: "bob", Model: "gpt-4o", Requests: 1},
+		{Day: "2026-10-18", Username: "alice", Model: "gpt-5", Requests: 3, PromptTokens: 9},
+	}
+	days := usageDays(time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, []string{"2026-10-16", "2026-10-17", "2026-10-18"}, days)
+
+	series, total := buildUsageSeries(rows, days, "day")
+	require.Len(t, series, 1)
+	require.Equal(t, "total", series[0].Key)
+	require.Len(t, series[0].Points, 3, "days without usage are filled")
+	require.InDelta(t, 0.25, series[0].Points[0].ErrorRate, 1e-9)
+	require.InDelta(t, 1, series[0].Points[0].CostUSD, 1e-9)
+	require.Zero(t, series[0].Points[1].Requests)
+	require.EqualValues(t, 4, series[0].Points[2].Requests)
+	require.EqualValues(t, 8, total.Requests)
+	require.InDelta(t, 0.125, total.ErrorRate, 1e-9)
+
+	series, _ = buildUsageSeries(rows, days, "model")
+	require.Equal(t, "gpt-4o", series[0].Key)
+	require.Equal(t, "gpt-5", series[1].Key)
+	require.EqualValues(t, 1, series[0].Points[2].Requests)
+
+	series, _ = buildUsageSeries(rows, days, "user")
+	require.Equal(t, "alice", series[0].Key)
+	require.EqualValues(t, 9, series[0].Points[2].PromptTokens)
+}
+
+// newUsageRouter serves the usage route.
+func newUsageRouter() *gin.Engine {
+	router := gin.New()
+	router.GET("/user/usage", GetUsageHandler)
+	return router
+}
+
+func TestGetUsageHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupPaidUsers(t)
+	setupOrganizations(t)
+	store := setupUsageStore(t)
+	router := newUsageRouter()
+	require.NoError(t, store.SaveDaily(context.Background(), []db.UsageDaily{
+		{ID: "2026-10-01|alice|gpt-4o", Day: "2026-10-01", Username: "alice", OrgID: "acme", Model: "gpt-4o",
+			Requests: 2, PromptTokens: 30, Cost: db.PriceUSD / 2},
+		{ID: "2026-10-02|bob|gpt-4o", Day: "2026-10-02", Username: "bob", Model: "gpt-4o", Requests: 5},
+	}))
+
+	resp, payload := testRequest(t, router, http.MethodGet, "/user/usage?from=2026-10-01&to=2026-10-02", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, []string{"alice"}, store.queries[0].Usernames)
+	require.EqualValues(t, 2, payload["total"].(map[string]any)["requests"])
+	require.Len(t, payload["series"].([]any)[0].(map[string]any)["points"], 2)
+
+	// users only see themselves, org admins their organization
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/usage?user=bob", testPaidToken, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/usage?scope=org", testBobToken, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	resp, _ = testRequest(t, router, http.MethodGet,
+		"/user/usage?scope=org&from=2026-10-01&to=2026-10-02", testPaidToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Equal(t, "acme", store.queries[len(store.queries)-1].OrgID)
+
+	// usage admins see everyone
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/usage?scope=all", testPaidToken, "")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+	resp, payload = testRequest(t, router, http.MethodGet,
+		"/user/usage?scope=all&group_by=user&from=2026-10-01&to=2026-10-02", testCarolToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Len(t, payload["series"], 2)
+
+	resp, _ = testRequest(t, router, http.MethodGet,
+		"/user/usage?scope=all&format=csv&from=2026-10-01&to=2026-10-02", testCarolToken, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Contains(t, resp.Header().Get("Content-Disposition"), "usage-2026-10-01-2026-10-02.csv")
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, "day", records[0][0])
+	require.Equal(t, []string{"2026-10-01", "alice", "acme", "gpt-4o", "2", "0", "30", "0", "0", "0", "0.500000"},
+		records[1])
+
+	for _, path := range []string{
+		"/user/usage?from=2026-10-05&to=2026-10-01",
+		"/user/usage?from=2024-01-01&to=2026-10-01",
+		"/user/usage?from=yesterday",
+		"/user/usage?group_by=week",
+		"/user/usage?format=xml",
+		"/user/usage?scope=world",
+	} {
+		resp, _ = testRequest(t, router, http.MethodGet, path, testPaidToken, "")
+		require.Equal(t, http.StatusBadRequest, resp.Code, path)
+	}
+
+	config.Config.UsageAnalytics.Enabled = false
+	resp, _ = testRequest(t, router, http.MethodGet, "/user/usage", testPaidToken, "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestFinishUsage(t *testing.T) {
+	setupPaidUsers(t)
+	setupOrganizations(t)
+	store := setupUsageStore(t)
+	store.saved = make(chan struct{}, 1)
+
+	ctx := newAuthContext(testPaidToken)
+	ev := startUsage(ctx)
+	require.Same(t, ev, usageFromCtx(ctx))
+
+	// not recorded before reaching a model
+	finishUsage(ctx, ev, nil)
+	_, err := getUserByAuthHeader(ctx)
+	require.NoError(t, err)
+	ev.Model = "gpt-4o"
+	ev.PromptTokens = 12
+	finishUsage(ctx, ev, context.Canceled)
+
+	select {
+	case <-store.saved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("usage event not saved")
+	}
+	require.Len(t, store.events, 1)
+	require.Equal(t, "alice", store.events[0].Username)
+	require.Equal(t, "acme", store.events[0].OrgID)
+	require.Equal(t, db.UsageKindChat, store.events[0].Kind)
+	require.True(t, store.events[0].Error)
+	require.False(t, store.events[0].CreatedAt.IsZero())
+
+	config.Config.UsageAnalytics.Enabled = false
+	require.Nil(t, startUsage(newAuthContext(testPaidToken)))
+}
@@ -6,16 +6,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"io"
-	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/Laisky/errors/v2"
 	gmw "github.com/Laisky/gin-middlewares/v7"
-	gconfig "github.com/Laisky/go-config/v2"
 	gutils "github.com/Laisky/go-utils/v6"
 	gcompress "github.com/Laisky/go-utils/v6/compress"
 	gcrypto "github.com/Laisky/go-utils/v6/crypto"
@@ -26,21 +24,13 @@ import (
 
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/s3"
-	"github.com/Laisky/go-ramjet/library/log"
-	rlimiter "github.com/Laisky/go-ramjet/library/ratelimit"
 	s3lib "github.com/Laisky/go-ramjet/library/s3"
 	"github.com/Laisky/go-ramjet/library/web"
 )
 
-var (
-	onceLimiter                                     sync.Once
-	freeModelRateLimiter, expensiveModelRateLimiter rlimiter.Limiter
-)
-
 const (
-	freeModelRateLimitCost = 3
-	freeUserMaxTokens      = 4500
-	freeUserMaxResponses   = 1
+	freeUserMaxTokens    = 4500
+	freeUserMaxResponses = 1
 )
 
 // GetCurrentUser get current user
@@ -353,9 +343,15 @@ func applyFreeUserQuotaLimits(req *FrontendReq, promptTokenLimit int) (droppedMe
 func IsModelAllowed(ctx context.Context,
 	user *config.UserConfig,
 	req *FrontendReq) error {
-	onceLimiter.Do(setupRateLimiter)
 	logger := gmw.GetLogger(ctx)
 
+	// a managed api key narrows the models even for unlimited owners
+	if user.APIKeyID != "" &&
+		!slices.Contains(user.AllowedModels, "*") &&
+		!slices.Contains(user.AllowedModels, req.Model) {
+		return errors.Errorf("model %q is not allowed for api key %q", req.Model, user.APIKeyID)
+	}
+
 	switch {
 	case user.BYOK: // bypass if user bring their own token
 		logger.Debug("bypass rate limit for BYOK user")
@@ -386,53 +382,6 @@ func IsModelAllowed(ctx context.Context,
 		return errors.Errorf("model %q is not allowed for user %q", req.Model, user.UserName)
 	}
 
-	// if !globalRatelimiter.Allow() { // check rate limit
-	// 	return errors.Errorf("too many requests, please try again later")
-	// }
-
-	// rate limit only support limit by second,
-	// so we consume 60 tokens once to make it limit by minute
-	var (
-		ratelimitCost int
-		ratelimiter   = expensiveModelRateLimiter
-	)
-	switch req.Model {
-	case "gpt-3.5-turbo", // free models
-		// "gpt-3.5-turbo-1106",
-		// "gpt-3.5-turbo-0125",
-		"gpt-4o-mini",
-		"gpt-5.4-nano",
-		"openai/gpt-oss-20b",
-		"openai/gpt-oss-120b",
-		// "llama2-70b-4096",
-		"deepseek-v4-flash",
-		// "deepseek-coder",
-		"gemma2-9b-it",
-		"gemma-3-27b-it",
-		"llama3-8b-8192",
-		"llama3-70b-8192",
-		"llama-3.1-8b-instant",
-		"llama-3.1-405b-instruct",
-		"llama-3.3-70b-versatile",
-		"qwen-qwq-32b",
-		"qwen/qwen3-32b",
-		// "moonshotai/kimi-k2-instruct",
-		// "mixtral-8x7b-32768",
-		// "img-to-img",
-		// "sdxl-turbo",
-		"tts",
-		"gemini-3.1-flash-lite-preview",
-		"gemini-2.0-flash":
-		ratelimiter = freeModelRateLimiter
-		ratelimitCost = freeModelRateLimitCost
-	default: // expensive model
-		if user.NoLimitExpensiveModels {
-			return nil
-		}
-
-		ratelimitCost = gconfig.Shared.GetInt("openai.rate_limit_expensive_models_interval_secs")
-	}
-
 	if !user.NoLimitExpensiveModels {
 		droppedMessages, finalPromptTokens, changed, err := applyFreeUserQuotaLimits(req, user.LimitPromptTokenLength)
 		if err != nil {
@@ -450,50 +399,13 @@ func IsModelAllowed(ctx context.Context,
 		}
 	}
 
-	// if price less than 0, means no limit
-	logger.Debug("check rate limit",
-		zap.String("model", req.Model), zap.Int("price", ratelimitCost))
-	if ratelimitCost > 0 && !ratelimiter.AllowN(ratelimitCost) { // check rate limit
-		return errors.Errorf("This model(%s) restricts usage for free users. "+
-			"Please hold on for %d seconds before trying again, "+
-			"alternatively, you may opt to switch to the free gpt-4o-mini, "+
-			"or upgrade to a paid membership by https://wiki.laisky.com/projects/gpt/pay/",
-			req.Model, (ratelimitCost - ratelimiter.Len()))
+	if err := checkModelRateLimit(ctx, user, req.Model, "This model("+
+		strings.ReplaceAll(req.Model, "%", "%%")+") restricts usage for free users. "+
+		"Please hold on for %d seconds before trying again, "+
+		"alternatively, you may opt to switch to the free gpt-4o-mini, "+
+		"or upgrade to a paid membership by https://wiki.laisky.com/projects/gpt/pay/"); err != nil {
+		return errors.WithStack(err)
 	}
 
 	return nil
 }
-
-// setupRateLimiter setup ratelimiter depends on loaded config
-func setupRateLimiter() {
-	const burstRatio = 1.3
-	var err error
-	logger := log.Logger.Named("gptchat.ratelimiter")
-
-	// {
-	// 	if globalRatelimiter, err = gutils.NewRateLimiter(context.Background(),
-	// 		gutils.RateLimiterArgs{
-	// 			Max:     10,
-	// 			NPerSec: 1,
-	// 		}); err != nil {
-	// 		log.Logger.Panic("new ratelimiter", zap.Error(err))
-	// 	}
-	// 	logger.Info("set overall ratelimiter", zap.Int("burst", 10))
-	// }
-
-	burst := int(math.Ceil(float64(config.Config.RateLimitExpensiveModelsIntervalSeconds) * burstRatio))
-	if expensiveModelRateLimiter, err = rlimiter.New(context.Background(),
-		"gptchat:expensive",
-		rlimiter.Args{Max: burst, NPerSec: 1}); err != nil {
-		log.Logger.Panic("new expensiveModelRateLimiter", zap.Error(err))
-	}
-	logger.Info("set ratelimiter for expensive models", zap.Int("burst", burst))
-
-	freeBurst := int(math.Ceil(float64(freeModelRateLimitCost) * burstRatio))
-	if freeModelRateLimiter, err = rlimiter.New(context.Background(),
-		"gptchat:free",
-		rlimiter.Args{Max: freeBurst, NPerSec: 1}); err != nil {
-		log.Logger.Panic("new freeModelRateLimiter", zap.Error(err))
-	}
-	logger.Info("set ratelimiter for free models", zap.Int("burst", freeBurst))
-}
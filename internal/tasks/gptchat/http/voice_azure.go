@@ -0,0 +1,199 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/Laisky/zap"
+	"github.com/Microsoft/cognitive-services-speech-sdk-go/common"
+	"github.com/Microsoft/cognitive-services-speech-sdk-go/speech"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+const (
+	defaultAzureVoice     = "zh-CN-XiaoxiaoNeural"
+	defaultAzureLanguage  = "zh-CN"
+	azureSynthesisTimeout = 60 * time.Second
+)
+
+var azureOutputFormats = map[string]common.SpeechSynthesisOutputFormat{
+	AudioFormatMP3:  common.Audio24Khz48KBitRateMonoMp3,
+	AudioFormatOpus: common.Ogg24Khz16BitMonoOpus,
+	AudioFormatWAV:  common.Riff24Khz16BitMonoPcm,
+}
+
+func init() {
+	RegisterTTSProvider(azureVoiceProvider{})
+	RegisterSTTProvider(azureVoiceProvider{})
+}
+
+// azureVoiceProvider uses Azure Cognitive Services: the speech SDK for
+// synthesis and the short-audio REST API for recognition.
+type azureVoiceProvider struct{}
+
+// Name implements TTSProvider and STTProvider.
+func (azureVoiceProvider) Name() string { return "azure" }
+
+// Supports implements TTSProvider and STTProvider.
+func (azureVoiceProvider) Supports(*config.UserConfig) bool {
+	return config.Config.Azure.TTSKey != "" && config.Config.Azure.TTSRegion != ""
+}
+
+// Formats implements TTSProvider.
+func (azureVoiceProvider) Formats() []string {
+	return []string{AudioFormatMP3, AudioFormatOpus, AudioFormatWAV}
+}
+
+// Synthesize implements TTSProvider.
+//
+// Without an explicit voice the text is first rewritten into expressive
+// SSML by the user's chat model, as the original TTS handler did.
+func (azureVoiceProvider) Synthesize(ctx context.Context,
+	user *config.UserConfig, req *SpeechRequest) (io.ReadCloser, error) {
+	logger := gmw.GetLogger(ctx)
+
+	ssml := ""
+	if req.Voice == "" {
+		var err error
+		if ssml, err = generateSSML(ctx, user, req.Text); err != nil {
+			logger.Warn("failed to generate ssml by llm", zap.Error(err))
+			ssml = ""
+		}
+	}
+	if ssml == "" {
+		ssml = azureSSML(req)
+	}
+
+	azureTTSConfig, err := speech.NewSpeechConfigFromSubscription(
+		config.Config.Azure.TTSKey, config.Config.Azure.TTSRegion)
+	if err != nil {
+		return nil, errors.Wrap(err, "new speech config")
+	}
+	defer azureTTSConfig.Close()
+
+	if err = azureTTSConfig.SetSpeechSynthesisOutputFormat(azureOutputFormats[req.Format]); err != nil {
+		return nil, errors.Wrap(err, "set output format")
+	}
+
+	// nil audio config keeps the audio in the result instead of playing it
+	speechSynthesizer, err := speech.NewSpeechSynthesizerFromConfig(azureTTSConfig, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new speech synthesizer")
+	}
+	defer speechSynthesizer.Close()
+
+	var outcome speech.SpeechSynthesisOutcome
+	select {
+	case outcome = <-speechSynthesizer.SpeakSsmlAsync(ssml):
+	case <-time.After(azureSynthesisTimeout):
+		return nil, errors.New("timeout for speech synthesis")
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+	defer outcome.Close()
+	if outcome.Error != nil {
+		return nil, errors.Wrap(outcome.Error, "speech synthesis")
+	}
+	if outcome.Failed() || outcome.Result == nil || len(outcome.Result.AudioData) == 0 {
+		return nil, errors.New("speech synthesis returned no audio")
+	}
+
+	return io.NopCloser(bytes.NewReader(outcome.Result.AudioData)), nil
+}
+
+// azureSSML wraps plain text into SSML for req.Voice.
+func azureSSML(req *SpeechRequest) string {
+	voice := gutils.OptionalVal(&req.Voice, defaultAzureVoice)
+	lang := gutils.OptionalVal(&req.Language, defaultAzureLanguage)
+
+	var text bytes.Buffer
+	_ = xml.EscapeText(&text, []byte(req.Text))
+	content := text.String()
+	if voice == defaultAzureVoice {
+		content = `<mstts:express-as style="gentle">` + content + `</mstts:express-as>`
+	}
+	if req.Speed > 0 && req.Speed != 1 {
+		content = fmt.Sprintf(`<prosody rate="%+.0f%%">%s</prosody>`, (req.Speed-1)*100, content)
+	}
+
+	return fmt.Sprintf(`<speak xmlns="http://www.w3.org/2001/10/synthesis" `+
+		`xmlns:mstts="http://www.w3.org/2001/mstts" version="1.0" xml:lang="%s">`+
+		`<voice name="%s">%s</voice></speak>`,
+		xmlAttrEscape(lang), xmlAttrEscape(voice), content)
+}
+
+func xmlAttrEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// azureRecognitionResponse is the simple-format response of the short
+// audio recognition API.
+type azureRecognitionResponse struct {
+	RecognitionStatus string `json:"RecognitionStatus"`
+	DisplayText       string `json:"DisplayText"`
+	// Duration is in 100-nanosecond ticks.
+	Duration int64 `json:"Duration"`
+}
+
+// Transcribe implements STTProvider with the short-audio REST API, which
+// accepts up to 60 seconds of WAV or OGG/Opus audio.
+func (azureVoiceProvider) Transcribe(ctx context.Context,
+	_ *config.UserConfig, req *TranscribeRequest) (*Transcription, error) {
+	logger := gmw.GetLogger(ctx)
+	lang := gutils.OptionalVal(&req.Language, defaultAzureLanguage)
+
+	contentType := "audio/wav; codecs=audio/pcm; samplerate=16000"
+	if ext := strings.ToLower(req.Filename); strings.HasSuffix(ext, ".ogg") || strings.HasSuffix(ext, ".opus") {
+		contentType = "audio/ogg; codecs=opus"
+	}
+
+	upstreamURL := fmt.Sprintf("https://%s.stt.speech.microsoft.com/speech/recognition/"+
+		"conversation/cognitiveservices/v1?language=%s",
+		config.Config.Azure.TTSRegion, url.QueryEscape(lang))
+	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodPost, upstreamURL, req.Audio)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+	upstreamReq.Header.Set("Ocp-Apim-Subscription-Key", config.Config.Azure.TTSKey)
+	upstreamReq.Header.Set("Content-Type", contentType)
+	upstreamReq.Header.Set("Accept", "application/json")
+
+	resp, err := httpcli.Do(upstreamReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+	defer gutils.CloseWithLog(resp.Body, logger)
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("[%d]%s", resp.StatusCode, string(respBody))
+	}
+
+	var respData azureRecognitionResponse
+	if err = json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+	if respData.RecognitionStatus != "Success" {
+		return nil, errors.Errorf("recognition failed: %s", respData.RecognitionStatus)
+	}
+
+	return &Transcription{
+		Text:     respData.DisplayText,
+		Language: lang,
+		Duration: float64(respData.Duration) / 1e7,
+	}, nil
+}
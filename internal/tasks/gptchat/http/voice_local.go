@@ -0,0 +1,119 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"unicode/utf8"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+const (
+	localVoiceSampleRate = 8000
+	// localVoiceSamplesPerRune makes the silent audio last 40ms per rune.
+	localVoiceSamplesPerRune = localVoiceSampleRate / 25
+	localVoiceMaxSamples     = localVoiceSampleRate * 60
+)
+
+func init() {
+	RegisterTTSProvider(localVoiceProvider{})
+	RegisterSTTProvider(localVoiceProvider{})
+}
+
+// localVoiceProvider is an offline stand-in for tests and development.
+// TTS renders silence whose length follows the text and stores the text in
+// the WAV INFO comment; STT reads that comment back, so a round trip
+// returns the original text without any upstream.
+type localVoiceProvider struct{}
+
+// Name implements TTSProvider and STTProvider.
+func (localVoiceProvider) Name() string { return "local" }
+
+// Supports implements TTSProvider and STTProvider.
+func (localVoiceProvider) Supports(*config.UserConfig) bool {
+	return config.Config != nil && config.Config.EnableLocalVoiceProvider
+}
+
+// Formats implements TTSProvider.
+func (localVoiceProvider) Formats() []string { return []string{AudioFormatWAV} }
+
+// Synthesize implements TTSProvider.
+func (localVoiceProvider) Synthesize(_ context.Context,
+	_ *config.UserConfig, req *SpeechRequest) (io.ReadCloser, error) {
+	samples := min(utf8.RuneCountInString(req.Text)*localVoiceSamplesPerRune, localVoiceMaxSamples)
+
+	comment := []byte(req.Text + "\x00")
+	if len(comment)%2 == 1 {
+		comment = append(comment, 0)
+	}
+	infoSize := 4 + 8 + len(comment)
+
+	var buf bytes.Buffer
+	write := func(v any) { _ = binary.Write(&buf, binary.LittleEndian, v) }
+	buf.WriteString("RIFF")
+	write(uint32(4 + (8 + 16) + (8 + infoSize) + (8 + samples)))
+	buf.WriteString("WAVE")
+
+	buf.WriteString("fmt ")
+	write(uint32(16))
+	write(uint16(1)) // PCM
+	write(uint16(1)) // mono
+	write(uint32(localVoiceSampleRate))
+	write(uint32(localVoiceSampleRate)) // byte rate
+	write(uint16(1))                    // block align
+	write(uint16(8))                    // bits per sample
+
+	buf.WriteString("LIST")
+	write(uint32(infoSize))
+	buf.WriteString("INFO")
+	buf.WriteString("ICMT")
+	write(uint32(len(comment)))
+	buf.Write(comment)
+
+	buf.WriteString("data")
+	write(uint32(samples))
+	buf.Write(bytes.Repeat([]byte{0x80}, samples)) // 8-bit PCM silence
+
+	return io.NopCloser(&buf), nil
+}
+
+// Transcribe implements STTProvider.
+func (localVoiceProvider) Transcribe(_ context.Context,
+	_ *config.UserConfig, req *TranscribeRequest) (*Transcription, error) {
+	data, err := io.ReadAll(req.Audio)
+	if err != nil {
+		return nil, errors.Wrap(err, "read audio")
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("local stt only reads wav files")
+	}
+
+	var (
+		text    string
+		samples int
+	)
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8 : min(pos+8+size, len(data))]
+		switch id {
+		case "LIST":
+			if len(body) >= 12 && string(body[:4]) == "INFO" && string(body[4:8]) == "ICMT" {
+				n := min(int(binary.LittleEndian.Uint32(body[8:12])), len(body)-12)
+				text = string(bytes.TrimRight(body[12:12+n], "\x00"))
+			}
+		case "data":
+			samples = len(body)
+		}
+		pos += 8 + size + size%2
+	}
+
+	return &Transcription{
+		Text:     text,
+		Duration: float64(samples) / localVoiceSampleRate,
+	}, nil
+}
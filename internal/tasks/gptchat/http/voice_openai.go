@@ -0,0 +1,113 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/go-utils/v6/json"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+const (
+	defaultOpenaiTTSModel = "tts-1"
+	defaultOpenaiTTSVoice = "alloy"
+	defaultOpenaiSTTModel = "whisper-1"
+)
+
+func init() {
+	RegisterTTSProvider(openaiVoiceProvider{})
+	RegisterSTTProvider(openaiVoiceProvider{})
+}
+
+// openaiVoiceProvider speaks the OpenAI-compatible `/v1/audio/*` API of
+// the user's api base, which is how one-api exposes every upstream.
+type openaiVoiceProvider struct{}
+
+// Name implements TTSProvider and STTProvider.
+func (openaiVoiceProvider) Name() string { return "openai" }
+
+// Supports implements TTSProvider and STTProvider.
+func (openaiVoiceProvider) Supports(user *config.UserConfig) bool {
+	return user.APIBase != "" && user.OpenaiToken != ""
+}
+
+// Formats implements TTSProvider.
+func (openaiVoiceProvider) Formats() []string {
+	return []string{AudioFormatMP3, AudioFormatOpus, AudioFormatAAC,
+		AudioFormatFLAC, AudioFormatWAV, AudioFormatPCM}
+}
+
+// Synthesize implements TTSProvider. The upstream body is returned as-is so
+// audio streams to the client while it is being generated.
+func (openaiVoiceProvider) Synthesize(ctx context.Context,
+	user *config.UserConfig, req *SpeechRequest) (io.ReadCloser, error) {
+	body := map[string]any{
+		"model":           gutils.OptionalVal(&req.Model, defaultOpenaiTTSModel),
+		"input":           req.Text,
+		"voice":           gutils.OptionalVal(&req.Voice, defaultOpenaiTTSVoice),
+		"response_format": req.Format,
+	}
+	if req.Speed > 0 {
+		body["speed"] = req.Speed
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal speech request")
+	}
+
+	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v1/audio/speech", user.APIBase), bytes.NewReader(payload))
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+	upstreamReq.Header.Set("Content-Type", "application/json")
+	upstreamReq.Header.Set("Authorization", "Bearer "+user.OpenaiToken)
+
+	resp, err := httpcli.Do(upstreamReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer gutils.CloseWithLog(resp.Body, gmw.GetLogger(ctx))
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("[%d]%s", resp.StatusCode, string(respBody))
+	}
+
+	return resp.Body, nil
+}
+
+// Transcribe implements STTProvider.
+func (openaiVoiceProvider) Transcribe(ctx context.Context,
+	user *config.UserConfig, req *TranscribeRequest) (*Transcription, error) {
+	// whisper takes ISO-639-1 codes, so "zh-CN" becomes "zh"
+	language, _, _ := strings.Cut(req.Language, "-")
+	resp, err := transcriptByOpenai(ctx, user, req.Audio,
+		gutils.OptionalVal(&req.Filename, "file.wav"),
+		gutils.OptionalVal(&req.Model, defaultOpenaiSTTModel),
+		strings.ToLower(language))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := &Transcription{
+		Text:     resp.Text,
+		Language: resp.Language,
+		Duration: resp.Duration,
+	}
+	for _, seg := range resp.Segments {
+		result.Segments = append(result.Segments, TranscriptionSegment{
+			Start: seg.Start,
+			End:   seg.End,
+			Text:  seg.Text,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,261 @@
+package http
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+// Audio formats of synthesized speech.
+const (
+	AudioFormatMP3  = "mp3"
+	AudioFormatOpus = "opus"
+	AudioFormatAAC  = "aac"
+	AudioFormatFLAC = "flac"
+	AudioFormatWAV  = "wav"
+	AudioFormatPCM  = "pcm"
+)
+
+// speechChunkRunes is the largest piece of text synthesized per upstream
+// call when streaming a long answer.
+const speechChunkRunes = 600
+
+var audioContentTypes = map[string]string{
+	AudioFormatMP3:  "audio/mpeg",
+	AudioFormatOpus: "audio/ogg",
+	AudioFormatAAC:  "audio/aac",
+	AudioFormatFLAC: "audio/flac",
+	AudioFormatWAV:  "audio/wav",
+	AudioFormatPCM:  "audio/pcm",
+}
+
+// streamableAudioFormats can be concatenated chunk by chunk and still
+// play as one stream. WAV and FLAC carry a header per file, so they are
+// synthesized in a single call.
+var streamableAudioFormats = map[string]bool{
+	AudioFormatMP3:  true,
+	AudioFormatOpus: true,
+	AudioFormatAAC:  true,
+	AudioFormatPCM:  true,
+}
+
+// SpeechRequest is the provider-neutral input of text-to-speech.
+type SpeechRequest struct {
+	Text   string
+	Voice  string
+	Model  string
+	Format string
+	// Speed is the speaking rate; 0 means the provider default.
+	Speed    float64
+	Language string
+}
+
+// TranscribeRequest is the provider-neutral input of speech-to-text.
+type TranscribeRequest struct {
+	Audio    io.Reader
+	Filename string
+	Model    string
+	Language string
+}
+
+// TranscriptionSegment is one timed piece of a transcription.
+type TranscriptionSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// Transcription is the provider-neutral result of speech-to-text.
+type Transcription struct {
+	Provider string                 `json:"provider"`
+	Text     string                 `json:"text"`
+	Language string                 `json:"language,omitempty"`
+	Duration float64                `json:"duration,omitempty"`
+	Segments []TranscriptionSegment `json:"segments,omitempty"`
+}
+
+// TTSProvider synthesizes speech. Billing and chunking are done by the
+// caller, so Synthesize only has to turn one piece of text into audio.
+type TTSProvider interface {
+	// Name is the stable identifier clients pass as `provider`.
+	Name() string
+	// Supports reports whether the provider is configured for user.
+	Supports(user *config.UserConfig) bool
+	// Formats lists the audio formats the provider can return; the first
+	// one is its default.
+	Formats() []string
+	// Synthesize returns the audio of req.Text in req.Format. The caller
+	// closes the returned reader.
+	Synthesize(ctx context.Context, user *config.UserConfig, req *SpeechRequest) (io.ReadCloser, error)
+}
+
+// STTProvider transcribes speech.
+type STTProvider interface {
+	Name() string
+	Supports(user *config.UserConfig) bool
+	Transcribe(ctx context.Context, user *config.UserConfig, req *TranscribeRequest) (*Transcription, error)
+}
+
+var (
+	voiceProvidersMu sync.RWMutex
+	ttsProviders     = map[string]TTSProvider{}
+	sttProviders     = map[string]STTProvider{}
+)
+
+// defaultTTSProviders and defaultSTTProviders are tried in order when
+// neither the request nor the user's preferences name a provider. Azure
+// stays first for TTS to keep the voice of the original handler.
+var (
+	defaultTTSProviders = []string{"azure", "openai"}
+	defaultSTTProviders = []string{"openai", "azure"}
+)
+
+// RegisterTTSProvider installs p under p.Name(). Providers register
+// themselves from init().
+func RegisterTTSProvider(p TTSProvider) {
+	voiceProvidersMu.Lock()
+	defer voiceProvidersMu.Unlock()
+	ttsProviders[p.Name()] = p
+}
+
+// RegisterSTTProvider installs p under p.Name().
+func RegisterSTTProvider(p STTProvider) {
+	voiceProvidersMu.Lock()
+	defer voiceProvidersMu.Unlock()
+	sttProviders[p.Name()] = p
+}
+
+// ListVoiceProviders returns the registered TTS and STT provider names, sorted.
+func ListVoiceProviders() (tts, stt []string) {
+	voiceProvidersMu.RLock()
+	defer voiceProvidersMu.RUnlock()
+	for name := range ttsProviders {
+		tts = append(tts, name)
+	}
+	for name := range sttProviders {
+		stt = append(stt, name)
+	}
+	sort.Strings(tts)
+	sort.Strings(stt)
+	return tts, stt
+}
+
+// resolveVoiceProvider picks a provider: the explicit name, then the
+// user's preference, then the defaults in order.
+func resolveVoiceProvider[P interface {
+	Name() string
+	Supports(*config.UserConfig) bool
+}](providers map[string]P, defaults []string, user *config.UserConfig, kind, name, preferred string) (P, error) {
+	voiceProvidersMu.RLock()
+	defer voiceProvidersMu.RUnlock()
+
+	var zero P
+	if name = strings.TrimSpace(name); name != "" {
+		p, ok := providers[name]
+		if !ok {
+			return zero, errors.Errorf("unknown %s provider %q", kind, name)
+		}
+		if !p.Supports(user) {
+			return zero, errors.Errorf("%s provider %q is not available", kind, name)
+		}
+		return p, nil
+	}
+
+	candidates := defaults
+	if preferred = strings.TrimSpace(preferred); preferred != "" {
+		candidates = append([]string{preferred}, defaults...)
+	}
+	for _, candidate := range candidates {
+		if p, ok := providers[candidate]; ok && p.Supports(user) {
+			return p, nil
+		}
+	}
+	return zero, errors.Errorf("no %s provider available", kind)
+}
+
+func resolveTTSProvider(user *config.UserConfig, name string) (TTSProvider, error) {
+	return resolveVoiceProvider(ttsProviders, defaultTTSProviders, user, "tts", name, user.Voice.TTSProvider)
+}
+
+func resolveSTTProvider(user *config.UserConfig, name string) (STTProvider, error) {
+	return resolveVoiceProvider(sttProviders, defaultSTTProviders, user, "stt", name, user.Voice.STTProvider)
+}
+
+// newSpeechRequest merges request parameters over the user's preferences
+// and settles the audio format against what p supports.
+func newSpeechRequest(p TTSProvider, user *config.UserConfig, req SpeechRequest) (*SpeechRequest, error) {
+	prefs := user.Voice
+	req.Voice = gutils.OptionalVal(&req.Voice, prefs.Voice)
+	req.Model = gutils.OptionalVal(&req.Model, prefs.TTSModel)
+	req.Language = gutils.OptionalVal(&req.Language, prefs.Language)
+	req.Speed = gutils.OptionalVal(&req.Speed, prefs.Speed)
+	req.Format = strings.ToLower(gutils.OptionalVal(&req.Format, prefs.Format))
+
+	formats := p.Formats()
+	switch {
+	case req.Format == "":
+		req.Format = formats[0]
+	case !gutils.Contains(formats, req.Format):
+		return nil, errors.Errorf("tts provider %q does not support format %q, supported: %v",
+			p.Name(), req.Format, formats)
+	}
+	return &req, nil
+}
+
+// audioContentType returns the MIME type of an audio format.
+func audioContentType(format string) string {
+	if ct, ok := audioContentTypes[format]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// splitSpeechText cuts text into pieces of at most maxRunes, preferring to
+// break after sentence punctuation, then after whitespace.
+func splitSpeechText(text string, maxRunes int) []string {
+	runes := []rune(strings.TrimSpace(text))
+	var chunks []string
+	for len(runes) > 0 {
+		if len(runes) <= maxRunes {
+			chunks = append(chunks, string(runes))
+			break
+		}
+
+		cut := -1
+		for i := maxRunes - 1; i > 0 && cut < 0; i-- {
+			if isSentenceEnd(runes[i]) {
+				cut = i + 1
+			}
+		}
+		for i := maxRunes - 1; i > 0 && cut < 0; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i + 1
+			}
+		}
+		if cut < 0 {
+			cut = maxRunes
+		}
+
+		if chunk := strings.TrimSpace(string(runes[:cut])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		runes = []rune(strings.TrimLeftFunc(string(runes[cut:]), unicode.IsSpace))
+	}
+	return chunks
+}
+
+func isSentenceEnd(r rune) bool {
+	switch r {
+	case '.', '!', '?', ';', '\n', '。', '！', '？', '；':
+		return true
+	}
+	return false
+}
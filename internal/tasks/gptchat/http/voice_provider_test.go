@@ -0,0 +1,196 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Laisky/testify/require"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+func TestSplitSpeechText(t *testing.T) {
+	require.Equal(t, []string{"hello"}, splitSpeechText("  hello ", 10))
+	require.Empty(t, splitSpeechText("   ", 10))
+
+	chunks := splitSpeechText("One two. Three four five six. Seven.", 16)
+	require.Equal(t, []string{"One two.", "Three four five", "six. Seven."}, chunks)
+
+	chunks = splitSpeechText("你好世界。今天天气很好！", 6)
+	require.Equal(t, []string{"你好世界。", "今天天气很好", "！"}, chunks)
+
+	long := strings.Repeat("a", 25)
+	chunks = splitSpeechText(long, 10)
+	require.Len(t, chunks, 3)
+	require.Equal(t, long, strings.Join(chunks, ""))
+	for _, c := range chunks {
+		require.LessOrEqual(t, utf8.RuneCountInString(c), 10)
+	}
+}
+
+// fakeTTSProvider records synthesized chunks and returns "<chunk>|" as audio.
+type fakeTTSProvider struct {
+	name      string
+	supported bool
+	formats   []string
+
+	mu     sync.Mutex
+	chunks []string
+}
+
+func (p *fakeTTSProvider) Name() string                     { return p.name }
+func (p *fakeTTSProvider) Supports(*config.UserConfig) bool { return p.supported }
+func (p *fakeTTSProvider) Formats() []string                { return p.formats }
+func (p *fakeTTSProvider) Synthesize(_ context.Context,
+	_ *config.UserConfig, req *SpeechRequest) (io.ReadCloser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.chunks = append(p.chunks, req.Text)
+	return io.NopCloser(strings.NewReader(req.Text + "|")), nil
+}
+
+func setupVoiceProviders(t *testing.T, providers ...TTSProvider) {
+	t.Helper()
+	voiceProvidersMu.Lock()
+	originalTTS := ttsProviders
+	originalDefaults := defaultTTSProviders
+	ttsProviders = map[string]TTSProvider{}
+	for _, p := range providers {
+		ttsProviders[p.Name()] = p
+	}
+	defaultTTSProviders = []string{"fake-a", "fake-b"}
+	voiceProvidersMu.Unlock()
+
+	t.Cleanup(func() {
+		voiceProvidersMu.Lock()
+		ttsProviders = originalTTS
+		defaultTTSProviders = originalDefaults
+		voiceProvidersMu.Unlock()
+	})
+}
+
+func TestResolveTTSProvider(t *testing.T) {
+	a := &fakeTTSProvider{name: "fake-a", formats: []string{AudioFormatMP3}}
+	b := &fakeTTSProvider{name: "fake-b", supported: true, formats: []string{AudioFormatMP3}}
+	c := &fakeTTSProvider{name: "fake-c", supported: true, formats: []string{AudioFormatWAV, AudioFormatMP3}}
+	setupVoiceProviders(t, a, b, c)
+
+	user := &config.UserConfig{}
+	p, err := resolveTTSProvider(user, "")
+	require.NoError(t, err)
+	require.Equal(t, "fake-b", p.Name(), "unsupported default is skipped")
+
+	user.Voice.TTSProvider = "fake-c"
+	p, err = resolveTTSProvider(user, "")
+	require.NoError(t, err)
+	require.Equal(t, "fake-c", p.Name(), "user preference wins over defaults")
+
+	p, err = resolveTTSProvider(user, "fake-b")
+	require.NoError(t, err)
+	require.Equal(t, "fake-b", p.Name(), "explicit name wins over preference")
+
+	_, err = resolveTTSProvider(user, "fake-a")
+	require.ErrorContains(t, err, "not available")
+	_, err = resolveTTSProvider(user, "nope")
+	require.ErrorContains(t, err, "unknown")
+
+	user.Voice.Format = "MP3"
+	user.Voice.Voice = "nova"
+	req, err := newSpeechRequest(c, user, SpeechRequest{Text: "hi", Voice: "echo"})
+	require.NoError(t, err)
+	require.Equal(t, AudioFormatMP3, req.Format)
+	require.Equal(t, "echo", req.Voice)
+
+	user.Voice.Format = ""
+	req, err = newSpeechRequest(c, user, SpeechRequest{Text: "hi"})
+	require.NoError(t, err)
+	require.Equal(t, AudioFormatWAV, req.Format, "first format is the default")
+	require.Equal(t, "nova", req.Voice)
+
+	_, err = newSpeechRequest(b, user, SpeechRequest{Text: "hi", Format: AudioFormatFLAC})
+	require.ErrorContains(t, err, "does not support format")
+}
+
+func TestLocalVoiceProviderRoundTrip(t *testing.T) {
+	originalConfig := config.Config
+	config.Config = &config.OpenAI{}
+	t.Cleanup(func() { config.Config = originalConfig })
+
+	p := localVoiceProvider{}
+	require.False(t, p.Supports(nil))
+	config.Config.EnableLocalVoiceProvider = true
+	require.True(t, p.Supports(nil))
+
+	text := "hello, 世界"
+	audio, err := p.Synthesize(context.Background(), nil, &SpeechRequest{Text: text})
+	require.NoError(t, err)
+	data, err := io.ReadAll(audio)
+	require.NoError(t, err)
+	require.Equal(t, "RIFF", string(data[:4]))
+
+	result, err := p.Transcribe(context.Background(), nil,
+		&TranscribeRequest{Audio: bytes.NewReader(data)})
+	require.NoError(t, err)
+	require.Equal(t, text, result.Text)
+	require.InDelta(t, float64(utf8.RuneCountInString(text))*0.04, result.Duration, 1e-9)
+
+	_, err = p.Transcribe(context.Background(), nil,
+		&TranscribeRequest{Audio: strings.NewReader("not a wav")})
+	require.Error(t, err)
+}
+
+func TestTTSHandlerStreamsChunks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	originalConfig := config.Config
+	config.Config = &config.OpenAI{RateLimitExpensiveModelsIntervalSeconds: 600}
+	t.Cleanup(func() { config.Config = originalConfig })
+
+	fake := &fakeTTSProvider{name: "fake-b", supported: true,
+		formats: []string{AudioFormatMP3, AudioFormatWAV}}
+	setupVoiceProviders(t, fake)
+
+	var charged int
+	originalCharge := chargeVoice
+	chargeVoice = func(context.Context, *config.UserConfig, string) error {
+		charged++
+		return nil
+	}
+	t.Cleanup(func() { chargeVoice = originalCharge })
+
+	user := &config.UserConfig{UserName: "u", BYOK: true}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(ctxKeyUser, user) })
+	router.GET("/audio/tts", TTSHanler)
+
+	text := strings.Repeat("Sentence number one. ", 60)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet,
+		"/audio/tts?text="+strings.ReplaceAll(text, " ", "%20"), nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "audio/mpeg", resp.Header().Get("Content-Type"))
+	require.Greater(t, len(fake.chunks), 1)
+	require.Equal(t, len(fake.chunks), charged, "every chunk is billed")
+	require.Equal(t, strings.Join(fake.chunks, "|")+"|", resp.Body.String())
+
+	// wav is not concatenable, so it is synthesized at once
+	fake.chunks, charged = nil, 0
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet,
+		"/audio/tts?format=wav&text="+strings.ReplaceAll(text, " ", "%20"), nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "audio/wav", resp.Header().Get("Content-Type"))
+	require.Len(t, fake.chunks, 1)
+	require.Equal(t, 1, charged)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/audio/tts?format=flac&text=hi", nil))
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
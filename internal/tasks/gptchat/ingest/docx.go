@@ -0,0 +1,210 @@
+package ingest
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// maxZipEntryBytes bounds how much of one OOXML part is decompressed, to
+// keep zip bombs from exhausting memory.
+const maxZipEntryBytes = 64 << 20
+
+// readZipEntry returns the decompressed content of name, or nil when the
+// archive has no such entry.
+func readZipEntry(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "open %s", name)
+		}
+		defer rc.Close()
+
+		data, err := io.ReadAll(io.LimitReader(rc, maxZipEntryBytes+1))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", name)
+		}
+		if len(data) > maxZipEntryBytes {
+			return nil, errors.Errorf("%s exceeds %d bytes", name, maxZipEntryBytes)
+		}
+		return data, nil
+	}
+	return nil, nil
+}
+
+// docxParagraph accumulates one <w:p>.
+type docxParagraph struct {
+	text    strings.Builder
+	heading int
+	list    bool
+}
+
+func (p *docxParagraph) markdown() string {
+	text := strings.TrimSpace(p.text.String())
+	if text == "" {
+		return ""
+	}
+	switch {
+	case p.heading > 0:
+		return strings.Repeat("#", p.heading) + " " + text
+	case p.list:
+		return "- " + text
+	default:
+		return text
+	}
+}
+
+// docxHeadingLevel maps the built-in Title/HeadingN styles to a Markdown
+// heading level. Title becomes h1 and HeadingN becomes h(N+1) so they nest
+// under it; levels are capped at 6.
+func docxHeadingLevel(style string) int {
+	style = strings.ToLower(strings.ReplaceAll(style, " ", ""))
+	if style == "title" {
+		return 1
+	}
+	if n, ok := strings.CutPrefix(style, "heading"); ok {
+		if level, err := strconv.Atoi(n); err == nil && level > 0 {
+			return min(level+1, 6)
+		}
+	}
+	return 0
+}
+
+// extractDOCX renders word/document.xml: headings, list items, paragraphs
+// and tables. Formatting, images and comments are dropped.
+func extractDOCX(_ context.Context, content []byte, _ *Options) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", errors.Wrap(err, "open docx archive")
+	}
+	doc, err := readZipEntry(zr, "word/document.xml")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if doc == nil {
+		return "", errors.New("word/document.xml not found")
+	}
+
+	var (
+		out        strings.Builder
+		dec        = xml.NewDecoder(bytes.NewReader(doc))
+		para       *docxParagraph
+		tableDepth int
+		inRun      bool
+		rows       [][]string
+		row        []string
+		cell       []string
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "decode word/document.xml")
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tbl":
+				tableDepth++
+				if tableDepth == 1 {
+					rows = nil
+				}
+			case "tr":
+				if tableDepth == 1 {
+					row = nil
+				}
+			case "tc":
+				if tableDepth == 1 {
+					cell = nil
+				}
+			case "p":
+				para = &docxParagraph{}
+			case "r":
+				inRun = true
+			case "pStyle":
+				if para != nil {
+					para.heading = docxHeadingLevel(xmlAttr(t, "val"))
+				}
+			case "numPr":
+				if para != nil {
+					para.list = true
+				}
+			case "t":
+				var text string
+				if err := dec.DecodeElement(&text, &t); err != nil {
+					return "", errors.Wrap(err, "decode text run")
+				}
+				if para != nil {
+					para.text.WriteString(text)
+				}
+			case "tab":
+				// <w:tab> also declares tab stops inside <w:pPr>; only
+				// the run-level one is content.
+				if para != nil && inRun {
+					para.text.WriteByte('\t')
+				}
+			case "br", "cr":
+				if para != nil {
+					para.text.WriteByte('\n')
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "r":
+				inRun = false
+			case "p":
+				if para == nil {
+					continue
+				}
+				text := para.markdown()
+				para = nil
+				if text == "" {
+					continue
+				}
+				if tableDepth > 0 {
+					cell = append(cell, text)
+				} else {
+					out.WriteString(text)
+					out.WriteString("\n\n")
+				}
+			case "tc":
+				if tableDepth == 1 {
+					row = append(row, strings.Join(cell, " "))
+				}
+			case "tr":
+				if tableDepth == 1 {
+					rows = append(rows, row)
+				}
+			case "tbl":
+				tableDepth--
+				if tableDepth == 0 && len(rows) > 0 {
+					writeMarkdownTable(&out, rows)
+				}
+			}
+		}
+	}
+
+	return out.String(), nil
+}
+
+// xmlAttr returns the value of the attribute with local name local.
+func xmlAttr(el xml.StartElement, local string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
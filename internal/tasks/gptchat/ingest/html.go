@@ -0,0 +1,18 @@
+package ingest
+
+import (
+	"context"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/Laisky/errors/v2"
+)
+
+// extractHTML converts an HTML document locally, the same converter the
+// crawler uses before it falls back to remote fetch proxies.
+func extractHTML(_ context.Context, content []byte, _ *Options) (string, error) {
+	markdown, err := md.NewConverter("", true, nil).ConvertString(string(content))
+	if err != nil {
+		return "", errors.Wrap(err, "convert html to markdown")
+	}
+	return markdown, nil
+}
@@ -0,0 +1,202 @@
+// Package ingest converts uploaded documents into normalized Markdown.
+//
+// Every extractor emits plain Markdown with HTML anchors in front of
+// each page (`page-N`) or sheet (`sheet-<name>`) so downstream consumers
+// can cite a location inside the original file. The package is pure: it
+// does not know about S3, users or caching, which live in the http
+// package.
+package ingest
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Laisky/errors/v2"
+)
+
+var (
+	// ErrUnsupportedFormat is returned for file extensions no extractor handles.
+	ErrUnsupportedFormat = errors.New("unsupported document format")
+	// ErrOCRUnavailable is returned for images when Options.OCR is nil.
+	ErrOCRUnavailable = errors.New("ocr is not configured")
+	// ErrNoText is returned when a document parsed fine but carries no
+	// extractable text, e.g. a scanned PDF.
+	ErrNoText = errors.New("document contains no extractable text")
+)
+
+// OCR recognizes the text in one image.
+type OCR interface {
+	OCRImage(ctx context.Context, image []byte) (string, error)
+}
+
+// Options tunes Extract.
+type Options struct {
+	// OCR is used for image files; optional.
+	OCR OCR
+}
+
+type extractor func(ctx context.Context, content []byte, opt *Options) (string, error)
+
+var extractors = map[string]extractor{
+	".pdf":      extractPDF,
+	".docx":     extractDOCX,
+	".xlsx":     extractXLSX,
+	".csv":      extractCSV,
+	".html":     extractHTML,
+	".htm":      extractHTML,
+	".txt":      extractText,
+	".md":       extractText,
+	".markdown": extractText,
+	".png":      extractImage,
+	".jpg":      extractImage,
+	".jpeg":     extractImage,
+	".webp":     extractImage,
+	".gif":      extractImage,
+}
+
+// normalizeExt lower-cases ext and makes sure it starts with a dot.
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// Supports reports whether Extract can handle files with extension ext.
+func Supports(ext string) bool {
+	_, ok := extractors[normalizeExt(ext)]
+	return ok
+}
+
+// Extract converts content, whose type is given by its file extension,
+// into normalized Markdown.
+func Extract(ctx context.Context, ext string, content []byte, opt *Options) (string, error) {
+	if opt == nil {
+		opt = &Options{}
+	}
+	ext = normalizeExt(ext)
+	fn, ok := extractors[ext]
+	if !ok {
+		return "", errors.Wrapf(ErrUnsupportedFormat, "ext %q", ext)
+	}
+	if len(content) == 0 {
+		return "", errors.New("content is empty")
+	}
+
+	markdown, err := fn(ctx, content, opt)
+	if err != nil {
+		return "", errors.Wrapf(err, "extract %s", ext)
+	}
+	markdown = normalizeMarkdown(markdown)
+	if markdown == "" {
+		return "", errors.Wrapf(ErrNoText, "extract %s", ext)
+	}
+	return markdown, nil
+}
+
+func extractText(_ context.Context, content []byte, _ *Options) (string, error) {
+	if !utf8.Valid(content) {
+		return "", errors.New("text file is not valid utf-8")
+	}
+	return string(content), nil
+}
+
+func extractImage(ctx context.Context, content []byte, opt *Options) (string, error) {
+	if opt.OCR == nil {
+		return "", errors.WithStack(ErrOCRUnavailable)
+	}
+	text, err := opt.OCR.OCRImage(ctx, content)
+	if err != nil {
+		return "", errors.Wrap(err, "ocr image")
+	}
+	return text, nil
+}
+
+var multiBlankLinesRegexp = regexp.MustCompile(`\n{3,}`)
+
+// normalizeMarkdown unifies line endings, strips trailing whitespace and
+// collapses runs of blank lines.
+func normalizeMarkdown(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	s = strings.ReplaceAll(s, "\x00", "")
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	s = strings.Join(lines, "\n")
+	s = multiBlankLinesRegexp.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
+
+// anchorSlug turns a page or sheet label into an HTML id fragment.
+func anchorSlug(s string) string {
+	var b strings.Builder
+	lastDash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			b.WriteRune(r)
+			lastDash = false
+		case !lastDash && b.Len() > 0:
+			b.WriteByte('-')
+			lastDash = true
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// writeSection writes an anchored level-2 heading.
+func writeSection(b *strings.Builder, anchor, title string) {
+	b.WriteString(`<a id="`)
+	b.WriteString(anchor)
+	b.WriteString("\"></a>\n\n## ")
+	b.WriteString(title)
+	b.WriteString("\n\n")
+}
+
+// writeMarkdownTable renders rows with the first row as header. Rows are
+// padded to the widest row.
+func writeMarkdownTable(b *strings.Builder, rows [][]string) {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	if width == 0 {
+		return
+	}
+
+	writeRow := func(row []string) {
+		b.WriteString("|")
+		for i := range width {
+			cell := ""
+			if i < len(row) {
+				cell = escapeTableCell(row[i])
+			}
+			b.WriteString(" ")
+			b.WriteString(cell)
+			b.WriteString(" |")
+		}
+		b.WriteString("\n")
+	}
+
+	writeRow(rows[0])
+	b.WriteString("|")
+	b.WriteString(strings.Repeat(" --- |", width))
+	b.WriteString("\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	b.WriteString("\n")
+}
+
+func escapeTableCell(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.ReplaceAll(s, "\r\n", " ")
+	s = strings.ReplaceAll(s, "\n", " ")
+	return strings.ReplaceAll(s, "|", `\|`)
+}
@@ -0,0 +1,136 @@
+package ingest
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// buildZip packs files into an in-memory zip archive.
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestExtractDOCX(t *testing.T) {
+	docx := buildZip(t, map[string]string{
+		"word/document.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+  <w:body>
+    <w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr><w:r><w:t>Quarterly Report</w:t></w:r></w:p>
+    <w:p><w:pPr><w:pStyle w:val="Heading1"/><w:tabs><w:tab w:val="left" w:pos="720"/></w:tabs></w:pPr><w:r><w:t>Summary</w:t></w:r></w:p>
+    <w:p><w:r><w:t xml:space="preserve">Revenue grew </w:t></w:r><w:r><w:t>12%.</w:t></w:r></w:p>
+    <w:p><w:pPr><w:numPr><w:ilvl w:val="0"/></w:numPr></w:pPr><w:r><w:t>first item</w:t></w:r></w:p>
+    <w:tbl>
+      <w:tr><w:tc><w:p><w:r><w:t>Region</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>Sales</w:t></w:r></w:p></w:tc></w:tr>
+      <w:tr><w:tc><w:p><w:r><w:t>EU|West</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>42</w:t></w:r></w:p></w:tc></w:tr>
+    </w:tbl>
+  </w:body>
+</w:document>`,
+	})
+
+	got, err := Extract(context.Background(), ".DOCX", docx, nil)
+	require.NoError(t, err)
+	require.Equal(t, "# Quarterly Report\n\n"+
+		"## Summary\n\n"+
+		"Revenue grew 12%.\n\n"+
+		"- first item\n\n"+
+		"| Region | Sales |\n| --- | --- |\n| EU\\|West | 42 |", got)
+}
+
+func TestExtractXLSX(t *testing.T) {
+	xlsx := buildZip(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+  xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+  <sheets><sheet name="Q1 Data" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId1" Type="worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <si><t>Name</t></si><si><t>Score</t></si><si><r><t>Al</t></r><r><t>ice</t></r></si>
+</sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+  <row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>
+  <row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2" t="b"><v>1</v></c><c r="C2"><v>97.5</v></c></row>
+  <row r="3"><c r="A3" t="inlineStr"><is><t>Bob</t></is></c></row>
+</sheetData></worksheet>`,
+	})
+
+	got, err := Extract(context.Background(), ".xlsx", xlsx, nil)
+	require.NoError(t, err)
+	require.Equal(t, "<a id=\"sheet-q1-data\"></a>\n\n## Sheet: Q1 Data\n\n"+
+		"| Name |  | Score |\n| --- | --- | --- |\n"+
+		"| Alice | true | 97.5 |\n"+
+		"| Bob |  |  |", got)
+}
+
+func TestExtractCSVAndText(t *testing.T) {
+	got, err := Extract(context.Background(), "csv", []byte("\xef\xbb\xbfa,b\n1,\"x\ny\"\n,\n"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "| a | b |\n| --- | --- |\n| 1 | x y |", got)
+
+	got, err = Extract(context.Background(), ".md", []byte("# t\r\n\r\n\r\n\r\nbody  \r\n"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "# t\n\nbody", got)
+
+	_, err = Extract(context.Background(), ".txt", []byte{0xff, 0xfe, 0x00}, nil)
+	require.Error(t, err)
+}
+
+func TestExtractHTML(t *testing.T) {
+	got, err := Extract(context.Background(), ".html",
+		[]byte(`<html><body><h1>Hi</h1><p>see <a href="https://example.com">this</a></p></body></html>`), nil)
+	require.NoError(t, err)
+	require.Equal(t, "# Hi\n\nsee [this](https://example.com)", got)
+}
+
+type fakeOCR struct{ text string }
+
+func (f fakeOCR) OCRImage(context.Context, []byte) (string, error) { return f.text, nil }
+
+func TestExtractImage(t *testing.T) {
+	_, err := Extract(context.Background(), ".png", []byte("png"), nil)
+	require.True(t, errors.Is(err, ErrOCRUnavailable))
+
+	got, err := Extract(context.Background(), ".png", []byte("png"), &Options{OCR: fakeOCR{text: "hello\n"}})
+	require.NoError(t, err)
+	require.Equal(t, "hello", got)
+
+	_, err = Extract(context.Background(), ".png", []byte("png"), &Options{OCR: fakeOCR{}})
+	require.True(t, errors.Is(err, ErrNoText))
+}
+
+func TestExtractUnsupported(t *testing.T) {
+	require.False(t, Supports(".exe"))
+	require.True(t, Supports("PDF"))
+	_, err := Extract(context.Background(), ".exe", []byte("MZ"), nil)
+	require.True(t, errors.Is(err, ErrUnsupportedFormat))
+}
+
+func TestPDFContentText(t *testing.T) {
+	stream := []byte(`BT /F1 12 Tf 72 720 Td (Hello \(PDF\)) Tj 0 -14 Td [(Wor) -30 (ld) -400 (again)] TJ
+T* <48692021> Tj ET
+% a comment (ignored) Tj
+BI /W 1 /H 1 ID ` + "\x00\xff(junk) Tj" + ` EI
+BT << /MCID 0 >> BDC (caf\351 \101) ' ET`)
+
+	require.Equal(t, "Hello (PDF)\nWorld again\nHi !\ncafé A\n", pdfContentText(stream))
+}
+
+func TestDecodePDFTextUTF16(t *testing.T) {
+	require.Equal(t, "中文", decodePDFText([]byte{0xFE, 0xFF, 0x4E, 0x2D, 0x65, 0x87}))
+}
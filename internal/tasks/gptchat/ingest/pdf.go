@@ -0,0 +1,411 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// tjSpaceThreshold is the TJ kerning offset, in thousandths of an em,
+// beyond which a gap is rendered as a space.
+const tjSpaceThreshold = 200
+
+// extractPDF reads every page's content stream with pdfcpu and pulls out
+// the text-showing operators. Only simple (single-byte or UTF-16) string
+// encodings are decoded; scanned pages and CID fonts without a usable
+// encoding yield no text.
+func extractPDF(ctx context.Context, content []byte, _ *Options) (string, error) {
+	conf := model.NewDefaultConfiguration()
+	conf.Cmd = model.EXTRACTCONTENT
+	conf.ValidationMode = model.ValidationRelaxed
+
+	pdfCtx, err := api.ReadValidateAndOptimize(bytes.NewReader(content), conf)
+	if err != nil {
+		return "", errors.Wrap(err, "read pdf")
+	}
+
+	var b strings.Builder
+	for pageNr := 1; pageNr <= pdfCtx.PageCount; pageNr++ {
+		if err := ctx.Err(); err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		r, err := pdfcpu.ExtractPageContent(pdfCtx, pageNr)
+		if err != nil {
+			return "", errors.Wrapf(err, "extract content of page %d", pageNr)
+		}
+		stream, err := io.ReadAll(r)
+		if err != nil {
+			return "", errors.Wrapf(err, "read content of page %d", pageNr)
+		}
+
+		text := strings.TrimSpace(pdfContentText(stream))
+		if text == "" {
+			continue
+		}
+		writeSection(&b, fmt.Sprintf("page-%d", pageNr), fmt.Sprintf("Page %d", pageNr))
+		b.WriteString(text)
+		b.WriteString("\n\n")
+	}
+
+	return b.String(), nil
+}
+
+// pdfContentText walks a page content stream and returns the text shown
+// by Tj, TJ, ' and ", breaking lines on text positioning operators.
+func pdfContentText(stream []byte) string {
+	var (
+		out      strings.Builder
+		lex      = pdfLexer{data: stream}
+		operands []pdfToken
+	)
+
+	newline := func() {
+		s := out.String()
+		if s != "" && !strings.HasSuffix(s, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			break
+		}
+		if tok.kind != pdfTokenOperator {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch tok.value {
+		case "Tj":
+			writeLastString(&out, operands)
+		case "'", "\"":
+			newline()
+			writeLastString(&out, operands)
+		case "TJ":
+			if n := len(operands); n > 0 && operands[n-1].kind == pdfTokenArray {
+				for _, item := range operands[n-1].items {
+					switch item.kind {
+					case pdfTokenString:
+						out.WriteString(item.value)
+					case pdfTokenNumber:
+						if v, err := strconv.ParseFloat(item.value, 64); err == nil && v < -tjSpaceThreshold {
+							out.WriteByte(' ')
+						}
+					}
+				}
+			}
+		case "T*", "ET":
+			newline()
+		case "Td", "TD":
+			// a vertical move starts a new line; a horizontal one is a gap
+			if n := len(operands); n >= 2 && !isZeroNumber(operands[n-1].value) {
+				newline()
+			} else if s := out.String(); s != "" && !strings.HasSuffix(s, " ") && !strings.HasSuffix(s, "\n") {
+				out.WriteByte(' ')
+			}
+		case "BI":
+			lex.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+
+	return out.String()
+}
+
+func isZeroNumber(s string) bool {
+	v, err := strconv.ParseFloat(s, 64)
+	return err == nil && v == 0
+}
+
+func writeLastString(out *strings.Builder, operands []pdfToken) {
+	if n := len(operands); n > 0 && operands[n-1].kind == pdfTokenString {
+		out.WriteString(operands[n-1].value)
+	}
+}
+
+type pdfTokenKind int
+
+const (
+	pdfTokenOperator pdfTokenKind = iota
+	pdfTokenNumber
+	pdfTokenString
+	pdfTokenName
+	pdfTokenArray
+	pdfTokenOther
+)
+
+type pdfToken struct {
+	kind  pdfTokenKind
+	value string
+	items []pdfToken
+}
+
+// pdfLexer is a minimal tokenizer for page content streams. It only
+// understands what pdfContentText needs; dictionaries are skipped.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFWhitespace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+	return false
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func (l *pdfLexer) skipSpaceAndComments() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFWhitespace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	l.skipSpaceAndComments()
+	if l.pos >= len(l.data) {
+		return pdfToken{}, false
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return pdfToken{kind: pdfTokenString, value: decodePDFText(l.literalString())}, true
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.skipDict()
+		return pdfToken{kind: pdfTokenOther}, true
+	case c == '<':
+		l.pos++
+		return pdfToken{kind: pdfTokenString, value: decodePDFText(l.hexString())}, true
+	case c == '[':
+		l.pos++
+		arr := pdfToken{kind: pdfTokenArray}
+		for {
+			l.skipSpaceAndComments()
+			if l.pos >= len(l.data) {
+				return arr, true
+			}
+			if l.data[l.pos] == ']' {
+				l.pos++
+				return arr, true
+			}
+			item, ok := l.next()
+			if !ok {
+				return arr, true
+			}
+			arr.items = append(arr.items, item)
+		}
+	case c == '/':
+		l.pos++
+		return pdfToken{kind: pdfTokenName, value: l.regular()}, true
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		l.pos++
+		return pdfToken{kind: pdfTokenOther}, true
+	}
+
+	word := l.regular()
+	if word == "" {
+		l.pos++
+		return pdfToken{kind: pdfTokenOther}, true
+	}
+	if _, err := strconv.ParseFloat(word, 64); err == nil {
+		return pdfToken{kind: pdfTokenNumber, value: word}, true
+	}
+	return pdfToken{kind: pdfTokenOperator, value: word}, true
+}
+
+// regular consumes a run of regular characters.
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFWhitespace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// literalString consumes a (...) string whose opening paren was already
+// read, handling nesting and escapes.
+func (l *pdfLexer) literalString() []byte {
+	var (
+		buf   []byte
+		depth = 1
+	)
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return buf
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return buf
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'b':
+				buf = append(buf, '\b')
+			case 'f':
+				buf = append(buf, '\f')
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					buf = append(buf, byte(v))
+				} else {
+					buf = append(buf, e)
+				}
+			}
+			continue
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+// hexString consumes a <...> string whose opening bracket was already read.
+func (l *pdfLexer) hexString() []byte {
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !isPDFWhitespace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++ // '>'
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, 0, len(digits)/2)
+	for i := 0; i+1 < len(digits); i += 2 {
+		v, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return nil
+		}
+		out = append(out, byte(v))
+	}
+	return out
+}
+
+// skipDict skips a <<...>> dictionary, including nested ones.
+func (l *pdfLexer) skipDict() {
+	depth := 0
+	for l.pos+1 < len(l.data) {
+		switch {
+		case l.data[l.pos] == '<' && l.data[l.pos+1] == '<':
+			depth++
+			l.pos += 2
+		case l.data[l.pos] == '>' && l.data[l.pos+1] == '>':
+			depth--
+			l.pos += 2
+			if depth == 0 {
+				return
+			}
+		case l.data[l.pos] == '(':
+			l.pos++
+			l.literalString()
+		default:
+			l.pos++
+		}
+	}
+	l.pos = len(l.data)
+}
+
+// skipInlineImage skips the binary payload between ID and EI.
+func (l *pdfLexer) skipInlineImage() {
+	idx := bytes.Index(l.data[l.pos:], []byte("ID"))
+	if idx < 0 {
+		l.pos = len(l.data)
+		return
+	}
+	l.pos += idx + 2
+	for l.pos+2 < len(l.data) {
+		if isPDFWhitespace(l.data[l.pos]) && l.data[l.pos+1] == 'E' && l.data[l.pos+2] == 'I' &&
+			(l.pos+3 == len(l.data) || isPDFWhitespace(l.data[l.pos+3])) {
+			l.pos += 3
+			return
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
+
+// decodePDFText decodes a UTF-16BE string (with BOM) or treats the bytes
+// as Latin-1. Control characters are dropped, so glyph-id encoded strings
+// of CID fonts mostly vanish instead of polluting the output.
+func decodePDFText(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return strings.Map(dropControl, string(utf16.Decode(units)))
+	}
+
+	var b strings.Builder
+	for _, c := range raw {
+		if r := dropControl(rune(c)); r >= 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func dropControl(r rune) rune {
+	if r == '\n' || r == '\t' {
+		return r
+	}
+	if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) {
+		return -1
+	}
+	return r
+}
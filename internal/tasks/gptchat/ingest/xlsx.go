@@ -0,0 +1,244 @@
+package ingest
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// maxSheetRows caps the rows rendered per sheet or CSV file; the rest is
+// summarized in a trailing note.
+const maxSheetRows = 2000
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		// RID is the r:id relationship pointing at the sheet part.
+		RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxRichText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (r xlsxRichText) String() string {
+	if len(r.R) == 0 {
+		return r.T
+	}
+	var b strings.Builder
+	for _, run := range r.R {
+		b.WriteString(run.T)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxRichText `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref       string        `xml:"r,attr"`
+			Type      string        `xml:"t,attr"`
+			Value     string        `xml:"v"`
+			InlineStr *xlsxRichText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// extractXLSX renders every worksheet as an anchored Markdown table.
+// Cached cell values are used as-is; formulas are not evaluated and number
+// formats are not applied.
+func extractXLSX(ctx context.Context, content []byte, _ *Options) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", errors.Wrap(err, "open xlsx archive")
+	}
+
+	var workbook xlsxWorkbook
+	if err := unmarshalZipXML(zr, "xl/workbook.xml", &workbook, true); err != nil {
+		return "", errors.WithStack(err)
+	}
+	var rels xlsxRelationships
+	if err := unmarshalZipXML(zr, "xl/_rels/workbook.xml.rels", &rels, true); err != nil {
+		return "", errors.WithStack(err)
+	}
+	var shared xlsxSharedStrings
+	if err := unmarshalZipXML(zr, "xl/sharedStrings.xml", &shared, false); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.ID] = target
+	}
+
+	var out strings.Builder
+	for _, s := range workbook.Sheets {
+		if err := ctx.Err(); err != nil {
+			return "", errors.WithStack(err)
+		}
+		target, ok := targets[s.RID]
+		if !ok {
+			continue
+		}
+
+		var sheet xlsxSheet
+		if err := unmarshalZipXML(zr, target, &sheet, true); err != nil {
+			return "", errors.Wrapf(err, "sheet %q", s.Name)
+		}
+
+		rows := make([][]string, 0, len(sheet.Rows))
+		for _, r := range sheet.Rows {
+			var row []string
+			for i, c := range r.Cells {
+				col := i
+				if ref := xlsxColumnIndex(c.Ref); ref >= 0 {
+					col = ref
+				}
+				for len(row) <= col {
+					row = append(row, "")
+				}
+
+				switch c.Type {
+				case "s":
+					if idx, err := strconv.Atoi(c.Value); err == nil && idx >= 0 && idx < len(shared.Items) {
+						row[col] = shared.Items[idx].String()
+					}
+				case "inlineStr":
+					if c.InlineStr != nil {
+						row[col] = c.InlineStr.String()
+					}
+				case "b":
+					row[col] = strconv.FormatBool(c.Value == "1")
+				default:
+					row[col] = c.Value
+				}
+			}
+			rows = append(rows, row)
+		}
+
+		writeSection(&out, "sheet-"+anchorSlug(s.Name), "Sheet: "+s.Name)
+		writeTableRows(&out, trimEmptyRows(rows))
+	}
+
+	return out.String(), nil
+}
+
+// extractCSV renders a CSV file as one Markdown table.
+func extractCSV(_ context.Context, content []byte, _ *Options) (string, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	var rows [][]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "read csv")
+		}
+		rows = append(rows, record)
+	}
+
+	var out strings.Builder
+	writeTableRows(&out, trimEmptyRows(rows))
+	return out.String(), nil
+}
+
+// writeTableRows writes rows as a table, truncated to maxSheetRows.
+func writeTableRows(out *strings.Builder, rows [][]string) {
+	if len(rows) == 0 {
+		out.WriteString("(empty)\n\n")
+		return
+	}
+	truncated := 0
+	if len(rows) > maxSheetRows {
+		truncated = len(rows) - maxSheetRows
+		rows = rows[:maxSheetRows]
+	}
+	writeMarkdownTable(out, rows)
+	if truncated > 0 {
+		fmt.Fprintf(out, "(%d more rows omitted)\n\n", truncated)
+	}
+}
+
+// trimEmptyRows drops rows whose cells are all blank.
+func trimEmptyRows(rows [][]string) [][]string {
+	kept := rows[:0]
+	for _, row := range rows {
+		for _, cell := range row {
+			if strings.TrimSpace(cell) != "" {
+				kept = append(kept, row)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// xlsxColumnIndex converts the column letters of a cell reference such as
+// "AB12" to a zero-based index, or -1 when ref has no letters.
+func xlsxColumnIndex(ref string) int {
+	col := 0
+	n := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return -1
+	}
+	return col - 1
+}
+
+// unmarshalZipXML decodes the XML part name into v. A missing part is an
+// error only when required is set.
+func unmarshalZipXML(zr *zip.Reader, name string, v any, required bool) error {
+	data, err := readZipEntry(zr, name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if data == nil {
+		if required {
+			return errors.Errorf("%s not found", name)
+		}
+		return nil
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return errors.Wrapf(err, "decode %s", name)
+	}
+	return nil
+}
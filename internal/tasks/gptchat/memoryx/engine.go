@@ -17,6 +17,13 @@ import (
 	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
 )
 
+const (
+	// l1RetentionDays and l2RetentionDays are how long facts of the
+	// short-lived tiers are kept.
+	l1RetentionDays = 1
+	l2RetentionDays = 7
+)
+
 var (
 	engineCacheMu sync.RWMutex
 	engineCache   = map[string]memory.Engine{}
@@ -56,8 +63,8 @@ func getEngine(ctx context.Context, conf *config.OpenAI, user *config.UserConfig
 		RecallFactsLimit:       20,
 		SearchLimit:            5,
 		CompactThreshold:       0.8,
-		L1RetentionDays:        1,
-		L2RetentionDays:        7,
+		L1RetentionDays:        l1RetentionDays,
+		L2RetentionDays:        l2RetentionDays,
 		CompactionMinAge:       24 * time.Hour,
 		SummaryRefreshInterval: time.Hour,
 		MaxProcessedTurns:      1024,
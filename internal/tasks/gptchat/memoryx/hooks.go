@@ -261,11 +261,12 @@ func runMemoryMaintenance(ctx context.Context, engine memory.Engine, keys Runtim
 //   - user: Current authenticated user.
 //
 // Returns:
-//   - bool: True when memory is globally enabled and user is not a free-tier user.
+//   - bool: True when memory is globally enabled and user is neither a free-tier
+//     user nor the anonymous identity, whose token is shared by every caller.
 func isMemoryEnabled(conf *config.OpenAI, user *config.UserConfig) bool {
 	if conf == nil || !conf.EnableMemory {
 		return false
 	}
 
-	return user == nil || !user.IsFree
+	return user == nil || (!user.IsFree && !user.IsAnonymous())
 }
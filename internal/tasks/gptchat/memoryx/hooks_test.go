@@ -279,6 +279,18 @@ func TestBeforeTurnHookDisabledForFreeUser(t *testing.T) {
 	require.False(t, before.Enabled)
 }
 
+func TestMemoryDisabledForAnonymousUser(t *testing.T) {
+	conf := &config.OpenAI{EnableMemory: true}
+
+	// prepaid anonymous users are not free, but still share one token
+	user := &config.UserConfig{UserName: "FREETIER-alice", Token: config.FreetierUserToken}
+	require.False(t, isMemoryEnabled(conf, user))
+	_, err := OpenStore(context.Background(), conf, user)
+	require.ErrorIs(t, err, ErrMemoryDisabled)
+
+	require.True(t, isMemoryEnabled(conf, &config.UserConfig{UserName: "alice", Token: "sso-alice"}))
+}
+
 func TestAfterTurnHookDisabledForFreeUser(t *testing.T) {
 	conf := &config.OpenAI{
 		EnableMemory:        true,
@@ -32,6 +32,10 @@ func BuildRuntimeKeys(conf *config.OpenAI, user *config.UserConfig, header http.
 	_ = header
 	keys := RuntimeKeys{UserID: strings.TrimSpace(user.UserName), TurnID: uuid.NewString()}
 	keys.Project = strings.TrimSpace(conf.MemoryProject)
+	if org := conf.Organization(user.OrgID); org != nil {
+		// organization members share the organization's memory project
+		keys.Project = org.MemoryProject
+	}
 	if keys.Project == "" {
 		keys.Project = "go-ramjet-memory"
 	}
@@ -40,3 +40,17 @@ func TestBuildRuntimeKeysFallbackToUserIDWithoutAPIKey(t *testing.T) {
 	keys := BuildRuntimeKeys(conf, user, header)
 	require.Equal(t, "alice", keys.SessionID)
 }
+
+func TestBuildRuntimeKeysOrganizationProject(t *testing.T) {
+	conf := &config.OpenAI{
+		MemoryProject: "gptchat",
+		Organizations: []*config.OrganizationConfig{{ID: "acme", MemoryProject: "gptchat-org-acme"}},
+	}
+
+	keys := BuildRuntimeKeys(conf, &config.UserConfig{UserName: "alice", OrgID: "acme"}, http.Header{})
+	require.Equal(t, "gptchat-org-acme", keys.Project)
+	require.Equal(t, "alice", keys.UserID)
+
+	keys = BuildRuntimeKeys(conf, &config.UserConfig{UserName: "bob", OrgID: "gone"}, http.Header{})
+	require.Equal(t, "gptchat", keys.Project)
+}
@@ -0,0 +1,580 @@
+package memoryx
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/go-utils/v6/agents/memory"
+	memorystorage "github.com/Laisky/go-utils/v6/agents/memory/storage"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+// The file layout below mirrors go-utils agents/memory, which does not
+// export it. Management writes follow the engine's own conventions: fact
+// changes are appended to the tier shards and mirrored in the active facts
+// index, so that an index rebuild keeps them.
+const (
+	memoryTierPinned  = "L0"
+	memoryTierDaily   = "L1"
+	memoryTierDefault = "L2"
+
+	factStateActive  = "active"
+	factStateDeleted = "deleted"
+
+	// manualTurnID marks fact records written by the management API.
+	manualTurnID = "manual"
+
+	// listDepth and listLimit bound the recursive listings of one session.
+	listDepth = 16
+	listLimit = 4096
+)
+
+var (
+	// ErrMemoryDisabled is returned when memory is off for the user.
+	ErrMemoryDisabled = errors.New("memory is not enabled for this user")
+	// ErrMemoryItemNotFound is returned for unknown fact or summary ids.
+	ErrMemoryItemNotFound = errors.New("memory item not found")
+)
+
+// Fact is one active long-term memory fact.
+type Fact struct {
+	// ID identifies the fact within the session, `<fact_id>::<key>`
+	ID         string  `json:"id"`
+	Key        string  `json:"key"`
+	Value      string  `json:"value"`
+	Confidence float64 `json:"confidence"`
+	Tier       string  `json:"tier"`
+	// Pinned facts never expire
+	Pinned       bool   `json:"pinned"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	UpdatedAt    string `json:"updated_at"`
+	SourceTurnID string `json:"source_turn_id,omitempty"`
+}
+
+// FactUpdate is an edit of one fact, nil fields are kept.
+type FactUpdate struct {
+	Value  *string `json:"value,omitempty"`
+	Pinned *bool   `json:"pinned,omitempty"`
+}
+
+// Summary is one compacted conversation summary or consolidated insight.
+type Summary struct {
+	ID string `json:"id"`
+	// Kind is `compact` or `insight`
+	Kind      string `json:"kind"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"created_at"`
+
+	file string
+}
+
+// SearchHit is one chunk of memory matching a search.
+type SearchHit struct {
+	Path    string  `json:"path"`
+	Content string  `json:"content"`
+	Score   float64 `json:"score"`
+}
+
+// ExportFile is one file of an exported session.
+type ExportFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// Export is every file stored for one memory session.
+type Export struct {
+	Project    string       `json:"project"`
+	SessionID  string       `json:"session_id"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Files      []ExportFile `json:"files"`
+}
+
+// Store inspects and edits the memory of one session.
+type Store struct {
+	storage memorystorage.Engine
+	engine  memory.Engine
+	keys    RuntimeKeys
+	now     func() time.Time
+}
+
+// NewStore returns a Store of the session keys, engine runs maintenance.
+func NewStore(storage memorystorage.Engine, engine memory.Engine, keys RuntimeKeys) *Store {
+	return &Store{storage: storage, engine: engine, keys: keys, now: time.Now}
+}
+
+// OpenStore returns the Store of user's memory session.
+//
+// Parameters:
+//   - ctx: Request context.
+//   - conf: Global gptchat openai config.
+//   - user: Current authenticated user.
+//
+// Returns:
+//   - *Store: Store of the session BuildRuntimeKeys picks for user.
+//   - error: ErrMemoryDisabled when memory is off for user.
+func OpenStore(ctx context.Context, conf *config.OpenAI, user *config.UserConfig) (*Store, error) {
+	if !isMemoryEnabled(conf, user) || user == nil {
+		return nil, ErrMemoryDisabled
+	}
+
+	storage, err := buildStorageEngine(ctx, conf, user, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "build memory storage engine")
+	}
+	engine, err := GetEngine(ctx, conf, user)
+	if err != nil {
+		return nil, errors.Wrap(err, "get memory engine")
+	}
+
+	keys := BuildRuntimeKeys(conf, user, nil)
+	if keys.SessionID == "" || strings.Contains(keys.SessionID, "/") {
+		return nil, errors.Errorf("invalid memory session %q", keys.SessionID)
+	}
+
+	return NewStore(storage, engine, keys), nil
+}
+
+// Keys returns the project and session of the store.
+func (s *Store) Keys() RuntimeKeys {
+	return s.keys
+}
+
+func (s *Store) basePath() string {
+	return "/memory/" + s.keys.SessionID
+}
+
+func (s *Store) activeFactsPath() string {
+	return path.Join(s.basePath(), "indexes", "active_facts.json")
+}
+
+func (s *Store) tierRoot(tier string) string {
+	return path.Join(s.basePath(), "memory_tiers", tier)
+}
+
+// tierShardPath returns the shard a fact of tier written at now goes to.
+func (s *Store) tierShardPath(tier string, now time.Time) string {
+	now = now.UTC()
+	switch tier {
+	case memoryTierPinned:
+		return path.Join(s.tierRoot(tier), now.Format("2006"), now.Format("01"),
+			fmt.Sprintf("facts-%s.jsonl", now.Format("200601")))
+	case memoryTierDaily:
+		return path.Join(s.tierRoot(tier), now.Format("2006"), now.Format("01"),
+			fmt.Sprintf("facts-%s.jsonl", now.Format("20060102")))
+	default:
+		year, week := now.ISOWeek()
+		return path.Join(s.tierRoot(memoryTierDefault), fmt.Sprintf("%04d", year), fmt.Sprintf("%02d", week),
+			fmt.Sprintf("facts-%04d-W%02d.jsonl", year, week))
+	}
+}
+
+// listFiles lists the files under root, missing directories are empty.
+func (s *Store) listFiles(ctx context.Context, root, suffix string) ([]memorystorage.FileInfo, error) {
+	entries, _, err := s.storage.List(ctx, s.keys.Project, root, listDepth, listLimit)
+	if err != nil {
+		if isMemoryColdStartNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "list %s", root)
+	}
+
+	files := make([]memorystorage.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type == memorystorage.FileTypeFile && strings.HasSuffix(entry.Path, suffix) {
+			files = append(files, entry)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// readJSONL calls fn with every line of the jsonl file p.
+func (s *Store) readJSONL(ctx context.Context, p string, fn func(line string) error) error {
+	body, err := s.storage.Read(ctx, s.keys.Project, p, 0, -1)
+	if err != nil {
+		return errors.Wrapf(err, "read %s", p)
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if err = fn(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadIndex loads the active facts index, or rebuilds it from the tier
+// shards like the engine does when the index is missing.
+func (s *Store) loadIndex(ctx context.Context) (memory.ActiveFactsIndex, error) {
+	info, err := s.storage.Stat(ctx, s.keys.Project, s.activeFactsPath())
+	if err != nil && !isMemoryColdStartNotFound(err) {
+		return memory.ActiveFactsIndex{}, errors.Wrap(err, "stat active facts index")
+	}
+	if err == nil && info.Exists && info.Type == memorystorage.FileTypeFile {
+		body, err := s.storage.Read(ctx, s.keys.Project, s.activeFactsPath(), 0, -1)
+		if err != nil {
+			return memory.ActiveFactsIndex{}, errors.Wrap(err, "read active facts index")
+		}
+
+		var index memory.ActiveFactsIndex
+		if stdjson.Unmarshal([]byte(body), &index) == nil {
+			if index.Facts == nil {
+				index.Facts = map[string]memory.MemoryFact{}
+			}
+			return index, nil
+		}
+	}
+
+	var records []memory.MemoryFact
+	for _, tier := range []string{memoryTierPinned, memoryTierDaily, memoryTierDefault} {
+		files, err := s.listFiles(ctx, s.tierRoot(tier), ".jsonl")
+		if err != nil {
+			return memory.ActiveFactsIndex{}, errors.Wrapf(err, "list tier %s", tier)
+		}
+		for _, f := range files {
+			if err = s.readJSONL(ctx, f.Path, func(line string) error {
+				var fact memory.MemoryFact
+				if stdjson.Unmarshal([]byte(line), &fact) == nil {
+					records = append(records, fact)
+				}
+				return nil
+			}); err != nil {
+				return memory.ActiveFactsIndex{}, err
+			}
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].TS < records[j].TS })
+	index := memory.ActiveFactsIndex{Facts: map[string]memory.MemoryFact{}}
+	for _, fact := range records {
+		id := factIdentity(fact)
+		if id == "" {
+			continue
+		}
+		switch strings.ToLower(fact.State) {
+		case "", factStateActive, "consolidated":
+			index.Facts[id] = fact
+		default:
+			delete(index.Facts, id)
+		}
+	}
+	return index, nil
+}
+
+// saveFact appends record to its tier shard and writes the index.
+func (s *Store) saveFact(ctx context.Context, index memory.ActiveFactsIndex, record memory.MemoryFact) error {
+	line, err := stdjson.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "marshal fact")
+	}
+	if err = s.storage.Write(ctx, s.keys.Project, s.tierShardPath(record.Tier, s.now()),
+		string(line)+"\n", memorystorage.WriteModeAppend, 0); err != nil {
+		return errors.Wrap(err, "append fact")
+	}
+
+	index.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	body, err := stdjson.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "marshal active facts index")
+	}
+	if err = s.storage.Write(ctx, s.keys.Project, s.activeFactsPath(),
+		string(body), memorystorage.WriteModeTruncate, 0); err != nil {
+		return errors.Wrap(err, "write active facts index")
+	}
+
+	return nil
+}
+
+// factIdentity identifies a fact like the engine does.
+func factIdentity(fact memory.MemoryFact) string {
+	factID := strings.TrimSpace(strings.ToLower(fact.FactID))
+	key := strings.TrimSpace(strings.ToLower(fact.Key))
+	if factID == "" && key == "" {
+		return ""
+	}
+	return factID + "::" + key
+}
+
+// isFactExpired reports whether fact expired at now.
+func isFactExpired(now time.Time, fact memory.MemoryFact) bool {
+	expiresAt, err := time.Parse(time.RFC3339, fact.ExpiresAt)
+	return err == nil && !expiresAt.After(now)
+}
+
+func toFact(id string, fact memory.MemoryFact) Fact {
+	return Fact{
+		ID:           id,
+		Key:          fact.Key,
+		Value:        fact.Value,
+		Confidence:   fact.Confidence,
+		Tier:         fact.Tier,
+		Pinned:       fact.Tier == memoryTierPinned,
+		ExpiresAt:    fact.ExpiresAt,
+		UpdatedAt:    fact.TS,
+		SourceTurnID: fact.SourceTurnID,
+	}
+}
+
+// Facts returns the active facts, those whose key or value contains query
+// when it is not empty, newest first.
+func (s *Store) Facts(ctx context.Context, query string) ([]Fact, error) {
+	index, err := s.loadIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	now := s.now()
+	facts := make([]Fact, 0, len(index.Facts))
+	for id, fact := range index.Facts {
+		if isFactExpired(now, fact) {
+			continue
+		}
+		if query != "" &&
+			!strings.Contains(strings.ToLower(fact.Key), query) &&
+			!strings.Contains(strings.ToLower(fact.Value), query) {
+			continue
+		}
+		facts = append(facts, toFact(id, fact))
+	}
+	sort.Slice(facts, func(i, j int) bool {
+		if facts[i].UpdatedAt == facts[j].UpdatedAt {
+			return facts[i].ID < facts[j].ID
+		}
+		return facts[i].UpdatedAt > facts[j].UpdatedAt
+	})
+
+	return facts, nil
+}
+
+// UpdateFact edits or pins the fact id. Pinned facts move to the tier that
+// never expires, unpinned ones to the default tier.
+func (s *Store) UpdateFact(ctx context.Context, id string, update FactUpdate) (Fact, error) {
+	index, err := s.loadIndex(ctx)
+	if err != nil {
+		return Fact{}, err
+	}
+	existing, ok := index.Facts[id]
+	if !ok || isFactExpired(s.now(), existing) {
+		return Fact{}, errors.Wrapf(ErrMemoryItemNotFound, "fact %q", id)
+	}
+
+	now := s.now().UTC()
+	record := existing
+	record.ID = fmt.Sprintf("%s-%d-fact_upsert", manualTurnID, now.UnixNano())
+	record.TS = now.Format(time.RFC3339)
+	record.Type = "fact_upsert"
+	record.State = factStateActive
+	record.SourceTurnID = manualTurnID
+	record.SourceUserID = s.keys.UserID
+	if update.Value != nil {
+		value := strings.TrimSpace(*update.Value)
+		if value == "" {
+			return Fact{}, errors.New("value should not be empty")
+		}
+		record.Value = value
+		// the user is the best source of truth
+		record.Confidence = 1
+	}
+	if update.Pinned != nil {
+		switch {
+		case *update.Pinned:
+			record.Tier = memoryTierPinned
+			record.ExpiresAt = ""
+		case record.Tier == memoryTierPinned:
+			record.Tier = memoryTierDefault
+			day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+			record.ExpiresAt = day.AddDate(0, 0, l2RetentionDays).Format(time.RFC3339)
+		}
+	}
+
+	index.Facts[id] = record
+	if err = s.saveFact(ctx, index, record); err != nil {
+		return Fact{}, err
+	}
+	return toFact(id, record), nil
+}
+
+// DeleteFact forgets the fact id.
+func (s *Store) DeleteFact(ctx context.Context, id string) error {
+	index, err := s.loadIndex(ctx)
+	if err != nil {
+		return err
+	}
+	existing, ok := index.Facts[id]
+	if !ok {
+		return errors.Wrapf(ErrMemoryItemNotFound, "fact %q", id)
+	}
+
+	now := s.now().UTC()
+	record := existing
+	record.ID = fmt.Sprintf("%s-%d-fact_delete", manualTurnID, now.UnixNano())
+	record.TS = now.Format(time.RFC3339)
+	record.Type = "fact_delete"
+	record.State = factStateDeleted
+	record.DeletedAt = record.TS
+	record.SourceTurnID = manualTurnID
+	record.SourceUserID = s.keys.UserID
+
+	delete(index.Facts, id)
+	return s.saveFact(ctx, index, record)
+}
+
+// Summaries returns the compacted conversation summaries and the
+// consolidated insights, newest first.
+func (s *Store) Summaries(ctx context.Context) ([]Summary, error) {
+	var summaries []Summary
+	for _, src := range []struct {
+		kind, root string
+	}{
+		{"compact", path.Join(s.basePath(), "events", "compact")},
+		{"insight", path.Join(s.basePath(), "insights")},
+	} {
+		files, err := s.listFiles(ctx, src.root, ".jsonl")
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if err = s.readJSONL(ctx, f.Path, func(line string) error {
+				var record struct {
+					ID      string `json:"id"`
+					TS      string `json:"ts"`
+					Summary string `json:"summary"`
+				}
+				if stdjson.Unmarshal([]byte(line), &record) == nil && record.ID != "" && record.Summary != "" {
+					summaries = append(summaries, Summary{
+						ID:        record.ID,
+						Kind:      src.kind,
+						Text:      record.Summary,
+						CreatedAt: record.TS,
+						file:      f.Path,
+					})
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].CreatedAt > summaries[j].CreatedAt })
+	return summaries, nil
+}
+
+// DeleteSummary removes the summary or insight id from its shard.
+func (s *Store) DeleteSummary(ctx context.Context, id string) error {
+	summaries, err := s.Summaries(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, summary := range summaries {
+		if summary.ID != id {
+			continue
+		}
+
+		var kept []string
+		if err = s.readJSONL(ctx, summary.file, func(line string) error {
+			var record struct {
+				ID string `json:"id"`
+			}
+			if stdjson.Unmarshal([]byte(line), &record) != nil || record.ID != id {
+				kept = append(kept, line)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		body := strings.Join(kept, "\n")
+		if body != "" {
+			body += "\n"
+		}
+		if err = s.storage.Write(ctx, s.keys.Project, summary.file, body,
+			memorystorage.WriteModeTruncate, 0); err != nil {
+			return errors.Wrapf(err, "rewrite %s", summary.file)
+		}
+		return nil
+	}
+
+	return errors.Wrapf(ErrMemoryItemNotFound, "summary %q", id)
+}
+
+// Search runs a full text search over the session's files.
+func (s *Store) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, errors.New("query is empty")
+	}
+
+	chunks, err := s.storage.Search(ctx, s.keys.Project, query, s.basePath(), limit)
+	if err != nil {
+		if isMemoryColdStartNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "search memory")
+	}
+
+	hits := make([]SearchHit, 0, len(chunks))
+	for _, chunk := range chunks {
+		hits = append(hits, SearchHit{
+			Path:    strings.TrimPrefix(chunk.FilePath, s.basePath()+"/"),
+			Content: chunk.Content,
+			Score:   chunk.Score,
+		})
+	}
+	return hits, nil
+}
+
+// Maintain runs the engine's maintenance, which compacts the runtime
+// context and expires old facts.
+func (s *Store) Maintain(ctx context.Context) error {
+	return runMemoryMaintenance(ctx, s.engine, s.keys)
+}
+
+// Export returns every file of the session.
+func (s *Store) Export(ctx context.Context) (*Export, error) {
+	files, err := s.listFiles(ctx, s.basePath(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{
+		Project:    s.keys.Project,
+		SessionID:  s.keys.SessionID,
+		ExportedAt: s.now().UTC(),
+		Files:      make([]ExportFile, 0, len(files)),
+	}
+	for _, f := range files {
+		body, err := s.storage.Read(ctx, s.keys.Project, f.Path, 0, -1)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", f.Path)
+		}
+		export.Files = append(export.Files, ExportFile{
+			Path:    strings.TrimPrefix(f.Path, s.basePath()+"/"),
+			Content: body,
+		})
+	}
+
+	return export, nil
+}
+
+// Erase deletes the whole session, the next turn starts without memory.
+func (s *Store) Erase(ctx context.Context) error {
+	if s.keys.SessionID == "" {
+		return errors.New("memory session is empty")
+	}
+	if err := s.storage.Delete(ctx, s.keys.Project, s.basePath(), true); err != nil &&
+		!isMemoryColdStartNotFound(err) {
+		return errors.Wrap(err, "delete memory session")
+	}
+	return nil
+}
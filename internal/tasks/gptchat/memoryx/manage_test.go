@@ -0,0 +1,211 @@
+package memoryx
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laisky/go-utils/v6/agents/files"
+	"github.com/Laisky/go-utils/v6/agents/memory"
+	memorystorage "github.com/Laisky/go-utils/v6/agents/memory/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// memStorage is an in-memory memorystorage.Engine.
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func (m *memStorage) Read(_ context.Context, project, path string, _, _ int64) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	body, ok := m.files[project+":"+path]
+	if !ok {
+		return "", &files.ToolError{Code: files.ErrorCodeNotFound, Message: path}
+	}
+	return body, nil
+}
+
+func (m *memStorage) Write(_ context.Context, project, path, content string, mode memorystorage.WriteMode, _ int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mode == memorystorage.WriteModeAppend {
+		m.files[project+":"+path] += content
+	} else {
+		m.files[project+":"+path] = content
+	}
+	return nil
+}
+
+func (m *memStorage) Stat(_ context.Context, project, path string) (memorystorage.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.files[project+":"+path]
+	return memorystorage.FileInfo{Path: path, Exists: ok, Type: memorystorage.FileTypeFile}, nil
+}
+
+func (m *memStorage) List(_ context.Context, project, path string, _, _ int) ([]memorystorage.FileInfo, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []memorystorage.FileInfo
+	for key := range m.files {
+		if p, ok := strings.CutPrefix(key, project+":"); ok && strings.HasPrefix(p, path+"/") {
+			entries = append(entries, memorystorage.FileInfo{Path: p, Exists: true, Type: memorystorage.FileTypeFile})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, false, nil
+}
+
+func (m *memStorage) Search(_ context.Context, project, query, pathPrefix string, _ int) ([]memorystorage.FileChunk, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var chunks []memorystorage.FileChunk
+	for key, body := range m.files {
+		if p, ok := strings.CutPrefix(key, project+":"); ok && strings.HasPrefix(p, pathPrefix) &&
+			strings.Contains(body, query) {
+			chunks = append(chunks, memorystorage.FileChunk{FilePath: p, Content: body, Score: 1})
+		}
+	}
+	return chunks, nil
+}
+
+func (m *memStorage) Delete(_ context.Context, project, path string, _ bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.files {
+		if p, ok := strings.CutPrefix(key, project+":"); ok && (p == path || strings.HasPrefix(p, path+"/")) {
+			delete(m.files, key)
+		}
+	}
+	return nil
+}
+
+func jsonLine(t *testing.T, v any) string {
+	t.Helper()
+	b, err := stdjson.Marshal(v)
+	require.NoError(t, err)
+	return string(b) + "\n"
+}
+
+// newTestStore returns a Store over a session holding two facts, a compact
+// summary and an insight.
+func newTestStore(t *testing.T) (*Store, *memStorage) {
+	t.Helper()
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	st := &memStorage{files: map[string]string{}}
+	name := memory.MemoryFact{ID: "t1-fact-name", TS: "2026-10-17T08:00:00Z", Type: "fact_upsert",
+		FactID: "user_name", Key: "name", Value: "alice", Confidence: 0.95, Tier: "L0", State: "active"}
+	lang := memory.MemoryFact{ID: "t2-fact-lang", TS: "2026-10-18T08:00:00Z", Type: "fact_upsert",
+		FactID: "preference", Key: "language", Value: "go", Confidence: 0.8, Tier: "L2", State: "active",
+		ExpiresAt: "2026-10-25T00:00:00Z"}
+	st.files["p:/memory/s1/memory_tiers/L0/2026/10/facts-202610.jsonl"] = jsonLine(t, name)
+	st.files["p:/memory/s1/memory_tiers/L2/2026/42/facts-2026-W42.jsonl"] = jsonLine(t, lang)
+	st.files["p:/memory/s1/events/compact/2026/10/17/compact-20261017.jsonl"] =
+		jsonLine(t, memory.LogEvent{ID: "compact-1", TS: "2026-10-17T10:00:00Z", Type: "compact", Summary: "talked about go"}) +
+			jsonLine(t, memory.LogEvent{ID: "compact-2", TS: "2026-10-17T11:00:00Z", Type: "compact", Summary: "talked about rust"})
+	st.files["p:/memory/s1/insights/2026/10/18/insights-20261018.jsonl"] =
+		jsonLine(t, memory.InsightRecord{ID: "insight-1", TS: "2026-10-18T01:00:00Z", Summary: "likes go"})
+	// another session of the project
+	st.files["p:/memory/s2/indexes/active_facts.json"] = "{}"
+
+	s := NewStore(st, &stubManagedEngine{}, RuntimeKeys{Project: "p", SessionID: "s1", UserID: "alice"})
+	s.now = func() time.Time { return now }
+	return s, st
+}
+
+func TestStoreFacts(t *testing.T) {
+	s, st := newTestStore(t)
+	ctx := context.Background()
+
+	facts, err := s.Facts(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, facts, 2, "the index is rebuilt from the tier shards")
+	require.Equal(t, "preference::language", facts[0].ID)
+	require.True(t, facts[1].Pinned)
+
+	facts, err = s.Facts(ctx, "ALI")
+	require.NoError(t, err)
+	require.Len(t, facts, 1)
+	require.Equal(t, "user_name::name", facts[0].ID)
+
+	value, pinned := "rust", true
+	fact, err := s.UpdateFact(ctx, "preference::language", FactUpdate{Value: &value, Pinned: &pinned})
+	require.NoError(t, err)
+	require.Equal(t, "rust", fact.Value)
+	require.True(t, fact.Pinned)
+	require.Empty(t, fact.ExpiresAt)
+	require.EqualValues(t, 1, fact.Confidence)
+	require.Contains(t, st.files["p:/memory/s1/memory_tiers/L0/2026/10/facts-202610.jsonl"], `"value":"rust"`)
+
+	pinned = false
+	fact, err = s.UpdateFact(ctx, "preference::language", FactUpdate{Pinned: &pinned})
+	require.NoError(t, err)
+	require.Equal(t, "L2", fact.Tier)
+	require.Equal(t, "2026-10-25T00:00:00Z", fact.ExpiresAt)
+
+	require.NoError(t, s.DeleteFact(ctx, "user_name::name"))
+	require.ErrorIs(t, s.DeleteFact(ctx, "user_name::name"), ErrMemoryItemNotFound)
+	_, err = s.UpdateFact(ctx, "nope::nope", FactUpdate{Value: &value})
+	require.ErrorIs(t, err, ErrMemoryItemNotFound)
+
+	facts, err = s.Facts(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, facts, 1)
+	require.Equal(t, "rust", facts[0].Value)
+
+	// edits survive an index rebuild
+	delete(st.files, "p:/memory/s1/indexes/active_facts.json")
+	rebuilt, err := s.Facts(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, facts, rebuilt)
+}
+
+func TestStoreSummaries(t *testing.T) {
+	s, st := newTestStore(t)
+	ctx := context.Background()
+
+	summaries, err := s.Summaries(ctx)
+	require.NoError(t, err)
+	require.Len(t, summaries, 3)
+	require.Equal(t, "insight-1", summaries[0].ID)
+	require.Equal(t, "insight", summaries[0].Kind)
+	require.Equal(t, "talked about rust", summaries[1].Text)
+
+	require.NoError(t, s.DeleteSummary(ctx, "compact-2"))
+	require.ErrorIs(t, s.DeleteSummary(ctx, "compact-2"), ErrMemoryItemNotFound)
+	body := st.files["p:/memory/s1/events/compact/2026/10/17/compact-20261017.jsonl"]
+	require.Contains(t, body, "compact-1")
+	require.NotContains(t, body, "compact-2")
+
+	hits, err := s.Search(ctx, "talked", 5)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	require.Equal(t, "events/compact/2026/10/17/compact-20261017.jsonl", hits[0].Path)
+}
+
+func TestStoreExportErase(t *testing.T) {
+	s, st := newTestStore(t)
+	ctx := context.Background()
+
+	export, err := s.Export(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "s1", export.SessionID)
+	require.Len(t, export.Files, 4, "other sessions are not exported")
+	require.Equal(t, "events/compact/2026/10/17/compact-20261017.jsonl", export.Files[0].Path)
+
+	require.NoError(t, s.Maintain(ctx))
+	require.Equal(t, 1, s.engine.(*stubManagedEngine).maintenanceCalls)
+
+	require.NoError(t, s.Erase(ctx))
+	require.Len(t, st.files, 1)
+	require.Contains(t, st.files, "p:/memory/s2/indexes/active_facts.json")
+	facts, err := s.Facts(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, facts)
+}
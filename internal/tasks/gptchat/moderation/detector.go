@@ -0,0 +1,147 @@
+// Package moderation detects PII and unwanted content in chat traffic,
+// and masks or blocks it according to configured rules.
+package moderation
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+// Finding is a span of text matched by a detector.
+type Finding struct {
+	// Detector is the name of the detector
+	Detector string
+	// Start and End are byte offsets into the text
+	Start, End int
+}
+
+// Detector finds spans of text.
+type Detector interface {
+	// Name returns the detector name, used in masks and errors
+	Name() string
+	// Detect returns the spans of text matched by the detector
+	Detect(text string) []Finding
+}
+
+// regexDetector matches a regexp, optionally filtered by valid.
+type regexDetector struct {
+	name string
+	re   *regexp.Regexp
+	// digitBound rejects matches glued to other digits, since RE2 has no
+	// lookbehind to express that
+	digitBound bool
+	valid      func(match string) bool
+}
+
+// NewRegexDetector returns a detector matching re.
+func NewRegexDetector(name string, re *regexp.Regexp) Detector {
+	return &regexDetector{name: name, re: re}
+}
+
+func (d *regexDetector) Name() string {
+	return d.name
+}
+
+func (d *regexDetector) Detect(text string) []Finding {
+	var findings []Finding
+	for _, loc := range d.re.FindAllStringIndex(text, -1) {
+		if d.digitBound && (isDigitAt(text, loc[0]-1) || isDigitAt(text, loc[1])) {
+			continue
+		}
+		if d.valid != nil && !d.valid(text[loc[0]:loc[1]]) {
+			continue
+		}
+		findings = append(findings, Finding{Detector: d.name, Start: loc[0], End: loc[1]})
+	}
+	return findings
+}
+
+func isDigitAt(text string, i int) bool {
+	return i >= 0 && i < len(text) && text[i] >= '0' && text[i] <= '9'
+}
+
+// NewDictionaryDetector returns a detector matching any of words,
+// case-insensitively.
+func NewDictionaryDetector(name string, words []string) Detector {
+	var quoted []string
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return &regexDetector{name: name, re: regexp.MustCompile(`[^\s\S]`)}
+	}
+
+	return &regexDetector{name: name, re: regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))}
+}
+
+var (
+	emailRegexp = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	// phoneRegexp matches international and local numbers, e.g.
+	// +86 138 0013 8000, (555) 123-4567, 13800138000
+	phoneRegexp = regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?|\d{2,4}[ .-]?)?\d{3,4}[ .-]?\d{3,4}`)
+	// apiKeyRegexp matches well-known secret formats
+	apiKeyRegexp = regexp.MustCompile(strings.Join([]string{
+		`\bsk-[A-Za-z0-9_-]{20,}`,
+		`\b(?:laisky|ramjet)-[A-Za-z0-9_-]{20,}`,
+		`\bgh[pousr]_[A-Za-z0-9]{36,}`,
+		`\bgithub_pat_[A-Za-z0-9_]{22,}`,
+		`\bAKIA[0-9A-Z]{16}\b`,
+		`\bAIza[0-9A-Za-z_-]{35}`,
+		`\bxox[abposr]-[A-Za-z0-9-]{10,}`,
+		`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`,
+		`-----BEGIN [A-Z ]*PRIVATE KEY-----`,
+	}, "|"))
+	// idNumberRegexp matches chinese resident ids and us social security
+	// numbers
+	idNumberRegexp = regexp.MustCompile(`\d{17}[\dXx]|\d{3}-\d{2}-\d{4}`)
+)
+
+// Builtin returns the builtin detector of name.
+func Builtin(name string) (Detector, bool) {
+	switch name {
+	case config.ModerationDetectorEmail:
+		return NewRegexDetector(name, emailRegexp), true
+	case config.ModerationDetectorPhone:
+		return &regexDetector{name: name, re: phoneRegexp, digitBound: true, valid: validPhone}, true
+	case config.ModerationDetectorAPIKey:
+		return NewRegexDetector(name, apiKeyRegexp), true
+	case config.ModerationDetectorIDNumber:
+		return &regexDetector{name: name, re: idNumberRegexp, digitBound: true, valid: validIDNumber}, true
+	default:
+		return nil, false
+	}
+}
+
+// validPhone requires 10 to 15 digits, as in E.164.
+func validPhone(match string) bool {
+	n := 0
+	for _, r := range match {
+		if unicode.IsDigit(r) {
+			n++
+		}
+	}
+	return n >= 10 && n <= 15
+}
+
+// validIDNumber checks the checksum of chinese resident ids, and the
+// reserved ranges of us social security numbers.
+func validIDNumber(match string) bool {
+	if len(match) == 11 {
+		area, group, serial := match[:3], match[4:6], match[7:]
+		return area != "000" && area != "666" && area[0] != '9' &&
+			group != "00" && serial != "0000"
+	}
+
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	sum := 0
+	for i, w := range weights {
+		sum += int(match[i]-'0') * w
+	}
+	check := "10X98765432"[sum%11]
+	return strings.ToUpper(match[17:]) == string(check)
+}
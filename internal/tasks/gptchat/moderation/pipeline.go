@@ -0,0 +1,201 @@
+package moderation
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+// ErrBlocked is returned for content matched by a block rule.
+var ErrBlocked = errors.New("content blocked by moderation")
+
+// BlockedError rejects content matched by a block rule.
+type BlockedError struct {
+	// Detector is the detector that matched
+	Detector string
+	// Category is the llm classifier's category, if any
+	Category string
+}
+
+func (e *BlockedError) Error() string {
+	if e.Category != "" {
+		return "content blocked by moderation: " + e.Detector + "/" + e.Category
+	}
+	return "content blocked by moderation: " + e.Detector
+}
+
+// Is makes errors.Is(err, ErrBlocked) hold.
+func (e *BlockedError) Is(target error) bool {
+	return target == ErrBlocked
+}
+
+// HTTPStatus returns 400.
+func (e *BlockedError) HTTPStatus() int {
+	return http.StatusBadRequest
+}
+
+// Classifier flags unwanted content, e.g. by asking an llm.
+type Classifier interface {
+	// Classify reports whether text is flagged and in which category
+	Classify(ctx context.Context, text string) (flagged bool, category string, err error)
+}
+
+type rule struct {
+	detector Detector
+	action   string
+}
+
+// Pipeline applies moderation rules to text. A nil *Pipeline lets
+// everything through unchanged.
+type Pipeline struct {
+	rules      []rule
+	classifier Classifier
+	scrubTools bool
+}
+
+// New builds the pipeline of cfg. classifier serves the llm detector and
+// may be nil if no rule uses it. It returns nil if moderation is disabled.
+func New(cfg config.ModerationConfig, classifier Classifier) (*Pipeline, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	p := &Pipeline{scrubTools: cfg.ScrubToolResults}
+	for _, r := range cfg.Rules {
+		switch r.Detector {
+		case config.ModerationDetectorLLM:
+			if classifier == nil {
+				return nil, errors.New("llm detector needs a classifier")
+			}
+			p.classifier = classifier
+		case config.ModerationDetectorDictionary:
+			p.rules = append(p.rules, rule{
+				detector: NewDictionaryDetector(r.Detector, cfg.Dictionary),
+				action:   r.Action,
+			})
+		default:
+			d, ok := Builtin(r.Detector)
+			if !ok {
+				return nil, errors.Errorf("unknown detector %q", r.Detector)
+			}
+			p.rules = append(p.rules, rule{detector: d, action: r.Action})
+		}
+	}
+
+	return p, nil
+}
+
+// findings returns the findings of rules with one of actions.
+func (p *Pipeline) findings(text string, actions ...string) []Finding {
+	var findings []Finding
+	for _, r := range p.rules {
+		for _, a := range actions {
+			if r.action == a {
+				findings = append(findings, r.detector.Detect(text)...)
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// Upstream moderates text before it is sent upstream. It fails with a
+// *BlockedError if a block rule matches, else masks the findings of
+// mask_upstream rules.
+func (p *Pipeline) Upstream(text string) (string, error) {
+	if p == nil || text == "" {
+		return text, nil
+	}
+
+	if blocked := p.findings(text, config.ModerationActionBlock); len(blocked) != 0 {
+		return "", &BlockedError{Detector: blocked[0].Detector}
+	}
+	return Mask(text, p.findings(text, config.ModerationActionMaskUpstream)), nil
+}
+
+// Persist masks text before it is stored, with every rule.
+func (p *Pipeline) Persist(text string) string {
+	if p == nil || text == "" {
+		return text
+	}
+
+	return Mask(text, p.findings(text,
+		config.ModerationActionBlock,
+		config.ModerationActionMaskUpstream,
+		config.ModerationActionMaskPersist,
+	))
+}
+
+// Scrub masks text from untrusted sources, like agent tool results, with
+// block and mask_upstream rules. It does nothing unless tool results are
+// configured to be scrubbed.
+func (p *Pipeline) Scrub(text string) string {
+	if p == nil || !p.scrubTools || text == "" {
+		return text
+	}
+
+	return Mask(text, p.findings(text,
+		config.ModerationActionBlock,
+		config.ModerationActionMaskUpstream,
+	))
+}
+
+// ScrubToolResults reports whether Scrub is enabled.
+func (p *Pipeline) ScrubToolResults() bool {
+	return p != nil && p.scrubTools
+}
+
+// Classify runs the llm classifier on text, failing with a *BlockedError
+// if it is flagged. It does nothing without a classifier.
+func (p *Pipeline) Classify(ctx context.Context, text string) error {
+	if p == nil || p.classifier == nil || strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	flagged, category, err := p.classifier.Classify(ctx, text)
+	if err != nil {
+		return errors.Wrap(err, "classify")
+	}
+	if flagged {
+		return &BlockedError{Detector: config.ModerationDetectorLLM, Category: category}
+	}
+	return nil
+}
+
+// Mask replaces the findings in text by `[REDACTED:<detector>]`.
+// Overlapping findings are merged into the earliest one.
+func Mask(text string, findings []Finding) string {
+	if len(findings) == 0 {
+		return text
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Start != findings[j].Start {
+			return findings[i].Start < findings[j].Start
+		}
+		return findings[i].End > findings[j].End
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		if f.End <= last {
+			continue
+		}
+		if f.Start < last {
+			// extend the previous mask over the overlap
+			last = f.End
+			continue
+		}
+		b.WriteString(text[last:f.Start])
+		b.WriteString("[REDACTED:" + f.Detector + "]")
+		last = f.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
@@ -81,22 +81,55 @@ func bindHTTP() {
 	// Static assets (JS/CSS) are no longer served from Go; the SPA handles all UI.
 	apiWithRatelimiter := grp.Group("", globalRatelimitMw)
 	apiWithRatelimiter.POST("/audit/conservation", ihttp.SaveLlmConservationHandler)
-	apiWithRatelimiter.Any("/api", ihttp.ChatHandler)
-	apiWithRatelimiter.POST("/images/generations", ihttp.DrawByDalleHandler)
-	apiWithRatelimiter.POST("/images/edits", ihttp.EditImageHandler)
+	apiWithRatelimiter.Any("/api", ihttp.RequireScope(ihttp.ScopeChat), ihttp.ChatHandler)
+	apiWithRatelimiter.POST("/images/generations", ihttp.RequireScope(ihttp.ScopeImage), ihttp.DrawByDalleHandler)
+	apiWithRatelimiter.POST("/images/edits", ihttp.RequireScope(ihttp.ScopeImage), ihttp.EditImageHandler)
 	// apiWithRatelimiter.POST("/images/generations/lcm", ihttp.DrawByLcmHandler)
-	apiWithRatelimiter.POST("/images/generations/flux/:model", ihttp.DrawByDalleHandler)
-	apiWithRatelimiter.POST("/images/edit/flux/:model", ihttp.EditImageHandler)
+	apiWithRatelimiter.POST("/images/generations/flux/:model", ihttp.RequireScope(ihttp.ScopeImage), ihttp.DrawByDalleHandler)
+	apiWithRatelimiter.POST("/images/edit/flux/:model", ihttp.RequireScope(ihttp.ScopeImage), ihttp.EditImageHandler)
 	// apiWithRatelimiter.POST("/images/generations/sdxl-turbo", ihttp.DrawBySdxlturboHandlerByNvidia)
-	apiWithRatelimiter.POST("/chat/oneshot", ihttp.OneShotChatHandler)
-	apiWithRatelimiter.POST("/files/chat", ihttp.UploadFiles)
-	apiWithRatelimiter.POST("/deepresearch", ihttp.CreateDeepResearchHandler)
-	apiWithRatelimiter.GET("/deepresearch/:task_id", ihttp.GetDeepResearchStatusHandler)
-	apiWithRatelimiter.GET("/audio/tts", ihttp.TTSHanler)
+	apiWithRatelimiter.POST("/images/jobs", ihttp.RequireScope(ihttp.ScopeImage), ihttp.SubmitImageJobHandler)
+	apiWithRatelimiter.GET("/images/jobs/:task_id", ihttp.RequireScope(ihttp.ScopeImage), ihttp.GetImageJobHandler)
+	grp.GET("/images/providers", ihttp.ListImageProvidersHandler)
+	apiWithRatelimiter.POST("/chat/oneshot", ihttp.RequireScope(ihttp.ScopeChat), ihttp.OneShotChatHandler)
+	apiWithRatelimiter.POST("/files/chat", ihttp.RequireScope(ihttp.ScopeFiles), ihttp.UploadFiles)
+	apiWithRatelimiter.POST("/deepresearch", ihttp.RequireScope(ihttp.ScopeAgent), ihttp.CreateDeepResearchHandler)
+	apiWithRatelimiter.GET("/deepresearch/:task_id", ihttp.RequireScope(ihttp.ScopeAgent), ihttp.GetDeepResearchStatusHandler)
+	apiWithRatelimiter.GET("/deepresearch/:task_id/export", ihttp.RequireScope(ihttp.ScopeAgent), ihttp.ExportDeepResearchHandler)
+	apiWithRatelimiter.GET("/audio/tts", ihttp.RequireScope(ihttp.ScopeTTS), ihttp.TTSHanler)
+	apiWithRatelimiter.POST("/audio/transcriptions", ihttp.RequireScope(ihttp.ScopeTTS), ihttp.TranscriptHandler)
+	grp.GET("/audio/providers", ihttp.ListVoiceProvidersHandler)
 	grp.GET("/user/me", ihttp.GetCurrentUser)
 	// grp.GET("/user/me/quota", ihttp.GetCurrentUserQuota)
 	apiWithRatelimiter.POST("/user/config", ihttp.UploadUserConfig)
+	apiWithRatelimiter.POST("/user/keys", ihttp.CreateAPIKeyHandler)
+	grp.GET("/user/keys", ihttp.ListAPIKeysHandler)
+	apiWithRatelimiter.POST("/user/keys/:id/rotate", ihttp.RotateAPIKeyHandler)
+	apiWithRatelimiter.DELETE("/user/keys/:id", ihttp.RevokeAPIKeyHandler)
+	grp.GET("/user/org", ihttp.GetOrganizationHandler)
+	grp.GET("/user/org/members", ihttp.ListOrgMembersHandler)
+	apiWithRatelimiter.POST("/user/org/members", ihttp.UpsertOrgMemberHandler)
+	apiWithRatelimiter.DELETE("/user/org/members/:username", ihttp.RemoveOrgMemberHandler)
+	grp.GET("/user/org/usage", ihttp.OrgUsageHandler)
+	grp.GET("/user/org/invite", ihttp.GetOrgInviteHandler)
+	apiWithRatelimiter.POST("/user/org/invite/accept", ihttp.AcceptOrgInviteHandler)
+	apiWithRatelimiter.DELETE("/user/org/invite", ihttp.DeclineOrgInviteHandler)
+	grp.GET("/user/usage", ihttp.GetUsageHandler)
 	grp.GET("/user/config", ihttp.DownloadUserConfig)
+	grp.GET("/user/memory", ihttp.RequireScope(ihttp.ScopeChat), ihttp.GetMemoryHandler)
+	grp.GET("/user/memory/search", ihttp.RequireScope(ihttp.ScopeChat), ihttp.SearchMemoryHandler)
+	grp.GET("/user/memory/export", ihttp.RequireScope(ihttp.ScopeChat), ihttp.ExportMemoryHandler)
+	apiWithRatelimiter.POST("/user/memory/facts/:id", ihttp.RequireScope(ihttp.ScopeChat), ihttp.UpdateMemoryFactHandler)
+	apiWithRatelimiter.DELETE("/user/memory/facts/:id", ihttp.RequireScope(ihttp.ScopeChat), ihttp.DeleteMemoryFactHandler)
+	apiWithRatelimiter.DELETE("/user/memory/summaries/:id", ihttp.RequireScope(ihttp.ScopeChat), ihttp.DeleteMemorySummaryHandler)
+	apiWithRatelimiter.POST("/user/memory/maintenance", ihttp.RequireScope(ihttp.ScopeChat), ihttp.RunMemoryMaintenanceHandler)
+	apiWithRatelimiter.DELETE("/user/memory", ihttp.EraseMemoryHandler)
+	// OpenAI-compatible gateway for third-party clients
+	gateway := grp.Group("/v1", ihttp.GatewayMiddleware, globalRatelimitMw)
+	gateway.POST("/chat/completions", ihttp.RequireScope(ihttp.ScopeChat), ihttp.GatewayChatCompletionsHandler)
+	gateway.POST("/responses", ihttp.RequireScope(ihttp.ScopeChat), ihttp.GatewayResponsesHandler)
+	gateway.POST("/embeddings", ihttp.RequireScope(ihttp.ScopeChat), ihttp.GatewayEmbeddingsHandler)
+	gateway.GET("/models", ihttp.GatewayModelsHandler)
 	apiWithRatelimiter.Any("/ramjet/*any", ihttp.RamjetProxyHandler)
 	grp.Any("/oneapi/*any", ihttp.OneapiProxyHandler)
 	grp.GET("/version", func(ctx *gin.Context) {
@@ -106,4 +139,6 @@ func bindHTTP() {
 	// payment
 	stripe.Key = config.Config.PaymentStripeKey
 	grp.POST("/create-payment-intent", ihttp.PaymentHandler)
+	grp.POST("/payment/stripe/webhook", ihttp.StripeWebhookHandler)
+	grp.GET("/payment/credit", ihttp.GetCreditBalanceHandler)
 }
@@ -1,9 +1,13 @@
 package gptchat
 
 import (
+	"context"
+	"time"
+
 	"github.com/Laisky/zap"
 
 	iconfig "github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	ihttp "github.com/Laisky/go-ramjet/internal/tasks/gptchat/http"
 	gptTasks "github.com/Laisky/go-ramjet/internal/tasks/gptchat/tasks"
 	"github.com/Laisky/go-ramjet/internal/tasks/store"
 	"github.com/Laisky/go-ramjet/library/log"
@@ -17,9 +21,23 @@ func bindTask() {
 	}
 
 	gptTasks.RunDynamicWebCrawler()
+	if iconfig.Config.UsageAnalytics.Enabled {
+		go store.TaskStore.TickerAfterRun(
+			time.Duration(iconfig.Config.UsageAnalytics.RollupIntervalSeconds)*time.Second, runUsageRollup)
+	}
 	bindHTTP()
 }
 
+// runUsageRollup refreshes the daily usage rollups.
+func runUsageRollup() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := ihttp.RollupUsage(ctx, time.Now()); err != nil {
+		log.Logger.Error("rollup gptchat usage", zap.Error(err))
+	}
+}
+
 func init() {
 	store.TaskStore.Store("gptchat", bindTask)
 }
@@ -94,20 +94,23 @@ func (u *bosUploader) Upload(fpath string) {
 
 	if localFileSize, err = u.CheckIsFileReady(fpath); err != nil {
 		log.Logger.Error("try to get file info error", zap.Error(err))
-		u.AddFaiFile(fpath)
+		u.AddFaiFile(fpath, err)
 		return
 	}
 
 	objName = u.getObjFname(fpath)
 	if remoteFileLen, err := u.loadRemoteFileLength(objName); err != nil {
 		log.Logger.Error("load remote file length", zap.String("fname", objName), zap.Error(err))
+		u.AddFaiFile(fpath, err)
 		return
 	} else if remoteFileLen != 0 {
 		if localFileSize < remoteFileLen {
-			log.Logger.Warn("will discard local file since of remote already exists", zap.String("file", objName))
-			// remove local file if local file is smaller than remote
+			// the local file was never verified against the remote, so it
+			// is kept and must not be marked uploaded
 			// TODO: download remote file and merge into local size, then upload the new file
-			u.AddSucFile(fpath)
+			err = errors.Errorf("local size %d is smaller than remote size %d", localFileSize, remoteFileLen)
+			log.Logger.Warn("keep local file since of remote is larger", zap.String("file", objName), zap.Error(err))
+			u.AddFaiFile(fpath, err)
 			return
 		}
 
@@ -121,16 +124,18 @@ func (u *bosUploader) Upload(fpath string) {
 	}
 	if err != nil {
 		log.Logger.Error("upload file got error", zap.Error(err))
-		u.AddFaiFile(fpath)
+		u.AddFaiFile(fpath, err)
 		return
 	}
 
 	if l, err := u.loadRemoteFileLength(objName); err != nil {
 		log.Logger.Error("load remote file length", zap.String("fname", objName), zap.Error(err))
+		u.AddFaiFile(fpath, err)
 		return
-	} else if l == 0 { // double check after uploading
-		u.AddFaiFile(fpath)
-		log.Logger.Error("file not exists after upload")
+	} else if l != localFileSize { // double check after uploading
+		err = errors.Errorf("remote size %d mismatch local size %d", l, localFileSize)
+		log.Logger.Error("verify uploaded file", zap.String("fname", objName), zap.Error(err))
+		u.AddFaiFile(fpath, err)
 		return
 	}
 
@@ -26,6 +26,26 @@ func TestGenRsyncCMD(t *testing.T) {
 	}
 }
 
+func TestParseRsyncListSize(t *testing.T) {
+	out := "-rw-r--r--      1,234,567 2018/04/19 10:00:00 20180418.log.gz\n" +
+		"-rw-r--r--             12 2018/04/19 10:00:00 20180417.log.gz\n"
+	if got, err := backup.ParseRsyncListSize(out, "20180418.log.gz"); err != nil {
+		t.Errorf("%+v", err)
+	} else if got != 1234567 {
+		t.Errorf("expect 1234567, got %v", got)
+	}
+
+	if _, err := backup.ParseRsyncListSize(out, "20180416.log.gz"); err == nil {
+		t.Errorf("expect error for missing file")
+	}
+
+	expect := "rsync --list-only 172.16.4.110::ivilog_bak/20180418.log.gz"
+	r := backup.GenRsyncListCMD("/data/20180418.log.gz", "172.16.4.110::ivilog_bak/")
+	if strings.Join(r, " ") != expect {
+		t.Errorf("expect %v, got %v", expect, strings.Join(r, " "))
+	}
+}
+
 func TestRunSysCMD(t *testing.T) {
 	got, err := backup.RunSysCMD([]string{"uptime"})
 	if err != nil {
@@ -0,0 +1,157 @@
+package backup
+
+// Backup log files to local filesystem, like a NFS mount
+// Configs:
+//     mode: "local"
+//     dir: destination directory, files are copied into `dir/<name>/`
+//     fsync: sync the copied file before verifying, default true
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+type localArgs struct {
+	Dir   string
+	Fsync bool
+}
+
+type localUploader struct {
+	*baseUploader
+	args *localArgs
+}
+
+func (u *localUploader) New(st *Setting) error {
+	u.baseUploader = createBaseUploader(st)
+	u.args = &localArgs{
+		Dir:   st.ArgString("dir", ""),
+		Fsync: st.ArgBool("fsync", true),
+	}
+	if u.args.Dir == "" {
+		return errors.Errorf("local uploader %q requires dir", st.Name)
+	}
+
+	return nil
+}
+
+func (u *localUploader) getDstPath(fpath string) string {
+	return filepath.Join(u.args.Dir, u.GetName(), filepath.Base(fpath))
+}
+
+// fileSHA256 returns the size and sha256 of fpath.
+func fileSHA256(fpath string) (int64, []byte, error) {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "open file %q", fpath)
+	}
+	defer fp.Close() // nolint: errcheck
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, fp)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "read file %q", fpath)
+	}
+
+	return n, hasher.Sum(nil), nil
+}
+
+// copyFile copies src to dst through a temp file in the same directory,
+// so dst is either absent or complete. Returns the sha256 of src.
+func (u *localUploader) copyFile(src, dst string) (digest []byte, err error) {
+	if err = os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return nil, errors.Wrapf(err, "create dir of %q", dst)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file %q", src)
+	}
+	defer in.Close() // nolint: errcheck
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return nil, errors.Wrapf(err, "create temp file for %q", dst)
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	hasher := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(tmp, hasher), in); err != nil {
+		_ = tmp.Close()
+		return nil, errors.Wrapf(err, "copy %q", src)
+	}
+	if u.args.Fsync {
+		if err = tmp.Sync(); err != nil {
+			_ = tmp.Close()
+			return nil, errors.Wrapf(err, "sync %q", tmp.Name())
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, errors.Wrapf(err, "close %q", tmp.Name())
+	}
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		return nil, errors.Wrapf(err, "rename to %q", dst)
+	}
+
+	return hasher.Sum(nil), nil
+}
+
+// upload copies fpath to dst, then verifies the size and sha256 of dst.
+func (u *localUploader) upload(fpath, dst string, fsize int64) error {
+	digest, err := u.copyFile(fpath, dst)
+	if err != nil {
+		return err
+	}
+
+	dstSize, dstDigest, err := fileSHA256(dst)
+	if err != nil {
+		return err
+	}
+	if dstSize != fsize {
+		return errors.Errorf("remote size %d mismatch local size %d", dstSize, fsize)
+	}
+	if !bytes.Equal(dstDigest, digest) {
+		return errors.Errorf("remote sha256 mismatch local file %q", fpath)
+	}
+
+	return nil
+}
+
+func (u *localUploader) Upload(fpath string) {
+	log.Logger.Debug("uploading file...", zap.String("fpath", fpath))
+	defer u.Done()
+
+	if gconfig.Shared.GetBool("dry") {
+		log.Logger.Debug("upload", zap.String("fpath", fpath))
+		return
+	}
+
+	fsize, err := u.CheckIsFileReady(fpath)
+	if err != nil {
+		log.Logger.Error("try to get file info error", zap.Error(err))
+		u.AddFaiFile(fpath, err)
+		return
+	}
+
+	dst := u.getDstPath(fpath)
+	if err = u.upload(fpath, dst, fsize); err != nil {
+		log.Logger.Error("copy file got error", zap.String("dst", dst), zap.Error(err))
+		u.AddFaiFile(fpath, err)
+		return
+	}
+
+	u.AddSucFile(fpath)
+	log.Logger.Info("success uploaded file", zap.String("fpath", fpath), zap.String("dst", dst))
+}
+
+func (u *localUploader) Clean() {
+	u.CleanFiles()
+}
@@ -2,6 +2,7 @@
 package backup
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -25,9 +26,41 @@ type Setting struct {
 	Args      map[string]interface{}
 }
 
+// ArgString returns the string arg of key, or def if not set.
+func (st *Setting) ArgString(key, def string) string {
+	if v, ok := st.Args[key].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// ArgInt returns the integer arg of key, or def if not set.
+func (st *Setting) ArgInt(key string, def int64) int64 {
+	switch v := st.Args[key].(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	case float64:
+		return int64(v)
+	}
+	return def
+}
+
+// ArgBool returns the bool arg of key, or def if not set.
+func (st *Setting) ArgBool(key string, def bool) bool {
+	if v, ok := st.Args[key].(bool); ok {
+		return v
+	}
+	return def
+}
+
 // uploader do the uploading
 type uploader interface {
 	New(*Setting) error
+	ShouldUpload(string) bool
 	Upload(string)
 	Add(int)
 	Wait()
@@ -35,16 +68,32 @@ type uploader interface {
 }
 
 type baseUploader struct {
+	sync.Mutex
 	wg             *sync.WaitGroup
 	ST             *Setting
+	state          *uploadState
 	successedFiles []string
 	failedFiles    []string
 }
 
+// stateFilePath returns the path of the upload state file of st,
+// default to a hidden file in st.Path.
+func stateFilePath(st *Setting) string {
+	return st.ArgString("state_file",
+		filepath.Join(st.Path, fmt.Sprintf(".ramjet-backup-%s.json", st.Name)))
+}
+
 func createBaseUploader(st *Setting) *baseUploader {
+	state, err := loadUploadState(stateFilePath(st))
+	if err != nil {
+		log.Logger.Error("load upload state, start from empty state",
+			zap.String("name", st.Name), zap.Error(err))
+	}
+
 	return &baseUploader{
-		wg: &sync.WaitGroup{},
-		ST: st,
+		wg:    &sync.WaitGroup{},
+		ST:    st,
+		state: state,
 	}
 }
 
@@ -64,24 +113,49 @@ func (u *baseUploader) Done() {
 	u.wg.Done()
 }
 
-// AddSucFile save successed file
+// ShouldUpload reports whether fpath is not verified yet
+// and not waiting for the backoff of its last failure
+func (u *baseUploader) ShouldUpload(fpath string) bool {
+	return u.state.ShouldUpload(fpath, time.Now())
+}
+
+// AddSucFile save successed file, the file must have been verified
 func (u *baseUploader) AddSucFile(fpath string) {
+	u.state.Verified(fpath, time.Now())
+
+	u.Lock()
+	defer u.Unlock()
 	u.successedFiles = append(u.successedFiles, fpath)
 }
 
-// AddFaiFile save failed file
-func (u *baseUploader) AddFaiFile(fpath string) {
+// AddFaiFile save failed file, it will be retried after backoff
+func (u *baseUploader) AddFaiFile(fpath string, cause error) {
+	u.state.Failed(fpath, cause, time.Now())
+
+	u.Lock()
+	defer u.Unlock()
 	u.failedFiles = append(u.failedFiles, fpath)
 }
 
-// CleanFiles remove failed files if IsReserve=false
+// CleanFiles remove verified files if IsReserve=false, then save the upload state
 func (u *baseUploader) CleanFiles() {
+	defer func() {
+		if err := u.state.Save(); err != nil {
+			log.Logger.Error("save upload state", zap.String("name", u.GetName()), zap.Error(err))
+		}
+	}()
+
 	if u.ST.IsReserve {
 		return
 	}
 	for _, fpath := range u.successedFiles {
+		if !u.state.IsVerified(fpath) {
+			log.Logger.Warn("file changed after uploaded, keep it", zap.String("fpath", fpath))
+			continue
+		}
 		if err := os.Remove(fpath); err != nil {
 			log.Logger.Error("remove file got error", zap.Error(err))
+			continue
 		}
 		log.Logger.Info("remove file", zap.String("fpath", fpath))
 	}
@@ -106,7 +180,7 @@ func (u *baseUploader) CheckIsFileReady(fpath string) (fsize int64, err error) {
 
 var (
 	interval      time.Duration
-	uploadTimeout = 1 * time.Second
+	uploadTimeout = 30 * time.Minute
 	backupLock    = &sync.Mutex{}
 )
 
@@ -199,6 +273,10 @@ func runTask() {
 			loader = &rsyncUploader{}
 		case "bos":
 			loader = &bosUploader{}
+		case "s3":
+			loader = &s3Uploader{}
+		case "local":
+			loader = &localUploader{}
 		default:
 			log.Logger.Error("got unknown upload mode", zap.String("mode", st.Mode))
 			continue
@@ -210,6 +288,11 @@ func runTask() {
 		}
 
 		for _, fpath = range ScanFiles(st.Path, st.Regex) {
+			if !loader.ShouldUpload(fpath) {
+				log.Logger.Debug("skip file", zap.String("fpath", fpath))
+				continue
+			}
+
 			loader.Add(1)
 			go loader.Upload(fpath)
 		}
@@ -8,7 +8,9 @@ package backup
 import (
 	"context"
 	"os/exec"
-	"regexp"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/Laisky/errors/v2"
 	gconfig "github.com/Laisky/go-config/v2"
@@ -45,21 +47,33 @@ func (u *rsyncUploader) Upload(fpath string) {
 
 	if fsize, err = u.CheckIsFileReady(fpath); err != nil {
 		log.Logger.Error("try to get file info error", zap.Error(err))
-		u.AddFaiFile(fpath)
+		u.AddFaiFile(fpath, err)
 		return
 	}
 
 	log.Logger.Debug("try to upload file via rsync", zap.Int64("fsize", fsize))
-	out, err := RunSysCMD(GenRsyncCMD(fpath, u.args.Remote))
-	if err != nil {
+	if _, err = RunSysCMD(GenRsyncCMD(fpath, u.args.Remote)); err != nil {
 		log.Logger.Error("run upload cmd error", zap.Error(err))
-		u.AddFaiFile(fpath)
+		u.AddFaiFile(fpath, err)
 		return
 	}
 
-	if matched, err := regexp.MatchString("", out); !matched || err != nil {
-		log.Logger.Error("upload got stderr", zap.Error(err))
-		u.AddFaiFile(fpath)
+	out, err := RunSysCMD(GenRsyncListCMD(fpath, u.args.Remote))
+	if err != nil {
+		log.Logger.Error("list remote file error", zap.Error(err))
+		u.AddFaiFile(fpath, err)
+		return
+	}
+	remoteSize, err := ParseRsyncListSize(out, filepath.Base(fpath))
+	if err != nil {
+		log.Logger.Error("parse remote file size error", zap.Error(err))
+		u.AddFaiFile(fpath, err)
+		return
+	}
+	if remoteSize != fsize {
+		err = errors.Errorf("remote size %d mismatch local size %d", remoteSize, fsize)
+		log.Logger.Error("verify uploaded file", zap.String("fpath", fpath), zap.Error(err))
+		u.AddFaiFile(fpath, err)
 		return
 	}
 
@@ -75,6 +89,30 @@ func GenRsyncCMD(fpath, remote string) (cmd []string) {
 	return []string{"rsync", "-tvhz", fpath, remote}
 }
 
+// GenRsyncListCMD generates the command listing the uploaded fpath in remote
+func GenRsyncListCMD(fpath, remote string) (cmd []string) {
+	return []string{"rsync", "--list-only", strings.TrimSuffix(remote, "/") + "/" + filepath.Base(fpath)}
+}
+
+// ParseRsyncListSize parses the size of fname from the output of `rsync --list-only`,
+// like `-rw-r--r--      1,234,567 2018/04/19 10:00:00 20180418.log.gz`
+func ParseRsyncListSize(out, fname string) (int64, error) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[len(fields)-1] != fname {
+			continue
+		}
+
+		size, err := strconv.ParseInt(strings.NewReplacer(",", "", ".", "").Replace(fields[1]), 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse size %q", fields[1])
+		}
+		return size, nil
+	}
+
+	return 0, errors.Errorf("file %q not found in remote", fname)
+}
+
 func RunSysCMD(cmd []string) (output string, err error) {
 	if gconfig.Shared.GetBool("debug") {
 		log.Logger.Debug("run cmd", zap.Strings("cmd", cmd))
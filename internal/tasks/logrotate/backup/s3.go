@@ -0,0 +1,327 @@
+package backup
+
+// Backup log files via S3-compatible object storage
+// Configs:
+//     mode: "s3"
+//     endpoint: s3 endpoint, like "s3.amazonaws.com"
+//     bucket: s3 bucket name
+//     access_key: s3 accessKey
+//     access_secret: s3 accessSecret
+//     prefix: object prefix, default to the name of setting
+//     secure: use https, default true
+//     storage_class: storage class of objects, like "STANDARD_IA"
+//     part_size: bytes of each multipart part, default 64MB
+//     threads: concurrent parts, default 4
+//     checksum: server-side checksum, "crc32c" (default), "crc32",
+//               "crc64nvme", "sha1", "sha256" or "none"
+//
+// Uploaded objects are verified by the sha256 the server computed, if it
+// reports a full object one, otherwise by size and etag.
+
+import (
+	"context"
+	"crypto/md5" // nolint: gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/zap"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/s3"
+)
+
+const (
+	defaultS3PartSize = 64 * 1024 * 1024
+	minS3PartSize     = 5 * 1024 * 1024
+	defaultS3Threads  = 4
+)
+
+// s3Checksums are the supported server-side checksums.
+var s3Checksums = map[string]minio.ChecksumType{
+	"none":      minio.ChecksumNone,
+	"crc32":     minio.ChecksumCRC32,
+	"crc32c":    minio.ChecksumCRC32C,
+	"crc64nvme": minio.ChecksumCRC64NVME,
+	"sha1":      minio.ChecksumSHA1,
+	"sha256":    minio.ChecksumSHA256,
+}
+
+type s3Args struct {
+	Endpoint     string
+	Bucket       string
+	AccessKey    string
+	AccessSecret string
+	Prefix       string
+	Secure       bool
+	StorageClass string
+	PartSize     uint64
+	Threads      uint
+	Checksum     minio.ChecksumType
+}
+
+// s3Client is the subset of *minio.Client used by s3Uploader.
+type s3Client interface {
+	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader,
+		objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	StatObject(ctx context.Context, bucketName, objectName string,
+		opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+}
+
+// newS3Client is replaced in tests.
+var newS3Client = func(args *s3Args) (s3Client, error) {
+	return s3.NewCli(args.Endpoint, args.AccessKey, args.AccessSecret, args.Secure)
+}
+
+type s3Uploader struct {
+	*baseUploader
+	args *s3Args
+	cli  s3Client
+}
+
+// parseS3Args parses the s3 args of st.
+func parseS3Args(st *Setting) (*s3Args, error) {
+	args := &s3Args{
+		Endpoint:     st.ArgString("endpoint", ""),
+		Bucket:       st.ArgString("bucket", ""),
+		AccessKey:    st.ArgString("access_key", ""),
+		AccessSecret: st.ArgString("access_secret", ""),
+		Prefix:       strings.Trim(st.ArgString("prefix", st.Name), "/"),
+		Secure:       st.ArgBool("secure", true),
+		StorageClass: st.ArgString("storage_class", ""),
+	}
+	if args.Endpoint == "" || args.Bucket == "" {
+		return nil, errors.Errorf("s3 uploader %q requires endpoint and bucket", st.Name)
+	}
+
+	partSize := st.ArgInt("part_size", defaultS3PartSize)
+	if partSize < minS3PartSize {
+		return nil, errors.Errorf("part_size should not be less than %d", minS3PartSize)
+	}
+	args.PartSize = uint64(partSize)
+
+	threads := st.ArgInt("threads", defaultS3Threads)
+	if threads <= 0 {
+		return nil, errors.New("threads should be positive")
+	}
+	args.Threads = uint(threads)
+
+	checksum, ok := s3Checksums[strings.ToLower(st.ArgString("checksum", "crc32c"))]
+	if !ok {
+		return nil, errors.Errorf("unknown checksum %q", st.ArgString("checksum", ""))
+	}
+	args.Checksum = checksum
+
+	return args, nil
+}
+
+func (u *s3Uploader) New(st *Setting) (err error) {
+	u.baseUploader = createBaseUploader(st)
+	if u.args, err = parseS3Args(st); err != nil {
+		return err
+	}
+
+	if u.cli, err = newS3Client(u.args); err != nil {
+		return errors.Wrapf(err, "connect to s3 %v", u.args.Endpoint)
+	}
+
+	return nil
+}
+
+func (u *s3Uploader) getObjName(fpath string) string {
+	return path.Join(u.args.Prefix, filepath.Base(fpath))
+}
+
+// statRemote returns the remote object of objName, nil if not exists.
+func (u *s3Uploader) statRemote(ctx context.Context, objName string) (*minio.ObjectInfo, error) {
+	// checksum mode asks the server for the checksums it computed
+	info, err := u.cli.StatObject(ctx, u.args.Bucket, objName, minio.StatObjectOptions{Checksum: true})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "stat object %q", objName)
+	}
+
+	return &info, nil
+}
+
+// s3Sums are the checksums of a local file, comparable to a remote object.
+type s3Sums struct {
+	// sha256 is base64 encoded, like x-amz-checksum-sha256
+	sha256 string
+	// etag is the md5 that s3 reports for an object uploaded with our part size
+	etag string
+}
+
+// hashFile reads fp once, computing its sha256 and the etag s3 would report.
+// Files larger than PartSize are uploaded by multipart, whose etag is
+// the md5 of all part md5s, suffixed with the number of parts.
+func (u *s3Uploader) hashFile(fp io.Reader, fsize int64) (*s3Sums, error) {
+	sha, whole := sha256.New(), md5.New()
+	var (
+		partSums []byte
+		parts    int
+	)
+	for {
+		part := md5.New()
+		n, err := io.CopyN(io.MultiWriter(sha, whole, part), fp, int64(u.args.PartSize))
+		if n > 0 {
+			partSums = append(partSums, part.Sum(nil)...)
+			parts++
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "read file")
+		}
+	}
+
+	sums := &s3Sums{
+		sha256: base64.StdEncoding.EncodeToString(sha.Sum(nil)),
+		etag:   hex.EncodeToString(whole.Sum(nil)),
+	}
+	if fsize > int64(u.args.PartSize) {
+		multi := md5.Sum(partSums)
+		sums.etag = fmt.Sprintf("%s-%d", hex.EncodeToString(multi[:]), parts)
+	}
+
+	return sums, nil
+}
+
+// fullObjectSHA256 returns the sha256 s3 computed over the whole remote object,
+// empty if the server did not report one. Composite checksums of multipart
+// uploads are checksums of part checksums, not comparable to the local sha256.
+func fullObjectSHA256(remote *minio.ObjectInfo) string {
+	if remote.ChecksumMode == "COMPOSITE" || strings.Contains(remote.ChecksumSHA256, "-") {
+		return ""
+	}
+
+	return remote.ChecksumSHA256
+}
+
+// matchRemote checks remote against the local file, by the server-computed
+// sha256 if there is one, otherwise by size and etag.
+func matchRemote(remote *minio.ObjectInfo, fsize int64, sums *s3Sums) error {
+	if remote.Size != fsize {
+		return errors.Errorf("remote size %d mismatch local size %d", remote.Size, fsize)
+	}
+
+	if sum := fullObjectSHA256(remote); sum != "" {
+		if sum != sums.sha256 {
+			return errors.Errorf("remote sha256 %q mismatch local sha256 %q", sum, sums.sha256)
+		}
+
+		return nil
+	}
+
+	if etag := strings.Trim(remote.ETag, `"`); etag != sums.etag {
+		return errors.Errorf("remote etag %q mismatch local etag %q", etag, sums.etag)
+	}
+
+	return nil
+}
+
+// upload uploads fpath to objName, then verifies the remote object.
+// An existing object is only kept if it matches the local file,
+// size alone does not tell a truncated upload.
+func (u *s3Uploader) upload(ctx context.Context, fpath, objName string, fsize int64) error {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return errors.Wrapf(err, "open file %q", fpath)
+	}
+	defer fp.Close() // nolint: errcheck
+
+	sums, err := u.hashFile(fp, fsize)
+	if err != nil {
+		return errors.Wrapf(err, "hash file %q", fpath)
+	}
+	if _, err = fp.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "seek file %q", fpath)
+	}
+
+	if remote, err := u.statRemote(ctx, objName); err != nil {
+		return err
+	} else if remote != nil {
+		if err = matchRemote(remote, fsize, sums); err == nil {
+			log.Logger.Info("remote object already exists", zap.String("object", objName))
+			return nil
+		}
+
+		log.Logger.Info("will replace remote by local file",
+			zap.String("object", objName), zap.Error(err))
+	}
+
+	// objects larger than PartSize are uploaded by multipart,
+	// reading parts concurrently from the file
+	uploaded, err := u.cli.PutObject(ctx, u.args.Bucket, objName, fp, fsize, minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		StorageClass: u.args.StorageClass,
+		PartSize:     u.args.PartSize,
+		NumThreads:   u.args.Threads,
+		Checksum:     u.args.Checksum,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "put object %q", objName)
+	}
+
+	remote, err := u.statRemote(ctx, objName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case remote == nil:
+		return errors.Errorf("object %q not exists after upload", objName)
+	case strings.Trim(remote.ETag, `"`) != strings.Trim(uploaded.ETag, `"`):
+		return errors.Errorf("remote etag %q mismatch uploaded etag %q", remote.ETag, uploaded.ETag)
+	}
+
+	return matchRemote(remote, fsize, sums)
+}
+
+func (u *s3Uploader) Upload(fpath string) {
+	log.Logger.Debug("uploading file...", zap.String("fpath", fpath))
+	defer u.Done()
+
+	if gconfig.Shared.GetBool("dry") {
+		log.Logger.Debug("upload", zap.String("fpath", fpath))
+		return
+	}
+
+	fsize, err := u.CheckIsFileReady(fpath)
+	if err != nil {
+		log.Logger.Error("try to get file info error", zap.Error(err))
+		u.AddFaiFile(fpath, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	objName := u.getObjName(fpath)
+	if err = u.upload(ctx, fpath, objName, fsize); err != nil {
+		log.Logger.Error("upload file got error", zap.String("object", objName), zap.Error(err))
+		u.AddFaiFile(fpath, err)
+		return
+	}
+
+	u.AddSucFile(fpath)
+	log.Logger.Info("success uploaded file",
+		zap.String("fpath", fpath),
+		zap.String("bucket", u.args.Bucket),
+		zap.String("object", objName))
+}
+
+func (u *s3Uploader) Clean() {
+	u.CleanFiles()
+}
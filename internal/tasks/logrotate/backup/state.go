@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+)
+
+var (
+	// retryBackoff is the delay before the first retry of a failed file,
+	// doubled by each following failure.
+	retryBackoff = time.Minute
+	// maxRetryBackoff caps the delay between retries.
+	maxRetryBackoff = 24 * time.Hour
+)
+
+// fileState is the upload state of a local file.
+type fileState struct {
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	Attempts  int       `json:"attempts"`
+	LastTry   time.Time `json:"last_try"`
+	LastError string    `json:"last_error,omitempty"`
+	// Verified is set once the remote copy matches the file.
+	Verified bool `json:"verified"`
+}
+
+// changed reports whether fi is not the file recorded in s.
+func (s *fileState) changed(fi os.FileInfo) bool {
+	return s.Size != fi.Size() || !s.ModTime.Equal(fi.ModTime())
+}
+
+// uploadState persists the per-file upload state of a setting,
+// so failed files are retried with backoff and reserved files
+// are not uploaded again.
+type uploadState struct {
+	mu    sync.Mutex
+	path  string
+	Files map[string]*fileState `json:"files"`
+}
+
+// loadUploadState loads the state from path, an empty state if path not exists.
+func loadUploadState(path string) (*uploadState, error) {
+	s := &uploadState{path: path, Files: map[string]*fileState{}}
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return s, errors.Wrapf(err, "read state file %q", path)
+	}
+
+	if err = json.Unmarshal(body, s); err != nil {
+		return &uploadState{path: path, Files: map[string]*fileState{}},
+			errors.Wrapf(err, "parse state file %q", path)
+	}
+	if s.Files == nil {
+		s.Files = map[string]*fileState{}
+	}
+
+	return s, nil
+}
+
+// ShouldUpload reports whether fpath needs uploading at now.
+func (s *uploadState) ShouldUpload(fpath string, now time.Time) bool {
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.Files[fpath]
+	if !ok || st.changed(fi) {
+		return true
+	}
+	if st.Verified {
+		return false
+	}
+
+	return !now.Before(st.LastTry.Add(backoffOf(st.Attempts)))
+}
+
+// backoffOf returns the delay after attempts failures.
+func backoffOf(attempts int) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+
+	d := retryBackoff
+	for i := 1; i < attempts && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryBackoff)
+}
+
+// record returns the state of fpath, reset if the file changed.
+// caller must hold s.mu.
+func (s *uploadState) record(fpath string) *fileState {
+	st, ok := s.Files[fpath]
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		if !ok {
+			st = &fileState{}
+			s.Files[fpath] = st
+		}
+		return st
+	}
+
+	if !ok || st.changed(fi) {
+		st = &fileState{Size: fi.Size(), ModTime: fi.ModTime()}
+		s.Files[fpath] = st
+	}
+	return st
+}
+
+// Failed records a failed attempt of fpath.
+func (s *uploadState) Failed(fpath string, cause error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.record(fpath)
+	st.Attempts++
+	st.LastTry = now
+	st.Verified = false
+	if cause != nil {
+		st.LastError = cause.Error()
+	}
+}
+
+// Verified records fpath as uploaded and verified.
+func (s *uploadState) Verified(fpath string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.record(fpath)
+	st.Attempts++
+	st.LastTry = now
+	st.LastError = ""
+	st.Verified = true
+}
+
+// IsVerified reports whether fpath has been verified and not changed since.
+func (s *uploadState) IsVerified(fpath string) bool {
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.Files[fpath]
+	return ok && st.Verified && !st.changed(fi)
+}
+
+// Save drops the files not exist anymore, then writes the state atomically.
+func (s *uploadState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for fpath := range s.Files {
+		if _, err := os.Stat(fpath); errors.Is(err, os.ErrNotExist) {
+			delete(s.Files, fpath)
+		}
+	}
+
+	body, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal state")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp state file")
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	if _, err = tmp.Write(body); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "write state file")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "close state file")
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return errors.Wrapf(err, "rename state file to %q", s.path)
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package backup
+
+import (
+	"context"
+	"crypto/md5" // nolint: gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+func writeFile(t *testing.T, fpath, content string) {
+	t.Helper()
+	if err := os.WriteFile(fpath, []byte(content), 0o644); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestUploadStateBackoff(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "20180418.log.gz")
+	writeFile(t, fpath, "hello")
+
+	statePath := filepath.Join(dir, "state.json")
+	state, err := loadUploadState(statePath)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	now := time.Now()
+	if !state.ShouldUpload(fpath, now) {
+		t.Fatalf("new file should be uploaded")
+	}
+
+	state.Failed(fpath, errors.New("boom"), now)
+	state.Failed(fpath, errors.New("boom"), now)
+	if state.ShouldUpload(fpath, now.Add(retryBackoff)) {
+		t.Fatalf("should wait for backoff after 2 failures")
+	}
+	if !state.ShouldUpload(fpath, now.Add(2*retryBackoff)) {
+		t.Fatalf("should retry after backoff")
+	}
+	if got := backoffOf(100); got != maxRetryBackoff {
+		t.Fatalf("expect backoff capped at %v, got %v", maxRetryBackoff, got)
+	}
+
+	// state survives restarts
+	state.Verified(fpath, now)
+	if err = state.Save(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if state, err = loadUploadState(statePath); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if state.ShouldUpload(fpath, now) || !state.IsVerified(fpath) {
+		t.Fatalf("verified file should not be uploaded again")
+	}
+
+	// changed file is uploaded again
+	writeFile(t, fpath, "hello world")
+	if !state.ShouldUpload(fpath, now) || state.IsVerified(fpath) {
+		t.Fatalf("changed file should be uploaded again")
+	}
+
+	// removed files are dropped from state
+	if err = os.Remove(fpath); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err = state.Save(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(state.Files) != 0 {
+		t.Fatalf("expect empty state, got %+v", state.Files)
+	}
+}
+
+func TestLocalUploader(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	fpath := filepath.Join(srcDir, "20180418.log.gz")
+	writeFile(t, fpath, "some logs")
+
+	u := &localUploader{}
+	if err := u.New(&Setting{
+		Name: "app",
+		Path: srcDir,
+		Args: map[string]interface{}{"dir": dstDir},
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	u.Add(1)
+	u.Upload(fpath)
+	u.Wait()
+	u.Clean()
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "app", "20180418.log.gz"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(got) != "some logs" {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if _, err = os.Stat(fpath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("verified file should be removed, got %v", err)
+	}
+	if _, err = os.Stat(stateFilePath(u.ST)); err != nil {
+		t.Fatalf("state file should be saved: %+v", err)
+	}
+
+	if err = (&localUploader{}).New(&Setting{Name: "app"}); err == nil {
+		t.Fatalf("expect error without dir")
+	}
+}
+
+type fakeS3Client struct {
+	objects map[string]minio.ObjectInfo
+	opts    minio.PutObjectOptions
+	// truncate simulates a broken upload
+	truncate bool
+	puts     int
+}
+
+func (f *fakeS3Client) PutObject(_ context.Context, _, objectName string, r io.Reader,
+	_ int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	if f.truncate {
+		body = body[:len(body)-1]
+	}
+
+	f.opts = opts
+	f.puts++
+	etag := md5.Sum(body) // nolint: gosec
+	info := minio.ObjectInfo{Key: objectName, Size: int64(len(body)), ETag: hex.EncodeToString(etag[:])}
+	if opts.Checksum == minio.ChecksumSHA256 {
+		sum := sha256.Sum256(body)
+		info.ChecksumSHA256 = base64.StdEncoding.EncodeToString(sum[:])
+		info.ChecksumMode = "FULL_OBJECT"
+	}
+	f.objects[objectName] = info
+	return minio.UploadInfo{Key: objectName, Size: info.Size, ETag: `"` + info.ETag + `"`}, nil
+}
+
+func (f *fakeS3Client) StatObject(_ context.Context, _, objectName string,
+	opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	info, ok := f.objects[objectName]
+	if !ok {
+		return info, minio.ErrorResponse{Code: minio.NoSuchKey}
+	}
+	if !opts.Checksum {
+		info.ChecksumSHA256, info.ChecksumMode = "", ""
+	}
+	return info, nil
+}
+
+func TestS3Uploader(t *testing.T) {
+	fake := &fakeS3Client{objects: map[string]minio.ObjectInfo{}}
+	origNew := newS3Client
+	newS3Client = func(*s3Args) (s3Client, error) { return fake, nil }
+	defer func() { newS3Client = origNew }()
+
+	dir := t.TempDir()
+	st := &Setting{
+		Name: "app",
+		Path: dir,
+		Args: map[string]interface{}{
+			"endpoint":      "s3.example.com",
+			"bucket":        "logs",
+			"prefix":        "/backups/app/",
+			"storage_class": "STANDARD_IA",
+			"part_size":     16 * 1024 * 1024,
+			"checksum":      "sha256",
+		},
+	}
+
+	fpath := filepath.Join(dir, "20180418.log.gz")
+	writeFile(t, fpath, "some logs")
+
+	// broken upload keeps the local file
+	fake.truncate = true
+	u := &s3Uploader{}
+	if err := u.New(st); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	u.Add(1)
+	u.Upload(fpath)
+	u.Wait()
+	u.Clean()
+	if _, err := os.Stat(fpath); err != nil {
+		t.Fatalf("unverified file should be kept: %+v", err)
+	}
+	if u.ShouldUpload(fpath) {
+		t.Fatalf("failed file should wait for backoff")
+	}
+	if fake.opts.StorageClass != "STANDARD_IA" ||
+		fake.opts.PartSize != 16*1024*1024 ||
+		fake.opts.Checksum != minio.ChecksumSHA256 {
+		t.Fatalf("unexpected put options %+v", fake.opts)
+	}
+
+	// retry succeeds after backoff
+	origBackoff := retryBackoff
+	retryBackoff = 0
+	defer func() { retryBackoff = origBackoff }()
+
+	fake.truncate = false
+	u = &s3Uploader{}
+	if err := u.New(st); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !u.ShouldUpload(fpath) {
+		t.Fatalf("failed file should be retried")
+	}
+	u.Add(1)
+	u.Upload(fpath)
+	u.Wait()
+	u.Clean()
+	if _, ok := fake.objects["backups/app/20180418.log.gz"]; !ok {
+		t.Fatalf("object not uploaded: %+v", fake.objects)
+	}
+	if _, err := os.Stat(fpath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("verified file should be removed, got %v", err)
+	}
+
+	// a remote object of the same size is replaced unless its sha256 matches
+	writeFile(t, fpath, "some logs")
+	fake.objects["backups/app/20180418.log.gz"] = minio.ObjectInfo{Size: 9,
+		ETag: fake.objects["backups/app/20180418.log.gz"].ETag, ChecksumSHA256: "other"}
+	fake.puts = 0
+	for i := 0; i < 2; i++ {
+		if err := u.upload(context.Background(), fpath, "backups/app/20180418.log.gz", 9); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if fake.puts != 1 {
+		t.Fatalf("expect the unverified object to be uploaded once, got %d puts", fake.puts)
+	}
+}
+
+func TestS3UploaderVerifyByETag(t *testing.T) {
+	fake := &fakeS3Client{objects: map[string]minio.ObjectInfo{}}
+	u := &s3Uploader{
+		args: &s3Args{Bucket: "logs", PartSize: minS3PartSize, Checksum: minio.ChecksumCRC32C},
+		cli:  fake,
+	}
+
+	fpath := filepath.Join(t.TempDir(), "20180418.log.gz")
+	writeFile(t, fpath, "some logs")
+
+	// without a server sha256, a truncated upload is told by its size
+	fake.truncate = true
+	if err := u.upload(context.Background(), fpath, "app.log.gz", 9); err == nil {
+		t.Fatalf("truncated upload should fail")
+	}
+
+	// and a corrupted object of the same size by its etag
+	fake.truncate = false
+	fake.objects["app.log.gz"] = minio.ObjectInfo{Size: 9, ETag: `"other"`}
+	fake.puts = 0
+	for i := 0; i < 2; i++ {
+		if err := u.upload(context.Background(), fpath, "app.log.gz", 9); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if fake.puts != 1 {
+		t.Fatalf("expect the mismatched object to be uploaded once, got %d puts", fake.puts)
+	}
+}
+
+func TestS3UploaderHashFile(t *testing.T) {
+	u := &s3Uploader{args: &s3Args{PartSize: minS3PartSize}}
+	body := strings.Repeat("a", minS3PartSize+1)
+	sums, err := u.hashFile(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	first, last := md5.Sum([]byte(body[:minS3PartSize])), md5.Sum([]byte(body[minS3PartSize:])) // nolint: gosec
+	multi := md5.Sum(append(first[:], last[:]...))                                              // nolint: gosec
+	if expect := hex.EncodeToString(multi[:]) + "-2"; sums.etag != expect {
+		t.Fatalf("expect multipart etag %q, got %q", expect, sums.etag)
+	}
+	sha := sha256.Sum256([]byte(body))
+	if expect := base64.StdEncoding.EncodeToString(sha[:]); sums.sha256 != expect {
+		t.Fatalf("expect sha256 %q, got %q", expect, sums.sha256)
+	}
+}
+
+func TestParseS3Args(t *testing.T) {
+	args, err := parseS3Args(&Setting{Name: "app", Args: map[string]interface{}{
+		"endpoint": "s3.example.com",
+		"bucket":   "logs",
+	}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if args.Prefix != "app" || !args.Secure ||
+		args.PartSize != defaultS3PartSize ||
+		args.Threads != defaultS3Threads ||
+		args.Checksum != minio.ChecksumCRC32C {
+		t.Fatalf("unexpected defaults %+v", args)
+	}
+
+	for _, extra := range []map[string]interface{}{
+		{"part_size": 1024},
+		{"threads": 0},
+		{"checksum": "md4"},
+		{"bucket": ""},
+	} {
+		st := &Setting{Name: "app", Args: map[string]interface{}{
+			"endpoint": "s3.example.com",
+			"bucket":   "logs",
+		}}
+		for k, v := range extra {
+			st.Args[k] = v
+		}
+		if _, err := parseS3Args(st); err == nil {
+			t.Errorf("expect error for %v", extra)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// Types of probes.
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeDNS  = "dns"
+	ProbeTLS  = "tls"
+	ProbeGRPC = "grpc"
+)
+
+const (
+	defaultProbeTimeout = 5 * time.Second
+	maxProbeBodySize    = 1 << 20
+)
+
+// prober checks a tenant once.
+type prober interface {
+	Probe(ctx context.Context) error
+}
+
+// tenantCfg is a tenant of `tasks.monitor.tenants`.
+type tenantCfg struct {
+	// Type is `http`, `tcp`, `dns`, `tls` or `grpc`.
+	Type string
+	// Interval is like `30s`, default to `tasks.monitor.interval`.
+	Interval time.Duration
+	// Timeout of each probe, default 5s.
+	Timeout time.Duration
+	// Retries is the consecutive failures tolerated before the tenant is down.
+	Retries int
+	// LatencySLO fails probes slower than it, 0 for no limit.
+	LatencySLO time.Duration `mapstructure:"latency_slo"`
+	// Receivers are names in `tasks.monitor.receivers`.
+	Receivers []string
+	// Severity of alerts, default critical.
+	Severity string
+
+	// URL, Method, Headers and Body of http probes.
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+	// ExpectStatus default to any 2xx.
+	ExpectStatus []int `mapstructure:"expect_status"`
+	// BodyRegex should match the response body.
+	BodyRegex string `mapstructure:"body_regex"`
+	// JSONPath maps paths like `data.items[0].status` to regexes of their values.
+	JSONPath           map[string]string `mapstructure:"json_path"`
+	InsecureSkipVerify bool              `mapstructure:"insecure_skip_verify"`
+
+	// Addr is the `host:port` of tcp, tls and grpc probes.
+	Addr       string
+	ServerName string `mapstructure:"server_name"`
+
+	// Host is resolved by dns probes, via Resolver (`host:port`) if set.
+	Host     string
+	Resolver string
+	// Expect are addresses the host should resolve to.
+	Expect []string
+
+	// Service is checked by grpc health probes, empty for the server.
+	Service string
+	// TLS dials grpc servers over tls.
+	TLS bool
+}
+
+// fillDefault fills defaults and checks cfg.
+func (c *tenantCfg) fillDefault(defaultInterval time.Duration) error {
+	if c.Interval == 0 {
+		c.Interval = defaultInterval
+	}
+	if c.Interval < time.Second {
+		return errors.Errorf("interval %v should be a duration like `30s`", c.Interval)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultProbeTimeout
+	}
+	if c.Retries < 0 {
+		return errors.New("retries should not be negative")
+	}
+
+	switch c.Type {
+	case ProbeHTTP:
+		if c.URL == "" {
+			return errors.New("http probe requires url")
+		}
+	case ProbeTCP, ProbeTLS, ProbeGRPC:
+		if c.Addr == "" {
+			return errors.Errorf("%s probe requires addr", c.Type)
+		}
+	case ProbeDNS:
+		if c.Host == "" {
+			return errors.New("dns probe requires host")
+		}
+	default:
+		return errors.Errorf("unknown probe type %q", c.Type)
+	}
+
+	return nil
+}
+
+// target returns what the tenant probes, like its url or addr.
+func (c *tenantCfg) target() string {
+	switch c.Type {
+	case ProbeHTTP:
+		return c.URL
+	case ProbeDNS:
+		return c.Host
+	default:
+		return c.Addr
+	}
+}
+
+// newProber builds the prober of cfg.
+func newProber(cfg *tenantCfg) (prober, error) {
+	switch cfg.Type {
+	case ProbeHTTP:
+		return newHTTPProber(cfg)
+	case ProbeTCP:
+		return &tcpProber{addr: cfg.Addr}, nil
+	case ProbeTLS:
+		return &tlsProber{addr: cfg.Addr, serverName: cfg.ServerName}, nil
+	case ProbeDNS:
+		return newDNSProber(cfg), nil
+	case ProbeGRPC:
+		return newGRPCProber(cfg), nil
+	default:
+		return nil, errors.Errorf("unknown probe type %q", cfg.Type)
+	}
+}
+
+// compileRegexes compiles the values of m.
+func compileRegexes(m map[string]string) (map[string]*regexp.Regexp, error) {
+	res := make(map[string]*regexp.Regexp, len(m))
+	for k, v := range m {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compile regex of %q", k)
+		}
+		res[k] = re
+	}
+	return res, nil
+}
@@ -0,0 +1,146 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net/http"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+// grpcHealthServing is `SERVING` of grpc.health.v1.HealthCheckResponse.ServingStatus.
+const grpcHealthServing = 1
+
+// grpcProber calls `grpc.health.v1.Health/Check` over http2,
+// h2c unless tls is enabled.
+type grpcProber struct {
+	url     string
+	service string
+	cli     *http.Client
+}
+
+func newGRPCProber(cfg *tenantCfg) *grpcProber {
+	protocols := new(http.Protocols)
+	scheme := "http"
+	if cfg.TLS {
+		protocols.SetHTTP2(true)
+		scheme = "https"
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return &grpcProber{
+		url:     scheme + "://" + cfg.Addr + "/grpc.health.v1.Health/Check",
+		service: cfg.Service,
+		cli: &http.Client{
+			Transport: &http.Transport{
+				Protocols: protocols,
+				TLSClientConfig: &tls.Config{
+					ServerName: cfg.ServerName,
+					MinVersion: tls.VersionTLS12,
+				},
+			},
+		},
+	}
+}
+
+// grpcFrame wraps msg as an uncompressed grpc message.
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// parseHealthStatus parses the status of a framed HealthCheckResponse.
+func parseHealthStatus(body []byte) (int, error) {
+	if len(body) < 5 {
+		return 0, errors.Errorf("grpc response too short: %d bytes", len(body))
+	}
+	if body[0] != 0 {
+		return 0, errors.New("compressed grpc response is not supported")
+	}
+	msg, size := body[5:], int(binary.BigEndian.Uint32(body[1:5]))
+	if size > len(msg) {
+		return 0, errors.Errorf("grpc message truncated, expect %d bytes", size)
+	}
+	msg = msg[:size]
+
+	status := 0
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return 0, errors.Wrap(protowire.ParseError(n), "parse grpc response")
+		}
+		msg = msg[n:]
+
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return 0, errors.Wrap(protowire.ParseError(n), "parse grpc status")
+			}
+			status, msg = int(v), msg[n:]
+			continue
+		}
+
+		if n = protowire.ConsumeFieldValue(num, typ, msg); n < 0 {
+			return 0, errors.Wrap(protowire.ParseError(n), "parse grpc response")
+		}
+		msg = msg[n:]
+	}
+
+	return status, nil
+}
+
+func (p *grpcProber) Probe(ctx context.Context) error {
+	var msg []byte
+	if p.service != "" {
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, p.service)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(grpcFrame(msg)))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := p.cli.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "call %s", p.url)
+	}
+	defer gutils.LogErr(resp.Body.Close, log.Logger) // nolint: errcheck,gosec
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("grpc got http status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+	if err != nil {
+		return errors.Wrap(err, "read grpc response")
+	}
+
+	// trailers-only responses carry the status in headers
+	grpcStatus, grpcMsg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if grpcStatus == "" {
+		grpcStatus, grpcMsg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if grpcStatus != "0" {
+		return errors.Errorf("grpc status %q: %s", grpcStatus, grpcMsg)
+	}
+
+	status, err := parseHealthStatus(body)
+	if err != nil {
+		return err
+	}
+	if status != grpcHealthServing {
+		return errors.Errorf("grpc health status %d, expect SERVING", status)
+	}
+	return nil
+}
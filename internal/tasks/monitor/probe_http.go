@@ -0,0 +1,168 @@
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+// httpProber requests an url and asserts on its response.
+type httpProber struct {
+	cfg      *tenantCfg
+	cli      *http.Client
+	bodyRe   *regexp.Regexp
+	jsonPath map[string]*regexp.Regexp
+}
+
+func newHTTPProber(cfg *tenantCfg) (*httpProber, error) {
+	p := &httpProber{
+		cfg: cfg,
+		cli: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: cfg.InsecureSkipVerify, //nolint:gosec // opt-in by config
+				},
+			},
+		},
+	}
+
+	var err error
+	if cfg.BodyRegex != "" {
+		if p.bodyRe, err = regexp.Compile(cfg.BodyRegex); err != nil {
+			return nil, errors.Wrap(err, "compile body_regex")
+		}
+	}
+	if p.jsonPath, err = compileRegexes(cfg.JSONPath); err != nil {
+		return nil, errors.Wrap(err, "compile json_path")
+	}
+
+	return p, nil
+}
+
+func (p *httpProber) Probe(ctx context.Context) error {
+	method := p.cfg.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if p.cfg.Body != "" {
+		body = strings.NewReader(p.cfg.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), p.cfg.URL, body)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	for k, v := range p.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.cli.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "request %s", p.cfg.URL)
+	}
+	defer gutils.LogErr(resp.Body.Close, log.Logger) // nolint: errcheck,gosec
+
+	if len(p.cfg.ExpectStatus) > 0 {
+		if !slices.Contains(p.cfg.ExpectStatus, resp.StatusCode) {
+			return errors.Errorf("got status %d, expect %v", resp.StatusCode, p.cfg.ExpectStatus)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("got status %d, expect 2xx", resp.StatusCode)
+	}
+
+	if p.bodyRe == nil && len(p.jsonPath) == 0 {
+		return nil
+	}
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+	if err != nil {
+		return errors.Wrap(err, "read body")
+	}
+	if p.bodyRe != nil && !p.bodyRe.Match(respBody) {
+		return errors.Errorf("body not match %q", p.cfg.BodyRegex)
+	}
+
+	if len(p.jsonPath) != 0 {
+		var doc any
+		if err = json.Unmarshal(respBody, &doc); err != nil {
+			return errors.Wrap(err, "parse json body")
+		}
+
+		for path, re := range p.jsonPath {
+			v, err := lookupJSONPath(doc, path)
+			if err != nil {
+				return err
+			}
+			if s := jsonValueString(v); !re.MatchString(s) {
+				return errors.Errorf("json path %q got %q, not match %q", path, s, re.String())
+			}
+		}
+	}
+
+	return nil
+}
+
+// lookupJSONPath returns the value of path like `$.data.items[0].status` in doc.
+func lookupJSONPath(doc any, path string) (any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	cur := doc
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			continue
+		}
+
+		name, rest, _ := strings.Cut(part, "[")
+		if name != "" {
+			obj, ok := cur.(map[string]any)
+			if !ok {
+				return nil, errors.Errorf("json path %q: %q is not in an object", path, name)
+			}
+			if cur, ok = obj[name]; !ok {
+				return nil, errors.Errorf("json path %q: %q not found", path, name)
+			}
+		}
+
+		for rest != "" {
+			var idxStr string
+			var ok bool
+			if idxStr, rest, ok = strings.Cut(rest, "]"); !ok {
+				return nil, errors.Errorf("json path %q: unclosed index", path)
+			}
+			rest = strings.TrimPrefix(rest, "[")
+
+			var idx int
+			if _, err := fmt.Sscanf(idxStr, "%d", &idx); err != nil {
+				return nil, errors.Errorf("json path %q: invalid index %q", path, idxStr)
+			}
+			arr, ok := cur.([]any)
+			if !ok || idx < 0 || idx >= len(arr) {
+				return nil, errors.Errorf("json path %q: index %d out of range", path, idx)
+			}
+			cur = arr[idx]
+		}
+	}
+
+	return cur, nil
+}
+
+// jsonValueString renders strings as is, other values as json.
+func jsonValueString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
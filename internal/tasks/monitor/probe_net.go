@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"slices"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+)
+
+// tcpProber connects to an address.
+type tcpProber struct {
+	addr string
+}
+
+func (p *tcpProber) Probe(ctx context.Context) error {
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return errors.Wrapf(err, "connect %s", p.addr)
+	}
+	gutils.SilentClose(conn)
+	return nil
+}
+
+// tlsProber completes a verified tls handshake with an address.
+type tlsProber struct {
+	addr       string
+	serverName string
+}
+
+func (p *tlsProber) Probe(ctx context.Context) error {
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: p.serverName, MinVersion: tls.VersionTLS12}}
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return errors.Wrapf(err, "tls handshake with %s", p.addr)
+	}
+	gutils.SilentClose(conn)
+	return nil
+}
+
+// dnsProber resolves a host, via a specific resolver if set.
+type dnsProber struct {
+	host     string
+	expect   []string
+	resolver *net.Resolver
+}
+
+func newDNSProber(cfg *tenantCfg) *dnsProber {
+	p := &dnsProber{
+		host:     cfg.Host,
+		expect:   cfg.Expect,
+		resolver: net.DefaultResolver,
+	}
+	if cfg.Resolver != "" {
+		p.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, network, cfg.Resolver)
+			},
+		}
+	}
+
+	return p
+}
+
+func (p *dnsProber) Probe(ctx context.Context) error {
+	addrs, err := p.resolver.LookupHost(ctx, p.host)
+	if err != nil {
+		return errors.Wrapf(err, "resolve %s", p.host)
+	}
+	if len(addrs) == 0 {
+		return errors.Errorf("resolve %s got no address", p.host)
+	}
+
+	for _, want := range p.expect {
+		if !slices.Contains(addrs, want) {
+			return errors.Errorf("resolve %s got %v, expect %s", p.host, addrs, want)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/status"
+	"github.com/Laisky/go-ramjet/internal/tasks/store"
+)
+
+func TestHTTPProber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"ok","data":{"items":[{"count":3}]}}`))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name string
+		cfg  tenantCfg
+		err  string
+	}{
+		{name: "ok", cfg: tenantCfg{
+			Headers:   map[string]string{"X-Token": "secret"},
+			BodyRegex: `"status":"ok"`,
+			JSONPath:  map[string]string{"$.status": "^ok$", "data.items[0].count": "^3$"},
+		}},
+		{name: "status", cfg: tenantCfg{}, err: "got status 401"},
+		{name: "expect status", cfg: tenantCfg{ExpectStatus: []int{401}}},
+		{name: "body regex", cfg: tenantCfg{
+			Headers:   map[string]string{"X-Token": "secret"},
+			BodyRegex: "degraded",
+		}, err: "body not match"},
+		{name: "json path value", cfg: tenantCfg{
+			Headers:  map[string]string{"X-Token": "secret"},
+			JSONPath: map[string]string{"data.items[0].count": "^4$"},
+		}, err: `got "3"`},
+		{name: "json path missing", cfg: tenantCfg{
+			Headers:  map[string]string{"X-Token": "secret"},
+			JSONPath: map[string]string{"data.items[1].count": "."},
+		}, err: "out of range"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.Type, cfg.URL = ProbeHTTP, srv.URL
+			require.NoError(t, cfg.fillDefault(time.Minute))
+			p, err := newProber(&cfg)
+			require.NoError(t, err)
+
+			err = p.Probe(context.Background())
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestTCPProber(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+
+	p := &tcpProber{addr: addr}
+	require.NoError(t, p.Probe(context.Background()))
+
+	require.NoError(t, ln.Close())
+	require.Error(t, p.Probe(context.Background()))
+}
+
+func TestGRPCProber(t *testing.T) {
+	servingStatus := uint64(grpcHealthServing)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/grpc.health.v1.Health/Check", r.URL.Path)
+		require.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+
+		msg := protowire.AppendTag(nil, 1, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, servingStatus)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(grpcFrame(msg))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	cfg := &tenantCfg{Type: ProbeGRPC, Addr: strings.TrimPrefix(srv.URL, "http://"), Service: "app"}
+	require.NoError(t, cfg.fillDefault(time.Minute))
+	p, err := newProber(cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Probe(context.Background()))
+
+	servingStatus = 2 // NOT_SERVING
+	require.ErrorContains(t, p.Probe(context.Background()), "expect SERVING")
+}
+
+type fakeProber struct {
+	err error
+}
+
+func (p *fakeProber) Probe(context.Context) error {
+	return p.err
+}
+
+func TestTenantTransitions(t *testing.T) {
+	var events []*ProbeTransition
+	origPublish := publishEvent
+	var checks []*status.Check
+	publishEvent = func(name string, _ map[string]interface{}, ret interface{}, _ error) {
+		switch name {
+		case ProbeStateEvt:
+			events = append(events, ret.(*ProbeTransition))
+		case status.CheckEvt:
+			checks = append(checks, ret.(*status.Check))
+		default:
+			t.Fatalf("unexpected event %q", name)
+		}
+	}
+	defer func() { publishEvent = origPublish }()
+
+	p := &fakeProber{}
+	tn := &tenant{name: "blog", prober: p, cfg: &tenantCfg{Type: ProbeTCP, Addr: "x:1", Retries: 1}}
+	require.NoError(t, tn.cfg.fillDefault(time.Minute))
+
+	steps := []struct {
+		err    error
+		events int
+	}{
+		{nil, 1},                // unknown -> up
+		{errors.New("boom"), 1}, // tolerated by retries
+		{errors.New("boom"), 2}, // up -> down
+		{errors.New("boom"), 2}, // still down
+		{nil, 3},                // down -> up
+	}
+	for i, step := range steps {
+		p.err = step.err
+		require.True(t, tn.state.due(time.Now(), 0))
+		tn.probe()
+		require.Len(t, events, step.events, "step %d", i)
+	}
+
+	require.Equal(t, StatusUnknown, events[0].From)
+	require.Equal(t, StatusUp, events[0].To)
+	require.Equal(t, StatusDown, events[1].To)
+	require.Equal(t, 2, events[1].Failures)
+	require.Equal(t, "tcp probe blog (x:1) is down", transitionAlert(events[1]).Summary)
+	require.Equal(t, StatusDown, events[2].From)
+	require.Equal(t, StatusUp, events[2].To)
+
+	// checks tolerated by retries are still up
+	require.Len(t, checks, len(steps))
+	for i, up := range []bool{true, true, false, false, true} {
+		require.Equal(t, up, checks[i].Up, "check %d", i)
+		require.Equal(t, "blog", checks[i].Service)
+	}
+
+	// slow probes violate the latency slo
+	tn.cfg.LatencySLO = time.Nanosecond
+	tn.cfg.Retries = 0
+	p.err = nil
+	tn.prober = proberFunc(func(context.Context) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	tn.probe()
+	require.Len(t, events, 4)
+	require.ErrorContains(t, events[3].Err, "exceeds slo")
+
+	// alerts are not sent for the initial up
+	alertOnTransition(&store.Event{Result: events[0]})
+}
+
+type proberFunc func(context.Context) error
+
+func (f proberFunc) Probe(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestTickInterval(t *testing.T) {
+	ts := []*tenant{
+		{cfg: &tenantCfg{Interval: 30 * time.Second}},
+		{cfg: &tenantCfg{Interval: 45 * time.Second}},
+	}
+	require.Equal(t, 15*time.Second, tickInterval(ts))
+	require.Equal(t, time.Second, tickInterval(nil))
+}
+
+func TestTenantCfgFillDefault(t *testing.T) {
+	cfg := &tenantCfg{Type: ProbeHTTP, URL: "http://localhost"}
+	require.NoError(t, cfg.fillDefault(time.Minute))
+	require.Equal(t, time.Minute, cfg.Interval)
+	require.Equal(t, defaultProbeTimeout, cfg.Timeout)
+
+	require.Error(t, (&tenantCfg{Type: ProbeHTTP, URL: "http://localhost", Interval: 30}).fillDefault(time.Minute))
+	require.Error(t, (&tenantCfg{Type: ProbeDNS}).fillDefault(time.Minute))
+	require.Error(t, (&tenantCfg{Type: "icmp"}).fillDefault(time.Minute))
+}
@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+)
+
+// Statuses of tenants.
+const (
+	StatusUnknown = "unknown"
+	StatusUp      = "up"
+	StatusDown    = "down"
+)
+
+// ProbeStateEvt is triggered when the status of a tenant changes,
+// its result is *ProbeTransition.
+const ProbeStateEvt = "monitor_probe_state"
+
+// ProbeTransition is a status change of a tenant.
+type ProbeTransition struct {
+	Tenant    string
+	Type      string
+	Target    string
+	From, To  string
+	Failures  int
+	Latency   time.Duration
+	Err       error
+	At        time.Time
+	Receivers []string
+	Severity  string
+}
+
+// tenantState tracks the status of a tenant across probes.
+type tenantState struct {
+	mu        sync.Mutex
+	status    string
+	failures  int
+	running   bool
+	nextRunAt time.Time
+}
+
+// observe records a probe result, the tenant is down after more than
+// retries consecutive failures, and up after any success.
+// Returns the status before and after, the consecutive failures,
+// and whether the status changed.
+func (s *tenantState) observe(err error, retries int) (from, to string, failures int, changed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from = s.status
+	if from == "" {
+		from = StatusUnknown
+	}
+
+	to = from
+	if err == nil {
+		s.failures = 0
+		to = StatusUp
+	} else {
+		s.failures++
+		if s.failures > retries {
+			to = StatusDown
+		}
+	}
+	s.status = to
+
+	return from, to, s.failures, from != to
+}
+
+// due marks the state as running and reports true if it should be probed at now.
+func (s *tenantState) due(now time.Time, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running || now.Before(s.nextRunAt) {
+		return false
+	}
+	s.running = true
+	s.nextRunAt = now.Add(interval)
+	return true
+}
+
+func (s *tenantState) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+}
@@ -1,137 +1,226 @@
 // Package monitor implements monitor task.
+//
+// Each tenant of `tasks.monitor.tenants` is probed at its own interval,
+// status changes are published as ProbeStateEvt events, which fire and
+// resolve alerts.
 package monitor
 
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Laisky/errors/v2"
 	gconfig "github.com/Laisky/go-config/v2"
-	gutils "github.com/Laisky/go-utils/v6"
 	"github.com/Laisky/zap"
 
+	"github.com/Laisky/go-ramjet/internal/tasks/status"
 	"github.com/Laisky/go-ramjet/internal/tasks/store"
 	alertManager "github.com/Laisky/go-ramjet/library/alert"
 	"github.com/Laisky/go-ramjet/library/log"
 )
 
+// tenant is a probed target.
+type tenant struct {
+	name   string
+	cfg    *tenantCfg
+	prober prober
+	state  tenantState
+}
+
 var (
-	httpClient = &http.Client{
-		Timeout: 5 * time.Second,
-	}
+	tenantsMu sync.RWMutex
+	tenants   []*tenant
+
+	// publishEvent is replaced in tests.
+	publishEvent = store.TaskStore.Trigger
 )
 
-func runTask() {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	log.Logger.Info("run monitor")
-	defer log.Logger.Info("monitor done")
-
-	result := &sync.Map{}
-	wg := &sync.WaitGroup{}
-
-	for name := range gconfig.Shared.Get("tasks.monitor.tenants").(map[string]interface{}) {
-		wg.Add(1)
-		switch gconfig.Shared.GetString("tasks.monitor.tenants." + name + ".type") {
-		case "http":
-			go checkHealthByHTTP(ctx, wg, name, gconfig.Shared.GetString("tasks.monitor.tenants."+name+".url"), result)
-		default:
-			log.Logger.Error("unknown type",
-				zap.String("type", gconfig.Shared.GetString("tasks.monitor.tenants."+name+".type")))
-		}
+// loadTenants loads `tasks.monitor.tenants`.
+func loadTenants() ([]*tenant, error) {
+	cfgs := map[string]*tenantCfg{}
+	if err := gconfig.Shared.UnmarshalKey("tasks.monitor.tenants", &cfgs); err != nil {
+		return nil, errors.Wrap(err, "unmarshal tasks.monitor.tenants")
 	}
 
-	wg.Wait()
-	alertForReceivers := map[string]string{}
-	for name := range gconfig.Shared.Get("tasks.monitor.tenants").(map[string]interface{}) {
-		for _, receiver := range gconfig.Shared.GetStringSlice("tasks.monitor.tenants." + name + ".receivers") {
-			var (
-				alert string
-				ok    bool
-			)
-			if alert, ok = alertForReceivers[receiver]; !ok {
-				alert = ""
-			}
-
-			if err, ok := result.Load(name); !ok {
-				log.Logger.Error("should contains monitor task", zap.String("name", name))
-				alert += fmt.Sprintf("should contains monitor task `%v`\n", name)
-				alert += "\n   ------------------\n\n"
-			} else if err != nil {
-				alert += fmt.Sprintf("monitor task `%v` is not health: %+v\n", name, err)
-				alert += "\n   ------------------\n\n"
-			}
-
-			alertForReceivers[receiver] = alert
+	defaultInterval := gconfig.Shared.GetDuration("tasks.monitor.interval") * time.Second
+	var ts []*tenant
+	for name, cfg := range cfgs {
+		if err := cfg.fillDefault(defaultInterval); err != nil {
+			return nil, errors.Wrapf(err, "tenant %q", name)
 		}
-	}
 
-	for receiver, alert := range alertForReceivers {
-		if alert == "" {
-			continue
+		p, err := newProber(cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tenant %q", name)
 		}
+		ts = append(ts, &tenant{name: name, cfg: cfg, prober: p})
+	}
+	slices.SortFunc(ts, func(a, b *tenant) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	return ts, nil
+}
 
-		alert = fmt.Sprintf("tested from: %v\n\n", gconfig.Shared.GetString("host")) + alert
-		alert = time.Now().Format(time.RFC3339) + "\n" + alert
-		if err := alertManager.Manager.Send(
-			gconfig.Shared.GetString("tasks.monitor.receivers."+receiver),
-			receiver,
-			"[google]ramjet monitor report",
-			alert,
-		); err != nil {
-			log.Logger.Error("try to send monitor alert email got error", zap.Error(err))
+// tickInterval returns the greatest common divisor of the intervals in seconds.
+func tickInterval(ts []*tenant) time.Duration {
+	gcd := 0
+	for _, t := range ts {
+		a := max(int(t.cfg.Interval/time.Second), 1)
+		for b := gcd; b != 0; {
+			a, b = b, a%b
 		}
+		gcd = a
 	}
+
+	return time.Duration(max(gcd, 1)) * time.Second
 }
 
-func BindTask() {
-	log.Logger.Info("bind monitor")
-	go store.TaskStore.TickerAfterRun(gconfig.Shared.GetDuration("tasks.monitor.interval")*time.Second, runTask)
+// runTask probes the due tenants.
+func runTask() {
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+
+	now := time.Now()
+	for _, t := range tenants {
+		if t.state.due(now, t.cfg.Interval) {
+			go t.probe()
+		}
+	}
 }
 
-func checkHealthByHTTP(ctx context.Context, wg *sync.WaitGroup, name, url string, result *sync.Map) {
-	log.Logger.Debug("checkHealthByHTTP", zap.String("name", name), zap.String("url", url))
-	defer wg.Done()
+// probe checks t once, and publishes its status change.
+func (t *tenant) probe() {
+	defer t.state.done()
+	ctx, cancel := context.WithTimeout(context.Background(), t.cfg.Timeout)
+	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		log.Logger.Warn("try to request url got error",
-			zap.Error(err),
-			zap.String("name", name),
-			zap.String("url", url))
-		result.Store(name, errors.Wrap(err, "try to create new request url got error"))
-		return
+	start := time.Now()
+	err := t.prober.Probe(ctx)
+	latency := time.Since(start)
+	if err == nil && t.cfg.LatencySLO > 0 && latency > t.cfg.LatencySLO {
+		err = errors.Errorf("latency %v exceeds slo %v", latency, t.cfg.LatencySLO)
 	}
 
-	resp, err := httpClient.Do(req) //nolint: bodyclose
+	from, to, failures, changed := t.state.observe(err, t.cfg.Retries)
+	publishEvent(status.CheckEvt, map[string]interface{}{"service": t.name}, &status.Check{
+		Service: t.name,
+		Source:  "monitor",
+		Up:      to != StatusDown,
+		Latency: latency,
+		At:      start,
+	}, err)
 	if err != nil {
-		log.Logger.Warn("try to request url got error",
-			zap.Error(err),
-			zap.String("name", name),
-			zap.String("url", url))
-		result.Store(name, errors.Wrap(err, "try to request url got error"))
+		log.Logger.Warn("probe failed",
+			zap.String("tenant", t.name),
+			zap.String("type", t.cfg.Type),
+			zap.String("status", to),
+			zap.Duration("latency", latency),
+			zap.Error(err))
+	} else {
+		log.Logger.Debug("probe succeeded",
+			zap.String("tenant", t.name),
+			zap.String("type", t.cfg.Type),
+			zap.Duration("latency", latency))
+	}
+	if !changed {
 		return
 	}
-	defer gutils.LogErr(resp.Body.Close, log.Logger) // nolint: errcheck,gosec
-
-	if err = gutils.CheckResp(resp); err != nil {
-		log.Logger.Warn("request url return error",
-			zap.Error(err),
-			zap.String("name", name),
-			zap.String("url", url))
-		result.Store(name, errors.Wrap(err, "request url return error"))
+
+	log.Logger.Info("tenant status changed",
+		zap.String("tenant", t.name),
+		zap.String("from", from),
+		zap.String("to", to))
+	publishEvent(ProbeStateEvt, map[string]interface{}{
+		"tenant": t.name,
+		"from":   from,
+		"to":     to,
+	}, &ProbeTransition{
+		Tenant:    t.name,
+		Type:      t.cfg.Type,
+		Target:    t.cfg.target(),
+		From:      from,
+		To:        to,
+		Failures:  failures,
+		Latency:   latency,
+		Err:       err,
+		At:        start,
+		Receivers: t.cfg.Receivers,
+		Severity:  t.cfg.Severity,
+	}, err)
+}
+
+// alertOnTransition fires alerts for tenants gone down,
+// and resolves them once the tenants are up again.
+// transitionAlert builds the alert of tr, summarized by the probe it failed.
+func transitionAlert(tr *ProbeTransition) alertManager.Alert {
+	a := alertManager.Alert{
+		Source:   "monitor",
+		Name:     "tenant_health",
+		Severity: tr.Severity,
+		Labels:   map[string]string{"tenant": tr.Tenant, "type": tr.Type, "target": tr.Target},
+		Summary:  fmt.Sprintf("%s probe %s (%s) is %s", tr.Type, tr.Tenant, tr.Target, tr.To),
+		Description: fmt.Sprintf("tested from: %v\n\nmonitor task `%v` is not health after %d failures: %+v",
+			gconfig.Shared.GetString("host"), tr.Tenant, tr.Failures, tr.Err),
+	}
+	if a.Severity == "" {
+		a.Severity = alertManager.SeverityCritical
+	}
+
+	return a
+}
+
+func alertOnTransition(evt *store.Event) {
+	tr, ok := evt.Result.(*ProbeTransition)
+	if !ok || (tr.To != StatusDown && tr.From != StatusDown) {
 		return
 	}
 
-	log.Logger.Debug("monitor task is good",
-		zap.String("name", name),
-		zap.String("url", url))
-	result.Store(name, nil)
+	a := transitionAlert(tr)
+	receivers := tr.Receivers
+	if len(receivers) == 0 {
+		receivers = []string{""}
+	}
+	for _, receiver := range receivers {
+		a.ToName = receiver
+		a.To = ""
+		if receiver != "" {
+			a.To = gconfig.Shared.GetString("tasks.monitor.receivers." + receiver)
+		}
+
+		var err error
+		if tr.To == StatusDown {
+			err = alertManager.Manager.Fire(context.Background(), a)
+		} else {
+			err = alertManager.Manager.Resolve(context.Background(), a)
+		}
+		if err != nil {
+			log.Logger.Error("try to send monitor alert got error", zap.Error(err))
+		}
+	}
+}
+
+func BindTask() {
+	log.Logger.Info("bind monitor")
+	ts, err := loadTenants()
+	if err != nil {
+		log.Logger.Panic("load monitor tenants", zap.Error(err))
+	}
+
+	tenantsMu.Lock()
+	tenants = ts
+	tenantsMu.Unlock()
+
+	tick := tickInterval(ts)
+	log.Logger.Info("monitor tenants", zap.Int("tenants", len(ts)), zap.Duration("tick", tick))
+	go store.TaskStore.TickerAfterRun(tick, runTask)
 }
 
 func init() {
 	store.TaskStore.Store("monitor", BindTask)
+	store.TaskStore.RegisterListener(ProbeStateEvt, "monitor-alert", alertOnTransition)
 }
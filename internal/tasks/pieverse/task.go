@@ -28,6 +28,7 @@ import (
 	gutils "github.com/Laisky/go-utils/v6"
 	"github.com/Laisky/zap"
 
+	"github.com/Laisky/go-ramjet/internal/tasks/status"
 	"github.com/Laisky/go-ramjet/internal/tasks/store"
 	"github.com/Laisky/go-ramjet/library/log"
 )
@@ -123,7 +124,10 @@ func runTask() {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultHTTPTimeout)
 	defer cancel()
 
-	if err := checkHealth(ctx, url); err != nil {
+	start := time.Now()
+	err := checkHealth(ctx, url)
+	status.Publish(taskName, taskName, time.Since(start), err)
+	if err != nil {
 		// Emit ERROR so the alert log pusher forwards it; no email is sent here.
 		log.Logger.Error("pieverse health check failed", zap.String("url", url), zap.Error(err))
 		return
@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/backup"
+)
+
+const (
+	formatPlain     = "plain"
+	formatCustom    = "custom"
+	formatDirectory = "directory"
+)
+
+var formatExts = map[string]string{formatPlain: "", formatCustom: ".dump", formatDirectory: ".tar"}
+
+// backupOptions is how a backup is dumped, compressed and encrypted.
+type backupOptions struct {
+	Format string
+	Jobs   int
+	backup.Encoding
+}
+
+// backupOptions returns the validated backup options of db.
+func (db cfgDB) backupOptions() (backupOptions, error) {
+	opts := backupOptions{Format: db.Format, Jobs: db.Jobs}
+	if opts.Format == "" {
+		opts.Format = formatPlain
+	}
+
+	if _, ok := formatExts[opts.Format]; !ok {
+		return opts, errors.Errorf("unknown format %q", opts.Format)
+	}
+	if opts.Jobs > 1 && opts.Format != formatDirectory {
+		return opts, errors.New("parallel jobs require the directory format")
+	}
+
+	var err error
+	opts.Encoding, err = backup.NewEncoding(db.Compression, db.Encryption)
+	return opts, err
+}
+
+// ext returns the object extension of the backup, plain gzip
+// backups keep the original `.gz`.
+func (o backupOptions) ext() string {
+	return formatExts[o.Format] + o.Encoding.Ext()
+}
+
+// pgDumpArgs builds the pg_dump arguments of db. dir is the output
+// directory of the directory format.
+func pgDumpArgs(db cfgDB, opts backupOptions, dir string) []string {
+	args := []string{
+		"-h", db.Host,
+		"-p", fmt.Sprintf("%d", db.Port),
+		"-U", db.User,
+		"-d", db.Database,
+	}
+
+	switch opts.Format {
+	case formatCustom:
+		// compressed outside of pg_dump
+		args = append(args, "-Fc", "-Z", "0")
+	case formatDirectory:
+		args = append(args, "-Fd", "-Z", "0", "-f", dir)
+		if opts.Jobs > 1 {
+			args = append(args, "-j", fmt.Sprintf("%d", opts.Jobs))
+		}
+	}
+
+	return args
+}
+
+// pgDumper dumps a database by pg_dump.
+type pgDumper struct {
+	db   cfgDB
+	opts backupOptions
+}
+
+// Engine implements backup.Engine.
+func (d *pgDumper) Engine() string { return "postgres" }
+
+// Ext implements backup.Engine.
+func (d *pgDumper) Ext() string { return formatExts[d.opts.Format] }
+
+// Dump implements backup.Dumper.
+// The directory format is dumped into a temporary directory under tempDir,
+// then archived by tar.
+func (d *pgDumper) Dump(ctx context.Context, w io.Writer, tempDir string) error {
+	if d.opts.Format != formatDirectory {
+		return runPGDump(ctx, d.db, pgDumpArgs(d.db, d.opts, ""), w)
+	}
+
+	dir, err := os.MkdirTemp(tempDir, "pg-dump-*")
+	if err != nil {
+		return errors.Wrap(err, "create temp dir")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	dumpDir := filepath.Join(dir, "dump")
+	if err = runPGDump(ctx, d.db, pgDumpArgs(d.db, d.opts, dumpDir), io.Discard); err != nil {
+		return err
+	}
+	if err = backup.TarDir(dumpDir, w); err != nil {
+		return errors.Wrap(err, "archive dump dir")
+	}
+	return nil
+}
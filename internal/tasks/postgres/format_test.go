@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/backup"
+)
+
+// TestBackupOptions verifies option defaults, validation and object keys.
+func TestBackupOptions(t *testing.T) {
+	opts, err := cfgDB{}.backupOptions()
+	require.NoError(t, err)
+	require.Equal(t, ".gz", opts.ext(), "default backups keep the legacy key")
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+	aesKeys := []backup.AESKey{{ID: "k1", Key: base64.StdEncoding.EncodeToString(key)}}
+
+	db := cfgDB{Database: "appdb", BackupFilePrefix: "backup/appdb/", Format: formatDirectory, Jobs: 4,
+		Compression: backup.CompressionZstd, Encryption: backup.Encryption{Method: backup.EncryptionAESGCM, Keys: aesKeys}}
+	opts, err = db.backupOptions()
+	require.NoError(t, err)
+	objectKey, fname := buildKeyAndFilename(db, opts, "20260101")
+	require.Equal(t, "backup/appdb/appdb-20260101.tar.zst.enc", objectKey)
+	require.Equal(t, "appdb-20260101.tar.zst.enc", fname)
+
+	date, parsed, ok := parseBackupKey("backup/appdb/appdb-", objectKey)
+	require.True(t, ok)
+	require.Equal(t, "20260101", date)
+	require.Equal(t, backupOptions{Format: formatDirectory, Encoding: backup.Encoding{
+		Compression: backup.CompressionZstd, Encryption: backup.EncryptionAESGCM}}, parsed)
+
+	// other engines share the prefix layout, but are not postgres backups
+	_, _, ok = parseBackupKey("backup/appdb/appdb-", "backup/appdb/appdb-20260101.rdb.gz")
+	require.False(t, ok)
+
+	require.Contains(t, strings.Join(pgDumpArgs(db, opts, "/tmp/d"), " "), "-Fd -Z 0 -f /tmp/d -j 4")
+
+	for _, bad := range []cfgDB{
+		{Format: "tar"},
+		{Compression: "lz4"},
+		{Format: formatCustom, Jobs: 2},
+		{Encryption: backup.Encryption{Method: backup.EncryptionAge}},
+		{Encryption: backup.Encryption{Method: backup.EncryptionAESGCM, Keys: []backup.AESKey{{ID: "k", Key: "c2hvcnQ="}}}},
+		{Encryption: backup.Encryption{Method: "rot13"}},
+	} {
+		_, err = bad.backupOptions()
+		require.Error(t, err)
+	}
+}
@@ -2,44 +2,32 @@
 package postgres
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
-	"path"
-	"slices"
-	"strings"
 	"time"
 
 	"github.com/Laisky/errors/v2"
 	gconfig "github.com/Laisky/go-config/v2"
-	gutils "github.com/Laisky/go-utils/v6"
 	"github.com/Laisky/zap"
-	"github.com/minio/minio-go/v7"
 
+	"github.com/Laisky/go-ramjet/internal/tasks/backup"
 	"github.com/Laisky/go-ramjet/internal/tasks/store"
 	"github.com/Laisky/go-ramjet/library/log"
-	"github.com/Laisky/go-ramjet/library/s3"
 )
 
 var logger = log.Logger.Named("postgres-backup")
 
 const (
-	defaultBackupHistoryLimit     = 14
-	backupObjectDateLayout        = "20060102"
-	backupRetentionCleanupTimeout = 10 * time.Minute
+	defaultBackupHistoryLimit = backup.DefaultKeepLast
 )
 
+// cfgS3 is where backups are stored, and how long they are kept.
 type cfgS3 struct {
-	Enable       bool
-	Endpoint     string
-	AccessKey    string
-	AccessSecret string
-	Bucket       string
-	KeepLast     int `mapstructure:"keep_last"`
+	backup.S3
+	backup.Retention
 }
 
 type cfgDB struct {
@@ -54,6 +42,16 @@ type cfgDB struct {
 	// Otherwise, it's treated as the filename prefix (optionally with directories),
 	// and the final object key will be: "<dir>/<prefix>-YYYYMMDD.gz".
 	BackupFilePrefix string `mapstructure:"backup_file_prefix"`
+	// Format is the pg_dump format, `plain` (default), `custom` or `directory`.
+	Format string
+	// Jobs is the number of parallel pg_dump/pg_restore jobs, directory format only.
+	Jobs int
+	// Compression is `gzip` (default) or `zstd`.
+	Compression string
+	// Encryption encrypts backups before uploading.
+	Encryption backup.Encryption
+	// Assertions are sanity queries run against each restored backup.
+	Assertions []cfgAssertion `mapstructure:"verify_assertions"`
 }
 
 type cfgBackup struct {
@@ -69,6 +67,9 @@ type cfgBackup struct {
 	// TempDir specifies where to place temporary backup files when
 	// UseTempFile is true. If empty, os.TempDir() will be used.
 	TempDir string `mapstructure:"temp_dir"`
+	// RestoreVerify periodically restores the latest backups into a
+	// scratch database to verify them.
+	RestoreVerify cfgRestoreVerify `mapstructure:"restore_verify"`
 }
 
 // loadCfg loads the postgres backup task configuration and applies local defaults.
@@ -77,15 +78,38 @@ func loadCfg() *cfgBackup {
 		Enable:      gconfig.Shared.GetBool("tasks.postgres.enable"),
 		IntervalSec: gconfig.Shared.GetInt("tasks.postgres.interval"),
 		S3: cfgS3{
-			Enable:       gconfig.Shared.GetBool("tasks.postgres.s3.enable"),
-			Endpoint:     gconfig.Shared.GetString("tasks.postgres.s3.endpoint"),
-			AccessKey:    gconfig.Shared.GetString("tasks.postgres.s3.access_key"),
-			AccessSecret: gconfig.Shared.GetString("tasks.postgres.s3.access_secret"),
-			Bucket:       gconfig.Shared.GetString("tasks.postgres.s3.bucket"),
-			KeepLast:     normalizeBackupHistoryLimit(gconfig.Shared.GetInt("tasks.postgres.s3.keep_last")),
+			S3: backup.S3{
+				Enable:       gconfig.Shared.GetBool("tasks.postgres.s3.enable"),
+				Endpoint:     gconfig.Shared.GetString("tasks.postgres.s3.endpoint"),
+				AccessKey:    gconfig.Shared.GetString("tasks.postgres.s3.access_key"),
+				AccessSecret: gconfig.Shared.GetString("tasks.postgres.s3.access_secret"),
+				Bucket:       gconfig.Shared.GetString("tasks.postgres.s3.bucket"),
+			},
+			Retention: backup.Retention{
+				KeepLast:     gconfig.Shared.GetInt("tasks.postgres.s3.keep_last"),
+				KeepDaily:    gconfig.Shared.GetInt("tasks.postgres.s3.keep_daily"),
+				KeepWeekly:   gconfig.Shared.GetInt("tasks.postgres.s3.keep_weekly"),
+				KeepMonthly:  gconfig.Shared.GetInt("tasks.postgres.s3.keep_monthly"),
+				KeepYearly:   gconfig.Shared.GetInt("tasks.postgres.s3.keep_yearly"),
+				MinAge:       gconfig.Shared.GetDuration("tasks.postgres.s3.min_age"),
+				MaxTotalSize: gconfig.Shared.GetInt64("tasks.postgres.s3.max_total_size"),
+			},
 		},
 		UseTempFile: gconfig.Shared.GetBool("tasks.postgres.use_temp_file"),
 		TempDir:     gconfig.Shared.GetString("tasks.postgres.temp_dir"),
+		RestoreVerify: cfgRestoreVerify{
+			Enable:      gconfig.Shared.GetBool("tasks.postgres.restore_verify.enable"),
+			IntervalSec: gconfig.Shared.GetInt("tasks.postgres.restore_verify.interval"),
+			ScratchDSN:  gconfig.Shared.GetString("tasks.postgres.restore_verify.scratch_dsn"),
+			DockerImage: gconfig.Shared.GetString("tasks.postgres.restore_verify.docker_image"),
+		},
+	}
+
+	// keep_last alone keeps working as before
+	others := cfg.S3.Retention
+	others.KeepLast = 0
+	if others.KeepsAll() {
+		cfg.S3.KeepLast = normalizeBackupHistoryLimit(cfg.S3.KeepLast)
 	}
 
 	// Load multiple databases: tasks.postgres.dbs: [ {host,port,user,password,database,backup_file_prefix}, ... ]
@@ -109,193 +133,42 @@ func normalizeBackupHistoryLimit(limit int) int {
 	return limit
 }
 
-// s3KeyFor builds the object key.
-// cleanS3Path cleans a user-provided S3 key/path to use forward slashes
-// and removes any leading slash.
-func cleanS3Path(p string) string {
-	p = strings.TrimSpace(p)
-	if p == "" {
-		return ""
-	}
-	p = strings.TrimPrefix(p, "/")
-	p = path.Clean(p)
-	if p == "." {
-		return ""
-	}
-	return p
-}
-
 // buildKeyPrefixAndBase derives the managed S3 key prefix and basename for one database backup series.
 func buildKeyPrefixAndBase(db cfgDB) (keyPrefix string, base string) {
-	pfx := strings.TrimSpace(db.BackupFilePrefix)
-	if strings.HasSuffix(pfx, "/") {
-		dir := cleanS3Path(pfx)
-		base = strings.TrimSpace(db.Database)
-		if base == "" {
-			base = "pg-backup"
-		}
-
-		if dir == "" {
-			return base + "-", base
-		}
-
-		return dir + "/" + base + "-", base
-	}
-
-	cleaned := cleanS3Path(pfx)
-	dir, base := path.Split(cleaned)
-	if strings.TrimSpace(base) == "" {
-		base = strings.TrimSpace(db.Database)
-		if base == "" {
-			base = "pg-backup"
-		}
-	}
-
-	if dir == "" {
-		return base + "-", base
-	}
-
-	return path.Clean(dir) + "/" + base + "-", base
+	return backup.KeyPrefix(db.BackupFilePrefix, db.Database, "pg-backup")
 }
 
 // buildKeyAndFilename computes the final S3 object key and a local filename (no slashes).
 // Rules:
 //   - If BackupFilePrefix ends with '/', treat it as directory prefix and use
-//     '<database>-YYYYMMDD.gz' as the basename.
+//     '<database>-YYYYMMDD<ext>' as the basename.
 //   - Else, treat BackupFilePrefix as the basename (optionally with directory), and append
-//     '-YYYYMMDD.gz'.
+//     '-YYYYMMDD<ext>'.
+//   - ext depends on the backup options, like `.gz` for plain gzip dumps
+//     or `.dump.zst.age` for encrypted custom format zstd dumps.
 //   - Local filename never contains '/'.
-func buildKeyAndFilename(db cfgDB, date string) (key string, filename string) {
+func buildKeyAndFilename(db cfgDB, opts backupOptions, date string) (key string, filename string) {
 	keyPrefix, base := buildKeyPrefixAndBase(db)
-	filename = fmt.Sprintf("%s-%s.gz", base, date)
-	return keyPrefix + date + ".gz", filename
+	filename = fmt.Sprintf("%s-%s%s", base, date, opts.ext())
+	return keyPrefix + date + opts.ext(), filename
 }
 
-// isManagedBackupKey reports whether key belongs to the configured backup series and naming scheme.
-func isManagedBackupKey(keyPrefix, key string) bool {
-	if !strings.HasPrefix(key, keyPrefix) || !strings.HasSuffix(key, ".gz") {
-		return false
+// parseBackupKey returns the date and options of a managed backup key.
+func parseBackupKey(keyPrefix, key string) (date string, opts backupOptions, ok bool) {
+	date, rawExt, enc, ok := backup.ParseKey(keyPrefix, key)
+	if !ok || enc.Compression == "" {
+		return "", opts, false
 	}
 
-	datePart := strings.TrimSuffix(strings.TrimPrefix(key, keyPrefix), ".gz")
-	if len(datePart) != len(backupObjectDateLayout) {
-		return false
-	}
-
-	if _, err := time.Parse(backupObjectDateLayout, datePart); err != nil {
-		return false
-	}
-
-	return true
-}
-
-// selectExpiredBackupKeys picks the oldest managed backup keys that exceed keepLast.
-func selectExpiredBackupKeys(keyPrefix string, keys []string, keepLast int) []string {
-	keepLast = normalizeBackupHistoryLimit(keepLast)
-
-	managedKeys := make([]string, 0, len(keys))
-	for _, key := range keys {
-		if isManagedBackupKey(keyPrefix, key) {
-			managedKeys = append(managedKeys, key)
+	for format, ext := range formatExts {
+		if ext == rawExt {
+			return date, backupOptions{Format: format, Encoding: enc}, true
 		}
 	}
-
-	if len(managedKeys) <= keepLast {
-		return nil
-	}
-
-	slices.Sort(managedKeys)
-	slices.Reverse(managedKeys)
-
-	expiredKeys := make([]string, 0, len(managedKeys)-keepLast)
-	expiredKeys = append(expiredKeys, managedKeys[keepLast:]...)
-	return expiredKeys
-}
-
-// cleanupExpiredBackups removes managed S3 backup objects that exceed the configured history limit.
-func cleanupExpiredBackups(ctx context.Context, s cfgS3, keyPrefix string) error {
-	s3cli, err := s3.GetCli(s.Endpoint, s.AccessKey, s.AccessSecret)
-	if err != nil {
-		return errors.Wrap(err, "new s3 client")
-	}
-
-	keys := make([]string, 0, s.KeepLast+1)
-	for obj := range s3cli.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: keyPrefix, Recursive: true}) {
-		if obj.Err != nil {
-			return errors.Wrapf(obj.Err, "list objects with prefix %q", keyPrefix)
-		}
-
-		keys = append(keys, obj.Key)
-	}
-
-	expiredKeys := selectExpiredBackupKeys(keyPrefix, keys, s.KeepLast)
-	if len(expiredKeys) == 0 {
-		logger.Debug("postgres backup retention within limit",
-			zap.String("bucket", s.Bucket),
-			zap.String("key_prefix", keyPrefix),
-			zap.Int("keep_last", s.KeepLast),
-			zap.Int("objects", len(keys)))
-		return nil
-	}
-
-	logger.Info("cleanup expired postgres backups",
-		zap.String("bucket", s.Bucket),
-		zap.String("key_prefix", keyPrefix),
-		zap.Int("keep_last", s.KeepLast),
-		zap.Int("delete_count", len(expiredKeys)))
-	for _, expiredKey := range expiredKeys {
-		if err := s3cli.RemoveObject(ctx, s.Bucket, expiredKey, minio.RemoveObjectOptions{}); err != nil {
-			return errors.Wrapf(err, "remove object %q", expiredKey)
-		}
-
-		logger.Info("deleted expired postgres backup",
-			zap.String("bucket", s.Bucket),
-			zap.String("object", expiredKey))
-	}
-
-	return nil
-}
-
-// startBackupRetentionCleanup launches background retention cleanup for one backup series.
-func startBackupRetentionCleanup(db cfgDB, s cfgS3, uploadedKey string) {
-	keyPrefix, _ := buildKeyPrefixAndBase(db)
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), backupRetentionCleanupTimeout)
-		defer cancel()
-
-		if err := cleanupExpiredBackups(ctx, s, keyPrefix); err != nil {
-			logger.Warn("postgres backup retention cleanup failed",
-				zap.String("bucket", s.Bucket),
-				zap.String("uploaded_object", uploadedKey),
-				zap.String("key_prefix", keyPrefix),
-				zap.Int("keep_last", s.KeepLast),
-				zap.Error(err))
-		}
-	}()
-}
-
-// s3ObjectExists checks if object already exists.
-func s3ObjectExists(ctx context.Context, s cfgS3, bucket, key string) (bool, error) {
-	s3cli, err := s3.GetCli(s.Endpoint, s.AccessKey, s.AccessSecret)
-	if err != nil {
-		return false, errors.Wrap(err, "new s3 client")
-	}
-
-	_, err = s3cli.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
-	if err == nil {
-		logger.Debug("s3 object exists", zap.String("bucket", bucket), zap.String("key", key))
-		return true, nil
-	}
-	resp := minio.ToErrorResponse(err)
-	if resp.StatusCode == http.StatusNotFound || strings.EqualFold(resp.Code, "NoSuchKey") {
-		logger.Debug("s3 object not found", zap.String("bucket", bucket), zap.String("key", key))
-		return false, nil
-	}
-	logger.Warn("failed to stat s3 object", zap.String("bucket", bucket), zap.String("key", key), zap.Error(err))
-	return false, err
+	return "", opts, false
 }
 
-// runBackup performs pg_dump -> gzip -> local file, then uploads to S3 if enabled.
+// runBackup performs pg_dump -> encoder -> S3 for every database.
 func runBackup() {
 	cfg := loadCfg()
 	if !cfg.Enable {
@@ -315,13 +188,13 @@ func runBackup() {
 		return
 	}
 
-	today := time.Now().UTC().Format(backupObjectDateLayout)
+	today := time.Now().UTC().Format(backup.DateLayout)
 	logger.Info("postgres backup run start",
 		zap.Bool("use_temp_file", cfg.UseTempFile),
 		zap.String("temp_dir", cfg.TempDir),
 		zap.String("s3_endpoint", cfg.S3.Endpoint),
 		zap.String("s3_bucket", cfg.S3.Bucket),
-		zap.Int("s3_keep_last", cfg.S3.KeepLast),
+		zap.Any("retention", cfg.S3.Retention),
 		zap.Int("dbs", len(dbs)))
 	perBackupTimeout := 30 * time.Minute
 
@@ -331,52 +204,43 @@ func runBackup() {
 		func() {
 			defer cancel()
 
-			// Compute S3 key and local filename
-			key, fname := buildKeyAndFilename(db, today)
+			opts, err := db.backupOptions()
+			if err != nil {
+				logger.Error("invalid postgres backup options", zap.String("db", db.Database), zap.Error(err))
+				return
+			}
 
+			keyPrefix, _ := buildKeyPrefixAndBase(db)
+			_, fname := buildKeyAndFilename(db, opts, today)
 			logger.Info("start postgres backup",
 				zap.String("file", fname),
 				zap.String("db", db.Database),
 				zap.String("pg_host", db.Host),
 				zap.Int("pg_port", db.Port),
-				zap.String("pg_user", db.User))
-
-			// Skip if today's backup for this DB already exists in S3
-			if exists, err := s3ObjectExists(ctx, cfg.S3, cfg.S3.Bucket, key); err != nil {
-				logger.Warn("cannot check existing backup; will proceed", zap.Error(err), zap.String("object", key))
-			} else if exists {
-				logger.Info("backup already exists; skip", zap.String("object", key))
-				return
+				zap.String("pg_user", db.User),
+				zap.String("format", opts.Format),
+				zap.String("compression", opts.Compression),
+				zap.String("encryption", opts.Encryption))
+
+			job := &backup.Job{
+				KeyPrefix:   keyPrefix,
+				Engine:      &pgDumper{db: db, opts: opts},
+				Encoding:    opts.Encoding,
+				Encryption:  db.Encryption,
+				S3:          cfg.S3.S3,
+				Retention:   cfg.S3.Retention,
+				UseTempFile: cfg.UseTempFile,
+				TempDir:     cfg.TempDir,
 			}
-
-			start := time.Now()
-			var err error
-			if cfg.UseTempFile {
-				err = backupViaTempFile(ctx, db, cfg.S3, fname, key, cfg.TempDir)
-			} else {
-				err = streamBackupToS3(ctx, db, cfg.S3, key)
-			}
-			if err != nil {
+			if key, err := job.Run(ctx, today); err != nil {
 				logger.Error("backup failed", zap.String("object", key), zap.Error(err))
-				return
 			}
-
-			startBackupRetentionCleanup(db, cfg.S3, key)
-			logger.Info("uploaded to s3", zap.String("object", key), zap.String("cost", gutils.CostSecs(time.Since(start))))
 		}()
 	}
 }
 
-// dumpAndGzipToWriter runs pg_dump and writes gzip-compressed output into w.
-func dumpAndGzipToWriter(ctx context.Context, db cfgDB, w io.Writer) error {
-	// Build pg_dump command
-	args := []string{
-		"-h", db.Host,
-		"-p", fmt.Sprintf("%d", db.Port),
-		"-U", db.User,
-		"-d", db.Database,
-	}
-
+// runPGDump runs pg_dump with args and copies its output into w.
+func runPGDump(ctx context.Context, db cfgDB, args []string, w io.Writer) error {
 	cmd := exec.CommandContext(ctx, "pg_dump", args...)
 	// Pass password via env var for non-interactive
 	env := os.Environ()
@@ -394,9 +258,6 @@ func dumpAndGzipToWriter(ctx context.Context, db cfgDB, w io.Writer) error {
 		return errors.Wrap(err, "StderrPipe")
 	}
 
-	gz := gzip.NewWriter(w)
-	defer func() { _ = gz.Close() }()
-
 	if err := cmd.Start(); err != nil {
 		logger.Error("failed to start pg_dump",
 			zap.Strings("args", args),
@@ -404,14 +265,11 @@ func dumpAndGzipToWriter(ctx context.Context, db cfgDB, w io.Writer) error {
 		return errors.Wrap(err, "start pg_dump")
 	}
 
-	// Stream pg_dump stdout into gzip writer
-	if n, err := io.Copy(gz, stdout); err != nil {
+	// Stream pg_dump stdout into the writer
+	if n, err := io.Copy(w, stdout); err != nil {
 		_ = cmd.Process.Kill() // ensure process terminated
 		logger.Error("stream copy failed", zap.Int64("bytes", n), zap.Error(err))
-		return errors.Wrap(err, "copy dump -> gzip")
-	}
-	if err := gz.Close(); err != nil {
-		return errors.Wrap(err, "close gzip")
+		return errors.Wrap(err, "copy dump")
 	}
 
 	// Read stderr for logging if non-empty
@@ -430,121 +288,6 @@ func dumpAndGzipToWriter(ctx context.Context, db cfgDB, w io.Writer) error {
 	return nil
 }
 
-// streamBackupToS3 connects a pipe between pg_dump->gzip and S3 PutObject.
-func streamBackupToS3(ctx context.Context, db cfgDB, s cfgS3, key string) error {
-	s3cli, err := s3.GetCli(s.Endpoint, s.AccessKey, s.AccessSecret)
-	if err != nil {
-		return errors.Wrap(err, "new s3 client")
-	}
-
-	pr, pw := io.Pipe()
-	errCh := make(chan error, 1)
-	go func() {
-		// CloseWithError ensures the reader sees error if any
-		err := dumpAndGzipToWriter(ctx, db, pw)
-		_ = pw.CloseWithError(err)
-		errCh <- err
-	}()
-
-	// Size -1 enables streaming multipart upload
-	logger.Info("s3 put (stream)", zap.String("bucket", s.Bucket), zap.String("key", key))
-	info, putErr := s3.PutObjectCappingVersions(ctx, logger, s3cli, s.Bucket, key, pr, -1, minio.PutObjectOptions{
-		ContentType:     "application/gzip",
-		ContentEncoding: "gzip",
-	}, s3.DefaultVersionsToKeep)
-
-	dumpErr := <-errCh // wait producer
-	if putErr != nil {
-		return errors.Wrap(putErr, "put object")
-	}
-
-	if dumpErr != nil {
-		return errors.Wrap(dumpErr, "pg_dump pipeline")
-	}
-
-	logger.Info("backup completed",
-		zap.String("object", key),
-		zap.Int64("uploaded_size", info.Size),
-		zap.String("etag", info.ETag))
-	return nil
-}
-
-// backupViaTempFile writes dump->gzip to a temporary file, then uploads that file to S3.
-// fname is the local filename (no slashes). key is the S3 object key.
-func backupViaTempFile(ctx context.Context, db cfgDB, s cfgS3, fname, key, tempDir string) error {
-	s3cli, err := s3.GetCli(s.Endpoint, s.AccessKey, s.AccessSecret)
-	if err != nil {
-		return errors.Wrap(err, "new s3 client")
-	}
-
-	if tempDir == "" {
-		tempDir = os.TempDir()
-	}
-	if err := os.MkdirAll(tempDir, 0o755); err != nil {
-		return errors.Wrap(err, "mkdir temp dir")
-	}
-
-	finalPath := tempDir + string(os.PathSeparator) + fname
-	tmpf, err := os.CreateTemp(tempDir, fname+".*")
-	if err != nil {
-		return errors.Wrap(err, "create temp file")
-	}
-	tmpPath := tmpf.Name()
-	_ = tmpf.Close()
-	// reopen for writing through our pipeline
-	wf, err := os.Create(tmpPath)
-	if err != nil {
-		return errors.Wrap(err, "open temp file for write")
-	}
-	logger.Info("dump to temp file", zap.String("tmp", tmpPath))
-	if err := dumpAndGzipToWriter(ctx, db, wf); err != nil {
-		_ = wf.Close()
-		_ = os.Remove(tmpPath)
-		return errors.Wrap(err, "dump to temp file")
-	}
-	if err := wf.Close(); err != nil {
-		_ = os.Remove(tmpPath)
-		return errors.Wrap(err, "close temp file")
-	}
-
-	// rename to final path for easier inspection (best effort)
-	if err := os.Rename(tmpPath, finalPath); err != nil {
-		logger.Warn("rename temp file failed; continue with tmp", zap.Error(err))
-		finalPath = tmpPath
-	}
-	// ensure cleanup
-	defer func() {
-		if err := os.Remove(finalPath); err != nil {
-			logger.Warn("cleanup temp file failed", zap.String("file", finalPath), zap.Error(err))
-		} else {
-			logger.Info("cleanup temp file", zap.String("file", finalPath))
-		}
-	}()
-
-	// stat size for upload
-	finfo, err := os.Stat(finalPath)
-	if err != nil {
-		return errors.Wrap(err, "stat temp file")
-	}
-	size := finfo.Size()
-	rf, err := os.Open(finalPath)
-	if err != nil {
-		return errors.Wrap(err, "open temp file for read")
-	}
-	defer rf.Close()
-
-	logger.Info("s3 put (file)", zap.String("bucket", s.Bucket), zap.String("key", key), zap.String("file", finalPath), zap.Int64("size", size))
-	info, putErr := s3.PutObjectCappingVersions(ctx, logger, s3cli, s.Bucket, key, rf, size, minio.PutObjectOptions{
-		ContentType:     "application/gzip",
-		ContentEncoding: "gzip",
-	}, s3.DefaultVersionsToKeep)
-	if putErr != nil {
-		return errors.Wrap(putErr, "put object")
-	}
-	logger.Info("backup completed (file)", zap.String("object", key), zap.Int64("uploaded_size", info.Size), zap.String("etag", info.ETag))
-	return nil
-}
-
 // bindPostgresBackupTask registers the scheduled postgres backup task when enabled.
 func bindPostgresBackupTask() {
 	logger.Info("bind postgres backup task...")
@@ -563,10 +306,22 @@ func bindPostgresBackupTask() {
 		zap.Bool("use_temp_file", cfg.UseTempFile),
 		zap.String("temp_dir", cfg.TempDir),
 		zap.Bool("s3_enable", cfg.S3.Enable),
-		zap.Int("s3_keep_last", cfg.S3.KeepLast),
+		zap.Any("retention", cfg.S3.Retention),
 		zap.String("s3_endpoint", cfg.S3.Endpoint),
 		zap.String("s3_bucket", cfg.S3.Bucket))
 	go store.TaskStore.TickerAfterRun(time.Duration(interval)*time.Second, runBackup)
+
+	if cfg.RestoreVerify.Enable {
+		verifyInterval := cfg.RestoreVerify.IntervalSec
+		if verifyInterval <= 0 {
+			verifyInterval = defaultRestoreVerifyIntervalSec
+		}
+		logger.Info("postgres restore verify config",
+			zap.Int("interval_sec", verifyInterval),
+			zap.Bool("scratch_dsn", cfg.RestoreVerify.ScratchDSN != ""),
+			zap.String("docker_image", cfg.RestoreVerify.DockerImage))
+		go store.TaskStore.Ticker(time.Duration(verifyInterval)*time.Second, runRestoreVerify)
+	}
 }
 
 // init registers the postgres backup task in the shared task store.
@@ -0,0 +1,153 @@
+// Package s3retention implements the task sweeping S3 prefixes by retention policies.
+package s3retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/store"
+	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/s3"
+)
+
+var logger = log.Logger.Named("s3-retention")
+
+const (
+	defaultIntervalSec = 86400
+	sweepTimeout       = 30 * time.Minute
+)
+
+type cfgS3 struct {
+	Endpoint     string
+	AccessKey    string `mapstructure:"access_key"`
+	AccessSecret string `mapstructure:"access_secret"`
+}
+
+type cfgRetention struct {
+	Enable      bool
+	IntervalSec int `mapstructure:"interval"`
+	// DryRun only logs what would be removed.
+	DryRun  bool `mapstructure:"dry_run"`
+	S3      cfgS3
+	Targets []s3.RetentionTarget
+}
+
+// loadCfg loads `tasks.s3_retention`.
+func loadCfg() (*cfgRetention, error) {
+	cfg := new(cfgRetention)
+	if err := gconfig.Shared.UnmarshalKey("tasks.s3_retention", cfg); err != nil {
+		return nil, errors.Wrap(err, "unmarshal tasks.s3_retention")
+	}
+
+	for _, target := range cfg.Targets {
+		if target.Name == "" {
+			return nil, errors.Errorf("retention target of prefix %q requires name", target.Prefix)
+		}
+		if target.Policy.KeepsAll() {
+			return nil, errors.Errorf("retention %q has no rule", target.Name)
+		}
+	}
+
+	return cfg, nil
+}
+
+// newClient is replaced in tests.
+var newClient = func(s cfgS3) (s3.RetentionClient, error) {
+	return s3.GetCli(s.Endpoint, s.AccessKey, s.AccessSecret)
+}
+
+// Sweep applies the retention targets, or only the one named target if it
+// is not empty. Nothing is removed if dryRun.
+func Sweep(ctx context.Context, target string, dryRun bool) ([]*s3.RetentionReport, error) {
+	cfg, err := loadCfg()
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := newClient(cfg.S3)
+	if err != nil {
+		return nil, errors.Wrap(err, "new s3 client")
+	}
+
+	var (
+		reports []*s3.RetentionReport
+		found   bool
+	)
+	now := time.Now()
+	for _, t := range cfg.Targets {
+		if target != "" && t.Name != target {
+			continue
+		}
+		found = true
+
+		report, err := s3.SweepRetention(ctx, logger, cli, t, now, dryRun)
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err != nil {
+			return reports, errors.Wrapf(err, "sweep %q", t.Name)
+		}
+	}
+	if target != "" && !found {
+		return nil, errors.Errorf("retention target %q not found", target)
+	}
+
+	return reports, nil
+}
+
+func runSweep() {
+	cfg, err := loadCfg()
+	if err != nil {
+		logger.Error("load s3 retention config", zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sweepTimeout)
+	defer cancel()
+
+	reports, err := Sweep(ctx, "", cfg.DryRun)
+	for _, r := range reports {
+		logger.Info("s3 retention swept",
+			zap.String("target", r.Name),
+			zap.String("bucket", r.Bucket),
+			zap.String("prefix", r.Prefix),
+			zap.Bool("dry_run", r.DryRun),
+			zap.Int("kept", r.Kept),
+			zap.Int64("kept_bytes", r.KeptBytes),
+			zap.Int("expired", r.Expired),
+			zap.Int64("expired_bytes", r.ExpiredBytes))
+	}
+	if err != nil {
+		logger.Error("s3 retention sweep failed", zap.Error(err))
+	}
+}
+
+func bindTask() {
+	logger.Info("bind s3 retention task...")
+	cfg, err := loadCfg()
+	if err != nil {
+		logger.Panic("load s3 retention config", zap.Error(err))
+	}
+	if !cfg.Enable {
+		logger.Info("s3 retention disabled by config; not binding task")
+		return
+	}
+
+	interval := cfg.IntervalSec
+	if interval <= 0 {
+		interval = defaultIntervalSec
+	}
+	logger.Info("s3 retention config",
+		zap.Int("interval_sec", interval),
+		zap.Bool("dry_run", cfg.DryRun),
+		zap.Int("targets", len(cfg.Targets)))
+	go store.TaskStore.TickerAfterRun(time.Duration(interval)*time.Second, runSweep)
+}
+
+func init() {
+	store.TaskStore.Store("s3-retention", bindTask)
+}
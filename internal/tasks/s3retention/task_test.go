@@ -0,0 +1,82 @@
+package s3retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Laisky/go-ramjet/library/s3"
+)
+
+type fakeClient struct {
+	objects []minio.ObjectInfo
+	removed []string
+}
+
+func (f *fakeClient) ListObjects(_ context.Context, _ string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	ch := make(chan minio.ObjectInfo, len(f.objects))
+	for _, obj := range f.objects {
+		if len(obj.Key) >= len(opts.Prefix) && obj.Key[:len(opts.Prefix)] == opts.Prefix {
+			ch <- obj
+		}
+	}
+	close(ch)
+	return ch
+}
+
+func (f *fakeClient) RemoveObject(_ context.Context, _, key string, _ minio.RemoveObjectOptions) error {
+	f.removed = append(f.removed, key)
+	return nil
+}
+
+// TestSweep verifies targets are loaded from config and swept.
+func TestSweep(t *testing.T) {
+	gconfig.Shared.Set("tasks.s3_retention", map[string]any{
+		"targets": []map[string]any{
+			{"name": "exports", "bucket": "b", "prefix": "exports/", "keep_last": 1, "min_age": "1h"},
+			{"name": "logs", "bucket": "b", "prefix": "logs/", "max_total_size": 10},
+		},
+	})
+	t.Cleanup(func() { gconfig.Shared.Set("tasks.s3_retention", nil) })
+
+	now := time.Now()
+	cli := &fakeClient{objects: []minio.ObjectInfo{
+		{Key: "exports/1", LastModified: now.Add(-72 * time.Hour), Size: 1},
+		{Key: "exports/2", LastModified: now.Add(-48 * time.Hour), Size: 1},
+		{Key: "exports/3", LastModified: now.Add(-time.Minute), Size: 1},
+		{Key: "logs/1", LastModified: now.Add(-2 * time.Hour), Size: 8},
+		{Key: "logs/2", LastModified: now.Add(-time.Hour), Size: 8},
+	}}
+	original := newClient
+	newClient = func(cfgS3) (s3.RetentionClient, error) { return cli, nil }
+	t.Cleanup(func() { newClient = original })
+
+	cfg, err := loadCfg()
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, cfg.Targets[0].Policy.MinAge)
+
+	reports, err := Sweep(context.Background(), "", true)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	require.Equal(t, 2, reports[0].Expired)
+	require.Equal(t, 1, reports[1].Expired)
+	require.Empty(t, cli.removed, "dry run")
+
+	reports, err = Sweep(context.Background(), "logs", false)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	require.Equal(t, []string{"logs/1"}, cli.removed)
+
+	_, err = Sweep(context.Background(), "nope", true)
+	require.ErrorContains(t, err, "not found")
+
+	gconfig.Shared.Set("tasks.s3_retention", map[string]any{
+		"targets": []map[string]any{{"name": "all", "bucket": "b"}},
+	})
+	_, err = loadCfg()
+	require.ErrorContains(t, err, "has no rule")
+}
@@ -0,0 +1,253 @@
+package sites
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+)
+
+// Kinds of problems.
+const (
+	ProblemExpiry        = "expiry"
+	ProblemChain         = "chain"
+	ProblemHostname      = "hostname"
+	ProblemWeakKey       = "weak_key"
+	ProblemWeakSignature = "weak_signature"
+	ProblemRevoked       = "revoked"
+)
+
+const (
+	minRSAKeyBits   = 2048
+	minECDSAKeyBits = 256
+)
+
+// rootCAs verifies chains, nil to use the system pool, replaced in tests.
+var rootCAs *x509.CertPool
+
+// CertInfo is a certificate in the chain.
+type CertInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	DNSNames           []string  `json:"dns_names,omitempty"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	DaysRemaining      int       `json:"days_remaining"`
+	KeyAlgorithm       string    `json:"key_algorithm"`
+	KeyBits            int       `json:"key_bits"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+}
+
+// Problem is a defect of the certificate of an endpoint.
+type Problem struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// CertReport is the result of checking an endpoint.
+type CertReport struct {
+	Name       string `json:"name"`
+	Addr       string `json:"addr"`
+	ServerName string `json:"server_name"`
+	StartTLS   string `json:"starttls,omitempty"`
+	// NotAfter is the earliest expiry in the chain
+	NotAfter      time.Time  `json:"not_after"`
+	DaysRemaining int        `json:"days_remaining"`
+	Threshold     string     `json:"threshold"`
+	Chain         []CertInfo `json:"chain,omitempty"`
+	// OCSPStatus is `good`, `revoked`, `unknown`, `unavailable` or `error`
+	OCSPStatus string    `json:"ocsp_status"`
+	Problems   []Problem `json:"problems,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// hasProblem reports whether r has problems of kind, or of kinds other than expiry if kind is empty.
+func (r *CertReport) hasProblem(kind string) bool {
+	for _, p := range r.Problems {
+		if p.Kind == kind || (kind == "" && p.Kind != ProblemExpiry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CertReport) addProblem(kind, format string, args ...any) {
+	r.Problems = append(r.Problems, Problem{Kind: kind, Message: fmt.Sprintf(format, args...)})
+}
+
+func daysUntil(now, t time.Time) int {
+	return int(math.Floor(t.Sub(now).Hours() / 24))
+}
+
+func keyInfo(cert *x509.Certificate) (algo string, bits int) {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0
+	}
+}
+
+func isWeakSignature(algo x509.SignatureAlgorithm) bool {
+	switch algo {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA,
+		x509.DSAWithSHA1, x509.DSAWithSHA256, x509.ECDSAWithSHA1:
+		return true
+	default:
+		return false
+	}
+}
+
+// dialTLS connects to the endpoint and completes the tls handshake without verification,
+// the chain is verified by inspectChain.
+func dialTLS(ctx context.Context, cfg *endpointCfg) (*tls.ConnectionState, error) {
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect %s", cfg.Addr)
+	}
+	defer gutils.SilentClose(conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, errors.Wrap(err, "set deadline")
+		}
+	}
+
+	if err = startTLS(conn, cfg.StartTLS); err != nil {
+		return nil, errors.Wrapf(err, "starttls %s", cfg.StartTLS)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: cfg.ServerName,
+		// verified by inspectChain, to report all problems rather than the first
+		InsecureSkipVerify: true, // nolint: gosec
+	})
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, errors.Wrapf(err, "tls handshake with %s", cfg.Addr)
+	}
+
+	state := tlsConn.ConnectionState()
+	return &state, nil
+}
+
+// inspectChain verifies the peer certificates of state, records the chain and its problems into report.
+// Returns the verified chain, or the peer certificates if failed to verify.
+func inspectChain(report *CertReport, state *tls.ConnectionState, cfg *endpointCfg, now time.Time) []*x509.Certificate {
+	peers := state.PeerCertificates
+	if len(peers) == 0 {
+		report.addProblem(ProblemChain, "no peer certificate")
+		return nil
+	}
+
+	leaf := peers[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range peers[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	chain := peers
+	chains, verifyErr := leaf.Verify(x509.VerifyOptions{
+		Roots:         rootCAs,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	if verifyErr != nil {
+		report.addProblem(ProblemChain, "verify chain: %v", verifyErr)
+	} else {
+		chain = chains[0]
+	}
+
+	if err := leaf.VerifyHostname(cfg.ServerName); err != nil {
+		report.addProblem(ProblemHostname, "%v", err)
+	}
+
+	for i, cert := range chain {
+		algo, bits := keyInfo(cert)
+		info := CertInfo{
+			Subject:            cert.Subject.String(),
+			Issuer:             cert.Issuer.String(),
+			SerialNumber:       cert.SerialNumber.String(),
+			NotBefore:          cert.NotBefore,
+			NotAfter:           cert.NotAfter,
+			DaysRemaining:      daysUntil(now, cert.NotAfter),
+			KeyAlgorithm:       algo,
+			KeyBits:            bits,
+			SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		}
+		if i == 0 {
+			info.DNSNames = cert.DNSNames
+		}
+		report.Chain = append(report.Chain, info)
+
+		if report.NotAfter.IsZero() || cert.NotAfter.Before(report.NotAfter) {
+			report.NotAfter = cert.NotAfter
+		}
+		if cert.NotAfter.Sub(now) < cfg.Threshold {
+			report.addProblem(ProblemExpiry, "%q expires at %s, %d days remaining",
+				info.Subject, cert.NotAfter.Format(time.RFC3339), info.DaysRemaining)
+		}
+
+		if (algo == "RSA" && bits < minRSAKeyBits) ||
+			(algo == "ECDSA" && bits < minECDSAKeyBits) ||
+			algo == x509.DSA.String() {
+			report.addProblem(ProblemWeakKey, "%q uses %d bits %s key", info.Subject, bits, algo)
+		}
+
+		// the self-signature of roots is not checked by clients
+		isRoot := i == len(chain)-1 && verifyErr == nil
+		if !isRoot && isWeakSignature(cert.SignatureAlgorithm) {
+			report.addProblem(ProblemWeakSignature, "%q is signed with %s", info.Subject, cert.SignatureAlgorithm)
+		}
+	}
+	report.DaysRemaining = daysUntil(now, report.NotAfter)
+
+	return chain
+}
+
+// checkEndpoint checks the certificates of the endpoint.
+func checkEndpoint(ctx context.Context, cfg *endpointCfg) *CertReport {
+	report := &CertReport{
+		Name:       cfg.Name,
+		Addr:       cfg.Addr,
+		ServerName: cfg.ServerName,
+		StartTLS:   cfg.StartTLS,
+		Threshold:  cfg.Threshold.String(),
+		CheckedAt:  time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	state, err := dialTLS(ctx, cfg)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	chain := inspectChain(report, state, cfg, report.CheckedAt)
+	report.OCSPStatus = ocspStatusUnavailable
+	if !cfg.SkipOCSP && len(chain) > 1 {
+		report.OCSPStatus, err = checkOCSP(ctx, state.OCSPResponse, chain[0], chain[1])
+		if err != nil {
+			report.Error = err.Error()
+		}
+		if report.OCSPStatus == ocspStatusRevoked {
+			report.addProblem(ProblemRevoked, "%q is revoked", report.Chain[0].Subject)
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,331 @@
+package sites
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/Laisky/go-ramjet/library/alert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+var testSerial atomic.Int64
+
+func newTestCert(t *testing.T, tpl *x509.Certificate, parent *testCert, key crypto.Signer) *testCert {
+	t.Helper()
+	if key == nil {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+	}
+
+	tpl.SerialNumber = big.NewInt(testSerial.Add(1))
+	if tpl.NotBefore.IsZero() {
+		tpl.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if tpl.NotAfter.IsZero() {
+		tpl.NotAfter = time.Now().Add(90 * 24 * time.Hour)
+	}
+
+	parentCert, parentKey := tpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parentCert, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string, parent *testCert) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, parent, nil)
+}
+
+// newTestChain returns root, intermediate and leaf of `example.com`.
+func newTestChain(t *testing.T, ocspServer string) (root, inter, leaf *testCert) {
+	root = newTestCA(t, "test root", nil)
+	inter = newTestCA(t, "test intermediate", root)
+	leafTpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "example.com"},
+		DNSNames:    []string{"example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}
+	if ocspServer != "" {
+		leafTpl.OCSPServer = []string{ocspServer}
+	}
+	leaf = newTestCert(t, leafTpl, inter, nil)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(root.cert)
+	origRootCAs := rootCAs
+	rootCAs = pool
+	t.Cleanup(func() { rootCAs = origRootCAs })
+
+	return root, inter, leaf
+}
+
+func tlsCertOf(leaf, inter *testCert) tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{leaf.cert.Raw, inter.cert.Raw},
+		PrivateKey:  leaf.key,
+	}
+}
+
+// ocspHandler responds status for any cert issued by inter.
+func ocspHandler(t *testing.T, inter *testCert, status *atomic.Int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+
+		tpl := ocsp.Response{
+			Status:       int(status.Load()),
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if tpl.Status == ocsp.Revoked {
+			tpl.RevokedAt = time.Now().Add(-time.Minute)
+		}
+		resp, err := ocsp.CreateResponse(inter.cert, inter.cert, tpl, inter.key)
+		require.NoError(t, err)
+		_, _ = w.Write(resp)
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	status := new(atomic.Int64)
+	status.Store(ocsp.Good)
+	var handler http.HandlerFunc
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+	}))
+	defer responder.Close()
+
+	_, inter, leaf := newTestChain(t, responder.URL)
+	handler = ocspHandler(t, inter, status)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCertOf(leaf, inter)},
+	})
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	cfg := &endpointCfg{Addr: ln.Addr().String(), ServerName: "example.com"}
+	require.NoError(t, cfg.fillDefault(30*24*time.Hour))
+
+	r := checkEndpoint(context.Background(), cfg)
+	require.Empty(t, r.Error)
+	require.Empty(t, r.Problems)
+	require.Len(t, r.Chain, 3)
+	require.Equal(t, ocspStatusGood, r.OCSPStatus)
+	require.Equal(t, []string{"example.com"}, r.Chain[0].DNSNames)
+	require.Equal(t, leaf.cert.NotAfter, r.NotAfter)
+	require.InDelta(t, 89, r.DaysRemaining, 1)
+
+	// revoked
+	status.Store(ocsp.Revoked)
+	r = checkEndpoint(context.Background(), cfg)
+	require.Equal(t, ocspStatusRevoked, r.OCSPStatus)
+	require.True(t, r.hasProblem(ProblemRevoked))
+
+	// hostname mismatch and expiring soon
+	cfg.ServerName = "other.example.com"
+	cfg.Threshold = 365 * 24 * time.Hour
+	cfg.SkipOCSP = true
+	r = checkEndpoint(context.Background(), cfg)
+	require.Equal(t, ocspStatusUnavailable, r.OCSPStatus)
+	require.True(t, r.hasProblem(ProblemHostname))
+	require.True(t, r.hasProblem(ProblemExpiry))
+	require.False(t, r.hasProblem(ProblemChain))
+
+	// unreachable
+	require.NoError(t, ln.Close())
+	r = checkEndpoint(context.Background(), cfg)
+	require.NotEmpty(t, r.Error)
+	require.Empty(t, r.Chain)
+}
+
+func TestInspectChainWeak(t *testing.T) {
+	newTestChain(t, "")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	self := newTestCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com"},
+		NotAfter: time.Now().Add(24 * time.Hour),
+	}, nil, rsaKey)
+
+	cfg := &endpointCfg{Addr: "example.com:443"}
+	require.NoError(t, cfg.fillDefault(7*24*time.Hour))
+	r := &CertReport{}
+	chain := inspectChain(r, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{self.cert}}, cfg, time.Now())
+	require.Len(t, chain, 1)
+	require.True(t, r.hasProblem(ProblemChain))
+	require.True(t, r.hasProblem(ProblemWeakKey))
+	require.True(t, r.hasProblem(ProblemExpiry))
+	require.False(t, r.hasProblem(ProblemHostname))
+	require.Equal(t, "RSA", r.Chain[0].KeyAlgorithm)
+	require.Equal(t, 1024, r.Chain[0].KeyBits)
+
+	require.True(t, isWeakSignature(x509.SHA1WithRSA))
+	require.False(t, isWeakSignature(x509.ECDSAWithSHA256))
+}
+
+func TestStartTLS(t *testing.T) {
+	_, inter, leaf := newTestChain(t, "")
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{tlsCertOf(leaf, inter)}}
+
+	for _, tc := range []struct {
+		protocol string
+		serve    func(rw *bufio.ReadWriter) bool
+	}{
+		{StartTLSSMTP, func(rw *bufio.ReadWriter) bool {
+			_, _ = rw.WriteString("220 mx.example.com ESMTP\r\n")
+			_ = rw.Flush()
+			if line, _ := rw.ReadString('\n'); !strings.HasPrefix(line, "EHLO") {
+				return false
+			}
+			_, _ = rw.WriteString("250-mx.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n")
+			_ = rw.Flush()
+			if line, _ := rw.ReadString('\n'); line != "STARTTLS\r\n" {
+				return false
+			}
+			_, _ = rw.WriteString("220 go ahead\r\n")
+			return rw.Flush() == nil
+		}},
+		{StartTLSIMAP, func(rw *bufio.ReadWriter) bool {
+			_, _ = rw.WriteString("* OK IMAP4rev1 ready\r\n")
+			_ = rw.Flush()
+			if line, _ := rw.ReadString('\n'); line != "a001 STARTTLS\r\n" {
+				return false
+			}
+			_, _ = rw.WriteString("* CAPABILITY IMAP4rev1\r\na001 OK begin tls\r\n")
+			return rw.Flush() == nil
+		}},
+	} {
+		t.Run(tc.protocol, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				if tc.serve(bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))) {
+					_ = tls.Server(conn, tlsCfg).Handshake()
+				}
+			}()
+
+			cfg := &endpointCfg{Addr: ln.Addr().String(), ServerName: "example.com", StartTLS: tc.protocol, SkipOCSP: true}
+			require.NoError(t, cfg.fillDefault(time.Hour))
+			r := checkEndpoint(context.Background(), cfg)
+			require.Empty(t, r.Error)
+			require.Empty(t, r.Problems)
+			require.Len(t, r.Chain, 3)
+		})
+	}
+}
+
+func TestCheckAndAlert(t *testing.T) {
+	origFire, origResolve := fireAlert, resolveAlert
+	defer func() { fireAlert, resolveAlert = origFire, origResolve }()
+	fired := map[string]bool{}
+	fireAlert = func(_ context.Context, a alert.Alert) error {
+		fired[a.Name] = true
+		return nil
+	}
+	resolveAlert = func(_ context.Context, a alert.Alert) error {
+		fired[a.Name] = false
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	cfg := &endpointCfg{Name: "down", Addr: addr}
+	require.NoError(t, cfg.fillDefault(time.Hour))
+	checkAndAlert(context.Background(), cfg)
+	require.Equal(t, map[string]bool{"ssl_cert_check": true}, fired)
+
+	gin.SetMode(gin.TestMode)
+	origRequireAdmin := requireAdmin
+	requireAdmin = func(ctx *gin.Context) {}
+	defer func() { requireAdmin = origRequireAdmin }()
+
+	engine := gin.New()
+	newRouter().bindHTTP(engine.Group("/sites"))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sites/certs", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Certs []*CertReport `json:"certs"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Certs, 1)
+	require.Equal(t, "down", resp.Certs[0].Name)
+	require.NotEmpty(t, resp.Certs[0].Error)
+}
+
+func TestEndpointCfgFillDefault(t *testing.T) {
+	cfg := &endpointCfg{Addr: "mail.example.com:587", StartTLS: StartTLSSMTP}
+	require.NoError(t, cfg.fillDefault(time.Hour))
+	require.Equal(t, "mail.example.com:587", cfg.Name)
+	require.Equal(t, "mail.example.com", cfg.ServerName)
+	require.Equal(t, time.Hour, cfg.Threshold)
+	require.Equal(t, defaultCheckTimeout, cfg.Timeout)
+
+	require.Error(t, (&endpointCfg{Addr: "example.com"}).fillDefault(time.Hour))
+	require.Error(t, (&endpointCfg{Addr: "example.com:25", StartTLS: "pop3"}).fillDefault(time.Hour))
+}
@@ -0,0 +1,101 @@
+package sites
+
+import (
+	"net"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+)
+
+// STARTTLS protocols.
+const (
+	StartTLSSMTP = "smtp"
+	StartTLSIMAP = "imap"
+)
+
+const (
+	defaultThreshold    = 30 * 24 * time.Hour
+	defaultCheckTimeout = 10 * time.Second
+)
+
+// endpointCfg is an endpoint of `tasks.sites.endpoints`.
+type endpointCfg struct {
+	// Name default to Addr
+	Name string
+	// Addr is `host:port`
+	Addr string
+	// ServerName is the SNI and the expected hostname, default to the host of Addr
+	ServerName string `mapstructure:"server_name"`
+	// StartTLS upgrades a plain connection, `smtp` or `imap`
+	StartTLS string `mapstructure:"starttls"`
+	// Threshold alerts certs expire within it
+	Threshold time.Duration
+	Timeout   time.Duration
+	Receiver  string
+	// SkipOCSP disables the revocation check
+	SkipOCSP bool `mapstructure:"skip_ocsp"`
+}
+
+func (c *endpointCfg) fillDefault(defaultThreshold time.Duration) error {
+	host, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid addr %q", c.Addr)
+	}
+
+	if c.Name == "" {
+		c.Name = c.Addr
+	}
+	if c.ServerName == "" {
+		c.ServerName = host
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = defaultThreshold
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultCheckTimeout
+	}
+
+	switch c.StartTLS {
+	case "", StartTLSSMTP, StartTLSIMAP:
+	default:
+		return errors.Errorf("unknown starttls %q", c.StartTLS)
+	}
+
+	return nil
+}
+
+// loadEndpoints loads `tasks.sites.endpoints`,
+// falls back to the legacy single `tasks.sites.addr`.
+func loadEndpoints() ([]*endpointCfg, error) {
+	threshold := defaultThreshold
+	if d := gconfig.Shared.GetDuration("tasks.sites.sslMonitor.duration") * time.Second; d > 0 {
+		threshold = d
+	}
+
+	var cfgs []*endpointCfg
+	if err := gconfig.Shared.UnmarshalKey("tasks.sites.endpoints", &cfgs); err != nil {
+		return nil, errors.Wrap(err, "unmarshal tasks.sites.endpoints")
+	}
+	if len(cfgs) == 0 {
+		if addr := gconfig.Shared.GetString("tasks.sites.addr"); addr != "" {
+			cfgs = append(cfgs, &endpointCfg{
+				Addr:     addr,
+				Receiver: gconfig.Shared.GetString("tasks.sites.receiver"),
+			})
+		}
+	}
+
+	names := map[string]bool{}
+	for _, cfg := range cfgs {
+		if err := cfg.fillDefault(threshold); err != nil {
+			return nil, err
+		}
+		if names[cfg.Name] {
+			return nil, errors.Errorf("duplicate endpoint %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+	}
+
+	return cfgs, nil
+}
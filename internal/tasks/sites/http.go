@@ -0,0 +1,30 @@
+package sites
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/sso"
+)
+
+// requireAdmin guards the api, replaced in tests.
+var requireAdmin = sso.RequireSession(true)
+
+type router struct{}
+
+func newRouter() *router {
+	return &router{}
+}
+
+func (r *router) bindHTTP(grp gin.IRouter) {
+	grp.Use(func(ctx *gin.Context) { requireAdmin(ctx) })
+	grp.GET("/certs", r.listCerts)
+}
+
+// listCerts lists the monitored certificates, the soonest expiring first.
+func (r *router) listCerts(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"certs": listReports(),
+	})
+}
@@ -0,0 +1,88 @@
+package sites
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"io"
+	"net/http"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+// OCSP statuses of CertReport.
+const (
+	ocspStatusGood        = "good"
+	ocspStatusRevoked     = "revoked"
+	ocspStatusUnknown     = "unknown"
+	ocspStatusUnavailable = "unavailable"
+	ocspStatusError       = "error"
+)
+
+const maxOCSPResponseSize = 1024 * 1024
+
+// ocspHTTPClient queries ocsp responders, replaced in tests.
+var ocspHTTPClient = http.DefaultClient
+
+func ocspStatusOf(resp *ocsp.Response) string {
+	switch resp.Status {
+	case ocsp.Good:
+		return ocspStatusGood
+	case ocsp.Revoked:
+		return ocspStatusRevoked
+	default:
+		return ocspStatusUnknown
+	}
+}
+
+// checkOCSP returns the revocation status of cert,
+// by the stapled response if any, otherwise by querying the responder of cert.
+func checkOCSP(ctx context.Context, stapled []byte, cert, issuer *x509.Certificate) (string, error) {
+	if len(stapled) != 0 {
+		resp, err := ocsp.ParseResponseForCert(stapled, cert, issuer)
+		if err == nil {
+			return ocspStatusOf(resp), nil
+		}
+		log.Logger.Debug("invalid stapled ocsp response, query responder instead")
+	}
+
+	if len(cert.OCSPServer) == 0 {
+		return ocspStatusUnavailable, nil
+	}
+
+	reqBody, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return ocspStatusError, errors.Wrap(err, "create ocsp request")
+	}
+
+	server := cert.OCSPServer[0]
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(reqBody))
+	if err != nil {
+		return ocspStatusError, errors.Wrap(err, "new ocsp request")
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+
+	httpResp, err := ocspHTTPClient.Do(req)
+	if err != nil {
+		return ocspStatusError, errors.Wrapf(err, "query ocsp responder %s", server)
+	}
+	defer gutils.LogErr(httpResp.Body.Close, log.Logger) // nolint: errcheck,gosec
+
+	if httpResp.StatusCode != http.StatusOK {
+		return ocspStatusError, errors.Errorf("ocsp responder %s got status %d", server, httpResp.StatusCode)
+	}
+	respBody, err := io.ReadAll(io.LimitReader(httpResp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return ocspStatusError, errors.Wrap(err, "read ocsp response")
+	}
+
+	resp, err := ocsp.ParseResponseForCert(respBody, cert, issuer)
+	if err != nil {
+		return ocspStatusError, errors.Wrap(err, "parse ocsp response")
+	}
+	return ocspStatusOf(resp), nil
+}
@@ -0,0 +1,78 @@
+package sites
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// startTLS asks the server to upgrade conn to tls by protocol,
+// does nothing if protocol is empty.
+func startTLS(conn net.Conn, protocol string) error {
+	switch protocol {
+	case "":
+		return nil
+	case StartTLSSMTP:
+		return startTLSSMTP(textproto.NewConn(conn))
+	case StartTLSIMAP:
+		return startTLSIMAP(textproto.NewConn(conn))
+	default:
+		return errors.Errorf("unknown starttls %q", protocol)
+	}
+}
+
+func startTLSSMTP(tc *textproto.Conn) error {
+	if _, _, err := tc.ReadResponse(220); err != nil {
+		return errors.Wrap(err, "read greeting")
+	}
+
+	if err := tc.PrintfLine("EHLO go-ramjet"); err != nil {
+		return errors.Wrap(err, "send EHLO")
+	}
+	_, msg, err := tc.ReadResponse(250)
+	if err != nil {
+		return errors.Wrap(err, "read EHLO")
+	}
+	if !strings.Contains(strings.ToUpper(msg), "STARTTLS") {
+		return errors.New("server does not support STARTTLS")
+	}
+
+	if err = tc.PrintfLine("STARTTLS"); err != nil {
+		return errors.Wrap(err, "send STARTTLS")
+	}
+	if _, _, err = tc.ReadResponse(220); err != nil {
+		return errors.Wrap(err, "read STARTTLS")
+	}
+
+	return nil
+}
+
+func startTLSIMAP(tc *textproto.Conn) error {
+	greeting, err := tc.ReadLine()
+	if err != nil {
+		return errors.Wrap(err, "read greeting")
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return errors.Errorf("unexpected greeting %q", greeting)
+	}
+
+	const tag = "a001"
+	if err = tc.PrintfLine("%s STARTTLS", tag); err != nil {
+		return errors.Wrap(err, "send STARTTLS")
+	}
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return errors.Wrap(err, "read STARTTLS")
+		}
+		// skip untagged responses
+		if status, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(status, "OK") {
+				return errors.Errorf("STARTTLS rejected: %q", line)
+			}
+			return nil
+		}
+	}
+}
@@ -1,83 +1,180 @@
 // Package sites implements sites tasks.
+//
+// The certificates of each endpoint of `tasks.sites.endpoints` are checked
+// for expiry of the whole chain, hostname mismatch, weak keys and signatures,
+// and revocation by OCSP.
 package sites
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/Laisky/errors/v2"
 	gconfig "github.com/Laisky/go-config/v2"
-	gutils "github.com/Laisky/go-utils/v6"
 	"github.com/Laisky/zap"
 
 	"github.com/Laisky/go-ramjet/internal/tasks/store"
 	"github.com/Laisky/go-ramjet/library/alert"
 	"github.com/Laisky/go-ramjet/library/log"
+	"github.com/Laisky/go-ramjet/library/web"
 )
 
-func LoadCertExpiresAt(addr string) (t time.Time, err error) {
-	log.Logger.Debug("LoadCertExpiresAt", zap.String("addr", addr))
-	conn, err := tls.Dial("tcp", addr, nil)
-	if err != nil {
-		return time.Time{}, errors.Wrapf(err, "request addr %v got error", addr)
+var (
+	endpointsMu sync.RWMutex
+	endpoints   []*endpointCfg
+
+	reportsMu sync.RWMutex
+	reports   = map[string]*CertReport{}
+
+	// fireAlert and resolveAlert are replaced in tests.
+	fireAlert    = alert.Manager.Fire
+	resolveAlert = alert.Manager.Resolve
+)
+
+// listReports returns the latest reports, the soonest expiring first.
+func listReports() []*CertReport {
+	reportsMu.RLock()
+	defer reportsMu.RUnlock()
+
+	rs := make([]*CertReport, 0, len(reports))
+	for _, r := range reports {
+		rs = append(rs, r)
 	}
-	gutils.SilentClose(conn)
+	slices.SortFunc(rs, func(a, b *CertReport) int {
+		if a.DaysRemaining != b.DaysRemaining {
+			return a.DaysRemaining - b.DaysRemaining
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
 
-	return conn.ConnectionState().VerifiedChains[0][0].NotAfter, nil
+	return rs
 }
 
-func checkIsTimeTooCloseToAlert(now, expiresAt time.Time, d time.Duration) (isAlert bool) {
-	log.Logger.Debug("checkIsTimeTooCloseToAlert",
-		zap.Time("now", now),
-		zap.Time("expiresAt", expiresAt),
-		zap.Duration("duration", d))
-	return expiresAt.Sub(now) < d
+func problemsText(r *CertReport, match func(kind string) bool) string {
+	var lines []string
+	for _, p := range r.Problems {
+		if match(p.Kind) {
+			lines = append(lines, fmt.Sprintf("- [%s] %s", p.Kind, p.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
 }
 
-func sendAlertEmail(addr, receiver string, expiresAt time.Time) (err error) {
-	log.Logger.Info("sendAlertEmail", zap.String("addr", addr), zap.String("receiver", receiver))
-	err = alert.Manager.Send(
-		receiver,
-		"Laisky Cai",
-		"SSL Cert Nearly expires",
-		fmt.Sprintf("SSL Cert [%v] Nearly expires [%v]", addr, expiresAt),
-	)
-	if err != nil {
-		return errors.Wrapf(err, "try to send email to [%v] got error", receiver)
+// certExpiryAlert returns the alert of the certs of the endpoint expire soon
+func certExpiryAlert(cfg *endpointCfg, r *CertReport) alert.Alert {
+	return alert.Alert{
+		Source:   "sites",
+		Name:     "ssl_cert_expiry",
+		Severity: alert.SeverityWarning,
+		Labels:   map[string]string{"endpoint": cfg.Name, "addr": cfg.Addr},
+		Summary:  fmt.Sprintf("SSL Cert of %s nearly expires", cfg.Name),
+		Description: fmt.Sprintf("SSL Cert [%v] Nearly expires [%v]\n\n%s", cfg.Addr, r.NotAfter,
+			problemsText(r, func(kind string) bool { return kind == ProblemExpiry })),
+		To: cfg.Receiver,
 	}
+}
 
-	return nil
+// certInvalidAlert returns the alert of the certs of the endpoint are not trustworthy
+func certInvalidAlert(cfg *endpointCfg, r *CertReport) alert.Alert {
+	return alert.Alert{
+		Source:   "sites",
+		Name:     "ssl_cert_invalid",
+		Severity: alert.SeverityCritical,
+		Labels:   map[string]string{"endpoint": cfg.Name, "addr": cfg.Addr},
+		Summary:  fmt.Sprintf("SSL Cert of %s is invalid", cfg.Name),
+		Description: fmt.Sprintf("SSL Cert [%v] of [%v] got problems:\n\n%s", cfg.Addr, cfg.ServerName,
+			problemsText(r, func(kind string) bool { return kind != ProblemExpiry })),
+		To: cfg.Receiver,
+	}
 }
 
-func runTask() {
-	log.Logger.Info("run ssl-monitor...")
-	var err error
+// certCheckAlert returns the alert of failed to check the certs of the endpoint
+func certCheckAlert(cfg *endpointCfg, r *CertReport) alert.Alert {
+	return alert.Alert{
+		Source:      "sites",
+		Name:        "ssl_cert_check",
+		Severity:    alert.SeverityWarning,
+		Labels:      map[string]string{"endpoint": cfg.Name, "addr": cfg.Addr},
+		Summary:     fmt.Sprintf("Failed to check SSL Cert of %s", cfg.Name),
+		Description: fmt.Sprintf("Failed to check SSL Cert [%v]: %s", cfg.Addr, r.Error),
+		To:          cfg.Receiver,
+	}
+}
 
-	addr := gconfig.Shared.GetString("tasks.sites.addr")
-	expiresAt, err := LoadCertExpiresAt(addr)
+func notify(ctx context.Context, a alert.Alert, firing bool) {
+	var err error
+	if firing {
+		err = fireAlert(ctx, a)
+	} else {
+		err = resolveAlert(ctx, a)
+	}
 	if err != nil {
-		log.Logger.Error("LoadCertExpiresAt got error", zap.String("addr", addr), zap.Error(err))
+		log.Logger.Error("send ssl alert got error",
+			zap.String("alert", a.Name),
+			zap.Any("labels", a.Labels),
+			zap.Error(err))
+	}
+}
+
+// checkAndAlert checks the endpoint, saves its report, and fires or resolves its alerts.
+func checkAndAlert(ctx context.Context, cfg *endpointCfg) {
+	r := checkEndpoint(ctx, cfg)
+	reportsMu.Lock()
+	reports[cfg.Name] = r
+	reportsMu.Unlock()
+
+	// the certs are unknown if failed to connect, keep their alerts as is
+	failed := len(r.Chain) == 0
+	notify(ctx, certCheckAlert(cfg, r), failed)
+	if failed {
+		log.Logger.Error("check ssl cert got error", zap.String("endpoint", cfg.Name), zap.String("err", r.Error))
 		return
 	}
+	if r.Error != "" {
+		log.Logger.Warn("check ssl cert", zap.String("endpoint", cfg.Name), zap.String("err", r.Error))
+	}
 
-	now := time.Now()
-	if checkIsTimeTooCloseToAlert(
-		now, expiresAt,
-		gconfig.Shared.GetDuration("tasks.sites.sslMonitor.duration")*time.Second) {
-		err = sendAlertEmail(addr,
-			gconfig.Shared.GetString("tasks.sites.receiver"),
-			expiresAt)
-		if err != nil {
-			log.Logger.Error("sendAlertEmail got error", zap.String("addr", addr), zap.Error(err))
-		}
+	log.Logger.Info("ssl cert checked",
+		zap.String("endpoint", cfg.Name),
+		zap.Int("days_remaining", r.DaysRemaining),
+		zap.String("ocsp", r.OCSPStatus),
+		zap.Int("problems", len(r.Problems)))
+	notify(ctx, certExpiryAlert(cfg, r), r.hasProblem(ProblemExpiry))
+	notify(ctx, certInvalidAlert(cfg, r), r.hasProblem(""))
+}
+
+func runTask() {
+	log.Logger.Info("run ssl-monitor...")
+	endpointsMu.RLock()
+	defer endpointsMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, cfg := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkAndAlert(context.Background(), cfg)
+		}()
 	}
+	wg.Wait()
 }
 
 // bindTask bind ssl-monitor task
 func bindTask() {
 	log.Logger.Info("bind ssl-monitor task...")
+	cfgs, err := loadEndpoints()
+	if err != nil {
+		log.Logger.Panic("load ssl-monitor endpoints", zap.Error(err))
+	}
+
+	endpointsMu.Lock()
+	endpoints = cfgs
+	endpointsMu.Unlock()
 
+	newRouter().bindHTTP(web.Server.Group("/sites"))
 	go store.TaskStore.TickerAfterRun(
 		gconfig.Shared.GetDuration(
 			"tasks.sites.sslMonitor.interval")*time.Second,
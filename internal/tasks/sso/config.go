@@ -0,0 +1,109 @@
+package sso
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+
+	gptconfig "github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+// Config is the sso task config, loaded from `tasks.sso`.
+type Config struct {
+	// Issuer (required) OIDC issuer url, its discovery document is
+	// served at `<issuer>/.well-known/openid-configuration`
+	Issuer string `json:"issuer" mapstructure:"issuer"`
+	// ClientID (required) OAuth2 client id
+	ClientID string `json:"client_id" mapstructure:"client_id"`
+	// ClientSecret (optional) OAuth2 client secret, empty for public clients
+	ClientSecret string `json:"-" mapstructure:"client_secret"`
+	// RedirectURL (required) callback url registered at the issuer,
+	// e.g. https://app.laisky.com/sso/callback
+	RedirectURL string `json:"redirect_url" mapstructure:"redirect_url"`
+	// Scopes (optional) requested scopes, default is openid, email and profile
+	Scopes []string `json:"scopes" mapstructure:"scopes"`
+	// GroupsClaim (optional) claim holding the user's groups, default is groups
+	GroupsClaim string `json:"groups_claim" mapstructure:"groups_claim"`
+	// CookieName (optional) session cookie name, default is ramjet_sso_session
+	CookieName string `json:"cookie_name" mapstructure:"cookie_name"`
+	// CookieDomain (optional) session cookie domain, default is the request host
+	CookieDomain string `json:"cookie_domain" mapstructure:"cookie_domain"`
+	// SessionSecret (required) signs the login cookies, at least 32
+	// bytes. Every instance must share it.
+	SessionSecret string `json:"-" mapstructure:"session_secret"`
+	// SessionTTLSeconds (optional) session lifetime, default is 7 days
+	SessionTTLSeconds int `json:"session_ttl_seconds" mapstructure:"session_ttl_seconds"`
+	// PostLoginRedirect (optional) where to go after login if the login
+	// request did not ask for a page, default is /
+	PostLoginRedirect string `json:"post_login_redirect" mapstructure:"post_login_redirect"`
+	// Users (required) maps claims to users, the first matching rule wins.
+	// Logins matching no rule are rejected.
+	Users []UserRule `json:"users" mapstructure:"users"`
+}
+
+// UserRule maps OIDC claims to a gptchat user.
+type UserRule struct {
+	// Emails (optional) verified emails, an entry starting with `@`
+	// matches a whole domain
+	Emails []string `json:"emails" mapstructure:"emails"`
+	// Groups (optional) any of these groups matches
+	Groups []string `json:"groups" mapstructure:"groups"`
+	// Admin (optional) grants access to admin surfaces
+	Admin bool `json:"admin" mapstructure:"admin"`
+	// User (optional) template of the gptchat user, such as allowed
+	// models, byok and limits. The username defaults to the email.
+	User gptconfig.UserConfig `json:"user" mapstructure:"user"`
+}
+
+// SessionTTL returns the session lifetime.
+func (c *Config) SessionTTL() time.Duration {
+	return time.Duration(c.SessionTTLSeconds) * time.Second
+}
+
+// loadConfig loads and validates the sso config.
+func loadConfig() (*Config, error) {
+	cfg := new(Config)
+	if err := gconfig.Shared.UnmarshalKey("tasks.sso", cfg); err != nil {
+		return nil, errors.Wrap(err, "unmarshal sso config")
+	}
+
+	if err := cfg.fillDefault(); err != nil {
+		return nil, errors.Wrap(err, "invalid tasks.sso")
+	}
+	return cfg, nil
+}
+
+func (c *Config) fillDefault() error {
+	c.Issuer = strings.TrimRight(strings.TrimSpace(c.Issuer), "/")
+	switch {
+	case c.Issuer == "":
+		return errors.New("issuer is empty")
+	case c.ClientID == "":
+		return errors.New("client_id is empty")
+	case c.RedirectURL == "":
+		return errors.New("redirect_url is empty")
+	case len(c.Users) == 0:
+		return errors.New("users is empty, nobody could login")
+	case len(c.SessionSecret) < 32:
+		return errors.New("session_secret is shorter than 32 bytes")
+	}
+
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+	c.GroupsClaim = gutils.OptionalVal(&c.GroupsClaim, "groups")
+	c.CookieName = gutils.OptionalVal(&c.CookieName, "ramjet_sso_session")
+	c.SessionTTLSeconds = gutils.OptionalVal(&c.SessionTTLSeconds, 7*24*3600)
+	c.PostLoginRedirect = gutils.OptionalVal(&c.PostLoginRedirect, "/")
+
+	for i, rule := range c.Users {
+		if len(rule.Emails) == 0 && len(rule.Groups) == 0 {
+			return errors.Errorf("users[%d] matches nobody, set emails or groups", i)
+		}
+	}
+
+	return nil
+}
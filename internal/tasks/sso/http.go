@@ -0,0 +1,229 @@
+package sso
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	"github.com/Laisky/zap"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+const ctxKeySession = "sso_session"
+
+// bindHTTP registers the login flow under grp.
+func (s *service) bindHTTP(grp gin.IRouter) {
+	grp.GET("/login", s.loginHandler)
+	grp.GET("/callback", s.callbackHandler)
+	grp.POST("/logout", s.logoutHandler)
+	grp.GET("/me", RequireSession(false), s.meHandler)
+}
+
+// loginHandler redirects to the issuer, `redirect` is the local page to
+// return to afterwards. The login is kept in a short-lived cookie, so only
+// the browser that started it can finish it.
+func (s *service) loginHandler(ctx *gin.Context) {
+	state, err := randomToken(24)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	nonce, err := randomToken(24)
+	if web.AbortErr(ctx, err) {
+		return
+	}
+	verifier, challenge, err := newPKCE()
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	value, err := s.sign(signPurposeLogin, &pendingLogin{
+		State:     state,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		Redirect:  s.safeRedirect(ctx.Query("redirect")),
+		ExpiresAt: time.Now().Add(pendingLoginTTL).Unix(),
+	})
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	s.setCookie(ctx, s.loginCookieName(), s.loginCookiePath(), value, int(pendingLoginTTL.Seconds()))
+	ctx.Redirect(http.StatusFound, s.provider.AuthCodeURL(state, nonce, challenge))
+}
+
+// callbackHandler finishes the login, sets the session cookie and
+// redirects to the page the login started from.
+func (s *service) callbackHandler(ctx *gin.Context) {
+	logger := gmw.GetLogger(ctx).Named("sso_callback")
+	if e := ctx.Query("error"); e != "" {
+		web.AbortErr(ctx, errors.Errorf("issuer rejected login: %s %s", e, ctx.Query("error_description")))
+		return
+	}
+
+	// the state must belong to a login started by this browser, and is
+	// redeemed only once
+	pending, err := s.pendingLogin(ctx)
+	s.setCookie(ctx, s.loginCookieName(), s.loginCookiePath(), "", -1)
+	if err != nil {
+		logger.Warn("reject callback", zap.Error(err))
+		web.AbortErr(ctx, errors.New("unknown or expired login state, please login again"))
+		return
+	}
+
+	token, err := s.provider.Exchange(gmw.Ctx(ctx), ctx.Query("code"), pending.Verifier)
+	if web.AbortErr(ctx, errors.Wrap(err, "exchange code")) {
+		return
+	}
+	claims, err := s.provider.VerifyIDToken(gmw.Ctx(ctx), token.IDToken, pending.Nonce)
+	if web.AbortErr(ctx, errors.Wrap(err, "verify id token")) {
+		return
+	}
+
+	tok := s.newSessionToken(claims)
+	sess, err := s.newSession(tok)
+	if err != nil {
+		logger.Warn("reject login", zap.Error(err))
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"err": err.Error()})
+		return
+	}
+
+	id, err := randomToken(sessionIDBytes)
+	if web.AbortErr(ctx, errors.Wrap(err, "generate session id")) {
+		return
+	}
+	if err = s.store.Save(gmw.Ctx(ctx), id, tok, s.cfg.SessionTTL()); err != nil {
+		logger.Error("save session", zap.Error(err))
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"err": "save session"})
+		return
+	}
+	s.setCookie(ctx, s.cfg.CookieName, "/", id, int(s.cfg.SessionTTL().Seconds()))
+	logger.Info("user login",
+		zap.String("user", sess.User.UserName),
+		zap.Strings("groups", sess.Groups),
+		zap.Bool("admin", sess.Admin))
+	ctx.Redirect(http.StatusFound, pending.Redirect)
+}
+
+// pendingLogin loads the login cookie and checks it against the
+// callback's state.
+func (s *service) pendingLogin(ctx *gin.Context) (*pendingLogin, error) {
+	state := ctx.Query("state")
+	if state == "" {
+		return nil, errors.New("empty state")
+	}
+
+	cookie, err := ctx.Request.Cookie(s.loginCookieName())
+	if err != nil {
+		return nil, errors.Wrap(err, "get login cookie")
+	}
+
+	pending := new(pendingLogin)
+	if err = s.verify(signPurposeLogin, cookie.Value, pending); err != nil {
+		return nil, errors.Wrap(err, "verify login cookie")
+	}
+	switch {
+	case time.Now().Unix() >= pending.ExpiresAt:
+		return nil, errors.New("login expired")
+	case subtle.ConstantTimeCompare([]byte(state), []byte(pending.State)) != 1:
+		return nil, errors.New("state mismatch")
+	}
+
+	return pending, nil
+}
+
+// logoutHandler deletes the session and drops its cookie, a copy of the
+// cookie is rejected afterwards.
+func (s *service) logoutHandler(ctx *gin.Context) {
+	if cookie, err := ctx.Request.Cookie(s.cfg.CookieName); err == nil && cookie.Value != "" {
+		if err = s.store.Delete(gmw.Ctx(ctx), cookie.Value); err != nil {
+			gmw.GetLogger(ctx).Error("delete session", zap.Error(err))
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"err": "delete session"})
+			return
+		}
+	}
+
+	s.setCookie(ctx, s.cfg.CookieName, "/", "", -1)
+	ctx.JSON(http.StatusOK, gin.H{
+		"end_session_endpoint": s.provider.meta.EndSessionEndpoint,
+	})
+}
+
+// meHandler returns the current session.
+func (s *service) meHandler(ctx *gin.Context) {
+	sess := ctx.MustGet(ctxKeySession).(*Session) //nolint:forcetypeassert
+	ctx.JSON(http.StatusOK, gin.H{
+		"username":       sess.User.UserName,
+		"allowed_models": sess.User.AllowedModels,
+		"session":        sess,
+	})
+}
+
+// RequireSession aborts requests without a valid sso session, or without
+// an admin session if admin is true. Other tasks use it to guard their
+// admin surfaces.
+func RequireSession(admin bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if instance == nil {
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"err": "sso is not enabled"})
+			return
+		}
+
+		sess, ok := instance.session(ctx.Request)
+		switch {
+		case !ok:
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"err": "login required"})
+			return
+		case admin && !sess.Admin:
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"err": "admin required"})
+			return
+		}
+
+		ctx.Set(ctxKeySession, sess)
+		ctx.Next()
+	}
+}
+
+// setCookie sets a cookie, maxAge < 0 deletes it. SameSite=Lax keeps
+// cross-site POSTs from riding on the session, while still sending the
+// login cookie on the issuer's redirect back.
+func (s *service) setCookie(ctx *gin.Context, name, path, value string, maxAge int) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   s.cfg.CookieDomain,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.cfg.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *service) loginCookieName() string {
+	return s.cfg.CookieName + "_login"
+}
+
+// loginCookiePath scopes the login cookie to the callback.
+func (s *service) loginCookiePath() string {
+	if u, err := url.Parse(s.cfg.RedirectURL); err == nil && u.Path != "" {
+		return u.Path
+	}
+	return "/"
+}
+
+// safeRedirect only allows local paths, so the login can not be abused
+// as an open redirect.
+func (s *service) safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") ||
+		strings.HasPrefix(redirect, "//") ||
+		strings.Contains(redirect, `\`) {
+		return s.cfg.PostLoginRedirect
+	}
+	return redirect
+}
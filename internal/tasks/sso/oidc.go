@@ -0,0 +1,371 @@
+package sso
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/Laisky/zap"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+const (
+	// jwksRefreshInterval bounds how often an unknown key id triggers a
+	// jwks refetch, so forged tokens can not hammer the issuer.
+	jwksRefreshInterval = time.Minute
+	// idTokenLeeway tolerates clock skew between us and the issuer.
+	idTokenLeeway = time.Minute
+	// maxOIDCResponseBytes caps documents read from the issuer.
+	maxOIDCResponseBytes = 1 << 20
+)
+
+// providerMetadata is the subset of the OIDC discovery document we use.
+type providerMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Provider is an OIDC relying party of one issuer, using the
+// authorization code flow with PKCE.
+type Provider struct {
+	cfg     *Config
+	meta    providerMetadata
+	httpCli *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// tokenResponse is the token endpoint response.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// NewProvider discovers the issuer of cfg.
+func NewProvider(ctx context.Context, cfg *Config, httpCli *http.Client) (*Provider, error) {
+	p := &Provider{
+		cfg:     cfg,
+		httpCli: httpCli,
+		keys:    map[string]crypto.PublicKey{},
+	}
+
+	if err := p.getJSON(ctx, cfg.Issuer+"/.well-known/openid-configuration", &p.meta); err != nil {
+		return nil, errors.Wrap(err, "get discovery document")
+	}
+	if strings.TrimRight(p.meta.Issuer, "/") != cfg.Issuer {
+		return nil, errors.Errorf("discovered issuer %q mismatch configured %q", p.meta.Issuer, cfg.Issuer)
+	}
+	if p.meta.AuthorizationEndpoint == "" || p.meta.TokenEndpoint == "" || p.meta.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks authorization, token or jwks endpoint")
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, errors.Wrap(err, "fetch jwks")
+	}
+	return p, nil
+}
+
+// newPKCE returns a PKCE verifier and its S256 challenge.
+func newPKCE() (verifier, challenge string, err error) {
+	if verifier, err = randomToken(32); err != nil {
+		return "", "", errors.Wrap(err, "generate verifier")
+	}
+
+	hashed := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(hashed[:]), nil
+}
+
+// randomToken returns n random bytes in base64url.
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// AuthCodeURL returns the issuer's login url.
+func (p *Provider) AuthCodeURL(state, nonce, challenge string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.meta.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange redeems an authorization code for tokens.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*tokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	// client_secret_basic is the default of the spec, only fall back to
+	// client_secret_post when the issuer does not support it
+	useBasic := p.cfg.ClientSecret != "" && (len(p.meta.TokenAuthMethods) == 0 ||
+		slices.Contains(p.meta.TokenAuthMethods, "client_secret_basic"))
+	form.Set("client_id", p.cfg.ClientID)
+	if p.cfg.ClientSecret != "" && !useBasic {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "new token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.httpCli.Do(req) //nolint:bodyclose
+	if err != nil {
+		return nil, errors.Wrap(err, "do token request")
+	}
+	defer gutils.CloseWithLog(resp.Body, log.Logger)
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOIDCResponseBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "read token response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("token endpoint returns %d: %s", resp.StatusCode, body)
+	}
+
+	token := new(tokenResponse)
+	if err = json.Unmarshal(body, token); err != nil {
+		return nil, errors.Wrap(err, "unmarshal token response")
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token, is the openid scope granted?")
+	}
+
+	return token, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce
+// of an ID token, and returns its claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	algs := p.meta.SigningAlgs
+	if len(algs) == 0 {
+		algs = []string{"RS256"}
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods(algs),
+		jwt.WithIssuer(p.meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(idTokenLeeway),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse id token")
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	return claims, nil
+}
+
+// key returns the signing key of kid, refetching the jwks once in a
+// while for keys rotated in by the issuer.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, errors.Errorf("unknown signing key %q", kid)
+	}
+	if err := p.refreshKeysLocked(ctx); err != nil {
+		return nil, errors.Wrap(err, "refresh jwks")
+	}
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+
+	return nil, errors.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds kid, a token without kid is accepted only if the
+// issuer publishes exactly one key.
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+func (p *Provider) refreshKeys(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.refreshKeysLocked(ctx)
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) refreshKeysLocked(ctx context.Context) error {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	p.keysFetched = time.Now()
+	if err := p.getJSON(ctx, p.meta.JWKSURI, &jwks); err != nil {
+		return errors.Wrap(err, "get jwks")
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Logger.Named("sso").Debug("skip unsupported jwk", zap.String("kid", jwk.Kid), zap.Error(err))
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("jwks has no usable signing key")
+	}
+
+	p.keys = keys
+	return nil
+}
+
+// publicKey decodes RSA and EC keys.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(name, v string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+		if err != nil || len(b) == 0 {
+			return nil, errors.Errorf("invalid jwk %s", name)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpCli.Do(req) //nolint:bodyclose
+	if err != nil {
+		return errors.Wrapf(err, "get %q", url)
+	}
+	defer gutils.CloseWithLog(resp.Body, log.Logger)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("get %q returns %d", url, resp.StatusCode)
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseBytes)).Decode(v); err != nil {
+		return errors.Wrapf(err, "decode %q", url)
+	}
+
+	return nil
+}
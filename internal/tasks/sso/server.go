@@ -1,29 +1,41 @@
-// Package gptchat implements gptchat tasks.
+// Package sso implements OIDC single sign-on for gptchat and admin pages.
 package sso
 
-// import (
-// 	"net/http"
-
-// 	"github.com/Laisky/zap"
-// 	"github.com/gin-gonic/gin"
-
-// 	ihttp "github.com/Laisky/go-ramjet/internal/tasks/gptchat/http"
-// 	istatic "github.com/Laisky/go-ramjet/internal/tasks/gptchat/templates/static"
-// 	"github.com/Laisky/go-ramjet/library/log"
-// 	"github.com/Laisky/go-ramjet/library/web"
-// )
-
-// func bindHTTP() {
-// 	// FIXME not implemented
-// 	if err := ihttp.SetupHTTPCli(); err != nil {
-// 		log.Logger.Panic("setup http client", zap.Error(err))
-// 	}
-
-// 	ihttp.RegisterStatic(web.Server.Group("/static"))
-// 	web.Server.GET("/favicon.ico", func(ctx *gin.Context) {
-// 		ctx.Header("Cache-Control", "max-age=86400")
-// 		ctx.Data(http.StatusOK, "image/png", istatic.Favicon)
-// 	})
-// 	web.Server.Any("/api/", ihttp.APIHandler)
-// 	web.Server.GET("/", ihttp.Chat)
-// }
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/store"
+	"github.com/Laisky/go-ramjet/library/log"
+	rutils "github.com/Laisky/go-ramjet/library/redis"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+// bindTask bind sso task
+func bindTask() {
+	logger := log.Logger.Named("sso")
+	logger.Info("bind sso task...")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Panic("load sso config", zap.Error(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	provider, err := NewProvider(ctx, cfg, &http.Client{Timeout: 30 * time.Second})
+	if err != nil {
+		logger.Panic("discover oidc issuer", zap.String("issuer", cfg.Issuer), zap.Error(err))
+	}
+
+	instance = newService(cfg, provider, newRedisStore(rutils.GetCli().GetDB().Client))
+	instance.bindHTTP(web.Server.Group("/sso"))
+	logger.Info("bind sso task done", zap.String("issuer", cfg.Issuer))
+}
+
+func init() {
+	store.TaskStore.Store("sso", bindTask)
+}
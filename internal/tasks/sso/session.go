@@ -0,0 +1,329 @@
+package sso
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/Laisky/zap"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jinzhu/copier"
+
+	gptconfig "github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+const (
+	// pendingLoginTTL is how long a user has to finish login at the issuer.
+	pendingLoginTTL = 10 * time.Minute
+	// maxCookieBytes keeps signed cookies under what browsers store.
+	maxCookieBytes = 4000
+	// sessionIDBytes is the entropy of a session id.
+	sessionIDBytes = 32
+)
+
+// signPurposeLogin is the purpose of the signed login cookie, a value
+// signed for another purpose is rejected.
+const signPurposeLogin = "login"
+
+// Session is a logged in user, rebuilt on every request from the stored
+// session.
+type Session struct {
+	Subject       string    `json:"subject"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Name          string    `json:"name"`
+	Groups        []string  `json:"groups"`
+	Admin         bool      `json:"admin"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	// User is the gptchat user mapped from the claims
+	User *gptconfig.UserConfig `json:"-"`
+}
+
+// sessionToken is the stored record of a session. Rules are matched again
+// on every request, so a config change applies at once.
+type sessionToken struct {
+	Subject       string   `json:"sub"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	Groups        []string `json:"groups,omitempty"`
+	ExpiresAt     int64    `json:"exp"`
+}
+
+// pendingLogin is an authorization request waiting for its callback,
+// kept in a signed cookie of the browser that started it.
+type pendingLogin struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	Redirect  string `json:"redirect"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// service is the running sso task. Sessions live in the store, so they
+// survive restarts, are shared by every instance, and logout revokes them.
+type service struct {
+	cfg      *Config
+	provider *Provider
+	store    sessionStore
+}
+
+// instance is set once the sso task is bound, UserFromRequest is a no-op
+// before that.
+var instance *service
+
+func newService(cfg *Config, provider *Provider, store sessionStore) *service {
+	return &service{
+		cfg:      cfg,
+		provider: provider,
+		store:    store,
+	}
+}
+
+// Identity is the verified part of a session, UserByIdentity maps it to
+// a user again later, such as when an api key of the user is used.
+type Identity struct {
+	Subject string
+	// Email is empty unless the issuer verified it
+	Email  string
+	Groups []string
+}
+
+// IdentityFromRequest returns the identity of the request's session
+// cookie, ok is false if there is no valid session.
+func IdentityFromRequest(r *http.Request) (id Identity, ok bool) {
+	if instance == nil {
+		return id, false
+	}
+
+	sess, ok := instance.session(r)
+	if !ok {
+		return id, false
+	}
+
+	id = Identity{Subject: sess.Subject, Groups: slices.Clone(sess.Groups)}
+	if sess.EmailVerified {
+		id.Email = sess.Email
+	}
+	return id, true
+}
+
+// UserByIdentity returns the gptchat user the current rules map id to, ok
+// is false if sso is disabled or no rule matches any more.
+func UserByIdentity(id Identity) (user *gptconfig.UserConfig, ok bool) {
+	if instance == nil {
+		return nil, false
+	}
+
+	sess, err := instance.newSession(&sessionToken{
+		Subject:       id.Subject,
+		Email:         id.Email,
+		EmailVerified: id.Email != "",
+		Groups:        id.Groups,
+	})
+	if err != nil {
+		return nil, false
+	}
+	return sess.User, true
+}
+
+// UserFromRequest returns the gptchat user of the request's session
+// cookie, ok is false if there is no valid session.
+func UserFromRequest(r *http.Request) (user *gptconfig.UserConfig, ok bool) {
+	if instance == nil {
+		return nil, false
+	}
+
+	sess, ok := instance.session(r)
+	if !ok {
+		return nil, false
+	}
+	return sess.User, true
+}
+
+// session loads the session of the request's cookie from the store.
+func (s *service) session(r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(s.cfg.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+
+	tok, err := s.store.Load(r.Context(), cookie.Value)
+	if err != nil {
+		log.Logger.Named("sso").Warn("load session", zap.Error(err))
+		return nil, false
+	}
+	if tok == nil || time.Now().Unix() >= tok.ExpiresAt {
+		return nil, false
+	}
+
+	sess, err := s.newSession(tok)
+	if err != nil {
+		return nil, false
+	}
+	return sess, true
+}
+
+// newSessionToken reads the claims of a verified id token. Only groups
+// named by some rule are kept, the rest could not match anything.
+func (s *service) newSessionToken(claims jwt.MapClaims) *sessionToken {
+	tok := &sessionToken{
+		Subject:   claimString(claims, "sub"),
+		Email:     strings.ToLower(claimString(claims, "email")),
+		Name:      claimString(claims, "name"),
+		ExpiresAt: time.Now().Add(s.cfg.SessionTTL()).Unix(),
+	}
+
+	// an email is only trusted if the issuer says so explicitly
+	verified := claims["email_verified"]
+	tok.EmailVerified = verified == true || verified == "true"
+
+	for _, g := range claimStrings(claims, s.cfg.GroupsClaim) {
+		for i := range s.cfg.Users {
+			if slices.Contains(s.cfg.Users[i].Groups, g) {
+				tok.Groups = append(tok.Groups, g)
+				break
+			}
+		}
+	}
+
+	return tok
+}
+
+// newSession maps a session token to a session, logins that match no
+// rule are rejected.
+func (s *service) newSession(tok *sessionToken) (*Session, error) {
+	sess := &Session{
+		Subject:       tok.Subject,
+		Email:         tok.Email,
+		EmailVerified: tok.EmailVerified,
+		Name:          tok.Name,
+		Groups:        tok.Groups,
+		ExpiresAt:     time.Unix(tok.ExpiresAt, 0),
+	}
+
+	// an unverified email must not match email rules
+	email := sess.Email
+	if !sess.EmailVerified {
+		email = ""
+	}
+
+	rule := matchRule(s.cfg.Users, email, sess.Groups)
+	if rule == nil {
+		return nil, errors.Errorf("user %q is not allowed to login", gutils.OptionalVal(&sess.Email, sess.Subject))
+	}
+	sess.Admin = rule.Admin
+
+	sess.User = new(gptconfig.UserConfig)
+	if err := copier.CopyWithOption(sess.User, &rule.User, copier.Option{DeepCopy: true}); err != nil {
+		return nil, errors.Wrap(err, "copy user template")
+	}
+	if sess.User.UserName == "" {
+		sess.User.UserName = gutils.OptionalVal(&sess.Email, sess.Subject)
+	}
+	if sess.User.Token == "" {
+		// a stable non-secret tenant token, the upstream token falls back
+		// to the server's default in UserConfig.Valid
+		hashed := sha256.Sum256([]byte(s.cfg.Issuer + "|" + sess.Subject))
+		sess.User.Token = "sso-" + hex.EncodeToString(hashed[:])[:24]
+	}
+
+	return sess, nil
+}
+
+// sign returns v as a cookie value signed for purpose.
+func (s *service) sign(purpose string, v any) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal cookie")
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	value := encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(purpose, encoded))
+	if len(value) > maxCookieBytes {
+		return "", errors.Errorf("cookie of %d bytes is too large", len(value))
+	}
+	return value, nil
+}
+
+// verify checks the signature of a cookie value signed for purpose and
+// unmarshals it into v.
+func (s *service) verify(purpose, value string, v any) error {
+	encoded, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return errors.New("malformed cookie")
+	}
+
+	gotMAC, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotMAC, s.mac(purpose, encoded)) {
+		return errors.New("invalid cookie signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.Wrap(err, "decode cookie")
+	}
+	return errors.Wrap(json.Unmarshal(payload, v), "unmarshal cookie")
+}
+
+func (s *service) mac(purpose, encoded string) []byte {
+	h := hmac.New(sha256.New, []byte(s.cfg.SessionSecret))
+	_, _ = h.Write([]byte(purpose + "|" + encoded))
+	return h.Sum(nil)
+}
+
+// matchRule returns the first rule matching the verified email or any
+// group.
+func matchRule(rules []UserRule, email string, groups []string) *UserRule {
+	for i := range rules {
+		rule := &rules[i]
+		if email != "" {
+			for _, e := range rule.Emails {
+				e = strings.ToLower(strings.TrimSpace(e))
+				if e == email || (strings.HasPrefix(e, "@") && strings.HasSuffix(email, e)) {
+					return rule
+				}
+			}
+		}
+
+		for _, g := range rule.Groups {
+			if slices.Contains(groups, g) {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	v, _ := claims[name].(string)
+	return strings.TrimSpace(v)
+}
+
+// claimStrings reads a claim that is either a string or a list of strings.
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var vals []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+		return vals
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,488 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	gptconfig "github.com/Laisky/go-ramjet/internal/tasks/gptchat/config"
+)
+
+const (
+	testClientID      = "ramjet"
+	testClientSecret  = "s3cret"
+	testSessionSecret = "0123456789abcdef0123456789abcdef"
+)
+
+// memStore is an in-memory sessionStore.
+type memStore struct {
+	mu       sync.Mutex
+	sessions map[string]sessionToken
+}
+
+func newMemStore() *memStore {
+	return &memStore{sessions: map[string]sessionToken{}}
+}
+
+func (m *memStore) Save(_ context.Context, id string, tok *sessionToken, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[id] = *tok
+	return nil
+}
+
+func (m *memStore) Load(_ context.Context, id string) (*sessionToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tok, ok := m.sessions[id]
+	if !ok {
+		return nil, nil //nolint:nilnil
+	}
+	return &tok, nil
+}
+
+func (m *memStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// mockIssuer is a minimal OIDC issuer, the user logs in as claims.
+type mockIssuer struct {
+	*httptest.Server
+	t      *testing.T
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockIssuer{t: t, key: key, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := "code-" + q.Get("state")
+		m.mu.Lock()
+		m.codes[code] = q
+		m.mu.Unlock()
+
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{
+			"code": {code}, "state": {q.Get("state")},
+		}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		id, secret, _ := r.BasicAuth()
+		if id != testClientID || secret != testClientSecret {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+
+		m.mu.Lock()
+		auth, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		m.mu.Unlock()
+		hashed := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(hashed[:]) != auth.Get("code_challenge") {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, map[string]any{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"id_token":     m.sign(auth.Get("nonce")),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIssuer) sign(nonce string) string {
+	claims := jwt.MapClaims{
+		"iss":   m.URL,
+		"aud":   testClientID,
+		"sub":   "user-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(m.key)
+	require.NoError(m.t, err)
+	return signed
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	body, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+func setupService(t *testing.T, issuer *mockIssuer) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := &Config{
+		Issuer:        issuer.URL,
+		ClientID:      testClientID,
+		ClientSecret:  testClientSecret,
+		RedirectURL:   "https://app.example.com/sso/callback",
+		SessionSecret: testSessionSecret,
+		Users: []UserRule{
+			{Groups: []string{"ops"}, Admin: true,
+				User: gptconfig.UserConfig{AllowedModels: []string{"*"}, BYOK: true}},
+			{Emails: []string{"@example.com"},
+				User: gptconfig.UserConfig{AllowedModels: []string{"gpt-4o-mini"}}},
+		},
+	}
+	require.NoError(t, cfg.fillDefault())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	provider, err := NewProvider(ctx, cfg, issuer.Client())
+	require.NoError(t, err)
+
+	original := instance
+	instance = newService(cfg, provider, newMemStore())
+	t.Cleanup(func() { instance = original })
+
+	router := gin.New()
+	instance.bindHTTP(router.Group("/sso"))
+	router.GET("/admin", RequireSession(true), func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+	return router
+}
+
+// login runs the browser side of the flow and returns the callback response.
+func login(t *testing.T, router *gin.Engine, issuer *mockIssuer, redirect string) *httptest.ResponseRecorder {
+	t.Helper()
+	callback, loginCookie := startLogin(t, router, issuer, redirect)
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/sso/callback?"+callback.RawQuery, nil)
+	req.AddCookie(loginCookie)
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+// startLogin runs the browser side of the flow up to the issuer's redirect
+// back, it returns the callback url and the login cookie.
+func startLogin(t *testing.T, router *gin.Engine, issuer *mockIssuer, redirect string) (*url.URL, *http.Cookie) {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/sso/login?redirect="+url.QueryEscape(redirect), nil))
+	require.Equal(t, http.StatusFound, resp.Code)
+	loginCookie := findCookie(t, resp, "ramjet_sso_session_login")
+	require.True(t, loginCookie.HttpOnly)
+	require.Equal(t, "/sso/callback", loginCookie.Path)
+
+	authorize := resp.Header().Get("Location")
+	require.Contains(t, authorize, issuer.URL+"/authorize?")
+	authURL, err := url.Parse(authorize)
+	require.NoError(t, err)
+	require.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+
+	cli := issuer.Client()
+	cli.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	issuerResp, err := cli.Get(authorize)
+	require.NoError(t, err)
+	require.NoError(t, issuerResp.Body.Close())
+	callback, err := url.Parse(issuerResp.Header.Get("Location"))
+	require.NoError(t, err)
+	return callback, loginCookie
+}
+
+func sessionCookie(t *testing.T, resp *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	return findCookie(t, resp, "ramjet_sso_session")
+}
+
+func findCookie(t *testing.T, resp *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	require.FailNow(t, "no cookie "+name)
+	return nil
+}
+
+func TestLoginFlow(t *testing.T) {
+	issuer := newMockIssuer(t)
+	router := setupService(t, issuer)
+	issuer.claims = jwt.MapClaims{"email": "Alice@Example.com", "email_verified": true, "name": "Alice"}
+
+	resp := login(t, router, issuer, "/gptchat/")
+	require.Equal(t, http.StatusFound, resp.Code, resp.Body.String())
+	require.Equal(t, "/gptchat/", resp.Header().Get("Location"))
+	cookie := sessionCookie(t, resp)
+	require.True(t, cookie.HttpOnly)
+	require.True(t, cookie.Secure)
+	require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	req := httptest.NewRequest(http.MethodGet, "/sso/me", nil)
+	req.AddCookie(cookie)
+	user, ok := UserFromRequest(req)
+	require.True(t, ok)
+	require.Equal(t, "alice@example.com", user.UserName)
+	require.Equal(t, []string{"gpt-4o-mini"}, user.AllowedModels)
+	require.False(t, user.BYOK)
+	require.NotEmpty(t, user.Token)
+
+	// callers get a copy
+	user.AllowedModels[0] = "o3"
+	user, _ = UserFromRequest(req)
+	require.Equal(t, []string{"gpt-4o-mini"}, user.AllowedModels)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"username":"alice@example.com"`)
+
+	// not an admin
+	req = httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	// the session is in the store, so it survives a restart
+	instance = newService(instance.cfg, instance.provider, instance.store)
+	_, ok = UserFromRequest(req)
+	require.True(t, ok)
+
+	// a tampered cookie is rejected
+	forged := httptest.NewRequest(http.MethodGet, "/sso/me", nil)
+	forged.AddCookie(&http.Cookie{Name: cookie.Name, Value: "x" + cookie.Value})
+	_, ok = UserFromRequest(forged)
+	require.False(t, ok)
+
+	// logout drops the session cookie
+	req = httptest.NewRequest(http.MethodPost, "/sso/logout", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Negative(t, sessionCookie(t, resp).MaxAge)
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	issuer := newMockIssuer(t)
+	router := setupService(t, issuer)
+	issuer.claims = jwt.MapClaims{"email": "alice@example.com", "email_verified": true}
+
+	resp := login(t, router, issuer, "/")
+	require.Equal(t, http.StatusFound, resp.Code, resp.Body.String())
+	cookie := sessionCookie(t, resp)
+
+	// the cookie is an opaque id, not the claims
+	require.NotContains(t, cookie.Value, ".")
+
+	req := httptest.NewRequest(http.MethodGet, "/sso/me", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/sso/logout", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// a copy of the cookie kept from before the logout is rejected
+	req = httptest.NewRequest(http.MethodGet, "/sso/me", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	_, ok := UserFromRequest(req)
+	require.False(t, ok)
+}
+
+func TestLoginFlowGroupsAndRejections(t *testing.T) {
+	issuer := newMockIssuer(t)
+	router := setupService(t, issuer)
+
+	issuer.claims = jwt.MapClaims{"email": "bob@other.org", "groups": []string{"dev", "ops"}}
+	resp := login(t, router, issuer, "https://evil.example.com/")
+	require.Equal(t, http.StatusFound, resp.Code, resp.Body.String())
+	require.Equal(t, "/", resp.Header().Get("Location"), "no open redirect")
+
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.AddCookie(sessionCookie(t, resp))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	user, ok := UserFromRequest(req)
+	require.True(t, ok)
+	require.True(t, user.BYOK)
+
+	// an unverified email does not match email rules
+	issuer.claims = jwt.MapClaims{"email": "mallory@example.com", "email_verified": false}
+	resp = login(t, router, issuer, "/")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	// no session at all
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/sso/me", nil))
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// an email without email_verified is not trusted either
+	issuer.claims = jwt.MapClaims{"email": "mallory@example.com"}
+	resp = login(t, router, issuer, "/")
+	require.Equal(t, http.StatusForbidden, resp.Code)
+
+	// unknown state
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/sso/callback?code=x&state=forged", nil))
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestCallbackRequiresLoginCookie(t *testing.T) {
+	issuer := newMockIssuer(t)
+	router := setupService(t, issuer)
+	issuer.claims = jwt.MapClaims{"email": "alice@example.com", "email_verified": true}
+
+	// a callback url planted in another browser does not log it in
+	callback, loginCookie := startLogin(t, router, issuer, "/")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/sso/callback?"+callback.RawQuery, nil))
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// nor does the login cookie of another login
+	_, otherCookie := startLogin(t, router, issuer, "/")
+	req := httptest.NewRequest(http.MethodGet, "/sso/callback?"+callback.RawQuery, nil)
+	req.AddCookie(otherCookie)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// nor a tampered login cookie
+	req = httptest.NewRequest(http.MethodGet, "/sso/callback?"+callback.RawQuery, nil)
+	req.AddCookie(&http.Cookie{Name: loginCookie.Name, Value: "x" + loginCookie.Value})
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/sso/callback?"+callback.RawQuery, nil)
+	req.AddCookie(loginCookie)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusFound, resp.Code, resp.Body.String())
+	require.Negative(t, findCookie(t, resp, loginCookie.Name).MaxAge, "login cookie is redeemed once")
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	issuer := newMockIssuer(t)
+	setupService(t, issuer)
+	ctx := context.Background()
+
+	_, err := instance.provider.VerifyIDToken(ctx, issuer.sign("n1"), "n2")
+	require.ErrorContains(t, err, "nonce mismatch")
+
+	issuer.claims = jwt.MapClaims{"aud": "someone-else"}
+	_, err = instance.provider.VerifyIDToken(ctx, issuer.sign("n1"), "n1")
+	require.ErrorContains(t, err, "aud")
+
+	issuer.claims = jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}
+	_, err = instance.provider.VerifyIDToken(ctx, issuer.sign("n1"), "n1")
+	require.ErrorContains(t, err, "expired")
+
+	// signed by a key the issuer does not publish
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer.claims = nil
+	issuer.key, other = other, issuer.key
+	forged := issuer.sign("n1")
+	issuer.key = other
+	_, err = instance.provider.VerifyIDToken(ctx, forged, "n1")
+	require.ErrorContains(t, err, "verification")
+
+	_, err = instance.provider.VerifyIDToken(ctx, issuer.sign("n1"), "n1")
+	require.NoError(t, err)
+}
+
+func TestConfigFillDefault(t *testing.T) {
+	cfg := &Config{Issuer: "https://id.example.com/", ClientID: "c", RedirectURL: "https://x/cb"}
+	require.ErrorContains(t, cfg.fillDefault(), "users is empty")
+
+	cfg.Users = []UserRule{{}}
+	require.ErrorContains(t, cfg.fillDefault(), "session_secret")
+
+	cfg.SessionSecret = testSessionSecret
+	require.ErrorContains(t, cfg.fillDefault(), "matches nobody")
+
+	cfg.Users = []UserRule{{Emails: []string{"a@b.c"}}}
+	require.NoError(t, cfg.fillDefault())
+	require.Equal(t, "https://id.example.com", cfg.Issuer)
+	require.Equal(t, []string{"openid", "email", "profile"}, cfg.Scopes)
+	require.Equal(t, 7*24*time.Hour, cfg.SessionTTL())
+}
+
+func TestUserByIdentity(t *testing.T) {
+	issuer := newMockIssuer(t)
+	router := setupService(t, issuer)
+	issuer.claims = jwt.MapClaims{"email": "alice@example.com", "email_verified": true, "groups": []string{"dev"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/sso/me", nil)
+	req.AddCookie(sessionCookie(t, login(t, router, issuer, "/")))
+	id, ok := IdentityFromRequest(req)
+	require.True(t, ok)
+	require.Equal(t, Identity{Subject: "user-1", Email: "alice@example.com"}, id, "only groups named by rules are kept")
+
+	user, ok := UserByIdentity(id)
+	require.True(t, ok)
+	require.Equal(t, "alice@example.com", user.UserName)
+	require.Equal(t, []string{"gpt-4o-mini"}, user.AllowedModels)
+
+	// a user dropped from the rules no longer resolves
+	instance.cfg.Users = instance.cfg.Users[:1]
+	_, ok = UserByIdentity(id)
+	require.False(t, ok)
+}
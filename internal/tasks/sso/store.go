@@ -0,0 +1,67 @@
+package sso
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeySessionPrefix = "ramjet:sso:session:"
+
+// sessionStore keeps the sessions, keyed by the opaque id in the
+// session cookie.
+type sessionStore interface {
+	// Save stores tok under id, expired after ttl
+	Save(ctx context.Context, id string, tok *sessionToken, ttl time.Duration) error
+	// Load returns the session of id, or nil if there is none
+	Load(ctx context.Context, id string) (*sessionToken, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// redisStore keeps each session as json in its own key, so redis expires
+// it with the session.
+type redisStore struct {
+	cli *redis.Client
+}
+
+func newRedisStore(cli *redis.Client) *redisStore {
+	return &redisStore{cli: cli}
+}
+
+// sessionKey hashes the id, a dump of redis must not yield live cookies.
+func sessionKey(id string) string {
+	hashed := sha256.Sum256([]byte(id))
+	return redisKeySessionPrefix + hex.EncodeToString(hashed[:])
+}
+
+func (s *redisStore) Save(ctx context.Context, id string, tok *sessionToken, ttl time.Duration) error {
+	payload, err := json.Marshal(tok)
+	if err != nil {
+		return errors.Wrap(err, "marshal session")
+	}
+	return errors.Wrap(s.cli.Set(ctx, sessionKey(id), payload, ttl).Err(), "save session")
+}
+
+func (s *redisStore) Load(ctx context.Context, id string) (*sessionToken, error) {
+	payload, err := s.cli.Get(ctx, sessionKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil //nolint:nilnil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "load session")
+	}
+
+	tok := new(sessionToken)
+	if err = json.Unmarshal(payload, tok); err != nil {
+		return nil, errors.Wrap(err, "unmarshal session")
+	}
+	return tok, nil
+}
+
+func (s *redisStore) Delete(ctx context.Context, id string) error {
+	return errors.Wrap(s.cli.Del(ctx, sessionKey(id)).Err(), "delete session")
+}
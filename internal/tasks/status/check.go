@@ -0,0 +1,44 @@
+// Package status implements the public status page.
+//
+// Tasks publish the result of each health check as CheckEvt,
+// the status task keeps the uptime history in hourly buckets,
+// derives incidents from status transitions, and serves them
+// as a read-only status page.
+package status
+
+import (
+	"time"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/store"
+)
+
+// CheckEvt is triggered by each health check, its result is *Check.
+const CheckEvt = "status_check"
+
+// Check is the result of a health check of a service.
+type Check struct {
+	Service string
+	// Source is the task that checked the service
+	Source  string
+	Up      bool
+	Latency time.Duration
+	At      time.Time
+}
+
+// NewCheck returns the check of service, it is up if err is nil.
+func NewCheck(service, source string, latency time.Duration, err error) *Check {
+	return &Check{
+		Service: service,
+		Source:  source,
+		Up:      err == nil,
+		Latency: latency,
+		At:      time.Now(),
+	}
+}
+
+// Publish triggers CheckEvt of service.
+func Publish(service, source string, latency time.Duration, err error) {
+	store.TaskStore.Trigger(CheckEvt, map[string]interface{}{
+		"service": service,
+	}, NewCheck(service, source, latency, err), err)
+}
@@ -0,0 +1,211 @@
+package status
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix       = "ramjet:status:"
+	redisKeyServices     = redisKeyPrefix + "services"
+	redisKeyIncidents    = redisKeyPrefix + "incidents"
+	redisKeyUptimePrefix = redisKeyPrefix + "uptime:"
+
+	// retention of uptime buckets and incidents
+	retention = 31 * 24 * time.Hour
+)
+
+// Bucket counts the checks of a service in an hour.
+type Bucket struct {
+	Start time.Time
+	Up    int64
+	Total int64
+}
+
+// ServiceState is the current status of a service.
+type ServiceState struct {
+	Name        string        `json:"name"`
+	Source      string        `json:"source"`
+	Up          bool          `json:"up"`
+	Since       time.Time     `json:"since"`
+	LastCheckAt time.Time     `json:"last_check_at"`
+	Latency     time.Duration `json:"latency"`
+	// OpenIncident is the incident of the service, if it is down
+	OpenIncident *Incident `json:"open_incident,omitempty"`
+}
+
+// Incident is a period the service is down.
+type Incident struct {
+	ID        string    `json:"id"`
+	Service   string    `json:"service"`
+	StartedAt time.Time `json:"started_at"`
+	// EndedAt is zero if the incident is ongoing
+	EndedAt time.Time `json:"ended_at"`
+}
+
+// historyStore persists the uptime history.
+type historyStore interface {
+	// AddCheck counts c into its hourly bucket
+	AddCheck(ctx context.Context, c *Check) error
+	// LoadBuckets returns the non-empty hourly buckets of service since since, in any order
+	LoadBuckets(ctx context.Context, service string, since time.Time) ([]Bucket, error)
+	SaveService(ctx context.Context, s *ServiceState) error
+	LoadServices(ctx context.Context) ([]*ServiceState, error)
+	SaveIncident(ctx context.Context, inc *Incident) error
+	LoadIncidents(ctx context.Context) ([]*Incident, error)
+	DeleteIncident(ctx context.Context, id string) error
+}
+
+// redisStore keeps the buckets of a service of a day in a hash,
+// with fields `<hour>:up` and `<hour>:n`, expired after retention.
+type redisStore struct {
+	cli *redis.Client
+}
+
+func newRedisStore(cli *redis.Client) *redisStore {
+	return &redisStore{cli: cli}
+}
+
+func uptimeKey(service string, day time.Time) string {
+	return redisKeyUptimePrefix + service + ":" + day.UTC().Format("20060102")
+}
+
+func (s *redisStore) AddCheck(ctx context.Context, c *Check) error {
+	at := c.At.UTC()
+	key := uptimeKey(c.Service, at)
+	hour := strconv.Itoa(at.Hour())
+
+	pipe := s.cli.TxPipeline()
+	pipe.HIncrBy(ctx, key, hour+":n", 1)
+	if c.Up {
+		pipe.HIncrBy(ctx, key, hour+":up", 1)
+	}
+	pipe.Expire(ctx, key, retention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrapf(err, "add check to %s", key)
+	}
+
+	return nil
+}
+
+func (s *redisStore) LoadBuckets(ctx context.Context, service string, since time.Time) ([]Bucket, error) {
+	since = since.UTC()
+	pipe := s.cli.Pipeline()
+	days := map[time.Time]*redis.MapStringStringCmd{}
+	for day := since.Truncate(24 * time.Hour); !day.After(time.Now()); day = day.Add(24 * time.Hour) {
+		days[day] = pipe.HGetAll(ctx, uptimeKey(service, day))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, errors.Wrapf(err, "load buckets of %s", service)
+	}
+
+	var buckets []Bucket
+	for day, cmd := range days {
+		hours := map[int]*Bucket{}
+		for field, val := range cmd.Val() {
+			hourStr, kind, ok := strings.Cut(field, ":")
+			hour, err := strconv.Atoi(hourStr)
+			if !ok || err != nil {
+				continue
+			}
+			cnt, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			b := hours[hour]
+			if b == nil {
+				b = &Bucket{Start: day.Add(time.Duration(hour) * time.Hour)}
+				hours[hour] = b
+			}
+			switch kind {
+			case "n":
+				b.Total = cnt
+			case "up":
+				b.Up = cnt
+			}
+		}
+
+		for _, b := range hours {
+			if b.Total > 0 && !b.Start.Before(since.Truncate(time.Hour)) {
+				buckets = append(buckets, *b)
+			}
+		}
+	}
+
+	return buckets, nil
+}
+
+func (s *redisStore) SaveService(ctx context.Context, svc *ServiceState) error {
+	payload, err := json.Marshal(svc)
+	if err != nil {
+		return errors.Wrap(err, "marshal service")
+	}
+	if err = s.cli.HSet(ctx, redisKeyServices, svc.Name, payload).Err(); err != nil {
+		return errors.Wrapf(err, "save service %s", svc.Name)
+	}
+
+	return nil
+}
+
+func (s *redisStore) LoadServices(ctx context.Context) ([]*ServiceState, error) {
+	vals, err := s.cli.HGetAll(ctx, redisKeyServices).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "load services")
+	}
+
+	svcs := make([]*ServiceState, 0, len(vals))
+	for name, val := range vals {
+		svc := new(ServiceState)
+		if err = json.Unmarshal([]byte(val), svc); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal service %s", name)
+		}
+		svcs = append(svcs, svc)
+	}
+
+	return svcs, nil
+}
+
+func (s *redisStore) SaveIncident(ctx context.Context, inc *Incident) error {
+	payload, err := json.Marshal(inc)
+	if err != nil {
+		return errors.Wrap(err, "marshal incident")
+	}
+	if err = s.cli.HSet(ctx, redisKeyIncidents, inc.ID, payload).Err(); err != nil {
+		return errors.Wrapf(err, "save incident %s", inc.ID)
+	}
+
+	return nil
+}
+
+func (s *redisStore) LoadIncidents(ctx context.Context) ([]*Incident, error) {
+	vals, err := s.cli.HGetAll(ctx, redisKeyIncidents).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "load incidents")
+	}
+
+	incs := make([]*Incident, 0, len(vals))
+	for id, val := range vals {
+		inc := new(Incident)
+		if err = json.Unmarshal([]byte(val), inc); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal incident %s", id)
+		}
+		incs = append(incs, inc)
+	}
+
+	return incs, nil
+}
+
+func (s *redisStore) DeleteIncident(ctx context.Context, id string) error {
+	if err := s.cli.HDel(ctx, redisKeyIncidents, id).Err(); err != nil {
+		return errors.Wrapf(err, "delete incident %s", id)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package status
+
+import (
+	"net/http"
+	"time"
+
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	"github.com/gin-gonic/gin"
+
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+const (
+	statusSitePathPrefix = "/status"
+	statusSiteID         = "status"
+	statusSiteTheme      = "default"
+)
+
+// buildStatusSiteMetadata returns the site metadata of the status page titled title.
+func buildStatusSiteMetadata(title string) web.SiteMetadata {
+	return web.SiteMetadata{
+		ID:            statusSiteID,
+		Theme:         statusSiteTheme,
+		Title:         title,
+		Description:   "Current status, uptime history and incidents of services.",
+		OGTitle:       title,
+		OGDescription: "Current status, uptime history and incidents of services.",
+	}
+}
+
+type router struct {
+	tracker *tracker
+}
+
+func newRouter(t *tracker) *router {
+	return &router{tracker: t}
+}
+
+// bindHTTP registers the read-only api of the status page.
+func (r *router) bindHTTP(grp gin.IRouter) {
+	grp.GET("/api/summary", r.getSummary)
+}
+
+// getSummary returns the public status of services.
+func (r *router) getSummary(ctx *gin.Context) {
+	sum, err := r.tracker.summarize(gmw.Ctx(ctx), time.Now())
+	if web.AbortErr(ctx, err) {
+		return
+	}
+
+	ctx.Header("Cache-Control", "public, max-age=30")
+	ctx.JSON(http.StatusOK, sum)
+}
@@ -0,0 +1,100 @@
+package status
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	gconfig "github.com/Laisky/go-config/v2"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/internal/tasks/heartbeat"
+	"github.com/Laisky/go-ramjet/internal/tasks/store"
+	"github.com/Laisky/go-ramjet/library/log"
+	rutils "github.com/Laisky/go-ramjet/library/redis"
+	"github.com/Laisky/go-ramjet/library/web"
+)
+
+const (
+	taskName = "status"
+	// heartbeatService is the service of ramjet itself, checked by heartbeat
+	heartbeatService  = "ramjet"
+	defaultStaleAfter = 15 * time.Minute
+	defaultSiteTitle  = "Laisky Status"
+	storeTimeout      = 10 * time.Second
+)
+
+var (
+	logger = log.Logger.Named("status")
+
+	// activeTracker is nil until the task is bound
+	activeTracker atomic.Pointer[tracker]
+)
+
+// onCheck records the check of CheckEvt.
+func onCheck(evt *store.Event) {
+	c, ok := evt.Result.(*Check)
+	t := activeTracker.Load()
+	if !ok || t == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := t.observe(ctx, c); err != nil {
+		logger.Error("record check", zap.String("service", c.Service), zap.Error(err))
+	}
+}
+
+// onHeartbeat records ramjet itself as up.
+func onHeartbeat(evt *store.Event) {
+	onCheck(&store.Event{Result: NewCheck(heartbeatService, "heartbeat", 0, nil)})
+}
+
+func runTask() {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := activeTracker.Load().pruneIncidents(ctx, time.Now()); err != nil {
+		logger.Error("prune incidents", zap.Error(err))
+	}
+}
+
+func bindTask() {
+	logger.Info("bind status task...")
+	staleAfter := gconfig.Shared.GetDuration("tasks.status.stale_after")
+	if staleAfter <= 0 {
+		staleAfter = defaultStaleAfter
+	}
+	title := gconfig.Shared.GetString("tasks.status.title")
+	if title == "" {
+		title = defaultSiteTitle
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	t, err := newTracker(ctx,
+		newRedisStore(rutils.GetCli().GetDB().Client),
+		gconfig.Shared.GetStringSlice("tasks.status.services"),
+		staleAfter)
+	if err != nil {
+		logger.Panic("new status tracker", zap.Error(err))
+	}
+	activeTracker.Store(t)
+
+	siteMeta := buildStatusSiteMetadata(title)
+	hosts := append([]string{statusSitePathPrefix}, gconfig.Shared.GetStringSlice("tasks.status.hosts")...)
+	web.RegisterSiteMetadata(hosts, siteMeta)
+	logger.Debug("register status site metadata",
+		zap.Strings("hosts", hosts),
+		zap.String("site_id", siteMeta.ID),
+		zap.String("title", siteMeta.Title))
+
+	newRouter(t).bindHTTP(web.Server.Group(statusSitePathPrefix))
+	go store.TaskStore.TickerAfterRun(24*time.Hour, runTask)
+}
+
+func init() {
+	store.TaskStore.Store(taskName, bindTask)
+	store.TaskStore.RegisterListener(CheckEvt, "status-record", onCheck)
+	store.TaskStore.RegisterListener(heartbeat.TaskDoneEvt, "status-heartbeat", onHeartbeat)
+}
@@ -0,0 +1,257 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// Statuses of services in the summary.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+	// StatusStale means the service has not been checked for a while
+	StatusStale = "stale"
+	// StatusDegraded is the overall status if any service is not up
+	StatusDegraded = "degraded"
+)
+
+const maxIncidents = 50
+
+// allServices in the allow list makes every service public.
+const allServices = "*"
+
+// tracker records checks, and derives incidents from status transitions.
+type tracker struct {
+	mu sync.Mutex
+	// saveMu orders the writes of observe, it is taken before mu is
+	// released so writes land in the order of the transitions
+	saveMu   sync.Mutex
+	store    historyStore
+	services map[string]*ServiceState
+	// allow lists the public services, none is public if empty
+	allow      []string
+	staleAfter time.Duration
+}
+
+func newTracker(ctx context.Context, store historyStore, allow []string, staleAfter time.Duration) (*tracker, error) {
+	svcs, err := store.LoadServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tracker{
+		store:      store,
+		services:   map[string]*ServiceState{},
+		allow:      allow,
+		staleAfter: staleAfter,
+	}
+	for _, svc := range svcs {
+		t.services[svc.Name] = svc
+	}
+
+	return t, nil
+}
+
+// observe records c, opens an incident once the service is down,
+// and closes it once the service is up again.
+func (t *tracker) observe(ctx context.Context, c *Check) error {
+	if err := t.store.AddCheck(ctx, c); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	svc, ok := t.services[c.Service]
+	if !ok {
+		svc = &ServiceState{Name: c.Service, Up: true, Since: c.At}
+		t.services[c.Service] = svc
+	}
+	svc.Source = c.Source
+	svc.LastCheckAt = c.At
+	svc.Latency = c.Latency
+
+	// the store is written out of mu, with copies of the states
+	var incidents []Incident
+	switch {
+	case !c.Up && svc.OpenIncident == nil:
+		svc.OpenIncident = &Incident{
+			ID:        fmt.Sprintf("%s-%d", c.Service, c.At.Unix()),
+			Service:   c.Service,
+			StartedAt: c.At,
+		}
+		incidents = append(incidents, *svc.OpenIncident)
+	case c.Up && svc.OpenIncident != nil:
+		svc.OpenIncident.EndedAt = c.At
+		incidents = append(incidents, *svc.OpenIncident)
+		svc.OpenIncident = nil
+	}
+	if svc.Up != c.Up {
+		svc.Up = c.Up
+		svc.Since = c.At
+	}
+	state := *svc
+	if state.OpenIncident != nil {
+		inc := *state.OpenIncident
+		state.OpenIncident = &inc
+	}
+
+	t.saveMu.Lock()
+	t.mu.Unlock()
+	defer t.saveMu.Unlock()
+
+	for i := range incidents {
+		if err := t.store.SaveIncident(ctx, &incidents[i]); err != nil {
+			return err
+		}
+	}
+	return t.store.SaveService(ctx, &state)
+}
+
+// isPublic reports whether service is shown on the status page.
+// Services are private unless listed, or the list has allServices.
+func (t *tracker) isPublic(service string) bool {
+	return slices.Contains(t.allow, service) || slices.Contains(t.allow, allServices)
+}
+
+// pruneIncidents deletes the incidents ended before retention.
+func (t *tracker) pruneIncidents(ctx context.Context, now time.Time) error {
+	incs, err := t.store.LoadIncidents(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, inc := range incs {
+		if !inc.EndedAt.IsZero() && now.Sub(inc.EndedAt) > retention {
+			errs = append(errs, t.store.DeleteIncident(ctx, inc.ID))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ServiceSummary is the public status of a service.
+type ServiceSummary struct {
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Since       time.Time `json:"since"`
+	LastCheckAt time.Time `json:"last_check_at"`
+	// Uptime are the ratios of successful checks, nil if not checked in the period
+	Uptime24h *float64 `json:"uptime_24h"`
+	Uptime7d  *float64 `json:"uptime_7d"`
+	Uptime30d *float64 `json:"uptime_30d"`
+	// Days are the daily uptime of the last 30 days, the oldest first
+	Days []DayUptime `json:"days"`
+}
+
+// DayUptime is the uptime of a service of a day.
+type DayUptime struct {
+	Date   string   `json:"date"`
+	Uptime *float64 `json:"uptime"`
+}
+
+// Summary is the public status page.
+type Summary struct {
+	Status    string            `json:"status"`
+	Services  []*ServiceSummary `json:"services"`
+	Incidents []*Incident       `json:"incidents"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+func uptimeOf(buckets []Bucket, since time.Time) *float64 {
+	var up, total int64
+	for _, b := range buckets {
+		if !b.Start.Before(since) {
+			up += b.Up
+			total += b.Total
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	ratio := float64(up) / float64(total)
+	return &ratio
+}
+
+// summarize returns the public status at now.
+func (t *tracker) summarize(ctx context.Context, now time.Time) (*Summary, error) {
+	t.mu.Lock()
+	var svcs []ServiceState
+	for _, svc := range t.services {
+		if t.isPublic(svc.Name) {
+			svcs = append(svcs, *svc)
+		}
+	}
+	t.mu.Unlock()
+	slices.SortFunc(svcs, func(a, b ServiceState) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	sum := &Summary{Status: StatusUp, UpdatedAt: now}
+	today := now.UTC().Truncate(24 * time.Hour)
+	since := today.Add(-29 * 24 * time.Hour)
+	for _, svc := range svcs {
+		buckets, err := t.store.LoadBuckets(ctx, svc.Name, since)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &ServiceSummary{
+			Name:        svc.Name,
+			Status:      StatusUp,
+			Since:       svc.Since,
+			LastCheckAt: svc.LastCheckAt,
+			Uptime24h:   uptimeOf(buckets, now.Add(-24*time.Hour).Truncate(time.Hour)),
+			Uptime7d:    uptimeOf(buckets, now.Add(-7*24*time.Hour).Truncate(time.Hour)),
+			Uptime30d:   uptimeOf(buckets, since),
+		}
+		switch {
+		case t.staleAfter > 0 && now.Sub(svc.LastCheckAt) > t.staleAfter:
+			s.Status = StatusStale
+		case !svc.Up:
+			s.Status = StatusDown
+		}
+		if s.Status != StatusUp {
+			sum.Status = StatusDegraded
+		}
+
+		for day := since; !day.After(today); day = day.Add(24 * time.Hour) {
+			var dayBuckets []Bucket
+			for _, b := range buckets {
+				if !b.Start.Before(day) && b.Start.Before(day.Add(24*time.Hour)) {
+					dayBuckets = append(dayBuckets, b)
+				}
+			}
+			s.Days = append(s.Days, DayUptime{
+				Date:   day.Format(time.DateOnly),
+				Uptime: uptimeOf(dayBuckets, day),
+			})
+		}
+
+		sum.Services = append(sum.Services, s)
+	}
+
+	incs, err := t.store.LoadIncidents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, inc := range incs {
+		if t.isPublic(inc.Service) && (inc.EndedAt.IsZero() || now.Sub(inc.EndedAt) <= 30*24*time.Hour) {
+			sum.Incidents = append(sum.Incidents, inc)
+		}
+	}
+	slices.SortFunc(sum.Incidents, func(a, b *Incident) int {
+		return b.StartedAt.Compare(a.StartedAt)
+	})
+	if len(sum.Incidents) > maxIncidents {
+		sum.Incidents = sum.Incidents[:maxIncidents]
+	}
+
+	return sum, nil
+}
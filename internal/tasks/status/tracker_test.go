@@ -0,0 +1,257 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is an in-memory historyStore.
+type memStore struct {
+	mu        sync.Mutex
+	buckets   map[string]map[time.Time]*Bucket
+	services  map[string]ServiceState
+	incidents map[string]Incident
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		buckets:   map[string]map[time.Time]*Bucket{},
+		services:  map[string]ServiceState{},
+		incidents: map[string]Incident{},
+	}
+}
+
+func (s *memStore) AddCheck(_ context.Context, c *Check) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets[c.Service] == nil {
+		s.buckets[c.Service] = map[time.Time]*Bucket{}
+	}
+	hour := c.At.UTC().Truncate(time.Hour)
+	b := s.buckets[c.Service][hour]
+	if b == nil {
+		b = &Bucket{Start: hour}
+		s.buckets[c.Service][hour] = b
+	}
+	b.Total++
+	if c.Up {
+		b.Up++
+	}
+	return nil
+}
+
+func (s *memStore) LoadBuckets(_ context.Context, service string, since time.Time) ([]Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bs []Bucket
+	for start, b := range s.buckets[service] {
+		if !start.Before(since.Truncate(time.Hour)) {
+			bs = append(bs, *b)
+		}
+	}
+	return bs, nil
+}
+
+func (s *memStore) SaveService(_ context.Context, svc *ServiceState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[svc.Name] = *svc
+	return nil
+}
+
+func (s *memStore) LoadServices(context.Context) ([]*ServiceState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var svcs []*ServiceState
+	for _, svc := range s.services {
+		svcs = append(svcs, &svc)
+	}
+	return svcs, nil
+}
+
+func (s *memStore) SaveIncident(_ context.Context, inc *Incident) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.incidents[inc.ID] = *inc
+	return nil
+}
+
+func (s *memStore) LoadIncidents(context.Context) ([]*Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var incs []*Incident
+	for _, inc := range s.incidents {
+		incs = append(incs, &inc)
+	}
+	return incs, nil
+}
+
+func (s *memStore) DeleteIncident(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.incidents, id)
+	return nil
+}
+
+func TestTrackerIncidents(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	tr, err := newTracker(ctx, store, nil, 0)
+	require.NoError(t, err)
+
+	start := time.Date(2026, 1, 10, 8, 0, 0, 0, time.UTC)
+	for i, up := range []bool{true, false, false, true, true, false} {
+		require.NoError(t, tr.observe(ctx, &Check{
+			Service: "blog",
+			Source:  "monitor",
+			Up:      up,
+			At:      start.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+
+	incs, err := store.LoadIncidents(ctx)
+	require.NoError(t, err)
+	require.Len(t, incs, 2)
+
+	svc := store.services["blog"]
+	require.False(t, svc.Up)
+	require.Equal(t, start.Add(5*time.Minute), svc.Since)
+	require.NotNil(t, svc.OpenIncident)
+
+	closed := store.incidents["blog-"+strconv.FormatInt(start.Add(time.Minute).Unix(), 10)]
+	require.Equal(t, start.Add(time.Minute), closed.StartedAt)
+	require.Equal(t, start.Add(3*time.Minute), closed.EndedAt)
+
+	// states survive restarts
+	tr, err = newTracker(ctx, store, nil, 0)
+	require.NoError(t, err)
+	require.NoError(t, tr.observe(ctx, &Check{Service: "blog", Up: true, At: start.Add(6 * time.Minute)}))
+	require.Equal(t, start.Add(6*time.Minute), store.incidents[svc.OpenIncident.ID].EndedAt)
+
+	// prune incidents ended before retention
+	require.NoError(t, tr.pruneIncidents(ctx, start.Add(retention+4*time.Minute)))
+	require.Len(t, store.incidents, 1)
+}
+
+func TestTrackerSummarize(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	tr, err := newTracker(ctx, store, []string{"blog", "api"}, 15*time.Minute)
+	require.NoError(t, err)
+
+	now := time.Date(2026, 1, 10, 12, 30, 0, 0, time.UTC)
+	observe := func(service string, at time.Time, up bool) {
+		require.NoError(t, tr.observe(ctx, &Check{Service: service, Up: up, At: at}))
+	}
+
+	// blog: down for 1 of 4 checks in the last day, all up 3 days ago
+	for i := range 4 {
+		observe("blog", now.Add(-3*24*time.Hour).Add(time.Duration(i)*time.Minute), true)
+	}
+	observe("blog", now.Add(-2*time.Hour), false)
+	for i := range 3 {
+		observe("blog", now.Add(-10*time.Minute).Add(time.Duration(i)*time.Minute), true)
+	}
+	// api: not checked recently
+	observe("api", now.Add(-time.Hour), true)
+	// internal: not public
+	observe("internal", now, false)
+
+	sum, err := tr.summarize(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, StatusDegraded, sum.Status)
+	require.Len(t, sum.Services, 2)
+
+	api, blog := sum.Services[0], sum.Services[1]
+	require.Equal(t, "api", api.Name)
+	require.Equal(t, StatusStale, api.Status)
+
+	require.Equal(t, StatusUp, blog.Status)
+	require.InDelta(t, 0.75, *blog.Uptime24h, 1e-9)
+	require.InDelta(t, 7.0/8, *blog.Uptime7d, 1e-9)
+	require.InDelta(t, 7.0/8, *blog.Uptime30d, 1e-9)
+	require.Len(t, blog.Days, 30)
+	require.Equal(t, "2026-01-10", blog.Days[29].Date)
+	require.InDelta(t, 0.75, *blog.Days[29].Uptime, 1e-9)
+	require.InDelta(t, 1, *blog.Days[26].Uptime, 1e-9)
+	require.Nil(t, blog.Days[0].Uptime)
+
+	require.Len(t, sum.Incidents, 1)
+	require.Equal(t, "blog", sum.Incidents[0].Service)
+	require.False(t, sum.Incidents[0].EndedAt.IsZero())
+
+	// services are private unless listed
+	tr.allow = nil
+	sum, err = tr.summarize(ctx, now)
+	require.NoError(t, err)
+	require.Empty(t, sum.Services)
+	require.Empty(t, sum.Incidents)
+	require.Equal(t, StatusUp, sum.Status)
+}
+
+// slowStore blocks SaveService until release is closed.
+type slowStore struct {
+	*memStore
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) SaveService(ctx context.Context, svc *ServiceState) error {
+	s.saving <- struct{}{}
+	<-s.release
+	return s.memStore.SaveService(ctx, svc)
+}
+
+func TestTrackerObserveUnlocked(t *testing.T) {
+	ctx := context.Background()
+	store := &slowStore{memStore: newMemStore(), saving: make(chan struct{}), release: make(chan struct{})}
+	tr, err := newTracker(ctx, store, []string{allServices}, 0)
+	require.NoError(t, err)
+
+	now := time.Date(2026, 1, 10, 12, 30, 0, 0, time.UTC)
+	errCh := make(chan error, 1)
+	go func() { errCh <- tr.observe(ctx, &Check{Service: "blog", Up: true, At: now}) }()
+	<-store.saving
+
+	// the summary is served while the store is busy
+	sum, err := tr.summarize(ctx, now)
+	require.NoError(t, err)
+	require.Len(t, sum.Services, 1)
+
+	close(store.release)
+	require.NoError(t, <-errCh)
+	require.Equal(t, now, store.services["blog"].LastCheckAt)
+}
+
+func TestSummaryAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	tr, err := newTracker(ctx, newMemStore(), []string{allServices}, 0)
+	require.NoError(t, err)
+	require.NoError(t, tr.observe(ctx, NewCheck("blog", "monitor", time.Millisecond, nil)))
+
+	engine := gin.New()
+	newRouter(tr).bindHTTP(engine.Group(statusSitePathPrefix))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status/api/summary", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	sum := new(Summary)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), sum))
+	require.Equal(t, StatusUp, sum.Status)
+	require.Len(t, sum.Services, 1)
+	require.InDelta(t, 1, *sum.Services[0].Uptime24h, 1e-9)
+}
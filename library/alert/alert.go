@@ -1,11 +1,132 @@
 // Package alert implements alert.
+//
+// Alerts are fired and resolved by tasks, routed to channels by severity and
+// source, deduplicated by fingerprint and muted by silences.
 package alert
 
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+// Severities of alerts.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Statuses of notifications.
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Alert is a problem reported by a task.
+type Alert struct {
+	// Source is the task raising the alert, like `elasticsearch`.
+	Source string `json:"source"`
+	// Name identifies the check within the source.
+	Name string `json:"name"`
+	// Severity is `info`, `warning` (default) or `critical`.
+	Severity string `json:"severity"`
+	// Labels identify the instance of the check, like the node or the site.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Summary is a one-line title.
+	Summary string `json:"summary"`
+	// Description is the detail of the alert.
+	Description string `json:"description,omitempty"`
+	// To and ToName are the receiver of email channels, optional.
+	To     string `json:"to,omitempty"`
+	ToName string `json:"to_name,omitempty"`
+}
+
+// Fingerprint identifies the alert across firings.
+// It depends on source, name, labels and receiver.
+func (a *Alert) Fingerprint() string {
+	hasher := sha256.New()
+	for _, v := range []string{a.Source, a.Name, a.To} {
+		hasher.Write([]byte(v))
+		hasher.Write([]byte{0})
+	}
+	for _, k := range slices.Sorted(maps.Keys(a.Labels)) {
+		hasher.Write([]byte(k + "=" + a.Labels[k]))
+		hasher.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))[:16]
+}
+
+// matchLabels returns the labels silences match against,
+// including `source`, `name` and `severity`.
+func (a *Alert) matchLabels() map[string]string {
+	labels := make(map[string]string, len(a.Labels)+3)
+	maps.Copy(labels, a.Labels)
+	labels["source"] = a.Source
+	labels["name"] = a.Name
+	labels["severity"] = a.Severity
+	return labels
+}
+
+// fillDefault fills the default severity and summary.
+func (a *Alert) fillDefault() {
+	if a.Severity == "" {
+		a.Severity = SeverityWarning
+	}
+	if a.Summary == "" {
+		a.Summary = a.Name
+	}
+}
+
+// Notification is an alert sent to channels.
+type Notification struct {
+	Alert
+	Fingerprint string    `json:"fingerprint"`
+	Status      string    `json:"status"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at,omitzero"`
+}
+
+// Title renders the one-line title of n.
+func (n *Notification) Title() string {
+	return "[" + strings.ToUpper(n.Status) + "][" + n.Severity + "] " + n.Summary
+}
+
+// Text renders the plain text body of n.
+func (n *Notification) Text() string {
+	var sb strings.Builder
+	sb.WriteString(n.Title() + "\n")
+	if n.Description != "" {
+		sb.WriteString(n.Description + "\n")
+	}
+	sb.WriteString("\nsource: " + n.Source + "\n")
+	for _, k := range slices.Sorted(maps.Keys(n.Labels)) {
+		sb.WriteString(k + ": " + n.Labels[k] + "\n")
+	}
+	sb.WriteString("since: " + n.StartsAt.Format(time.RFC3339) + "\n")
+	if n.Status == StatusResolved {
+		sb.WriteString("resolved: " + n.EndsAt.Format(time.RFC3339) + "\n")
+	}
+
+	return sb.String()
+}
+
 var Manager = new(ManagerType)
 
 type ManagerType struct {
 	emailCli    *EmailType
 	telegramCli *TelegramCli
+	dispatcher  *Dispatcher
 }
 
 func (m *ManagerType) Setup() {
@@ -14,9 +135,49 @@ func (m *ManagerType) Setup() {
 
 	m.telegramCli = Telegram
 	m.telegramCli.Setup()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Logger.Panic("load alert config", zap.Error(err))
+	}
+
+	if m.dispatcher, err = NewDispatcher(cfg); err != nil {
+		log.Logger.Panic("new alert dispatcher", zap.Error(err))
+	}
+	log.Logger.Info("setup alert",
+		zap.Int("channels", len(m.dispatcher.channels)),
+		zap.Int("routes", len(cfg.Routes)))
+}
+
+// Dispatcher returns the dispatcher, nil before Setup.
+func (m *ManagerType) Dispatcher() *Dispatcher {
+	return m.dispatcher
+}
+
+// Fire notifies the firing alert, deduplicated by its fingerprint.
+func (m *ManagerType) Fire(ctx context.Context, a Alert) error {
+	if m.dispatcher == nil {
+		return errors.New("alert manager is not setup")
+	}
+	return m.dispatcher.Fire(ctx, a)
+}
+
+// Resolve notifies the alert is resolved, if it has been fired.
+func (m *ManagerType) Resolve(ctx context.Context, a Alert) error {
+	if m.dispatcher == nil {
+		return errors.New("alert manager is not setup")
+	}
+	return m.dispatcher.Resolve(ctx, a)
 }
 
+// Send fires a warning from the legacy callers, deduplicated by subject and receiver.
 func (m *ManagerType) Send(to, toName, subject, content string) (err error) {
-	// return m.emailCli.Send(to, toName, subject, content)
-	return m.telegramCli.SendAlert(subject + "\n" + content)
+	return m.Fire(context.Background(), Alert{
+		Source:      "legacy",
+		Name:        subject,
+		Summary:     subject,
+		Description: content,
+		To:          to,
+		ToName:      toName,
+	})
 }
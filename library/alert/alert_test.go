@@ -0,0 +1,273 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeChannel struct {
+	name string
+	err  error
+
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (c *fakeChannel) Name() string {
+	return c.name
+}
+
+func (c *fakeChannel) Notify(_ context.Context, n *Notification) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.sent = append(c.sent, *n)
+	return nil
+}
+
+func (c *fakeChannel) statuses() (statuses []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, n := range c.sent {
+		statuses = append(statuses, n.Status)
+	}
+	return statuses
+}
+
+// newTestDispatcher returns a dispatcher with fake channels named `ops` and `oncall`.
+func newTestDispatcher(t *testing.T, cfg *Config) (*Dispatcher, *fakeChannel, *fakeChannel, *time.Time) {
+	t.Helper()
+	cfg.Channels = []ChannelConfig{{Name: "ops", Type: ChannelWebhook}, {Name: "oncall", Type: ChannelWebhook}}
+	require.NoError(t, cfg.fillDefault())
+
+	ops, oncall := &fakeChannel{name: "ops"}, &fakeChannel{name: "oncall"}
+	silences, err := NewSilences("")
+	require.NoError(t, err)
+	d := newDispatcher(cfg, map[string]Channel{"ops": ops, "oncall": oncall}, silences)
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	return d, ops, oncall, &now
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Alert{Source: "es", Name: "storage", Labels: map[string]string{"node": "n1", "cluster": "c1"}}
+	b := Alert{Source: "es", Name: "storage", Labels: map[string]string{"cluster": "c1", "node": "n1"},
+		Summary: "changed", Description: "changed"}
+	require.Equal(t, a.Fingerprint(), b.Fingerprint())
+
+	b.Labels["node"] = "n2"
+	require.NotEqual(t, a.Fingerprint(), b.Fingerprint())
+}
+
+func TestDispatcherDedupAndResolve(t *testing.T) {
+	ctx := context.Background()
+	d, ops, oncall, now := newTestDispatcher(t, &Config{RepeatInterval: time.Hour})
+	a := Alert{Source: "es", Name: "storage", Labels: map[string]string{"node": "n1"}}
+
+	require.NoError(t, d.Fire(ctx, a))
+	*now = now.Add(30 * time.Minute)
+	require.NoError(t, d.Fire(ctx, a))
+	require.Equal(t, []string{StatusFiring}, ops.statuses(), "deduplicated within repeat interval")
+	require.Equal(t, []string{StatusFiring}, oncall.statuses(), "default channels are all channels")
+
+	*now = now.Add(31 * time.Minute)
+	require.NoError(t, d.Fire(ctx, a))
+	require.Equal(t, []string{StatusFiring, StatusFiring}, ops.statuses(), "repeated after interval")
+	require.Len(t, d.Active(), 1)
+	require.Equal(t, SeverityWarning, d.Active()[0].Severity)
+
+	require.NoError(t, d.Resolve(ctx, a))
+	require.Equal(t, []string{StatusFiring, StatusFiring, StatusResolved}, ops.statuses())
+	require.Empty(t, d.Active())
+
+	// resolving an alert never fired sends nothing
+	require.NoError(t, d.Resolve(ctx, a))
+	require.Len(t, ops.statuses(), 3)
+}
+
+func TestDispatcherRetryFailedNotify(t *testing.T) {
+	ctx := context.Background()
+	d, ops, oncall, _ := newTestDispatcher(t, &Config{})
+	ops.err = errors.New("boom")
+	oncall.err = errors.New("boom")
+	a := Alert{Source: "es", Name: "storage"}
+
+	require.Error(t, d.Fire(ctx, a))
+	ops.err, oncall.err = nil, nil
+	require.NoError(t, d.Fire(ctx, a))
+	require.Equal(t, []string{StatusFiring}, ops.statuses(), "failed notification is not deduplicated")
+}
+
+func TestDispatcherRoute(t *testing.T) {
+	ctx := context.Background()
+	d, ops, oncall, _ := newTestDispatcher(t, &Config{
+		Routes: []Route{
+			{Severities: []string{SeverityCritical}, Channels: []string{"oncall"}, Continue: true},
+			{Sources: []string{"es"}, Channels: []string{"ops"}},
+			{Channels: []string{"oncall"}},
+		},
+	})
+
+	require.NoError(t, d.Fire(ctx, Alert{Source: "es", Name: "a", Severity: SeverityCritical}))
+	require.Len(t, oncall.statuses(), 1)
+	require.Len(t, ops.statuses(), 1)
+
+	require.NoError(t, d.Fire(ctx, Alert{Source: "es", Name: "b"}))
+	require.Len(t, oncall.statuses(), 1)
+	require.Len(t, ops.statuses(), 2)
+
+	require.NoError(t, d.Fire(ctx, Alert{Source: "sites", Name: "c"}))
+	require.Len(t, oncall.statuses(), 2)
+	require.Len(t, ops.statuses(), 2)
+}
+
+func TestDispatcherSilence(t *testing.T) {
+	ctx := context.Background()
+	d, ops, _, now := newTestDispatcher(t, &Config{})
+	a := Alert{Source: "es", Name: "storage", Labels: map[string]string{"node": "n1"}}
+
+	silence, err := d.Silences.Add(Silence{
+		Matchers: map[string]string{"source": "es", "node": "n1"},
+		EndsAt:   now.Add(time.Hour),
+	}, *now)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Fire(ctx, a))
+	require.Empty(t, ops.statuses())
+	require.Equal(t, silence.ID, d.Active()[0].SilencedBy)
+
+	// other nodes are not silenced
+	require.NoError(t, d.Fire(ctx, Alert{Source: "es", Name: "storage", Labels: map[string]string{"node": "n2"}}))
+	require.Len(t, ops.statuses(), 1)
+
+	// notified once the silence expires
+	*now = now.Add(2 * time.Hour)
+	require.NoError(t, d.Fire(ctx, a))
+	require.Len(t, ops.statuses(), 2)
+	for _, act := range d.Active() {
+		require.Empty(t, act.SilencedBy)
+	}
+}
+
+func TestSilencesPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "silences.json")
+	s, err := NewSilences(file)
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, err = s.Add(Silence{Matchers: map[string]string{"source": "es"}}, now)
+	require.Error(t, err, "silence requires EndsAt")
+	_, err = s.Add(Silence{EndsAt: now.Add(time.Hour)}, now)
+	require.Error(t, err, "silence requires matchers")
+
+	kept, err := s.Add(Silence{Matchers: map[string]string{"source": "es"}, EndsAt: now.Add(time.Hour)}, now)
+	require.NoError(t, err)
+	removed, err := s.Add(Silence{Matchers: map[string]string{"source": "sites"}, EndsAt: now.Add(time.Hour)}, now)
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(removed.ID))
+	require.ErrorIs(t, s.Delete(removed.ID), ErrSilenceNotFound)
+
+	s, err = NewSilences(file)
+	require.NoError(t, err)
+	items := s.List(now)
+	require.Len(t, items, 1)
+	require.Equal(t, kept.ID, items[0].ID)
+	require.Empty(t, s.List(now.Add(2*time.Hour)), "expired silences are not listed")
+}
+
+func TestWebhookChannel(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []map[string]any
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "secret", r.Header.Get("X-Token"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		payload := map[string]any{}
+		require.NoError(t, json.Unmarshal(body, &payload))
+		mu.Lock()
+		bodies = append(bodies, payload)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	n := &Notification{
+		Alert:       Alert{Source: "es", Name: "storage", Severity: SeverityCritical, Summary: "disk full"},
+		Fingerprint: "abc",
+		Status:      StatusFiring,
+		StartsAt:    time.Now(),
+	}
+
+	webhook, err := newChannel(ChannelConfig{Name: "hook", Type: ChannelWebhook, URL: srv.URL,
+		Headers: map[string]string{"X-Token": "secret"}})
+	require.NoError(t, err)
+	require.NoError(t, webhook.Notify(context.Background(), n))
+
+	slack, err := newChannel(ChannelConfig{Name: "slack", Type: ChannelSlack, URL: srv.URL,
+		Headers: map[string]string{"X-Token": "secret"}})
+	require.NoError(t, err)
+	require.NoError(t, slack.Notify(context.Background(), n))
+
+	require.Len(t, bodies, 2)
+	require.Equal(t, "abc", bodies[0]["fingerprint"])
+	require.Equal(t, StatusFiring, bodies[0]["status"])
+	require.Contains(t, bodies[1]["text"], "[FIRING][critical] disk full")
+
+	_, err = newChannel(ChannelConfig{Name: "hook", Type: ChannelWebhook})
+	require.Error(t, err, "webhook requires url")
+	_, err = newChannel(ChannelConfig{Name: "pager", Type: "pager"})
+	require.Error(t, err)
+}
+
+func TestConfigFillDefault(t *testing.T) {
+	cfg := &Config{}
+	require.NoError(t, cfg.fillDefault())
+	require.Equal(t, DefaultRepeatInterval, cfg.RepeatInterval)
+	require.Equal(t, []string{defaultChannelName}, cfg.DefaultChannels)
+
+	cfg = &Config{Routes: []Route{{Channels: []string{"unknown"}}}}
+	require.Error(t, cfg.fillDefault())
+
+	// alerts matched by no route go to all channels
+	cfg = &Config{
+		Channels: []ChannelConfig{{Name: "ops", Type: ChannelWebhook}, {Name: "oncall", Type: ChannelWebhook}},
+		Routes:   []Route{{Sources: []string{"es"}, Channels: []string{"oncall"}}},
+	}
+	require.NoError(t, cfg.fillDefault())
+	require.Equal(t, []string{"ops", "oncall"}, cfg.DefaultChannels)
+}
+
+func TestDispatcherExpireStale(t *testing.T) {
+	ctx := context.Background()
+	d, ops, _, now := newTestDispatcher(t, &Config{RepeatInterval: time.Hour})
+
+	// legacy callers never resolve their alerts
+	require.NoError(t, d.Fire(ctx, Alert{Source: "legacy", Name: "a"}))
+	*now = now.Add(ActiveAlertTTL / 2)
+	require.NoError(t, d.Fire(ctx, Alert{Source: "legacy", Name: "b"}))
+	require.Len(t, d.Active(), 2)
+
+	*now = now.Add(ActiveAlertTTL/2 + time.Minute)
+	require.Len(t, d.Active(), 1, "alert a expired")
+	require.Equal(t, "b", d.Active()[0].Name)
+
+	// an expired alert firing again is notified as new
+	require.NoError(t, d.Fire(ctx, Alert{Source: "legacy", Name: "a"}))
+	require.Len(t, ops.statuses(), 3)
+	require.Len(t, d.Active(), 2)
+}
@@ -0,0 +1,131 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	gutils "github.com/Laisky/go-utils/v6"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+// Channel delivers notifications.
+type Channel interface {
+	Name() string
+	Notify(ctx context.Context, n *Notification) error
+}
+
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// newChannel builds the channel of cfg.
+func newChannel(cfg ChannelConfig) (Channel, error) {
+	switch cfg.Type {
+	case ChannelTelegram:
+		return &telegramChannel{name: cfg.Name, cli: Telegram}, nil
+	case ChannelEmail:
+		return &emailChannel{name: cfg.Name, cli: Email, to: cfg.To, toName: cfg.ToName}, nil
+	case ChannelWebhook, ChannelSlack:
+		if cfg.URL == "" {
+			return nil, errors.Errorf("alert channel %q requires url", cfg.Name)
+		}
+		return &webhookChannel{
+			name:    cfg.Name,
+			url:     cfg.URL,
+			headers: cfg.Headers,
+			slack:   cfg.Type == ChannelSlack,
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown type %q of alert channel %q", cfg.Type, cfg.Name)
+	}
+}
+
+// telegramChannel sends to the chat of `telegram.alert`.
+type telegramChannel struct {
+	name string
+	cli  *TelegramCli
+}
+
+func (c *telegramChannel) Name() string {
+	return c.name
+}
+
+func (c *telegramChannel) Notify(ctx context.Context, n *Notification) error {
+	return c.cli.Send(ctx,
+		gconfig.Shared.GetString("telegram.alert"),
+		gconfig.Shared.GetString("telegram.push_token"),
+		n.Text())
+}
+
+// emailChannel sends to the receiver of alerts, or to its default receiver.
+type emailChannel struct {
+	name       string
+	cli        *EmailType
+	to, toName string
+}
+
+func (c *emailChannel) Name() string {
+	return c.name
+}
+
+func (c *emailChannel) Notify(_ context.Context, n *Notification) error {
+	to, toName := n.To, n.ToName
+	if to == "" {
+		to, toName = c.to, c.toName
+	}
+	if to == "" {
+		return errors.Errorf("email channel %q got no receiver", c.name)
+	}
+
+	return c.cli.Send(to, toName, n.Title(), n.Text())
+}
+
+// webhookChannel posts the notification as json,
+// or as a Slack-compatible message if slack.
+type webhookChannel struct {
+	name    string
+	url     string
+	headers map[string]string
+	slack   bool
+}
+
+func (c *webhookChannel) Name() string {
+	return c.name
+}
+
+func (c *webhookChannel) Notify(ctx context.Context, n *Notification) error {
+	var payload any = n
+	if c.slack {
+		payload = map[string]string{"text": n.Text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "marshal notification")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "post to channel %q", c.name)
+	}
+	defer gutils.LogErr(resp.Body.Close, log.Logger) // nolint: errcheck,gosec
+
+	if err = gutils.CheckResp(resp); err != nil {
+		return errors.Wrapf(err, "post to channel %q", c.name)
+	}
+	return nil
+}
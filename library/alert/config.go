@@ -0,0 +1,139 @@
+package alert
+
+import (
+	"slices"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+)
+
+const (
+	// DefaultRepeatInterval is how long a firing alert is muted after notified.
+	DefaultRepeatInterval = 4 * time.Hour
+	// ActiveAlertTTL is how long a firing alert is kept without firing
+	// again, at least its repeat interval. Callers that never resolve
+	// their alerts would grow the active alerts forever otherwise.
+	ActiveAlertTTL = 24 * time.Hour
+
+	defaultChannelName = "telegram"
+)
+
+// Types of channels.
+const (
+	ChannelTelegram = "telegram"
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelSlack    = "slack"
+)
+
+// ChannelConfig configures a channel.
+type ChannelConfig struct {
+	Name string
+	// Type is `telegram`, `email`, `webhook` or `slack`.
+	Type string
+	// URL of `webhook` and `slack`.
+	URL string
+	// Headers of `webhook` requests.
+	Headers map[string]string
+	// To and ToName are the default receiver of `email`.
+	To     string
+	ToName string `mapstructure:"to_name"`
+}
+
+// Route sends the matched alerts to channels.
+type Route struct {
+	// Sources matches the source of alerts, empty matches all.
+	Sources []string
+	// Severities matches the severity of alerts, empty matches all.
+	Severities []string
+	Channels   []string
+	// RepeatInterval overrides the global repeat interval.
+	RepeatInterval time.Duration `mapstructure:"repeat_interval"`
+	// SendResolved notifies resolved alerts, default true.
+	SendResolved *bool `mapstructure:"send_resolved"`
+	// Continue matches the following routes after matched.
+	Continue bool
+}
+
+// match reports whether r matches a.
+func (r *Route) match(a *Alert) bool {
+	return (len(r.Sources) == 0 || slices.Contains(r.Sources, a.Source)) &&
+		(len(r.Severities) == 0 || slices.Contains(r.Severities, a.Severity))
+}
+
+func (r *Route) sendResolved() bool {
+	return r.SendResolved == nil || *r.SendResolved
+}
+
+// Config is `alert` in settings.
+type Config struct {
+	RepeatInterval time.Duration `mapstructure:"repeat_interval"`
+	// SilencesFile persists silences, optional.
+	SilencesFile string `mapstructure:"silences_file"`
+	// APIToken authorizes the silences API besides admin sso sessions.
+	APIToken string `mapstructure:"api_token"`
+	Channels []ChannelConfig
+	Routes   []Route
+	// DefaultChannels receive the alerts not matched by any route,
+	// default to all channels.
+	DefaultChannels []string `mapstructure:"default_channels"`
+}
+
+// LoadConfig loads `alert`. Without channels, all alerts go to the
+// telegram chat of `telegram.alert`, as before alert routing existed.
+func LoadConfig() (*Config, error) {
+	cfg := new(Config)
+	if err := gconfig.Shared.UnmarshalKey("alert", cfg); err != nil {
+		return nil, errors.Wrap(err, "unmarshal alert")
+	}
+
+	if err := cfg.fillDefault(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) fillDefault() error {
+	if c.RepeatInterval <= 0 {
+		c.RepeatInterval = DefaultRepeatInterval
+	}
+	if len(c.Channels) == 0 {
+		c.Channels = []ChannelConfig{{Name: defaultChannelName, Type: ChannelTelegram}}
+	}
+	// unmatched alerts are never dropped
+	if len(c.DefaultChannels) == 0 {
+		for _, ch := range c.Channels {
+			c.DefaultChannels = append(c.DefaultChannels, ch.Name)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, ch := range c.Channels {
+		if ch.Name == "" {
+			return errors.Errorf("alert channel of type %q requires name", ch.Type)
+		}
+		if names[ch.Name] {
+			return errors.Errorf("duplicate alert channel %q", ch.Name)
+		}
+		names[ch.Name] = true
+	}
+
+	check := func(chs []string) error {
+		for _, name := range chs {
+			if !names[name] {
+				return errors.Errorf("unknown alert channel %q", name)
+			}
+		}
+		return nil
+	}
+	for _, r := range c.Routes {
+		if len(r.Channels) == 0 {
+			return errors.New("alert route requires channels")
+		}
+		if err := check(r.Channels); err != nil {
+			return err
+		}
+	}
+	return check(c.DefaultChannels)
+}
@@ -0,0 +1,235 @@
+package alert
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/zap"
+
+	"github.com/Laisky/go-ramjet/library/log"
+)
+
+// ActiveAlert is a firing alert.
+type ActiveAlert struct {
+	Notification
+	Channels       []string  `json:"channels"`
+	LastFiredAt    time.Time `json:"last_fired_at"`
+	LastNotifiedAt time.Time `json:"last_notified_at,omitzero"`
+	// SilencedBy is the id of the silence muting the alert.
+	SilencedBy string `json:"silenced_by,omitempty"`
+
+	repeatInterval time.Duration
+	sendResolved   bool
+}
+
+// Dispatcher routes alerts to channels, deduplicates them by fingerprint
+// and mutes them by silences.
+type Dispatcher struct {
+	cfg      *Config
+	channels map[string]Channel
+	Silences *Silences
+
+	mu     sync.Mutex
+	active map[string]*ActiveAlert
+	now    func() time.Time
+}
+
+// NewDispatcher builds the channels and silences of cfg.
+func NewDispatcher(cfg *Config) (*Dispatcher, error) {
+	channels := map[string]Channel{}
+	for _, chCfg := range cfg.Channels {
+		ch, err := newChannel(chCfg)
+		if err != nil {
+			return nil, err
+		}
+		channels[ch.Name()] = ch
+	}
+
+	silences, err := NewSilences(cfg.SilencesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDispatcher(cfg, channels, silences), nil
+}
+
+func newDispatcher(cfg *Config, channels map[string]Channel, silences *Silences) *Dispatcher {
+	return &Dispatcher{
+		cfg:      cfg,
+		channels: channels,
+		Silences: silences,
+		active:   map[string]*ActiveAlert{},
+		now:      time.Now,
+	}
+}
+
+// route returns the channels, repeat interval and whether to send resolved of a.
+func (d *Dispatcher) route(a *Alert) (channels []string, repeat time.Duration, sendResolved bool) {
+	matched := false
+	for i := range d.cfg.Routes {
+		r := &d.cfg.Routes[i]
+		if !r.match(a) {
+			continue
+		}
+
+		matched = true
+		for _, ch := range r.Channels {
+			if !slices.Contains(channels, ch) {
+				channels = append(channels, ch)
+			}
+		}
+		if r.RepeatInterval > 0 && (repeat == 0 || r.RepeatInterval < repeat) {
+			repeat = r.RepeatInterval
+		}
+		sendResolved = sendResolved || r.sendResolved()
+		if !r.Continue {
+			break
+		}
+	}
+	if !matched {
+		channels, sendResolved = d.cfg.DefaultChannels, true
+	}
+	if repeat == 0 {
+		repeat = d.cfg.RepeatInterval
+	}
+
+	return channels, repeat, sendResolved
+}
+
+// Fire notifies the firing alert a, unless it is silenced
+// or has been notified within the repeat interval.
+func (d *Dispatcher) Fire(ctx context.Context, a Alert) error {
+	a.fillDefault()
+	fp := a.Fingerprint()
+	now := d.now()
+
+	d.mu.Lock()
+	d.expireLocked(now)
+	act, ok := d.active[fp]
+	if !ok {
+		channels, repeat, sendResolved := d.route(&a)
+		act = &ActiveAlert{
+			Notification: Notification{
+				Fingerprint: fp,
+				Status:      StatusFiring,
+				StartsAt:    now,
+			},
+			Channels:       channels,
+			repeatInterval: repeat,
+			sendResolved:   sendResolved,
+		}
+		d.active[fp] = act
+	}
+	act.Alert = a
+	act.LastFiredAt = now
+
+	if silence := d.Silences.Silenced(a.matchLabels(), now); silence != nil {
+		act.SilencedBy = silence.ID
+		d.mu.Unlock()
+		log.Logger.Debug("alert silenced", zap.String("fingerprint", fp), zap.String("silence", silence.ID))
+		return nil
+	}
+	act.SilencedBy = ""
+
+	lastNotifiedAt := act.LastNotifiedAt
+	if !lastNotifiedAt.IsZero() && now.Sub(lastNotifiedAt) < act.repeatInterval {
+		d.mu.Unlock()
+		return nil
+	}
+	// mark as notified before sending, so concurrent firings are deduplicated
+	act.LastNotifiedAt = now
+	n, channels := act.Notification, act.Channels
+	d.mu.Unlock()
+
+	sent, err := d.notify(ctx, &n, channels)
+	if sent == 0 {
+		d.mu.Lock()
+		if act.LastNotifiedAt.Equal(now) {
+			act.LastNotifiedAt = lastNotifiedAt
+		}
+		d.mu.Unlock()
+	}
+
+	return err
+}
+
+// Resolve notifies the alert a is resolved, if it has been notified as firing.
+func (d *Dispatcher) Resolve(ctx context.Context, a Alert) error {
+	a.fillDefault()
+	fp := a.Fingerprint()
+	now := d.now()
+
+	d.mu.Lock()
+	act, ok := d.active[fp]
+	if ok {
+		delete(d.active, fp)
+	}
+	d.mu.Unlock()
+	if !ok || act.LastNotifiedAt.IsZero() || !act.sendResolved {
+		return nil
+	}
+	if d.Silences.Silenced(a.matchLabels(), now) != nil {
+		return nil
+	}
+
+	n := act.Notification
+	n.Status = StatusResolved
+	n.EndsAt = now
+	_, err := d.notify(ctx, &n, act.Channels)
+	return err
+}
+
+// notify sends n to channels, returns the number of channels succeeded.
+func (d *Dispatcher) notify(ctx context.Context, n *Notification, channels []string) (sent int, err error) {
+	var errs []error
+	for _, name := range channels {
+		ch, ok := d.channels[name]
+		if !ok {
+			errs = append(errs, errors.Errorf("unknown alert channel %q", name))
+			continue
+		}
+
+		if chErr := ch.Notify(ctx, n); chErr != nil {
+			errs = append(errs, errors.Wrapf(chErr, "notify channel %q", name))
+			continue
+		}
+		sent++
+		log.Logger.Info("sent alert",
+			zap.String("channel", name),
+			zap.String("status", n.Status),
+			zap.String("source", n.Source),
+			zap.String("name", n.Name),
+			zap.String("fingerprint", n.Fingerprint))
+	}
+
+	return sent, errors.Join(errs...)
+}
+
+// expireLocked forgets the alerts not fired within ActiveAlertTTL, or their
+// repeat interval if longer. d.mu must be held.
+func (d *Dispatcher) expireLocked(now time.Time) {
+	for fp, act := range d.active {
+		if now.Sub(act.LastFiredAt) > max(ActiveAlertTTL, act.repeatInterval) {
+			delete(d.active, fp)
+		}
+	}
+}
+
+// Active returns the firing alerts, by StartsAt.
+func (d *Dispatcher) Active() []ActiveAlert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expireLocked(d.now())
+
+	alerts := make([]ActiveAlert, 0, len(d.active))
+	for _, act := range d.active {
+		alerts = append(alerts, *act)
+	}
+	slices.SortFunc(alerts, func(a, b ActiveAlert) int {
+		return a.StartsAt.Compare(b.StartsAt)
+	})
+	return alerts
+}
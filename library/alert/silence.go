@@ -0,0 +1,203 @@
+package alert
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// ErrSilenceNotFound is returned when deleting an unknown silence.
+var ErrSilenceNotFound = errors.New("silence not found")
+
+// Silence mutes the alerts matching all its matchers between StartsAt and EndsAt.
+type Silence struct {
+	ID string `json:"id"`
+	// Matchers match labels of alerts by equality, including
+	// `source`, `name` and `severity`.
+	Matchers  map[string]string `json:"matchers"`
+	StartsAt  time.Time         `json:"starts_at"`
+	EndsAt    time.Time         `json:"ends_at"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	Comment   string            `json:"comment,omitempty"`
+}
+
+// Valid checks s.
+func (s *Silence) Valid() error {
+	if len(s.Matchers) == 0 {
+		return errors.New("silence requires matchers")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("silence should end after it starts")
+	}
+	return nil
+}
+
+// Active reports whether s mutes alerts at now.
+func (s *Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// Match reports whether s matches labels.
+func (s *Silence) Match(labels map[string]string) bool {
+	for k, v := range s.Matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Silences stores silences, persisted to file if not empty.
+type Silences struct {
+	mu    sync.RWMutex
+	file  string
+	items map[string]*Silence
+}
+
+// NewSilences loads silences from file, file could be empty.
+func NewSilences(file string) (*Silences, error) {
+	s := &Silences{file: file, items: map[string]*Silence{}}
+	if file == "" {
+		return s, nil
+	}
+
+	body, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "read silences file %q", file)
+	}
+
+	var items []*Silence
+	if err = json.Unmarshal(body, &items); err != nil {
+		return nil, errors.Wrapf(err, "parse silences file %q", file)
+	}
+	for _, item := range items {
+		s.items[item.ID] = item
+	}
+
+	return s, nil
+}
+
+// Add adds silence, which starts at now if StartsAt is zero.
+func (s *Silences) Add(silence Silence, now time.Time) (*Silence, error) {
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = now
+	}
+	if err := silence.Valid(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "generate silence id")
+	}
+	silence.ID = hex.EncodeToString(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[silence.ID] = &silence
+	if err := s.save(); err != nil {
+		delete(s.items, silence.ID)
+		return nil, err
+	}
+
+	return &silence, nil
+}
+
+// Delete removes the silence of id.
+func (s *Silences) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	silence, ok := s.items[id]
+	if !ok {
+		return ErrSilenceNotFound
+	}
+	delete(s.items, id)
+	if err := s.save(); err != nil {
+		s.items[id] = silence
+		return err
+	}
+
+	return nil
+}
+
+// List returns the silences not expired at now, by EndsAt.
+func (s *Silences) List(now time.Time) []Silence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var items []Silence
+	for _, item := range s.items {
+		if now.Before(item.EndsAt) {
+			items = append(items, *item)
+		}
+	}
+	slices.SortFunc(items, func(a, b Silence) int {
+		return a.EndsAt.Compare(b.EndsAt)
+	})
+	return items
+}
+
+// Silenced returns the active silence matching labels at now, nil if none.
+func (s *Silences) Silenced(labels map[string]string, now time.Time) *Silence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, item := range s.items {
+		if item.Active(now) && item.Match(labels) {
+			silence := *item
+			return &silence
+		}
+	}
+	return nil
+}
+
+// save writes the silences not expired into file atomically.
+// caller must hold s.mu.
+func (s *Silences) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	now := time.Now()
+	items := []*Silence{}
+	for id, item := range s.items {
+		if !now.Before(item.EndsAt) {
+			delete(s.items, id)
+			continue
+		}
+		items = append(items, item)
+	}
+	body, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal silences")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp silences file")
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	if _, err = tmp.Write(body); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "write silences file")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "close silences file")
+	}
+	if err = os.Rename(tmp.Name(), s.file); err != nil {
+		return errors.Wrapf(err, "rename silences file to %q", s.file)
+	}
+
+	return nil
+}
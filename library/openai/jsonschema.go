@@ -0,0 +1,323 @@
+package openai
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/Laisky/go-utils/v6/json"
+)
+
+// SchemaError is returned when a document does not match its JSON Schema.
+type SchemaError struct {
+	// Problems describes each mismatch, prefixed by its JSON path
+	Problems []string
+}
+
+// Error implements error.
+func (e *SchemaError) Error() string {
+	return "json schema mismatch: " + strings.Join(e.Problems, "; ")
+}
+
+// compiledSchema validates documents against a subset of JSON Schema:
+// type, enum, const, properties, required, additionalProperties, items,
+// min/maxItems, min/maxLength, pattern, minimum, maximum, exclusive bounds,
+// anyOf, oneOf, allOf and local $ref.
+type compiledSchema struct {
+	root     map[string]any
+	patterns map[string]*regexp.Regexp
+}
+
+// compileSchema parses a JSON Schema.
+func compileSchema(raw []byte) (*compiledSchema, error) {
+	var root map[string]any
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, errors.Wrap(err, "schema should be a json object")
+	}
+
+	s := &compiledSchema{root: root, patterns: map[string]*regexp.Regexp{}}
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compilePatterns compiles every `pattern` in node, so that bad patterns
+// fail when compiling instead of when validating.
+func (s *compiledSchema) compilePatterns(node any) error {
+	switch node := node.(type) {
+	case map[string]any:
+		for k, v := range node {
+			if pattern, ok := v.(string); ok && k == "pattern" {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return errors.Wrapf(err, "compile pattern %q", pattern)
+				}
+				s.patterns[pattern] = re
+				continue
+			}
+			if err := s.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range node {
+			if err := s.compilePatterns(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks doc against the schema.
+func (s *compiledSchema) validate(doc []byte) error {
+	var v any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return &SchemaError{Problems: []string{"$: not valid json: " + err.Error()}}
+	}
+
+	var problems []string
+	s.check(s.root, v, "$", &problems, 0)
+	if len(problems) != 0 {
+		return &SchemaError{Problems: problems}
+	}
+	return nil
+}
+
+// resolve follows a local $ref like `#/$defs/item`.
+func (s *compiledSchema) resolve(ref string) (map[string]any, bool) {
+	if ref == "#" {
+		return s.root, true
+	}
+	path, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, false
+	}
+
+	var node any = s.root
+	for _, key := range strings.Split(path, "/") {
+		key = strings.ReplaceAll(strings.ReplaceAll(key, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	m, ok := node.(map[string]any)
+	return m, ok
+}
+
+// check appends the mismatches of v against schema to problems.
+func (s *compiledSchema) check(schema map[string]any, v any, path string, problems *[]string, depth int) {
+	add := func(format string, args ...any) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+	if depth > 64 {
+		add("schema nests too deep")
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, ok := s.resolve(ref)
+		if !ok {
+			add("unresolvable $ref %q", ref)
+			return
+		}
+		s.check(target, v, path, problems, depth+1)
+	}
+
+	if typ, ok := schema["type"]; ok && !matchType(typ, v) {
+		add("expected %s, got %s", typeName(typ), jsonType(v))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("value is not one of the enum")
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		add("value does not equal const")
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		s.checkObject(schema, v, path, problems, depth)
+	case []any:
+		if n, ok := schema["minItems"].(float64); ok && float64(len(v)) < n {
+			add("expected at least %v items", n)
+		}
+		if n, ok := schema["maxItems"].(float64); ok && float64(len(v)) > n {
+			add("expected at most %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				s.check(items, item, fmt.Sprintf("%s[%d]", path, i), problems, depth+1)
+			}
+		}
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if min, ok := schema["minLength"].(float64); ok && n < min {
+			add("expected at least %v characters", min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && n > max {
+			add("expected at most %v characters", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !s.patterns[pattern].MatchString(v) {
+			add("does not match pattern %q", pattern)
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			add("expected >= %v", min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			add("expected <= %v", max)
+		}
+		if min, ok := schema["exclusiveMinimum"].(float64); ok && v <= min {
+			add("expected > %v", min)
+		}
+		if max, ok := schema["exclusiveMaximum"].(float64); ok && v >= max {
+			add("expected < %v", max)
+		}
+	}
+
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			if sub, ok := sub.(map[string]any); ok {
+				s.check(sub, v, path, problems, depth+1)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok && s.countMatches(anyOf, v, depth) == 0 {
+		add("matches none of anyOf")
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		if n := s.countMatches(oneOf, v, depth); n != 1 {
+			add("matches %d of oneOf, expected exactly 1", n)
+		}
+	}
+}
+
+// checkObject checks the properties of an object.
+func (s *compiledSchema) checkObject(schema, v map[string]any, path string, problems *[]string, depth int) {
+	props, _ := schema["properties"].(map[string]any)
+	if required, ok := schema["required"].([]any); ok {
+		for _, key := range required {
+			if key, ok := key.(string); ok {
+				if _, ok := v[key]; !ok {
+					*problems = append(*problems, fmt.Sprintf("%s: missing required property %q", path, key))
+				}
+			}
+		}
+	}
+
+	// sorted, so that problems are reported in a stable order
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if sub, ok := props[key].(map[string]any); ok {
+			s.check(sub, v[key], path+"."+key, problems, depth+1)
+			continue
+		}
+		if _, ok := props[key]; ok {
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				*problems = append(*problems, fmt.Sprintf("%s: unexpected property %q", path, key))
+			}
+		case map[string]any:
+			s.check(extra, v[key], path+"."+key, problems, depth+1)
+		}
+	}
+}
+
+// countMatches returns how many of schemas v matches.
+func (s *compiledSchema) countMatches(schemas []any, v any, depth int) (n int) {
+	for _, sub := range schemas {
+		sub, ok := sub.(map[string]any)
+		if !ok {
+			continue
+		}
+		var problems []string
+		if s.check(sub, v, "$", &problems, depth+1); len(problems) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// matchType reports whether v is of typ, a type name or a list of them.
+func matchType(typ any, v any) bool {
+	switch typ := typ.(type) {
+	case string:
+		actual := jsonType(v)
+		return actual == typ ||
+			(typ == "number" && actual == "integer")
+	case []any:
+		for _, t := range typ {
+			if matchType(t, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeName formats the `type` keyword for messages.
+func typeName(typ any) string {
+	if list, ok := typ.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, t := range list {
+			names = append(names, fmt.Sprint(t))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(typ)
+}
+
+// jsonType returns the JSON Schema type of a decoded value.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// jsonEqual compares two decoded values.
+func jsonEqual(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
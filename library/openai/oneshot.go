@@ -35,6 +35,55 @@ var defaultSystemPrompt = "# Core Capabilities and Behavior\n\nI am an AI assist
 // Returns:
 //   - answer: assistant message content.
 func OneshotChat(ctx context.Context, apiBase, apiKey, model, systemPrompt, userPrompt string) (answer string, err error) {
+	return oneshot(ctx, apiBase, apiKey, model, systemPrompt, userPrompt)
+}
+
+// OneshotVision is OneshotChat with one image attached to the user message.
+//
+// Args:
+//   - imageURL: http(s) URL or `data:` URL of the image.
+//
+// Other args are the same as OneshotChat.
+func OneshotVision(ctx context.Context, apiBase, apiKey, model, systemPrompt, userPrompt, imageURL string) (answer string, err error) {
+	if strings.TrimSpace(imageURL) == "" {
+		return "", errors.New("imageURL is empty")
+	}
+
+	return oneshot(ctx, apiBase, apiKey, model, systemPrompt, []map[string]any{
+		{"type": "text", "text": userPrompt},
+		{"type": "image_url", "image_url": map[string]any{"url": imageURL}},
+	})
+}
+
+// oneshot sends one non-streaming chat completion; userContent is either a
+// string or an array of content parts.
+func oneshot(ctx context.Context, apiBase, apiKey, model, systemPrompt string, userContent any) (answer string, err error) {
+	if systemPrompt == "" {
+		systemPrompt = defaultSystemPrompt
+	}
+
+	return chatCompletion(ctx, apiBase, apiKey, model, []map[string]any{
+		{"role": "system", "content": systemPrompt},
+		{"role": "user", "content": userContent},
+	}, nil)
+}
+
+// upstreamError is a non-200 response of the upstream.
+type upstreamError struct {
+	url    string
+	status int
+	body   string
+}
+
+// Error implements error.
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("req %q [%d]%s", e.url, e.status, e.body)
+}
+
+// chatCompletion sends messages as one non-streaming chat completion,
+// with extra merged into the request body.
+func chatCompletion(ctx context.Context, apiBase, apiKey, model string,
+	messages []map[string]any, extra map[string]any) (answer string, err error) {
 	logger := gmw.GetLogger(ctx).Named("oneshot_chat")
 
 	apiBase = strings.TrimRight(strings.TrimSpace(apiBase), "/")
@@ -46,22 +95,20 @@ func OneshotChat(ctx context.Context, apiBase, apiKey, model, systemPrompt, user
 		return "", errors.New("apiKey is empty")
 	}
 
-	if systemPrompt == "" {
-		systemPrompt = defaultSystemPrompt
-	}
 	if model == "" {
 		model = defaultChatModel
 	}
 
-	body, err := json.Marshal(map[string]any{
+	reqBody := map[string]any{
 		"model":      model,
 		"max_tokens": 20000,
 		"stream":     false,
-		"messages": []map[string]any{
-			{"role": "system", "content": systemPrompt},
-			{"role": "user", "content": userPrompt},
-		},
-	})
+		"messages":   messages,
+	}
+	for k, v := range extra {
+		reqBody[k] = v
+	}
+	body, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", errors.Wrap(err, "marshal req")
 	}
@@ -92,7 +139,7 @@ func OneshotChat(ctx context.Context, apiBase, apiKey, model, systemPrompt, user
 			zap.Int("status", resp.StatusCode),
 			zap.ByteString("resp", respText),
 		)
-		return "", errors.Errorf("req %q [%d]%s", url, resp.StatusCode, string(respText))
+		return "", errors.WithStack(&upstreamError{url: url, status: resp.StatusCode, body: string(respText)})
 	}
 
 	var respData struct {
@@ -0,0 +1,211 @@
+package openai
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+	gmw "github.com/Laisky/gin-middlewares/v7"
+	"github.com/Laisky/go-utils/v6/json"
+	"github.com/Laisky/zap"
+)
+
+const (
+	defaultSchemaName = "answer"
+	defaultMaxRepairs = 2
+	maxMaxRepairs     = 5
+
+	structuredSystemPrompt = "You are a helpful assistant."
+	structuredFormatPrompt = "\n\nReply with a single JSON value that matches the following JSON Schema. " +
+		"Do not wrap it in markdown, do not add any other text.\n\n"
+	structuredRepairPrompt = "Your reply does not match the JSON Schema:\n"
+)
+
+var schemaNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// responseFormatUnsupported holds the `apiBase|model` whose upstream rejected
+// `response_format`, they get the schema in the prompt only.
+var responseFormatUnsupported sync.Map
+
+// Schema describes the JSON answer of OneshotStructured.
+type Schema struct {
+	// Name identifies the schema to the upstream, defaults to "answer"
+	Name string `json:"name,omitempty"`
+	// Schema is the JSON Schema of the answer
+	Schema stdjson.RawMessage `json:"schema"`
+	// Strict asks the upstream to enforce the schema while generating
+	Strict bool `json:"strict,omitempty"`
+	// MaxRepairs is how many times an invalid answer is sent back to the
+	// model to be fixed, 0 means 2, at most 5.
+	MaxRepairs int `json:"max_repairs,omitempty"`
+
+	compiled *compiledSchema
+}
+
+// Valid checks and compiles the schema.
+func (s *Schema) Valid() error {
+	if s.Name == "" {
+		s.Name = defaultSchemaName
+	}
+	if !schemaNameRegexp.MatchString(s.Name) {
+		return errors.Errorf("schema name %q should match %s", s.Name, schemaNameRegexp)
+	}
+	if s.MaxRepairs < 0 || s.MaxRepairs > maxMaxRepairs {
+		return errors.Errorf("max_repairs should be between 0 and %d", maxMaxRepairs)
+	}
+	if s.MaxRepairs == 0 {
+		s.MaxRepairs = defaultMaxRepairs
+	}
+	if len(s.Schema) == 0 {
+		return errors.New("schema is empty")
+	}
+
+	var err error
+	if s.compiled, err = compileSchema(s.Schema); err != nil {
+		return errors.Wrap(err, "compile schema")
+	}
+	return nil
+}
+
+// Validate checks doc against the schema, mismatches are returned as *SchemaError.
+func (s *Schema) Validate(doc []byte) error {
+	if s.compiled == nil {
+		if err := s.Valid(); err != nil {
+			return err
+		}
+	}
+	return s.compiled.validate(doc)
+}
+
+// OneshotStructured is OneshotChat whose answer is a JSON document matching schema.
+//
+// The upstream is asked for `response_format: json_schema`, upstreams that
+// reject it get the schema in the system prompt only. The answer is always
+// validated locally, an invalid answer is sent back to the model with the
+// mismatches, up to schema.MaxRepairs times.
+//
+// Args:
+//   - schema: JSON Schema of the answer.
+//   - out: optional, the answer is unmarshaled into it.
+//
+// Other args are the same as OneshotChat.
+//
+// Returns:
+//   - answer: the JSON document. When it still mismatches after the repairs,
+//     the last answer is returned with a *SchemaError.
+func OneshotStructured(ctx context.Context, apiBase, apiKey, model, systemPrompt, userPrompt string,
+	schema *Schema, out any) (answer string, err error) {
+	logger := gmw.GetLogger(ctx).Named("oneshot_structured")
+	if schema == nil {
+		return "", errors.New("schema is nil")
+	}
+	if err = schema.Valid(); err != nil {
+		return "", errors.Wrap(err, "invalid schema")
+	}
+
+	if model == "" {
+		model = defaultChatModel
+	}
+	if systemPrompt == "" {
+		systemPrompt = structuredSystemPrompt
+	}
+	messages := []map[string]any{
+		{"role": "system", "content": systemPrompt + structuredFormatPrompt + string(schema.Schema)},
+		{"role": "user", "content": userPrompt},
+	}
+
+	cacheKey := strings.TrimRight(strings.TrimSpace(apiBase), "/") + "|" + model
+	var extra map[string]any
+	if _, unsupported := responseFormatUnsupported.Load(cacheKey); !unsupported {
+		extra = map[string]any{
+			"response_format": map[string]any{
+				"type": "json_schema",
+				"json_schema": map[string]any{
+					"name":   schema.Name,
+					"schema": schema.Schema,
+					"strict": schema.Strict,
+				},
+			},
+		}
+	}
+
+	for repairs := 0; ; {
+		answer, err = chatCompletion(ctx, apiBase, apiKey, model, messages, extra)
+		if err != nil && extra != nil && isResponseFormatRejected(err) {
+			logger.Info("upstream rejected response_format, fallback to prompt",
+				zap.String("model", model), zap.Error(err))
+			responseFormatUnsupported.Store(cacheKey, struct{}{})
+			extra = nil
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "chat completion")
+		}
+
+		answer = trimJSONFence(answer)
+		verr := schema.Validate([]byte(answer))
+		if verr == nil {
+			break
+		}
+		if repairs >= schema.MaxRepairs {
+			return answer, errors.WithStack(verr)
+		}
+
+		repairs++
+		logger.Debug("answer mismatches schema, ask to repair",
+			zap.Int("repair", repairs), zap.Error(verr))
+		var problems []string
+		if schemaErr := new(SchemaError); errors.As(verr, &schemaErr) {
+			problems = schemaErr.Problems
+		}
+		messages = append(messages,
+			map[string]any{"role": "assistant", "content": answer},
+			map[string]any{"role": "user", "content": structuredRepairPrompt + "- " +
+				strings.Join(problems, "\n- ") + "\n\nReply again with only the corrected JSON."},
+		)
+	}
+
+	if out != nil {
+		if err = json.Unmarshal([]byte(answer), out); err != nil {
+			return answer, errors.Wrap(err, "unmarshal answer")
+		}
+	}
+
+	return answer, nil
+}
+
+// isResponseFormatRejected reports whether err is an upstream rejecting
+// the `response_format` parameter.
+func isResponseFormatRejected(err error) bool {
+	upErr := new(upstreamError)
+	if !errors.As(err, &upErr) {
+		return false
+	}
+	switch upErr.status {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+	default:
+		return false
+	}
+	return strings.Contains(upErr.body, "response_format") ||
+		strings.Contains(upErr.body, "json_schema")
+}
+
+// trimJSONFence removes the markdown code fence models like to wrap JSON in.
+func trimJSONFence(answer string) string {
+	answer = strings.TrimSpace(answer)
+	if !strings.HasPrefix(answer, "```") {
+		return answer
+	}
+
+	answer = strings.TrimSuffix(answer, "```")
+	if i := strings.IndexByte(answer, '\n'); i >= 0 {
+		answer = answer[i+1:]
+	} else {
+		answer = strings.TrimPrefix(answer, "```")
+	}
+	return strings.TrimSpace(answer)
+}
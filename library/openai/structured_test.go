@@ -0,0 +1,162 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+		"kind": {"enum": ["cat", "dog"]}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {"tag": {"type": "string", "pattern": "^[a-z]+$"}}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	s := &Schema{Schema: []byte(testSchema)}
+	require.NoError(t, s.Valid())
+	require.Equal(t, "answer", s.Name)
+	require.Equal(t, 2, s.MaxRepairs)
+
+	require.NoError(t, s.Validate([]byte(`{"name":"tom","age":3,"tags":["a"],"kind":"cat"}`)))
+
+	err := s.Validate([]byte(`{"name":"","age":1.5,"tags":["A","b","c"],"kind":"cow","extra":1}`))
+	schemaErr := new(SchemaError)
+	require.True(t, errors.As(err, &schemaErr))
+	require.Equal(t, []string{
+		"$.age: expected integer, got number",
+		`$: unexpected property "extra"`,
+		"$.kind: value is not one of the enum",
+		"$.name: expected at least 1 characters",
+		"$.tags: expected at most 2 items",
+		`$.tags[0]: does not match pattern "^[a-z]+$"`,
+	}, schemaErr.Problems)
+
+	err = s.Validate([]byte(`{"age":-1}`))
+	require.ErrorContains(t, err, `$: missing required property "name"`)
+	require.ErrorContains(t, err, "$.age: expected >= 0")
+	require.ErrorContains(t, s.Validate([]byte(`not json`)), "not valid json")
+
+	oneOf := &Schema{Schema: []byte(`{"oneOf":[{"type":"string"},{"type":"number"}]}`)}
+	require.NoError(t, oneOf.Validate([]byte(`1`)))
+	require.Error(t, oneOf.Validate([]byte(`true`)))
+
+	for _, bad := range []*Schema{
+		{Schema: []byte(`[]`)},
+		{Schema: []byte(`{"pattern":"("}`)},
+		{Schema: []byte(testSchema), Name: "has space"},
+		{Schema: []byte(testSchema), MaxRepairs: 9},
+		{},
+	} {
+		require.Error(t, bad.Valid())
+	}
+}
+
+func TestTrimJSONFence(t *testing.T) {
+	require.Equal(t, `{"a":1}`, trimJSONFence(" {\"a\":1}\n"))
+	require.Equal(t, `{"a":1}`, trimJSONFence("```json\n{\"a\":1}\n```"))
+	require.Equal(t, `{"a":1}`, trimJSONFence("```{\"a\":1}```"))
+}
+
+// structuredUpstream fakes a chat completions upstream that answers with
+// answers in turn, and records the requests.
+type structuredUpstream struct {
+	mu       sync.Mutex
+	answers  []string
+	requests []map[string]any
+	// rejectFormat rejects requests with response_format
+	rejectFormat bool
+}
+
+func (u *structuredUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	u.requests = append(u.requests, body)
+	if _, ok := body["response_format"]; ok && u.rejectFormat {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"message":"unknown parameter: response_format"}}`))
+		return
+	}
+
+	answer := u.answers[0]
+	if len(u.answers) > 1 {
+		u.answers = u.answers[1:]
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"choices": []any{map[string]any{"message": map[string]any{"content": answer}}},
+	})
+}
+
+func TestOneshotStructured(t *testing.T) {
+	ctx := context.Background()
+	up := &structuredUpstream{answers: []string{
+		"```json\n{\"name\":\"tom\"}\n```",
+		`{"name":"tom","age":3}`,
+	}}
+	srv := httptest.NewServer(up)
+	defer srv.Close()
+
+	var out struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	answer, err := OneshotStructured(ctx, srv.URL, "key", "m1", "", "who?",
+		&Schema{Name: "person", Schema: []byte(testSchema)}, &out)
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"tom","age":3}`, answer)
+	require.Equal(t, 3, out.Age)
+
+	require.Len(t, up.requests, 2, "the first answer is repaired")
+	format := up.requests[0]["response_format"].(map[string]any)
+	require.Equal(t, "json_schema", format["type"])
+	require.Equal(t, "person", format["json_schema"].(map[string]any)["name"])
+	repair := up.requests[1]["messages"].([]any)
+	require.Len(t, repair, 4)
+	require.Equal(t, `{"name":"tom"}`, repair[2].(map[string]any)["content"])
+	require.Contains(t, repair[3].(map[string]any)["content"], `$: missing required property "age"`)
+
+	// repairs are bounded
+	up.requests = nil
+	up.answers = []string{`{"name":"tom"}`}
+	answer, err = OneshotStructured(ctx, srv.URL, "key", "m1", "", "who?",
+		&Schema{Schema: []byte(testSchema), MaxRepairs: 1}, nil)
+	schemaErr := new(SchemaError)
+	require.True(t, errors.As(err, &schemaErr))
+	require.Equal(t, `{"name":"tom"}`, answer)
+	require.Len(t, up.requests, 2)
+}
+
+func TestOneshotStructuredFallback(t *testing.T) {
+	up := &structuredUpstream{answers: []string{`{"name":"tom","age":3}`}, rejectFormat: true}
+	srv := httptest.NewServer(up)
+	defer srv.Close()
+
+	for range 2 {
+		_, err := OneshotStructured(context.Background(), srv.URL, "key", "m2", "", "who?",
+			&Schema{Schema: []byte(testSchema)}, nil)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, up.requests, 3, "the rejection is remembered")
+	_, ok := up.requests[1]["response_format"]
+	require.False(t, ok)
+	system := up.requests[2]["messages"].([]any)[0].(map[string]any)["content"].(string)
+	require.True(t, strings.HasSuffix(system, testSchema), "the schema is in the prompt")
+}
@@ -0,0 +1,270 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	gconfig "github.com/Laisky/go-config/v2"
+	glog "github.com/Laisky/go-utils/v6/log"
+	"github.com/Laisky/zap"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Laisky/go-ramjet/library/log"
+	rutils "github.com/Laisky/go-ramjet/library/redis"
+)
+
+// memoryKeyedMaxKeys bounds the buckets kept by the memory backend, idle
+// full buckets are dropped once it is reached.
+const memoryKeyedMaxKeys = 10000
+
+// Result is the outcome of one keyed limiter check.
+type Result struct {
+	// Allowed reports whether the cost was taken from the bucket.
+	Allowed bool
+	// Limit is the bucket capacity.
+	Limit int
+	// Remaining is the tokens left after this check.
+	Remaining int
+	// RetryAfter is how long until the same cost fits, zero if allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// SetHeaders writes the `RateLimit-*` headers, and `Retry-After` if denied.
+func (r Result) SetHeaders(h http.Header) {
+	h.Set("RateLimit-Limit", strconv.Itoa(r.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(r.Reset)))
+	if !r.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(r.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ExceededError is returned by callers when a keyed limiter denies a
+// request. It maps to 429 in web.AbortErr.
+type ExceededError struct {
+	Result Result
+	// Msg is the user facing message, a generic one is used if empty.
+	Msg string
+}
+
+// Error implements error.
+func (e *ExceededError) Error() string {
+	if e.Msg != "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("rate limit exceeded, retry after %d seconds", ceilSeconds(e.Result.RetryAfter))
+}
+
+// HTTPStatus returns 429.
+func (e *ExceededError) HTTPStatus() int {
+	return http.StatusTooManyRequests
+}
+
+// SetHeaders writes the rate limit headers of the denied check.
+func (e *ExceededError) SetHeaders(h http.Header) {
+	e.Result.SetHeaders(h)
+}
+
+// KeyedLimiter keeps one token bucket per key, such as a user, a client
+// IP, or a model group.
+type KeyedLimiter interface {
+	// AllowN takes n tokens from the bucket of key.
+	AllowN(ctx context.Context, key string, n int) Result
+	// ReturnN puts back n tokens taken by an allowed AllowN, for callers
+	// checking several buckets whose later bucket denied the request.
+	ReturnN(ctx context.Context, key string, n int)
+}
+
+// NewKeyed creates a keyed limiter using the configured backend.
+func NewKeyed(name string, args Args) (KeyedLimiter, error) {
+	if args.Max <= 0 {
+		return nil, errors.Errorf("ratelimit %q: max must be > 0", name)
+	}
+	if args.NPerSec <= 0 {
+		return nil, errors.Errorf("ratelimit %q: n_per_sec must be > 0", name)
+	}
+
+	backend := normaliseBackend(gconfig.Shared.GetString("openai.rate_limiter_backend"))
+	switch backend {
+	case BackendLegacy:
+		return newMemoryKeyedLimiter(args), nil
+	case BackendRedis:
+		return newRedisKeyedLimiter(name, args)
+	default:
+		return nil, errors.Errorf("unsupported rate limiter backend %q", backend)
+	}
+}
+
+// bucketResult builds a Result from the tokens left in a bucket.
+func bucketResult(allowed bool, tokens float64, cost int, args Args) Result {
+	rate := float64(args.NPerSec)
+	r := Result{
+		Allowed:   allowed,
+		Limit:     args.Max,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(args.Max) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		need := float64(cost) - tokens
+		if cost > args.Max {
+			// never fits, ask to wait for a full refill
+			need = float64(args.Max)
+		}
+		r.RetryAfter = time.Duration(math.Max(need, 0) / rate * float64(time.Second))
+	}
+	return r
+}
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// memoryKeyedLimiter refills lazily on access, so idle keys cost nothing
+// but memory.
+type memoryKeyedLimiter struct {
+	mu      sync.Mutex
+	args    Args
+	buckets map[string]*memoryBucket
+	now     func() time.Time
+}
+
+func newMemoryKeyedLimiter(args Args) *memoryKeyedLimiter {
+	return &memoryKeyedLimiter{
+		args:    args,
+		buckets: map[string]*memoryBucket{},
+		now:     time.Now,
+	}
+}
+
+// AllowN implements KeyedLimiter.
+func (l *memoryKeyedLimiter) AllowN(_ context.Context, key string, n int) Result {
+	if n <= 0 {
+		n = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= memoryKeyedMaxKeys {
+			l.prune(now)
+		}
+		b = &memoryBucket{tokens: float64(l.args.Max), last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	allowed := float64(n) <= b.tokens
+	if allowed {
+		b.tokens -= float64(n)
+	}
+	return bucketResult(allowed, b.tokens, n, l.args)
+}
+
+// ReturnN implements KeyedLimiter.
+func (l *memoryKeyedLimiter) ReturnN(_ context.Context, key string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		return
+	}
+	l.refill(b, l.now())
+	b.tokens = math.Min(float64(l.args.Max), b.tokens+float64(n))
+}
+
+func (l *memoryKeyedLimiter) refill(b *memoryBucket, now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(l.args.Max), b.tokens+elapsed*float64(l.args.NPerSec))
+		b.last = now
+	}
+}
+
+// prune drops buckets that have refilled completely, they are
+// indistinguishable from new ones.
+func (l *memoryKeyedLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.args.Max) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// redisKeyedLimiter shares tokenBucketScript with redisLimiter, one hash
+// per key.
+type redisKeyedLimiter struct {
+	client *redis.Client
+	logger glog.Logger
+	prefix string
+	args   Args
+	ttl    time.Duration
+}
+
+func newRedisKeyedLimiter(name string, args Args) (*redisKeyedLimiter, error) {
+	cli := rutils.GetCli().GetDB()
+	if cli == nil {
+		return nil, errors.Errorf("ratelimit %q: redis client is nil", name)
+	}
+
+	windowSeconds := float64(args.Max) / float64(args.NPerSec)
+	return &redisKeyedLimiter{
+		client: cli.Client,
+		logger: log.Logger.Named("ratelimit").With(zap.String("name", name)),
+		prefix: fmt.Sprintf("ramjet:ratelimit:%s:", name),
+		args:   args,
+		ttl:    time.Duration(math.Max(windowSeconds*3, 60) * float64(time.Second)),
+	}, nil
+}
+
+// AllowN implements KeyedLimiter. Redis errors fail open, like redisLimiter.
+func (l *redisKeyedLimiter) AllowN(ctx context.Context, key string, n int) Result {
+	if n <= 0 {
+		n = 1
+	}
+
+	res, err := tokenBucketScript.Run(ctx, l.client, []string{l.prefix + key},
+		time.Now().UnixMilli(), l.args.Max, l.args.NPerSec, n, l.ttl.Milliseconds()).Result()
+	if err == nil {
+		if values, ok := res.([]interface{}); ok && len(values) == 2 {
+			return bucketResult(toInt(values[0]) == 1, float64(toInt(values[1])), n, l.args)
+		}
+		err = errors.Errorf("unexpected redis limiter response: %#v", res)
+	}
+
+	l.logger.Warn("redis keyed limiter allow", zap.String("key", key), zap.Error(err))
+	return Result{Allowed: true, Limit: l.args.Max, Remaining: l.args.Max}
+}
+
+// ReturnN implements KeyedLimiter, by running tokenBucketScript with a
+// negative cost.
+func (l *redisKeyedLimiter) ReturnN(ctx context.Context, key string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	if err := tokenBucketScript.Run(ctx, l.client, []string{l.prefix + key},
+		time.Now().UnixMilli(), l.args.Max, l.args.NPerSec, -n, l.ttl.Milliseconds()).Err(); err != nil {
+		l.logger.Warn("redis keyed limiter return", zap.String("key", key), zap.Error(err))
+	}
+}
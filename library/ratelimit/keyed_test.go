@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryKeyedLimiterIsolatesKeysAndRefills(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newMemoryKeyedLimiter(Args{Max: 4, NPerSec: 1})
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if r := l.AllowN(ctx, "alice", 3); !r.Allowed || r.Remaining != 1 || r.Limit != 4 {
+		t.Fatalf("expected first request allowed with 1 remaining, got %+v", r)
+	}
+	r := l.AllowN(ctx, "alice", 3)
+	if r.Allowed {
+		t.Fatalf("expected alice to be limited")
+	}
+	if r.RetryAfter != 2*time.Second {
+		t.Fatalf("expected retry after 2s, got %s", r.RetryAfter)
+	}
+	if r.Reset != 3*time.Second {
+		t.Fatalf("expected reset after 3s, got %s", r.Reset)
+	}
+
+	if r := l.AllowN(ctx, "bob", 3); !r.Allowed {
+		t.Fatalf("expected bob to have his own bucket")
+	}
+
+	now = now.Add(2 * time.Second)
+	if r := l.AllowN(ctx, "alice", 3); !r.Allowed || r.Remaining != 0 {
+		t.Fatalf("expected alice to be refilled, got %+v", r)
+	}
+
+	if r := l.AllowN(ctx, "carol", 5); r.Allowed || r.RetryAfter != 4*time.Second {
+		t.Fatalf("expected a cost above capacity to wait a full refill, got %+v", r)
+	}
+}
+
+func TestMemoryKeyedLimiterReturnN(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newMemoryKeyedLimiter(Args{Max: 4, NPerSec: 1})
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	l.AllowN(ctx, "alice", 3)
+	l.ReturnN(ctx, "alice", 3)
+	if r := l.AllowN(ctx, "alice", 4); !r.Allowed {
+		t.Fatalf("expected returned tokens to be usable, got %+v", r)
+	}
+
+	l.ReturnN(ctx, "alice", 10)
+	if r := l.AllowN(ctx, "alice", 1); r.Remaining != 3 {
+		t.Fatalf("expected returns to be capped at capacity, got %+v", r)
+	}
+	l.ReturnN(ctx, "bob", 1)
+	if _, ok := l.buckets["bob"]; ok {
+		t.Fatalf("expected no bucket for unknown keys")
+	}
+}
+
+func TestMemoryKeyedLimiterPrunesFullBuckets(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newMemoryKeyedLimiter(Args{Max: 2, NPerSec: 1})
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < memoryKeyedMaxKeys; i++ {
+		l.AllowN(ctx, time.Duration(i).String(), 1)
+	}
+	now = now.Add(time.Minute)
+	l.AllowN(ctx, "new", 1)
+	if len(l.buckets) != 1 {
+		t.Fatalf("expected idle buckets to be pruned, got %d", len(l.buckets))
+	}
+}
+
+func TestResultSetHeaders(t *testing.T) {
+	h := http.Header{}
+	Result{Allowed: true, Limit: 10, Remaining: 7, Reset: 2500 * time.Millisecond}.SetHeaders(h)
+	if h.Get("RateLimit-Limit") != "10" || h.Get("RateLimit-Remaining") != "7" || h.Get("RateLimit-Reset") != "3" {
+		t.Fatalf("unexpected headers %v", h)
+	}
+	if h.Get("Retry-After") != "" {
+		t.Fatalf("allowed result should not set Retry-After")
+	}
+
+	err := &ExceededError{Result: Result{Limit: 10, RetryAfter: 1200 * time.Millisecond}}
+	if err.HTTPStatus() != http.StatusTooManyRequests {
+		t.Fatalf("expected 429")
+	}
+	if err.Error() != "rate limit exceeded, retry after 2 seconds" {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+	h = http.Header{}
+	err.SetHeaders(h)
+	if h.Get("Retry-After") != "2" {
+		t.Fatalf("expected Retry-After 2, got %q", h.Get("Retry-After"))
+	}
+}
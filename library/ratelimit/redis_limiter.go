@@ -77,7 +77,8 @@ end
 
 local allowed = 0
 if cost <= tokens then
-	tokens = tokens - cost
+	-- a negative cost returns tokens, never above the capacity
+	tokens = math.min(max_tokens, tokens - cost)
 	allowed = 1
 end
 
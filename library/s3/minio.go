@@ -33,15 +33,23 @@ func GetCli(
 
 	var err error
 	once.Do(func() {
-		cli, err = minio.New(
-			endpoint,
-			&minio.Options{
-				Creds: credentials.NewStaticV4(
-					accessID, accessKey, ""),
-				Secure: true,
-			},
-		)
+		cli, err = NewCli(endpoint, accessID, accessKey, true)
 	})
 
 	return cli, err
 }
+
+// NewCli create a new s3 client, for callers that need their own endpoint
+func NewCli(
+	endpoint, accessID, accessKey string,
+	secure bool,
+) (*minio.Client, error) {
+	return minio.New(
+		endpoint,
+		&minio.Options{
+			Creds: credentials.NewStaticV4(
+				accessID, accessKey, ""),
+			Secure: secure,
+		},
+	)
+}
@@ -0,0 +1,192 @@
+package s3
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// Retention reasons recorded in RetentionDecision.
+const (
+	RetentionReasonLast     = "last"
+	RetentionReasonDaily    = "daily"
+	RetentionReasonWeekly   = "weekly"
+	RetentionReasonMonthly  = "monthly"
+	RetentionReasonYearly   = "yearly"
+	RetentionReasonMinAge   = "min_age"
+	RetentionReasonMaxTotal = "max_total_size"
+)
+
+// RetentionPolicy is a grandfather-father-son retention policy.
+//
+// An item is kept if any keep rule keeps it, and all items are kept if no
+// keep rule is set. MaxTotalSize then expires the oldest kept items until the
+// kept ones fit. Items younger than MinAge are never expired.
+type RetentionPolicy struct {
+	// KeepLast keeps the newest N items.
+	KeepLast int `mapstructure:"keep_last" json:"keep_last,omitempty"`
+	// KeepDaily keeps the newest item of each of the newest N days.
+	KeepDaily int `mapstructure:"keep_daily" json:"keep_daily,omitempty"`
+	// KeepWeekly keeps the newest item of each of the newest N ISO weeks.
+	KeepWeekly int `mapstructure:"keep_weekly" json:"keep_weekly,omitempty"`
+	// KeepMonthly keeps the newest item of each of the newest N months.
+	KeepMonthly int `mapstructure:"keep_monthly" json:"keep_monthly,omitempty"`
+	// KeepYearly keeps the newest item of each of the newest N years.
+	KeepYearly int `mapstructure:"keep_yearly" json:"keep_yearly,omitempty"`
+	// MinAge protects items younger than it.
+	MinAge time.Duration `mapstructure:"min_age" json:"min_age,omitempty"`
+	// MaxTotalSize caps the total bytes of kept items, 0 for no cap.
+	MaxTotalSize int64 `mapstructure:"max_total_size" json:"max_total_size,omitempty"`
+}
+
+// hasKeepRule reports whether any keep rule is set.
+func (p RetentionPolicy) hasKeepRule() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 ||
+		p.KeepMonthly > 0 || p.KeepYearly > 0
+}
+
+// KeepsAll reports whether p never expires anything.
+func (p RetentionPolicy) KeepsAll() bool {
+	return !p.hasKeepRule() && p.MaxTotalSize <= 0
+}
+
+// Valid checks p.
+func (p RetentionPolicy) Valid() error {
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 ||
+		p.KeepMonthly < 0 || p.KeepYearly < 0 {
+		return errors.New("keep rules should not be negative")
+	}
+	if p.MinAge < 0 || p.MaxTotalSize < 0 {
+		return errors.New("min_age and max_total_size should not be negative")
+	}
+
+	return nil
+}
+
+// RetentionItem is an object, or a version of an object, under retention.
+type RetentionItem struct {
+	Key       string    `json:"key"`
+	VersionID string    `json:"version_id,omitempty"`
+	Time      time.Time `json:"time"`
+	Size      int64     `json:"size"`
+	// IsLatest wins ties of Time.
+	IsLatest bool `json:"-"`
+}
+
+// RetentionDecision is whether an item is kept, and by which rules.
+type RetentionDecision struct {
+	RetentionItem
+	Keep    bool     `json:"keep"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// retentionPeriods are the calendar rules of a policy.
+var retentionPeriods = []struct {
+	reason string
+	keep   func(RetentionPolicy) int
+	period func(time.Time) string
+}{
+	{RetentionReasonDaily, func(p RetentionPolicy) int { return p.KeepDaily },
+		func(t time.Time) string { return t.Format(time.DateOnly) }},
+	{RetentionReasonWeekly, func(p RetentionPolicy) int { return p.KeepWeekly },
+		func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+	{RetentionReasonMonthly, func(p RetentionPolicy) int { return p.KeepMonthly },
+		func(t time.Time) string { return t.Format("2006-01") }},
+	{RetentionReasonYearly, func(p RetentionPolicy) int { return p.KeepYearly },
+		func(t time.Time) string { return t.Format("2006") }},
+}
+
+// Plan decides which items p keeps at now, newest first.
+// Calendar periods are in UTC.
+func (p RetentionPolicy) Plan(items []RetentionItem, now time.Time) []RetentionDecision {
+	decisions := make([]RetentionDecision, 0, len(items))
+	for _, item := range items {
+		decisions = append(decisions, RetentionDecision{RetentionItem: item})
+	}
+	slices.SortFunc(decisions, func(a, b RetentionDecision) int {
+		if c := b.Time.Compare(a.Time); c != 0 {
+			return c
+		}
+		if a.IsLatest != b.IsLatest {
+			if a.IsLatest {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(b.Key, a.Key); c != 0 {
+			return c
+		}
+		return strings.Compare(b.VersionID, a.VersionID)
+	})
+
+	keep := func(d *RetentionDecision, reason string) {
+		d.Keep = true
+		d.Reasons = append(d.Reasons, reason)
+	}
+	for i := range decisions {
+		d := &decisions[i]
+		if !p.hasKeepRule() {
+			d.Keep = true
+		} else if i < p.KeepLast {
+			keep(d, RetentionReasonLast)
+		}
+		if p.MinAge > 0 && now.Sub(d.Time) < p.MinAge {
+			keep(d, RetentionReasonMinAge)
+		}
+	}
+	for _, rule := range retentionPeriods {
+		n := rule.keep(p)
+		seen := map[string]bool{}
+		for i := range decisions {
+			if len(seen) >= n {
+				break
+			}
+			if period := rule.period(decisions[i].Time.UTC()); !seen[period] {
+				seen[period] = true
+				keep(&decisions[i], rule.reason)
+			}
+		}
+	}
+
+	if p.MaxTotalSize > 0 {
+		var (
+			total int64
+			full  bool
+		)
+		for i := range decisions {
+			d := &decisions[i]
+			if !d.Keep {
+				continue
+			}
+			if slices.Contains(d.Reasons, RetentionReasonMinAge) {
+				total += d.Size
+				continue
+			}
+			if full = full || total+d.Size > p.MaxTotalSize; full {
+				d.Keep = false
+				d.Reasons = []string{RetentionReasonMaxTotal}
+				continue
+			}
+			total += d.Size
+		}
+	}
+
+	return decisions
+}
+
+// ExpiredItems returns the items of decisions that are not kept.
+func ExpiredItems(decisions []RetentionDecision) []RetentionItem {
+	var expired []RetentionItem
+	for _, d := range decisions {
+		if !d.Keep {
+			expired = append(expired, d.RetentionItem)
+		}
+	}
+	return expired
+}
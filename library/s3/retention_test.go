@@ -0,0 +1,106 @@
+package s3
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// day returns the item of key at 12:00 UTC of date.
+func day(key, date string, size int64) RetentionItem {
+	t, _ := time.Parse(time.DateOnly, date)
+	return RetentionItem{Key: key, Time: t.Add(12 * time.Hour), Size: size}
+}
+
+func expiredKeys(decisions []RetentionDecision) []string {
+	var keys []string
+	for _, item := range ExpiredItems(decisions) {
+		keys = append(keys, item.Key)
+	}
+	return keys
+}
+
+func TestRetentionPolicy_Plan(t *testing.T) {
+	items := []RetentionItem{
+		day("2024-06", "2024-06-30", 1),
+		day("2025-12", "2025-12-31", 1),
+		day("2026-01a", "2026-01-01", 1),
+		day("2026-01b", "2026-01-05", 1),
+		day("2026-01c", "2026-01-06", 1),
+		day("2026-01d", "2026-01-07", 1),
+	}
+	now := items[5].Time.Add(time.Hour)
+
+	cases := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{"no rule keeps all", RetentionPolicy{}, nil},
+		{"last", RetentionPolicy{KeepLast: 4}, []string{"2025-12", "2024-06"}},
+		{"weekly", RetentionPolicy{KeepWeekly: 2}, []string{"2026-01c", "2026-01b", "2025-12", "2024-06"}},
+		{"monthly and yearly", RetentionPolicy{KeepMonthly: 2, KeepYearly: 3},
+			[]string{"2026-01c", "2026-01b", "2026-01a"}},
+		{"min age protects", RetentionPolicy{KeepLast: 1, MinAge: 50 * time.Hour},
+			[]string{"2026-01a", "2025-12", "2024-06"}},
+		{"max total size", RetentionPolicy{MaxTotalSize: 2}, []string{"2026-01b", "2026-01a", "2025-12", "2024-06"}},
+		{"max total size caps keep rules", RetentionPolicy{KeepYearly: 3, MaxTotalSize: 1},
+			[]string{"2026-01c", "2026-01b", "2026-01a", "2025-12", "2024-06"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := expiredKeys(c.policy.Plan(items, now)); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("expired %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	decisions := RetentionPolicy{KeepLast: 1, KeepDaily: 2}.Plan(items, now)
+	if want := []string{RetentionReasonLast, RetentionReasonDaily}; !reflect.DeepEqual(decisions[0].Reasons, want) {
+		t.Fatalf("reasons %v, want %v", decisions[0].Reasons, want)
+	}
+
+	if err := (RetentionPolicy{KeepLast: -1}).Valid(); err == nil {
+		t.Fatal("negative keep_last should be invalid")
+	}
+}
+
+func TestSweepRetention(t *testing.T) {
+	now := fakeVerBase.Add(time.Hour)
+	f := &fakeVersionClient{list: []minio.ObjectInfo{
+		{Key: "logs/a", VersionID: "a1", LastModified: fakeVerBase, Size: 10},
+		{Key: "logs/a", VersionID: "a2", LastModified: fakeVerBase.Add(-time.Hour), Size: 10},
+		{Key: "logs/a", VersionID: "a3", LastModified: fakeVerBase.Add(-2 * time.Hour), Size: 10},
+		{Key: "logs/a", VersionID: "a4", LastModified: fakeVerBase.Add(-3 * time.Hour), IsDeleteMarker: true},
+		{Key: "logs/b", VersionID: "b1", LastModified: fakeVerBase, Size: 5},
+		{Key: "logs/b", VersionID: "b2", LastModified: fakeVerBase.Add(-time.Hour), Size: 5},
+	}}
+	target := RetentionTarget{Name: "logs", Bucket: "b", Prefix: "logs/", Versions: true,
+		Policy: RetentionPolicy{KeepLast: 1}}
+
+	report, err := SweepRetention(context.Background(), testLogger(t), f, target, now, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.removed) != 0 {
+		t.Fatalf("dry run removed %v", f.removed)
+	}
+	if report.Kept != 2 || report.Expired != 3 || report.ExpiredBytes != 25 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	if _, err = SweepRetention(context.Background(), testLogger(t), f, target, now, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"a2", "a3", "b2"}; !reflect.DeepEqual(f.removed, want) {
+		t.Fatalf("removed %v, want %v", f.removed, want)
+	}
+
+	target.Bucket = ""
+	if _, err = SweepRetention(context.Background(), testLogger(t), f, target, now, true); err == nil {
+		t.Fatal("expected error without bucket")
+	}
+}
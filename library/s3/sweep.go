@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	glog "github.com/Laisky/go-utils/v6/log"
+	"github.com/Laisky/zap"
+	"github.com/minio/minio-go/v7"
+)
+
+// RetentionClient is the subset of *minio.Client needed to sweep objects.
+type RetentionClient interface {
+	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
+}
+
+// RetentionTarget declares a retention policy on the objects under a prefix.
+type RetentionTarget struct {
+	Name   string `json:"name"`
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	// Versions applies the policy to the versions of each object under
+	// Prefix, instead of to the objects themselves.
+	Versions bool            `json:"versions,omitempty"`
+	Policy   RetentionPolicy `mapstructure:",squash" json:"policy"`
+}
+
+// RetentionReport is the outcome of a sweep.
+type RetentionReport struct {
+	RetentionTarget
+	DryRun       bool                `json:"dry_run"`
+	Kept         int                 `json:"kept"`
+	KeptBytes    int64               `json:"kept_bytes"`
+	Expired      int                 `json:"expired"`
+	ExpiredBytes int64               `json:"expired_bytes"`
+	Decisions    []RetentionDecision `json:"decisions"`
+}
+
+// add records decisions into r.
+func (r *RetentionReport) add(decisions []RetentionDecision) {
+	for _, d := range decisions {
+		if d.Keep {
+			r.Kept++
+			r.KeptBytes += d.Size
+		} else {
+			r.Expired++
+			r.ExpiredBytes += d.Size
+		}
+	}
+	r.Decisions = append(r.Decisions, decisions...)
+}
+
+// SweepRetention applies the policy of target at now, and removes the expired
+// objects unless dryRun. Delete markers and unversioned objects are never
+// removed in versions mode.
+func SweepRetention(
+	ctx context.Context,
+	logger glog.Logger,
+	cli RetentionClient,
+	target RetentionTarget,
+	now time.Time,
+	dryRun bool,
+) (*RetentionReport, error) {
+	if err := target.Policy.Valid(); err != nil {
+		return nil, errors.Wrapf(err, "retention %q", target.Name)
+	}
+	if strings.TrimSpace(target.Bucket) == "" {
+		return nil, errors.Errorf("retention %q requires bucket", target.Name)
+	}
+
+	groups := map[string][]RetentionItem{}
+	var keys []string
+	for obj := range cli.ListObjects(ctx, target.Bucket, minio.ListObjectsOptions{
+		Prefix:       target.Prefix,
+		Recursive:    true,
+		WithVersions: target.Versions,
+	}) {
+		if obj.Err != nil {
+			return nil, errors.Wrapf(obj.Err, "list objects with prefix %q", target.Prefix)
+		}
+
+		group := ""
+		if target.Versions {
+			if obj.VersionID == "" || obj.IsDeleteMarker {
+				continue
+			}
+			group = obj.Key
+		}
+		if _, ok := groups[group]; !ok {
+			keys = append(keys, group)
+		}
+		groups[group] = append(groups[group], RetentionItem{
+			Key:       obj.Key,
+			VersionID: obj.VersionID,
+			Time:      obj.LastModified,
+			Size:      obj.Size,
+			IsLatest:  obj.IsLatest,
+		})
+	}
+
+	report := &RetentionReport{RetentionTarget: target, DryRun: dryRun}
+	for _, key := range keys {
+		decisions := target.Policy.Plan(groups[key], now)
+		report.add(decisions)
+		if dryRun {
+			continue
+		}
+
+		for _, item := range ExpiredItems(decisions) {
+			if err := cli.RemoveObject(ctx, target.Bucket, item.Key,
+				minio.RemoveObjectOptions{VersionID: item.VersionID}); err != nil {
+				return report, errors.Wrapf(err, "remove object %q", item.Key)
+			}
+			logger.Debug("removed expired object",
+				zap.String("bucket", target.Bucket),
+				zap.String("object", item.Key),
+				zap.String("version", item.VersionID))
+		}
+	}
+
+	return report, nil
+}
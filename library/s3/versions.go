@@ -3,8 +3,8 @@ package s3
 import (
 	"context"
 	"io"
-	"sort"
 	"strings"
+	"time"
 
 	"github.com/Laisky/errors/v2"
 	glog "github.com/Laisky/go-utils/v6/log"
@@ -91,14 +91,18 @@ func KeepLatestObjectVersions(
 		return nil
 	}
 
-	sort.Slice(versions, func(i, j int) bool {
-		if versions[i].LastModified.Equal(versions[j].LastModified) {
-			return versions[i].VersionID > versions[j].VersionID
-		}
-		return versions[i].LastModified.After(versions[j].LastModified)
-	})
+	items := make([]RetentionItem, 0, len(versions))
+	for _, version := range versions {
+		items = append(items, RetentionItem{
+			Key:       version.Key,
+			VersionID: version.VersionID,
+			Time:      version.LastModified,
+			Size:      version.Size,
+		})
+	}
 
-	for _, version := range versions[keep:] {
+	policy := RetentionPolicy{KeepLast: keep}
+	for _, version := range ExpiredItems(policy.Plan(items, time.Now())) {
 		if err := cli.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{VersionID: version.VersionID}); err != nil {
 			return errors.Wrapf(err, "remove object version %s", version.VersionID)
 		}
@@ -32,6 +32,14 @@ func isContextCanceledErr(err error) bool {
 		strings.Contains(msg, "connection reset by peer")
 }
 
+// httpStatusError is an expected rejection that carries its own status,
+// such as ratelimit.ExceededError. It may also implement
+// SetHeaders(http.Header) to add response headers.
+type httpStatusError interface {
+	error
+	HTTPStatus() int
+}
+
 // AbortErr abort with error
 func AbortErr(ctx *gin.Context, err error) bool {
 	if err == nil {
@@ -52,6 +60,18 @@ func AbortErr(ctx *gin.Context, err error) bool {
 		return true
 	}
 
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		logger.Info("request rejected", zap.Int("status", statusErr.HTTPStatus()), zap.Error(err))
+		if h, ok := statusErr.(interface{ SetHeaders(http.Header) }); ok {
+			h.SetHeaders(ctx.Writer.Header())
+		}
+		ctx.AbortWithStatusJSON(statusErr.HTTPStatus(), gin.H{
+			"err": err.Error(),
+		})
+		return true
+	}
+
 	logger.Error("chat abort", zap.Error(err))
 	ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 		"err": err.Error(),
@@ -3,6 +3,7 @@ package web
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -106,3 +107,23 @@ func TestAbortErr_Default(t *testing.T) {
 	// Ensure we did log an error-level entry for a real error.
 	req.True(strings.Contains(out, "\"level\":\"error\"") || strings.Contains(out, "\"level\":\"ERROR\""))
 }
+
+type testStatusError struct{}
+
+func (testStatusError) Error() string            { return "slow down" }
+func (testStatusError) HTTPStatus() int          { return http.StatusTooManyRequests }
+func (testStatusError) SetHeaders(h http.Header) { h.Set("Retry-After", "7") }
+
+// TestAbortErr_StatusError ensures errors carrying a status keep it and their headers.
+func TestAbortErr_StatusError(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+
+	ctx, w, _, _ := newTestGinContext(t)
+
+	ok := AbortErr(ctx, fmt.Errorf("check model: %w", testStatusError{}))
+	req.True(ok)
+	req.Equal(http.StatusTooManyRequests, w.Code)
+	req.Equal("7", w.Header().Get("Retry-After"))
+	req.Contains(w.Body.String(), "slow down")
+}
@@ -10,6 +10,9 @@ const GPTChatPage = lazy(() =>
 const CVPage = lazy(() =>
   import('@/pages/cv').then((m) => ({ default: m.CVPage })),
 )
+const StatusPage = lazy(() =>
+  import('@/pages/status').then((m) => ({ default: m.StatusPage })),
+)
 const TaskPage = lazy(() =>
   import('@/pages/task').then((m) => ({ default: m.TaskPage })),
 )
@@ -48,6 +51,7 @@ export function App() {
           <Route path="/" element={<SiteLanding />} />
           <Route path="/gptchat" element={<GPTChatPage />} />
           <Route path="/cv" element={<CVPage />} />
+          <Route path="/status" element={<StatusPage />} />
           <Route path="/tasks/:task" element={<TaskPage />} />
           <Route path="*" element={<NotFoundPage />} />
         </Routes>
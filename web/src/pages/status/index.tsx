@@ -0,0 +1,185 @@
+import { useCallback, useEffect, useState } from 'react'
+
+import { Badge } from '@/components/ui/badge'
+import { Card, CardTitle } from '@/components/ui/card'
+import { cn } from '@/utils/cn'
+import { setPageTitle } from '@/utils/dom'
+
+import {
+  formatDuration,
+  formatUptime,
+  isZeroTime,
+  type ServiceSummary,
+  type StatusSummary,
+  uptimeColor,
+} from './status-helpers'
+
+const SUMMARY_URL = '/status/api/summary'
+const REFRESH_INTERVAL_MS = 60_000
+
+const statusLabels: Record<ServiceSummary['status'], string> = {
+  up: 'Operational',
+  down: 'Down',
+  stale: 'No data',
+}
+
+/**
+ * ServiceRow renders the status and the daily uptime bars of a service.
+ */
+function ServiceRow({ service }: { service: ServiceSummary }) {
+  return (
+    <div className="space-y-2 border-b py-4 last:border-b-0">
+      <div className="flex items-center justify-between gap-4">
+        <div className="font-medium">{service.name}</div>
+        <Badge
+          variant={
+            service.status === 'up'
+              ? 'success'
+              : service.status === 'down'
+                ? 'destructive'
+                : 'secondary'
+          }
+        >
+          {statusLabels[service.status]}
+        </Badge>
+      </div>
+      <div className="flex h-8 gap-0.5">
+        {service.days.map((day) => (
+          <div
+            key={day.date}
+            title={`${day.date}: ${formatUptime(day.uptime)}`}
+            className={cn('flex-1 rounded-sm', uptimeColor(day.uptime))}
+          />
+        ))}
+      </div>
+      <div className="flex justify-between text-xs text-muted-foreground">
+        <span>30 days ago</span>
+        <span>
+          24h {formatUptime(service.uptime_24h)} · 7d{' '}
+          {formatUptime(service.uptime_7d)} · 30d{' '}
+          {formatUptime(service.uptime_30d)}
+        </span>
+        <span>Today</span>
+      </div>
+    </div>
+  )
+}
+
+/**
+ * StatusPage renders the public status page and returns the page element.
+ */
+export function StatusPage() {
+  const [summary, setSummary] = useState<StatusSummary | null>(null)
+  const [error, setError] = useState<string | null>(null)
+
+  useEffect(() => {
+    setPageTitle('Status')
+  }, [])
+
+  // loadSummary fetches the status summary from the backend API.
+  const loadSummary = useCallback(async (signal?: AbortSignal) => {
+    try {
+      const response = await fetch(SUMMARY_URL, { signal })
+      if (!response.ok) {
+        throw new Error(`${response.status} ${response.statusText}`)
+      }
+      setSummary((await response.json()) as StatusSummary)
+      setError(null)
+    } catch (err) {
+      if (err instanceof Error && err.name === 'AbortError') {
+        return
+      }
+      setError(err instanceof Error ? err.message : 'failed to load status')
+    }
+  }, [])
+
+  useEffect(() => {
+    const controller = new AbortController()
+    loadSummary(controller.signal)
+    const timer = window.setInterval(
+      () => loadSummary(controller.signal),
+      REFRESH_INTERVAL_MS,
+    )
+    return () => {
+      window.clearInterval(timer)
+      controller.abort()
+    }
+  }, [loadSummary])
+
+  if (!summary) {
+    return (
+      <div className="flex min-h-[50vh] items-center justify-center text-muted-foreground">
+        {error ? `Failed to load status: ${error}` : 'Loading…'}
+      </div>
+    )
+  }
+
+  const services = summary.services ?? []
+  const incidents = summary.incidents ?? []
+  const operational = summary.status === 'up'
+
+  return (
+    <div className="mx-auto max-w-3xl space-y-6">
+      <Card
+        className={cn(
+          'p-6 text-lg font-semibold',
+          operational
+            ? 'bg-emerald-500 text-white'
+            : 'bg-amber-500 text-white',
+        )}
+      >
+        {operational
+          ? 'All systems operational'
+          : 'Some systems are experiencing issues'}
+      </Card>
+
+      <Card className="px-6 py-2">
+        {services.length === 0 ? (
+          <p className="py-4 text-muted-foreground">No monitored services.</p>
+        ) : (
+          services.map((service) => (
+            <ServiceRow key={service.name} service={service} />
+          ))
+        )}
+      </Card>
+
+      <Card className="space-y-4 p-6">
+        <CardTitle>Incidents</CardTitle>
+        {incidents.length === 0 ? (
+          <p className="text-sm text-muted-foreground">
+            No incidents in the last 30 days.
+          </p>
+        ) : (
+          <ul className="space-y-3 text-sm">
+            {incidents.map((incident) => (
+              <li
+                key={incident.id}
+                className="flex items-center justify-between gap-4"
+              >
+                <div>
+                  <span className="font-medium">{incident.service}</span>{' '}
+                  <span className="text-muted-foreground">
+                    {new Date(incident.started_at).toLocaleString()}
+                  </span>
+                </div>
+                <div className="text-muted-foreground">
+                  {isZeroTime(incident.ended_at) ? (
+                    <Badge variant="destructive">Ongoing</Badge>
+                  ) : (
+                    'Resolved'
+                  )}{' '}
+                  after {formatDuration(incident.started_at, incident.ended_at)}
+                </div>
+              </li>
+            ))}
+          </ul>
+        )}
+      </Card>
+
+      <p className="text-center text-xs text-muted-foreground">
+        Updated {new Date(summary.updated_at).toLocaleString()}, refreshes
+        every minute.
+      </p>
+    </div>
+  )
+}
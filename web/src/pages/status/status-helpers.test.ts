@@ -0,0 +1,50 @@
+import {
+  formatDuration,
+  formatUptime,
+  isZeroTime,
+  uptimeColor,
+} from '@/pages/status/status-helpers'
+import { describe, expect, it } from 'vitest'
+
+describe('formatUptime', () => {
+  it('renders ratios as truncated percentages', () => {
+    expect(formatUptime(1)).toBe('100%')
+    expect(formatUptime(0.99999)).toBe('99.99%')
+    expect(formatUptime(0.875)).toBe('87.50%')
+  })
+
+  it('renders a dash without data', () => {
+    expect(formatUptime(null)).toBe('—')
+  })
+})
+
+describe('uptimeColor', () => {
+  it('grades daily uptime', () => {
+    expect(uptimeColor(null)).toBe('bg-muted')
+    expect(uptimeColor(1)).toBe('bg-emerald-500')
+    expect(uptimeColor(0.995)).toBe('bg-amber-400')
+    expect(uptimeColor(0.5)).toBe('bg-rose-500')
+  })
+})
+
+describe('formatDuration', () => {
+  it('renders ended incidents', () => {
+    expect(
+      formatDuration('2026-01-10T08:00:00Z', '2026-01-10T09:05:30Z'),
+    ).toBe('1h 5m')
+    expect(
+      formatDuration('2026-01-10T08:00:00Z', '2026-01-10T08:00:42Z'),
+    ).toBe('42s')
+  })
+
+  it('renders ongoing incidents until now', () => {
+    expect(
+      formatDuration(
+        '2026-01-10T08:00:00Z',
+        '0001-01-01T00:00:00Z',
+        new Date('2026-01-12T08:30:00Z'),
+      ),
+    ).toBe('2d 30m')
+    expect(isZeroTime('0001-01-01T00:00:00Z')).toBe(true)
+  })
+})
@@ -0,0 +1,103 @@
+/**
+ * DayUptime is the uptime of a service on a UTC day, null if not checked.
+ */
+export interface DayUptime {
+  date: string
+  uptime: number | null
+}
+
+/**
+ * ServiceSummary is the public status of a service.
+ */
+export interface ServiceSummary {
+  name: string
+  status: 'up' | 'down' | 'stale'
+  since: string
+  last_check_at: string
+  uptime_24h: number | null
+  uptime_7d: number | null
+  uptime_30d: number | null
+  days: DayUptime[]
+}
+
+/**
+ * Incident is a period a service was down, ended_at is zero while ongoing.
+ */
+export interface Incident {
+  id: string
+  service: string
+  started_at: string
+  ended_at: string
+}
+
+/**
+ * StatusSummary is the payload of `/status/api/summary`.
+ */
+export interface StatusSummary {
+  status: 'up' | 'degraded'
+  services: ServiceSummary[] | null
+  incidents: Incident[] | null
+  updated_at: string
+}
+
+/**
+ * isZeroTime reports whether value is empty or Go's zero time.
+ */
+export function isZeroTime(value: string | undefined | null): boolean {
+  return !value || value.startsWith('0001-01-01')
+}
+
+/**
+ * formatUptime renders a ratio as a percentage, or a dash if there is no data.
+ */
+export function formatUptime(ratio: number | null | undefined): string {
+  if (ratio === null || ratio === undefined) {
+    return '—'
+  }
+  const percent = ratio * 100
+  if (percent >= 100) {
+    return '100%'
+  }
+  return `${(Math.floor(percent * 100) / 100).toFixed(2)}%`
+}
+
+/**
+ * uptimeColor returns the tailwind class of a daily uptime bar.
+ */
+export function uptimeColor(ratio: number | null | undefined): string {
+  if (ratio === null || ratio === undefined) {
+    return 'bg-muted'
+  }
+  if (ratio >= 0.999) {
+    return 'bg-emerald-500'
+  }
+  if (ratio >= 0.99) {
+    return 'bg-amber-400'
+  }
+  return 'bg-rose-500'
+}
+
+/**
+ * formatDuration renders the milliseconds between start and end (or now) like `1h 5m`.
+ */
+export function formatDuration(
+  start: string,
+  end: string,
+  now: Date = new Date(),
+): string {
+  const endAt = isZeroTime(end) ? now.getTime() : Date.parse(end)
+  const seconds = Math.max(
+    0,
+    Math.round((endAt - Date.parse(start)) / 1000),
+  )
+  if (seconds < 60) {
+    return `${seconds}s`
+  }
+
+  const days = Math.floor(seconds / 86400)
+  const hours = Math.floor((seconds % 86400) / 3600)
+  const minutes = Math.floor((seconds % 3600) / 60)
+  return [days && `${days}d`, hours && `${hours}h`, minutes && `${minutes}m`]
+    .filter(Boolean)
+    .join(' ')
+}
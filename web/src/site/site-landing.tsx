@@ -11,6 +11,9 @@ const GPTChatPage = lazy(() =>
 const CVPage = lazy(() =>
   import('@/pages/cv').then((m) => ({ default: m.CVPage })),
 )
+const StatusPage = lazy(() =>
+  import('@/pages/status').then((m) => ({ default: m.StatusPage })),
+)
 
 /**
  * SiteLanding renders the landing page for the active site id and returns a page element.
@@ -34,5 +37,13 @@ export function SiteLanding() {
     )
   }
 
+  if (siteId === 'status') {
+    return (
+      <Suspense fallback={null}>
+        <StatusPage />
+      </Suspense>
+    )
+  }
+
   return <HomePage />
 }